@@ -0,0 +1,258 @@
+package artifacts
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Store keeps original upload archives by key
+type Store interface {
+	// Name identifies the store in the artifact records
+	Name() string
+	Put(key string, r io.Reader, size int64) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// Wiper is implemented by stores that can overwrite an artifact before
+// deleting it, for secure deletes
+type Wiper interface {
+	Wipe(key string) error
+}
+
+// Default is the store new artifacts are kept in; nil disables retention
+var Default Store
+
+// ErrNotFound is returned for deployments without a retained artifact
+var ErrNotFound = errors.New("no artifact retained for deployment")
+
+// Artifact is the original archive a deployment was extracted from
+type Artifact struct {
+	DeploymentID string    `json:"deployment_id"`
+	Store        string    `json:"store"`
+	Key          string    `json:"key"`
+	Format       string    `json:"format"` // "zip", "tar" or "tar.gz"
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Retain copies the archive at src into the Default store and links it to
+// deploymentID. It does nothing when retention is disabled.
+func Retain(db *sql.DB, deploymentID, format, src string) error {
+	if Default == nil {
+		return nil
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	a := Artifact{
+		DeploymentID: deploymentID,
+		Store:        Default.Name(),
+		Key:          deploymentID + "." + format,
+		Format:       format,
+		Size:         info.Size(),
+		CreatedAt:    time.Now().UTC(),
+	}
+	hash := sha256.New()
+	if err := Default.Put(a.Key, io.TeeReader(f, hash), a.Size); err != nil {
+		return err
+	}
+	a.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	_, err = db.Exec(
+		"INSERT OR REPLACE INTO deployment_artifacts (deployment_id, store, key, format, size, sha256, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		a.DeploymentID, a.Store, a.Key, a.Format, a.Size, a.SHA256, a.CreatedAt,
+	)
+	if err != nil {
+		Default.Delete(a.Key)
+	}
+	return err
+}
+
+// Lookup returns the artifact retained for deploymentID
+func Lookup(db *sql.DB, deploymentID string) (*Artifact, error) {
+	var a Artifact
+	err := db.QueryRow(
+		"SELECT deployment_id, store, key, format, size, sha256, created_at FROM deployment_artifacts WHERE deployment_id = ?",
+		deploymentID,
+	).Scan(&a.DeploymentID, &a.Store, &a.Key, &a.Format, &a.Size, &a.SHA256, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Open reads a retained artifact back from its store
+func (a *Artifact) Open() (io.ReadCloser, error) {
+	if Default == nil || Default.Name() != a.Store {
+		return nil, fmt.Errorf("artifact store %q is not configured", a.Store)
+	}
+	return Default.Open(a.Key)
+}
+
+// Verify re-hashes a retained artifact, returning its current SHA-256 and
+// whether it still matches the hash recorded when it was stored
+func (a *Artifact) Verify() (string, bool, error) {
+	rc, err := a.Open()
+	if err != nil {
+		return "", false, err
+	}
+	defer rc.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, rc); err != nil {
+		return "", false, err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	return sum, sum == a.SHA256, nil
+}
+
+// Remove deletes the artifact retained for deploymentID, if any. An empty
+// ID removes every artifact, for bulk deletes.
+func Remove(db *sql.DB, deploymentID string) {
+	query := "SELECT key FROM deployment_artifacts"
+	var args []any
+	if deploymentID != "" {
+		query += " WHERE deployment_id = ?"
+		args = append(args, deploymentID)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Printf("Warning: Failed to look up artifacts: %v", err)
+		return
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if rows.Scan(&key) == nil {
+			keys = append(keys, key)
+		}
+	}
+	rows.Close()
+
+	for _, key := range keys {
+		if Default == nil {
+			break
+		}
+		if err := Default.Delete(key); err != nil {
+			log.Printf("Warning: Failed to delete artifact %s: %v", key, err)
+		}
+	}
+	if deploymentID == "" {
+		db.Exec("DELETE FROM deployment_artifacts")
+	} else {
+		db.Exec("DELETE FROM deployment_artifacts WHERE deployment_id = ?", deploymentID)
+	}
+}
+
+// Wipe deletes the artifact retained for deploymentID like Remove, first
+// overwriting it when the store is a Wiper. It reports "overwritten",
+// "deleted" when the store can only delete, or "" when there was none.
+func Wipe(db *sql.DB, deploymentID string) (string, error) {
+	a, err := Lookup(db, deploymentID)
+	if err == ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if Default == nil || Default.Name() != a.Store {
+		return "", fmt.Errorf("artifact store %q is not configured", a.Store)
+	}
+	result := "deleted"
+	if wiper, ok := Default.(Wiper); ok {
+		if err := wiper.Wipe(a.Key); err != nil {
+			return "", err
+		}
+		result = "overwritten"
+	}
+	if err := Default.Delete(a.Key); err != nil {
+		return "", err
+	}
+	_, err = db.Exec("DELETE FROM deployment_artifacts WHERE deployment_id = ?", deploymentID)
+	return result, err
+}
+
+// Local keeps artifacts as files in a directory
+type Local struct {
+	Dir string
+}
+
+func (l Local) Name() string { return "local" }
+
+func (l Local) Put(key string, r io.Reader, size int64) error {
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
+	// Write under a temporary name so a half-written artifact is never read
+	tmp := filepath.Join(l.Dir, "."+key+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(l.Dir, key))
+}
+
+func (l Local) Open(key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(l.Dir, key))
+}
+
+func (l Local) Delete(key string) error {
+	err := os.Remove(filepath.Join(l.Dir, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Wipe overwrites the artifact with zeros and syncs it to disk
+func (l Local) Wipe(key string) error {
+	f, err := os.OpenFile(filepath.Join(l.Dir, key), os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zeros := make([]byte, 32<<10)
+	for left := info.Size(); left > 0; left -= int64(len(zeros)) {
+		if left < int64(len(zeros)) {
+			zeros = zeros[:left]
+		}
+		if _, err := f.Write(zeros); err != nil {
+			return err
+		}
+	}
+	return f.Sync()
+}
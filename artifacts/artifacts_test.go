@@ -0,0 +1,201 @@
+package artifacts
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE deployment_artifacts (
+		deployment_id TEXT PRIMARY KEY, store TEXT NOT NULL, key TEXT NOT NULL, format TEXT NOT NULL,
+		size INTEGER NOT NULL, sha256 TEXT NOT NULL, created_at DATETIME NOT NULL)`)
+	if err != nil {
+		t.Fatalf("Failed to create deployment_artifacts table: %v", err)
+	}
+	return db
+}
+
+func TestRetainVerifyRemove(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	dir := t.TempDir()
+	saved := Default
+	defer func() { Default = saved }()
+	Default = Local{Dir: filepath.Join(dir, "store")}
+
+	src := filepath.Join(dir, "site.zip")
+	os.WriteFile(src, []byte("archive bytes"), 0644)
+	if err := Retain(db, "d1", "zip", src); err != nil {
+		t.Fatalf("Retain failed: %v", err)
+	}
+
+	a, err := Lookup(db, "d1")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if a.Key != "d1.zip" || a.Size != 13 || a.Store != "local" {
+		t.Errorf("unexpected artifact %+v", a)
+	}
+	if _, ok, err := a.Verify(); !ok || err != nil {
+		t.Errorf("expected the artifact to verify, got %v (%v)", ok, err)
+	}
+
+	// Tampering with the stored copy is caught
+	os.WriteFile(filepath.Join(dir, "store", "d1.zip"), []byte("changed"), 0644)
+	if _, ok, _ := a.Verify(); ok {
+		t.Error("expected a modified artifact to fail verification")
+	}
+
+	Remove(db, "d1")
+	if _, err := Lookup(db, "d1"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound after Remove, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "store", "d1.zip")); !os.IsNotExist(err) {
+		t.Error("expected the stored archive to be deleted")
+	}
+}
+
+func TestWipe(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	dir := t.TempDir()
+	saved := Default
+	defer func() { Default = saved }()
+	Default = Local{Dir: filepath.Join(dir, "store")}
+
+	src := filepath.Join(dir, "site.zip")
+	os.WriteFile(src, []byte("archive bytes"), 0644)
+	Retain(db, "d1", "zip", src)
+	f, err := os.Open(filepath.Join(dir, "store", "d1.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if result, err := Wipe(db, "d1"); result != "overwritten" || err != nil {
+		t.Fatalf("expected the artifact to be overwritten, got %q (%v)", result, err)
+	}
+	if data, _ := io.ReadAll(f); string(data) != strings.Repeat("\x00", 13) {
+		t.Errorf("expected the stored archive to be zeroed, got %q", data)
+	}
+	if _, err := Lookup(db, "d1"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound after Wipe, got %v", err)
+	}
+	if result, err := Wipe(db, "d1"); result != "" || err != nil {
+		t.Errorf("expected nothing to wipe, got %q (%v)", result, err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	saved := Default
+	defer func() { Default = saved }()
+	store := NewMemory()
+	Default = store
+
+	src := filepath.Join(t.TempDir(), "site.tar.gz")
+	os.WriteFile(src, []byte("archive bytes"), 0644)
+	if err := Retain(db, "d1", "tar.gz", src); err != nil {
+		t.Fatalf("Retain failed: %v", err)
+	}
+	a, err := Lookup(db, "d1")
+	if err != nil || a.Store != "memory" {
+		t.Fatalf("expected an artifact in the memory store, got %+v, %v", a, err)
+	}
+	if _, ok, err := a.Verify(); !ok || err != nil {
+		t.Errorf("expected the artifact to verify, got %v (%v)", ok, err)
+	}
+
+	Remove(db, "d1")
+	if _, err := store.Open("d1.tar.gz"); !os.IsNotExist(err) {
+		t.Errorf("expected the artifact to be deleted, got %v", err)
+	}
+}
+
+func TestRetainDisabled(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	saved := Default
+	defer func() { Default = saved }()
+	Default = nil
+
+	if err := Retain(db, "d1", "zip", "does-not-exist.zip"); err != nil {
+		t.Errorf("expected retention to be skipped, got %v", err)
+	}
+	if _, err := Lookup(db, "d1"); err != ErrNotFound {
+		t.Errorf("expected nothing recorded, got %v", err)
+	}
+}
+
+func TestS3SignedRequests(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(auth, "/eu-west-1/s3/aws4_request") || r.Header.Get("X-Amz-Date") == "" {
+			http.Error(w, "bad signature", http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = string(body)
+		case http.MethodGet:
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				http.Error(w, "NoSuchKey", http.StatusNotFound)
+				return
+			}
+			io.WriteString(w, body)
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	s := S3{Endpoint: server.URL, Region: "eu-west-1", Bucket: "builds", Prefix: "artifacts/", AccessKey: "AKID", SecretKey: "secret"}
+	if err := s.Put("d1.zip", strings.NewReader("archive"), 7); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, ok := objects["/builds/artifacts/d1.zip"]; !ok {
+		t.Fatalf("expected a path-style object key, got %v", objects)
+	}
+	rc, err := s.Open("d1.zip")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	body, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(body) != "archive" {
+		t.Errorf("expected the stored archive, got %q", body)
+	}
+	if err := s.Delete("d1.zip"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := s.Open("d1.zip"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a 404 error for a deleted object, got %v", err)
+	}
+}
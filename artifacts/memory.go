@@ -0,0 +1,59 @@
+package artifacts
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// Memory keeps artifacts in memory, for tests and ephemeral servers; they
+// are gone when the process exits
+type Memory struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+// NewMemory returns an empty Memory store
+func NewMemory() *Memory {
+	return &Memory{blobs: map[string][]byte{}}
+}
+
+func (m *Memory) Name() string { return "memory" }
+
+func (m *Memory) Put(key string, r io.Reader, size int64) error {
+	// Read it all first so a failed upload never replaces the artifact
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[key] = data
+	return nil
+}
+
+func (m *Memory) Open(key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.blobs[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: key, Err: fs.ErrNotExist}
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blobs, key)
+	return nil
+}
+
+// Wipe zeroes the artifact's bytes so no copy outlives the delete
+func (m *Memory) Wipe(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.blobs[key])
+	return nil
+}
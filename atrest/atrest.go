@@ -0,0 +1,349 @@
+// Package atrest encrypts deployment files on disk, for servers whose
+// volumes are shared or unencrypted, and decrypts them transparently for
+// whatever serves them.
+//
+// Files are sealed with AES-256-GCM in chunks of chunkSize, so a range
+// request only decrypts the chunks it reads. Each file starts with a header
+// holding the magic, the ID of the key and a random nonce; the nonce of a
+// chunk is the file's nonce XORed with its index, and the last chunk is
+// marked as such, so chunks can't be reordered, swapped between files or
+// cut off unnoticed.
+//
+// Whether a tree is encrypted is recorded in a sidecar file beside it rather
+// than told from its files, whose plaintext may well start with the magic.
+// A tree is encrypted whole or not at all.
+package atrest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	magic      = "ssh-enc1"
+	keyIDSize  = 4
+	nonceSize  = 12
+	headerSize = len(magic) + keyIDSize + nonceSize
+	chunkSize  = 64 << 10
+	tagSize    = 16
+
+	// markerSuffix names the sidecar file beside an encrypted tree
+	markerSuffix = ".atrest"
+)
+
+// ErrNoKey is returned when opening a file encrypted with a key that is not
+// configured
+var ErrNoKey = errors.New("file is encrypted with a key that is not configured")
+
+// Cipher encrypts files with one key
+type Cipher struct {
+	id   [keyIDSize]byte
+	aead cipher.AEAD
+}
+
+// Default encrypts new deployments; nil stores them in plaintext
+var Default *Cipher
+
+// NewCipher returns a Cipher for a 32-byte key
+func NewCipher(key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cipher{aead: aead}
+	sum := sha256.Sum256(key)
+	copy(c.id[:], sum[:])
+	return c, nil
+}
+
+// EncryptTree encrypts every regular file under dir in place and marks dir
+// as encrypted. A tree already marked is left as it is. A nil Cipher leaves
+// the files in plaintext, dropping any mark left by an earlier tree at dir.
+func (c *Cipher) EncryptTree(dir string) error {
+	if c == nil {
+		return Unmark(dir)
+	}
+	if Encrypted(dir) {
+		return nil
+	}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		return c.encryptFile(p)
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(marker(dir), []byte(magic+"\n"), 0444)
+}
+
+// Encrypted reports whether the tree at root was encrypted by EncryptTree
+func Encrypted(root string) bool {
+	_, err := os.Stat(marker(root))
+	return err == nil
+}
+
+// Unmark drops the mark of an encrypted tree at root, once it is removed
+func Unmark(root string) error {
+	if err := os.Remove(marker(root)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func marker(root string) string {
+	return filepath.Clean(root) + markerSuffix
+}
+
+func (c *Cipher) encryptFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Written beside the file so the rename replacing it is atomic
+	tmp := filepath.Join(filepath.Dir(name), "."+filepath.Base(name)+".enc")
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm()|0200)
+	if err != nil {
+		return err
+	}
+	err = c.encrypt(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (c *Cipher) encrypt(w io.Writer, r io.Reader) error {
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	copy(header[len(magic):], c.id[:])
+	nonce := header[len(magic)+keyIDSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	// Reading a chunk ahead tells whether the current one is the last
+	buf := make([]byte, chunkSize)
+	next := make([]byte, chunkSize)
+	n, err := io.ReadFull(r, buf)
+	for index := uint64(0); ; index++ {
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return err
+		}
+		last := err != nil
+		var m int
+		if !last {
+			m, err = io.ReadFull(r, next)
+			last = m == 0 && err == io.EOF
+		}
+		sealed := c.aead.Seal(nil, chunkNonce(nonce, index), buf[:n], chunkAD(last))
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		buf, next, n = next, buf, m
+	}
+}
+
+func chunkNonce(base []byte, index uint64) []byte {
+	nonce := bytes.Clone(base)
+	var ctr [8]byte
+	binary.BigEndian.PutUint64(ctr[:], index)
+	for i := range ctr {
+		nonce[nonceSize-8+i] ^= ctr[i]
+	}
+	return nonce
+}
+
+func chunkAD(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// File is an open deployment file, read as plaintext whether or not it is
+// encrypted
+type File interface {
+	io.ReadSeekCloser
+	// Size is the size of the plaintext
+	Size() int64
+}
+
+// Open opens the file at name, within the tree at root, for reading,
+// decrypting it with Default if the tree is encrypted
+func Open(root, name string) (File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := open(f, Encrypted(root))
+	if err != nil {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return file, nil
+}
+
+func open(f *os.File, encrypted bool) (File, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !encrypted {
+		return plainFile{f, info.Size()}, nil
+	}
+	header := make([]byte, headerSize)
+	if n, err := f.ReadAt(header, 0); n < headerSize || string(header[:len(magic)]) != magic {
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("encrypted file has no valid header")
+	}
+
+	c := Default
+	if c == nil || !bytes.Equal(c.id[:], header[len(magic):len(magic)+keyIDSize]) {
+		return nil, ErrNoKey
+	}
+	body := info.Size() - int64(headerSize)
+	chunks, rest := body/(chunkSize+tagSize), body%(chunkSize+tagSize)
+	if rest > 0 && rest < tagSize || rest == 0 && chunks == 0 {
+		return nil, fmt.Errorf("encrypted file is truncated")
+	}
+	size := chunks * chunkSize
+	if rest > 0 {
+		size += rest - tagSize
+	}
+	return &encryptedFile{f: f, c: c, nonce: header[len(magic)+keyIDSize:], size: size, chunk: -1}, nil
+}
+
+// ReadFile reads the whole file at name, within the tree at root,
+// decrypting it if the tree is encrypted
+func ReadFile(root, name string) ([]byte, error) {
+	f, err := Open(root, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Size returns the plaintext size of the file at name, within the tree at
+// root
+func Size(root, name string) (int64, error) {
+	f, err := Open(root, name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.Size(), nil
+}
+
+// PlainSize returns the plaintext size of the file at name, within the tree
+// at root and described by info, only opening it when the tree is encrypted
+func PlainSize(root, name string, info fs.FileInfo) (int64, error) {
+	if !Encrypted(root) {
+		return info.Size(), nil
+	}
+	return Size(root, name)
+}
+
+type plainFile struct {
+	*os.File
+	size int64
+}
+
+func (p plainFile) Size() int64 { return p.size }
+
+type encryptedFile struct {
+	f     *os.File
+	c     *Cipher
+	nonce []byte
+	size  int64
+	pos   int64
+
+	// The chunk last decrypted, so sequential reads decrypt each once
+	chunk int64
+	plain []byte
+}
+
+func (e *encryptedFile) Size() int64 { return e.size }
+
+func (e *encryptedFile) Read(p []byte) (int, error) {
+	if e.pos >= e.size {
+		return 0, io.EOF
+	}
+	index := e.pos / chunkSize
+	if index != e.chunk {
+		if err := e.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, e.plain[e.pos-index*chunkSize:])
+	e.pos += int64(n)
+	return n, nil
+}
+
+func (e *encryptedFile) load(index int64) error {
+	last := (index+1)*chunkSize >= e.size
+	length := int64(chunkSize)
+	if last {
+		length = e.size - index*chunkSize
+	}
+	sealed := make([]byte, length+tagSize)
+	if _, err := e.f.ReadAt(sealed, int64(headerSize)+index*(chunkSize+tagSize)); err != nil {
+		return err
+	}
+	plain, err := e.c.aead.Open(e.plain[:0], chunkNonce(e.nonce, uint64(index)), sealed, chunkAD(last))
+	if err != nil {
+		return fmt.Errorf("decrypting %s: %w", e.f.Name(), err)
+	}
+	e.chunk, e.plain = index, plain
+	return nil
+}
+
+func (e *encryptedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += e.pos
+	case io.SeekEnd:
+		offset += e.size
+	}
+	if offset < 0 {
+		return 0, errors.New("atrest: negative position")
+	}
+	e.pos = offset
+	return offset, nil
+}
+
+func (e *encryptedFile) Close() error { return e.f.Close() }
@@ -0,0 +1,171 @@
+package atrest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testCipher(t *testing.T, b byte) *Cipher {
+	t.Helper()
+	c, err := NewCipher(bytes.Repeat([]byte{b}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func useDefault(t *testing.T, c *Cipher) {
+	previous := Default
+	Default = c
+	t.Cleanup(func() { Default = previous })
+}
+
+func TestEncryptTreeRoundTrip(t *testing.T) {
+	c := testCipher(t, 1)
+	useDefault(t, c)
+	dir := t.TempDir()
+
+	contents := map[string][]byte{}
+	for name, size := range map[string]int{"empty": 0, "small": 13, "chunk": chunkSize, "chunks": 2*chunkSize + 5} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i * 7)
+		}
+		contents[name] = data
+		os.WriteFile(filepath.Join(dir, name), data, 0644)
+	}
+	if err := c.EncryptTree(dir); err != nil {
+		t.Fatalf("EncryptTree failed: %v", err)
+	}
+
+	for name, want := range contents {
+		p := filepath.Join(dir, name)
+		raw, _ := os.ReadFile(p)
+		if len(want) > 0 && bytes.Contains(raw, want) {
+			t.Errorf("%s: expected the file on disk to be encrypted", name)
+		}
+		got, err := ReadFile(dir, p)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s: expected the plaintext back, got %d bytes, %v", name, len(got), err)
+		}
+		if size, err := Size(dir, p); err != nil || size != int64(len(want)) {
+			t.Errorf("%s: expected size %d, got %d, %v", name, len(want), size, err)
+		}
+	}
+
+	// Encrypting again leaves encrypted files as they are
+	before, _ := os.ReadFile(filepath.Join(dir, "small"))
+	c.EncryptTree(dir)
+	if after, _ := os.ReadFile(filepath.Join(dir, "small")); !bytes.Equal(before, after) {
+		t.Error("expected an encrypted file not to be encrypted twice")
+	}
+}
+
+func TestOpenSeek(t *testing.T) {
+	c := testCipher(t, 1)
+	useDefault(t, c)
+	dir := t.TempDir()
+	data := make([]byte, 3*chunkSize)
+	for i := range data {
+		data[i] = byte(i / 3)
+	}
+	os.WriteFile(filepath.Join(dir, "f"), data, 0644)
+	c.EncryptTree(dir)
+
+	f, err := Open(dir, filepath.Join(dir, "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, offset := range []int64{chunkSize - 10, 5, 2*chunkSize + 1} {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 100)
+		n, err := io.ReadFull(f, buf)
+		if err != nil || !bytes.Equal(buf[:n], data[offset:offset+100]) {
+			t.Errorf("reading 100 bytes at %d: got %d bytes, %v", offset, n, err)
+		}
+	}
+	if end, _ := f.Seek(0, io.SeekEnd); end != int64(len(data)) {
+		t.Errorf("expected the end at %d, got %d", len(data), end)
+	}
+}
+
+func TestOpenWithoutKey(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "f")
+	os.WriteFile(p, []byte("plaintext"), 0644)
+
+	// Files stored before a key was configured are still served
+	useDefault(t, testCipher(t, 1))
+	if got, err := ReadFile(dir, p); err != nil || string(got) != "plaintext" {
+		t.Errorf("expected a plaintext file to be read as is, got %q, %v", got, err)
+	}
+
+	Default.EncryptTree(dir)
+	Default = testCipher(t, 2)
+	if _, err := Open(dir, p); !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected ErrNoKey with another key, got %v", err)
+	}
+	Default = nil
+	if _, err := Open(dir, p); !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected ErrNoKey without a key, got %v", err)
+	}
+}
+
+func TestOpenTampered(t *testing.T) {
+	c := testCipher(t, 1)
+	useDefault(t, c)
+	dir := t.TempDir()
+	p := filepath.Join(dir, "f")
+	os.WriteFile(p, bytes.Repeat([]byte("x"), chunkSize+100), 0644)
+	c.EncryptTree(dir)
+	raw, _ := os.ReadFile(p)
+
+	// Cutting the file at a chunk boundary drops its last chunk
+	os.WriteFile(p, raw[:headerSize+chunkSize+tagSize], 0644)
+	if _, err := ReadFile(dir, p); err == nil {
+		t.Error("expected a truncated file to fail to decrypt")
+	}
+
+	flipped := bytes.Clone(raw)
+	flipped[headerSize+10] ^= 1
+	os.WriteFile(p, flipped, 0644)
+	if _, err := ReadFile(dir, p); err == nil {
+		t.Error("expected a modified file to fail to decrypt")
+	}
+}
+
+func TestPlaintextStartingWithMagic(t *testing.T) {
+	useDefault(t, testCipher(t, 1))
+	dir := t.TempDir()
+	p := filepath.Join(dir, "f")
+	data := []byte(magic + "\x00\x00\x00\x00 not a header, just content that looks like one")
+	os.WriteFile(p, data, 0644)
+
+	// Only the mark beside the tree tells it is encrypted
+	if got, err := ReadFile(dir, p); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("expected a plaintext file starting with the magic to be read as is, got %q, %v", got, err)
+	}
+	if err := Default.EncryptTree(dir); err != nil {
+		t.Fatalf("EncryptTree failed: %v", err)
+	}
+	if !Encrypted(dir) {
+		t.Fatal("expected the tree to be marked as encrypted")
+	}
+	if got, err := ReadFile(dir, p); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("expected the file to be encrypted and read back, got %q, %v", got, err)
+	}
+	if raw, _ := os.ReadFile(p); bytes.Equal(raw, data) {
+		t.Error("expected the file to be encrypted on disk")
+	}
+
+	if err := Unmark(dir); err != nil || Encrypted(dir) {
+		t.Errorf("expected the mark to be dropped, got %v", err)
+	}
+}
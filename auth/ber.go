@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Minimal BER encoding for the subset of LDAPv3 used by the LDAP backend
+
+// berPacket is a decoded TLV element
+type berPacket struct {
+	tag      byte
+	value    []byte      // raw content for primitive elements
+	children []berPacket // parsed content for constructed elements
+}
+
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berBoolean     = 0x01
+	berSequence    = 0x30
+	berSet         = 0x31
+)
+
+func berEncode(tag byte, content []byte) []byte {
+	out := []byte{tag}
+	out = append(out, berLength(len(content))...)
+	return append(out, content...)
+}
+
+func berLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for v := n; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func berConstructed(tag byte, children ...[]byte) []byte {
+	var content []byte
+	for _, c := range children {
+		content = append(content, c...)
+	}
+	return berEncode(tag, content)
+}
+
+func berInt(tag byte, v int) []byte {
+	// Two's complement, minimal length
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		if (v >= -128 && v < 128) || len(b) >= 4 {
+			break
+		}
+		v >>= 8
+	}
+	return berEncode(tag, b)
+}
+
+func berString(tag byte, s string) []byte {
+	return berEncode(tag, []byte(s))
+}
+
+func berBool(v bool) []byte {
+	if v {
+		return berEncode(berBoolean, []byte{0xff})
+	}
+	return berEncode(berBoolean, []byte{0x00})
+}
+
+// berRead reads one complete element from r
+func berRead(r *bufio.Reader) (berPacket, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return berPacket{}, err
+	}
+	n, err := berReadLength(r)
+	if err != nil {
+		return berPacket{}, err
+	}
+	if n > 16<<20 {
+		return berPacket{}, errors.New("ldap: message too large")
+	}
+	content := make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return berPacket{}, err
+	}
+	return berParse(tag, content)
+}
+
+func berReadLength(r *bufio.Reader) (int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if first < 0x80 {
+		return int(first), nil
+	}
+	count := int(first & 0x7f)
+	if count == 0 || count > 4 {
+		return 0, errors.New("ldap: unsupported length encoding")
+	}
+	n := 0
+	for i := 0; i < count; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n = n<<8 | int(b)
+	}
+	return n, nil
+}
+
+func berParse(tag byte, content []byte) (berPacket, error) {
+	p := berPacket{tag: tag, value: content}
+	if tag&0x20 == 0 {
+		return p, nil
+	}
+	for len(content) > 0 {
+		childTag := content[0]
+		rest := content[1:]
+		if len(rest) == 0 {
+			return p, errors.New("ldap: truncated element")
+		}
+		var n, used int
+		if rest[0] < 0x80 {
+			n, used = int(rest[0]), 1
+		} else {
+			count := int(rest[0] & 0x7f)
+			if count == 0 || count > 4 || len(rest) < 1+count {
+				return p, errors.New("ldap: bad length")
+			}
+			for i := 1; i <= count; i++ {
+				n = n<<8 | int(rest[i])
+			}
+			used = 1 + count
+		}
+		if len(rest) < used+n {
+			return p, errors.New("ldap: truncated element")
+		}
+		child, err := berParse(childTag, rest[used:used+n])
+		if err != nil {
+			return p, err
+		}
+		p.children = append(p.children, child)
+		content = rest[used+n:]
+	}
+	return p, nil
+}
+
+func (p berPacket) int() int {
+	v := 0
+	for i, b := range p.value {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int(b)
+	}
+	return v
+}
+
+func (p berPacket) str() string {
+	return string(p.value)
+}
+
+// berFilter compiles an RFC 4515 filter string. Supported: &, |, !, equality
+// and presence (attr=*). Values may use \XX escapes.
+func berFilter(filter string) ([]byte, error) {
+	out, rest, err := parseFilter(strings.TrimSpace(filter))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: trailing data in filter %q", filter)
+	}
+	return out, nil
+}
+
+func parseFilter(f string) ([]byte, string, error) {
+	if !strings.HasPrefix(f, "(") {
+		return nil, "", fmt.Errorf("ldap: filter must start with '(': %q", f)
+	}
+	f = f[1:]
+	if f == "" {
+		return nil, "", errors.New("ldap: empty filter")
+	}
+
+	switch f[0] {
+	case '&', '|':
+		tag := byte(0xa0)
+		if f[0] == '|' {
+			tag = 0xa1
+		}
+		f = f[1:]
+		var parts [][]byte
+		for strings.HasPrefix(f, "(") {
+			part, rest, err := parseFilter(f)
+			if err != nil {
+				return nil, "", err
+			}
+			parts = append(parts, part)
+			f = rest
+		}
+		if !strings.HasPrefix(f, ")") {
+			return nil, "", errors.New("ldap: unterminated filter")
+		}
+		return berConstructed(tag, parts...), f[1:], nil
+
+	case '!':
+		part, rest, err := parseFilter(f[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", errors.New("ldap: unterminated filter")
+		}
+		return berConstructed(0xa2, part), rest[1:], nil
+	}
+
+	end := strings.IndexByte(f, ')')
+	if end < 0 {
+		return nil, "", errors.New("ldap: unterminated filter")
+	}
+	item, rest := f[:end], f[end+1:]
+	attr, value, ok := strings.Cut(item, "=")
+	if !ok || attr == "" {
+		return nil, "", fmt.Errorf("ldap: unsupported filter item %q", item)
+	}
+	if value == "*" {
+		return berString(0x87, attr), rest, nil
+	}
+	decoded, err := unescapeFilterValue(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return berConstructed(0xa3, berString(berOctetString, attr), berString(berOctetString, decoded)), rest, nil
+}
+
+func unescapeFilterValue(v string) (string, error) {
+	if strings.Contains(v, "*") {
+		return "", errors.New("ldap: substring filters are not supported")
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' {
+			b.WriteByte(v[i])
+			continue
+		}
+		if i+3 > len(v) {
+			return "", errors.New("ldap: bad escape in filter")
+		}
+		n, err := strconv.ParseUint(v[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", errors.New("ldap: bad escape in filter")
+		}
+		b.WriteByte(byte(n))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// EscapeFilterValue escapes user input for inclusion in an LDAP filter
+func EscapeFilterValue(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
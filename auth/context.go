@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// SessionCookie is the cookie carrying browser session tokens
+const SessionCookie = "session"
+
+// CSRFHeader carries the CSRF token of the session whose cookie a request
+// presents. Browsers send the cookie with requests other pages make, and
+// sites are served from the API's own origin, so unsafe requests relying on
+// the cookie alone aren't trusted.
+const CSRFHeader = "X-CSRF-Token"
+
+type contextKey struct{}
+
+// WithClaims returns a copy of ctx carrying the caller's claims
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns the caller's claims, or nil for anonymous requests
+func FromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(contextKey{}).(*Claims)
+	return claims
+}
+
+// CSRFToken returns the CSRF token of a session token. It can't be derived
+// without the token, which scripts can't read from its HttpOnly cookie, and
+// is only handed out with the session.
+func CSRFToken(sessionToken string) string {
+	sum := sha256.Sum256([]byte("csrf:" + sessionToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// CookieWithoutCSRF reports whether r presents its token only in the
+// session cookie, with an unsafe method and without that session's CSRF token
+func CookieWithoutCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return false
+	}
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return false
+	}
+	c, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(CSRFHeader)), []byte(CSRFToken(c.Value))) != 1
+}
+
+// RequestToken returns the token r presents as a Bearer header, or else in
+// the session cookie, or "" if it presents none
+func RequestToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	if c, err := r.Cookie(SessionCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
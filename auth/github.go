@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotOrgMember is returned for GitHub users outside the allowed
+// organizations
+var ErrNotOrgMember = errors.New("not a member of an allowed GitHub organization")
+
+// GitHubConfig describes an OAuth app registered on GitHub
+type GitHubConfig struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	BaseURL      string   // https://github.com, or a GitHub Enterprise Server URL
+	APIURL       string   // https://api.github.com, or {BaseURL}/api/v3
+	Orgs         []string // when set, only members of these organizations may log in
+}
+
+// GitHubProvider runs the OAuth web application flow against GitHub
+type GitHubProvider struct {
+	cfg    GitHubConfig
+	client *http.Client
+}
+
+// GitHubIdentity is what we learn about the user from the GitHub API
+type GitHubIdentity struct {
+	ID    string // numeric user ID; logins can be renamed
+	Login string
+	Name  string
+	Email string // primary verified address, if the user has one
+}
+
+// NewGitHubProvider returns a provider for cfg, on github.com unless
+// BaseURL names a GitHub Enterprise Server
+func NewGitHubProvider(cfg GitHubConfig) *GitHubProvider {
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	switch {
+	case cfg.APIURL != "":
+	case cfg.BaseURL == "" || cfg.BaseURL == "https://github.com":
+		cfg.APIURL = "https://api.github.com"
+	default:
+		cfg.APIURL = cfg.BaseURL + "/api/v3"
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "https://github.com"
+	}
+	cfg.APIURL = strings.TrimSuffix(cfg.APIURL, "/")
+	return &GitHubProvider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// AuthCodeURL returns the GitHub URL the browser is redirected to
+func (p *GitHubProvider) AuthCodeURL(state string) string {
+	scopes := "read:user user:email"
+	if len(p.cfg.Orgs) > 0 {
+		scopes += " read:org"
+	}
+	q := url.Values{}
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", scopes)
+	q.Set("state", state)
+	q.Set("allow_signup", "false")
+	return p.cfg.BaseURL + "/login/oauth/authorize?" + q.Encode()
+}
+
+// Exchange trades an authorization code for an access token and looks the
+// user up with it, checking organization membership when required
+func (p *GitHubProvider) Exchange(code string) (*GitHubIdentity, error) {
+	form := url.Values{}
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("client_secret", p.cfg.ClientSecret)
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+
+	req, err := http.NewRequest(http.MethodPost, p.cfg.BaseURL+"/login/oauth/access_token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+
+	// GitHub reports a rejected code with 200 and an error field
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	if tokens.Error != "" {
+		return nil, fmt.Errorf("token endpoint returned %s", tokens.Error)
+	}
+	if tokens.AccessToken == "" {
+		return nil, errors.New("token response has no access_token")
+	}
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := p.get(tokens.AccessToken, "/user", &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, errors.New("user response has no id")
+	}
+	identity := &GitHubIdentity{ID: strconv.FormatInt(user.ID, 10), Login: user.Login, Name: user.Name}
+
+	// The profile's public email may be unset or unverified
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.get(tokens.AccessToken, "/user/emails", &emails); err != nil {
+		return nil, err
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			identity.Email = e.Email
+		}
+	}
+
+	if len(p.cfg.Orgs) > 0 {
+		var orgs []struct {
+			Login string `json:"login"`
+		}
+		if err := p.get(tokens.AccessToken, "/user/orgs", &orgs); err != nil {
+			return nil, err
+		}
+		member := false
+		for _, org := range orgs {
+			for _, allowed := range p.cfg.Orgs {
+				member = member || strings.EqualFold(org.Login, allowed)
+			}
+		}
+		if !member {
+			return nil, ErrNotOrgMember
+		}
+	}
+	return identity, nil
+}
+
+// get decodes the API response for path, authenticated with token
+func (p *GitHubProvider) get(token, path string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, p.cfg.APIURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GitHub API %s returned %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
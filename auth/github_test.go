@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// fakeGitHub answers the OAuth and API endpoints for the code "good",
+// whose user belongs to the acme organization
+func fakeGitHub(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("client_secret") != "shh" {
+			t.Errorf("expected the client secret, got %q", r.Form.Get("client_secret"))
+		}
+		if r.Form.Get("code") != "good" {
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "gho_token", "token_type": "bearer"})
+	})
+	api := func(path string, body any) {
+		mux.HandleFunc("/api/v3"+path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer gho_token" {
+				http.Error(w, "Bad credentials", http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(body)
+		})
+	}
+	api("/user", map[string]any{"id": 583231, "login": "octocat", "name": "The Octocat", "email": "public@example.com"})
+	api("/user/emails", []map[string]any{
+		{"email": "old@example.com", "primary": false, "verified": true},
+		{"email": "octocat@example.com", "primary": true, "verified": true},
+	})
+	api("/user/orgs", []map[string]any{{"login": "Acme"}})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGitHubAuthCodeURL(t *testing.T) {
+	p := NewGitHubProvider(GitHubConfig{ClientID: "app", RedirectURL: "https://hosting.example.com/auth/github/callback", Orgs: []string{"acme"}})
+	u, err := url.Parse(p.AuthCodeURL("xyz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Host != "github.com" || q.Get("client_id") != "app" || q.Get("state") != "xyz" || !strings.Contains(q.Get("scope"), "read:org") {
+		t.Errorf("unexpected authorize URL %s", u)
+	}
+	if p.cfg.APIURL != "https://api.github.com" {
+		t.Errorf("expected the github.com API, got %s", p.cfg.APIURL)
+	}
+	if ghe := NewGitHubProvider(GitHubConfig{BaseURL: "https://git.example.com/"}); ghe.cfg.APIURL != "https://git.example.com/api/v3" {
+		t.Errorf("expected the Enterprise Server API, got %s", ghe.cfg.APIURL)
+	}
+}
+
+func TestGitHubExchange(t *testing.T) {
+	server := fakeGitHub(t)
+	p := NewGitHubProvider(GitHubConfig{ClientID: "app", ClientSecret: "shh", BaseURL: server.URL, Orgs: []string{"acme"}})
+
+	identity, err := p.Exchange("good")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	expected := GitHubIdentity{ID: "583231", Login: "octocat", Name: "The Octocat", Email: "octocat@example.com"}
+	if *identity != expected {
+		t.Errorf("expected %+v, got %+v", expected, *identity)
+	}
+
+	if _, err := p.Exchange("bad"); err == nil || !strings.Contains(err.Error(), "bad_verification_code") {
+		t.Errorf("expected the rejected code to be reported, got %v", err)
+	}
+
+	p = NewGitHubProvider(GitHubConfig{ClientID: "app", ClientSecret: "shh", BaseURL: server.URL, Orgs: []string{"other"}})
+	if _, err := p.Exchange("good"); err != ErrNotOrgMember {
+		t.Errorf("expected ErrNotOrgMember, got %v", err)
+	}
+}
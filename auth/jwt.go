@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token expired")
+	ErrWrongPurpose = errors.New("token issued for another purpose")
+)
+
+// PurposeLoginState marks the tokens holding the state of an OAuth or OIDC
+// login while the provider is visited. Credentials carry no purpose.
+const PurposeLoginState = "login_state"
+
+// Claims identify the caller of an authenticated request
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Email     string   `json:"email,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	Role      string   `json:"role"`
+	Groups    []string `json:"groups,omitempty"`
+	Provider  string   `json:"provider,omitempty"` // "oidc", "ldap", ...
+	Tenant    string   `json:"tenant,omitempty"`   // billing tenant; empty means the default tenant
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+
+	// API tokens carry their ID, so they can be revoked, and the scopes
+	// limiting what they may do. Deploy tokens exchanged for one share its
+	// ID, may only deploy Site and carry the TokenHash of the API token, so
+	// they end when it is rotated.
+	TokenID    string   `json:"jti,omitempty"`
+	Scopes     []string `json:"scopes,omitempty"`
+	Site       string   `json:"site,omitempty"`
+	ParentHash string   `json:"parent_hash,omitempty"`
+
+	// Session tokens name the server-side session they belong to, and are
+	// refused once it has ended
+	SessionID string `json:"sid,omitempty"`
+
+	// Tokens that are not credentials name what they were issued for, so
+	// Verify refuses them; VerifyPurpose accepts only that purpose
+	Purpose string `json:"purpose,omitempty"`
+
+	// Purpose-specific fields for short-lived tokens (e.g. OIDC login state)
+	State string `json:"state,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// Signer issues and verifies HS256 JWTs with a shared secret
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner returns a signer for secret
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Issue signs claims valid for ttl from now
+func (s *Signer) Issue(claims Claims, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(ttl).Unix()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + s.sign(signingInput), nil
+}
+
+// Verify checks the signature and expiry of a credential and returns its
+// claims. Tokens issued for another purpose are refused, whoever holds them.
+func (s *Signer) Verify(token string) (*Claims, error) {
+	claims, err := s.parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" || claims.State != "" {
+		return nil, ErrWrongPurpose
+	}
+	return claims, nil
+}
+
+// VerifyPurpose checks the signature and expiry of a token issued for
+// purpose and returns its claims
+func (s *Signer) VerifyPurpose(token, purpose string) (*Claims, error) {
+	claims, err := s.parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if purpose == "" || claims.Purpose != purpose {
+		return nil, ErrWrongPurpose
+	}
+	return claims, nil
+}
+
+// parse checks the signature and expiry of token and returns its claims
+func (s *Signer) parse(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return nil, ErrInvalidToken
+	}
+
+	expected := s.sign(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+	return &claims, nil
+}
+
+func (s *Signer) sign(input string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(input))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignerIssueAndVerify(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+
+	token, err := signer.Issue(Claims{Subject: "user-1", Role: RoleDeployer}, time.Hour)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	claims, err := signer.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.Role != RoleDeployer {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestSignerPurpose(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+
+	state, _ := signer.Issue(Claims{Purpose: PurposeLoginState, State: "abc"}, time.Hour)
+	if _, err := signer.Verify(state); err != ErrWrongPurpose {
+		t.Errorf("expected a login state token not to pass as a credential, got %v", err)
+	}
+	if claims, err := signer.VerifyPurpose(state, PurposeLoginState); err != nil || claims.State != "abc" {
+		t.Errorf("expected the login state to verify, got %+v, %v", claims, err)
+	}
+
+	// State tokens issued before purposes were recorded aren't credentials either
+	legacy, _ := signer.Issue(Claims{State: "abc"}, time.Hour)
+	if _, err := signer.Verify(legacy); err != ErrWrongPurpose {
+		t.Errorf("expected a legacy state token to be refused, got %v", err)
+	}
+
+	credential, _ := signer.Issue(Claims{Subject: "user-1"}, time.Hour)
+	if _, err := signer.VerifyPurpose(credential, PurposeLoginState); err != ErrWrongPurpose {
+		t.Errorf("expected a credential not to pass as login state, got %v", err)
+	}
+}
+
+func TestSignerRejectsTamperedAndExpired(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+
+	token, _ := signer.Issue(Claims{Subject: "user-1"}, time.Hour)
+	if _, err := NewSigner([]byte("other")).Verify(token); err != ErrInvalidToken {
+		t.Errorf("expected ErrInvalidToken for wrong key, got %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	forged := parts[0] + "." + parts[1] + "x." + parts[2]
+	if _, err := signer.Verify(forged); err != ErrInvalidToken {
+		t.Errorf("expected ErrInvalidToken for modified payload, got %v", err)
+	}
+
+	expired, _ := signer.Issue(Claims{Subject: "user-1"}, -time.Minute)
+	if _, err := signer.Verify(expired); err != ErrExpiredToken {
+		t.Errorf("expected ErrExpiredToken, got %v", err)
+	}
+}
+
+func TestSignShare(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	expires := time.Now().Add(time.Hour).Unix()
+	sig := signer.SignShare("abc", expires)
+
+	if !signer.VerifyShare("abc", expires, sig) {
+		t.Error("expected the signature to verify")
+	}
+	for name, ok := range map[string]bool{
+		"other deployment": signer.VerifyShare("abd", expires, sig),
+		"later expiry":     signer.VerifyShare("abc", expires+3600, sig),
+		"other key":        NewSigner([]byte("other")).VerifyShare("abc", expires, sig),
+		"expired":          signer.VerifyShare("abc", time.Now().Unix()-1, signer.SignShare("abc", time.Now().Unix()-1)),
+	} {
+		if ok {
+			t.Errorf("%s: expected the signature to be rejected", name)
+		}
+	}
+}
+
+func TestRoleForGroups(t *testing.T) {
+	mapping := map[string]string{"eng": RoleDeployer, "platform-admins": RoleAdmin}
+
+	tests := []struct {
+		groups   []string
+		expected string
+	}{
+		{nil, RoleViewer},
+		{[]string{"marketing"}, RoleViewer},
+		{[]string{"eng"}, RoleDeployer},
+		{[]string{"eng", "platform-admins"}, RoleAdmin},
+	}
+
+	for _, tt := range tests {
+		if got := RoleForGroups(tt.groups, mapping, RoleViewer); got != tt.expected {
+			t.Errorf("RoleForGroups(%v) = %s, expected %s", tt.groups, got, tt.expected)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		role, required string
+		expected       bool
+	}{
+		{RoleAdmin, RoleDeployer, true},
+		{RoleDeployer, RoleDeployer, true},
+		{RoleViewer, RoleDeployer, false},
+		{"", RoleViewer, false},
+		{"root", RoleViewer, false},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		if got := Allows(tt.role, tt.required); got != tt.expected {
+			t.Errorf("Allows(%q, %q) = %v, expected %v", tt.role, tt.required, got, tt.expected)
+		}
+	}
+}
+
+func TestPermits(t *testing.T) {
+	ci := &Claims{Role: RoleDeployer, TokenID: "tok-1", Scopes: []string{ScopeDeployWrite}}
+	tests := []struct {
+		claims   *Claims
+		scope    string
+		expected bool
+	}{
+		{ci, ScopeDeployWrite, true},
+		{ci, ScopeDeployRead, true},
+		{ci, ScopeDeployUpload, true},
+		{ci, ScopeDeployDelete, false},
+		{ci, ScopeAdmin, false},
+		{ci, "", true},
+		{&Claims{TokenID: "tok-2", Scopes: []string{ScopeAdmin}}, ScopeDeployDelete, true},
+		{&Claims{TokenID: "tok-3", Scopes: []string{ScopeDeployRead}}, ScopeDeployWrite, false},
+		{&Claims{TokenID: "tok-4"}, ScopeDeployRead, false},
+		{&Claims{TokenID: "tok-5", Scopes: []string{ScopeDeployUpload}}, ScopeDeployRead, false},
+		{&Claims{Role: RoleAdmin}, ScopeAdmin, true}, // sessions aren't scoped
+	}
+
+	for _, tt := range tests {
+		if got := tt.claims.Permits(tt.scope); got != tt.expected {
+			t.Errorf("%+v.Permits(%q) = %v, expected %v", tt.claims, tt.scope, got, tt.expected)
+		}
+	}
+
+	if ScopeAllowed(RoleDeployer, ScopeAdmin) || !ScopeAllowed(RoleDeployer, ScopeDeployDelete) || ScopeAllowed(RoleAdmin, "deploy:*") {
+		t.Error("expected scopes to be limited by role and known names")
+	}
+}
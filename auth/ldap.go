@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCredentials is returned when the directory rejects a login
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// LDAPConfig describes how to find and authenticate users in a directory
+type LDAPConfig struct {
+	URL            string // ldap://host:389 or ldaps://host:636
+	BindDN         string // service account used to search; empty for anonymous
+	BindPassword   string
+	UserBaseDN     string
+	UserFilter     string // e.g. (uid=%s) or (sAMAccountName=%s)
+	GroupAttribute string // attribute on the user entry listing groups, e.g. memberOf
+	Timeout        time.Duration
+	TLSConfig      *tls.Config
+}
+
+// LDAPIdentity is an authenticated directory user
+type LDAPIdentity struct {
+	DN     string
+	Email  string
+	Name   string
+	Groups []string // group CNs and full DNs, so either can be mapped to roles
+}
+
+// LDAPAuthenticator verifies usernames and passwords against LDAP or
+// Active Directory using search-then-bind
+type LDAPAuthenticator struct {
+	cfg LDAPConfig
+}
+
+// NewLDAPAuthenticator returns an authenticator for cfg
+func NewLDAPAuthenticator(cfg LDAPConfig) *LDAPAuthenticator {
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(uid=%s)"
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "memberOf"
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &LDAPAuthenticator{cfg: cfg}
+}
+
+// Authenticate looks the user up, verifies the password with a bind as the
+// user's DN and returns the user's groups
+func (a *LDAPAuthenticator) Authenticate(username, password string) (*LDAPIdentity, error) {
+	// An empty password would be an unauthenticated bind, which most
+	// servers accept for any DN
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	if a.cfg.BindDN != "" {
+		if err := conn.bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("service account bind failed: %v", err)
+		}
+	}
+
+	filter := strings.ReplaceAll(a.cfg.UserFilter, "%s", EscapeFilterValue(username))
+	entries, err := conn.search(a.cfg.UserBaseDN, filter, []string{a.cfg.GroupAttribute, "mail", "cn", "displayName"})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := entries[0]
+
+	if err := conn.bind(entry.dn, password); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	identity := &LDAPIdentity{DN: entry.dn, Email: entry.first("mail"), Name: entry.first("displayName")}
+	if identity.Name == "" {
+		identity.Name = entry.first("cn")
+	}
+	for _, groupDN := range entry.attrs[strings.ToLower(a.cfg.GroupAttribute)] {
+		identity.Groups = append(identity.Groups, groupDN)
+		if cn := firstCN(groupDN); cn != "" {
+			identity.Groups = append(identity.Groups, cn)
+		}
+	}
+	return identity, nil
+}
+
+// firstCN returns "admins" for "cn=admins,ou=groups,dc=example,dc=com"
+func firstCN(dn string) string {
+	first, _, _ := strings.Cut(dn, ",")
+	key, value, ok := strings.Cut(first, "=")
+	if ok && strings.EqualFold(strings.TrimSpace(key), "cn") {
+		return strings.TrimSpace(value)
+	}
+	return ""
+}
+
+type ldapConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	nextID  int
+	timeout time.Duration
+}
+
+type ldapEntry struct {
+	dn    string
+	attrs map[string][]string // lower-cased attribute names
+}
+
+func (e ldapEntry) first(attr string) string {
+	if v := e.attrs[strings.ToLower(attr)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (a *LDAPAuthenticator) dial() (*ldapConn, error) {
+	u, err := url.Parse(a.cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL: %v", err)
+	}
+
+	host := u.Host
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: a.cfg.Timeout}
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		tlsConfig := a.cfg.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: u.Hostname()}
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+	default:
+		return nil, fmt.Errorf("unsupported LDAP scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(a.cfg.Timeout))
+	return &ldapConn{conn: conn, r: bufio.NewReader(conn), timeout: a.cfg.Timeout}, nil
+}
+
+func (c *ldapConn) send(op []byte) (int, error) {
+	c.nextID++
+	msg := berConstructed(berSequence, berInt(berInteger, c.nextID), op)
+	_, err := c.conn.Write(msg)
+	return c.nextID, err
+}
+
+func (c *ldapConn) read(id int) (berPacket, error) {
+	for {
+		msg, err := berRead(c.r)
+		if err != nil {
+			return berPacket{}, err
+		}
+		if len(msg.children) < 2 {
+			return berPacket{}, errors.New("ldap: malformed message")
+		}
+		if msg.children[0].int() == id {
+			return msg.children[1], nil
+		}
+	}
+}
+
+func (c *ldapConn) bind(dn, password string) error {
+	id, err := c.send(berConstructed(0x60,
+		berInt(berInteger, 3),
+		berString(berOctetString, dn),
+		berString(0x80, password),
+	))
+	if err != nil {
+		return err
+	}
+	resp, err := c.read(id)
+	if err != nil {
+		return err
+	}
+	if resp.tag != 0x61 {
+		return errors.New("ldap: unexpected bind response")
+	}
+	return ldapResultError(resp)
+}
+
+func (c *ldapConn) search(baseDN, filter string, attrs []string) ([]ldapEntry, error) {
+	compiled, err := berFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var attrList [][]byte
+	for _, a := range attrs {
+		attrList = append(attrList, berString(berOctetString, a))
+	}
+
+	id, err := c.send(berConstructed(0x63,
+		berString(berOctetString, baseDN),
+		berInt(berEnumerated, 2), // wholeSubtree
+		berInt(berEnumerated, 0), // neverDerefAliases
+		berInt(berInteger, 2),    // size limit: we only need to detect ambiguity
+		berInt(berInteger, int(c.timeout.Seconds())),
+		berBool(false),
+		compiled,
+		berConstructed(berSequence, attrList...),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ldapEntry
+	for {
+		resp, err := c.read(id)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.tag {
+		case 0x64: // SearchResultEntry
+			if len(resp.children) < 2 {
+				return nil, errors.New("ldap: malformed search entry")
+			}
+			entry := ldapEntry{dn: resp.children[0].str(), attrs: map[string][]string{}}
+			for _, attr := range resp.children[1].children {
+				if len(attr.children) < 2 {
+					continue
+				}
+				name := strings.ToLower(attr.children[0].str())
+				for _, v := range attr.children[1].children {
+					entry.attrs[name] = append(entry.attrs[name], v.str())
+				}
+			}
+			entries = append(entries, entry)
+		case 0x73: // SearchResultReference; referrals aren't followed
+		case 0x65: // SearchResultDone
+			if err := ldapResultError(resp); err != nil {
+				// sizeLimitExceeded still tells us the user is ambiguous
+				if len(entries) > 1 {
+					return entries, nil
+				}
+				return nil, err
+			}
+			return entries, nil
+		default:
+			return nil, errors.New("ldap: unexpected search response")
+		}
+	}
+}
+
+func (c *ldapConn) close() {
+	c.send(berEncode(0x42, nil)) // UnbindRequest
+	c.conn.Close()
+}
+
+func ldapResultError(resp berPacket) error {
+	if len(resp.children) < 3 {
+		return errors.New("ldap: malformed result")
+	}
+	if code := resp.children[0].int(); code != 0 {
+		return fmt.Errorf("ldap: result code %d: %s", code, resp.children[2].str())
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"bufio"
+	"net"
+	"testing"
+)
+
+// fakeDirectory serves a single user over plain LDAP
+type fakeDirectory struct {
+	listener net.Listener
+	userDN   string
+	password string
+	groups   []string
+	filters  []string // equality values seen in searches
+}
+
+func newFakeDirectory(t *testing.T) *fakeDirectory {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	d := &fakeDirectory{
+		listener: l,
+		userDN:   "uid=jdoe,ou=people,dc=example,dc=com",
+		password: "s3cret",
+		groups:   []string{"cn=deployers,ou=groups,dc=example,dc=com"},
+	}
+	go d.serve()
+	return d
+}
+
+func (d *fakeDirectory) url() string { return "ldap://" + d.listener.Addr().String() }
+
+func (d *fakeDirectory) serve() {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *fakeDirectory) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := berRead(r)
+		if err != nil || len(msg.children) < 2 {
+			return
+		}
+		id := msg.children[0].int()
+		op := msg.children[1]
+		reply := func(resp []byte) {
+			conn.Write(berConstructed(berSequence, berInt(berInteger, id), resp))
+		}
+		result := func(tag byte, code int) []byte {
+			return berConstructed(tag, berInt(berEnumerated, code), berString(berOctetString, ""), berString(berOctetString, ""))
+		}
+
+		switch op.tag {
+		case 0x60: // bind
+			dn, password := op.children[1].str(), op.children[2].str()
+			if dn == "cn=svc,dc=example,dc=com" && password == "svc-pass" || dn == d.userDN && password == d.password {
+				reply(result(0x61, 0))
+			} else {
+				reply(result(0x61, 49)) // invalidCredentials
+			}
+		case 0x63: // search
+			filter := op.children[6]
+			value := ""
+			if filter.tag == 0xa3 {
+				value = filter.children[1].str()
+			}
+			d.filters = append(d.filters, value)
+			if value == "jdoe" {
+				var groupVals [][]byte
+				for _, g := range d.groups {
+					groupVals = append(groupVals, berString(berOctetString, g))
+				}
+				reply(berConstructed(0x64,
+					berString(berOctetString, d.userDN),
+					berConstructed(berSequence,
+						berConstructed(berSequence, berString(berOctetString, "memberOf"), berConstructed(berSet, groupVals...)),
+						berConstructed(berSequence, berString(berOctetString, "mail"),
+							berConstructed(berSet, berString(berOctetString, "jdoe@example.com"))),
+					),
+				))
+			}
+			reply(result(0x65, 0))
+		case 0x42: // unbind
+			return
+		}
+	}
+}
+
+func TestLDAPAuthenticator(t *testing.T) {
+	dir := newFakeDirectory(t)
+	defer dir.listener.Close()
+
+	authenticator := NewLDAPAuthenticator(LDAPConfig{
+		URL:          dir.url(),
+		BindDN:       "cn=svc,dc=example,dc=com",
+		BindPassword: "svc-pass",
+		UserBaseDN:   "ou=people,dc=example,dc=com",
+	})
+
+	identity, err := authenticator.Authenticate("jdoe", "s3cret")
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if identity.DN != dir.userDN || identity.Email != "jdoe@example.com" {
+		t.Errorf("unexpected identity: %+v", identity)
+	}
+	if RoleForGroups(identity.Groups, map[string]string{"deployers": RoleDeployer}, RoleViewer) != RoleDeployer {
+		t.Errorf("expected group CN to map to deployer, groups: %v", identity.Groups)
+	}
+
+	if _, err := authenticator.Authenticate("jdoe", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials for bad password, got %v", err)
+	}
+	if _, err := authenticator.Authenticate("nobody", "s3cret"); err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials for unknown user, got %v", err)
+	}
+	if _, err := authenticator.Authenticate("jdoe", ""); err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials for empty password, got %v", err)
+	}
+}
+
+func TestBerFilterEscaping(t *testing.T) {
+	escaped := EscapeFilterValue("a*b(c)")
+	if escaped != `a\2ab\28c\29` {
+		t.Errorf("unexpected escaped value %q", escaped)
+	}
+
+	compiled, err := berFilter("(&(objectClass=person)(uid=" + escaped + "))")
+	if err != nil {
+		t.Fatalf("berFilter failed: %v", err)
+	}
+	p, err := berParse(compiled[0], compiled[2:])
+	if err != nil {
+		t.Fatalf("failed to parse compiled filter: %v", err)
+	}
+	if p.tag != 0xa0 || len(p.children) != 2 {
+		t.Fatalf("expected AND with 2 items, got tag %x with %d children", p.tag, len(p.children))
+	}
+	if got := p.children[1].children[1].str(); got != "a*b(c)" {
+		t.Errorf("expected unescaped assertion value, got %q", got)
+	}
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// fakeProvider is a minimal OpenID provider issuing RS256 ID tokens
+type fakeProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	fp := &fakeProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 fp.URL,
+			"authorization_endpoint": fp.URL + "/authorize",
+			"token_endpoint":         fp.URL + "/token",
+			"jwks_uri":               fp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key-1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": fp.sign(t, fp.claims)})
+	})
+	fp.Server = httptest.NewServer(mux)
+	return fp
+}
+
+func (fp *fakeProvider) sign(t *testing.T, claims map[string]interface{}) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"key-1"}`))
+	payload, _ := json.Marshal(claims)
+	input := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, fp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCProviderExchange(t *testing.T) {
+	fp := newFakeProvider(t)
+	defer fp.Close()
+
+	fp.claims = map[string]interface{}{
+		"iss":    fp.URL,
+		"aud":    "hosting",
+		"sub":    "abc123",
+		"email":  "dev@example.com",
+		"groups": []string{"eng"},
+		"nonce":  "n-1",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}
+
+	provider := NewOIDCProvider(OIDCConfig{Issuer: fp.URL, ClientID: "hosting", RedirectURL: "http://localhost/cb"})
+
+	authURL, err := provider.AuthCodeURL("s-1", "n-1")
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	if u.Query().Get("state") != "s-1" || u.Query().Get("client_id") != "hosting" {
+		t.Errorf("unexpected authorization URL %s", authURL)
+	}
+
+	identity, err := provider.Exchange("good-code", "n-1")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if identity.Subject != "abc123" || identity.Email != "dev@example.com" {
+		t.Errorf("unexpected identity: %+v", identity)
+	}
+	if len(identity.Groups) != 1 || identity.Groups[0] != "eng" {
+		t.Errorf("expected groups [eng], got %v", identity.Groups)
+	}
+}
+
+func TestOIDCProviderRejectsBadTokens(t *testing.T) {
+	fp := newFakeProvider(t)
+	defer fp.Close()
+
+	provider := NewOIDCProvider(OIDCConfig{Issuer: fp.URL, ClientID: "hosting"})
+	valid := map[string]interface{}{
+		"iss": fp.URL, "aud": "hosting", "sub": "abc", "nonce": "n", "exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	tests := []struct {
+		name     string
+		override map[string]interface{}
+		nonce    string
+	}{
+		{"wrong audience", map[string]interface{}{"aud": "someone-else"}, "n"},
+		{"wrong issuer", map[string]interface{}{"iss": "https://evil.example.com"}, "n"},
+		{"expired", map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()}, "n"},
+		{"nonce mismatch", nil, "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := map[string]interface{}{}
+			for k, v := range valid {
+				claims[k] = v
+			}
+			for k, v := range tt.override {
+				claims[k] = v
+			}
+			if _, err := provider.VerifyIDToken(fp.sign(t, claims), tt.nonce); err == nil {
+				t.Error("expected verification to fail")
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashAndCheckPassword(t *testing.T) {
+	defer func(n int) { PasswordIterations = n }(PasswordIterations)
+	PasswordIterations = 1000
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword failed: %v", err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$1000$") || strings.Contains(hash, "correct horse") {
+		t.Errorf("unexpected hash %q", hash)
+	}
+	if !CheckPassword(hash, "correct horse") {
+		t.Error("expected the password to match its hash")
+	}
+	if CheckPassword(hash, "battery staple") {
+		t.Error("expected a wrong password to be rejected")
+	}
+
+	other, _ := HashPassword("correct horse")
+	if other == hash {
+		t.Error("expected each hash to use a fresh salt")
+	}
+
+	// Hashes keep working after the work factor is raised
+	PasswordIterations = 2000
+	if !CheckPassword(hash, "correct horse") {
+		t.Error("expected an older hash to still verify")
+	}
+}
+
+func TestCheckPasswordRejectsMalformedHashes(t *testing.T) {
+	for _, hash := range []string{"", "plain", "bcrypt$10$salt$key", "pbkdf2-sha256$x$c2FsdA$a2V5", "pbkdf2-sha256$1000$c2FsdA$"} {
+		if CheckPassword(hash, "") {
+			t.Errorf("expected %q to be rejected", hash)
+		}
+	}
+}
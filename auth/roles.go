@@ -0,0 +1,45 @@
+package auth
+
+// Roles, from most to least privileged
+const (
+	RoleAdmin    = "admin"
+	RoleDeployer = "deployer"
+	RoleViewer   = "viewer"
+)
+
+var roleRank = map[string]int{
+	RoleViewer:   1,
+	RoleDeployer: 2,
+	RoleAdmin:    3,
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// RoleForGroups maps a user's groups to the most privileged role found in
+// mapping (group name to role), falling back to def
+func RoleForGroups(groups []string, mapping map[string]string, def string) string {
+	best := def
+	for _, g := range groups {
+		role, ok := mapping[g]
+		if !ok {
+			continue
+		}
+		if roleRank[role] > roleRank[best] {
+			best = role
+		}
+	}
+	return best
+}
+
+// Allows reports whether role grants everything required does. Nothing is
+// required for public requests (required ""), which anyone may make.
+func Allows(role, required string) bool {
+	if required == "" {
+		return true
+	}
+	return roleRank[role] >= roleRank[required] && ValidRole(role)
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Scopes limit what an API token may do, on top of its role
+const (
+	ScopeDeployRead   = "deploy:read"   // reads
+	ScopeDeployUpload = "deploy:upload" // uploads, and nothing else
+	ScopeDeployWrite  = "deploy:write"  // uploads and other changes, but no deletions
+	ScopeDeployDelete = "deploy:delete" // deletions
+	ScopeAdmin        = "admin"         // admin endpoints, and everything else
+)
+
+// scopeImplies lists what each scope grants besides itself
+var scopeImplies = map[string][]string{
+	ScopeDeployRead:   nil,
+	ScopeDeployUpload: nil,
+	ScopeDeployWrite:  {ScopeDeployRead, ScopeDeployUpload},
+	ScopeDeployDelete: {ScopeDeployRead},
+	ScopeAdmin:        {ScopeDeployRead, ScopeDeployUpload, ScopeDeployWrite, ScopeDeployDelete},
+}
+
+// scopeRole is the least role that may hold each scope
+var scopeRole = map[string]string{
+	ScopeDeployRead:   RoleViewer,
+	ScopeDeployUpload: RoleDeployer,
+	ScopeDeployWrite:  RoleDeployer,
+	ScopeDeployDelete: RoleDeployer,
+	ScopeAdmin:        RoleAdmin,
+}
+
+// ValidScope reports whether scope is one of the known scopes
+func ValidScope(scope string) bool {
+	_, ok := scopeImplies[scope]
+	return ok
+}
+
+// ScopeAllowed reports whether role may hold scope
+func ScopeAllowed(role, scope string) bool {
+	return ValidScope(scope) && Allows(role, scopeRole[scope])
+}
+
+// Permits reports whether claims may make a request needing scope. Only
+// API tokens carry scopes; sessions are limited by their role alone.
+func (c *Claims) Permits(scope string) bool {
+	if c.TokenID == "" || scope == "" {
+		return true
+	}
+	for _, held := range c.Scopes {
+		if held == scope {
+			return true
+		}
+		for _, implied := range scopeImplies[held] {
+			if implied == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TokenHash is what is stored of an API token to recognise it: its
+// SHA-256, hex-encoded. Tokens are random enough that no salt is needed.
+func TokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,23 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"strconv"
+	"time"
+)
+
+// SignShare returns the signature of a link sharing deploymentID until
+// expires (Unix seconds). Its input can't be mistaken for a JWT's, so
+// neither signature is ever valid as the other.
+func (s *Signer) SignShare(deploymentID string, expires int64) string {
+	return s.sign("share\x00" + deploymentID + "\x00" + strconv.FormatInt(expires, 10))
+}
+
+// VerifyShare reports whether sig signs a link sharing deploymentID until
+// expires, and expires is still to come
+func (s *Signer) VerifyShare(deploymentID string, expires int64, sig string) bool {
+	if time.Now().Unix() >= expires {
+		return false
+	}
+	return hmac.Equal([]byte(s.SignShare(deploymentID, expires)), []byte(sig))
+}
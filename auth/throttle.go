@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"static-site-hosting/shared"
+)
+
+// Throttle slows down repeated authentication failures. Each key (a client
+// IP, or a username) gets a number of free failures; every failure after
+// that locks the key out for twice as long as the previous one, up to a
+// maximum. Failures are forgotten once a key has been quiet for the maximum
+// lockout. A nil Throttle never throttles.
+type Throttle struct {
+	FreeFailures int
+	BaseLockout  time.Duration
+	MaxLockout   time.Duration
+	// TrustProxy takes the client IP from X-Forwarded-For, for deployments
+	// behind a reverse proxy; otherwise the connection's address is used
+	TrustProxy bool
+	// Shared keeps failures and lockouts where every node sees them, so a
+	// client can't spread its attempts across nodes. While it fails, each
+	// node throttles on its own.
+	Shared shared.Store
+
+	mu      sync.Mutex
+	entries map[string]*failureRecord
+	now     func() time.Time
+}
+
+type failureRecord struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// throttleMaxEntries bounds memory; quiet keys are pruned beyond it
+const throttleMaxEntries = 10000
+
+// NewThrottle returns a throttle allowing free failures per key before
+// lockouts of base, doubling up to max
+func NewThrottle(free int, base, max time.Duration) *Throttle {
+	return &Throttle{
+		FreeFailures: free,
+		BaseLockout:  base,
+		MaxLockout:   max,
+		entries:      map[string]*failureRecord{},
+		now:          time.Now,
+	}
+}
+
+// IPKey is the throttle key for the client making r
+func (t *Throttle) IPKey(r *http.Request) string {
+	return "ip:" + t.ClientIP(r)
+}
+
+// ClientIP returns the address of the client making r
+func (t *Throttle) ClientIP(r *http.Request) string {
+	if t != nil && t.TrustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// UserKey is the throttle key for login attempts on username
+func UserKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+// Wait returns how long the caller must wait before another attempt for
+// any of keys is accepted; 0 means go ahead
+func (t *Throttle) Wait(keys ...string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	if wait, ok := t.sharedWait(keys); ok {
+		return wait
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	var wait time.Duration
+	for _, key := range keys {
+		if e, ok := t.entries[key]; ok && e.lockedUntil.After(now) {
+			wait = max(wait, e.lockedUntil.Sub(now))
+		}
+	}
+	return wait
+}
+
+// Fail records a failed attempt for each key and returns the longest
+// lockout it caused
+func (t *Throttle) Fail(keys ...string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	if lockout, ok := t.sharedFail(keys); ok {
+		return lockout
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if len(t.entries) >= throttleMaxEntries {
+		t.prune(now)
+	}
+
+	var lockout time.Duration
+	for _, key := range keys {
+		e, ok := t.entries[key]
+		if !ok || now.Sub(e.last) > t.MaxLockout {
+			e = &failureRecord{}
+			t.entries[key] = e
+		}
+		e.count++
+		e.last = now
+		if d := t.lockoutAfter(e.count); d > 0 {
+			e.lockedUntil = now.Add(d)
+			lockout = max(lockout, d)
+		}
+	}
+	return lockout
+}
+
+// lockoutAfter returns the lockout the count-th failure in a row earns
+func (t *Throttle) lockoutAfter(count int) time.Duration {
+	over := count - t.FreeFailures
+	if over <= 0 {
+		return 0
+	}
+	d := t.BaseLockout
+	for i := 1; i < over && d < t.MaxLockout; i++ {
+		d *= 2
+	}
+	return min(d, t.MaxLockout)
+}
+
+// Reset forgets the failures of each key, after a successful attempt
+func (t *Throttle) Reset(keys ...string) {
+	if t == nil {
+		return
+	}
+	if t.Shared != nil {
+		ctx, cancel := shared.Context()
+		var sharedKeys []string
+		for _, key := range keys {
+			sharedKeys = append(sharedKeys, failuresKey(key), lockKey(key))
+		}
+		if err := t.Shared.Del(ctx, sharedKeys...); err != nil {
+			log.Printf("Warning: Failed to reset shared throttle state: %v", err)
+		}
+		cancel()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range keys {
+		delete(t.entries, key)
+	}
+}
+
+// Shared store keys: a key's failure count, forgotten once it has been
+// quiet for MaxLockout, and its lockout, expiring when the lockout ends
+func failuresKey(key string) string { return "throttle:failures:" + key }
+func lockKey(key string) string     { return "throttle:lock:" + key }
+
+// sharedWait is Wait against the shared store; ok is false when there is
+// none or it can't be reached
+func (t *Throttle) sharedWait(keys []string) (wait time.Duration, ok bool) {
+	if t.Shared == nil {
+		return 0, false
+	}
+	ctx, cancel := shared.Context()
+	defer cancel()
+	for _, key := range keys {
+		d, err := t.Shared.TTL(ctx, lockKey(key))
+		if err != nil {
+			log.Printf("Warning: Shared throttle state unavailable: %v", err)
+			return 0, false
+		}
+		wait = max(wait, d)
+	}
+	return wait, true
+}
+
+// sharedFail is Fail against the shared store; ok is false when there is
+// none or it can't be reached
+func (t *Throttle) sharedFail(keys []string) (lockout time.Duration, ok bool) {
+	if t.Shared == nil {
+		return 0, false
+	}
+	ctx, cancel := shared.Context()
+	defer cancel()
+	for _, key := range keys {
+		count, err := t.Shared.IncrBy(ctx, failuresKey(key), 1, t.MaxLockout)
+		if err != nil {
+			log.Printf("Warning: Shared throttle state unavailable: %v", err)
+			return 0, false
+		}
+		if d := t.lockoutAfter(int(count)); d > 0 {
+			if err := t.Shared.Set(ctx, lockKey(key), 1, d); err != nil {
+				log.Printf("Warning: Shared throttle state unavailable: %v", err)
+				return 0, false
+			}
+			lockout = max(lockout, d)
+		}
+	}
+	return lockout, true
+}
+
+// RetryAfter formats a wait as a Retry-After header value in whole seconds
+func RetryAfter(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
+
+func (t *Throttle) prune(now time.Time) {
+	for key, e := range t.entries {
+		if now.Sub(e.last) > t.MaxLockout {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// Audit events for authentication
+const (
+	AuditLoginSucceeded = "auth.login_succeeded"
+	AuditLoginFailed    = "auth.login_failed"
+	AuditTokenRejected  = "auth.token_rejected"
+	AuditThrottled      = "auth.throttled"
+	AuditTOTPFailed     = "auth.totp_failed"
+)
+
+// AuditEntry is one security-relevant event, written as a JSON line
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	IP       string    `json:"ip"`
+	Path     string    `json:"path"`
+	Subject  string    `json:"subject,omitempty"`
+	Provider string    `json:"provider,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+}
+
+// auditLog receives audit entries; nil falls back to the standard logger
+var auditLog *log.Logger
+
+// SetAuditOutput sends audit entries to w instead of the application log
+func SetAuditOutput(w io.Writer) {
+	if w == nil {
+		auditLog = nil
+		return
+	}
+	auditLog = log.New(w, "", 0)
+}
+
+// Audit records an authentication event for the client making r
+func (t *Throttle) Audit(r *http.Request, e AuditEntry) {
+	e.Time = time.Now().UTC()
+	e.IP = t.ClientIP(r)
+	e.Path = r.URL.Path
+	line, _ := json.Marshal(e)
+	if auditLog != nil {
+		auditLog.Print(string(line))
+	} else {
+		log.Printf("audit: %s", line)
+	}
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"static-site-hosting/shared"
+)
+
+func TestThrottleLockoutsGrowAndExpire(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	th := NewThrottle(2, time.Second, 8*time.Second)
+	th.now = func() time.Time { return now }
+
+	if d := th.Fail("ip:a"); d != 0 {
+		t.Errorf("expected the first failure to be free, got %v", d)
+	}
+	th.Fail("ip:a")
+	if w := th.Wait("ip:a"); w != 0 {
+		t.Errorf("expected no wait within the free failures, got %v", w)
+	}
+
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second} {
+		if d := th.Fail("ip:a"); d != want {
+			t.Errorf("failure %d: expected lockout %v, got %v", i+3, want, d)
+		}
+	}
+	if w := th.Wait("ip:b", "ip:a"); w != 8*time.Second {
+		t.Errorf("expected the longest wait of any key, got %v", w)
+	}
+
+	now = now.Add(8 * time.Second)
+	if w := th.Wait("ip:a"); w != 0 {
+		t.Errorf("expected the lockout to have expired, got %v", w)
+	}
+	if d := th.Fail("ip:a"); d != 8*time.Second {
+		t.Errorf("expected failures to be remembered while recent, got %v", d)
+	}
+
+	// A key quiet for longer than the maximum lockout starts afresh
+	now = now.Add(9 * time.Second)
+	if d := th.Fail("ip:a"); d != 0 {
+		t.Errorf("expected old failures to be forgotten, got %v", d)
+	}
+
+	th.Fail("ip:a")
+	th.Fail("ip:a")
+	th.Reset("ip:a")
+	if w := th.Wait("ip:a"); w != 0 {
+		t.Errorf("expected Reset to clear the lockout, got %v", w)
+	}
+}
+
+func TestThrottleClientIP(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:5000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 10.0.0.1")
+
+	th := NewThrottle(1, time.Second, time.Minute)
+	if got := th.IPKey(req); got != "ip:10.0.0.1" {
+		t.Errorf("expected the connection address without TrustProxy, got %s", got)
+	}
+	th.TrustProxy = true
+	if got := th.IPKey(req); got != "ip:203.0.113.9" {
+		t.Errorf("expected the forwarded client address, got %s", got)
+	}
+
+	var nilThrottle *Throttle
+	if nilThrottle.Fail("x") != 0 || nilThrottle.Wait("x") != 0 {
+		t.Error("expected a nil throttle to never lock out")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if got := RetryAfter(1500 * time.Millisecond); got != "2" {
+		t.Errorf("expected seconds rounded up, got %s", got)
+	}
+}
+
+func TestThrottleSharesLockoutsAcrossNodes(t *testing.T) {
+	store := shared.NewMemory()
+	nodeA := NewThrottle(1, time.Minute, time.Hour)
+	nodeB := NewThrottle(1, time.Minute, time.Hour)
+	nodeA.Shared, nodeB.Shared = store, store
+
+	nodeA.Fail("ip:a")
+	if d := nodeB.Fail("ip:a"); d != time.Minute {
+		t.Errorf("expected failures on both nodes to add up to a lockout, got %v", d)
+	}
+	if w := nodeA.Wait("ip:a"); w <= 0 || w > time.Minute {
+		t.Errorf("expected the lockout to apply on the other node, got %v", w)
+	}
+
+	nodeB.Reset("ip:a")
+	if w := nodeA.Wait("ip:a"); w != 0 {
+		t.Errorf("expected Reset to clear the lockout everywhere, got %v", w)
+	}
+}
+
+// failingStore is a shared store that can't be reached
+type failingStore struct{ shared.Memory }
+
+func (*failingStore) IncrBy(context.Context, string, int64, time.Duration) (int64, error) {
+	return 0, errors.New("connection refused")
+}
+
+func (*failingStore) TTL(context.Context, string) (time.Duration, error) {
+	return 0, errors.New("connection refused")
+}
+
+func TestThrottleFallsBackToLocalState(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	th := NewThrottle(0, time.Minute, time.Hour)
+	th.now = func() time.Time { return now }
+	th.Shared = &failingStore{}
+	if d := th.Fail("ip:a"); d != time.Minute {
+		t.Errorf("expected a local lockout, got %v", d)
+	}
+	if w := th.Wait("ip:a"); w != time.Minute {
+		t.Errorf("expected the local lockout to be enforced, got %v", w)
+	}
+}
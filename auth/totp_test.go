@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		if code, err := TOTPCode(secret, time.Unix(unix, 0)); err != nil || code != expected {
+			t.Errorf("at %d: expected %s, got %s (%v)", unix, expected, code, err)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	code, _ := TOTPCode(secret, now)
+	if step, ok := VerifyTOTP(secret, code, now.Add(totpStep)); !ok || step != now.Unix()/30 {
+		t.Errorf("expected a code from the previous step to be accepted, got step %d, %v", step, ok)
+	}
+	if _, ok := VerifyTOTP(secret, code, now.Add(3*totpStep)); ok {
+		t.Error("expected an old code to be refused")
+	}
+	if _, ok := VerifyTOTP(secret, "", now); ok {
+		t.Error("expected an empty code to be refused")
+	}
+
+	generated, err := NewTOTPSecret()
+	if err != nil || len(generated) != 32 {
+		t.Fatalf("expected a 32-character secret, got %q, %v", generated, err)
+	}
+	if uri := TOTPURI("Static Sites", "alice", generated); !strings.HasPrefix(uri, "otpauth://totp/Static%20Sites:alice?") || !strings.Contains(uri, "secret="+generated) {
+		t.Errorf("unexpected URI %s", uri)
+	}
+}
@@ -17,70 +17,12 @@ import (
 
 	_ "github.com/mattn/go-sqlite3"
 
-	"static-site-hosting/handlers"
-	"static-site-hosting/middleware"
 	"static-site-hosting/models"
+	"static-site-hosting/testsupport"
 )
 
 func setupTestE2EDatabase(t *testing.T) *sql.DB {
-	// Create in-memory SQLite database for testing
-	db, err := sql.Open("sqlite3", ":memory:")
-	if err != nil {
-		t.Fatalf("Failed to create test database: %v", err)
-	}
-
-	// Create tables
-	createDeploymentsTable := `
-	CREATE TABLE deployments (
-		id TEXT PRIMARY KEY,
-		filename TEXT NOT NULL,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		path TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	)`
-
-	if _, err := db.Exec(createDeploymentsTable); err != nil {
-		t.Fatalf("Failed to create deployments table: %v", err)
-	}
-
-	return db
-}
-
-func setupE2ERoutes(db *sql.DB) *http.ServeMux {
-	mux := http.NewServeMux()
-
-	// API endpoints with database
-	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
-		handlers.UploadHandler(w, r, db)
-	})
-
-	// Handle both list (GET) and delete all (DELETE) on /deployments
-	mux.HandleFunc("/deployments", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			handlers.ListDeploymentsHandler(w, r, db)
-		case http.MethodDelete:
-			handlers.DeleteAllDeploymentsHandler(w, r, db)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	mux.HandleFunc("/deployments/", func(w http.ResponseWriter, r *http.Request) {
-		handlers.DeleteDeploymentHandler(w, r, db)
-	})
-	mux.HandleFunc("/rollback/", func(w http.ResponseWriter, r *http.Request) {
-		handlers.RollbackHandler(w, r, db)
-	})
-	mux.HandleFunc("/reset", func(w http.ResponseWriter, r *http.Request) {
-		handlers.ResetSystemHandler(w, r, db)
-	})
-	mux.HandleFunc("/hello-world", handlers.HelloWorldHandler)
-
-	// Static file serving - this should be last since it's a catch-all
-	mux.Handle("/", handlers.StaticFileHandler())
-
-	return mux
+	return testsupport.NewStore(t)
 }
 
 // E2E Test that simulates the complete user workflow
@@ -92,9 +34,8 @@ func TestE2EStaticSiteHostingWorkflow(t *testing.T) {
 	db := setupTestE2EDatabase(t)
 	defer db.Close()
 
-	// Create test server with database
-	mux := setupE2ERoutes(db)
-	server := httptest.NewServer(middleware.LoggingMiddleware(mux))
+	// Create test server with the server's own routes and middleware
+	server := httptest.NewServer(testsupport.Routes(db))
 	defer server.Close()
 
 	t.Run("Complete Workflow", func(t *testing.T) {
@@ -154,7 +95,7 @@ func TestE2EStaticSiteHostingWorkflow(t *testing.T) {
 		}
 
 		client := &http.Client{}
-		resp, err := client.Do(req)
+		resp, err := client.Do(testsupport.AsAdmin(req))
 		if err != nil {
 			t.Fatalf("Delete request failed: %v", err)
 		}
@@ -184,7 +125,7 @@ func TestE2EStaticSiteHostingWorkflow(t *testing.T) {
 			t.Fatalf("Failed to create rollback request: %v", err)
 		}
 
-		resp, err = client.Do(req)
+		resp, err = client.Do(testsupport.AsAdmin(req))
 		if err != nil {
 			t.Fatalf("Rollback request failed: %v", err)
 		}
@@ -207,8 +148,9 @@ func TestE2EStaticSiteHostingWorkflow(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Failed to create delete all request: %v", err)
 		}
+		deleteAllReq.Header.Set("X-Confirm", "yes")
 
-		resp, err = client.Do(deleteAllReq)
+		resp, err = client.Do(testsupport.AsAdmin(deleteAllReq))
 		if err != nil {
 			t.Fatalf("Delete all request failed: %v", err)
 		}
@@ -227,7 +169,7 @@ func TestE2EStaticSiteHostingWorkflow(t *testing.T) {
 
 		// Verify deleted site is no longer accessible
 		t.Log("Step 14: Verify all deleted sites inaccessible")
-		resp, err = http.Get(server.URL + "/" + deployment2.ID + "/index.html")
+		resp, err = http.Get(server.URL + "/s/" + deployment2.ID + "/index.html")
 		if err != nil {
 			t.Fatalf("Failed to test deleted site access: %v", err)
 		}
@@ -245,8 +187,7 @@ func TestE2EErrorScenarios(t *testing.T) {
 	db := setupTestE2EDatabase(t)
 	defer db.Close()
 
-	mux := setupE2ERoutes(db)
-	server := httptest.NewServer(middleware.LoggingMiddleware(mux))
+	server := httptest.NewServer(testsupport.Routes(db))
 	defer server.Close()
 
 	t.Run("Invalid Upload", func(t *testing.T) {
@@ -257,11 +198,12 @@ func TestE2EErrorScenarios(t *testing.T) {
 		part.Write([]byte("not a zip file"))
 		writer.Close()
 
-		resp, err := http.Post(
-			server.URL+"/upload",
-			writer.FormDataContentType(),
-			body,
-		)
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/upload", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		resp, err := http.DefaultClient.Do(testsupport.AsAdmin(req))
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -273,7 +215,7 @@ func TestE2EErrorScenarios(t *testing.T) {
 	})
 
 	t.Run("Access Non-existent Site", func(t *testing.T) {
-		resp, err := http.Get(server.URL + "/nonexistent-site/index.html")
+		resp, err := http.Get(server.URL + "/s/nonexistent-site/index.html")
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -289,7 +231,7 @@ func TestE2EErrorScenarios(t *testing.T) {
 		deployment := uploadTestSite(t, server.URL)
 
 		// Try to access non-existent file
-		resp, err := http.Get(server.URL + "/" + deployment.ID + "/nonexistent.html")
+		resp, err := http.Get(server.URL + "/s/" + deployment.ID + "/nonexistent.html")
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -310,9 +252,10 @@ func TestE2EErrorScenarios(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Failed to create delete all request: %v", err)
 		}
+		deleteAllReq.Header.Set("X-Confirm", "yes")
 
 		client := &http.Client{}
-		resp, err := client.Do(deleteAllReq)
+		resp, err := client.Do(testsupport.AsAdmin(deleteAllReq))
 		if err != nil {
 			t.Fatalf("Delete all request failed: %v", err)
 		}
@@ -343,9 +286,10 @@ func TestE2EErrorScenarios(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Failed to create reset request: %v", err)
 		}
+		resetReq.Header.Set("X-Confirm", "yes")
 
 		client := &http.Client{}
-		resp, err := client.Do(resetReq)
+		resp, err := client.Do(testsupport.AsAdmin(resetReq))
 		if err != nil {
 			t.Fatalf("Reset request failed: %v", err)
 		}
@@ -407,11 +351,12 @@ func uploadTestSite(t *testing.T, serverURL string) models.Deployment {
 	io.Copy(part, zipBuffer)
 	writer.Close()
 
-	resp, err := http.Post(
-		serverURL+"/upload",
-		writer.FormDataContentType(),
-		body,
-	)
+	req, err := http.NewRequest(http.MethodPost, serverURL+"/upload", body)
+	if err != nil {
+		t.Fatalf("Failed to create upload request: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := http.DefaultClient.Do(testsupport.AsAdmin(req))
 	if err != nil {
 		t.Fatalf("Upload request failed: %v", err)
 	}
@@ -431,7 +376,11 @@ func uploadTestSite(t *testing.T, serverURL string) models.Deployment {
 }
 
 func listDeployments(t *testing.T, serverURL string) []models.Deployment {
-	resp, err := http.Get(serverURL + "/deployments")
+	req, err := http.NewRequest(http.MethodGet, serverURL+"/deployments", nil)
+	if err != nil {
+		t.Fatalf("Failed to create list request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(testsupport.AsAdmin(req))
 	if err != nil {
 		t.Fatalf("Failed to list deployments: %v", err)
 	}
@@ -464,7 +413,7 @@ func testStaticFileAccess(t *testing.T, serverURL, siteID string) {
 	}
 
 	for _, tc := range testCases {
-		url := fmt.Sprintf("%s/%s/%s", serverURL, siteID, tc.file)
+		url := fmt.Sprintf("%s/s/%s/%s", serverURL, siteID, tc.file)
 		resp, err := http.Get(url)
 		if err != nil {
 			t.Errorf("Failed to access %s: %v", tc.file, err)
@@ -500,8 +449,7 @@ func TestE2EPerformance(t *testing.T) {
 	db := setupTestE2EDatabase(t)
 	defer db.Close()
 
-	mux := setupE2ERoutes(db)
-	server := httptest.NewServer(middleware.LoggingMiddleware(mux))
+	server := httptest.NewServer(testsupport.Routes(db))
 	defer server.Close()
 
 	// Upload a site
@@ -516,7 +464,7 @@ func TestE2EPerformance(t *testing.T) {
 
 		for i := 0; i < numRequests; i++ {
 			go func() {
-				resp, err := http.Get(server.URL + "/" + deployment.ID + "/index.html")
+				resp, err := http.Get(server.URL + "/s/" + deployment.ID + "/index.html")
 				if err != nil {
 					t.Errorf("Request failed: %v", err)
 				} else {
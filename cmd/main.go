@@ -1,18 +1,119 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 
+	"static-site-hosting/artifacts"
+	"static-site-hosting/atrest"
+	"static-site-hosting/auth"
+	"static-site-hosting/config"
+	"static-site-hosting/discovery"
+	"static-site-hosting/features"
+	"static-site-hosting/fixtures"
 	"static-site-hosting/handlers"
+	"static-site-hosting/i18n"
+	"static-site-hosting/immutable"
+	"static-site-hosting/integrity"
+	"static-site-hosting/logging"
 	"static-site-hosting/middleware"
+	"static-site-hosting/notify"
+	"static-site-hosting/orgs"
+	"static-site-hosting/quota"
+	"static-site-hosting/retention"
+	"static-site-hosting/routecache"
+	"static-site-hosting/schema"
+	"static-site-hosting/secrets"
+	"static-site-hosting/server"
+	"static-site-hosting/sessions"
+	"static-site-hosting/shared"
+	"static-site-hosting/tracing"
+	"static-site-hosting/usage"
+	"static-site-hosting/webhooks"
+)
+
+// Set at build time with -ldflags "-X main.version=1.2.0 -X main.commit=abc123"
+var (
+	version = "dev"
+	commit  = ""
 )
 
 func main() {
+	// "--validate-config" checks the configuration, for CI, and exits
+	if len(os.Args) > 1 && os.Args[1] == "--validate-config" {
+		os.Exit(validateConfig(os.Stdout))
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// "--ephemeral" keeps nothing once the process exits: the database and
+	// retained artifacts are in memory, and deployments are written to a
+	// temporary directory
+	ephemeral := hasFlag("--ephemeral")
+	if ephemeral {
+		dir, err := enterTempDir(cfg)
+		if err != nil {
+			log.Fatalf("Error creating ephemeral directory: %v", err)
+		}
+		defer immutable.RemoveAll(dir)
+		log.Printf("Ephemeral mode: nothing is kept after exit, deployments are in %s", dir)
+	}
+
+	// Route logs to rotating files when configured
+	accessLog, closeLogs, err := logging.Setup(cfg)
+	if err != nil {
+		log.Fatalf("Error opening log files: %v", err)
+	}
+	defer closeLogs()
+	if accessLog != nil {
+		middleware.SetAccessLogOutput(accessLog)
+	}
+
+	keyring, err := secretsKeyring(cfg)
+	if err != nil {
+		log.Fatalf("Invalid secrets key: %v", err)
+	}
+	secrets.Default = keyring
+
+	storage, err := storageCipher(cfg)
+	if err != nil {
+		log.Fatalf("Invalid storage key: %v", err)
+	}
+	atrest.Default = storage
+
+	// "rotate-secrets" re-encrypts stored secrets with the current key and exits
+	if len(os.Args) > 1 && os.Args[1] == "rotate-secrets" {
+		if err := rotateSecrets(keyring); err != nil {
+			log.Fatalf("Secret rotation failed: %v", err)
+		}
+		return
+	}
+
+	if cfg.OTLPEndpoint != "" {
+		exporter := tracing.NewOTLPExporter(cfg.OTLPEndpoint, cfg.ServiceName)
+		defer exporter.Close()
+		tracing.SetExporter(exporter)
+	}
+
 	// Ensure necessary directories exist
 	if err := os.MkdirAll("deployments", 0755); err != nil {
 		log.Fatalf("Error creating deployments directory: %v", err)
@@ -23,40 +124,333 @@ func main() {
 	}
 
 	// Setup and connect to the database
-	db, err := setupDatabase()
+	var db *sql.DB
+	if ephemeral {
+		db, err = schema.OpenMemory()
+	} else {
+		db, err = setupDatabase()
+	}
 	if err != nil {
 		log.Fatalf("Database setup failed: %v", err)
 	}
 	defer db.Close()
 
-	// Setup HTTP routes
-	mux := setupRoutes(db)
+	handlers.Configure(cfg)
+	if err := handlers.PrepareSpool(); err != nil {
+		log.Fatalf("Error preparing spool directory: %v", err)
+	}
+	handlers.SetBuildInfo(version, commit)
+	immutable.Chattr = cfg.ImmutableChattr
+	routecache.TTL = cfg.RoutingCacheTTL
+	routecache.MaxStale = cfg.RoutingCacheMaxStale
+
+	flags, err := features.New(db, cfg.FeatureFlags)
+	if err != nil {
+		log.Fatalf("Feature flags: %v", err)
+	}
+	handlers.SetFeatures(flags)
+
+	// "--seed" fills an empty database with sample sites for development
+	if hasFlag("--seed") {
+		sites, _ := fs.Sub(fixtures.Sites, "sites")
+		n, err := handlers.Seed(db, sites)
+		if err != nil {
+			log.Fatalf("Seeding failed after %d deployments: %v", n, err)
+		}
+		log.Printf("Seeded %d sample deployments", n)
+	}
+
+	switch cfg.ArtifactStore {
+	case config.ArtifactStoreLocal:
+		artifacts.Default = artifacts.Local{Dir: cfg.ArtifactDir}
+	case config.ArtifactStoreS3:
+		artifacts.Default = artifacts.S3{
+			Endpoint:  cfg.ArtifactS3Endpoint,
+			Region:    cfg.ArtifactS3Region,
+			Bucket:    cfg.ArtifactS3Bucket,
+			Prefix:    cfg.ArtifactS3Prefix,
+			AccessKey: cfg.AWSAccessKeyID,
+			SecretKey: cfg.AWSSecretAccessKey,
+		}
+	case config.ArtifactStoreMemory:
+		artifacts.Default = artifacts.NewMemory()
+	}
+	if ephemeral && artifacts.Default != nil {
+		artifacts.Default = artifacts.NewMemory()
+	}
+
+	// Deliver queued webhook events in the background
+	dispatcher := webhooks.NewDispatcher(db, cfg.WebhookMaxAttempts, cfg.WebhookBackoff)
+	go dispatcher.Run(context.Background())
+
+	// Lockouts and bandwidth are counted across nodes when they share Redis,
+	// and routing lookups cached there for all of them
+	var sharedStore shared.Store
+	if cfg.RedisURL != "" {
+		store, err := shared.ParseRedisURL(cfg.RedisURL, cfg.RedisKeyPrefix)
+		if err != nil {
+			log.Fatalf("Invalid REDIS_URL: %v", err)
+		}
+		defer store.Close()
+		sharedStore = store
+		usage.Shared = store
+		if cfg.RoutingCacheShared {
+			routecache.Share(context.Background(), store)
+		}
+	}
+
+	// Bandwidth is buffered in memory and flushed for billing exports
+	meter := usage.NewMeter(db)
+	handlers.SetUsageMeter(meter)
+	go meter.Run(context.Background(), time.Minute)
+	// So is API token use, to find stale and abused tokens
+	tokenMeter := usage.NewTokenMeter(db)
+	handlers.SetTokenMeter(tokenMeter)
+	go tokenMeter.Run(context.Background(), time.Minute)
+
+	mailer := &notify.Mailer{
+		Addr:     cfg.SMTPAddr,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.SMTPFrom,
+	}
+	// Sites can be given an expiry date at any time, so the sweeper always
+	// runs; deployments themselves only expire if RetentionMaxAge is set
+	policy := retention.Policy{MaxAge: cfg.RetentionMaxAge, Warning: cfg.RetentionWarning}
+	sweeper := retention.NewSweeper(db, policy, mailer, cfg.ExpiryNotifyEmails)
+	sweeper.SiteGrace = cfg.SiteExpiryGrace
+	go sweeper.Run(context.Background())
+
+	// Sites keep being served from the routing cache if the database fails
+	go routecache.Monitor(context.Background(), db, 5*time.Second)
 
-	// Apply middleware
-	wrappedMux := middleware.LoggingMiddleware(mux)
+	// Integrity checks can always be run on demand; nightly only if scheduled
+	checker := integrity.NewChecker(db, cfg.IntegrityCheckHour, cfg.IntegritySamplePercent, mailer, cfg.IntegrityNotifyEmails)
+	handlers.SetIntegrityChecker(checker)
+	if cfg.IntegrityCheckHour >= 0 {
+		go checker.Run(context.Background())
+	}
+
+	// Quotas only warn: on API responses, and once per threshold crossed
+	limits := quota.Limits{StorageBytes: cfg.StorageQuotaBytes, BandwidthBytes: cfg.BandwidthQuotaBytes}
+	watcher := quota.NewWatcher(db, limits, cfg.QuotaWarnPercents, cfg.QuotaCheckInterval, mailer, cfg.QuotaNotifyEmails)
+	handlers.SetQuotaWatcher(watcher)
+	if watcher.Enabled() {
+		go watcher.Run(context.Background())
+	}
+
+	signer := auth.NewSigner(authSecret(cfg))
+	sessionStore := sessions.New(db)
+	handlers.SetSessionStore(sessionStore)
+	handlers.SetOrgStore(orgs.New(db))
+	handlers.SetShareSigner(signer)
+	throttle := auth.NewThrottle(cfg.AuthMaxFailures, cfg.AuthLockoutBase, cfg.AuthLockoutMax)
+	throttle.TrustProxy = cfg.TrustProxyHeaders
+	throttle.Shared = sharedStore
+
+	// API error messages follow Accept-Language
+	catalog := i18n.New()
+	if cfg.I18nDir != "" {
+		if err := catalog.LoadDir(cfg.I18nDir); err != nil {
+			log.Fatalf("Error loading translations: %v", err)
+		}
+	}
+
+	handler := server.Handler(server.Options{
+		DB:             db,
+		Config:         cfg,
+		Signer:         signer,
+		Throttle:       throttle,
+		Catalog:        catalog,
+		SessionActive:  sessionStore.Active,
+		RecordTokenUse: tokenMeter.Record,
+	})
 
 	log.Println("Endpoints available:")
 	log.Println("  POST /upload - Upload a zip file")
-	log.Println("  GET /deployments - List all deployments")
-	log.Println("  DELETE /deployments - Delete ALL deployments")
+	log.Println("  GET /uploads/{id}/progress - Upload and extraction progress")
+	log.Println("  GET /uploads/{id} - Chunks received of a chunked upload, to resume it")
+	log.Println("  PUT /uploads/{id}/chunks/{n} - Upload one chunk of a large archive")
+	log.Println("  POST /uploads/{id}/complete - Deploy the chunks of an upload, joined in order")
+	log.Println("  GET /deployments - List your deployments (all for admins)")
+	log.Println("  DELETE /deployments - Delete ALL deployments (X-Confirm: yes, or ?dry_run=true)")
 	log.Println("  DELETE /deployments/{id} - Delete a deployment")
+	log.Println("  PATCH /deployments/{id} - Make a deployment public or private")
+	log.Println("  GET /deployments/expiring?days=N - Deployments scheduled for deletion")
+	log.Println("  POST|DELETE /deployments/{id}/pin - Pin or unpin a deployment")
+	log.Println("  GET|PUT|DELETE /deployments/{id}/password - Password-protect a deployment")
+	log.Println("  POST /deployments/{id}/share?ttl=1h - Signed, expiring link to a protected deployment")
+	log.Println("  GET /deployments/{id}/files - Paginated file manifest")
+	log.Println("  GET|PUT /deployments/{id}/files/{path} - Read or patch a single file (If-Match)")
+	log.Println("  GET /deployments/{id}/diff?against={id} - File changes and size deltas")
+	log.Println("  GET /deployments/{id}/artifact - Download the original uploaded archive")
+	log.Println("  POST /deployments/{id}/artifact/verify|extract - Re-verify or re-extract the archive")
 	log.Println("  POST /rollback/{id} - Rollback to a previous deployment")
-	log.Println("  POST /reset - Reset entire system (nuclear option)")
-	log.Println("  GET /{site-id}/{file-path} - Serve static files")
-	log.Println("  GET /hello-world - Test endpoint")
+	log.Println("  POST /reset - Reset entire system (X-Confirm: yes, or ?dry_run=true)")
+	log.Println("  GET|PUT /sites/{site-id}/settings - View or update site settings")
+	log.Println("  GET /sites - List sites with their live and latest deployments")
+	log.Println("  PUT /sites/{slug}/deployments - Deploy a raw zip or tar body")
+	log.Println("  GET /sites/{slug}/export - Export a site's settings, domains and latest deployments")
+	log.Println("  GET /sites/{slug}/manifest.json - List the live deployment's assets with hashes")
+	log.Println("  POST /sites/import - Import a site exported from another server")
+	log.Println("  POST /sites/{slug}/migrate - Deploy a Netlify or Vercel export with its redirects and headers")
+	log.Println("  GET|PUT|DELETE /sites/{slug}/expiry - View, set or lift a site's expiry date")
+	log.Println("  GET /s/{site-id}/{file-path} - Serve static files")
+	log.Println("  /dav/{site-id}/ - WebDAV access to site content")
+	log.Println("  GET|POST /orgs - List your organizations or create one")
+	log.Println("  GET|DELETE /orgs/{id} - View an organization and its members, or delete it")
+	log.Println("  PUT|DELETE /orgs/{id}/members[/{subject}] - Add, change or remove a member")
+	log.Println("  PUT /orgs/{id}/sites/{slug} - Share a site you own with an organization")
+	log.Println("  GET|POST /webhooks - List or register webhooks")
+	log.Println("  PUT /webhooks/{id} - Filter the events and sites a webhook is sent")
+	log.Println("  DELETE /webhooks/{id} - Delete a webhook")
+	log.Println("  GET /webhooks/{id}/deliveries - Delivery attempts log")
+	log.Println("  POST /webhooks/{id}/redeliver - Retry dead-lettered deliveries")
+	log.Println("  POST /webhooks/{id}/secrets/rotate - Rotate a webhook's signing secret")
+	log.Println("  GET /webhooks/{id}/secrets - Retired secrets still signing deliveries")
+	log.Println("  DELETE /webhooks/{id}/secrets/{secret-id} - End a retired secret's overlap")
+	log.Println("  GET /auth/me - Current authenticated identity")
+	log.Println("  POST /auth/register - Create a local account")
+	log.Println("  POST /auth/login - Log in with a local account")
+	log.Println("  POST /auth/logout - End the current session")
+	log.Println("  GET|POST /auth/tokens - List or create scoped API tokens")
+	log.Println("  DELETE /auth/tokens/{id} - Revoke an API token")
+	log.Println("  POST /auth/tokens/{id}/rotate - Replace an API token's secret")
+	log.Println("  GET /auth/tokens/{id}/usage - Requests, errors, bytes uploaded and last use of an API token")
+	log.Println("  POST /auth/tokens/exchange - Exchange an API token for a short-lived single-site deploy token")
+	log.Println("  GET|DELETE /auth/2fa - Two-factor status, or disable it with a current code")
+	log.Println("  POST /auth/2fa/setup - Start TOTP two-factor enrollment")
+	log.Println("  POST /auth/2fa/verify - Confirm enrollment with a code from the app")
+	if cfg.OIDCIssuer != "" {
+		log.Println("  GET /auth/oidc/login - Single sign-on via OpenID Connect")
+	}
+	if cfg.GitHubClientID != "" {
+		log.Println("  GET /auth/github - Log in with GitHub")
+	}
+	if cfg.LDAPURL != "" {
+		log.Println("  POST /auth/ldap/login - Log in with directory credentials")
+	}
+	log.Println("  GET /api/v1/info - Version, uptime, backends and features")
+	log.Println("  GET /admin/features - Feature flags and where their values come from")
+	log.Println("  GET|PUT|DELETE /admin/features/{name} - Override or reset a feature flag")
+	log.Println("  GET|PUT|DELETE /admin/tenants/{id}/upload-policy - Per-tenant upload limits and scanning")
+	log.Println("  GET /metrics - Prometheus metrics")
+	log.Println("  GET /admin/slo - SLIs and error budgets")
+	log.Println("  GET /admin/billing/usage?period=YYYY-MM - Per-tenant usage export")
+	log.Println("  GET|POST /admin/integrity - Integrity reports, or run a check now")
+	log.Println("  GET /admin/events?after=N - The event log, in order")
+	log.Println("  POST /admin/events/replay - Rebuild usage and routing state from the event log")
+	log.Println("  GET|POST /admin/service-accounts - List or create service accounts for CI")
+	log.Println("  GET|DELETE /admin/service-accounts/{id} - Show a service account's keys, or revoke it")
+	log.Println("  POST /admin/service-accounts/{id}/keys - Issue a service account another key")
+
+	// On SIGINT or SIGTERM, leave the registry before draining requests so
+	// load balancers stop sending new ones first
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	deregistered := make(chan struct{})
+	if cfg.Registry != "" {
+		inst, err := serviceInstance(cfg, listenPort)
+		if err != nil {
+			log.Fatalf("Service registration: %v", err)
+		}
+		health := func() string {
+			if routecache.Available() {
+				return discovery.StatusPassing
+			}
+			// Sites are still served from the routing cache
+			return discovery.StatusWarning
+		}
+		go func() {
+			discovery.Run(ctx, serviceRegistry(cfg), inst, cfg.RegistryTTL, health)
+			close(deregistered)
+		}()
+	} else {
+		close(deregistered)
+	}
 
-	log.Fatal(http.ListenAndServe(":8080", wrappedMux))
+	httpServer := &http.Server{Addr: fmt.Sprintf(":%d", listenPort), Handler: handler}
+	go func() {
+		<-ctx.Done()
+		<-deregistered
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-deregistered
+	log.Println("Server stopped")
 }
 
-func setupDatabase() (*sql.DB, error) {
-	// Remove existing database for fresh start (development only!)
-	// TODO: Remove this in production
-	err := os.Remove("./db/database.db")
-	if err != nil && !os.IsNotExist(err) {
-		return nil, err
+// hasFlag reports whether the server was started with flag
+func hasFlag(flag string) bool {
+	return slices.Contains(os.Args[1:], flag)
+}
+
+// enterTempDir makes a temporary directory the working directory, where
+// deployments and spooled uploads are written, and returns it. Relative log
+// and translation paths are resolved first so they still point where the
+// configuration meant.
+func enterTempDir(cfg *config.Config) (string, error) {
+	for _, p := range []*string{&cfg.LogFile, &cfg.AccessLogFile, &cfg.I18nDir} {
+		if *p == "" || filepath.IsAbs(*p) {
+			continue
+		}
+		abs, err := filepath.Abs(*p)
+		if err != nil {
+			return "", err
+		}
+		*p = abs
+	}
+	dir, err := os.MkdirTemp("", "static-site-hosting-")
+	if err != nil {
+		return "", err
+	}
+	return dir, os.Chdir(dir)
+}
+
+// listenPort is the port the server listens on
+const listenPort = 8080
+
+// serviceRegistry returns the registry configured by SERVICE_REGISTRY
+func serviceRegistry(cfg *config.Config) discovery.Registry {
+	if cfg.Registry == config.RegistryEtcd {
+		return &discovery.Etcd{Addr: cfg.RegistryAddr}
+	}
+	return &discovery.Consul{Addr: cfg.RegistryAddr, Token: cfg.RegistryToken}
+}
+
+// serviceInstance describes this node to the registry, advertising
+// SERVICE_ADDRESS or else the host name and the listen port
+func serviceInstance(cfg *config.Config, port int) (discovery.Instance, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return discovery.Instance{}, err
 	}
+	inst := discovery.Instance{Name: cfg.ServiceName, Address: hostname, Port: port, Tags: cfg.ServiceTags}
+	if cfg.ServiceAddress != "" {
+		host, portStr, err := net.SplitHostPort(cfg.ServiceAddress)
+		if err != nil {
+			return inst, fmt.Errorf("SERVICE_ADDRESS: %v", err)
+		}
+		if inst.Port, err = strconv.Atoi(portStr); err != nil {
+			return inst, fmt.Errorf("SERVICE_ADDRESS: invalid port %q", portStr)
+		}
+		inst.Address = host
+	}
+	inst.ID = cfg.ServiceID
+	if inst.ID == "" {
+		inst.ID = fmt.Sprintf("%s-%d", hostname, inst.Port)
+	}
+	return inst, nil
+}
 
-	db, err := sql.Open("sqlite3", "./db/database.db")
+// setupDatabase opens the database at databasePath, keeping what an earlier
+// run stored, and creates any tables it lacks. --ephemeral starts afresh.
+func setupDatabase() (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", databasePath)
 	if err != nil {
 		return nil, err
 	}
@@ -68,7 +462,7 @@ func setupDatabase() (*sql.DB, error) {
 	}
 
 	// Create tables
-	if err := createTables(db); err != nil {
+	if err := schema.Create(db); err != nil {
 		db.Close()
 		return nil, err
 	}
@@ -76,67 +470,173 @@ func setupDatabase() (*sql.DB, error) {
 	return db, nil
 }
 
-func createTables(db *sql.DB) error {
-	createDeploymentsTable := `
-	CREATE TABLE IF NOT EXISTS deployments (
-		id TEXT PRIMARY KEY,
-		filename TEXT NOT NULL,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		path TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	)`
-
-	if _, err := db.Exec(createDeploymentsTable); err != nil {
-		return err
+// secretsKeyring builds the keyring sealing stored secrets, or nil when no
+// key is configured
+func secretsKeyring(cfg *config.Config) (*secrets.Keyring, error) {
+	if cfg.SecretsKey == "" {
+		return nil, nil
 	}
+	current, err := secrets.ParseKey(cfg.SecretsKey)
+	if err != nil {
+		return nil, err
+	}
+	var previous [][]byte
+	for _, k := range cfg.SecretsPreviousKeys {
+		key, err := secrets.ParseKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("previous key: %w", err)
+		}
+		previous = append(previous, key)
+	}
+	return secrets.NewKeyring(current, previous...)
+}
 
-	// Keeping the example table for now
-	createExampleTable := `
-	CREATE TABLE IF NOT EXISTS example (
-		id INTEGER PRIMARY KEY, 
-		name TEXT
-	)`
+// storageCipher builds the cipher encrypting deployment files, or nil when
+// no key is configured
+func storageCipher(cfg *config.Config) (*atrest.Cipher, error) {
+	if cfg.StorageKey == "" {
+		return nil, nil
+	}
+	key, err := secrets.ParseKey(cfg.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	return atrest.NewCipher(key)
+}
 
-	if _, err := db.Exec(createExampleTable); err != nil {
+// rotateSecrets re-seals the existing database's secrets with the current
+// key. It opens the database directly, without creating any tables.
+func rotateSecrets(keyring *secrets.Keyring) error {
+	db, err := sql.Open("sqlite3", databasePath)
+	if err != nil {
 		return err
 	}
+	defer db.Close()
 
+	n, err := secrets.Rotate(db, keyring)
+	if err != nil {
+		return err
+	}
+	log.Printf("Re-encrypted %d secrets with key %s", n, keyring.ID())
 	return nil
 }
 
-func setupRoutes(db *sql.DB) *http.ServeMux {
-	mux := http.NewServeMux()
+// databasePath is the SQLite database the server opens
+const databasePath = "./db/database.db"
 
-	// API endpoints
-	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
-		handlers.UploadHandler(w, r, db)
-	})
+// validateConfig loads the configuration from the environment and checks
+// that what it points at can be used: the directories the server writes,
+// the database, the secrets key and translations. It reports every
+// problem to w, not just the first, and returns the exit status: 0 if
+// the server would start, 1 otherwise.
+func validateConfig(w io.Writer) int {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+		return 1
+	}
 
-	// Handle both list (GET) and delete all (DELETE) on /deployments
-	mux.HandleFunc("/deployments", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			handlers.ListDeploymentsHandler(w, r, db)
-		case http.MethodDelete:
-			handlers.DeleteAllDeploymentsHandler(w, r, db)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	problems := 0
+	check := func(what string, err error) {
+		if err != nil {
+			fmt.Fprintf(w, "error: %s: %v\n", what, err)
+			problems++
 		}
-	})
+	}
 
-	mux.HandleFunc("/deployments/", func(w http.ResponseWriter, r *http.Request) {
-		handlers.DeleteDeploymentHandler(w, r, db)
-	})
-	mux.HandleFunc("/rollback/", func(w http.ResponseWriter, r *http.Request) {
-		handlers.RollbackHandler(w, r, db)
-	})
-	mux.HandleFunc("/reset", func(w http.ResponseWriter, r *http.Request) {
-		handlers.ResetSystemHandler(w, r, db)
-	})
-	mux.HandleFunc("/hello-world", handlers.HelloWorldHandler)
+	_, err = secretsKeyring(cfg)
+	check("SECRETS_KEY", err)
+	_, err = storageCipher(cfg)
+	check("STORAGE_KEY", err)
+
+	check("deployments directory", writableDir("deployments"))
+	check("database directory", writableDir(filepath.Dir(databasePath)))
+	check("SPOOL_DIR", writableDir(cfg.SpoolDir))
+	if cfg.ArtifactStore != config.ArtifactStoreS3 && cfg.ArtifactStore != config.ArtifactStoreMemory {
+		check("ARTIFACT_DIR", writableDir(cfg.ArtifactDir))
+	}
+	if cfg.LogFile != "" {
+		check("LOG_FILE", writableDir(filepath.Dir(cfg.LogFile)))
+	}
+	if cfg.AccessLogFile != "" {
+		check("ACCESS_LOG_FILE", writableDir(filepath.Dir(cfg.AccessLogFile)))
+	}
+	if cfg.I18nDir != "" {
+		if info, err := os.Stat(cfg.I18nDir); err != nil {
+			check("I18N_DIR", err)
+		} else if !info.IsDir() {
+			check("I18N_DIR", fmt.Errorf("%s is not a directory", cfg.I18nDir))
+		} else {
+			check("I18N_DIR", i18n.New().LoadDir(cfg.I18nDir))
+		}
+	}
+	check("database", checkDatabase(databasePath))
 
-	// Static file serving - this should be last since it's a catch-all
-	mux.Handle("/", handlers.StaticFileHandler())
+	if cfg.AuthSecret == "" {
+		fmt.Fprintln(w, "warning: AUTH_SECRET not set; sessions will not survive a restart")
+	}
+	if problems > 0 {
+		fmt.Fprintf(w, "%d problem(s) found\n", problems)
+		return 1
+	}
+	fmt.Fprintln(w, "Configuration OK")
+	return 0
+}
 
-	return mux
+// writableDir returns why the server couldn't create files in dir, or nil.
+// A missing dir is fine if it can be created, as it is at startup.
+func writableDir(dir string) error {
+	for d := dir; ; d = filepath.Dir(d) {
+		info, err := os.Stat(d)
+		if os.IsNotExist(err) && filepath.Dir(d) != d {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", d)
+		}
+		f, err := os.CreateTemp(d, ".validate-*")
+		if err != nil {
+			return fmt.Errorf("%s is not writable: %w", d, err)
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}
+}
+
+// checkDatabase opens an existing database and checks it isn't corrupt. A
+// missing one is created at startup.
+func checkDatabase(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=rw")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA quick_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("%s failed its integrity check: %s", path, result)
+	}
+	return nil
+}
+
+// authSecret returns the configured token signing key or a random one
+func authSecret(cfg *config.Config) []byte {
+	if cfg.AuthSecret != "" {
+		return []byte(cfg.AuthSecret)
+	}
+	log.Println("Warning: AUTH_SECRET not set; sessions will not survive a restart")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("Error generating auth secret: %v", err)
+	}
+	return secret
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("AUTH_SECRET", "secret")
+
+	var out bytes.Buffer
+	if status := validateConfig(&out); status != 0 {
+		t.Fatalf("expected the defaults to validate, got %d: %s", status, out.String())
+	}
+
+	os.WriteFile("not-a-dir", nil, 0644)
+	t.Setenv("SPOOL_DIR", "not-a-dir/spool")
+	t.Setenv("I18N_DIR", "missing")
+	out.Reset()
+	if status := validateConfig(&out); status != 1 {
+		t.Fatalf("expected problems to fail validation, got %d", status)
+	}
+	for _, want := range []string{"error: SPOOL_DIR: stat not-a-dir/spool: not a directory", "error: I18N_DIR:", "2 problem(s) found"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in:\n%s", want, out.String())
+		}
+	}
+
+	t.Setenv("DEPLOY_TOKEN_TTL", "0s")
+	out.Reset()
+	if status := validateConfig(&out); status != 1 || !strings.Contains(out.String(), "DEPLOY_TOKEN_TTL") {
+		t.Errorf("expected an invalid setting to be reported, got %d: %s", status, out.String())
+	}
+}
+
+func TestSetupDatabaseKeepsData(t *testing.T) {
+	t.Chdir(t.TempDir())
+	os.MkdirAll("db", 0755)
+
+	db, err := setupDatabase()
+	if err != nil {
+		t.Fatalf("setupDatabase failed: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO deployments (id, filename, path) VALUES ('kept', 'site.zip', 'deployments/kept')"); err != nil {
+		t.Fatalf("failed to insert deployment: %v", err)
+	}
+	db.Close()
+
+	// A restart must not lose sessions, passwords or deployment records
+	db, err = setupDatabase()
+	if err != nil {
+		t.Fatalf("setupDatabase failed on restart: %v", err)
+	}
+	defer db.Close()
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM deployments WHERE id = 'kept'").Scan(&n)
+	if n != 1 {
+		t.Error("expected the deployment recorded before the restart to be kept")
+	}
+}
+
+func TestSetupDatabaseUpgradesOldSchema(t *testing.T) {
+	t.Chdir(t.TempDir())
+	os.MkdirAll("db", 0755)
+
+	// The deployments table as the first release created it
+	old, err := sql.Open("sqlite3", databasePath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = old.Exec(`CREATE TABLE deployments (
+		id TEXT PRIMARY KEY,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+		path TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err == nil {
+		_, err = old.Exec("INSERT INTO deployments (id, path) VALUES ('legacy', 'deployments/legacy')")
+	}
+	if err == nil {
+		_, err = old.Exec(`CREATE TABLE webhooks (
+		id TEXT PRIMARY KEY,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL DEFAULT '',
+		sites TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	}
+	old.Close()
+	if err != nil {
+		t.Fatalf("failed to create the old schema: %v", err)
+	}
+
+	db, err := setupDatabase()
+	if err != nil {
+		t.Fatalf("setupDatabase failed on the old schema: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec("INSERT INTO deployments (id, filename, path, site, owner_id, visibility) VALUES ('new', 'site.zip', 'deployments/new', 'docs', 'user:alice', 'private')")
+	if err != nil {
+		t.Fatalf("expected the missing columns to be added, got %v", err)
+	}
+	var status, visibility string
+	db.QueryRow("SELECT status, visibility FROM deployments WHERE id = 'legacy'").Scan(&status, &visibility)
+	if status != "ready" || visibility != "public" {
+		t.Errorf("expected the existing deployment to get the defaults, got %q and %q", status, visibility)
+	}
+	if _, err := db.Exec("INSERT INTO webhooks (id, url, secret, owner_id) VALUES ('wh', 'https://example.com', 's', 'user:alice')"); err != nil {
+		t.Errorf("expected webhooks to get an owner, got %v", err)
+	}
+}
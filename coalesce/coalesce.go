@@ -0,0 +1,54 @@
+// Package coalesce merges concurrent calls doing the same work into one,
+// as golang.org/x/sync/singleflight does: while a call for a key is in
+// flight, later callers for that key wait for it and share its result.
+package coalesce
+
+import "sync"
+
+// call is a call in flight and, once done, its result
+type call[V any] struct {
+	done  sync.WaitGroup
+	value V
+	err   error
+	dups  int
+}
+
+// Group coalesces calls by key. The zero value is ready to use.
+type Group[V any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[V]
+}
+
+// Do calls fn and returns its result, unless a call for key is already in
+// flight, in which case it waits for that call and returns its result
+// instead. shared reports whether the result went to more than one caller.
+func (g *Group[V]) Do(key string, fn func() (V, error)) (value V, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*call[V]{}
+	}
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		c.done.Wait()
+		return c.value, c.err, true
+	}
+	c := &call[V]{}
+	c.done.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	// Waiters are released even if fn panics; they see its zero result
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.done.Done()
+	}()
+	c.value, c.err = fn()
+
+	g.mu.Lock()
+	shared = c.dups > 0
+	g.mu.Unlock()
+	return c.value, c.err, shared
+}
@@ -0,0 +1,74 @@
+package coalesce
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDoCoalescesConcurrentCalls(t *testing.T) {
+	var g Group[string]
+	var calls atomic.Int32
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	const n = 10
+	var wg sync.WaitGroup
+	results := make([]string, n)
+	go func() {
+		defer close(started)
+		g.Do("key", func() (string, error) {
+			started <- struct{}{}
+			calls.Add(1)
+			<-release
+			return "value", nil
+		})
+	}()
+	<-started
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, _, _ := g.Do("key", func() (string, error) {
+				calls.Add(1)
+				return "other", nil
+			})
+			results[i] = v
+		}(i)
+	}
+	// Let the waiters register before the first call finishes
+	for {
+		g.mu.Lock()
+		dups := g.calls["key"].dups
+		g.mu.Unlock()
+		if dups == n {
+			break
+		}
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("expected a single call, got %d", calls.Load())
+	}
+	for i, v := range results {
+		if v != "value" {
+			t.Errorf("caller %d got %q", i, v)
+		}
+	}
+}
+
+func TestDoSequentialCallsAndErrors(t *testing.T) {
+	var g Group[int]
+	boom := errors.New("boom")
+	if _, err, shared := g.Do("k", func() (int, error) { return 0, boom }); err != boom || shared {
+		t.Errorf("expected the error of an unshared call, got %v, %v", err, shared)
+	}
+	// Results aren't kept once a call is done
+	if v, err, _ := g.Do("k", func() (int, error) { return 2, nil }); v != 2 || err != nil {
+		t.Errorf("expected a fresh call, got %d, %v", v, err)
+	}
+}
@@ -0,0 +1,996 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds server settings loaded from the environment
+type Config struct {
+	// Application and access log files. Empty means stderr.
+	LogFile       string
+	AccessLogFile string
+
+	// Rotation and retention for file-based logs
+	LogMaxSizeMB  int
+	LogMaxAge     time.Duration
+	LogMaxBackups int
+	LogCompress   bool
+
+	// Remote log shipping: "syslog", "loki", "http" or empty to disable
+	LogShipTarget   string
+	LogShipEndpoint string
+
+	// Traces are exported over OTLP/HTTP when OTLPEndpoint is set
+	OTLPEndpoint string
+	ServiceName  string
+
+	// Registration with a service registry, so load balancers discover
+	// every node: RegistryConsul or RegistryEtcd at RegistryAddr, or empty
+	// to disable. The instance is listed as ServiceName.
+	Registry       string
+	RegistryAddr   string
+	RegistryToken  string // Consul ACL token
+	RegistryTTL    time.Duration
+	ServiceID      string // defaults to {hostname}-{port}
+	ServiceAddress string // host:port to advertise; defaults to {hostname}:{port}
+	ServiceTags    []string
+
+	// Shared state for multi-node deployments: with RedisURL set, login
+	// lockouts and bandwidth quota usage are counted across all nodes
+	RedisURL       string
+	RedisKeyPrefix string
+
+	// SLO targets used for error budget reporting
+	SLOAvailabilityTarget float64
+	SLOLatencyTarget      float64
+
+	// Sites are always served under /s/{id}/. StaticHost additionally serves
+	// them at the root of a dedicated host, and StaticRootServing keeps the
+	// original /{id}/ URLs working alongside the API routes.
+	StaticHost        string
+	StaticRootServing bool
+
+	// Routing lookups for static serving are cached until something changes
+	// them, or at most RoutingCacheTTL for changes made by other servers, and
+	// kept serving for up to RoutingCacheMaxStale while the database is down
+	RoutingCacheTTL      time.Duration
+	RoutingCacheMaxStale time.Duration
+	// With RedisURL set, nodes also share cached lookups through Redis and
+	// see each other's changes at once rather than after RoutingCacheTTL
+	RoutingCacheShared bool
+
+	// Concurrent requests for the same static file up to this size share a
+	// single read of it; larger files are streamed to each (0 disables)
+	StaticCoalesceMaxBytes int64
+
+	// When a deployment goes live, the PrewarmFiles files of the one it
+	// replaces that were requested most are read into memory ahead of its
+	// first visitors, holding at most PrewarmMaxBytes (0 disables)
+	PrewarmFiles    int
+	PrewarmMaxBytes int64
+
+	// Static files honour Range requests so players can seek and downloads
+	// resume; RangeRequestsHTML false serves HTML whole regardless
+	RangeRequestsHTML bool
+
+	// Public addressing. PublicBaseURL is the API's external URL; empty uses
+	// the request's own host. Under SiteDomain every deployment is served at
+	// {id}.{SiteDomain} and every site's live deployment at {slug}.{SiteDomain}.
+	// CustomDomains maps extra hostnames to site slugs.
+	PublicBaseURL string
+	SiteDomain    string
+	CustomDomains map[string]string // hostname -> site slug
+
+	// PreviewNoIndex keeps deployments reached by ID, rather than through
+	// their site's host name, out of search engines. It only applies once
+	// SiteDomain or CustomDomains give sites host names of their own.
+	PreviewNoIndex bool
+
+	// Feature flag values for this environment, e.g. FEATURE_FLAGS=brotli=true.
+	// Runtime overrides made through /admin/features take precedence.
+	FeatureFlags map[string]bool
+
+	// Smallest API response body worth gzip-compressing
+	APIGzipMinBytes int
+
+	// Allow PUT/DELETE/MKCOL over WebDAV, each creating a new revision
+	WebDAVReadWrite bool
+
+	// Webhook retries: attempts before dead-lettering and the first backoff,
+	// doubled after each failure
+	WebhookMaxAttempts int
+	WebhookBackoff     time.Duration
+
+	// How long a webhook's previous signing secret keeps signing deliveries
+	// after a rotation, unless the rotation request says otherwise
+	WebhookSecretOverlap time.Duration
+
+	// Signing key and lifetime for session tokens. An empty secret means a
+	// random one is generated at startup, so sessions don't survive restarts.
+	AuthSecret string
+	SessionTTL time.Duration
+
+	// Longest lifetime of an API token, and the lifetime of those created
+	// without asking for a shorter one
+	APITokenTTL time.Duration
+
+	// Longest lifetime of a single-site deploy token exchanged for an API
+	// token, and the lifetime of those exchanged without asking for less
+	DeployTokenTTL time.Duration
+
+	// Longest lifetime of a share link to a password-protected deployment,
+	// and the lifetime of those minted without asking for less
+	ShareLinkTTL time.Duration
+
+	// Visibility of new deployments of sites without one to inherit and
+	// uploads that don't ask: "public", or "private" to serve them only to
+	// callers who may see them
+	DefaultVisibility string
+
+	// Brute-force protection: failed logins or rejected tokens allowed per
+	// client IP (and per username for LDAP) before lockouts begin at
+	// AuthLockoutBase, doubling per further failure up to AuthLockoutMax
+	AuthMaxFailures   int
+	AuthLockoutBase   time.Duration
+	AuthLockoutMax    time.Duration
+	TrustProxyHeaders bool // take the client IP from X-Forwarded-For
+
+	// API requests allowed a minute per API key or session, and per client
+	// IP for anonymous callers, in bursts of up to RateLimitBurst; 0 turns
+	// the limit off. Static files aren't limited.
+	RateLimitPerMinute          int
+	RateLimitAnonymousPerMinute int
+	RateLimitBurst              int
+
+	// Local accounts. The first account registered is an admin; after that
+	// anyone may register with UserDefaultRole if RegistrationOpen is set,
+	// otherwise only admins create accounts.
+	RegistrationOpen  bool
+	UserDefaultRole   string
+	PasswordMinLength int
+
+	// Require2FA refuses destructive admin operations, such as /reset, to
+	// callers who haven't enrolled in TOTP two-factor authentication;
+	// otherwise only enrolled callers are asked for a code
+	Require2FA bool
+
+	// Role of callers without a session. The default, empty, makes every
+	// request but logins and sites authenticate; installations without
+	// accounts opt in to viewer, deployer or admin.
+	AnonymousRole string
+
+	// Key sealing secrets stored in the database (32 bytes, base64 or hex),
+	// from SECRETS_KEY or a file such as one mounted from a KMS or secret
+	// manager. Previous keys stay readable until rotate-secrets has run.
+	SecretsKey          string
+	SecretsPreviousKeys []string
+
+	// Key encrypting deployment files on disk (32 bytes, base64 or hex),
+	// from STORAGE_KEY or a file. Unset stores them in plaintext.
+	StorageKey string
+
+	// OpenID Connect single sign-on; disabled unless OIDCIssuer is set
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       []string
+	OIDCGroupsClaim  string
+	OIDCRoleMapping  map[string]string // provider group -> role
+	OIDCDefaultRole  string
+	OIDCTenantClaim  string // claim used as the billing tenant; unset bills everyone to "default"
+
+	// LDAP / Active Directory login; disabled unless LDAPURL is set
+	LDAPURL            string
+	LDAPBindDN         string
+	LDAPBindPassword   string
+	LDAPUserBaseDN     string
+	LDAPUserFilter     string
+	LDAPGroupAttribute string
+	LDAPRoleMapping    map[string]string // group CN or DN -> role
+	LDAPDefaultRole    string
+
+	// GitHub OAuth login; disabled unless GitHubClientID is set. GitHub
+	// users get local accounts; GitHubAllowedOrgs, when set, restricts
+	// logins to their members and lets them create accounts even while
+	// registration is closed.
+	GitHubClientID     string
+	GitHubClientSecret string
+	GitHubRedirectURL  string
+	GitHubURL          string // GitHub Enterprise Server URL; empty for github.com
+	GitHubAPIURL       string
+	GitHubAllowedOrgs  []string
+
+	// Archive extraction: number of concurrent writers (0 uses one per CPU)
+	// and whether extracted files are fsynced before the deployment is live
+	ExtractWorkers int
+	ExtractFsync   bool
+
+	// What to do with archive entries that would be written outside the
+	// deployment: UnsafeEntriesReject or UnsafeEntriesSkip
+	UnsafeEntries string
+
+	// Patterns of secret files (.env, .git/, *.pem and the like) that are
+	// extracted and served anyway; see handlers.secretFilePatterns
+	SecretFilesAllow []string
+
+	// Upload archives are spooled and imports staged here before they are
+	// extracted or moved into deployments. On the same filesystem as
+	// deployments, staged sites move into place with a rename.
+	SpoolDir string
+
+	// Directory of API error message translations, one {lang}.json per
+	// language, added to the built-in German, French and Spanish ones
+	I18nDir string
+
+	// Deployment trees are always made read-only once created; this also
+	// sets the filesystem immutable attribute (chattr +i) on them
+	ImmutableChattr bool
+
+	// What to do when an archive identical to one already deployed to the
+	// same site is uploaded: DuplicateReuse, DuplicateAlias or DuplicateOff
+	DuplicateUploads string
+
+	// How deployment IDs are assigned: DeploymentIDsRandom, or
+	// DeploymentIDsContent to derive them from the site and archive hash
+	// (or take a validated ID from the client), making redeploys idempotent
+	DeploymentIDs string
+
+	// Global upload policy: largest request body (0 for no limit), file
+	// extensions a deployment may contain (empty allows all) and whether
+	// files are virus scanned with the ClamAV daemon at ClamdAddr. Tenants
+	// can be given their own values through /admin/tenants/{id}/upload-policy.
+	UploadMaxBytes     int64
+	UploadAllowedTypes []string
+	UploadScan         bool
+	ClamdAddr          string
+
+	// Bytes of a multipart upload held in memory; the rest is spooled to
+	// temporary files
+	UploadMemoryBytes int64
+
+	// Shared secret uploads to /upload must be signed with, so they are
+	// authenticated even over plain HTTP; empty accepts unsigned uploads.
+	// Signatures older than UploadSignatureMaxAge are refused.
+	UploadSigningSecret   string
+	UploadSignatureMaxAge time.Duration
+
+	// Original upload archives kept next to each deployment so they can be
+	// downloaded, verified or extracted again: ArtifactStoreLocal under
+	// ArtifactDir, ArtifactStoreS3 in a bucket, ArtifactStoreMemory until
+	// the process exits, or empty to discard them.
+	// S3 credentials come from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+	ArtifactStore      string
+	ArtifactDir        string
+	ArtifactS3Bucket   string
+	ArtifactS3Region   string
+	ArtifactS3Endpoint string // empty uses AWS; set for MinIO and other S3-compatible stores
+	ArtifactS3Prefix   string
+	AWSAccessKeyID     string
+	AWSSecretAccessKey string
+
+	// Pre-deploy validation run on every extracted upload. A deployment
+	// failing any check is marked failed instead of going live.
+	ValidateRequiredFiles     []string
+	ValidateHTML              bool
+	ValidateForbiddenPatterns []string // regular expressions
+	ValidateMaxScanBytes      int64    // larger files are not scanned for patterns
+
+	// Post-deploy smoke test: paths requested from a new deployment, each of
+	// which must answer 200 before it is marked ready
+	SmokeTest      bool
+	SmokeTestPaths []string
+
+	// Deployment retention; deployments are never deleted automatically
+	// unless RetentionMaxAge is set
+	RetentionMaxAge  time.Duration
+	RetentionWarning time.Duration
+
+	// Sites given an expiry date answer with SiteExpiredPage (or a built-in
+	// page) once it passes, and are deleted SiteExpiryGrace later
+	SiteExpiryGrace time.Duration
+	SiteExpiredPage string
+
+	// Outgoing mail for notifications; disabled unless SMTPAddr is set
+	SMTPAddr           string
+	SMTPUsername       string
+	SMTPPassword       string
+	SMTPFrom           string
+	ExpiryNotifyEmails []string
+
+	// Nightly integrity check of deployment files against the manifests
+	// recorded at deploy time; disabled unless IntegrityCheckHour is set
+	IntegrityCheckHour     int // UTC, 0-23
+	IntegritySamplePercent int // share of each deployment's files hashed
+	IntegrityNotifyEmails  []string
+
+	// Per-tenant quotas, 0 for none. They aren't enforced: crossing one of
+	// QuotaWarnPercents adds X-Quota-Warning to the tenant's API responses
+	// and sends a quota.warning webhook and email once per threshold.
+	StorageQuotaBytes   int64
+	BandwidthQuotaBytes int64 // per calendar month
+	QuotaWarnPercents   []int
+	QuotaCheckInterval  time.Duration
+	QuotaNotifyEmails   []string
+}
+
+// Service registries
+const (
+	RegistryConsul = "consul"
+	RegistryEtcd   = "etcd"
+)
+
+// Duplicate upload handling modes
+const (
+	DuplicateReuse = "reuse" // return the existing deployment
+	DuplicateAlias = "alias" // record a new deployment sharing the existing files
+	DuplicateOff   = "off"   // always extract a new copy
+)
+
+// Unsafe archive entry handling modes
+const (
+	UnsafeEntriesReject = "reject" // fail the whole extraction
+	UnsafeEntriesSkip   = "skip"   // leave the entry out and report it
+)
+
+// Deployment ID modes
+const (
+	DeploymentIDsRandom  = "random"  // a new UUID per upload
+	DeploymentIDsContent = "content" // derived from the content, or supplied by the client
+)
+
+// Artifact stores
+const (
+	ArtifactStoreLocal  = "local"
+	ArtifactStoreS3     = "s3"
+	ArtifactStoreMemory = "memory"
+)
+
+// Default returns the configuration used when nothing is set
+func Default() *Config {
+	return &Config{
+		LogMaxSizeMB:  100,
+		LogMaxAge:     30 * 24 * time.Hour,
+		LogMaxBackups: 10,
+		LogCompress:   true,
+
+		ServiceName: "static-site-hosting",
+		RegistryTTL: 15 * time.Second,
+
+		RedisKeyPrefix: "static-site-hosting:",
+
+		SLOAvailabilityTarget: 0.999,
+		SLOLatencyTarget:      0.95,
+
+		StaticRootServing:      true,
+		StaticCoalesceMaxBytes: 1 << 20,
+		PrewarmMaxBytes:        64 << 20,
+		UploadMemoryBytes:      20 << 20,
+		RangeRequestsHTML:      true,
+
+		RoutingCacheTTL:      time.Minute,
+		RoutingCacheMaxStale: 24 * time.Hour,
+		RoutingCacheShared:   true,
+
+		PreviewNoIndex: true,
+
+		APIGzipMinBytes: 1024,
+
+		WebhookMaxAttempts:   8,
+		WebhookBackoff:       30 * time.Second,
+		WebhookSecretOverlap: 24 * time.Hour,
+
+		SessionTTL:     12 * time.Hour,
+		APITokenTTL:    90 * 24 * time.Hour,
+		DeployTokenTTL: 15 * time.Minute,
+		ShareLinkTTL:   7 * 24 * time.Hour,
+
+		DefaultVisibility: "public",
+
+		AuthMaxFailures: 5,
+		AuthLockoutBase: time.Second,
+		AuthLockoutMax:  15 * time.Minute,
+
+		RateLimitPerMinute:          600,
+		RateLimitAnonymousPerMinute: 120,
+		RateLimitBurst:              60,
+
+		UserDefaultRole:   "deployer",
+		PasswordMinLength: 8,
+
+		OIDCGroupsClaim: "groups",
+		OIDCDefaultRole: "viewer",
+
+		LDAPUserFilter:     "(uid=%s)",
+		LDAPGroupAttribute: "memberOf",
+		LDAPDefaultRole:    "viewer",
+
+		ExtractFsync:  true,
+		UnsafeEntries: UnsafeEntriesReject,
+		SpoolDir:      ".",
+
+		DuplicateUploads: DuplicateReuse,
+		DeploymentIDs:    DeploymentIDsRandom,
+
+		UploadSignatureMaxAge: 5 * time.Minute,
+
+		ArtifactDir:      "artifacts",
+		ArtifactS3Region: "us-east-1",
+
+		ValidateMaxScanBytes: 5 * 1024 * 1024,
+
+		SmokeTestPaths: []string{"/index.html"},
+
+		RetentionWarning: 7 * 24 * time.Hour,
+		SiteExpiryGrace:  7 * 24 * time.Hour,
+
+		IntegrityCheckHour:     -1,
+		IntegritySamplePercent: 100,
+
+		QuotaWarnPercents:  []int{80, 90},
+		QuotaCheckInterval: 10 * time.Minute,
+	}
+}
+
+// Load reads configuration from environment variables on top of the defaults
+func Load() (*Config, error) {
+	c := Default()
+	var err error
+
+	c.LogFile = os.Getenv("LOG_FILE")
+	c.AccessLogFile = os.Getenv("ACCESS_LOG_FILE")
+
+	if c.LogMaxSizeMB, err = envInt("LOG_MAX_SIZE_MB", c.LogMaxSizeMB); err != nil {
+		return nil, err
+	}
+	if c.LogMaxAge, err = envDays("LOG_MAX_AGE_DAYS", c.LogMaxAge); err != nil {
+		return nil, err
+	}
+	if c.LogMaxBackups, err = envInt("LOG_MAX_BACKUPS", c.LogMaxBackups); err != nil {
+		return nil, err
+	}
+	if c.LogCompress, err = envBool("LOG_COMPRESS", c.LogCompress); err != nil {
+		return nil, err
+	}
+
+	c.LogShipTarget = os.Getenv("LOG_SHIP_TARGET")
+	c.LogShipEndpoint = os.Getenv("LOG_SHIP_ENDPOINT")
+
+	c.OTLPEndpoint = strings.TrimSuffix(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "/")
+	if v := os.Getenv("SERVICE_NAME"); v != "" {
+		c.ServiceName = v
+	}
+	if v := os.Getenv("OTEL_SERVICE_NAME"); v != "" {
+		c.ServiceName = v
+	}
+
+	c.Registry = os.Getenv("SERVICE_REGISTRY")
+	c.RegistryAddr = strings.TrimSuffix(os.Getenv("SERVICE_REGISTRY_ADDR"), "/")
+	c.RegistryToken = os.Getenv("SERVICE_REGISTRY_TOKEN")
+	switch c.Registry {
+	case "":
+	case RegistryConsul:
+		if c.RegistryAddr == "" {
+			c.RegistryAddr = "http://127.0.0.1:8500"
+		}
+	case RegistryEtcd:
+		if c.RegistryAddr == "" {
+			c.RegistryAddr = "http://127.0.0.1:2379"
+		}
+	default:
+		return nil, fmt.Errorf("SERVICE_REGISTRY: must be %s or %s", RegistryConsul, RegistryEtcd)
+	}
+	if c.RegistryTTL, err = envDuration("SERVICE_REGISTRY_TTL", c.RegistryTTL); err != nil {
+		return nil, err
+	}
+	if c.RegistryTTL < 3*time.Second {
+		return nil, fmt.Errorf("SERVICE_REGISTRY_TTL must be at least 3s")
+	}
+	c.ServiceID = os.Getenv("SERVICE_ID")
+	c.ServiceAddress = os.Getenv("SERVICE_ADDRESS")
+	c.ServiceTags = envList("SERVICE_TAGS")
+
+	c.RedisURL = os.Getenv("REDIS_URL")
+	if v := os.Getenv("REDIS_KEY_PREFIX"); v != "" {
+		c.RedisKeyPrefix = v
+	}
+
+	if c.SLOAvailabilityTarget, err = envFloat("SLO_AVAILABILITY_TARGET", c.SLOAvailabilityTarget); err != nil {
+		return nil, err
+	}
+	if c.SLOLatencyTarget, err = envFloat("SLO_LATENCY_TARGET", c.SLOLatencyTarget); err != nil {
+		return nil, err
+	}
+
+	c.StaticHost = os.Getenv("STATIC_HOST")
+	if c.StaticRootServing, err = envBool("STATIC_ROOT_SERVING", c.StaticRootServing); err != nil {
+		return nil, err
+	}
+	if c.RoutingCacheTTL, err = envDuration("ROUTING_CACHE_TTL", c.RoutingCacheTTL); err != nil {
+		return nil, err
+	}
+	coalesceMax, err := envInt("STATIC_COALESCE_MAX_BYTES", int(c.StaticCoalesceMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+	c.StaticCoalesceMaxBytes = int64(coalesceMax)
+	if c.PrewarmFiles, err = envInt("PREWARM_FILES", c.PrewarmFiles); err != nil {
+		return nil, err
+	}
+	prewarmMax, err := envInt("PREWARM_MAX_BYTES", int(c.PrewarmMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+	c.PrewarmMaxBytes = int64(prewarmMax)
+	if c.RangeRequestsHTML, err = envBool("RANGE_REQUESTS_HTML", c.RangeRequestsHTML); err != nil {
+		return nil, err
+	}
+	if c.RoutingCacheMaxStale, err = envDuration("ROUTING_CACHE_MAX_STALE", c.RoutingCacheMaxStale); err != nil {
+		return nil, err
+	}
+	if c.RoutingCacheShared, err = envBool("ROUTING_CACHE_SHARED", c.RoutingCacheShared); err != nil {
+		return nil, err
+	}
+	c.PublicBaseURL = strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/")
+	c.SiteDomain = strings.ToLower(os.Getenv("SITE_DOMAIN"))
+	if c.CustomDomains, err = envMap("SITE_CUSTOM_DOMAINS"); err != nil {
+		return nil, err
+	}
+	if c.PreviewNoIndex, err = envBool("PREVIEW_NOINDEX", c.PreviewNoIndex); err != nil {
+		return nil, err
+	}
+
+	flags, err := envMap("FEATURE_FLAGS")
+	if err != nil {
+		return nil, err
+	}
+	c.FeatureFlags = map[string]bool{}
+	for name, v := range flags {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("FEATURE_FLAGS: invalid boolean %q for %s", v, name)
+		}
+		c.FeatureFlags[name] = b
+	}
+
+	if c.APIGzipMinBytes, err = envInt("API_GZIP_MIN_BYTES", c.APIGzipMinBytes); err != nil {
+		return nil, err
+	}
+
+	if c.WebDAVReadWrite, err = envBool("WEBDAV_READ_WRITE", c.WebDAVReadWrite); err != nil {
+		return nil, err
+	}
+
+	if c.WebhookMaxAttempts, err = envInt("WEBHOOK_MAX_ATTEMPTS", c.WebhookMaxAttempts); err != nil {
+		return nil, err
+	}
+	if c.WebhookBackoff, err = envDuration("WEBHOOK_BACKOFF", c.WebhookBackoff); err != nil {
+		return nil, err
+	}
+	if c.WebhookSecretOverlap, err = envDuration("WEBHOOK_SECRET_OVERLAP", c.WebhookSecretOverlap); err != nil {
+		return nil, err
+	}
+
+	c.AuthSecret = os.Getenv("AUTH_SECRET")
+	if c.SessionTTL, err = envDuration("SESSION_TTL", c.SessionTTL); err != nil {
+		return nil, err
+	}
+	if c.APITokenTTL, err = envDuration("API_TOKEN_TTL", c.APITokenTTL); err != nil {
+		return nil, err
+	}
+	if c.APITokenTTL <= 0 {
+		return nil, fmt.Errorf("API_TOKEN_TTL must be positive")
+	}
+	if c.DeployTokenTTL, err = envDuration("DEPLOY_TOKEN_TTL", c.DeployTokenTTL); err != nil {
+		return nil, err
+	}
+	if c.DeployTokenTTL <= 0 {
+		return nil, fmt.Errorf("DEPLOY_TOKEN_TTL must be positive")
+	}
+	if c.ShareLinkTTL, err = envDuration("SHARE_LINK_TTL", c.ShareLinkTTL); err != nil {
+		return nil, err
+	}
+	if c.ShareLinkTTL <= 0 {
+		return nil, fmt.Errorf("SHARE_LINK_TTL must be positive")
+	}
+	switch v := os.Getenv("DEFAULT_VISIBILITY"); v {
+	case "":
+	case "public", "private":
+		c.DefaultVisibility = v
+	default:
+		return nil, fmt.Errorf("DEFAULT_VISIBILITY must be public or private")
+	}
+	if c.AuthMaxFailures, err = envInt("AUTH_MAX_FAILURES", c.AuthMaxFailures); err != nil {
+		return nil, err
+	}
+	if c.AuthLockoutBase, err = envDuration("AUTH_LOCKOUT_BASE", c.AuthLockoutBase); err != nil {
+		return nil, err
+	}
+	if c.AuthLockoutMax, err = envDuration("AUTH_LOCKOUT_MAX", c.AuthLockoutMax); err != nil {
+		return nil, err
+	}
+	if c.TrustProxyHeaders, err = envBool("TRUST_PROXY_HEADERS", c.TrustProxyHeaders); err != nil {
+		return nil, err
+	}
+	if c.RateLimitPerMinute, err = envInt("RATE_LIMIT_PER_MINUTE", c.RateLimitPerMinute); err != nil {
+		return nil, err
+	}
+	if c.RateLimitAnonymousPerMinute, err = envInt("RATE_LIMIT_ANONYMOUS_PER_MINUTE", c.RateLimitAnonymousPerMinute); err != nil {
+		return nil, err
+	}
+	if c.RateLimitBurst, err = envInt("RATE_LIMIT_BURST", c.RateLimitBurst); err != nil {
+		return nil, err
+	}
+	if c.RegistrationOpen, err = envBool("REGISTRATION_OPEN", c.RegistrationOpen); err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("USER_DEFAULT_ROLE"); v != "" {
+		c.UserDefaultRole = v
+	}
+	if c.PasswordMinLength, err = envInt("PASSWORD_MIN_LENGTH", c.PasswordMinLength); err != nil {
+		return nil, err
+	}
+	if c.PasswordMinLength < 1 {
+		return nil, fmt.Errorf("PASSWORD_MIN_LENGTH must be at least 1")
+	}
+	if c.Require2FA, err = envBool("REQUIRE_2FA", c.Require2FA); err != nil {
+		return nil, err
+	}
+	switch v := os.Getenv("ANONYMOUS_ROLE"); v {
+	case "":
+	case "none":
+		c.AnonymousRole = ""
+	case "admin", "deployer", "viewer":
+		c.AnonymousRole = v
+	default:
+		return nil, fmt.Errorf("ANONYMOUS_ROLE must be admin, deployer, viewer or none")
+	}
+
+	c.SecretsKey = os.Getenv("SECRETS_KEY")
+	if path := os.Getenv("SECRETS_KEY_FILE"); path != "" {
+		key, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("SECRETS_KEY_FILE: %w", err)
+		}
+		c.SecretsKey = strings.TrimSpace(string(key))
+	}
+	c.SecretsPreviousKeys = envList("SECRETS_PREVIOUS_KEYS")
+	if len(c.SecretsPreviousKeys) > 0 && c.SecretsKey == "" {
+		return nil, fmt.Errorf("SECRETS_PREVIOUS_KEYS requires SECRETS_KEY")
+	}
+
+	c.StorageKey = os.Getenv("STORAGE_KEY")
+	if path := os.Getenv("STORAGE_KEY_FILE"); path != "" {
+		key, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("STORAGE_KEY_FILE: %w", err)
+		}
+		c.StorageKey = strings.TrimSpace(string(key))
+	}
+
+	c.OIDCIssuer = os.Getenv("OIDC_ISSUER")
+	c.OIDCClientID = os.Getenv("OIDC_CLIENT_ID")
+	c.OIDCClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	c.OIDCRedirectURL = os.Getenv("OIDC_REDIRECT_URL")
+	c.OIDCScopes = envList("OIDC_SCOPES")
+	if v := os.Getenv("OIDC_GROUPS_CLAIM"); v != "" {
+		c.OIDCGroupsClaim = v
+	}
+	if c.OIDCRoleMapping, err = envMap("OIDC_ROLE_MAPPING"); err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("OIDC_DEFAULT_ROLE"); v != "" {
+		c.OIDCDefaultRole = v
+	}
+	c.OIDCTenantClaim = os.Getenv("OIDC_TENANT_CLAIM")
+	if c.OIDCIssuer != "" && (c.OIDCClientID == "" || c.OIDCRedirectURL == "") {
+		return nil, fmt.Errorf("OIDC_ISSUER requires OIDC_CLIENT_ID and OIDC_REDIRECT_URL")
+	}
+
+	c.LDAPURL = os.Getenv("LDAP_URL")
+	c.LDAPBindDN = os.Getenv("LDAP_BIND_DN")
+	c.LDAPBindPassword = os.Getenv("LDAP_BIND_PASSWORD")
+	c.LDAPUserBaseDN = os.Getenv("LDAP_USER_BASE_DN")
+	if v := os.Getenv("LDAP_USER_FILTER"); v != "" {
+		c.LDAPUserFilter = v
+	}
+	if v := os.Getenv("LDAP_GROUP_ATTRIBUTE"); v != "" {
+		c.LDAPGroupAttribute = v
+	}
+	if c.LDAPRoleMapping, err = envMap("LDAP_ROLE_MAPPING"); err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("LDAP_DEFAULT_ROLE"); v != "" {
+		c.LDAPDefaultRole = v
+	}
+	if c.LDAPURL != "" && c.LDAPUserBaseDN == "" {
+		return nil, fmt.Errorf("LDAP_URL requires LDAP_USER_BASE_DN")
+	}
+
+	c.GitHubClientID = os.Getenv("GITHUB_CLIENT_ID")
+	c.GitHubClientSecret = os.Getenv("GITHUB_CLIENT_SECRET")
+	c.GitHubRedirectURL = os.Getenv("GITHUB_REDIRECT_URL")
+	c.GitHubURL = os.Getenv("GITHUB_URL")
+	c.GitHubAPIURL = os.Getenv("GITHUB_API_URL")
+	c.GitHubAllowedOrgs = envList("GITHUB_ALLOWED_ORGS")
+	if c.GitHubClientID != "" && (c.GitHubClientSecret == "" || c.GitHubRedirectURL == "") {
+		return nil, fmt.Errorf("GITHUB_CLIENT_ID requires GITHUB_CLIENT_SECRET and GITHUB_REDIRECT_URL")
+	}
+
+	if c.ExtractWorkers, err = envInt("EXTRACT_WORKERS", c.ExtractWorkers); err != nil {
+		return nil, err
+	}
+	if c.ExtractFsync, err = envBool("EXTRACT_FSYNC", c.ExtractFsync); err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("UNSAFE_ARCHIVE_ENTRIES"); v != "" {
+		switch v {
+		case UnsafeEntriesReject, UnsafeEntriesSkip:
+			c.UnsafeEntries = v
+		default:
+			return nil, fmt.Errorf("UNSAFE_ARCHIVE_ENTRIES: must be %s or %s", UnsafeEntriesReject, UnsafeEntriesSkip)
+		}
+	}
+	c.SecretFilesAllow = envList("SECRET_FILES_ALLOW")
+	for _, p := range c.SecretFilesAllow {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("SECRET_FILES_ALLOW: invalid pattern %q", p)
+		}
+	}
+	if v := os.Getenv("SPOOL_DIR"); v != "" {
+		c.SpoolDir = v
+	}
+	c.I18nDir = os.Getenv("I18N_DIR")
+	if c.ImmutableChattr, err = envBool("IMMUTABLE_CHATTR", c.ImmutableChattr); err != nil {
+		return nil, err
+	}
+
+	if v := os.Getenv("DUPLICATE_UPLOADS"); v != "" {
+		switch v {
+		case DuplicateReuse, DuplicateAlias, DuplicateOff:
+			c.DuplicateUploads = v
+		default:
+			return nil, fmt.Errorf("DUPLICATE_UPLOADS: must be %s, %s or %s", DuplicateReuse, DuplicateAlias, DuplicateOff)
+		}
+	}
+
+	if v := os.Getenv("DEPLOYMENT_IDS"); v != "" {
+		switch v {
+		case DeploymentIDsRandom, DeploymentIDsContent:
+			c.DeploymentIDs = v
+		default:
+			return nil, fmt.Errorf("DEPLOYMENT_IDS: must be %s or %s", DeploymentIDsRandom, DeploymentIDsContent)
+		}
+	}
+
+	maxBytes, err := envInt("UPLOAD_MAX_BYTES", int(c.UploadMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+	c.UploadMaxBytes = int64(maxBytes)
+	memoryBytes, err := envInt("UPLOAD_MEMORY_BYTES", int(c.UploadMemoryBytes))
+	if err != nil {
+		return nil, err
+	}
+	if memoryBytes <= 0 {
+		return nil, fmt.Errorf("UPLOAD_MEMORY_BYTES must be positive")
+	}
+	c.UploadMemoryBytes = int64(memoryBytes)
+	c.UploadAllowedTypes = envList("UPLOAD_ALLOWED_TYPES")
+	if c.UploadScan, err = envBool("UPLOAD_SCAN", c.UploadScan); err != nil {
+		return nil, err
+	}
+	c.ClamdAddr = os.Getenv("CLAMD_ADDR")
+	c.UploadSigningSecret = os.Getenv("UPLOAD_SIGNING_SECRET")
+	if c.UploadSignatureMaxAge, err = envDuration("UPLOAD_SIGNATURE_MAX_AGE", c.UploadSignatureMaxAge); err != nil {
+		return nil, err
+	}
+
+	c.ArtifactStore = os.Getenv("ARTIFACT_STORE")
+	if v := os.Getenv("ARTIFACT_DIR"); v != "" {
+		c.ArtifactDir = v
+	}
+	c.ArtifactS3Bucket = os.Getenv("ARTIFACT_S3_BUCKET")
+	if v := os.Getenv("ARTIFACT_S3_REGION"); v != "" {
+		c.ArtifactS3Region = v
+	}
+	c.ArtifactS3Endpoint = strings.TrimSuffix(os.Getenv("ARTIFACT_S3_ENDPOINT"), "/")
+	c.ArtifactS3Prefix = os.Getenv("ARTIFACT_S3_PREFIX")
+	c.AWSAccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	c.AWSSecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	switch c.ArtifactStore {
+	case "", ArtifactStoreLocal, ArtifactStoreMemory:
+	case ArtifactStoreS3:
+		if c.ArtifactS3Bucket == "" || c.AWSAccessKeyID == "" || c.AWSSecretAccessKey == "" {
+			return nil, fmt.Errorf("ARTIFACT_STORE=s3 requires ARTIFACT_S3_BUCKET, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+	default:
+		return nil, fmt.Errorf("ARTIFACT_STORE: must be %s, %s or %s", ArtifactStoreLocal, ArtifactStoreS3, ArtifactStoreMemory)
+	}
+
+	c.ValidateRequiredFiles = envList("VALIDATE_REQUIRED_FILES")
+	if c.ValidateHTML, err = envBool("VALIDATE_HTML", c.ValidateHTML); err != nil {
+		return nil, err
+	}
+	// Patterns may contain commas, so they are given as a JSON array
+	if v := os.Getenv("VALIDATE_FORBIDDEN_PATTERNS"); v != "" {
+		if err := json.Unmarshal([]byte(v), &c.ValidateForbiddenPatterns); err != nil {
+			return nil, fmt.Errorf("VALIDATE_FORBIDDEN_PATTERNS: expected a JSON array of strings: %v", err)
+		}
+		for _, p := range c.ValidateForbiddenPatterns {
+			if _, err := regexp.Compile(p); err != nil {
+				return nil, fmt.Errorf("VALIDATE_FORBIDDEN_PATTERNS: %v", err)
+			}
+		}
+	}
+	maxScan, err := envInt("VALIDATE_MAX_SCAN_BYTES", int(c.ValidateMaxScanBytes))
+	if err != nil {
+		return nil, err
+	}
+	c.ValidateMaxScanBytes = int64(maxScan)
+
+	if c.SmokeTest, err = envBool("SMOKE_TEST", c.SmokeTest); err != nil {
+		return nil, err
+	}
+	if paths := envList("SMOKE_TEST_PATHS"); paths != nil {
+		c.SmokeTestPaths = paths
+	}
+
+	if c.RetentionMaxAge, err = envDays("RETENTION_MAX_AGE_DAYS", c.RetentionMaxAge); err != nil {
+		return nil, err
+	}
+	if c.RetentionWarning, err = envDays("RETENTION_WARNING_DAYS", c.RetentionWarning); err != nil {
+		return nil, err
+	}
+	if c.SiteExpiryGrace, err = envDays("SITE_EXPIRY_GRACE_DAYS", c.SiteExpiryGrace); err != nil {
+		return nil, err
+	}
+	c.SiteExpiredPage = os.Getenv("SITE_EXPIRED_PAGE")
+
+	c.SMTPAddr = os.Getenv("SMTP_ADDR")
+	c.SMTPUsername = os.Getenv("SMTP_USERNAME")
+	c.SMTPPassword = os.Getenv("SMTP_PASSWORD")
+	c.SMTPFrom = os.Getenv("SMTP_FROM")
+	c.ExpiryNotifyEmails = envList("EXPIRY_NOTIFY_EMAILS")
+
+	if c.IntegrityCheckHour, err = envInt("INTEGRITY_CHECK_HOUR", c.IntegrityCheckHour); err != nil {
+		return nil, err
+	}
+	if c.IntegrityCheckHour < -1 || c.IntegrityCheckHour > 23 {
+		return nil, fmt.Errorf("INTEGRITY_CHECK_HOUR must be an hour from 0 to 23")
+	}
+	if c.IntegritySamplePercent, err = envInt("INTEGRITY_SAMPLE_PERCENT", c.IntegritySamplePercent); err != nil {
+		return nil, err
+	}
+	if c.IntegritySamplePercent < 1 || c.IntegritySamplePercent > 100 {
+		return nil, fmt.Errorf("INTEGRITY_SAMPLE_PERCENT must be from 1 to 100")
+	}
+	c.IntegrityNotifyEmails = envList("INTEGRITY_NOTIFY_EMAILS")
+
+	storageQuota, err := envInt("STORAGE_QUOTA_BYTES", int(c.StorageQuotaBytes))
+	if err != nil {
+		return nil, err
+	}
+	c.StorageQuotaBytes = int64(storageQuota)
+	bandwidthQuota, err := envInt("BANDWIDTH_QUOTA_BYTES", int(c.BandwidthQuotaBytes))
+	if err != nil {
+		return nil, err
+	}
+	c.BandwidthQuotaBytes = int64(bandwidthQuota)
+	if c.StorageQuotaBytes < 0 || c.BandwidthQuotaBytes < 0 {
+		return nil, fmt.Errorf("quotas must not be negative")
+	}
+	if v := envList("QUOTA_WARN_PERCENTS"); v != nil {
+		c.QuotaWarnPercents = nil
+		for _, p := range v {
+			n, err := strconv.Atoi(p)
+			if err != nil || n < 1 || n > 100 {
+				return nil, fmt.Errorf("QUOTA_WARN_PERCENTS: %q is not a percentage from 1 to 100", p)
+			}
+			c.QuotaWarnPercents = append(c.QuotaWarnPercents, n)
+		}
+	}
+	if c.QuotaCheckInterval, err = envDuration("QUOTA_CHECK_INTERVAL", c.QuotaCheckInterval); err != nil {
+		return nil, err
+	}
+	if c.QuotaCheckInterval <= 0 {
+		return nil, fmt.Errorf("QUOTA_CHECK_INTERVAL must be positive")
+	}
+	c.QuotaNotifyEmails = envList("QUOTA_NOTIFY_EMAILS")
+
+	return c, nil
+}
+
+func envInt(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid integer %q", key, v)
+	}
+	return n, nil
+}
+
+func envFloat(key string, def float64) (float64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid number %q", key, v)
+	}
+	return f, nil
+}
+
+func envBool(key string, def bool) (bool, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s: invalid boolean %q", key, v)
+	}
+	return b, nil
+}
+
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid duration %q", key, v)
+	}
+	return d, nil
+}
+
+// envDays parses a whole number of days into a duration
+func envDays(key string, def time.Duration) (time.Duration, error) {
+	n, err := envInt(key, -1)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return def, nil
+	}
+	return time.Duration(n) * 24 * time.Hour, nil
+}
+
+func envList(key string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// envMap parses "a=x,b=y" into a map
+func envMap(key string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, pair := range envList(key) {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("%s: expected key=value, got %q", key, pair)
+		}
+		out[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return out, nil
+}
@@ -0,0 +1,89 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Consul registers with the local Consul agent's HTTP API. The service
+// gets a TTL check that Heartbeat keeps updating; Consul itself removes
+// the service once the check has been critical for a minute.
+type Consul struct {
+	Addr   string // agent URL, such as http://127.0.0.1:8500
+	Token  string // ACL token, if the agent requires one
+	Client *http.Client
+}
+
+func (c *Consul) Register(ctx context.Context, inst Instance, ttl time.Duration) error {
+	return c.put(ctx, "/v1/agent/service/register", map[string]any{
+		"ID":      inst.ID,
+		"Name":    inst.Name,
+		"Address": inst.Address,
+		"Port":    inst.Port,
+		"Tags":    inst.Tags,
+		"Check": map[string]any{
+			"CheckID":                        checkID(inst),
+			"TTL":                            ttl.String(),
+			"DeregisterCriticalServiceAfter": "1m",
+		},
+	})
+}
+
+func (c *Consul) Heartbeat(ctx context.Context, inst Instance, status string) error {
+	return c.put(ctx, "/v1/agent/check/update/"+url.PathEscape(checkID(inst)), map[string]any{
+		"Status": status,
+		"Output": "Reported by " + inst.ID,
+	})
+}
+
+func (c *Consul) Deregister(ctx context.Context, inst Instance) error {
+	return c.put(ctx, "/v1/agent/service/deregister/"+url.PathEscape(inst.ID), nil)
+}
+
+// checkID is the ID Consul gives a service's own check
+func checkID(inst Instance) string {
+	return "service:" + inst.ID
+}
+
+func (c *Consul) put(ctx context.Context, path string, body any) error {
+	var payload io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.Addr+path, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("X-Consul-Token", c.Token)
+	}
+	resp, err := client(c.Client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("consul %s: %s: %s", path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// client returns c, or a client with a sensible timeout when c is nil
+func client(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
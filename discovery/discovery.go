@@ -0,0 +1,85 @@
+// Package discovery registers the server with a service registry, Consul
+// or etcd, so load balancers in front of several nodes find it without
+// configuration. Registrations carry a TTL that the server keeps renewing
+// with its health: a node that dies without deregistering drops out on
+// its own.
+package discovery
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Health statuses, as Consul names them
+const (
+	StatusPassing  = "passing"
+	StatusWarning  = "warning" // serving, but degraded
+	StatusCritical = "critical"
+)
+
+// Instance is this server as the registry lists it
+type Instance struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Address string   `json:"address"`
+	Port    int      `json:"port"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+// Registry is a service registry the server can list itself in
+type Registry interface {
+	// Register lists inst, to be dropped if not renewed within ttl
+	Register(ctx context.Context, inst Instance, ttl time.Duration) error
+	// Heartbeat renews the registration of inst with its current status
+	Heartbeat(ctx context.Context, inst Instance, status string) error
+	// Deregister removes inst
+	Deregister(ctx context.Context, inst Instance) error
+}
+
+// Run registers inst and renews the registration every third of ttl with
+// the status health reports, until ctx is cancelled; then it deregisters
+// and returns. Failures are logged and retried at the next renewal, so an
+// unavailable registry never keeps the server from starting.
+func Run(ctx context.Context, reg Registry, inst Instance, ttl time.Duration, health func() string) {
+	registered := false
+	renew := func() {
+		var err error
+		if !registered {
+			if err = reg.Register(ctx, inst, ttl); err == nil {
+				registered = true
+				log.Printf("Registered as %s (%s) at %s:%d", inst.Name, inst.ID, inst.Address, inst.Port)
+			}
+		}
+		if registered {
+			if err = reg.Heartbeat(ctx, inst, health()); err != nil {
+				// The registry may have dropped us; register again next time
+				registered = false
+			}
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Warning: Service registration failed: %v", err)
+		}
+	}
+
+	renew()
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			renew()
+		case <-ctx.Done():
+			if registered {
+				dctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := reg.Deregister(dctx, inst); err != nil {
+					log.Printf("Warning: Service deregistration failed: %v", err)
+				} else {
+					log.Printf("Deregistered %s", inst.ID)
+				}
+				cancel()
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package discovery
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a fake registry API logging the requests it gets
+type recorder struct {
+	mu       sync.Mutex
+	requests []string
+	bodies   map[string]map[string]any
+	respond  func(path string) string
+}
+
+func newRecorder(t *testing.T, respond func(path string) string) (*recorder, *httptest.Server) {
+	rec := &recorder{bodies: map[string]map[string]any{}, respond: respond}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, r.Method+" "+r.URL.Path)
+		rec.bodies[r.URL.Path] = body
+		rec.mu.Unlock()
+		if rec.respond != nil {
+			w.Write([]byte(rec.respond(r.URL.Path)))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return rec, srv
+}
+
+func (rec *recorder) log() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]string(nil), rec.requests...)
+}
+
+var testInstance = Instance{ID: "node-1", Name: "static-site-hosting", Address: "10.0.0.5", Port: 8080, Tags: []string{"web"}}
+
+func TestConsulRegistration(t *testing.T) {
+	rec, srv := newRecorder(t, nil)
+	c := &Consul{Addr: srv.URL, Token: "secret"}
+	ctx := context.Background()
+
+	if err := c.Register(ctx, testInstance, 15*time.Second); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := c.Heartbeat(ctx, testInstance, StatusWarning); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	if err := c.Deregister(ctx, testInstance); err != nil {
+		t.Fatalf("Deregister failed: %v", err)
+	}
+
+	want := []string{
+		"PUT /v1/agent/service/register",
+		"PUT /v1/agent/check/update/service:node-1",
+		"PUT /v1/agent/service/deregister/node-1",
+	}
+	if got := rec.log(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected requests %q, got %q", want, got)
+	}
+	reg := rec.bodies["/v1/agent/service/register"]
+	if reg["Name"] != "static-site-hosting" || reg["Address"] != "10.0.0.5" || reg["Port"] != 8080.0 {
+		t.Errorf("unexpected registration %v", reg)
+	}
+	if check, _ := reg["Check"].(map[string]any); check["TTL"] != "15s" {
+		t.Errorf("expected a 15s TTL check, got %v", reg["Check"])
+	}
+	if status := rec.bodies["/v1/agent/check/update/service:node-1"]["Status"]; status != StatusWarning {
+		t.Errorf("expected status %q, got %v", StatusWarning, status)
+	}
+}
+
+func TestConsulReportsErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Permission denied", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	err := (&Consul{Addr: srv.URL}).Register(context.Background(), testInstance, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "Permission denied") {
+		t.Errorf("expected the agent's error, got %v", err)
+	}
+}
+
+func TestEtcdRegistration(t *testing.T) {
+	rec, srv := newRecorder(t, func(path string) string {
+		switch path {
+		case "/v3/lease/grant":
+			return `{"ID":"7587","TTL":"15"}`
+		case "/v3/lease/keepalive":
+			return `{"result":{"ID":"7587","TTL":"15"}}`
+		}
+		return `{}`
+	})
+	e := &Etcd{Addr: srv.URL}
+	ctx := context.Background()
+
+	if err := e.Register(ctx, testInstance, 15*time.Second); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := e.Heartbeat(ctx, testInstance, StatusPassing); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	put := rec.bodies["/v3/kv/put"]
+	key, _ := base64.StdEncoding.DecodeString(put["key"].(string))
+	if string(key) != "/services/static-site-hosting/node-1" || put["lease"] != "7587" {
+		t.Errorf("unexpected put %v (key %s)", put, key)
+	}
+	value, _ := base64.StdEncoding.DecodeString(put["value"].(string))
+	var entry etcdEntry
+	if err := json.Unmarshal(value, &entry); err != nil || entry.Address != "10.0.0.5" || entry.Status != StatusPassing {
+		t.Errorf("unexpected value %s: %v", value, err)
+	}
+
+	if err := e.Deregister(ctx, testInstance); err != nil {
+		t.Fatalf("Deregister failed: %v", err)
+	}
+	if revoke := rec.bodies["/v3/lease/revoke"]; revoke["ID"] != "7587" {
+		t.Errorf("expected the lease to be revoked, got %v", revoke)
+	}
+}
+
+func TestEtcdHeartbeatDetectsExpiredLease(t *testing.T) {
+	_, srv := newRecorder(t, func(path string) string {
+		if path == "/v3/lease/grant" {
+			return `{"ID":"1","TTL":"15"}`
+		}
+		return `{"result":{"ID":"1"}}`
+	})
+	e := &Etcd{Addr: srv.URL}
+	e.Register(context.Background(), testInstance, 15*time.Second)
+	if err := e.Heartbeat(context.Background(), testInstance, StatusPassing); err == nil {
+		t.Error("expected an expired lease to fail the heartbeat")
+	}
+}
+
+// fakeRegistry counts calls and fails registration a set number of times
+type fakeRegistry struct {
+	mu                                 sync.Mutex
+	failRegister                       int
+	registers, heartbeats, deregisters int
+	statuses                           []string
+}
+
+func (f *fakeRegistry) Register(ctx context.Context, inst Instance, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.registers++
+	if f.failRegister > 0 {
+		f.failRegister--
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+func (f *fakeRegistry) Heartbeat(ctx context.Context, inst Instance, status string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.heartbeats++
+	f.statuses = append(f.statuses, status)
+	return nil
+}
+
+func (f *fakeRegistry) Deregister(ctx context.Context, inst Instance) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deregisters++
+	return nil
+}
+
+func TestRunRetriesAndDeregisters(t *testing.T) {
+	reg := &fakeRegistry{failRegister: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Run(ctx, reg, testInstance, 30*time.Millisecond, func() string { return StatusWarning })
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		reg.mu.Lock()
+		heartbeats := reg.heartbeats
+		reg.mu.Unlock()
+		if heartbeats >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.registers != 2 {
+		t.Errorf("expected a failed registration to be retried once, got %d attempts", reg.registers)
+	}
+	if reg.heartbeats < 2 || reg.statuses[0] != StatusWarning {
+		t.Errorf("expected heartbeats with the reported status, got %v", reg.statuses)
+	}
+	if reg.deregisters != 1 {
+		t.Errorf("expected one deregistration on shutdown, got %d", reg.deregisters)
+	}
+}
@@ -0,0 +1,126 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Etcd registers through etcd's v3 JSON gateway. The instance is stored
+// under {Prefix}/{name}/{id} as JSON with its status, attached to a lease
+// that Heartbeat keeps alive, so the key vanishes with a node that stops.
+type Etcd struct {
+	Addr   string // endpoint URL, such as http://127.0.0.1:2379
+	Prefix string // key prefix; defaults to /services
+	Client *http.Client
+
+	mu    sync.Mutex
+	lease int64
+}
+
+// etcdEntry is the value stored for an instance
+type etcdEntry struct {
+	Instance
+	Status string `json:"status"`
+}
+
+func (e *Etcd) Register(ctx context.Context, inst Instance, ttl time.Duration) error {
+	var granted struct {
+		ID string `json:"ID"`
+	}
+	seconds := int64(ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	if err := e.post(ctx, "/v3/lease/grant", map[string]any{"TTL": strconv.FormatInt(seconds, 10)}, &granted); err != nil {
+		return err
+	}
+	lease, err := strconv.ParseInt(granted.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("etcd lease grant: invalid lease ID %q", granted.ID)
+	}
+	e.mu.Lock()
+	e.lease = lease
+	e.mu.Unlock()
+	return e.put(ctx, inst, StatusPassing)
+}
+
+func (e *Etcd) Heartbeat(ctx context.Context, inst Instance, status string) error {
+	var kept struct {
+		Result struct {
+			TTL string `json:"TTL"`
+		} `json:"result"`
+	}
+	if err := e.post(ctx, "/v3/lease/keepalive", map[string]any{"ID": e.leaseID()}, &kept); err != nil {
+		return err
+	}
+	// An expired lease is reported with no TTL; its key is already gone
+	if kept.Result.TTL == "" || kept.Result.TTL == "0" {
+		return fmt.Errorf("etcd lease %s expired", e.leaseID())
+	}
+	return e.put(ctx, inst, status)
+}
+
+func (e *Etcd) Deregister(ctx context.Context, inst Instance) error {
+	// Revoking the lease deletes the key with it
+	return e.post(ctx, "/v3/lease/revoke", map[string]any{"ID": e.leaseID()}, nil)
+}
+
+// Key returns the key inst is registered under
+func (e *Etcd) Key(inst Instance) string {
+	prefix := e.Prefix
+	if prefix == "" {
+		prefix = "/services"
+	}
+	return prefix + "/" + inst.Name + "/" + inst.ID
+}
+
+func (e *Etcd) leaseID() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return strconv.FormatInt(e.lease, 10)
+}
+
+func (e *Etcd) put(ctx context.Context, inst Instance, status string) error {
+	value, err := json.Marshal(etcdEntry{inst, status})
+	if err != nil {
+		return err
+	}
+	return e.post(ctx, "/v3/kv/put", map[string]any{
+		"key":   base64.StdEncoding.EncodeToString([]byte(e.Key(inst))),
+		"value": base64.StdEncoding.EncodeToString(value),
+		"lease": e.leaseID(),
+	}, nil)
+}
+
+func (e *Etcd) post(ctx context.Context, path string, body, out any) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Addr+path, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client(e.Client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("etcd %s: %s: %s", path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,106 @@
+// Package events keeps an append-only log of domain events: deployments
+// created, failed, promoted and deleted. Derived state, such as the usage
+// ledger, can be rebuilt by replaying it, and consumers can follow it by
+// sequence number.
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Event types, named like the webhook events they accompany
+const (
+	DeploymentCreated  = "deployment.created"
+	DeploymentFailed   = "deployment.failed"
+	DeploymentPromoted = "deployment.promoted"
+	DeploymentDeleted  = "deployment.deleted"
+)
+
+// replayBatch is how many events Replay reads at a time
+const replayBatch = 500
+
+// Event is an entry of the log. Seq orders events and is never reused.
+type Event struct {
+	Seq          int64           `json:"seq"`
+	Type         string          `json:"type"`
+	DeploymentID string          `json:"deployment_id,omitempty"`
+	Site         string          `json:"site,omitempty"`
+	Data         json.RawMessage `json:"data,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// Append adds an event to the log, with data stored as JSON
+func Append(db *sql.DB, eventType, deploymentID, site string, data any) (Event, error) {
+	e := Event{Type: eventType, DeploymentID: deploymentID, Site: site, CreatedAt: time.Now().UTC()}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return e, err
+	}
+	e.Data = raw
+	result, err := db.Exec(
+		"INSERT INTO events (type, deployment_id, site, data, created_at) VALUES (?, ?, ?, ?, ?)",
+		e.Type, e.DeploymentID, e.Site, string(e.Data), e.CreatedAt,
+	)
+	if err != nil {
+		return e, err
+	}
+	e.Seq, err = result.LastInsertId()
+	return e, err
+}
+
+// Record is Append for callers that shouldn't fail because of the log
+func Record(db *sql.DB, eventType, deploymentID, site string, data any) {
+	if _, err := Append(db, eventType, deploymentID, site, data); err != nil {
+		log.Printf("Warning: Failed to record %s event for %s: %v", eventType, deploymentID, err)
+	}
+}
+
+// List returns up to limit events after sequence number after, oldest first
+func List(db *sql.DB, after int64, limit int) ([]Event, error) {
+	rows, err := db.Query(
+		"SELECT seq, type, deployment_id, site, data, created_at FROM events WHERE seq > ? ORDER BY seq LIMIT ?",
+		after, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Event{}
+	for rows.Next() {
+		var e Event
+		var data string
+		if err := rows.Scan(&e.Seq, &e.Type, &e.DeploymentID, &e.Site, &data, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Data = json.RawMessage(data)
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// Replay calls apply with every event after sequence number after, in
+// order, stopping at the first error. No query is open while apply runs.
+// It returns the number of events applied.
+func Replay(db *sql.DB, after int64, apply func(Event) error) (int, error) {
+	n := 0
+	for {
+		batch, err := List(db, after, replayBatch)
+		if err != nil {
+			return n, err
+		}
+		for _, e := range batch {
+			if err := apply(e); err != nil {
+				return n, err
+			}
+			n++
+			after = e.Seq
+		}
+		if len(batch) < replayBatch {
+			return n, nil
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE events (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		type TEXT NOT NULL,
+		deployment_id TEXT NOT NULL DEFAULT '',
+		site TEXT NOT NULL DEFAULT '',
+		data TEXT NOT NULL DEFAULT 'null',
+		created_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create events table: %v", err)
+	}
+	return db
+}
+
+func TestAppendAndList(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	first, err := Append(db, DeploymentCreated, "docs-1", "docs", map[string]int{"bytes": 42})
+	if err != nil || first.Seq == 0 {
+		t.Fatalf("Append failed: %+v, %v", first, err)
+	}
+	Record(db, DeploymentDeleted, "docs-1", "docs", nil)
+
+	list, err := List(db, 0, 10)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("expected 2 events, got %+v, %v", list, err)
+	}
+	if list[0].Type != DeploymentCreated || list[1].Type != DeploymentDeleted || list[1].Seq <= list[0].Seq {
+		t.Errorf("expected events in order, got %+v", list)
+	}
+	var data struct {
+		Bytes int `json:"bytes"`
+	}
+	if json.Unmarshal(list[0].Data, &data); data.Bytes != 42 {
+		t.Errorf("expected the event's data back, got %s", list[0].Data)
+	}
+	if list, _ := List(db, first.Seq, 10); len(list) != 1 || list[0].Type != DeploymentDeleted {
+		t.Errorf("expected only events after %d, got %+v", first.Seq, list)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// More than one batch, so Replay pages through the log
+	for i := 0; i < replayBatch+3; i++ {
+		Record(db, DeploymentCreated, "d", "", nil)
+	}
+	var last int64
+	n, err := Replay(db, 0, func(e Event) error {
+		if e.Seq <= last {
+			t.Fatalf("expected increasing sequence numbers, got %d after %d", e.Seq, last)
+		}
+		last = e.Seq
+		return nil
+	})
+	if err != nil || n != replayBatch+3 {
+		t.Errorf("expected %d events replayed, got %d, %v", replayBatch+3, n, err)
+	}
+}
@@ -0,0 +1,167 @@
+package features
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Flag names
+const (
+	SPAFallback  = "spa_fallback"
+	Brotli       = "brotli"
+	AsyncUploads = "async_uploads"
+)
+
+// Flag describes an experimental behaviour that can be switched on
+type Flag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Default     bool   `json:"default"`
+}
+
+// Known lists every flag; state for any other name is rejected
+var Known = []Flag{
+	{SPAFallback, "Serve index.html for extensionless paths that don't exist, unless a site's settings say otherwise", false},
+	{Brotli, "Serve precompressed .br files to clients that accept brotli", false},
+	{AsyncUploads, "Let uploads ask with async=true to be answered once received, and deployed in the background", false},
+}
+
+// Sources of a flag's current value
+const (
+	SourceDefault = "default"
+	SourceConfig  = "config"
+	SourceRuntime = "runtime"
+)
+
+// State is a flag's effective value and where it came from
+type State struct {
+	Flag
+	Enabled bool   `json:"enabled"`
+	Source  string `json:"source"`
+}
+
+// Set resolves flags from, in order of precedence, runtime overrides made
+// through the admin API, the configuration, and the built-in defaults.
+// Runtime overrides are stored in the feature_flags table so they survive
+// restarts. A nil *Set reports every flag at its default.
+type Set struct {
+	db         *sql.DB
+	configured map[string]bool
+
+	mu        sync.RWMutex
+	overrides map[string]bool
+}
+
+// New loads runtime overrides from db. configured holds the values set in
+// the environment; unknown names are an error so typos don't go unnoticed.
+func New(db *sql.DB, configured map[string]bool) (*Set, error) {
+	for name := range configured {
+		if _, ok := lookup(name); !ok {
+			return nil, fmt.Errorf("unknown feature flag %q", name)
+		}
+	}
+
+	s := &Set{db: db, configured: configured, overrides: map[string]bool{}}
+	rows, err := db.Query("SELECT name, enabled FROM feature_flags")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var enabled bool
+		if err := rows.Scan(&name, &enabled); err != nil {
+			return nil, err
+		}
+		// Overrides of flags that have since been removed are ignored
+		if _, ok := lookup(name); ok {
+			s.overrides[name] = enabled
+		}
+	}
+	return s, rows.Err()
+}
+
+// Enabled reports whether the named flag is on
+func (s *Set) Enabled(name string) bool {
+	return s.state(name).Enabled
+}
+
+// List returns the state of every known flag, sorted by name
+func (s *Set) List() []State {
+	states := make([]State, 0, len(Known))
+	for _, f := range Known {
+		states = append(states, s.state(f.Name))
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// Get returns the state of one flag
+func (s *Set) Get(name string) (State, bool) {
+	if _, ok := lookup(name); !ok {
+		return State{}, false
+	}
+	return s.state(name), true
+}
+
+// Override sets a flag at runtime, taking precedence over the configuration
+func (s *Set) Override(name string, enabled bool) error {
+	if _, ok := lookup(name); !ok {
+		return fmt.Errorf("unknown feature flag %q", name)
+	}
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO feature_flags (name, enabled, updated_at) VALUES (?, ?, ?)",
+		name, enabled, time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.overrides[name] = enabled
+	s.mu.Unlock()
+	return nil
+}
+
+// Reset removes a runtime override, returning the flag to its configured
+// or default value
+func (s *Set) Reset(name string) error {
+	if _, ok := lookup(name); !ok {
+		return fmt.Errorf("unknown feature flag %q", name)
+	}
+	if _, err := s.db.Exec("DELETE FROM feature_flags WHERE name = ?", name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.overrides, name)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Set) state(name string) State {
+	f, _ := lookup(name)
+	st := State{Flag: f, Enabled: f.Default, Source: SourceDefault}
+	if s == nil {
+		return st
+	}
+	if v, ok := s.configured[name]; ok {
+		st.Enabled, st.Source = v, SourceConfig
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if v, ok := s.overrides[name]; ok {
+		st.Enabled, st.Source = v, SourceRuntime
+	}
+	return st
+}
+
+func lookup(name string) (Flag, bool) {
+	for _, f := range Known {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Flag{Name: name}, false
+}
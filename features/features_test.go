@@ -0,0 +1,83 @@
+package features
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE feature_flags (name TEXT PRIMARY KEY, enabled BOOLEAN NOT NULL, updated_at DATETIME NOT NULL)`)
+	if err != nil {
+		t.Fatalf("Failed to create feature_flags table: %v", err)
+	}
+	return db
+}
+
+func TestPrecedence(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	s, err := New(db, map[string]bool{Brotli: true})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if st, _ := s.Get(SPAFallback); st.Enabled || st.Source != SourceDefault {
+		t.Errorf("expected spa_fallback off by default, got %+v", st)
+	}
+	if st, _ := s.Get(Brotli); !st.Enabled || st.Source != SourceConfig {
+		t.Errorf("expected brotli on from config, got %+v", st)
+	}
+
+	if err := s.Override(Brotli, false); err != nil {
+		t.Fatalf("Override failed: %v", err)
+	}
+	if s.Enabled(Brotli) {
+		t.Error("expected the runtime override to win over config")
+	}
+
+	// Overrides are persisted and reloaded
+	reloaded, err := New(db, map[string]bool{Brotli: true})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if st, _ := reloaded.Get(Brotli); st.Enabled || st.Source != SourceRuntime {
+		t.Errorf("expected the override to survive a restart, got %+v", st)
+	}
+
+	if err := reloaded.Reset(Brotli); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if !reloaded.Enabled(Brotli) {
+		t.Error("expected reset to return to the configured value")
+	}
+}
+
+func TestUnknownFlags(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if _, err := New(db, map[string]bool{"no_such_flag": true}); err == nil {
+		t.Error("expected an unknown configured flag to be rejected")
+	}
+	s, _ := New(db, nil)
+	if err := s.Override("no_such_flag", true); err == nil {
+		t.Error("expected overriding an unknown flag to fail")
+	}
+}
+
+func TestNilSetUsesDefaults(t *testing.T) {
+	var s *Set
+	if s.Enabled(SPAFallback) {
+		t.Error("expected a nil set to report defaults")
+	}
+	if len(s.List()) != len(Known) {
+		t.Errorf("expected every known flag, got %v", s.List())
+	}
+}
@@ -0,0 +1,9 @@
+// Package fixtures holds sample sites for local development. Each
+// directory under sites/ is a site, and each numbered directory below it
+// one of its deployments, oldest first.
+package fixtures
+
+import "embed"
+
+//go:embed sites
+var Sites embed.FS
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"static-site-hosting/models"
+)
+
+// accessPatterns caches compiled access, redirect and header rule patterns;
+// rules are validated when saved, so the set stays small and compiling
+// never fails here
+var accessPatterns sync.Map // pattern -> *regexp.Regexp
+
+func accessPattern(expr string) *regexp.Regexp {
+	if re, ok := accessPatterns.Load(expr); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil
+	}
+	accessPatterns.Store(expr, re)
+	return re
+}
+
+// accessAction returns the action of the first of rules matching r, with
+// path relative to the site, or "" when none matches
+func accessAction(rules []models.AccessRule, r *http.Request, path string) string {
+	for _, rule := range rules {
+		if accessRuleMatches(rule, r, path) {
+			if rule.Action == "" {
+				return models.AccessBlock
+			}
+			return rule.Action
+		}
+	}
+	return ""
+}
+
+// blockedByAccessRules reports whether the first of rules matching r, with
+// path relative to the site, blocks it. Requests matching no rule are served.
+func blockedByAccessRules(rules []models.AccessRule, r *http.Request, path string) bool {
+	return accessAction(rules, r, path) == models.AccessBlock
+}
+
+func accessRuleMatches(rule models.AccessRule, r *http.Request, path string) bool {
+	if len(rule.Methods) > 0 {
+		matched := false
+		for _, m := range rule.Methods {
+			if strings.EqualFold(m, r.Method) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if rule.Path != "" {
+		re := accessPattern(rule.Path)
+		if re == nil || !re.MatchString(path) {
+			return false
+		}
+	}
+	if rule.UserAgent != "" {
+		re := accessPattern("(?i)" + rule.UserAgent)
+		if re == nil || !re.MatchString(r.UserAgent()) {
+			return false
+		}
+	}
+	return true
+}
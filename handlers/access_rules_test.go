@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+)
+
+func TestAccessRulesBlockBeforeServing(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	root := filepath.Join("deployments", "site-1")
+	os.MkdirAll(filepath.Join(root, "private"), 0755)
+	recordDeployment(t, db, "site-1")
+	os.WriteFile(filepath.Join(root, "index.html"), []byte("home"), 0644)
+	os.WriteFile(filepath.Join(root, "private", "data.json"), []byte("{}"), 0644)
+	os.WriteFile(filepath.Join(root, "private", "public.json"), []byte("{}"), 0644)
+
+	saveSiteSettings(db, "site-1", models.SiteSettings{
+		CaseInsensitivePaths: true,
+		AccessRules: []models.AccessRule{
+			{Path: `^/private/public\.json$`, Action: models.AccessAllow},
+			{Path: "^/private/"},
+			{UserAgent: "scrapy|python-requests"},
+			{Methods: []string{"POST", "DELETE"}},
+		},
+	})
+
+	handler := StaticFileHandler(db)
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		userAgent string
+		expected  int
+	}{
+		{"unmatched", http.MethodGet, "/site-1/index.html", "Mozilla/5.0", http.StatusOK},
+		{"blocked directory", http.MethodGet, "/site-1/private/data.json", "", http.StatusForbidden},
+		{"allowed before block", http.MethodGet, "/site-1/private/public.json", "", http.StatusOK},
+		{"case folding", http.MethodGet, "/site-1/PRIVATE/data.json", "", http.StatusForbidden},
+		{"dot segments", http.MethodGet, "/site-1/x/../private/data.json", "", http.StatusForbidden},
+		{"missing file in blocked directory", http.MethodGet, "/site-1/private/missing", "", http.StatusForbidden},
+		{"user agent", http.MethodGet, "/site-1/index.html", "Scrapy/2.11", http.StatusForbidden},
+		{"method", http.MethodPost, "/site-1/index.html", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.URL.Path = tt.path
+			req.Header.Set("User-Agent", tt.userAgent)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, rr.Code)
+			}
+		})
+	}
+}
+
+func TestAccessRulesPrivatePaths(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	root := filepath.Join("deployments", "mixed-1")
+	os.MkdirAll(filepath.Join(root, "internal"), 0755)
+	os.WriteFile(filepath.Join(root, "index.html"), []byte("home"), 0644)
+	os.WriteFile(filepath.Join(root, "internal", "handbook.html"), []byte("handbook"), 0644)
+	db.Exec("INSERT INTO deployments (id, filename, path, site, owner_id) VALUES ('mixed-1', 'site.zip', ?, 'mixed', 'user:alice')", root)
+
+	saveSiteSettings(db, "mixed-1", models.SiteSettings{
+		CaseInsensitivePaths: true,
+		AccessRules:          []models.AccessRule{{Path: "^/internal/", Action: models.AccessPrivate}},
+	})
+
+	handler := StaticFileHandler(db)
+	tests := []struct {
+		name     string
+		path     string
+		claims   *auth.Claims
+		expected int
+	}{
+		{"public page", "/mixed-1/index.html", nil, http.StatusOK},
+		{"anonymous", "/mixed-1/internal/handbook.html", nil, http.StatusUnauthorized},
+		{"case folding", "/mixed-1/INTERNAL/handbook.html", nil, http.StatusUnauthorized},
+		{"dot segments", "/mixed-1/x/../internal/handbook.html", nil, http.StatusUnauthorized},
+		{"other user", "/mixed-1/internal/handbook.html", bobClaims, http.StatusNotFound},
+		{"owner", "/mixed-1/internal/handbook.html", aliceClaims, http.StatusOK},
+		{"admin", "/mixed-1/internal/handbook.html", adminClaims, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.URL.Path = tt.path
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, as(req, tt.claims))
+			if rr.Code != tt.expected {
+				t.Errorf("expected %d, got %d", tt.expected, rr.Code)
+			}
+			if rr.Code == http.StatusOK && strings.Contains(tt.path, "internal") && rr.Header().Get("Cache-Control") != "private" {
+				t.Errorf("expected a private response, got Cache-Control %q", rr.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+func TestSiteSettingsRejectsInvalidAccessRules(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	db.Exec("INSERT INTO deployments (id, filename, path) VALUES ('site-1', 'site.zip', 'deployments/site-1')")
+
+	for _, body := range []string{
+		`{"access_rules":[{"path":"("}]}`,
+		`{"access_rules":[{"action":"block"}]}`,
+		`{"access_rules":[{"path":"^/x","action":"redirect"}]}`,
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/sites/site-1/settings", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		SiteSettingsHandler(rr, req, db)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"static-site-hosting/artifacts"
+	"static-site-hosting/immutable"
+	"static-site-hosting/models"
+
+	"github.com/google/uuid"
+)
+
+// archiveContentTypes is the Content-Type each archive format is served as
+var archiveContentTypes = map[string]string{
+	archiveZip:   "application/zip",
+	archiveTar:   "application/x-tar",
+	archiveTarGz: "application/gzip",
+}
+
+// DeploymentArtifactHandler serves the original archive a deployment was
+// extracted from.
+// Expected: GET /deployments/{id}/artifact
+//
+//	POST /deployments/{id}/artifact/verify re-hashes the stored archive
+//	POST /deployments/{id}/artifact/extract deploys it again as a new deployment
+func DeploymentArtifactHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	deploymentID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/deployments/"), "/artifact")
+	action = strings.TrimPrefix(action, "/")
+
+	var d models.Deployment
+	err := db.QueryRow("SELECT id, filename, site, owner_id FROM deployments WHERE id = ?", deploymentID).Scan(&d.ID, &d.Filename, &d.Site, &d.OwnerID)
+	if err == sql.ErrNoRows || err == nil && !ownsDeployment(r, d.OwnerID) {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch deployment", http.StatusInternalServerError)
+		return
+	}
+	// The archive holds every file the deployment serves
+	if _, _, ok := authorizeDeploymentRead(w, r, db, deploymentID); !ok {
+		return
+	}
+	artifact, err := artifacts.Lookup(db, deploymentID)
+	if errors.Is(err, artifacts.ErrNotFound) {
+		http.Error(w, "No archive retained for this deployment", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch artifact", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		serveArtifact(w, d, artifact)
+	case action == "verify" && r.Method == http.MethodPost:
+		sum, ok, err := artifact.Verify()
+		if err != nil {
+			log.Printf("Failed to verify artifact of deployment %s: %v", deploymentID, err)
+			http.Error(w, "Failed to read artifact", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"deployment_id": deploymentID,
+			"expected":      artifact.SHA256,
+			"sha256":        sum,
+			"verified":      ok,
+		})
+	case action == "extract" && r.Method == http.MethodPost:
+		reextractArtifact(w, r, db, d, artifact)
+	case action == "" || action == "verify" || action == "extract":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func serveArtifact(w http.ResponseWriter, d models.Deployment, artifact *artifacts.Artifact) {
+	rc, err := artifact.Open()
+	if err != nil {
+		log.Printf("Failed to open artifact of deployment %s: %v", d.ID, err)
+		http.Error(w, "Failed to read artifact", http.StatusBadGateway)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", archiveContentTypes[artifact.Format])
+	w.Header().Set("Content-Length", strconv.FormatInt(artifact.Size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", d.Filename))
+	w.Header().Set("X-Archive-Sha256", artifact.SHA256)
+	io.Copy(w, rc)
+}
+
+// reextractArtifact extracts a retained archive into a new deployment of the
+// same site, running the current extraction and checks over it
+func reextractArtifact(w http.ResponseWriter, r *http.Request, db *sql.DB, source models.Deployment, artifact *artifacts.Artifact) {
+	if !requireUploadSignature(w, r, nil) {
+		return
+	}
+	started := time.Now()
+	progress := startUpload(uploadID(r), artifact.Size)
+	w.Header().Set("X-Upload-Id", progress.id)
+	fail := func(msg string, code int) {
+		progress.fail(msg)
+		http.Error(w, msg, code)
+	}
+
+	rc, err := artifact.Open()
+	if err != nil {
+		log.Printf("Failed to open artifact of deployment %s: %v", source.ID, err)
+		fail("Failed to read artifact", http.StatusBadGateway)
+		return
+	}
+	defer rc.Close()
+
+	// Both formats are extracted from a local copy, which is then retained
+	// for the new deployment
+	deploymentID := uuid.New().String()
+	archivePath := spoolPath("%s.%s", deploymentID, artifact.Format)
+	dst, err := os.Create(archivePath)
+	if err != nil {
+		fail("Could not create temp file", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(archivePath)
+	_, err = io.Copy(dst, progress.body(rc))
+	dst.Close()
+	if err != nil {
+		fail("Failed to read artifact", http.StatusBadGateway)
+		return
+	}
+
+	destDir := filepath.Join("deployments", deploymentID)
+	if err := extractArchive(archivePath, artifact.Format, destDir, progress); err != nil {
+		immutable.RemoveAll(destDir)
+		fail(extractFailure(err, "Failed to extract artifact"), http.StatusInternalServerError)
+		return
+	}
+
+	deployment := models.NewDeployment(deploymentID, source.Filename, destDir)
+	deployment.Site = source.Site
+	deployment.ArchiveSHA256 = artifact.SHA256
+	w.Header().Set("X-Source-Deployment", source.ID)
+	publishDeployment(w, r, db, deployment, &uploadArchive{archivePath, artifact.Format}, progress, started)
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/sessions"
+)
+
+const oidcStateCookie = "oidc_state"
+
+// sessionStore records the sessions logins start; nil leaves session tokens
+// valid until they expire, logged out or not
+var sessionStore *sessions.Store
+
+// SetSessionStore sets the store recording login sessions
+func SetSessionStore(s *sessions.Store) {
+	sessionStore = s
+}
+
+// OIDCLoginHandler redirects the browser to the identity provider
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request, provider *auth.OIDCProvider, signer *auth.Signer) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state, nonce := randomToken(), randomToken()
+	target, err := provider.AuthCodeURL(state, nonce)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// State and nonce travel in a short-lived signed cookie so the callback
+	// can be verified without server-side storage
+	stateToken, err := signer.Issue(auth.Claims{Purpose: auth.PurposeLoginState, State: state, Nonce: nonce}, 10*time.Minute)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    stateToken,
+		Path:     "/auth/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// OIDCCallbackHandler completes the login, maps provider groups to a role and
+// issues a session token. Forged state and rejected codes count towards the
+// client's lockout in throttle.
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request, provider *auth.OIDCProvider, signer *auth.Signer, throttle *auth.Throttle) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ipKey := throttle.IPKey(r)
+	if refuseThrottled(w, r, throttle, "oidc", "", ipKey) {
+		return
+	}
+
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		http.Error(w, "Login failed: "+errCode, http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Error(w, "Missing login state", http.StatusBadRequest)
+		return
+	}
+	stateClaims, err := signer.VerifyPurpose(cookie.Value, auth.PurposeLoginState)
+	if err != nil || stateClaims.State == "" || stateClaims.State != r.URL.Query().Get("state") {
+		recordAuthFailure(r, throttle, "oidc", "", "invalid state", ipKey)
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "Authorization code required", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := provider.Exchange(code, stateClaims.Nonce)
+	if err != nil {
+		log.Printf("OIDC callback failed: %v", err)
+		recordAuthFailure(r, throttle, "oidc", "", err.Error(), ipKey)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	claims := auth.Claims{
+		Subject:  "oidc:" + identity.Subject,
+		Email:    identity.Email,
+		Name:     identity.Name,
+		Groups:   identity.Groups,
+		Role:     auth.RoleForGroups(identity.Groups, cfg.OIDCRoleMapping, cfg.OIDCDefaultRole),
+		Provider: "oidc",
+		Tenant:   identity.Tenant,
+	}
+
+	throttle.Audit(r, auth.AuditEntry{Event: auth.AuditLoginSucceeded, Provider: "oidc", Subject: claims.Subject})
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/auth/oidc", MaxAge: -1})
+	issueSession(w, r, signer, claims)
+}
+
+// LDAPLoginHandler authenticates a username and password against the
+// directory and issues a session token. Wrong passwords count towards
+// lockouts of both the client IP and the username, so neither guessing many
+// passwords for one user nor one password across many users gets far.
+// Expected: POST /auth/ldap/login {"username": "...", "password": "..."}
+func LDAPLoginHandler(w http.ResponseWriter, r *http.Request, authenticator *auth.LDAPAuthenticator, signer *auth.Signer, throttle *auth.Throttle) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	ipKey, userKey := throttle.IPKey(r), auth.UserKey(req.Username)
+	if refuseThrottled(w, r, throttle, "ldap", req.Username, ipKey, userKey) {
+		return
+	}
+
+	identity, err := authenticator.Authenticate(req.Username, req.Password)
+	if err == auth.ErrInvalidCredentials {
+		recordAuthFailure(r, throttle, "ldap", req.Username, "invalid credentials", ipKey, userKey)
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("LDAP login failed: %v", err)
+		http.Error(w, "Directory unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// Only the username is cleared: a shared IP that guessed its way through
+	// other accounts stays locked out
+	throttle.Reset(userKey)
+	throttle.Audit(r, auth.AuditEntry{Event: auth.AuditLoginSucceeded, Provider: "ldap", Subject: req.Username})
+	issueSession(w, r, signer, auth.Claims{
+		Subject:  "ldap:" + identity.DN,
+		Email:    identity.Email,
+		Name:     identity.Name,
+		Groups:   identity.Groups,
+		Role:     auth.RoleForGroups(identity.Groups, cfg.LDAPRoleMapping, cfg.LDAPDefaultRole),
+		Provider: "ldap",
+	})
+}
+
+// refuseThrottled answers 429 when any of keys is locked out
+func refuseThrottled(w http.ResponseWriter, r *http.Request, throttle *auth.Throttle, provider, subject string, keys ...string) bool {
+	wait := throttle.Wait(keys...)
+	if wait == 0 {
+		return false
+	}
+	throttle.Audit(r, auth.AuditEntry{Event: auth.AuditThrottled, Provider: provider, Subject: subject})
+	w.Header().Set("Retry-After", auth.RetryAfter(wait))
+	http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+	return true
+}
+
+// recordAuthFailure counts a failed login against keys and audits it
+func recordAuthFailure(r *http.Request, throttle *auth.Throttle, provider, subject, reason string, keys ...string) {
+	lockout := throttle.Fail(keys...)
+	if lockout > 0 {
+		reason += "; locked out for " + lockout.String()
+	}
+	throttle.Audit(r, auth.AuditEntry{Event: auth.AuditLoginFailed, Provider: provider, Subject: subject, Detail: reason})
+}
+
+// issueSession starts a session, signs claims for it, sets the session
+// cookie and returns the token, with the CSRF token unsafe requests relying
+// on the cookie must send. The cookie is Secure whenever the API is reached
+// over HTTPS, including through a TLS-terminating proxy.
+func issueSession(w http.ResponseWriter, r *http.Request, signer *auth.Signer, claims auth.Claims) {
+	if sessionStore != nil {
+		session, err := sessionStore.Create(claims.Subject, r.UserAgent(), cfg.SessionTTL)
+		if err != nil {
+			log.Printf("Failed to start session: %v", err)
+			http.Error(w, "Failed to issue session", http.StatusInternalServerError)
+			return
+		}
+		claims.SessionID = session.ID
+	}
+	token, err := signer.Issue(claims, cfg.SessionTTL)
+	if err != nil {
+		http.Error(w, "Failed to issue session", http.StatusInternalServerError)
+		return
+	}
+	expiresAt := time.Now().Add(cfg.SessionTTL)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(publicBaseURL(r), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"csrf_token": auth.CSRFToken(token),
+		"expires_at": expiresAt.UTC(),
+		"user": map[string]interface{}{
+			"id":     claims.Subject,
+			"email":  claims.Email,
+			"name":   claims.Name,
+			"role":   claims.Role,
+			"groups": claims.Groups,
+		},
+	})
+}
+
+// LogoutHandler ends the caller's session, so neither its cookie nor its
+// token is accepted again, and clears the cookie. API tokens are revoked
+// separately.
+// Expected: POST /auth/logout
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if claims := auth.FromContext(r.Context()); claims != nil && claims.SessionID != "" && sessionStore != nil {
+		if err := sessionStore.End(claims.SessionID); err != nil {
+			http.Error(w, "Failed to log out", http.StatusInternalServerError)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: auth.SessionCookie, Path: "/", MaxAge: -1})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out"})
+}
+
+// MeHandler returns the authenticated caller's identity
+func MeHandler(w http.ResponseWriter, r *http.Request) {
+	claims := auth.FromContext(r.Context())
+	if claims == nil {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claims)
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
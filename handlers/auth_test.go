@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/sessions"
+)
+
+func TestMeHandler(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+	rr := httptest.NewRecorder()
+	MeHandler(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 for anonymous caller, got %d", rr.Code)
+	}
+
+	claims := &auth.Claims{Subject: "oidc:abc", Role: auth.RoleViewer}
+	req = req.WithContext(auth.WithClaims(req.Context(), claims))
+	rr = httptest.NewRecorder()
+	MeHandler(rr, req)
+
+	var got auth.Claims
+	json.NewDecoder(rr.Body).Decode(&got)
+	if got.Subject != "oidc:abc" {
+		t.Errorf("expected subject oidc:abc, got %q", got.Subject)
+	}
+}
+
+func TestOIDCCallbackHandlerRejectsBadState(t *testing.T) {
+	signer := auth.NewSigner([]byte("secret"))
+	provider := auth.NewOIDCProvider(auth.OIDCConfig{Issuer: "http://unused.invalid"})
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?state=abc&code=x", nil)
+	rr := httptest.NewRecorder()
+	OIDCCallbackHandler(rr, req, provider, signer, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 without state cookie, got %d", rr.Code)
+	}
+
+	stateToken, _ := signer.Issue(auth.Claims{State: "expected", Nonce: "n"}, 10*time.Minute)
+	req = httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?state=abc&code=x", nil)
+	req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: stateToken})
+	rr = httptest.NewRecorder()
+	OIDCCallbackHandler(rr, req, provider, signer, nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for mismatched state, got %d", rr.Code)
+	}
+}
+
+func TestLDAPLoginHandlerLocksOutRepeatedFailures(t *testing.T) {
+	signer := auth.NewSigner([]byte("secret"))
+	// Empty passwords are rejected before the directory is contacted
+	authenticator := auth.NewLDAPAuthenticator(auth.LDAPConfig{URL: "ldap://unused.invalid", UserBaseDN: "dc=example"})
+	throttle := auth.NewThrottle(3, time.Minute, time.Hour)
+
+	login := func(username, addr string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"username": username, "password": ""})
+		req := httptest.NewRequest(http.MethodPost, "/auth/ldap/login", bytes.NewReader(body))
+		req.RemoteAddr = addr
+		rr := httptest.NewRecorder()
+		LDAPLoginHandler(rr, req, authenticator, signer, throttle)
+		return rr
+	}
+
+	for i := 0; i < 4; i++ {
+		if rr := login("alice", "10.0.0.1:1000"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, rr.Code)
+		}
+	}
+	rr := login("alice", "10.0.0.1:1000")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After after repeated failures, got %d", rr.Code)
+	}
+
+	// The username stays locked from another address
+	if rr := login("Alice", "10.0.0.2:1000"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the username lockout to apply from any IP, got %d", rr.Code)
+	}
+	// and the address stays locked for other usernames
+	if rr := login("bob", "10.0.0.1:1000"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the IP lockout to apply to any username, got %d", rr.Code)
+	}
+	if rr := login("bob", "10.0.0.3:1000"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected an unrelated login to be checked, got %d", rr.Code)
+	}
+}
+
+func TestSessionLogout(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	fastPasswords(t)
+	store := sessions.New(db)
+	SetSessionStore(store)
+	defer SetSessionStore(nil)
+	signer := auth.NewSigner([]byte("secret"))
+	register := func(w http.ResponseWriter, r *http.Request) { RegisterHandler(w, r, db, signer) }
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(`{"username": "alice", "password": "correct horse"}`))
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rr := httptest.NewRecorder()
+	register(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || !cookies[0].Secure || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Fatalf("expected a secure, HttpOnly session cookie, got %+v", cookies)
+	}
+	claims, err := signer.Verify(cookies[0].Value)
+	if err != nil || claims.SessionID == "" || !store.Active(claims.SessionID) {
+		t.Fatalf("expected the token to name an active session, got %+v, %v", claims, err)
+	}
+
+	// API tokens created in the session outlive it
+	tokens := func(w http.ResponseWriter, r *http.Request) { TokensHandler(w, r, db, signer) }
+	rr = postJSON(tokens, "/auth/tokens", map[string]any{"name": "ci", "scopes": []string{auth.ScopeDeployRead}}, claims)
+	var created struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(rr.Body).Decode(&created)
+	if tokenClaims, err := signer.Verify(created.Token); err != nil || tokenClaims.SessionID != "" {
+		t.Errorf("expected an API token without the session, got %+v, %v", tokenClaims, err)
+	}
+
+	rr = httptest.NewRecorder()
+	LogoutHandler(rr, as(httptest.NewRequest(http.MethodPost, "/auth/logout", nil), claims))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if store.Active(claims.SessionID) {
+		t.Error("expected logging out to end the session")
+	}
+	if cleared := rr.Result().Cookies(); len(cleared) != 1 || cleared[0].MaxAge >= 0 {
+		t.Errorf("expected the cookie to be cleared, got %+v", cleared)
+	}
+}
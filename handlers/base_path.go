@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Attributes holding a single URL, quoted or not
+	urlAttrPattern = regexp.MustCompile(`(?i)(\s(?:href|src|action|poster|data|formaction)\s*=\s*["']?)/`)
+	// srcset lists several URLs, each followed by a size
+	srcsetPattern = regexp.MustCompile(`(?i)(\ssrcset\s*=\s*)("[^"]*"|'[^']*')`)
+	// url() in inline styles
+	cssURLPattern = regexp.MustCompile(`(?i)(url\(\s*["']?)/`)
+)
+
+// rewriteBasePath rewrites root-relative URLs (/css/app.css) in the HTML
+// files below dir to start with prefix (/s/{id}/css/app.css), for sites
+// built to be served at a domain root but deployed to a path. Protocol-
+// relative URLs (//cdn.example.com) and URLs already under prefix are
+// left alone.
+func rewriteBasePath(dir, prefix string) error {
+	prefix = strings.TrimSuffix(prefix, "/")
+	rewrite := func(url string) string {
+		if strings.HasPrefix(url, "//") || url == prefix || strings.HasPrefix(url, prefix+"/") {
+			return url
+		}
+		return prefix + url
+	}
+	// The patterns match up to the URL's leading slash; the rest is looked
+	// at by rewrite
+	replaceAll := func(pattern *regexp.Regexp, html string) string {
+		var b strings.Builder
+		last := 0
+		for _, m := range pattern.FindAllStringSubmatchIndex(html, -1) {
+			slash := m[1] - 1
+			end := slash
+			for end < len(html) && !strings.ContainsRune("\"' \t\r\n>)", rune(html[end])) {
+				end++
+			}
+			b.WriteString(html[last:slash])
+			b.WriteString(rewrite(html[slash:end]))
+			last = end
+		}
+		b.WriteString(html[last:])
+		return b.String()
+	}
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isHTMLFile(path) {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		html := replaceAll(urlAttrPattern, string(data))
+		html = replaceAll(cssURLPattern, html)
+		html = srcsetPattern.ReplaceAllStringFunc(html, func(attr string) string {
+			m := srcsetPattern.FindStringSubmatch(attr)
+			quote, list := m[2][:1], m[2][1:len(m[2])-1]
+			candidates := strings.Split(list, ",")
+			for i, c := range candidates {
+				trimmed := strings.TrimLeft(c, " \t\r\n")
+				if strings.HasPrefix(trimmed, "/") {
+					candidates[i] = c[:len(c)-len(trimmed)] + rewrite(trimmed)
+				}
+			}
+			return m[1] + quote + strings.Join(candidates, ",") + quote
+		})
+		if html == string(data) {
+			return nil
+		}
+		return os.WriteFile(path, []byte(html), 0644)
+	})
+}
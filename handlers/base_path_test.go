@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"static-site-hosting/models"
+)
+
+func TestRewriteBasePath(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "docs"), 0755)
+	files := map[string]string{
+		"index.html": `<link rel="stylesheet" href="/css/app.css"><script src='/js/app.js'></script>` +
+			`<a href=/about>About</a><a href="//cdn.example.com/x.js">CDN</a><a href="https://example.com/">Out</a>` +
+			`<a href="/s/abc/already">Done</a><a href="relative.html">Rel</a>` +
+			`<img srcset="/img/a.png 1x, /img/a@2x.png 2x" src="/img/a.png"><div style="background: url('/bg.png')"></div>`,
+		"docs/page.HTM": `<form action="/search"></form>`,
+		"app.js":        `fetch("/api")`,
+	}
+	for name, content := range files {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	if err := rewriteBasePath(dir, "/s/abc/"); err != nil {
+		t.Fatalf("rewriteBasePath failed: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"index.html": `<link rel="stylesheet" href="/s/abc/css/app.css"><script src='/s/abc/js/app.js'></script>` +
+			`<a href=/s/abc/about>About</a><a href="//cdn.example.com/x.js">CDN</a><a href="https://example.com/">Out</a>` +
+			`<a href="/s/abc/already">Done</a><a href="relative.html">Rel</a>` +
+			`<img srcset="/s/abc/img/a.png 1x, /s/abc/img/a@2x.png 2x" src="/s/abc/img/a.png"><div style="background: url('/s/abc/bg.png')"></div>`,
+		"docs/page.HTM": `<form action="/s/abc/search"></form>`,
+		"app.js":        `fetch("/api")`,
+	} {
+		got, _ := os.ReadFile(filepath.Join(dir, name))
+		if string(got) != want {
+			t.Errorf("%s:\n got %s\nwant %s", name, got, want)
+		}
+	}
+}
+
+func TestUploadRewritesBasePath(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	archive := new(bytes.Buffer)
+	zw := zip.NewWriter(archive)
+	f, _ := zw.Create("index.html")
+	f.Write([]byte(`<link href="/style.css">`))
+	zw.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("rewrite_base_path", "true")
+	part, _ := writer.CreateFormFile("file", "site.zip")
+	part.Write(archive.Bytes())
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	UploadHandler(rr, req, db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var deployment models.Deployment
+	json.NewDecoder(rr.Body).Decode(&deployment)
+	got, _ := os.ReadFile(filepath.Join(deployment.Path, "index.html"))
+	if want := `<link href="/s/` + deployment.ID + `/style.css">`; string(got) != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
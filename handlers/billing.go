@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/usage"
+)
+
+// meter counts bytes served by StaticFileHandler; nil disables metering
+var meter *usage.Meter
+
+// SetUsageMeter sets the meter that records bandwidth for billing
+func SetUsageMeter(m *usage.Meter) {
+	meter = m
+}
+
+// requestTenant returns the tenant of the authenticated caller, falling back
+// to fallback for anonymous callers or identities without a tenant
+func requestTenant(r *http.Request, fallback string) string {
+	if claims := auth.FromContext(r.Context()); claims != nil && claims.Tenant != "" {
+		return claims.Tenant
+	}
+	return fallback
+}
+
+// countingWriter counts response body bytes for bandwidth metering
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// BillingUsageHandler exports per-tenant usage for a calendar month:
+//
+//	GET /admin/billing/usage?period=2024-06[&format=csv]
+func BillingUsageHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	start, end, err := usage.ParsePeriod(period)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Make sure recently served bytes are included
+	if meter != nil {
+		meter.Flush()
+	}
+
+	report, err := usage.Report(db, start, end)
+	if err != nil {
+		http.Error(w, "Failed to compute usage", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=usage-"+period+".csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"period", "tenant_id", "storage_byte_days", "bandwidth_bytes", "build_minutes", "deployments_active"})
+		for _, u := range report {
+			cw.Write([]string{
+				period,
+				u.TenantID,
+				strconv.FormatFloat(u.StorageByteDays, 'f', 2, 64),
+				strconv.FormatInt(u.BandwidthBytes, 10),
+				strconv.FormatFloat(u.BuildMinutes, 'f', 2, 64),
+				strconv.Itoa(u.DeploymentsActive),
+			})
+		}
+		cw.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"period":  period,
+		"start":   start,
+		"end":     end,
+		"tenants": report,
+	})
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"static-site-hosting/usage"
+)
+
+func TestBillingUsageHandlerJSON(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	created := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	db.Exec(
+		"INSERT INTO deployment_usage (deployment_id, tenant_id, bytes, build_ms, created_at) VALUES (?, ?, ?, ?, ?)",
+		"site-1", "acme", 2048, 60000, created,
+	)
+	db.Exec("INSERT INTO bandwidth_daily (deployment_id, day, bytes) VALUES (?, ?, ?)", "site-1", "2024-06-15", 4096)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/billing/usage?period=2024-06", nil)
+	rr := httptest.NewRecorder()
+	BillingUsageHandler(rr, req, db)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+
+	var body struct {
+		Period  string              `json:"period"`
+		Tenants []usage.TenantUsage `json:"tenants"`
+	}
+	json.NewDecoder(rr.Body).Decode(&body)
+	if body.Period != "2024-06" || len(body.Tenants) != 1 {
+		t.Fatalf("unexpected response: %+v", body)
+	}
+	acme := body.Tenants[0]
+	if acme.TenantID != "acme" || acme.BandwidthBytes != 4096 || acme.BuildMinutes != 1 {
+		t.Errorf("unexpected usage: %+v", acme)
+	}
+	if acme.StorageByteDays != 2048*30 {
+		t.Errorf("expected %d byte-days, got %f", 2048*30, acme.StorageByteDays)
+	}
+}
+
+func TestBillingUsageHandlerCSV(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/billing/usage?period=2024-06&format=csv", nil)
+	rr := httptest.NewRecorder()
+	BillingUsageHandler(rr, req, db)
+
+	if ct := rr.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("expected text/csv, got %s", ct)
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil || len(records) != 1 || records[0][1] != "tenant_id" {
+		t.Errorf("expected header row only, got %v (%v)", records, err)
+	}
+}
+
+func TestBillingUsageHandlerInvalidPeriod(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/billing/usage?period=last-month", nil)
+	rr := httptest.NewRecorder()
+	BillingUsageHandler(rr, req, db)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rr.Code)
+	}
+}
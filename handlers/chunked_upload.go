@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"static-site-hosting/locks"
+	"static-site-hosting/models"
+
+	"github.com/google/uuid"
+)
+
+// maxUploadChunks bounds the number of chunks of one chunked upload
+const maxUploadChunks = 10000
+
+// chunkUploadTTL is how long the chunks of an upload that is never
+// completed are kept
+const chunkUploadTTL = 24 * time.Hour
+
+// chunkDir holds the chunks of a chunked upload until it is completed
+func chunkDir(id string) string {
+	return spoolPath("chunks-%s", id)
+}
+
+// UploadChunkHandler stores one chunk of an upload sent in several
+// requests, for clients whose tooling splits large archives. Chunks are
+// numbered from 1 and may arrive in any order or be resent; they are
+// deployed in order by CompleteUploadHandler. Received chunks are recorded
+// in the database, so an upload can be resumed after a restart, and together
+// may not exceed the caller's size limit.
+// Expected: PUT /uploads/{id}/chunks/{n}
+func UploadChunkHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "PUT required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/uploads/"), "/chunks/")
+	var errs models.ValidationErrors
+	errs.Include(uploadIDError(id))
+	n, err := strconv.Atoi(rest)
+	if err != nil || n < 1 || n > maxUploadChunks {
+		errs.Add("chunk", models.CodeInvalid, "Chunk number must be between 1 and %d", maxUploadChunks)
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, http.StatusBadRequest, errs...)
+		return
+	}
+	if !limitUploadBody(w, r, db) {
+		writeUploadTooLarge(w, uploadPolicy(r, db).MaxBytes, nil)
+		return
+	}
+	// Each chunk is signed, so completing an upload deploys nothing that wasn't
+	if _, problem := verifyUploadSignature(r); problem != "" {
+		http.Error(w, problem, http.StatusUnauthorized)
+		return
+	}
+
+	pruneChunkUploads()
+	pruneUploadSessions(db)
+	dir, ownerID, err := spoolChunkDir(db, r, id)
+	if err != nil {
+		http.Error(w, "Failed to fetch upload", http.StatusInternalServerError)
+		return
+	}
+	if !ownsDeployment(r, ownerID) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		http.Error(w, "Could not create temp file", http.StatusInternalServerError)
+		return
+	}
+
+	progress := restoreUpload(db, id)
+	w.Header().Set("X-Upload-Id", progress.id)
+	// The size limit applies to the chunks together, so an upload can't
+	// spool more than it could deploy
+	body := signedBody(r)
+	limit := uploadPolicy(r, db).MaxBytes
+	if limit > 0 {
+		recorded, err := recordedChunks(db, id)
+		if err != nil {
+			http.Error(w, "Failed to fetch upload", http.StatusInternalServerError)
+			return
+		}
+		remaining := limit
+		for chunk, size := range recorded {
+			// A resent chunk replaces the one received before
+			if chunk != n {
+				remaining -= size
+			}
+		}
+		if remaining < 0 || r.ContentLength > remaining {
+			writeUploadTooLarge(w, limit, progress)
+			return
+		}
+		body = http.MaxBytesReader(w, body, remaining)
+	}
+	size, err := saveChunk(dir, n, progress.body(body), false)
+	if tooLarge(err) {
+		writeUploadTooLarge(w, limit, progress)
+		return
+	}
+	if errors.Is(err, errSignatureMismatch) {
+		http.Error(w, errUploadSignature, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save uploaded file", http.StatusInternalServerError)
+		return
+	}
+	if err := recordChunk(db, id, n, size, dir, ownerID); err != nil {
+		log.Printf("Failed to record chunk %d of upload %s: %v", n, id, err)
+		http.Error(w, "Failed to save uploaded file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{"upload_id": id, "chunk": n, "size": size})
+}
+
+// saveChunk writes chunk n of an upload to dir from body and returns its
+// size. It is written aside and renamed, so a resent chunk never leaves a
+// partial one; with keepPartial, what arrived before a failed read is kept
+// as the chunk, and its size returned with the error.
+func saveChunk(dir string, n int, body io.Reader, keepPartial bool) (int64, error) {
+	part := filepath.Join(dir, fmt.Sprintf("%d.%s.part", n, uuid.New().String()))
+	dst, err := os.Create(part)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(part)
+	size, err := io.Copy(dst, body)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil && !(keepPartial && size > 0 && !tooLarge(err)) {
+		return 0, err
+	}
+	if renameErr := os.Rename(part, filepath.Join(dir, strconv.Itoa(n))); renameErr != nil {
+		return 0, renameErr
+	}
+	return size, err
+}
+
+// CompleteUploadHandler deploys the chunks of an upload, joined in order.
+// The archive format is detected from its content, and a zip uploaded one
+// volume per chunk is joined into a single archive. The site, deployment_id
+// and filename query parameters are those of /upload.
+// Expected: POST /uploads/{id}/complete
+func CompleteUploadHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/uploads/"), "/complete")
+	site := r.URL.Query().Get("site")
+	requestedID := r.URL.Query().Get("deployment_id")
+	var errs models.ValidationErrors
+	errs.Include(uploadIDError(id))
+	if site != "" {
+		errs.Include(siteSlugError(site))
+	}
+	errs.Include(deploymentIDError(requestedID))
+	_, e := expiresAtParam(r, site)
+	errs.Include(e)
+	if len(errs) > 0 {
+		writeFieldErrors(w, http.StatusBadRequest, errs...)
+		return
+	}
+
+	if !requireUploadSignature(w, r, nil) {
+		return
+	}
+
+	started := time.Now()
+	unlock, ok := lockMutation(w, "complete upload", locks.Upload(id))
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	dir, ownerID, err := uploadSession(db, id)
+	if err == sql.ErrNoRows || err == nil && !ownsDeployment(r, ownerID) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch upload", http.StatusInternalServerError)
+		return
+	}
+	recorded, err := recordedChunks(db, id)
+	if err != nil {
+		http.Error(w, "Failed to fetch upload", http.StatusInternalServerError)
+		return
+	}
+	chunks, volumes, err := uploadChunks(dir, recorded)
+	if err != nil {
+		http.Error(w, "Incomplete upload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var total int64
+	for _, size := range volumes {
+		total += size
+	}
+	if length := uploadLength(db, id); length >= 0 && total != length {
+		http.Error(w, fmt.Sprintf("Incomplete upload: %d of %d bytes received", total, length), http.StatusBadRequest)
+		return
+	}
+	if limit := uploadPolicy(r, db).MaxBytes; limit > 0 && total > limit {
+		writeUploadTooLarge(w, limit, nil)
+		return
+	}
+
+	var body []io.Reader
+	for _, name := range chunks {
+		f, err := os.Open(name)
+		if err != nil {
+			http.Error(w, "Failed to read uploaded chunks", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		body = append(body, f)
+	}
+	format := sniffArchiveFormat(chunks[0])
+	if format == "" {
+		http.Error(w, "Upload is not a zip, tar or tar.gz archive", http.StatusBadRequest)
+		return
+	}
+
+	name := site
+	if name == "" {
+		name = id
+	}
+	filename := rawArchiveFilename(r, name, format)
+	if q := filepath.Base(r.URL.Query().Get("filename")); q != "." && q != "/" {
+		filename = q
+	}
+
+	progress := restoreUpload(db, id)
+	w.Header().Set("X-Upload-Id", progress.id)
+	deployRawArchive(w, r, db, io.MultiReader(body...), volumes, format, site, requestedID, filename, progress, started)
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("Warning: Failed to remove chunks of upload %s: %v", id, err)
+	}
+	forgetUpload(db, id)
+}
+
+// uploadIDError explains why id can't name a chunked upload, or returns nil
+func uploadIDError(id string) *models.FieldError {
+	if !validUploadID(id) {
+		return &models.FieldError{Field: "upload_id", Code: models.CodeInvalid, Message: "Invalid upload ID"}
+	}
+	return nil
+}
+
+// uploadChunks lists the chunk files of an upload in order with their
+// sizes, requiring every chunk from 1 to the highest to be recorded and
+// still in dir as it was received
+func uploadChunks(dir string, recorded map[int]int64) (paths []string, sizes []int64, err error) {
+	last := 0
+	for n := range recorded {
+		last = max(last, n)
+	}
+	if last == 0 {
+		return nil, nil, fmt.Errorf("no chunks were received")
+	}
+	for n := 1; n <= last; n++ {
+		size, ok := recorded[n]
+		if !ok {
+			return nil, nil, fmt.Errorf("chunk %d is missing", n)
+		}
+		path := filepath.Join(dir, strconv.Itoa(n))
+		if info, err := os.Stat(path); err != nil || info.Size() != size {
+			return nil, nil, fmt.Errorf("chunk %d was lost; resend it", n)
+		}
+		paths = append(paths, path)
+		sizes = append(sizes, size)
+	}
+	return paths, sizes, nil
+}
+
+// sniffArchiveFormat tells an archive's format from its first bytes, or
+// returns "" if it isn't one
+func sniffArchiveFormat(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+
+	switch {
+	case len(head) >= 2 && head[0] == 'P' && head[1] == 'K':
+		return archiveZip
+	case len(head) >= 2 && head[0] == 0x1f && head[1] == 0x8b:
+		return archiveTarGz
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return archiveTar
+	}
+	return ""
+}
+
+// pruneChunkUploads removes the chunks of uploads left incomplete for
+// longer than chunkUploadTTL
+func pruneChunkUploads() {
+	dirs, _ := filepath.Glob(chunkDir("*"))
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err == nil && time.Since(info.ModTime()) > chunkUploadTTL {
+			os.RemoveAll(dir)
+		}
+	}
+}
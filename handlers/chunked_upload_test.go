@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"static-site-hosting/models"
+)
+
+func putChunk(t *testing.T, db *sql.DB, id string, n int, chunk []byte) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPut, "/uploads/"+id+"/chunks/"+strconv.Itoa(n), bytes.NewReader(chunk))
+	rr := httptest.NewRecorder()
+	UploadChunkHandler(rr, req, db)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("chunk %d: expected status 201, got %d: %s", n, rr.Code, rr.Body.String())
+	}
+}
+
+func completeUpload(db *sql.DB, id, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/uploads/"+id+"/complete"+query, nil)
+	rr := httptest.NewRecorder()
+	CompleteUploadHandler(rr, req, db)
+	return rr
+}
+
+func TestChunkedUpload(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	archive := createTestTarGz(t)
+	third := len(archive) / 3
+	chunks := [][]byte{archive[:third], archive[third : 2*third], archive[2*third:]}
+
+	// Chunks can arrive in any order, and be resent
+	putChunk(t, db, "big-build", 3, chunks[2])
+	putChunk(t, db, "big-build", 1, []byte("garbage"))
+	putChunk(t, db, "big-build", 1, chunks[0])
+	if rr := completeUpload(db, "big-build", "?site=docs"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 while chunk 2 is missing, got %d", rr.Code)
+	}
+	putChunk(t, db, "big-build", 2, chunks[1])
+
+	rr := completeUpload(db, "big-build", "?site=docs")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var d models.Deployment
+	json.NewDecoder(rr.Body).Decode(&d)
+	if d.Site != "docs" || d.Filename != "docs.tar.gz" {
+		t.Errorf("expected a tar.gz deployment of docs, got %+v", d)
+	}
+	if _, err := os.Stat(filepath.Join(d.Path, "index.html")); err != nil {
+		t.Error("expected the joined archive to be extracted")
+	}
+	if _, err := os.Stat(chunkDir("big-build")); !os.IsNotExist(err) {
+		t.Error("expected the chunks to be removed")
+	}
+	if _, p := getProgress(t, "big-build"); p.State != UploadComplete || p.DeploymentID != d.ID {
+		t.Errorf("expected the upload to be complete, got %+v", p)
+	}
+
+	if rr := completeUpload(db, "big-build", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 once the chunks are deployed, got %d", rr.Code)
+	}
+}
+
+func TestChunkedUploadZipVolumes(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	volumes, asset := testSplitZip(t)
+	for i, v := range volumes {
+		putChunk(t, db, "split-build", i+1, v)
+	}
+	rr := completeUpload(db, "split-build", "?filename=site.zip")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var d models.Deployment
+	json.NewDecoder(rr.Body).Decode(&d)
+	if d.Filename != "site.zip" {
+		t.Errorf("expected the filename parameter to name the deployment, got %q", d.Filename)
+	}
+	if got, _ := os.ReadFile(filepath.Join(d.Path, "assets", "data.bin")); !bytes.Equal(got, asset) {
+		t.Error("expected each chunk to be joined as a volume")
+	}
+}
+
+func TestChunkedUploadSurvivesRestart(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.SpoolDir = t.TempDir()
+
+	archive := createTestTarGz(t)
+	third := len(archive) / 3
+	putChunk(t, db, "resumed-build", 1, archive[:third])
+	putChunk(t, db, "resumed-build", 3, archive[2*third:])
+
+	// A restart forgets in-flight uploads, and may move the spool
+	uploads.Lock()
+	uploads.byID = map[string]*uploadTracker{}
+	uploads.Unlock()
+	cfg.SpoolDir = t.TempDir()
+
+	rr := httptest.NewRecorder()
+	UploadSessionHandler(rr, httptest.NewRequest(http.MethodGet, "/uploads/resumed-build", nil), db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var session UploadSession
+	json.NewDecoder(rr.Body).Decode(&session)
+	if len(session.Chunks) != 2 || session.Chunks[0].Offset != 0 || session.Chunks[1].Offset != -1 {
+		t.Errorf("expected chunks 1 and 3 with only the first offset known, got %+v", session.Chunks)
+	}
+	if len(session.Missing) != 1 || session.Missing[0] != 2 {
+		t.Errorf("expected chunk 2 to be missing, got %v", session.Missing)
+	}
+
+	// Chunks sent before the restart are deployed with those sent after
+	putChunk(t, db, "resumed-build", 2, archive[third:2*third])
+	if p := lookupUpload("resumed-build").snapshot(); p.BytesReceived != int64(len(archive)) {
+		t.Errorf("expected progress to count the chunks from before the restart, got %d of %d bytes", p.BytesReceived, len(archive))
+	}
+	rr = completeUpload(db, "resumed-build", "?site=docs")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if entries, _ := os.ReadDir(cfg.SpoolDir); len(entries) != 0 {
+		t.Errorf("expected no chunks in the new spool directory, got %d entries", len(entries))
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM upload_chunks").Scan(&count)
+	if count != 0 {
+		t.Errorf("expected the completed upload to be forgotten, got %d chunks", count)
+	}
+}
+
+func TestChunkedUploadsAreIsolatedByOwner(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	req := httptest.NewRequest(http.MethodPut, "/uploads/alices-build/chunks/1", bytes.NewReader([]byte("chunk")))
+	rr := httptest.NewRecorder()
+	UploadChunkHandler(rr, as(req, aliceClaims), db)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	defer os.RemoveAll(chunkDir("alices-build"))
+
+	req = httptest.NewRequest(http.MethodPut, "/uploads/alices-build/chunks/2", bytes.NewReader([]byte("chunk")))
+	rr = httptest.NewRecorder()
+	UploadChunkHandler(rr, as(req, bobClaims), db)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected bob's chunk for alice's upload to be 404, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	UploadSessionHandler(rr, as(httptest.NewRequest(http.MethodGet, "/uploads/alices-build", nil), bobClaims), db)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected alice's upload to be hidden from bob, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	CompleteUploadHandler(rr, as(httptest.NewRequest(http.MethodPost, "/uploads/alices-build/complete", nil), bobClaims), db)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected bob's completion of alice's upload to be 404, got %d", rr.Code)
+	}
+}
+
+func TestChunkedUploadSizeLimitCoversEveryChunk(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll(chunkDir("capped"))
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.UploadMaxBytes = 100
+
+	put := func(n int, size int, length int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/uploads/capped/chunks/"+strconv.Itoa(n), bytes.NewReader(make([]byte, size)))
+		req.ContentLength = length
+		rr := httptest.NewRecorder()
+		UploadChunkHandler(rr, req, db)
+		return rr
+	}
+	if rr := put(1, 60, 60); rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr := put(2, 60, 60)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected a chunk taking the upload over the limit to be refused, got %d", rr.Code)
+	}
+	assertTooLarge(t, rr, 100)
+	if rr := put(2, 60, -1); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 while streaming, got %d", rr.Code)
+	}
+	// Resending a chunk replaces it, so only the chunks kept count
+	if rr := put(1, 90, 90); rr.Code != http.StatusCreated {
+		t.Errorf("expected a resent chunk to replace the first, got %d", rr.Code)
+	}
+	if rr := put(2, 10, 10); rr.Code != http.StatusCreated {
+		t.Errorf("expected a chunk within the limit to be kept, got %d", rr.Code)
+	}
+	if recorded, _ := recordedChunks(db, "capped"); recorded[1] != 90 || recorded[2] != 10 {
+		t.Errorf("expected only chunks within the limit to be recorded, got %v", recorded)
+	}
+}
@@ -0,0 +1,11 @@
+package handlers
+
+import "static-site-hosting/config"
+
+// cfg holds the server configuration used by handlers
+var cfg = config.Default()
+
+// Configure replaces the configuration used by handlers
+func Configure(c *config.Config) {
+	cfg = c
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"static-site-hosting/models"
+)
+
+func TestContentTypeRules(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	dir := filepath.Join("deployments", "apps-1")
+	os.MkdirAll(filepath.Join(dir, "releases"), 0755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>apps</h1>"), 0644)
+	os.WriteFile(filepath.Join(dir, "releases", "app.apk"), []byte("PK apk"), 0644)
+	os.WriteFile(filepath.Join(dir, "releases", "notes.txt"), []byte("notes"), 0644)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES ('apps-1', 'apps.zip', ?, ?, 'apps')", time.Now(), dir)
+
+	settings := models.SiteSettings{ContentTypes: []models.ContentTypeRule{
+		{Path: `\.apk$`, ContentType: "application/vnd.android.package-archive", Attachment: true},
+		{Path: "^/releases/", Attachment: true},
+		{Path: `\.txt$`, ContentType: "text/markdown"},
+	}}
+	if err := settings.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveSiteSettings(db, "apps-1", settings); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := StaticFileHandler(db)
+	get := func(url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+		return rr
+	}
+
+	rr := get("/apps-1/releases/app.apk")
+	if ct := rr.Header().Get("Content-Type"); ct != "application/vnd.android.package-archive" {
+		t.Errorf("expected the apk's content type, got %q", ct)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); cd != `attachment; filename=app.apk` {
+		t.Errorf("expected the apk to be downloaded, got %q", cd)
+	}
+
+	// The first matching rule applies: notes are downloaded as themselves
+	rr = get("/apps-1/releases/notes.txt")
+	if ct := rr.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("expected the guessed content type, got %q", ct)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); cd != `attachment; filename=notes.txt` {
+		t.Errorf("expected the notes to be downloaded, got %q", cd)
+	}
+
+	rr = get("/apps-1/index.html")
+	if cd := rr.Header().Get("Content-Disposition"); rr.Code != http.StatusOK || cd != "" {
+		t.Errorf("expected pages to be displayed, got %d %q", rr.Code, cd)
+	}
+
+	invalid := models.SiteSettings{ContentTypes: []models.ContentTypeRule{
+		{Path: `\.pkg$`},
+		{Path: "(", ContentType: "application/octet-stream"},
+		{Path: `\.bin$`, ContentType: "not a type"},
+	}}
+	if errs, ok := invalid.Validate().(models.ValidationErrors); !ok || len(errs) != 3 {
+		t.Errorf("expected three validation errors, got %v", invalid.Validate())
+	}
+}
+
+func TestSiteCharset(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	dir := filepath.Join("deployments", "legacy-1")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>caf\xe9</h1>"), 0644)
+	os.WriteFile(filepath.Join(dir, "README"), []byte("plain caf\xe9 notes"), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.md"), []byte("# notes"), 0644)
+	os.WriteFile(filepath.Join(dir, "sjis.txt"), []byte("text"), 0644)
+	os.WriteFile(filepath.Join(dir, "logo.png"), []byte("\x89PNG\r\n\x1a\n"), 0644)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES ('legacy-1', 'legacy.zip', ?, ?, 'legacy')", time.Now(), dir)
+
+	settings := models.SiteSettings{
+		Charset: "windows-1252",
+		ContentTypes: []models.ContentTypeRule{
+			{Path: `\.md$`, ContentType: "text/markdown; charset=utf-8", Params: map[string]string{"variant": "GFM"}},
+			{Path: `^/sjis\.txt$`, Params: map[string]string{"charset": "shift_jis"}},
+		},
+	}
+	if err := settings.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveSiteSettings(db, "legacy-1", settings); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := StaticFileHandler(db)
+	for url, want := range map[string]string{
+		"/legacy-1/index.html": "text/html; charset=windows-1252",
+		"/legacy-1/README":     "text/plain; charset=windows-1252", // sniffed
+		"/legacy-1/notes.md":   "text/markdown; charset=utf-8; variant=GFM",
+		"/legacy-1/sjis.txt":   "text/plain; charset=shift_jis",
+		"/legacy-1/logo.png":   "image/png",
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+		if ct := rr.Header().Get("Content-Type"); ct != want {
+			t.Errorf("%s: expected %q, got %q", url, want, ct)
+		}
+	}
+
+	// Sniffing must leave the whole file to be served
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/legacy-1/README", nil))
+	if rr.Body.String() != "plain caf\xe9 notes" {
+		t.Errorf("expected the whole file, got %q", rr.Body.String())
+	}
+
+	invalid := models.SiteSettings{
+		Charset:      "utf 8",
+		ContentTypes: []models.ContentTypeRule{{Path: `\.txt$`, Params: map[string]string{"bad name": "x"}}},
+	}
+	if errs, ok := invalid.Validate().(models.ValidationErrors); !ok || len(errs) != 2 {
+		t.Errorf("expected two validation errors, got %v", invalid.Validate())
+	}
+}
@@ -4,11 +4,21 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 
+	"static-site-hosting/artifacts"
+	"static-site-hosting/events"
+	"static-site-hosting/immutable"
+	"static-site-hosting/integrity"
+	"static-site-hosting/locks"
 	"static-site-hosting/models"
+	"static-site-hosting/routecache"
+	"static-site-hosting/tombstone"
+	"static-site-hosting/usage"
+	"static-site-hosting/webhooks"
 )
 
 func DeleteDeploymentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
@@ -26,12 +36,30 @@ func DeleteDeploymentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB)
 	}
 	deploymentID := path
 
+	// ?wipe=true overwrites the files before unlinking them, for tenants
+	// that must show the data was destroyed
+	var wipe bool
+	if v := r.URL.Query().Get("wipe"); v != "" {
+		var err error
+		if wipe, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "wipe must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	unlock, ok := lockMutation(w, "delete", locks.Deployment(deploymentID))
+	if !ok {
+		return
+	}
+	defer unlock()
+
 	// Get deployment info before deleting
 	var deployment models.Deployment
-	err := db.QueryRow("SELECT id, filename, timestamp, path FROM deployments WHERE id = ?", deploymentID).
-		Scan(&deployment.ID, &deployment.Filename, &deployment.Timestamp, &deployment.Path)
+	err := db.QueryRow("SELECT id, filename, timestamp, path, site, owner_id FROM deployments WHERE id = ?", deploymentID).
+		Scan(&deployment.ID, &deployment.Filename, &deployment.Timestamp, &deployment.Path, &deployment.Site, &deployment.OwnerID)
 
-	if err == sql.ErrNoRows {
+	// Other owners' deployments are indistinguishable from missing ones
+	if err == sql.ErrNoRows || (err == nil && !ownsDeployment(r, deployment.OwnerID)) {
 		http.Error(w, "Deployment not found", http.StatusNotFound)
 		return
 	}
@@ -40,21 +68,96 @@ func DeleteDeploymentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB)
 		return
 	}
 
+	// Wiping files another deployment serves would destroy that one too
+	if wipe {
+		var shared int
+		if err := db.QueryRow("SELECT COUNT(*) FROM deployments WHERE path = ? AND id != ?", deployment.Path, deploymentID).Scan(&shared); err != nil {
+			http.Error(w, "Failed to fetch deployment", http.StatusInternalServerError)
+			return
+		}
+		if shared > 0 {
+			http.Error(w, "Deployment shares its files with other deployments; delete them first or delete without wipe", http.StatusConflict)
+			return
+		}
+	}
+
+	// Deleting the live deployment puts the one before it live
+	previousLive := liveDeploymentID(db, deployment.Site)
+
+	// Stop serving it before the record goes, whatever happens to its files
+	if err := tombstone.Add(db, deploymentID); err != nil {
+		tombstone.Remove(db, deploymentID)
+		http.Error(w, "Failed to delete from database", http.StatusInternalServerError)
+		return
+	}
+
 	// Delete from database
 	_, err = db.Exec("DELETE FROM deployments WHERE id = ?", deploymentID)
 	if err != nil {
+		tombstone.Remove(db, deploymentID)
 		http.Error(w, "Failed to delete from database", http.StatusInternalServerError)
 		return
 	}
+	routecache.Invalidate()
 
-	// Delete files from filesystem
-	if err := os.RemoveAll(deployment.Path); err != nil {
-		// Log error but don't fail the request since DB deletion succeeded
-		fmt.Printf("Warning: Failed to delete files at %s: %v\n", deployment.Path, err)
+	// Settings are keyed by site ID and would otherwise outlive the deployment
+	db.Exec("DELETE FROM site_settings WHERE site_id = ?", deploymentID)
+	db.Exec("DELETE FROM preload_hints WHERE deployment_id = ?", deploymentID)
+	db.Exec("DELETE FROM deployment_pins WHERE deployment_id = ?", deploymentID)
+	db.Exec("DELETE FROM deployment_passwords WHERE deployment_id = ?", deploymentID)
+	db.Exec("DELETE FROM expiry_notices WHERE deployment_id = ?", deploymentID)
+	db.Exec("DELETE FROM deployment_reports WHERE deployment_id = ?", deploymentID)
+	report := wipeReport{Method: "overwrite"}
+	if wipe {
+		report.Artifact, err = artifacts.Wipe(db, deploymentID)
+		if err != nil {
+			report.Error = "artifact: " + err.Error()
+		}
+	} else {
+		artifacts.Remove(db, deploymentID)
 	}
+	integrity.Remove(db, deploymentID)
+	usage.MarkDeleted(db, deploymentID)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	webhooks.Notify(db, webhooks.EventDeploymentDeleted, deployment, deployment.Site)
+	events.Record(db, events.DeploymentDeleted, deployment.ID, deployment.Site, map[string]any{"deployment": deployment})
+	notifyPromotion(db, deployment.Site, previousLive)
+
+	// Delete files from filesystem; aliased deployments share a directory,
+	// so only the last deployment using it removes the files
+	if wipe {
+		report.Files, report.Bytes, err = immutable.Wipe(deployment.Path)
+		if err != nil && report.Error == "" {
+			report.Error = err.Error()
+		}
+	} else if !deploymentFilesShared(db, deployment.Path) {
+		if err := immutable.RemoveAll(deployment.Path); err != nil {
+			// Log error but don't fail the request since DB deletion succeeded
+			fmt.Printf("Warning: Failed to delete files at %s: %v\n", deployment.Path, err)
+		}
+	}
+
+	response := map[string]any{
 		"message": fmt.Sprintf("Deployment %s (%s) deleted successfully", deploymentID, deployment.Filename),
-	})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if wipe {
+		response["wipe"] = report
+		if report.Error != "" {
+			// The records are gone, but the caller can't count the data destroyed
+			log.Printf("Warning: Failed to wipe deployment %s: %s", deploymentID, report.Error)
+			response["message"] = fmt.Sprintf("Deployment %s (%s) deleted, but wiping it failed", deploymentID, deployment.Filename)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// wipeReport tells a caller deleting with ?wipe=true what was overwritten
+type wipeReport struct {
+	Method   string `json:"method"`
+	Files    int    `json:"files"`
+	Bytes    int64  `json:"bytes"`
+	Artifact string `json:"artifact,omitempty"`
+	Error    string `json:"error,omitempty"`
 }
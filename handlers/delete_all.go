@@ -6,18 +6,97 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 
+	"static-site-hosting/artifacts"
+	"static-site-hosting/events"
+	"static-site-hosting/immutable"
+	"static-site-hosting/integrity"
 	"static-site-hosting/models"
+	"static-site-hosting/routecache"
+	"static-site-hosting/tombstone"
+	"static-site-hosting/usage"
+	"static-site-hosting/webhooks"
 )
 
+// confirmHeader must be "yes" on requests that delete every deployment
+const confirmHeader = "X-Confirm"
+
+// confirmDestructive checks a request to delete every deployment: it needs
+// an admin credential, not just ANONYMOUS_ROLE, and either ?dry_run=true or
+// X-Confirm: yes, so a stray request can't wipe the server. It reports
+// whether the request is a dry run and whether it may go ahead.
+func confirmDestructive(w http.ResponseWriter, r *http.Request) (dryRun, ok bool) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden: deleting every deployment requires an admin credential", http.StatusForbidden)
+		return false, false
+	}
+	if dryRun, _ = strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun {
+		return true, true
+	}
+	if r.Header.Get(confirmHeader) != "yes" {
+		http.Error(w, confirmHeader+": yes required to delete every deployment (or ?dry_run=true to preview)", http.StatusPreconditionRequired)
+		return false, false
+	}
+	return false, true
+}
+
+// previewDestructive answers a dry run with the deployments it would delete
+func previewDestructive(w http.ResponseWriter, db *sql.DB) {
+	rows, err := db.Query("SELECT id, filename, timestamp, path, site FROM deployments ORDER BY timestamp DESC")
+	if err != nil {
+		http.Error(w, "Failed to fetch deployments", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	deployments := []models.Deployment{}
+	for rows.Next() {
+		var d models.Deployment
+		if err := rows.Scan(&d.ID, &d.Filename, &d.Timestamp, &d.Path, &d.Site); err != nil {
+			http.Error(w, "Failed to scan deployment", http.StatusInternalServerError)
+			return
+		}
+		deployments = append(deployments, d)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Failed to fetch deployments", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":            "Dry run: nothing was deleted",
+		"dry_run":            true,
+		"would_delete_count": len(deployments),
+		"would_delete":       deployments,
+	})
+}
+
+// DeleteAllDeploymentsHandler deletes every deployment and its files.
+// Expected: DELETE /deployments with X-Confirm: yes, or ?dry_run=true
 func DeleteAllDeploymentsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	if r.Method != http.MethodDelete {
 		http.Error(w, "DELETE required", http.StatusMethodNotAllowed)
 		return
 	}
+	dryRun, ok := confirmDestructive(w, r)
+	if !ok {
+		return
+	}
+	if dryRun {
+		previewDestructive(w, db)
+		return
+	}
+
+	unlock, ok := lockAllMutations(w, "bulk delete")
+	if !ok {
+		return
+	}
+	defer unlock()
 
 	// Get all deployments before deleting
-	rows, err := db.Query("SELECT id, filename, timestamp, path FROM deployments")
+	rows, err := db.Query("SELECT id, filename, timestamp, path, site FROM deployments")
 	if err != nil {
 		http.Error(w, "Failed to fetch deployments", http.StatusInternalServerError)
 		return
@@ -29,7 +108,7 @@ func DeleteAllDeploymentsHandler(w http.ResponseWriter, r *http.Request, db *sql
 
 	for rows.Next() {
 		var d models.Deployment
-		err := rows.Scan(&d.ID, &d.Filename, &d.Timestamp, &d.Path)
+		err := rows.Scan(&d.ID, &d.Filename, &d.Timestamp, &d.Path, &d.Site)
 		if err != nil {
 			http.Error(w, "Failed to scan deployment", http.StatusInternalServerError)
 			return
@@ -49,12 +128,32 @@ func DeleteAllDeploymentsHandler(w http.ResponseWriter, r *http.Request, db *sql
 		return
 	}
 
+	// Stop serving them before the records go, whatever happens to the files
+	frozen, err := tombstone.AddAll(db)
+	if err != nil {
+		tombstone.RemoveAll(db, frozen)
+		http.Error(w, "Failed to delete deployments from database", http.StatusInternalServerError)
+		return
+	}
+
 	// Delete all deployments from database first
 	result, err := db.Exec("DELETE FROM deployments")
 	if err != nil {
+		tombstone.RemoveAll(db, frozen)
 		http.Error(w, "Failed to delete deployments from database", http.StatusInternalServerError)
 		return
 	}
+	routecache.Invalidate()
+
+	db.Exec("DELETE FROM site_settings")
+	db.Exec("DELETE FROM preload_hints")
+	db.Exec("DELETE FROM deployment_pins")
+	db.Exec("DELETE FROM deployment_passwords")
+	db.Exec("DELETE FROM expiry_notices")
+	db.Exec("DELETE FROM deployment_reports")
+	artifacts.Remove(db, "")
+	integrity.Remove(db, "")
+	usage.MarkDeleted(db, "")
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
@@ -62,11 +161,16 @@ func DeleteAllDeploymentsHandler(w http.ResponseWriter, r *http.Request, db *sql
 		return
 	}
 
+	for _, d := range deployments {
+		webhooks.Notify(db, webhooks.EventDeploymentDeleted, d, d.Site)
+		events.Record(db, events.DeploymentDeleted, d.ID, d.Site, map[string]any{"deployment": d})
+	}
+
 	// Delete all deployment directories from filesystem
 	var failedDeletions []string
 
 	for _, path := range pathsToDelete {
-		if err := os.RemoveAll(path); err != nil {
+		if err := immutable.RemoveAll(path); err != nil {
 			// Log error but continue with other deletions
 			fmt.Printf("Warning: Failed to delete directory %s: %v\n", path, err)
 			failedDeletions = append(failedDeletions, path)
@@ -92,12 +196,27 @@ func DeleteAllDeploymentsHandler(w http.ResponseWriter, r *http.Request, db *sql
 	json.NewEncoder(w).Encode(response)
 }
 
-// Alternative: Delete all deployments and reset the entire system
+// Alternative: Delete all deployments and reset the entire system.
+// Expected: POST /reset with X-Confirm: yes, or ?dry_run=true
 func ResetSystemHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "POST required", http.StatusMethodNotAllowed)
 		return
 	}
+	dryRun, ok := confirmDestructive(w, r)
+	if !ok {
+		return
+	}
+	if dryRun {
+		previewDestructive(w, db)
+		return
+	}
+
+	unlock, ok := lockAllMutations(w, "reset")
+	if !ok {
+		return
+	}
+	defer unlock()
 
 	// Get count before deletion
 	var count int
@@ -107,15 +226,36 @@ func ResetSystemHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	frozen, err := tombstone.AddAll(db)
+	if err != nil {
+		tombstone.RemoveAll(db, frozen)
+		http.Error(w, "Failed to clear database", http.StatusInternalServerError)
+		return
+	}
+
 	// Delete all from database
 	_, err = db.Exec("DELETE FROM deployments")
 	if err != nil {
+		tombstone.RemoveAll(db, frozen)
 		http.Error(w, "Failed to clear database", http.StatusInternalServerError)
 		return
 	}
+	routecache.Invalidate()
+	db.Exec("DELETE FROM site_settings")
+	db.Exec("DELETE FROM preload_hints")
+	db.Exec("DELETE FROM deployment_pins")
+	db.Exec("DELETE FROM deployment_passwords")
+	db.Exec("DELETE FROM expiry_notices")
+	db.Exec("DELETE FROM deployment_reports")
+	artifacts.Remove(db, "")
+	integrity.Remove(db, "")
+	usage.MarkDeleted(db, "")
+	for _, id := range frozen {
+		events.Record(db, events.DeploymentDeleted, id, "", nil)
+	}
 
 	// Remove entire deployments directory
-	err = os.RemoveAll("deployments")
+	err = immutable.RemoveAll("deployments")
 	if err != nil {
 		fmt.Printf("Warning: Failed to remove deployments directory: %v\n", err)
 	}
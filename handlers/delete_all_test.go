@@ -10,6 +10,9 @@ import (
 	"testing"
 	"time"
 
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -64,7 +67,8 @@ func TestDeleteAllDeploymentsHandler(t *testing.T) {
 	}
 
 	// Test delete all
-	req := httptest.NewRequest(http.MethodDelete, "/deployments", nil)
+	req := as(httptest.NewRequest(http.MethodDelete, "/deployments", nil), adminClaims)
+	req.Header.Set("X-Confirm", "yes")
 	rr := httptest.NewRecorder()
 
 	DeleteAllDeploymentsHandler(rr, req, db)
@@ -114,7 +118,8 @@ func TestDeleteAllDeploymentsHandlerEmpty(t *testing.T) {
 	defer db.Close()
 
 	// Test delete all when no deployments exist
-	req := httptest.NewRequest(http.MethodDelete, "/deployments", nil)
+	req := as(httptest.NewRequest(http.MethodDelete, "/deployments", nil), adminClaims)
+	req.Header.Set("X-Confirm", "yes")
 	rr := httptest.NewRecorder()
 
 	DeleteAllDeploymentsHandler(rr, req, db)
@@ -157,6 +162,64 @@ func TestDeleteAllDeploymentsHandlerInvalidMethod(t *testing.T) {
 	}
 }
 
+func TestDeleteAllRequiresConfirmation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES ('keep-1', 'site.zip', ?, 'deployments/keep-1', 'docs')", time.Now())
+
+	for _, tt := range []struct {
+		name    string
+		method  string
+		path    string
+		claims  *auth.Claims
+		confirm string
+		status  int
+	}{
+		{"anonymous delete", http.MethodDelete, "/deployments", nil, "yes", http.StatusForbidden},
+		{"deployer delete", http.MethodDelete, "/deployments", aliceClaims, "yes", http.StatusForbidden},
+		{"unconfirmed delete", http.MethodDelete, "/deployments", adminClaims, "", http.StatusPreconditionRequired},
+		{"misconfirmed delete", http.MethodDelete, "/deployments", adminClaims, "true", http.StatusPreconditionRequired},
+		{"anonymous reset", http.MethodPost, "/reset", nil, "yes", http.StatusForbidden},
+		{"unconfirmed reset", http.MethodPost, "/reset", adminClaims, "", http.StatusPreconditionRequired},
+		{"dry-run delete", http.MethodDelete, "/deployments?dry_run=true", adminClaims, "", http.StatusOK},
+		{"dry-run reset", http.MethodPost, "/reset?dry_run=1", adminClaims, "yes", http.StatusOK},
+	} {
+		req := as(httptest.NewRequest(tt.method, tt.path, nil), tt.claims)
+		if tt.confirm != "" {
+			req.Header.Set("X-Confirm", tt.confirm)
+		}
+		rr := httptest.NewRecorder()
+		if tt.method == http.MethodPost {
+			ResetSystemHandler(rr, req, db)
+		} else {
+			DeleteAllDeploymentsHandler(rr, req, db)
+		}
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, rr.Code, rr.Body.String())
+			continue
+		}
+		if rr.Code == http.StatusOK {
+			var preview struct {
+				DryRun      bool                `json:"dry_run"`
+				Count       int                 `json:"would_delete_count"`
+				WouldDelete []models.Deployment `json:"would_delete"`
+			}
+			json.NewDecoder(rr.Body).Decode(&preview)
+			if !preview.DryRun || preview.Count != 1 || len(preview.WouldDelete) != 1 || preview.WouldDelete[0].ID != "keep-1" {
+				t.Errorf("%s: expected a preview of keep-1, got %+v", tt.name, preview)
+			}
+		}
+	}
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM deployments").Scan(&count)
+	if count != 1 {
+		t.Errorf("expected nothing to be deleted, got %d deployments left", count)
+	}
+}
+
 func TestResetSystemHandler(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
@@ -178,7 +241,8 @@ func TestResetSystemHandler(t *testing.T) {
 	}
 
 	// Test system reset
-	req := httptest.NewRequest(http.MethodPost, "/reset", nil)
+	req := as(httptest.NewRequest(http.MethodPost, "/reset", nil), adminClaims)
+	req.Header.Set("X-Confirm", "yes")
 	rr := httptest.NewRecorder()
 
 	ResetSystemHandler(rr, req, db)
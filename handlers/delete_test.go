@@ -10,6 +10,10 @@ import (
 	"testing"
 	"time"
 
+	"static-site-hosting/artifacts"
+	"static-site-hosting/locks"
+	"static-site-hosting/tombstone"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -138,3 +142,135 @@ func TestDeleteDeploymentHandlerMissingID(t *testing.T) {
 		t.Error("expected 'Deployment ID required' error message")
 	}
 }
+
+func TestDeleteDeploymentConflictsWithRunningMutation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	testID := "test-locked-123"
+	testPath := filepath.Join("deployments", testID)
+	os.MkdirAll(testPath, 0755)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, ?, ?, ?)",
+		testID, "site.zip", time.Now(), testPath)
+
+	// Simulate a rollback copying this deployment
+	unlock, err := locks.Default.TryLock("rollback", locks.Deployment(testID))
+	if err != nil {
+		t.Fatalf("failed to lock deployment: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/deployments/"+testID, nil)
+	rr := httptest.NewRecorder()
+	DeleteDeploymentHandler(rr, req, db)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected status 409, got %d", rr.Code)
+	}
+	if _, err := os.Stat(testPath); err != nil {
+		t.Errorf("expected files to survive a rejected delete: %v", err)
+	}
+
+	unlock()
+	rr = httptest.NewRecorder()
+	DeleteDeploymentHandler(rr, req, db)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200 once the lock is released, got %d", rr.Code)
+	}
+}
+
+func TestDeletedDeploymentIsNotServed(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	testID := "test-tombstone-123"
+	testPath := filepath.Join("deployments", testID)
+	writeIndex := func(body string) {
+		os.MkdirAll(testPath, 0755)
+		if err := os.WriteFile(filepath.Join(testPath, "index.html"), []byte(body), 0644); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+	}
+	writeIndex("<html>test</html>")
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, 'site.zip', ?, ?)", testID, time.Now(), testPath)
+
+	handler := StaticFileHandler(db)
+	get := func() int {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/"+testID+"/index.html", nil))
+		return rr.Code
+	}
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("expected 200 before deletion, got %d", code)
+	}
+
+	rr := httptest.NewRecorder()
+	DeleteDeploymentHandler(rr, httptest.NewRequest(http.MethodDelete, "/deployments/"+testID, nil), db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Files outliving the record must not bring the deployment back
+	writeIndex("<html>leftover</html>")
+	if code := get(); code != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted deployment with files left behind, got %d", code)
+	}
+
+	// Reusing the ID serves it again
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, 'site.zip', ?, ?)", testID, time.Now(), testPath)
+	tombstone.Remove(db, testID)
+	if code := get(); code != http.StatusOK {
+		t.Errorf("expected 200 once the ID is deployed again, got %d", code)
+	}
+}
+
+func TestDeleteDeploymentWipe(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	saved := artifacts.Default
+	defer func() { artifacts.Default = saved }()
+	artifacts.Default = artifacts.NewMemory()
+
+	testPath := filepath.Join("deployments", "wipe-me")
+	os.MkdirAll(testPath, 0755)
+	os.WriteFile(filepath.Join(testPath, "index.html"), []byte("<html>secret</html>"), 0644)
+	for _, id := range []string{"wipe-me", "alias"} {
+		db.Exec("INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, 'site.zip', ?, ?)", id, time.Now(), testPath)
+	}
+	archive := filepath.Join(t.TempDir(), "site.zip")
+	os.WriteFile(archive, []byte("archive bytes"), 0644)
+	artifacts.Retain(db, "wipe-me", "zip", archive)
+
+	del := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		DeleteDeploymentHandler(rr, httptest.NewRequest(http.MethodDelete, "/deployments/wipe-me"+query, nil), db)
+		return rr
+	}
+	if rr := del("?wipe=maybe"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an invalid wipe value, got %d", rr.Code)
+	}
+	// The alias serves the same files
+	if rr := del("?wipe=true"); rr.Code != http.StatusConflict {
+		t.Fatalf("expected status 409 while the files are shared, got %d", rr.Code)
+	}
+	db.Exec("DELETE FROM deployments WHERE id = 'alias'")
+
+	rr := del("?wipe=true")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Wipe wipeReport `json:"wipe"`
+	}
+	json.NewDecoder(rr.Body).Decode(&response)
+	if response.Wipe != (wipeReport{Method: "overwrite", Files: 1, Bytes: 19, Artifact: "overwritten"}) {
+		t.Errorf("unexpected wipe report %+v", response.Wipe)
+	}
+	if _, err := os.Stat(testPath); !os.IsNotExist(err) {
+		t.Error("expected the files to be removed")
+	}
+	if _, err := artifacts.Lookup(db, "wipe-me"); err != artifacts.ErrNotFound {
+		t.Errorf("expected the artifact to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"static-site-hosting/models"
+)
+
+// remoteArchiveClient downloads the archives of /deploy/url. Redirects
+// must stay on HTTPS, and every connection, redirects' included, must be
+// to a public address. The check is made on the address dialled, after
+// the name is resolved, so DNS can't be rebound to the server's network.
+// No proxy is used, since it would connect on the client's behalf.
+var remoteArchiveClient = &http.Client{
+	Timeout: 5 * time.Minute,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 30 * time.Second, Control: refusePrivateAddress}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return errors.New("redirected to a non-HTTPS URL")
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	},
+}
+
+// errPrivateAddress refuses connections to the server's own networks
+var errPrivateAddress = errors.New("address is not public")
+
+// refusePrivateAddress is a net.Dialer Control hook refusing to connect
+// anywhere but a public address
+func refusePrivateAddress(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil || !publicAddress(addrPort.Addr()) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// nonPublicPrefixes are ranges netip doesn't classify that still aren't
+// the internet: "this network", which Linux connects to itself, carrier-
+// grade NAT and NAT64, which reaches IPv4 addresses through IPv6
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// publicAddress reports whether addr is reachable on the internet rather
+// than loopback, private, link-local (cloud metadata services among them),
+// multicast or unspecified
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// remoteArchiveMaxBytes caps downloads for callers without an upload size
+// limit, so a URL can't stream an unbounded body onto the disk
+var remoteArchiveMaxBytes int64 = 1 << 30
+
+const errArchiveDownload = "Failed to download archive"
+
+// deployURLRequest is the body of POST /deploy/url
+type deployURLRequest struct {
+	URL          string `json:"url"`
+	Site         string `json:"site"`
+	DeploymentID string `json:"deployment_id"`
+}
+
+// DeployURLHandler deploys an archive downloaded from an HTTPS URL, so CI
+// systems that publish builds to object storage can deploy them with a
+// small JSON request instead of uploading them again.
+// Expected: POST /deploy/url {"url": "https://...", "site": "docs"}
+//
+// The download is held to the caller's upload size limit, or 1 GiB when
+// there is none, and must be served as a zip, tar or tar.gz; object stores
+// that answer with application/octet-stream are taken at the URL's file
+// extension.
+func DeployURLHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	verified, problem := verifyUploadSignature(r)
+	if problem != "" {
+		http.Error(w, problem, http.StatusUnauthorized)
+		return
+	}
+	var req deployURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	// Nothing is downloaded for a request that isn't signed
+	if err := verified(); err != nil {
+		http.Error(w, errUploadSignature, http.StatusUnauthorized)
+		return
+	}
+	var errs models.ValidationErrors
+	source, err := url.Parse(req.URL)
+	switch {
+	case req.URL == "":
+		errs.Add("url", models.CodeMissing, "url required")
+	case err != nil || source.Host == "":
+		errs.Add("url", models.CodeInvalid, "url must be an absolute URL")
+	case source.Scheme != "https":
+		errs.Add("url", models.CodeUnsupported, "url must use HTTPS")
+	}
+	if req.Site != "" {
+		errs.Include(siteSlugError(req.Site))
+	}
+	errs.Include(deploymentIDError(req.DeploymentID))
+	if len(errs) > 0 {
+		writeFieldErrors(w, http.StatusBadRequest, errs...)
+		return
+	}
+	// Nothing is downloaded for a caller who couldn't deploy it
+	if !authorizeSiteDeploy(w, r, db, req.Site, nil) {
+		return
+	}
+
+	started := time.Now()
+	download, err := http.NewRequestWithContext(r.Context(), http.MethodGet, source.String(), nil)
+	if err != nil {
+		http.Error(w, "Invalid archive URL", http.StatusBadRequest)
+		return
+	}
+	// Callers aren't told why a download failed, so the handler can't be
+	// used to probe what answers where
+	resp, err := remoteArchiveClient.Do(download)
+	if err != nil {
+		log.Printf("Failed to download archive from %s: %v", source.Host, err)
+		http.Error(w, errArchiveDownload, http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Failed to download archive from %s: answered %s", source.Host, resp.Status)
+		http.Error(w, errArchiveDownload, http.StatusBadGateway)
+		return
+	}
+
+	format := remoteArchiveFormat(resp)
+	if format == "" {
+		writeFieldErrors(w, http.StatusUnsupportedMediaType, models.FieldError{
+			Field:   "url",
+			Code:    models.CodeUnsupported,
+			Message: "url must serve a zip, tar or tar.gz archive",
+		})
+		return
+	}
+	limit := uploadPolicy(r, db).MaxBytes
+	if limit <= 0 {
+		limit = remoteArchiveMaxBytes
+	}
+	if resp.ContentLength > limit {
+		writeUploadTooLarge(w, limit, nil)
+		return
+	}
+	body := http.MaxBytesReader(nil, resp.Body, limit)
+
+	progress := startUpload(uploadID(r), resp.ContentLength)
+	w.Header().Set("X-Upload-Id", progress.id)
+
+	name := req.Site
+	if name == "" {
+		name = "site"
+	}
+	filename := path.Base(resp.Request.URL.Path)
+	if filename == "." || filename == "/" {
+		filename = name + "." + format
+	}
+	deployRawArchive(w, r, db, progress.body(body), nil, format, req.Site, req.DeploymentID, filename, progress, started)
+}
+
+// remoteArchiveFormat tells the format of a downloaded archive from its
+// Content-Type, or for generic binary types from its URL's extension, or
+// returns "" when it isn't an archive
+func remoteArchiveFormat(resp *http.Response) string {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if format, ok := archiveFormats[mediaType]; ok {
+		return format
+	}
+	switch mediaType {
+	case "application/octet-stream", "binary/octet-stream", "":
+	default:
+		return ""
+	}
+	name := strings.ToLower(resp.Request.URL.Path)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return archiveZip
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveTarGz
+	case strings.HasSuffix(name, ".tar"):
+		return archiveTar
+	}
+	return ""
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+)
+
+// withArchiveServer serves archive at /site.tar.gz and other responses for
+// the tests of /deploy/url, trusted and, though it listens on loopback,
+// dialled by remoteArchiveClient until cleanup
+func withArchiveServer(t *testing.T, archive []byte) *httptest.Server {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/site.tar.gz":
+			w.Header().Set("Content-Type", "application/gzip")
+		case "/build/site.tgz":
+			w.Header().Set("Content-Type", "binary/octet-stream")
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+		case "/moved":
+			http.Redirect(w, r, "/site.tar.gz", http.StatusFound)
+			return
+		case "/metadata":
+			http.Redirect(w, r, "https://169.254.169.254/latest/meta-data/", http.StatusFound)
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(archive)
+	}))
+	t.Cleanup(srv.Close)
+
+	transport := srv.Client().Transport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Control: func(network, address string, c syscall.RawConn) error {
+		if address == srv.Listener.Addr().String() {
+			return nil
+		}
+		return refusePrivateAddress(network, address, c)
+	}}).DialContext
+	client := *remoteArchiveClient
+	client.Transport = transport
+	saved := remoteArchiveClient
+	remoteArchiveClient = &client
+	t.Cleanup(func() { remoteArchiveClient = saved })
+	return srv
+}
+
+func deployURL(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/deploy/url", strings.NewReader(body)))
+	return rr
+}
+
+func TestDeployURL(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	srv := withArchiveServer(t, createTestTarGz(t))
+	handler := func(w http.ResponseWriter, r *http.Request) { DeployURLHandler(w, r, db) }
+
+	for _, p := range []string{"/site.tar.gz", "/build/site.tgz", "/moved"} {
+		rr := deployURL(handler, `{"url": "`+srv.URL+p+`", "site": "docs"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d. Response: %s", p, rr.Code, rr.Body.String())
+		}
+		var d models.Deployment
+		json.NewDecoder(rr.Body).Decode(&d)
+		if d.Site != "docs" || d.ArchiveSHA256 == "" {
+			t.Errorf("%s: unexpected deployment %+v", p, d)
+		}
+		if _, err := os.Stat(filepath.Join(d.Path, "css", "style.css")); err != nil {
+			t.Errorf("%s: expected the archive to be extracted: %v", p, err)
+		}
+	}
+
+	for body, want := range map[string]int{
+		`{"site": "docs"}`: http.StatusBadRequest,
+		`{"url": "` + strings.Replace(srv.URL, "https:", "http:", 1) + `/site.tar.gz"}`: http.StatusBadRequest,
+		`{"url": "` + srv.URL + `/page.html"}`:                                          http.StatusUnsupportedMediaType,
+		`{"url": "` + srv.URL + `/missing.zip"}`:                                        http.StatusBadGateway,
+		`{"url": "` + srv.URL + `/metadata"}`:                                           http.StatusBadGateway,
+	} {
+		if rr := deployURL(handler, body); rr.Code != want {
+			t.Errorf("%s: expected status %d, got %d. Response: %s", body, want, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestDeployURLRefusesPrivateAddresses(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	var fetched atomic.Int32
+	internal := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched.Add(1)
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Write([]byte("internal"))
+	}))
+	defer internal.Close()
+	handler := func(w http.ResponseWriter, r *http.Request) { DeployURLHandler(w, r, db) }
+
+	for _, u := range []string{internal.URL + "/site.tar.gz", "https://169.254.169.254/latest/meta-data/", "https://[::1]:1/site.tar.gz"} {
+		rr := deployURL(handler, `{"url": "`+u+`"}`)
+		if rr.Code != http.StatusBadGateway || strings.TrimSpace(rr.Body.String()) != errArchiveDownload {
+			t.Errorf("%s: expected a bare 502, got %d %q", u, rr.Code, rr.Body.String())
+		}
+	}
+	if fetched.Load() != 0 {
+		t.Errorf("expected nothing to be fetched from loopback, got %d requests", fetched.Load())
+	}
+}
+
+func TestDeployURLAuthorizesBeforeDownloading(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	srv := withArchiveServer(t, createTestTarGz(t))
+	var fetched atomic.Int32
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { fetched.Add(1) })
+
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site, owner_id) VALUES ('bobs', 'site.zip', ?, 'deployments/bobs', 'docs', 'user:bob')", time.Now())
+	for name, claims := range map[string]*auth.Claims{
+		"other owner":      aliceClaims,
+		"other site token": {Subject: "user:bob", Role: auth.RoleDeployer, Site: "blog"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/deploy/url", strings.NewReader(`{"url": "`+srv.URL+`/site.tar.gz", "site": "docs"}`))
+		rr := httptest.NewRecorder()
+		DeployURLHandler(rr, as(req, claims), db)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%s: expected status 403, got %d: %s", name, rr.Code, rr.Body.String())
+		}
+	}
+	if fetched.Load() != 0 {
+		t.Errorf("expected nothing to be downloaded for callers who can't deploy, got %d requests", fetched.Load())
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.215.14":        true,
+		"2606:2800:21f:cb07::": true,
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"0.1.2.3":              false,
+		"0.0.0.0":              false,
+		"224.0.0.1":            false,
+		"::1":                  false,
+		"fd00::1":              false,
+		"fe80::1":              false,
+		"::ffff:127.0.0.1":     false,
+		"64:ff9b::a00:1":       false,
+	} {
+		if got := publicAddress(netip.MustParseAddr(addr)); got != public {
+			t.Errorf("%s: expected public %v, got %v", addr, public, got)
+		}
+	}
+}
+
+func TestDeployURLSizeLimit(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	cfg.UploadMaxBytes = 100
+	defer func() { cfg.UploadMaxBytes = 0 }()
+
+	srv := withArchiveServer(t, make([]byte, 1000))
+	handler := func(w http.ResponseWriter, r *http.Request) { DeployURLHandler(w, r, db) }
+	rr := deployURL(handler, `{"url": "`+srv.URL+`/site.tar.gz"}`)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+	assertTooLarge(t, rr, 100)
+}
+
+func TestDeployURLDefaultSizeLimit(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	// No upload size limit is configured by default
+	saved := remoteArchiveMaxBytes
+	remoteArchiveMaxBytes = 100
+	defer func() { remoteArchiveMaxBytes = saved }()
+
+	srv := withArchiveServer(t, make([]byte, 1000))
+	handler := func(w http.ResponseWriter, r *http.Request) { DeployURLHandler(w, r, db) }
+	rr := deployURL(handler, `{"url": "`+srv.URL+`/site.tar.gz"}`)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+	assertTooLarge(t, rr, 100)
+}
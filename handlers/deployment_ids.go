@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"static-site-hosting/config"
+	"static-site-hosting/locks"
+	"static-site-hosting/models"
+)
+
+// deploymentIDError explains why an upload can't use the deployment ID it
+// asked for, or returns nil. Asking for none is always fine.
+func deploymentIDError(requested string) *models.FieldError {
+	if requested == "" {
+		return nil
+	}
+	if cfg.DeploymentIDs != config.DeploymentIDsContent {
+		return &models.FieldError{Field: "deployment_id", Code: models.CodeDisabled, Message: "Client-supplied deployment IDs are not enabled"}
+	}
+	if !models.ValidDeploymentID(requested) {
+		return &models.FieldError{Field: "deployment_id", Code: models.CodeInvalid, Message: "Invalid deployment ID"}
+	}
+	return nil
+}
+
+// contentDeploymentID derives a deployment ID from the site and the archive
+// hash, so redeploying the same archive to the same site yields the same ID
+func contentDeploymentID(site, archiveHash string) string {
+	sum := sha256.Sum256([]byte(site + "\x00" + archiveHash))
+	return hex.EncodeToString(sum[:16])
+}
+
+// assignDeploymentID picks the ID for an upload once its archive hash is
+// known. Callers who may not deploy to site are refused first. In random
+// mode the ID is stagingID, the UUID the upload was received under, after
+// the usual duplicate detection. Deterministic IDs (requested, or derived
+// from the content) are locked for the upload; if the ID already exists the
+// upload is answered from that deployment when it holds the same archive
+// and the caller owns it, and refused with 409 otherwise. handled reports
+// that a response was written; otherwise release must be called once the
+// deployment is published.
+func assignDeploymentID(w http.ResponseWriter, r *http.Request, db *sql.DB, stagingID, requested, site, archiveHash, filename string, progress *uploadTracker, started time.Time) (id string, release func(), handled bool) {
+	// Before any existing deployment is handed out or aliased, so the same
+	// bytes can't deploy to, or reveal, a site the caller may not touch,
+	// nor be deployed unsigned
+	if err := checkUploadSignature(r); err != nil {
+		progress.fail(errUploadSignature)
+		http.Error(w, errUploadSignature, http.StatusUnauthorized)
+		return "", nil, true
+	}
+	if !authorizeSiteDeploy(w, r, db, site, progress) {
+		return "", nil, true
+	}
+	if cfg.DeploymentIDs != config.DeploymentIDsContent {
+		if respondIfDuplicate(w, r, db, site, archiveHash, filename, progress, started) {
+			return "", nil, true
+		}
+		return stagingID, func() {}, false
+	}
+
+	id = requested
+	if id == "" {
+		id = contentDeploymentID(site, archiveHash)
+	}
+	unlock, ok := lockMutation(w, "upload", locks.Deployment(id))
+	if !ok {
+		progress.fail("Conflicting operation in progress")
+		return "", nil, true
+	}
+
+	var existing models.Deployment
+	err := db.QueryRow(
+		"SELECT id, filename, timestamp, path, site, archive_sha256, status, owner_id, deployed_by, visibility FROM deployments WHERE id = ?", id,
+	).Scan(&existing.ID, &existing.Filename, &existing.Timestamp, &existing.Path, &existing.Site, &existing.ArchiveSHA256, &existing.Status, &existing.OwnerID, &existing.DeployedBy, &existing.Visibility)
+	if err == sql.ErrNoRows {
+		return id, unlock, false
+	}
+	unlock()
+	if err != nil {
+		progress.fail("Failed to check deployment ID")
+		http.Error(w, "Failed to check deployment ID", http.StatusInternalServerError)
+		return "", nil, true
+	}
+
+	if !ownsDeployment(r, existing.OwnerID) {
+		msg := fmt.Sprintf("Deployment ID %q is already in use", id)
+		progress.fail(msg)
+		http.Error(w, msg, http.StatusConflict)
+		return "", nil, true
+	}
+	if existing.Site != site || existing.ArchiveSHA256 != archiveHash {
+		msg := fmt.Sprintf("Deployment ID %q is already used by different content", id)
+		progress.fail(msg)
+		http.Error(w, msg, http.StatusConflict)
+		return "", nil, true
+	}
+	// Same content under the same ID: the redeploy is a no-op
+	progress.complete(existing.ID)
+	if existing.Status == models.StatusReady {
+		withURLs(r, &existing)
+	}
+	w.Header().Set("X-Duplicate-Of", existing.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(existing)
+	return "", nil, true
+}
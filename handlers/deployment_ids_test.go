@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"static-site-hosting/config"
+	"static-site-hosting/models"
+)
+
+func TestContentDerivedDeploymentIDs(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.DeploymentIDs = config.DeploymentIDsContent
+
+	// createTestTarGz orders entries randomly, so build the archive once
+	archive := createTestTarGz(t)
+	deploy := func(w http.ResponseWriter, r *http.Request) { SiteDeploymentsHandler(w, r, db) }
+	var first, second models.Deployment
+	rr := putArchive(deploy, "docs", "application/gzip", archive)
+	json.NewDecoder(rr.Body).Decode(&first)
+	if rr.Code != http.StatusOK || first.ID != contentDeploymentID("docs", first.ArchiveSHA256) {
+		t.Fatalf("expected a content-derived ID, got %d %+v", rr.Code, first)
+	}
+	if first.Path != filepath.Join("deployments", first.ID) {
+		t.Errorf("expected the staged files to be moved under the final ID, got %s", first.Path)
+	}
+	if _, err := os.Stat(filepath.Join(first.Path, "index.html")); err != nil {
+		t.Errorf("expected extracted files under the final ID: %v", err)
+	}
+
+	rr = putArchive(deploy, "docs", "application/gzip", archive)
+	json.NewDecoder(rr.Body).Decode(&second)
+	if rr.Code != http.StatusOK || second.ID != first.ID || rr.Header().Get("X-Duplicate-Of") != first.ID {
+		t.Errorf("expected the redeploy to return %s, got %d %+v", first.ID, rr.Code, second)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM deployments").Scan(&count)
+	if count != 1 {
+		t.Errorf("expected one deployment after an idempotent redeploy, got %d", count)
+	}
+	entries, _ := os.ReadDir("deployments")
+	if len(entries) != 1 {
+		t.Errorf("expected the redeploy's staged files to be removed, found %d directories", len(entries))
+	}
+
+	// The same archive on another site is a different deployment
+	rr = putArchive(deploy, "blog", "application/gzip", archive)
+	json.NewDecoder(rr.Body).Decode(&second)
+	if second.ID == first.ID {
+		t.Error("expected a different ID for another site")
+	}
+}
+
+func TestClientSuppliedDeploymentIDs(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	put := func(id, contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/sites/docs/deployments?deployment_id="+id, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		SiteDeploymentsHandler(rr, req, db)
+		return rr
+	}
+
+	if rr := put("release-1", "application/zip", testZipBytes(t)); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 while client IDs are disabled, got %d", rr.Code)
+	}
+
+	cfg.DeploymentIDs = config.DeploymentIDsContent
+	if rr := put("Release_1", "application/zip", testZipBytes(t)); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid ID, got %d", rr.Code)
+	}
+
+	rr := put("release-1", "application/zip", testZipBytes(t))
+	var d models.Deployment
+	json.NewDecoder(rr.Body).Decode(&d)
+	if rr.Code != http.StatusOK || d.ID != "release-1" {
+		t.Fatalf("expected deployment release-1, got %d %+v", rr.Code, d)
+	}
+
+	if rr := put("release-1", "application/gzip", createTestTarGz(t)); rr.Code != http.StatusConflict {
+		t.Errorf("expected 409 reusing the ID for different content, got %d", rr.Code)
+	}
+}
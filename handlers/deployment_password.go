@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+	"static-site-hosting/routecache"
+)
+
+// deploymentCredentials protect a deployment with HTTP Basic Auth. An empty
+// Username accepts any username with the shared password.
+type deploymentCredentials struct {
+	Username, Hash string
+}
+
+// verifiedPasswords remembers passwords that matched a hash, so visitors
+// don't pay for a slow hash on every file they load
+var verifiedPasswords sync.Map // sha256(hash, password) -> struct{}
+
+// DeploymentPasswordHandler reports (GET), sets (PUT) or removes (DELETE)
+// the password visitors must give before any file of a deployment is
+// served. Later deployments of the same site keep it.
+// Expected: PUT /deployments/{id}/password {"username": "staging", "password": "..."}
+func DeploymentPasswordHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	deploymentID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/deployments/"), "/password")
+
+	var ownerID string
+	err := db.QueryRow("SELECT owner_id FROM deployments WHERE id = ?", deploymentID).Scan(&ownerID)
+	if err == sql.ErrNoRows || err == nil && !ownsDeployment(r, ownerID) {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch deployment", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		creds, err := loadDeploymentCredentials(db, deploymentID)
+		if err != nil && err != sql.ErrNoRows {
+			http.Error(w, "Failed to fetch password", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"deployment_id": deploymentID,
+			"protected":     err == nil,
+			"username":      creds.Username,
+		})
+
+	case http.MethodPut:
+		var req struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		var errs models.ValidationErrors
+		if strings.Contains(req.Username, ":") {
+			errs.Add("username", models.CodeInvalid, "username can't contain a colon")
+		}
+		if req.Password == "" {
+			errs.Add("password", models.CodeMissing, "password is required")
+		}
+		if len(errs) > 0 {
+			writeFieldErrors(w, http.StatusBadRequest, errs...)
+			return
+		}
+		hash, err := auth.HashPassword(req.Password)
+		if err != nil {
+			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			return
+		}
+		_, err = db.Exec(
+			`INSERT INTO deployment_passwords (deployment_id, username, password_hash, updated_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT(deployment_id) DO UPDATE SET username = excluded.username, password_hash = excluded.password_hash, updated_at = excluded.updated_at`,
+			deploymentID, req.Username, hash, time.Now().UTC(),
+		)
+		if err != nil {
+			http.Error(w, "Failed to save password", http.StatusInternalServerError)
+			return
+		}
+		routecache.Invalidate()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"deployment_id": deploymentID, "protected": true, "username": req.Username})
+
+	case http.MethodDelete:
+		if _, err := db.Exec("DELETE FROM deployment_passwords WHERE deployment_id = ?", deploymentID); err != nil {
+			http.Error(w, "Failed to remove password", http.StatusInternalServerError)
+			return
+		}
+		routecache.Invalidate()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"deployment_id": deploymentID, "protected": false})
+
+	default:
+		http.Error(w, "GET, PUT or DELETE required", http.StatusMethodNotAllowed)
+	}
+}
+
+func loadDeploymentCredentials(db *sql.DB, deploymentID string) (deploymentCredentials, error) {
+	var creds deploymentCredentials
+	err := db.QueryRow("SELECT username, password_hash FROM deployment_passwords WHERE deployment_id = ?", deploymentID).Scan(&creds.Username, &creds.Hash)
+	return creds, err
+}
+
+// deploymentProtected returns the credentials protecting a deployment
+// through the routing cache, or ok false if it has none. A failed lookup,
+// including one during a database outage for a deployment the cache
+// doesn't know, is treated as a password no one knows.
+func deploymentProtected(db *sql.DB, deploymentID string) (deploymentCredentials, bool) {
+	type entry struct {
+		Creds     deploymentCredentials
+		Protected bool
+	}
+	found, err := routecache.Lookup("password:"+deploymentID, func() (entry, error) {
+		creds, err := loadDeploymentCredentials(db, deploymentID)
+		if err == sql.ErrNoRows {
+			return entry{}, nil
+		}
+		return entry{creds, err == nil}, err
+	})
+	if err != nil {
+		return deploymentCredentials{}, true
+	}
+	return found.Creds, found.Protected
+}
+
+// passwordAuthorized reports whether r carries Basic credentials matching
+// creds
+func passwordAuthorized(r *http.Request, creds deploymentCredentials) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok || creds.Hash == "" {
+		return false
+	}
+	if creds.Username != "" && subtle.ConstantTimeCompare([]byte(username), []byte(creds.Username)) != 1 {
+		return false
+	}
+	key := sha256.Sum256([]byte(creds.Hash + "\x00" + password))
+	if _, ok := verifiedPasswords.Load(key); ok {
+		return true
+	}
+	if !auth.CheckPassword(creds.Hash, password) {
+		return false
+	}
+	verifiedPasswords.Store(key, struct{}{})
+	return true
+}
+
+// challengePassword asks the browser for the deployment's password
+func challengePassword(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="Protected site", charset="UTF-8"`)
+	w.Header().Set("Cache-Control", "no-store")
+	http.Error(w, "Password required", http.StatusUnauthorized)
+}
+
+// inheritPassword protects a new deployment of a site with the password of
+// its latest deployment, if it has one, so redeploying a staging site doesn't
+// make it public. It reports whether it did.
+func inheritPassword(db *sql.DB, deployment *models.Deployment) (bool, error) {
+	if deployment.Site == "" {
+		return false, nil
+	}
+	result, err := db.Exec(`INSERT OR IGNORE INTO deployment_passwords (deployment_id, username, password_hash, updated_at)
+		SELECT ?, username, password_hash, ? FROM deployment_passwords WHERE deployment_id =
+			(SELECT id FROM deployments WHERE site = ? AND id != ? ORDER BY timestamp DESC LIMIT 1)`,
+		deployment.ID, time.Now().UTC(), deployment.Site, deployment.ID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/config"
+	"static-site-hosting/models"
+)
+
+func TestDeploymentPassword(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	fastPasswords(t)
+
+	dir := filepath.Join("deployments", "staging-1")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("staging"), 0644)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site, owner_id) VALUES ('staging-1', 'staging.zip', ?, ?, 'staging', ?)", time.Now(), dir, requestOwner(as(httptest.NewRequest(http.MethodGet, "/", nil), aliceClaims)))
+
+	password := func(method string, body any, claims *auth.Claims) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		rr := httptest.NewRecorder()
+		DeploymentPasswordHandler(rr, as(httptest.NewRequest(method, "/deployments/staging-1/password", bytes.NewReader(data)), claims), db)
+		return rr
+	}
+	get := func(username, pass string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/staging-1/index.html", nil)
+		if username != "" || pass != "" {
+			req.SetBasicAuth(username, pass)
+		}
+		rr := httptest.NewRecorder()
+		StaticFileHandler(db).ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := get("", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected an unprotected deployment to be served, got %d", rr.Code)
+	}
+	if rr := password(http.MethodPut, map[string]string{"username": "qa", "password": "s3cret"}, bobClaims); rr.Code != http.StatusNotFound {
+		t.Errorf("expected bob not to see alice's deployment, got %d", rr.Code)
+	}
+	if rr := password(http.MethodPut, map[string]string{"username": "qa"}, aliceClaims); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected a missing password to be rejected, got %d", rr.Code)
+	}
+	if rr := password(http.MethodPut, map[string]string{"username": "qa", "password": "s3cret"}, aliceClaims); rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	for name, tt := range map[string]struct {
+		username, password string
+		status             int
+	}{
+		"no credentials": {"", "", http.StatusUnauthorized},
+		"wrong password": {"qa", "guess", http.StatusUnauthorized},
+		"wrong username": {"dev", "s3cret", http.StatusUnauthorized},
+		"right":          {"qa", "s3cret", http.StatusOK},
+		"right again":    {"qa", "s3cret", http.StatusOK},
+	} {
+		rr := get(tt.username, tt.password)
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", name, tt.status, rr.Code)
+		}
+		if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a Basic challenge", name)
+		}
+	}
+
+	// Redeploying the site keeps it protected
+	next := models.NewDeployment("staging-2", "staging.zip", dir)
+	next.Site = "staging"
+	if inherited, err := inheritPassword(db, next); err != nil || !inherited {
+		t.Fatalf("expected the next deployment to inherit the password, got %v, %v", inherited, err)
+	}
+	if creds, ok := deploymentProtected(db, "staging-2"); !ok || creds.Username != "qa" {
+		t.Errorf("expected staging-2 to be protected for qa, got %+v, %v", creds, ok)
+	}
+
+	if rr := password(http.MethodDelete, nil, aliceClaims); rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if rr := get("", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected the deployment to be public again, got %d", rr.Code)
+	}
+	var status struct {
+		Protected bool `json:"protected"`
+	}
+	json.NewDecoder(password(http.MethodGet, nil, aliceClaims).Body).Decode(&status)
+	if status.Protected {
+		t.Error("expected the deployment to report no password")
+	}
+}
+
+func TestNewDeploymentsKeepPassword(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	fastPasswords(t)
+	saved := cfg.DuplicateUploads
+	defer func() { cfg.DuplicateUploads = saved }()
+
+	alice := func(method, target string) *http.Request {
+		return as(httptest.NewRequest(method, target, nil), aliceClaims)
+	}
+	dir := filepath.Join("deployments", "locked-1")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("locked"), 0644)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site, owner_id) VALUES ('locked-1', 'locked.zip', ?, ?, 'locked', ?)",
+		time.Now().Add(-time.Hour), dir, requestOwner(alice(http.MethodGet, "/")))
+	data, _ := json.Marshal(map[string]string{"username": "qa", "password": "s3cret"})
+	rr := httptest.NewRecorder()
+	DeploymentPasswordHandler(rr, as(httptest.NewRequest(http.MethodPut, "/deployments/locked-1/password", bytes.NewReader(data)), aliceClaims), db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to set password: %d %s", rr.Code, rr.Body.String())
+	}
+	source, err := fetchDeployment(db, "locked-1")
+	if err != nil {
+		t.Fatalf("failed to fetch deployment: %v", err)
+	}
+
+	// Each way of putting a new deployment live must keep the site protected
+	for _, tt := range []struct {
+		name   string
+		deploy func() string
+	}{
+		{"rollback", func() string {
+			rr := httptest.NewRecorder()
+			RollbackHandler(rr, alice(http.MethodPost, "/rollback/locked-1"), db)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("rollback failed: %d %s", rr.Code, rr.Body.String())
+			}
+			return liveDeploymentID(db, "locked")
+		}},
+		{"revision", func() string {
+			d, _, err := createRevision(alice(http.MethodPatch, "/"), db, *source, "[PATCH] locked.zip", func(string) (int, error) {
+				return http.StatusOK, nil
+			})
+			if err != nil {
+				t.Fatalf("revision failed: %v", err)
+			}
+			return d.ID
+		}},
+		{"alias", func() string {
+			cfg.DuplicateUploads = config.DuplicateAlias
+			rr := httptest.NewRecorder()
+			handleDuplicateUpload(rr, alice(http.MethodPost, "/upload"), db, source, "locked.zip", startUpload("", -1), time.Now())
+			var d models.Deployment
+			json.NewDecoder(rr.Body).Decode(&d)
+			if rr.Code != http.StatusOK || d.ID == source.ID {
+				t.Fatalf("alias upload failed: %d %+v", rr.Code, d)
+			}
+			return d.ID
+		}},
+		{"import", func() string {
+			d, err := importDeployment(db, t.TempDir(), "locked", source.OwnerID, exportDeployment{ID: "bundled", Filename: "locked.zip", Timestamp: time.Now()})
+			if err != nil {
+				t.Fatalf("import failed: %v", err)
+			}
+			return d.ID
+		}},
+	} {
+		id := tt.deploy()
+		if creds, ok := deploymentProtected(db, id); !ok || creds.Username != "qa" {
+			t.Errorf("%s: expected deployment %s to keep the password, got %+v, %v", tt.name, id, creds, ok)
+		}
+	}
+}
+
+func TestFileRoutesRequirePassword(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	fastPasswords(t)
+
+	dir := filepath.Join("deployments", "guarded-1")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("guarded"), 0644)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site, owner_id) VALUES ('guarded-1', 'guarded.zip', ?, ?, 'guarded', ?)", time.Now(), dir, requestOwner(as(httptest.NewRequest(http.MethodGet, "/", nil), aliceClaims)))
+	data, _ := json.Marshal(map[string]string{"username": "qa", "password": "s3cret"})
+	rr := httptest.NewRecorder()
+	DeploymentPasswordHandler(rr, as(httptest.NewRequest(http.MethodPut, "/deployments/guarded-1/password", bytes.NewReader(data)), aliceClaims), db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to set password: %d %s", rr.Code, rr.Body.String())
+	}
+
+	for name, target := range map[string]string{
+		"files list":  "/deployments/guarded-1/files",
+		"files read":  "/deployments/guarded-1/files/index.html",
+		"webdav read": "/dav/guarded-1/index.html",
+	} {
+		for _, tt := range []struct {
+			password string
+			status   int
+		}{{"", http.StatusUnauthorized}, {"wrong", http.StatusUnauthorized}, {"s3cret", http.StatusOK}} {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			if tt.password != "" {
+				req.SetBasicAuth("qa", tt.password)
+			}
+			rr := httptest.NewRecorder()
+			switch {
+			case strings.HasPrefix(target, "/dav/"):
+				WebDAVHandler(rr, req, db)
+			case strings.HasSuffix(target, "/files"):
+				DeploymentFilesHandler(rr, req, db)
+			default:
+				DeploymentFileHandler(rr, req, db)
+			}
+			if rr.Code != tt.status {
+				t.Errorf("%s with password %q: expected status %d, got %d", name, tt.password, tt.status, rr.Code)
+			}
+			if tt.status == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("%s: expected a Basic Auth challenge", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"static-site-hosting/atrest"
+	"static-site-hosting/models"
+)
+
+// largestChangesLimit caps how many of the biggest changes are summarised
+const largestChangesLimit = 10
+
+// FileChange is one file that differs between two deployments
+type FileChange struct {
+	Path      string `json:"path"`
+	Status    string `json:"status"` // added, removed or changed
+	OldSize   int64  `json:"old_size"`
+	NewSize   int64  `json:"new_size"`
+	SizeDelta int64  `json:"size_delta"`
+}
+
+// DiffSummary aggregates a diff so clients can print a one-line summary
+type DiffSummary struct {
+	Added          int          `json:"added"`
+	Removed        int          `json:"removed"`
+	Changed        int          `json:"changed"`
+	Unchanged      int          `json:"unchanged"`
+	SizeBefore     int64        `json:"size_before"`
+	SizeAfter      int64        `json:"size_after"`
+	SizeDelta      int64        `json:"size_delta"`
+	LargestChanges []FileChange `json:"largest_changes"`
+	Text           string       `json:"text"` // e.g. "+12 files, -3, ~4 changed, net +1.2MB"
+}
+
+// DeploymentDiff lists the changes from one deployment to another
+type DeploymentDiff struct {
+	From    string       `json:"from"`
+	To      string       `json:"to"`
+	Summary DiffSummary  `json:"summary"`
+	Changes []FileChange `json:"changes,omitempty"`
+}
+
+// DeploymentDiffHandler compares a deployment with an earlier one.
+// Expected: GET /deployments/{id}/diff?against={other-id}
+//
+// Without against, the deployment is compared with the previous deployment
+// of the same site. summary_only=true leaves out the per-file changes.
+func DeploymentDiffHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	toID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/deployments/"), "/diff")
+	to, err := fetchDeployment(db, toID)
+	if err == sql.ErrNoRows || err == nil && !ownsDeployment(r, to.OwnerID) {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch deployment", http.StatusInternalServerError)
+		return
+	}
+
+	var from *models.Deployment
+	if againstID := r.URL.Query().Get("against"); againstID != "" {
+		from, err = fetchDeployment(db, againstID)
+	} else {
+		from, err = previousSiteDeployment(db, to)
+	}
+	if err == sql.ErrNoRows || err == nil && !ownsDeployment(r, from.OwnerID) {
+		http.Error(w, "Deployment to compare against not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch deployment", http.StatusInternalServerError)
+		return
+	}
+
+	diff, err := diffTrees(from.Path, to.Path)
+	if err != nil {
+		http.Error(w, "Failed to compare deployments", http.StatusInternalServerError)
+		return
+	}
+	diff.From = from.ID
+	diff.To = to.ID
+	if r.URL.Query().Get("summary_only") == "true" {
+		diff.Changes = nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+func fetchDeployment(db *sql.DB, id string) (*models.Deployment, error) {
+	var d models.Deployment
+	err := db.QueryRow("SELECT id, filename, timestamp, path, site, owner_id FROM deployments WHERE id = ?", id).
+		Scan(&d.ID, &d.Filename, &d.Timestamp, &d.Path, &d.Site, &d.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// previousSiteDeployment returns the live deployment of d's site created
+// just before d, or sql.ErrNoRows
+func previousSiteDeployment(db *sql.DB, d *models.Deployment) (*models.Deployment, error) {
+	if d.Site == "" {
+		return nil, sql.ErrNoRows
+	}
+	var prev models.Deployment
+	err := db.QueryRow(
+		`SELECT id, filename, timestamp, path, site, owner_id FROM deployments
+		WHERE site = ? AND timestamp < ? AND status = 'ready' ORDER BY timestamp DESC LIMIT 1`,
+		d.Site, d.Timestamp,
+	).Scan(&prev.ID, &prev.Filename, &prev.Timestamp, &prev.Path, &prev.Site, &prev.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	return &prev, nil
+}
+
+// diffTrees compares two directory trees. Files of equal size are compared
+// byte for byte; anything else of differing size has changed.
+func diffTrees(fromDir, toDir string) (*DeploymentDiff, error) {
+	before, err := treeSizes(fromDir)
+	if err != nil {
+		return nil, err
+	}
+	after, err := treeSizes(toDir)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &DeploymentDiff{Changes: []FileChange{}}
+	s := &diff.Summary
+	for p, size := range after {
+		s.SizeAfter += size
+		oldSize, existed := before[p]
+		switch {
+		case !existed:
+			diff.Changes = append(diff.Changes, FileChange{Path: p, Status: "added", NewSize: size, SizeDelta: size})
+			s.Added++
+		case oldSize != size:
+			diff.Changes = append(diff.Changes, FileChange{Path: p, Status: "changed", OldSize: oldSize, NewSize: size, SizeDelta: size - oldSize})
+			s.Changed++
+		default:
+			same, err := sameContent(fromDir, toDir, p)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				s.Unchanged++
+			} else {
+				diff.Changes = append(diff.Changes, FileChange{Path: p, Status: "changed", OldSize: oldSize, NewSize: size})
+				s.Changed++
+			}
+		}
+	}
+	for p, size := range before {
+		s.SizeBefore += size
+		if _, ok := after[p]; !ok {
+			diff.Changes = append(diff.Changes, FileChange{Path: p, Status: "removed", OldSize: size, SizeDelta: -size})
+			s.Removed++
+		}
+	}
+	s.SizeDelta = s.SizeAfter - s.SizeBefore
+	sort.Slice(diff.Changes, func(i, j int) bool { return diff.Changes[i].Path < diff.Changes[j].Path })
+
+	largest := append([]FileChange(nil), diff.Changes...)
+	sort.SliceStable(largest, func(i, j int) bool { return abs64(largest[i].SizeDelta) > abs64(largest[j].SizeDelta) })
+	if len(largest) > largestChangesLimit {
+		largest = largest[:largestChangesLimit]
+	}
+	s.LargestChanges = largest
+	s.Text = fmt.Sprintf("+%d files, -%d, ~%d changed, net %s", s.Added, s.Removed, s.Changed, formatSizeDelta(s.SizeDelta))
+	return diff, nil
+}
+
+// treeSizes maps every file below root to its size
+func treeSizes(root string) (map[string]int64, error) {
+	sizes := map[string]int64{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size, err := atrest.PlainSize(root, p, info)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		sizes[filepath.ToSlash(rel)] = size
+		return nil
+	})
+	return sizes, err
+}
+
+// sameContent compares the file at rel, of equal size in both trees
+func sameContent(fromDir, toDir, rel string) (bool, error) {
+	fa, err := atrest.Open(fromDir, filepath.Join(fromDir, rel))
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := atrest.Open(toDir, filepath.Join(toDir, rel))
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufA := make([]byte, 32*1024)
+	bufB := make([]byte, 32*1024)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
+
+// formatSizeDelta renders a signed byte count, e.g. "+1.2MB" or "-340B"
+func formatSizeDelta(n int64) string {
+	sign := "+"
+	if n < 0 {
+		sign = "-"
+	}
+	v := math.Abs(float64(n))
+	for _, unit := range []string{"B", "KB", "MB", "GB"} {
+		if v < 1024 || unit == "GB" {
+			if unit == "B" {
+				return fmt.Sprintf("%s%d%s", sign, int64(v), unit)
+			}
+			return fmt.Sprintf("%s%.1f%s", sign, v, unit)
+		}
+		v /= 1024
+	}
+	return ""
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestDeploymentDiffAgainstPreviousSiteDeployment(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	oldPath := filepath.Join("deployments", "diff-old")
+	newPath := filepath.Join("deployments", "diff-new")
+	writeTree(t, oldPath, map[string]string{
+		"index.html": "<html>v1</html>",
+		"same.css":   "body{}",
+		"gone.js":    "console.log(1)",
+		"app.js":     strings.Repeat("a", 100),
+	})
+	writeTree(t, newPath, map[string]string{
+		"index.html":     "<html>v2</html>", // same size, different content
+		"same.css":       "body{}",
+		"app.js":         strings.Repeat("a", 2148),
+		"img/banner.png": strings.Repeat("p", 50),
+	})
+	now := time.Now()
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES (?, ?, ?, ?, ?)", "diff-old", "v1.zip", now.Add(-time.Hour), oldPath, "docs")
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES (?, ?, ?, ?, ?)", "diff-new", "v2.zip", now, newPath, "docs")
+
+	req := httptest.NewRequest(http.MethodGet, "/deployments/diff-new/diff", nil)
+	rr := httptest.NewRecorder()
+	DeploymentDiffHandler(rr, req, db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var diff DeploymentDiff
+	json.NewDecoder(rr.Body).Decode(&diff)
+	s := diff.Summary
+	if diff.From != "diff-old" || diff.To != "diff-new" {
+		t.Errorf("expected diff-old -> diff-new, got %s -> %s", diff.From, diff.To)
+	}
+	if s.Added != 1 || s.Removed != 1 || s.Changed != 2 || s.Unchanged != 1 {
+		t.Errorf("unexpected counts %+v", s)
+	}
+	if s.SizeDelta != 2148-100+50-14 {
+		t.Errorf("unexpected size delta %d", s.SizeDelta)
+	}
+	if len(s.LargestChanges) == 0 || s.LargestChanges[0].Path != "app.js" {
+		t.Errorf("expected app.js to be the largest change, got %+v", s.LargestChanges)
+	}
+	if s.Text != "+1 files, -1, ~2 changed, net +2.0KB" {
+		t.Errorf("unexpected summary text %q", s.Text)
+	}
+	if len(diff.Changes) != 4 {
+		t.Errorf("expected 4 file changes, got %d", len(diff.Changes))
+	}
+
+	// The first deployment of a site has nothing to compare against
+	req = httptest.NewRequest(http.MethodGet, "/deployments/diff-old/diff", nil)
+	rr = httptest.NewRecorder()
+	DeploymentDiffHandler(rr, req, db)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rr.Code)
+	}
+}
+
+func TestFormatSizeDelta(t *testing.T) {
+	tests := map[int64]string{
+		0:                "+0B",
+		-340:             "-340B",
+		1258291:          "+1.2MB",
+		-3 * 1024 * 1024: "-3.0MB",
+	}
+	for n, want := range tests {
+		if got := formatSizeDelta(n); got != want {
+			t.Errorf("formatSizeDelta(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
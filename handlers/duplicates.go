@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"static-site-hosting/config"
+	"static-site-hosting/events"
+	"static-site-hosting/integrity"
+	"static-site-hosting/locks"
+	"static-site-hosting/models"
+	"static-site-hosting/tombstone"
+	"static-site-hosting/usage"
+	"static-site-hosting/webhooks"
+
+	"github.com/google/uuid"
+)
+
+// findDuplicateUpload returns the newest deployment of site built from the
+// archive with the given hash whose files still exist and which the caller
+// owns, or nil
+func findDuplicateUpload(db *sql.DB, r *http.Request, site, archiveHash string) (*models.Deployment, error) {
+	var d models.Deployment
+	err := db.QueryRow(
+		`SELECT id, filename, timestamp, path, site, archive_sha256, owner_id FROM deployments
+		WHERE site = ? AND archive_sha256 = ? AND status = 'ready' ORDER BY timestamp DESC LIMIT 1`,
+		site, archiveHash,
+	).Scan(&d.ID, &d.Filename, &d.Timestamp, &d.Path, &d.Site, &d.ArchiveSHA256, &d.OwnerID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ownsDeployment(r, d.OwnerID) {
+		return nil, nil
+	}
+	if _, err := os.Stat(d.Path); err != nil {
+		return nil, nil
+	}
+	return &d, nil
+}
+
+// respondIfDuplicate answers an upload from an existing deployment when the
+// same archive is already deployed to site, reporting whether it responded
+func respondIfDuplicate(w http.ResponseWriter, r *http.Request, db *sql.DB, site, archiveHash, filename string, progress *uploadTracker, started time.Time) bool {
+	if site == "" || cfg.DuplicateUploads == config.DuplicateOff {
+		return false
+	}
+	existing, err := findDuplicateUpload(db, r, site, archiveHash)
+	if err != nil {
+		progress.fail("Failed to check for duplicate uploads")
+		http.Error(w, "Failed to check for duplicate uploads", http.StatusInternalServerError)
+		return true
+	}
+	if existing == nil {
+		return false
+	}
+	handleDuplicateUpload(w, r, db, existing, filename, progress, started)
+	return true
+}
+
+// handleDuplicateUpload answers an upload whose archive is already deployed
+// to the site. In "reuse" mode the existing deployment is returned as is; in
+// "alias" mode a new deployment is recorded that shares the existing files.
+func handleDuplicateUpload(w http.ResponseWriter, r *http.Request, db *sql.DB, existing *models.Deployment, filename string, progress *uploadTracker, started time.Time) {
+	w.Header().Set("X-Duplicate-Of", existing.ID)
+
+	if cfg.DuplicateUploads != config.DuplicateAlias {
+		progress.complete(existing.ID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(withURLs(r, existing))
+		return
+	}
+
+	// The original must not be deleted while its files gain another owner
+	unlock, ok := lockMutation(w, "upload", locks.Deployment(existing.ID))
+	if !ok {
+		progress.fail("Conflicting operation in progress")
+		return
+	}
+	defer unlock()
+
+	alias := models.NewDeployment(uuid.New().String(), filename, existing.Path)
+	alias.Site = existing.Site
+	alias.ArchiveSHA256 = existing.ArchiveSHA256
+	alias.OwnerID = deploymentOwner(db, r, alias.Site)
+	alias.DeployedBy = deployedBy(r)
+	alias.Visibility = deploymentVisibility(db, alias.Site, r.FormValue("visibility"))
+	previousLive := liveDeploymentID(db, alias.Site)
+	inherited, err := inheritPassword(db, alias)
+	if err != nil {
+		progress.fail("Failed to save deployment")
+		http.Error(w, "Failed to save deployment", http.StatusInternalServerError)
+		return
+	}
+	_, err = db.Exec(
+		"INSERT INTO deployments (id, filename, timestamp, path, site, archive_sha256, owner_id, deployed_by, visibility) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		alias.ID, alias.Filename, alias.Timestamp, alias.Path, alias.Site, alias.ArchiveSHA256, alias.OwnerID, alias.DeployedBy, alias.Visibility,
+	)
+	if err != nil {
+		if inherited {
+			db.Exec("DELETE FROM deployment_passwords WHERE deployment_id = ?", alias.ID)
+		}
+		progress.fail("Failed to save deployment")
+		http.Error(w, "Failed to save deployment", http.StatusInternalServerError)
+		return
+	}
+	tombstone.Remove(db, alias.ID)
+
+	recordPreloadHints(db, alias.ID, alias.Path)
+	if err := integrity.Copy(db, existing.ID, alias.ID); err != nil {
+		log.Printf("Warning: Failed to copy file manifest to deployment %s: %v", alias.ID, err)
+	}
+	// The files are already billed to the original deployment
+	entry := usage.RecordDeployment(db, alias.ID, requestTenant(r, usage.DefaultTenant), "", time.Since(started))
+	webhooks.Notify(db, webhooks.EventDeploymentCreated, alias, alias.Site)
+	events.Record(db, events.DeploymentCreated, alias.ID, alias.Site, map[string]any{"deployment": alias, "usage": entry})
+	notifyPromotion(db, alias.Site, previousLive)
+	progress.complete(alias.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(withURLs(r, alias))
+}
+
+// deploymentFilesShared reports whether another deployment still serves
+// files from path, in which case they must not be removed
+func deploymentFilesShared(db *sql.DB, path string) bool {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM deployments WHERE path = ?", path).Scan(&count); err != nil {
+		// Err on the side of keeping files
+		return true
+	}
+	return count > 0
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/config"
+	"static-site-hosting/models"
+)
+
+func testZipBytes(t *testing.T) []byte {
+	zipBuffer, err := createTestZip()
+	if err != nil {
+		t.Fatalf("failed to create test zip: %v", err)
+	}
+	return zipBuffer.Bytes()
+}
+
+func uploadToSite(t *testing.T, handler func(http.ResponseWriter, *http.Request), site string, archive []byte) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("site", site)
+	part, _ := writer.CreateFormFile("file", "test-site.zip")
+	part.Write(archive)
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+	return rr
+}
+
+func TestDuplicateUploadReusesDeployment(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	upload := func(w http.ResponseWriter, r *http.Request) { UploadHandler(w, r, db) }
+	archive := testZipBytes(t)
+
+	var first, second models.Deployment
+	json.NewDecoder(uploadToSite(t, upload, "docs", archive).Body).Decode(&first)
+	rr := uploadToSite(t, upload, "docs", archive)
+	json.NewDecoder(rr.Body).Decode(&second)
+
+	if first.ArchiveSHA256 == "" {
+		t.Fatal("expected archive hash to be recorded")
+	}
+	if second.ID != first.ID {
+		t.Errorf("expected re-upload to return deployment %s, got %s", first.ID, second.ID)
+	}
+	if rr.Header().Get("X-Duplicate-Of") != first.ID {
+		t.Errorf("expected X-Duplicate-Of header, got %q", rr.Header().Get("X-Duplicate-Of"))
+	}
+
+	// A different site never matches
+	var other models.Deployment
+	json.NewDecoder(uploadToSite(t, upload, "blog", archive).Body).Decode(&other)
+	if other.ID == first.ID {
+		t.Error("expected upload to another site to create a new deployment")
+	}
+}
+
+func TestDuplicateUploadAlias(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	cfg.DuplicateUploads = config.DuplicateAlias
+	defer func() { cfg.DuplicateUploads = config.DuplicateReuse }()
+
+	upload := func(w http.ResponseWriter, r *http.Request) { UploadHandler(w, r, db) }
+	archive := testZipBytes(t)
+
+	var first, alias models.Deployment
+	json.NewDecoder(uploadToSite(t, upload, "docs", archive).Body).Decode(&first)
+	json.NewDecoder(uploadToSite(t, upload, "docs", archive).Body).Decode(&alias)
+
+	if alias.ID == first.ID {
+		t.Fatal("expected alias mode to create a new deployment")
+	}
+	if alias.Path != first.Path {
+		t.Errorf("expected alias to share %s, got %s", first.Path, alias.Path)
+	}
+
+	// Deleting the original must keep the files the alias serves
+	req := httptest.NewRequest(http.MethodDelete, "/deployments/"+first.ID, nil)
+	rr := httptest.NewRecorder()
+	DeleteDeploymentHandler(rr, req, db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if _, err := os.Stat(alias.Path); err != nil {
+		t.Errorf("expected shared files to survive deleting the original: %v", err)
+	}
+}
+
+func TestDuplicateUploadRespectsSiteOwner(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	saved := *cfg
+	defer func() { *cfg = saved }()
+
+	archive := testZipBytes(t)
+	upload := func(claims *auth.Claims, site string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		if site != "" {
+			writer.WriteField("site", site)
+		}
+		part, _ := writer.CreateFormFile("file", "test-site.zip")
+		part.Write(archive)
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		UploadHandler(rr, as(req, claims), db)
+		return rr
+	}
+	count := func() int {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM deployments").Scan(&n)
+		return n
+	}
+
+	if rr := upload(aliceClaims, "docs"); rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	scoped := &auth.Claims{Subject: "user:alice", Role: auth.RoleDeployer, Site: "blog"}
+	for _, mode := range []string{config.DuplicateReuse, config.DuplicateAlias} {
+		cfg.DuplicateUploads = mode
+		if rr := upload(bobClaims, "docs"); rr.Code != http.StatusForbidden || rr.Header().Get("X-Duplicate-Of") != "" {
+			t.Errorf("%s: expected bob's copy of alice's archive to be refused, got %d: %s", mode, rr.Code, rr.Body.String())
+		}
+		if rr := upload(scoped, "docs"); rr.Code != http.StatusForbidden {
+			t.Errorf("%s: expected a token for blog not to deploy docs, got %d", mode, rr.Code)
+		}
+	}
+	if n := count(); n != 1 {
+		t.Errorf("expected refused uploads to create no deployment, found %d", n)
+	}
+
+	// Content IDs of site-less uploads don't hand out another owner's deployment
+	cfg.DeploymentIDs = config.DeploymentIDsContent
+	if rr := upload(aliceClaims, ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := upload(bobClaims, ""); rr.Code != http.StatusConflict || strings.Contains(rr.Body.String(), "user:alice") {
+		t.Errorf("expected bob's upload to be refused without alice's deployment, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestUploadRejectsInvalidSite(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("site", "Not A Slug")
+	part, _ := writer.CreateFormFile("file", "test-site.zip")
+	part.Write([]byte("zip"))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	UploadHandler(rr, req, db)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rr.Code)
+	}
+}
+
+func TestAliasServesSharedFiles(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	cfg.DuplicateUploads = config.DuplicateAlias
+	defer func() { cfg.DuplicateUploads = config.DuplicateReuse }()
+
+	upload := func(w http.ResponseWriter, r *http.Request) { UploadHandler(w, r, db) }
+	archive := testZipBytes(t)
+
+	var first, alias models.Deployment
+	json.NewDecoder(uploadToSite(t, upload, "docs", archive).Body).Decode(&first)
+	json.NewDecoder(uploadToSite(t, upload, "docs", archive).Body).Decode(&alias)
+
+	req := httptest.NewRequest(http.MethodGet, "/"+alias.ID+"/index.html", nil)
+	rr := httptest.NewRecorder()
+	StaticFileHandler(db).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected alias to serve the shared files, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"static-site-hosting/events"
+	"static-site-hosting/routecache"
+	"static-site-hosting/usage"
+)
+
+// maxEventsPage is the most events one GET /admin/events returns
+const maxEventsPage = 1000
+
+// EventsHandler lists the event log in order, from after the sequence
+// number given, so consumers can follow it page by page:
+//
+//	GET /admin/events?after=0&limit=100
+func EventsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var err error
+	after := int64(0)
+	if v := r.URL.Query().Get("after"); v != "" {
+		if after, err = strconv.ParseInt(v, 10, 64); err != nil || after < 0 {
+			http.Error(w, "after must be a sequence number", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxEventsPage {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+	}
+
+	list, err := events.List(db, after, limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch events", http.StatusInternalServerError)
+		return
+	}
+	next := after
+	if len(list) > 0 {
+		next = list[len(list)-1].Seq
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"events": list, "next": next})
+}
+
+// ReplayEventsHandler rebuilds state derived from the event log: the usage
+// ledger is replayed from it and the routing cache is dropped, on this
+// node and, with a shared cache, on every node
+//
+//	POST /admin/events/replay
+func ReplayEventsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	unlock, ok := lockAllMutations(w, "replay")
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	n, err := usage.Rebuild(db)
+	if err != nil {
+		log.Printf("Event replay failed after %d events: %v", n, err)
+		http.Error(w, "Failed to replay events", http.StatusInternalServerError)
+		return
+	}
+	routecache.Invalidate()
+	log.Printf("Replayed %d events", n)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"replayed": n})
+}
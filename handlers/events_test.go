@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"static-site-hosting/events"
+	"static-site-hosting/models"
+)
+
+func TestEventLogReplay(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	upload := func(w http.ResponseWriter, r *http.Request) { UploadHandler(w, r, db) }
+	var kept, deleted models.Deployment
+	json.NewDecoder(uploadToSite(t, upload, "docs", testZipBytes(t)).Body).Decode(&deleted)
+	zipBuffer, _ := createTestZip()
+	json.NewDecoder(uploadToSite(t, upload, "blog", zipBuffer.Bytes()).Body).Decode(&kept)
+	rr := httptest.NewRecorder()
+	DeleteDeploymentHandler(rr, httptest.NewRequest(http.MethodDelete, "/deployments/"+deleted.ID, nil), db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the deletion to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	EventsHandler(rr, httptest.NewRequest(http.MethodGet, "/admin/events", nil), db)
+	var page struct {
+		Events []events.Event `json:"events"`
+		Next   int64          `json:"next"`
+	}
+	json.NewDecoder(rr.Body).Decode(&page)
+	var types []string
+	for _, e := range page.Events {
+		types = append(types, e.Type)
+	}
+	want := []string{events.DeploymentCreated, events.DeploymentPromoted, events.DeploymentCreated, events.DeploymentPromoted, events.DeploymentDeleted}
+	if len(types) != len(want) {
+		t.Fatalf("expected events %v, got %v", want, types)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("expected events %v, got %v", want, types)
+		}
+	}
+	if page.Next != page.Events[len(page.Events)-1].Seq {
+		t.Errorf("expected next to be the last sequence number, got %d", page.Next)
+	}
+
+	// The usage ledger is lost, and rebuilt from the log
+	usageRow := func(id string) (bytes int64, deleted bool, err error) {
+		err = db.QueryRow("SELECT bytes, deleted_at IS NOT NULL FROM deployment_usage WHERE deployment_id = ?", id).Scan(&bytes, &deleted)
+		return
+	}
+	keptBytes, _, _ := usageRow(kept.ID)
+	db.Exec("DELETE FROM deployment_usage")
+
+	rr = httptest.NewRecorder()
+	ReplayEventsHandler(rr, httptest.NewRequest(http.MethodPost, "/admin/events/replay", nil), db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if bytes, isDeleted, err := usageRow(kept.ID); err != nil || bytes != keptBytes || bytes == 0 || isDeleted {
+		t.Errorf("expected %s's usage back, got %d, %v, %v", kept.ID, bytes, isDeleted, err)
+	}
+	if _, isDeleted, err := usageRow(deleted.ID); err != nil || !isDeleted {
+		t.Errorf("expected %s's usage to be closed by its deletion, got %v, %v", deleted.ID, isDeleted, err)
+	}
+}
@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"static-site-hosting/config"
+	"static-site-hosting/models"
+)
+
+type extractJob struct {
+	file *zip.File
+	path string
+}
+
+// unzip extracts src into dest using a bounded pool of workers, reporting
+// per-entry progress when progress is non-nil. Files are written without
+// individual fsyncs; when cfg.ExtractFsync is set they are synced in one
+// batch after everything has been written, which is far cheaper than a
+// sync per file for sites with many small files.
+func unzip(src, dest string, progress *uploadTracker) error {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	os.MkdirAll(dest, 0755)
+
+	if progress != nil {
+		progress.extracting(len(r.File))
+	}
+
+	// Create every directory up front so workers never race on MkdirAll
+	var jobs []extractJob
+	dirs := map[string]bool{filepath.Clean(dest): true}
+	// Entries repeating a path would be written concurrently; as with
+	// sequential extraction, the last one wins
+	queued := map[string]int{}
+	for _, f := range r.File {
+		// Prevent path traversal attacks
+		if traversesUp(f.Name) {
+			if err := unsafeEntry(progress, f.Name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fPath := filepath.Join(dest, f.Name)
+		// "./" is dest itself, which already exists
+		if fPath == filepath.Clean(dest) && f.FileInfo().IsDir() {
+			continue
+		}
+
+		// Ensure the file path is within dest directory
+		if !strings.HasPrefix(fPath, filepath.Clean(dest)+string(os.PathSeparator)) {
+			if err := unsafeEntry(progress, f.Name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if secretFile(f.Name) {
+			skipEntry(progress, f.Name, models.SkipSecret)
+			continue
+		}
+
+		if f.FileInfo().IsDir() {
+			if !dirs[fPath] {
+				os.MkdirAll(fPath, f.Mode())
+				dirs[fPath] = true
+			}
+			continue
+		}
+
+		parent := filepath.Dir(fPath)
+		if !dirs[parent] {
+			if err := os.MkdirAll(parent, 0755); err != nil {
+				return err
+			}
+			dirs[parent] = true
+		}
+		if i, ok := queued[fPath]; ok {
+			jobs[i].file = f
+			continue
+		}
+		queued[fPath] = len(jobs)
+		jobs = append(jobs, extractJob{file: f, path: fPath})
+	}
+
+	var done atomic.Int64
+	done.Store(int64(len(r.File) - len(jobs))) // skipped, repeated and directory entries
+	err = runWorkers(len(jobs), func(i int) error {
+		if err := extractFile(jobs[i].file, jobs[i].path); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress.extracted(int(done.Add(1)))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, len(jobs))
+	for i, job := range jobs {
+		paths[i] = job.path
+	}
+	if err := syncExtracted(paths, dirs); err != nil {
+		return err
+	}
+
+	if progress != nil {
+		progress.extracted(len(r.File))
+	}
+	return nil
+}
+
+// untar extracts a tar stream, gzip-compressed when gzipped is set, into
+// dest as it is read, so the archive never has to be stored first. Entries
+// are written in stream order; the total file count is unknown up front.
+func untar(src io.Reader, dest string, gzipped bool, progress *uploadTracker) error {
+	if gzipped {
+		zr, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		src = zr
+	}
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	if progress != nil {
+		progress.extracting(0)
+	}
+
+	tr := tar.NewReader(src)
+	root := filepath.Clean(dest)
+	dirs := map[string]bool{root: true}
+	var paths []string
+	done := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		done++
+
+		// Same traversal rules as zip archives
+		fPath := filepath.Join(dest, hdr.Name)
+		// tar -C dist -czf site.tgz . starts with "./", which is dest itself
+		if fPath == root && hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		if traversesUp(hdr.Name) || !strings.HasPrefix(fPath, root+string(os.PathSeparator)) {
+			if err := unsafeEntry(progress, hdr.Name); err != nil {
+				return err
+			}
+			continue
+		}
+		name := hdr.Name
+		if hdr.Typeflag == tar.TypeDir && !strings.HasSuffix(name, "/") {
+			name += "/"
+		}
+		if secretFile(name) {
+			skipEntry(progress, hdr.Name, models.SkipSecret)
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if !dirs[fPath] {
+				if err := os.MkdirAll(fPath, 0755); err != nil {
+					return err
+				}
+				dirs[fPath] = true
+			}
+		case tar.TypeReg:
+			parent := filepath.Dir(fPath)
+			if !dirs[parent] {
+				if err := os.MkdirAll(parent, 0755); err != nil {
+					return err
+				}
+				dirs[parent] = true
+			}
+			if err := writeTarEntry(tr, fPath, hdr.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+			paths = append(paths, fPath)
+		default:
+			// Links and special files have no place in a static site
+			skipEntry(progress, hdr.Name, models.SkipUnsupported)
+		}
+
+		if progress != nil {
+			progress.extracted(done)
+		}
+	}
+
+	return syncExtracted(paths, dirs)
+}
+
+// extractArchive extracts the archive at src, in format, into dest
+func extractArchive(src, format, dest string, progress *uploadTracker) error {
+	if format == archiveZip {
+		return unzip(src, dest, progress)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return untar(f, dest, format == archiveTarGz, progress)
+}
+
+// unsafeEntryError rejects an archive with an entry that would be written
+// outside the directory it is extracted into
+type unsafeEntryError struct {
+	name string
+}
+
+func (e *unsafeEntryError) Error() string {
+	return fmt.Sprintf("archive entry %q is outside the deployment", e.name)
+}
+
+// traversesUp reports whether an entry name climbs out of the directory it
+// is extracted into. Only a ".." segment does; names like app..min.js are
+// ordinary files.
+func traversesUp(name string) bool {
+	for _, segment := range strings.Split(path.Clean(name), "/") {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// unsafeEntry handles an entry whose path escapes the extraction directory
+// per cfg.UnsafeEntries: it is skipped, or an error rejects the archive
+func unsafeEntry(progress *uploadTracker, name string) error {
+	if cfg.UnsafeEntries == config.UnsafeEntriesSkip {
+		skipEntry(progress, name, models.SkipUnsafePath)
+		return nil
+	}
+	log.Printf("Rejected archive%s: entry %q is outside the deployment", uploadLabel(progress), name)
+	return &unsafeEntryError{name}
+}
+
+// extractFailure is the message for a failed extraction: msg, or what was
+// wrong with the archive when it was rejected for an unsafe entry
+func extractFailure(err error, msg string) string {
+	var unsafe *unsafeEntryError
+	if errors.As(err, &unsafe) {
+		return "Archive rejected: " + unsafe.Error()
+	}
+	return msg
+}
+
+// skipEntry records an entry left out of an extraction with a progress
+// tracker and logs it, so incomplete sites can be traced back to their
+// archive
+func skipEntry(progress *uploadTracker, name, reason string) {
+	log.Printf("Skipped archive entry%s: %q (%s)", uploadLabel(progress), name, reason)
+	if progress != nil {
+		progress.skip(name, reason)
+	}
+}
+
+// uploadLabel names the upload progress tracks in log messages
+func uploadLabel(progress *uploadTracker) string {
+	if progress == nil {
+		return ""
+	}
+	return " of upload " + progress.id
+}
+
+func writeTarEntry(r io.Reader, fPath string, mode os.FileMode) error {
+	outFile, err := os.OpenFile(fPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode|0200)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(outFile, r)
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncExtracted fsyncs extracted files and their directories in one batch
+// when cfg.ExtractFsync is set
+func syncExtracted(paths []string, dirs map[string]bool) error {
+	if !cfg.ExtractFsync {
+		return nil
+	}
+	err := runWorkers(len(paths), func(i int) error {
+		return syncPath(paths[i])
+	})
+	if err != nil {
+		return err
+	}
+	// Directory entries must be synced too for new files to survive a crash
+	for dir := range dirs {
+		if err := syncPath(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(f *zip.File, fPath string) error {
+	outFile, err := os.OpenFile(fPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+	if err != nil {
+		return err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		outFile.Close()
+		return err
+	}
+
+	_, err = io.Copy(outFile, rc)
+	rc.Close()
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func syncPath(p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// runWorkers calls fn for 0..n-1 on up to cfg.ExtractWorkers goroutines and
+// returns the first error. Remaining work is abandoned after a failure.
+func runWorkers(n int, fn func(i int) error) error {
+	workers := cfg.ExtractWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > n {
+		workers = n
+	}
+
+	var (
+		next     atomic.Int64
+		failed   atomic.Bool
+		firstErr error
+		once     sync.Once
+		wg       sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !failed.Load() {
+				i := int(next.Add(1)) - 1
+				if i >= n {
+					return
+				}
+				if err := fn(i); err != nil {
+					once.Do(func() { firstErr = err })
+					failed.Store(true)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
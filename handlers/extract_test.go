@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"static-site-hosting/config"
+)
+
+func writeZip(t *testing.T, files map[string]string) string {
+	path := filepath.Join(t.TempDir(), "site.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create zip: %v", err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", name, err)
+		}
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	f.Close()
+	return path
+}
+
+func TestUnzipManyFilesInParallel(t *testing.T) {
+	files := map[string]string{"../escape.txt": "nope"}
+	for i := 0; i < 200; i++ {
+		files[fmt.Sprintf("assets/dir%d/file%d.txt", i%7, i)] = fmt.Sprintf("content %d", i)
+	}
+	src := writeZip(t, files)
+	dest := filepath.Join(t.TempDir(), "out")
+
+	cfg.ExtractWorkers = 4
+	cfg.UnsafeEntries = config.UnsafeEntriesSkip
+	defer func() {
+		cfg.ExtractWorkers = 0
+		cfg.UnsafeEntries = config.UnsafeEntriesReject
+	}()
+
+	progress := startUpload("extract-test", -1)
+	if err := unzip(src, dest, progress); err != nil {
+		t.Fatalf("unzip failed: %v", err)
+	}
+
+	for i := 0; i < 200; i++ {
+		got, err := os.ReadFile(filepath.Join(dest, fmt.Sprintf("assets/dir%d/file%d.txt", i%7, i)))
+		if err != nil || string(got) != fmt.Sprintf("content %d", i) {
+			t.Fatalf("file %d not extracted correctly: %q %v", i, got, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dest), "escape.txt")); !os.IsNotExist(err) {
+		t.Error("expected path traversal entry to be skipped")
+	}
+
+	p := progress.snapshot()
+	if p.FilesTotal != 201 || p.FilesExtracted != 201 {
+		t.Errorf("expected 201 of 201 entries processed, got %d of %d", p.FilesExtracted, p.FilesTotal)
+	}
+}
+
+func TestUnzipRejectsUnsafeEntries(t *testing.T) {
+	src := writeZip(t, map[string]string{
+		"index.html":    "<html></html>",
+		"../escape.txt": "nope",
+	})
+	dest := filepath.Join(t.TempDir(), "out")
+
+	err := unzip(src, dest, nil)
+	var unsafe *unsafeEntryError
+	if !errors.As(err, &unsafe) || unsafe.name != "../escape.txt" {
+		t.Fatalf("expected the archive to be rejected for ../escape.txt, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "index.html")); !os.IsNotExist(err) {
+		t.Error("expected nothing to be extracted from a rejected archive")
+	}
+}
+
+func TestUnzipKeepsDottedNames(t *testing.T) {
+	src := writeZip(t, map[string]string{
+		"app..min.js":      "app",
+		"v1..2/index.html": "<html></html>",
+	})
+	dest := filepath.Join(t.TempDir(), "out")
+
+	if err := unzip(src, dest, nil); err != nil {
+		t.Fatalf("expected names containing .. to be extracted, got %v", err)
+	}
+	for _, name := range []string{"app..min.js", "v1..2/index.html"} {
+		if _, err := os.Stat(filepath.Join(dest, name)); err != nil {
+			t.Errorf("expected %s to be extracted: %v", name, err)
+		}
+	}
+}
+
+func TestUnzipDuplicateEntriesLastWins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "site.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create zip: %v", err)
+	}
+	// writeZip takes a map, which can't repeat a name
+	zw := zip.NewWriter(f)
+	for i, name := range []string{"index.html", "assets/app.js", "./index.html", "assets//app.js", "index.html"} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(fmt.Sprintf("version %d of %s", i, name)))
+	}
+	zw.Close()
+	f.Close()
+
+	cfg.ExtractWorkers = 4
+	defer func() { cfg.ExtractWorkers = 0 }()
+	dest := filepath.Join(t.TempDir(), "out")
+	progress := startUpload("duplicate-test", -1)
+	if err := unzip(path, dest, progress); err != nil {
+		t.Fatalf("unzip failed: %v", err)
+	}
+
+	for name, want := range map[string]string{"index.html": "version 4 of index.html", "assets/app.js": "version 3 of assets//app.js"} {
+		if got, _ := os.ReadFile(filepath.Join(dest, name)); string(got) != want {
+			t.Errorf("expected %s to hold the last entry %q, got %q", name, want, got)
+		}
+	}
+	if p := progress.snapshot(); p.FilesExtracted != 5 {
+		t.Errorf("expected all 5 entries to be counted, got %d", p.FilesExtracted)
+	}
+}
+
+func TestRunWorkersStopsOnError(t *testing.T) {
+	cfg.ExtractWorkers = 2
+	defer func() { cfg.ExtractWorkers = 0 }()
+
+	var calls atomic.Int64
+	boom := errors.New("boom")
+	err := runWorkers(1000, func(i int) error {
+		calls.Add(1)
+		if i == 3 {
+			return boom
+		}
+		return nil
+	})
+	if err != boom {
+		t.Fatalf("expected first error to be returned, got %v", err)
+	}
+	if calls.Load() == 1000 {
+		t.Error("expected remaining work to be abandoned after a failure")
+	}
+}
+
+func TestExtractDotRootedArchives(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755})
+	for name, content := range map[string]string{"./index.html": "<html></html>", "./css/style.css": "body{}"} {
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+
+	// tar -C dist -czf site.tgz . and zips built the same way lead with "./"
+	dest := filepath.Join(t.TempDir(), "tar")
+	if err := untar(&buf, dest, true, nil); err != nil {
+		t.Fatalf("expected a ./-rooted tarball to extract, got %v", err)
+	}
+	for _, name := range []string{"index.html", "css/style.css"} {
+		if _, err := os.Stat(filepath.Join(dest, name)); err != nil {
+			t.Errorf("expected %s to be extracted from the tarball: %v", name, err)
+		}
+	}
+
+	src := writeZip(t, map[string]string{"./": "", "./index.html": "<html></html>"})
+	dest = filepath.Join(t.TempDir(), "zip")
+	if err := unzip(src, dest, nil); err != nil {
+		t.Fatalf("expected a ./-rooted zip to extract, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "index.html")); err != nil {
+		t.Errorf("expected index.html to be extracted from the zip: %v", err)
+	}
+}
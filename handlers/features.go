@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"static-site-hosting/features"
+)
+
+// flags gates experimental behaviour; nil leaves every flag at its default
+var flags *features.Set
+
+// SetFeatures sets the feature flags consulted by handlers
+func SetFeatures(f *features.Set) {
+	flags = f
+}
+
+// FeaturesHandler lists every feature flag with its effective value and
+// where it comes from: GET /admin/features
+func FeaturesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flags.List())
+}
+
+// FeatureHandler reads, overrides or resets one flag at runtime.
+// Expected: GET, PUT {"enabled": true} or DELETE /admin/features/{name}
+//
+// Overrides take effect immediately and persist across restarts; DELETE
+// returns the flag to its configured value.
+func FeatureHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/admin/features/")
+	if _, ok := flags.Get(name); !ok {
+		http.Error(w, "Unknown feature flag", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+			http.Error(w, `Body must be {"enabled": true|false}`, http.StatusBadRequest)
+			return
+		}
+		if flags == nil {
+			http.Error(w, "Feature flags are not configured", http.StatusServiceUnavailable)
+			return
+		}
+		if err := flags.Override(name, *body.Enabled); err != nil {
+			http.Error(w, "Failed to save feature flag", http.StatusInternalServerError)
+			return
+		}
+	case http.MethodDelete:
+		if flags == nil {
+			http.Error(w, "Feature flags are not configured", http.StatusServiceUnavailable)
+			return
+		}
+		if err := flags.Reset(name); err != nil {
+			http.Error(w, "Failed to reset feature flag", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "GET, PUT or DELETE required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state, _ := flags.Get(name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"static-site-hosting/features"
+	"static-site-hosting/models"
+)
+
+func TestFeatureHandlerOverrideAndReset(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	f, err := features.New(db, nil)
+	if err != nil {
+		t.Fatalf("failed to load feature flags: %v", err)
+	}
+	SetFeatures(f)
+	defer SetFeatures(nil)
+
+	rr := httptest.NewRecorder()
+	FeatureHandler(rr, httptest.NewRequest(http.MethodPut, "/admin/features/spa_fallback", strings.NewReader(`{"enabled": true}`)))
+	var state features.State
+	json.NewDecoder(rr.Body).Decode(&state)
+	if rr.Code != http.StatusOK || !state.Enabled || state.Source != features.SourceRuntime {
+		t.Fatalf("expected runtime override, got %d %+v", rr.Code, state)
+	}
+
+	rr = httptest.NewRecorder()
+	FeatureHandler(rr, httptest.NewRequest(http.MethodDelete, "/admin/features/spa_fallback", nil))
+	json.NewDecoder(rr.Body).Decode(&state)
+	if state.Enabled || state.Source != features.SourceDefault {
+		t.Errorf("expected reset to the default, got %+v", state)
+	}
+
+	rr = httptest.NewRecorder()
+	FeatureHandler(rr, httptest.NewRequest(http.MethodPut, "/admin/features/warp_drive", strings.NewReader(`{"enabled": true}`)))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown flag, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	FeatureHandler(rr, httptest.NewRequest(http.MethodPut, "/admin/features/brotli", strings.NewReader(`{}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without enabled, got %d", rr.Code)
+	}
+}
+
+func TestStaticFlagGatedBehaviour(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	dir := filepath.Join("deployments", "flags-test")
+	os.MkdirAll(dir, 0755)
+	recordDeployment(t, db, "flags-test")
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("app shell"), 0644)
+	os.WriteFile(filepath.Join(dir, "app.js"), []byte("plain"), 0644)
+	os.WriteFile(filepath.Join(dir, "app.js.br"), []byte("compressed"), 0644)
+
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rr := httptest.NewRecorder()
+		StaticFileHandler(db).ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := get("/flags-test/dashboard/settings", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 with spa_fallback off, got %d", rr.Code)
+	}
+	if rr := get("/flags-test/app.js", "br"); rr.Body.String() != "plain" {
+		t.Errorf("expected uncompressed file with brotli off, got %q", rr.Body.String())
+	}
+
+	f, _ := features.New(db, map[string]bool{features.SPAFallback: true, features.Brotli: true})
+	SetFeatures(f)
+	defer SetFeatures(nil)
+
+	if rr := get("/flags-test/dashboard/settings", ""); rr.Code != http.StatusOK || rr.Body.String() != "app shell" {
+		t.Errorf("expected index.html fallback, got %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := get("/flags-test/missing.css", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected missing assets to stay 404, got %d", rr.Code)
+	}
+	rr := get("/flags-test/app.js", "gzip, br")
+	if rr.Body.String() != "compressed" || rr.Header().Get("Content-Encoding") != "br" {
+		t.Errorf("expected precompressed brotli, got %q %q", rr.Header().Get("Content-Encoding"), rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.Contains(ct, "javascript") {
+		t.Errorf("expected the original file's content type, got %q", ct)
+	}
+	if rr := get("/flags-test/app.js", "gzip, br;q=0"); rr.Body.String() != "plain" {
+		t.Errorf("expected br;q=0 to be refused, got %q", rr.Body.String())
+	}
+
+	// A site's own setting wins over the flag
+	off := false
+	saveSiteSettings(db, "flags-test", models.SiteSettings{SPAFallback: &off})
+	if rr := get("/flags-test/dashboard/settings", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected the site setting to disable the fallback, got %d", rr.Code)
+	}
+}
+
+func TestAsyncUploadsFlag(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	upload := func() *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("site", "docs")
+		writer.WriteField("async", "true")
+		part, _ := writer.CreateFormFile("file", "site.zip")
+		part.Write(testZipBytes(t))
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		UploadHandler(rr, req, db)
+		return rr
+	}
+
+	rr := upload()
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), models.CodeDisabled) {
+		t.Errorf("expected async uploads to be refused while the flag is off, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	f, err := features.New(db, map[string]bool{features.AsyncUploads: true})
+	if err != nil {
+		t.Fatalf("failed to load feature flags: %v", err)
+	}
+	SetFeatures(f)
+	defer SetFeatures(nil)
+
+	rr = upload()
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var accepted UploadProgress
+	json.NewDecoder(rr.Body).Decode(&accepted)
+	if rr.Header().Get("Location") != "/uploads/"+accepted.UploadID+"/progress" {
+		t.Errorf("expected the progress URL, got %q", rr.Header().Get("Location"))
+	}
+
+	// The deployment is published in the background
+	deadline := time.Now().Add(5 * time.Second)
+	progress := lookupUpload(accepted.UploadID).snapshot()
+	for progress.State != UploadComplete && progress.State != UploadFailed && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		progress = lookupUpload(accepted.UploadID).snapshot()
+	}
+	if progress.State != UploadComplete || progress.DeploymentID == "" {
+		t.Fatalf("expected the upload to complete, got %+v", progress)
+	}
+	var site string
+	db.QueryRow("SELECT site FROM deployments WHERE id = ?", progress.DeploymentID).Scan(&site)
+	if site != "docs" {
+		t.Errorf("expected the deployment to be recorded, got site %q", site)
+	}
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"static-site-hosting/models"
+)
+
+// writeFieldErrors answers a request with the problems found in its fields,
+// so clients can point at the field rather than parse a message:
+//
+//	{"error": "Invalid file", "errors": [{"field": "file", "code": "missing", "message": "Invalid file"}]}
+//
+// error joins the messages for clients that only show one string.
+func writeFieldErrors(w http.ResponseWriter, status int, errs ...models.FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":  models.ValidationErrors(errs).Error(),
+		"errors": errs,
+	})
+}
+
+// writeValidationError answers 400 with err's field errors when it has
+// them, and as plain text otherwise
+func writeValidationError(w http.ResponseWriter, err error) {
+	var errs models.ValidationErrors
+	if errors.As(err, &errs) {
+		writeFieldErrors(w, http.StatusBadRequest, errs...)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
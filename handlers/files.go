@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"static-site-hosting/atrest"
+	"static-site-hosting/locks"
+	"static-site-hosting/models"
+	"static-site-hosting/tombstone"
+)
+
+const (
+	defaultManifestLimit = 1000
+	maxManifestLimit     = 10000
+)
+
+// ManifestEntry is one file in a deployment manifest
+type ManifestEntry struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// Manifest is one page of a deployment's file listing
+type Manifest struct {
+	DeploymentID string          `json:"deployment_id"`
+	Prefix       string          `json:"prefix,omitempty"`
+	Files        []ManifestEntry `json:"files"`
+	Directories  []string        `json:"directories,omitempty"` // only with delimiter=/
+	NextAfter    string          `json:"next_after,omitempty"`  // pass as ?after= for the next page
+	Truncated    bool            `json:"truncated"`
+}
+
+// errManifestPageFull stops the walk once a page has been filled
+var errManifestPageFull = errors.New("page full")
+
+// DeploymentFilesHandler lists a deployment's files in path order, one page
+// at a time, so clients can browse sites with 100k+ files lazily.
+// Expected: GET /deployments/{id}/files?prefix=assets/&limit=1000&after=assets/x.js
+//
+// With delimiter=/ only the entries directly below prefix are listed, and
+// subdirectories are returned under "directories" instead of being expanded.
+func DeploymentFilesHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deploymentID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/deployments/"), "/files")
+	if deploymentID == "" {
+		http.Error(w, "Deployment ID required", http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	prefix := strings.TrimPrefix(q.Get("prefix"), "/")
+	after := q.Get("after")
+	delimiter := q.Get("delimiter")
+	if delimiter != "" && delimiter != "/" {
+		http.Error(w, "delimiter must be /", http.StatusBadRequest)
+		return
+	}
+	limit := defaultManifestLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxManifestLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxManifestLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	var deployment models.Deployment
+	err := db.QueryRow("SELECT id, path, status, owner_id FROM deployments WHERE id = ?", deploymentID).
+		Scan(&deployment.ID, &deployment.Path, &deployment.Status, &deployment.OwnerID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch deployment", http.StatusInternalServerError)
+		return
+	}
+	if !authorizeFileAccess(w, r, db, deployment) {
+		return
+	}
+
+	manifest, err := listManifest(deployment.Path, prefix, after, delimiter != "", limit)
+	if err != nil {
+		http.Error(w, "Failed to list deployment files", http.StatusInternalServerError)
+		return
+	}
+	manifest.DeploymentID = deployment.ID
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// listManifest walks root in lexical order and returns up to limit entries
+// below prefix that sort after the cursor. Directories that can't contain
+// matching entries are skipped without being read.
+func listManifest(root, prefix, after string, shallow bool, limit int) (*Manifest, error) {
+	m := &Manifest{Prefix: prefix, Files: []ManifestEntry{}}
+	count := 0
+	last := ""
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			dir := rel + "/"
+			// Secret files extracted before they were left out aren't listed
+			if secretFile(dir) {
+				return filepath.SkipDir
+			}
+			// Prune directories outside the prefix and those fully before the cursor
+			if !strings.HasPrefix(dir, prefix) && !strings.HasPrefix(prefix, dir) {
+				return filepath.SkipDir
+			}
+			if after != "" && manifestKey(dir) < manifestKey(after) && !strings.HasPrefix(after, dir) {
+				return filepath.SkipDir
+			}
+			if shallow && dir != prefix && strings.HasPrefix(dir, prefix) {
+				if manifestKey(dir) > manifestKey(after) {
+					if count == limit {
+						m.Truncated = true
+						return errManifestPageFull
+					}
+					m.Directories = append(m.Directories, dir)
+					count++
+					last = dir
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if secretFile(rel) || !strings.HasPrefix(rel, prefix) || manifestKey(rel) <= manifestKey(after) {
+			return nil
+		}
+		if count == limit {
+			m.Truncated = true
+			return errManifestPageFull
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size, err := atrest.PlainSize(root, p, info)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, ManifestEntry{Path: rel, Size: size, Modified: info.ModTime().UTC()})
+		count++
+		last = rel
+		return nil
+	})
+	if err != nil && err != errManifestPageFull {
+		return nil, err
+	}
+
+	if m.Truncated {
+		m.NextAfter = last
+	}
+	return m, nil
+}
+
+// manifestKey orders paths the way the walk visits them: a directory's
+// contents come before any sibling whose name extends the directory's name
+// ("a/b" before "a-c"), so "/" has to sort lowest
+func manifestKey(p string) string {
+	return strings.ReplaceAll(p, "/", "\x00")
+}
+
+// DeploymentFileHandler reads or patches a single file of a deployment.
+// Expected: GET|HEAD|PUT /deployments/{id}/files/{file-path}
+//
+// Responses carry the file's SHA-256 as a strong ETag. A PUT never touches
+// the deployment itself: it creates a new revision with the file replaced and
+// must present the current ETag in If-Match (or If-None-Match: * to create a
+// file), so concurrent editors can't silently overwrite each other. For a
+// deployment that belongs to a site the edit applies to the site's latest
+// revision, which is what the ETag is checked against.
+func DeploymentFileHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	rest := strings.TrimPrefix(r.URL.Path, "/deployments/")
+	deploymentID, filePath, _ := strings.Cut(rest, "/files/")
+	rel := path.Clean("/" + filePath)
+	if deploymentID == "" || rel == "/" {
+		http.Error(w, "Deployment ID and file path required", http.StatusBadRequest)
+		return
+	}
+
+	var deployment models.Deployment
+	err := db.QueryRow("SELECT id, filename, timestamp, path, site, status, owner_id FROM deployments WHERE id = ?", deploymentID).
+		Scan(&deployment.ID, &deployment.Filename, &deployment.Timestamp, &deployment.Path, &deployment.Site, &deployment.Status, &deployment.OwnerID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch deployment", http.StatusInternalServerError)
+		return
+	}
+	if !authorizeFileAccess(w, r, db, deployment) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		fullPath := filepath.Join(deployment.Path, filepath.FromSlash(rel))
+		info, err := os.Stat(fullPath)
+		if err != nil || info.IsDir() || secretFile(rel) {
+			http.NotFound(w, r)
+			return
+		}
+		etag, err := fileETag(deployment.Path, fullPath)
+		if err != nil {
+			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+			return
+		}
+		file, err := atrest.Open(deployment.Path, fullPath)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+	case http.MethodPut:
+		patchDeploymentFile(w, r, db, deployment, rel)
+	default:
+		http.Error(w, "GET, HEAD or PUT required", http.StatusMethodNotAllowed)
+	}
+}
+
+func patchDeploymentFile(w http.ResponseWriter, r *http.Request, db *sql.DB, deployment models.Deployment, rel string) {
+	if !authorizeRevision(w, r, db, deployment) {
+		return
+	}
+	keys := locks.Keys(deployment.ID, deployment.Site)
+	base := deployment
+	if deployment.Site != "" {
+		latest, err := latestSiteDeployment(db, deployment.Site)
+		if err != nil {
+			http.Error(w, "Failed to fetch site", http.StatusInternalServerError)
+			return
+		}
+		if latest.ID != deployment.ID {
+			base = *latest
+			keys = append(keys, locks.Deployment(base.ID))
+		}
+	}
+
+	unlock, ok := lockMutation(w, "file patch", keys...)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	current := ""
+	target := filepath.Join(base.Path, filepath.FromSlash(rel))
+	if info, err := os.Stat(target); err == nil {
+		if info.IsDir() {
+			http.Error(w, "Cannot replace a directory", http.StatusConflict)
+			return
+		}
+		if current, err = fileETag(base.Path, target); err != nil {
+			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	switch {
+	case ifMatch == "" && ifNoneMatch == "":
+		http.Error(w, "If-Match with the file's current ETag (or If-None-Match: * for a new file) required", http.StatusPreconditionRequired)
+		return
+	case ifMatch != "" && (current == "" || !etagMatches(ifMatch, current)),
+		ifNoneMatch != "" && current != "" && (ifNoneMatch == "*" || etagMatches(ifNoneMatch, current)):
+		if current != "" {
+			w.Header().Set("ETag", current)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPreconditionFailed)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":         "File has changed",
+			"deployment_id": base.ID,
+			"path":          strings.TrimPrefix(rel, "/"),
+			"etag":          current,
+		})
+		return
+	}
+
+	var etag string
+	filename := fmt.Sprintf("[PATCH] %s", base.Filename)
+	newDeployment, status, err := createRevision(r, db, base, filename, func(dir string) (int, error) {
+		dst := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return http.StatusConflict, fmt.Errorf("Cannot create parent directory")
+		}
+		f, err := os.Create(dst)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("Failed to write file")
+		}
+		defer f.Close()
+		hash := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(f, hash), r.Body); err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("Failed to write file")
+		}
+		etag = `"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+		if current == "" {
+			return http.StatusCreated, nil
+		}
+		return http.StatusOK, nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Location", path.Join("/deployments", newDeployment.ID, "files", rel))
+	w.Header().Set("X-Deployment-Id", newDeployment.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":           "New revision created",
+		"source_deployment": base.ID,
+		"new_deployment":    withURLs(r, newDeployment),
+		"etag":              etag,
+	})
+}
+
+// authorizeFileAccess makes the checks StaticFileHandler makes before
+// serving d for the routes that read its files by ID, the files API and
+// WebDAV. Deployments that aren't ready, such as failed ones kept for
+// inspection, are only there for those who own them. It answers the request
+// and returns false when the caller may not read d.
+func authorizeFileAccess(w http.ResponseWriter, r *http.Request, db *sql.DB, d models.Deployment) bool {
+	if tombstone.Frozen(d.ID) || d.Status != models.StatusReady && !ownsDeployment(r, d.OwnerID) {
+		http.NotFound(w, r)
+		return false
+	}
+	_, _, ok := authorizeDeploymentRead(w, r, db, d.ID)
+	return ok
+}
+
+// latestSiteDeployment returns the newest live deployment of site
+func latestSiteDeployment(db *sql.DB, site string) (*models.Deployment, error) {
+	var d models.Deployment
+	err := db.QueryRow(
+		"SELECT id, filename, timestamp, path, site FROM deployments WHERE site = ? AND status = 'ready' ORDER BY timestamp DESC LIMIT 1", site,
+	).Scan(&d.ID, &d.Filename, &d.Timestamp, &d.Path, &d.Site)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// fileETag returns the quoted SHA-256 of the content of a file within root
+func fileETag(root, name string) (string, error) {
+	f, err := atrest.Open(root, name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)) + `"`, nil
+}
+
+// etagMatches reports whether an If-Match/If-None-Match header lists etag.
+// Weak validators never match, as these comparisons guard writes.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/routecache"
+)
+
+func createManifestDeployment(t *testing.T, db *sql.DB, id string) {
+	root := filepath.Join("deployments", id)
+	files := []string{"index.html", "a-c.txt", "a/b.txt", "assets/app.js", "assets/img/logo.png", "assets/img/bg.png"}
+	for _, f := range files {
+		p := filepath.Join(root, f)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(f), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", f, err)
+		}
+	}
+	_, err := db.Exec("INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, ?, ?, ?)", id, "site.zip", time.Now(), root)
+	if err != nil {
+		t.Fatalf("failed to insert deployment: %v", err)
+	}
+}
+
+func getManifest(t *testing.T, db *sql.DB, id, query string) Manifest {
+	req := httptest.NewRequest(http.MethodGet, "/deployments/"+id+"/files?"+query, nil)
+	rr := httptest.NewRecorder()
+	DeploymentFilesHandler(rr, req, db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var m Manifest
+	if err := json.NewDecoder(rr.Body).Decode(&m); err != nil {
+		t.Fatalf("failed to decode manifest: %v", err)
+	}
+	return m
+}
+
+func manifestPaths(m Manifest) []string {
+	var paths []string
+	for _, f := range m.Files {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
+
+func TestDeploymentFilesPagination(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	createManifestDeployment(t, db, "manifest-test")
+
+	var all []string
+	query := "limit=2"
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("pagination did not terminate")
+		}
+		m := getManifest(t, db, "manifest-test", query)
+		all = append(all, manifestPaths(m)...)
+		if !m.Truncated {
+			break
+		}
+		query = "limit=2&after=" + m.NextAfter
+	}
+
+	expected := []string{"a/b.txt", "a-c.txt", "assets/app.js", "assets/img/bg.png", "assets/img/logo.png", "index.html"}
+	if !reflect.DeepEqual(all, expected) {
+		t.Errorf("expected %v, got %v", expected, all)
+	}
+}
+
+func TestDeploymentFilesPrefixAndDelimiter(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	createManifestDeployment(t, db, "manifest-test")
+
+	m := getManifest(t, db, "manifest-test", "prefix=assets/img/")
+	if got := manifestPaths(m); !reflect.DeepEqual(got, []string{"assets/img/bg.png", "assets/img/logo.png"}) {
+		t.Errorf("unexpected prefix listing %v", got)
+	}
+
+	m = getManifest(t, db, "manifest-test", "prefix=assets/&delimiter=/")
+	if got := manifestPaths(m); !reflect.DeepEqual(got, []string{"assets/app.js"}) {
+		t.Errorf("unexpected files %v", got)
+	}
+	if !reflect.DeepEqual(m.Directories, []string{"assets/img/"}) {
+		t.Errorf("unexpected directories %v", m.Directories)
+	}
+}
+
+func TestDeploymentFilesErrors(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/deployments/missing/files", http.StatusNotFound},
+		{"/deployments/missing/files?limit=0", http.StatusBadRequest},
+		{"/deployments/missing/files?delimiter=-", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		DeploymentFilesHandler(rr, httptest.NewRequest(http.MethodGet, tt.path, nil), db)
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, rr.Code)
+		}
+	}
+}
+
+func putFile(db *sql.DB, id, file, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/deployments/"+id+"/files/"+file, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	DeploymentFileHandler(rr, req, db)
+	return rr
+}
+
+func TestDeploymentFileConditionalPatch(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	createManifestDeployment(t, db, "patch-test")
+	db.Exec("UPDATE deployments SET site = 'docs' WHERE id = 'patch-test'")
+
+	req := httptest.NewRequest(http.MethodGet, "/deployments/patch-test/files/index.html", nil)
+	rr := httptest.NewRecorder()
+	DeploymentFileHandler(rr, req, db)
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected file with ETag, got %d %q", rr.Code, etag)
+	}
+
+	if rr := putFile(db, "patch-test", "index.html", "v2", nil); rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("expected 428 without If-Match, got %d", rr.Code)
+	}
+
+	rr = putFile(db, "patch-test", "index.html", "v2", map[string]string{"If-Match": etag})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	newID := rr.Header().Get("X-Deployment-Id")
+	if newID == "" || newID == "patch-test" {
+		t.Fatalf("expected a new revision, got %q", newID)
+	}
+	original, _ := os.ReadFile(filepath.Join("deployments", "patch-test", "index.html"))
+	if string(original) != "index.html" {
+		t.Errorf("expected the original deployment to be untouched, got %q", original)
+	}
+
+	// A second editor still holding the old ETag loses, and learns the latest
+	rr = putFile(db, "patch-test", "index.html", "v3", map[string]string{"If-Match": etag})
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for a stale ETag, got %d", rr.Code)
+	}
+	var conflict map[string]string
+	json.NewDecoder(rr.Body).Decode(&conflict)
+	if conflict["etag"] == "" || conflict["etag"] == etag || conflict["deployment_id"] != newID {
+		t.Errorf("expected latest ETag of %s in 412 response, got %v", newID, conflict)
+	}
+
+	rr = putFile(db, "patch-test", "new.txt", "hi", map[string]string{"If-None-Match": "*"})
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected 201 creating a file, got %d", rr.Code)
+	}
+}
+
+func TestFilePatchFollowsUploadPolicy(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	createManifestDeployment(t, db, "policy-test")
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.UploadAllowedTypes = []string{".html", ".txt", ".js", ".png"}
+	cfg.UploadMaxBytes = 10
+
+	rr := putFile(db, "policy-test", "payload.exe", "MZ", map[string]string{"If-None-Match": "*"})
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "payload.exe") {
+		t.Errorf("expected a blocked file type to be refused with 422, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = putFile(db, "policy-test", "big.txt", strings.Repeat("x", 11), map[string]string{"If-None-Match": "*"})
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a file over the size limit to be refused with 413, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM deployments").Scan(&count)
+	if count != 1 {
+		t.Errorf("expected no revision to be published, got %d deployments", count)
+	}
+
+	if rr := putFile(db, "policy-test", "notes.txt", "notes", map[string]string{"If-None-Match": "*"}); rr.Code != http.StatusCreated {
+		t.Errorf("expected an allowed file to be published, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestFileRoutesRespectVisibility(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	createManifestDeployment(t, db, "private-files")
+	db.Exec("UPDATE deployments SET owner_id = 'user:alice', visibility = 'private' WHERE id = 'private-files'")
+
+	routes := map[string]func(*auth.Claims) int{
+		"files list": func(claims *auth.Claims) int {
+			rr := httptest.NewRecorder()
+			DeploymentFilesHandler(rr, as(httptest.NewRequest(http.MethodGet, "/deployments/private-files/files", nil), claims), db)
+			return rr.Code
+		},
+		"files read": func(claims *auth.Claims) int {
+			rr := httptest.NewRecorder()
+			DeploymentFileHandler(rr, as(httptest.NewRequest(http.MethodGet, "/deployments/private-files/files/index.html", nil), claims), db)
+			return rr.Code
+		},
+		"webdav read": func(claims *auth.Claims) int {
+			rr := httptest.NewRecorder()
+			WebDAVHandler(rr, as(httptest.NewRequest(http.MethodGet, "/dav/private-files/index.html", nil), claims), db)
+			return rr.Code
+		},
+		"webdav listing": func(claims *auth.Claims) int {
+			req := httptest.NewRequest("PROPFIND", "/dav/private-files/", nil)
+			req.Header.Set("Depth", "1")
+			rr := httptest.NewRecorder()
+			WebDAVHandler(rr, as(req, claims), db)
+			return rr.Code
+		},
+	}
+	for name, route := range routes {
+		if code := route(nil); code != http.StatusUnauthorized {
+			t.Errorf("%s: expected anonymous callers to get 401, got %d", name, code)
+		}
+		if code := route(bobClaims); code != http.StatusNotFound {
+			t.Errorf("%s: expected other users to get 404, got %d", name, code)
+		}
+		if code := route(aliceClaims); code != http.StatusOK && code != http.StatusMultiStatus {
+			t.Errorf("%s: expected the owner to read the files, got %d", name, code)
+		}
+	}
+
+	// Failed deployments are kept for their owners to inspect
+	db.Exec("UPDATE deployments SET visibility = 'public', status = 'failed' WHERE id = 'private-files'")
+	routecache.Invalidate()
+	if code := routes["files read"](bobClaims); code != http.StatusNotFound {
+		t.Errorf("expected a failed deployment to be hidden from other users, got %d", code)
+	}
+	if code := routes["files read"](aliceClaims); code != http.StatusOK {
+		t.Errorf("expected the owner to inspect a failed deployment, got %d", code)
+	}
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+	"static-site-hosting/users"
+)
+
+const githubStateCookie = "github_state"
+
+// GitHubLoginHandler redirects the browser to GitHub to authorize the app
+// Expected: GET /auth/github
+func GitHubLoginHandler(w http.ResponseWriter, r *http.Request, provider *auth.GitHubProvider, signer *auth.Signer) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := randomToken()
+	stateToken, err := signer.Issue(auth.Claims{Purpose: auth.PurposeLoginState, State: state}, 10*time.Minute)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     githubStateCookie,
+		Value:    stateToken,
+		Path:     "/auth/github",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, provider.AuthCodeURL(state), http.StatusFound)
+}
+
+// GitHubCallbackHandler completes the login and issues a session token for
+// the local account the GitHub user is linked to. A caller already logged
+// in to a local account links the GitHub user to it; otherwise a GitHub
+// user without an account gets one, named after their login, if
+// registration is open or GITHUB_ALLOWED_ORGS vouched for them. Forged
+// state and rejected codes count towards the client's lockout in throttle.
+// Expected: GET /auth/github/callback?code=...&state=...
+func GitHubCallbackHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, provider *auth.GitHubProvider, signer *auth.Signer, throttle *auth.Throttle) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ipKey := throttle.IPKey(r)
+	if refuseThrottled(w, r, throttle, "github", "", ipKey) {
+		return
+	}
+
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		http.Error(w, "Login failed: "+errCode, http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(githubStateCookie)
+	if err != nil {
+		http.Error(w, "Missing login state", http.StatusBadRequest)
+		return
+	}
+	stateClaims, err := signer.VerifyPurpose(cookie.Value, auth.PurposeLoginState)
+	if err != nil || stateClaims.State == "" || stateClaims.State != r.URL.Query().Get("state") {
+		recordAuthFailure(r, throttle, "github", "", "invalid state", ipKey)
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "Authorization code required", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := provider.Exchange(code)
+	if err == auth.ErrNotOrgMember {
+		recordAuthFailure(r, throttle, "github", "", err.Error(), ipKey)
+		http.Error(w, "Login restricted to members of the allowed GitHub organizations", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("GitHub callback failed: %v", err)
+		recordAuthFailure(r, throttle, "github", "", err.Error(), ipKey)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	registerMu.Lock()
+	defer registerMu.Unlock()
+
+	user, err := users.ByIdentity(db, "github", identity.ID)
+	current, linking := localAccount(r)
+	switch {
+	case err == nil && linking && user.ID != current:
+		http.Error(w, "This GitHub account is linked to another account", http.StatusConflict)
+		return
+	case err == nil:
+	case err != sql.ErrNoRows:
+		log.Printf("GitHub login failed: %v", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	case linking:
+		user, err = users.Get(db, current)
+		if err == nil {
+			err = users.Link(db, user.ID, "github", identity.ID, identity.Login)
+		}
+		if err != nil {
+			log.Printf("Failed to link GitHub user %s: %v", identity.Login, err)
+			http.Error(w, "Failed to link GitHub account", http.StatusInternalServerError)
+			return
+		}
+	default:
+		if user, err = createGitHubUser(w, db, identity); user == nil {
+			if err != nil {
+				log.Printf("Failed to create account for GitHub user %s: %v", identity.Login, err)
+				http.Error(w, "Failed to create account", http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	throttle.Audit(r, auth.AuditEntry{Event: auth.AuditLoginSucceeded, Provider: "github", Subject: identity.Login})
+	http.SetCookie(w, &http.Cookie{Name: githubStateCookie, Path: "/auth/github", MaxAge: -1})
+	claims := userClaims(user)
+	claims.Provider = "github"
+	issueSession(w, r, signer, claims)
+}
+
+// localAccount returns the ID of the local account the caller is logged in
+// to, if any
+func localAccount(r *http.Request) (string, bool) {
+	claims := auth.FromContext(r.Context())
+	if claims == nil {
+		return "", false
+	}
+	return strings.CutPrefix(claims.Subject, "user:")
+}
+
+// createGitHubUser creates and links an account for a GitHub user without
+// one. It answers the request itself, returning nil, if policy forbids it.
+// The account has no password and takes the GitHub login as its username,
+// or login@github if a local account already has that name.
+func createGitHubUser(w http.ResponseWriter, db *sql.DB, identity *auth.GitHubIdentity) (*models.User, error) {
+	count, err := users.Count(db)
+	if err != nil {
+		return nil, err
+	}
+	user := &models.User{Username: identity.Login, Email: identity.Email, Name: identity.Name, Role: cfg.UserDefaultRole}
+	switch {
+	case count == 0:
+		user.Role = auth.RoleAdmin
+	case !cfg.RegistrationOpen && len(cfg.GitHubAllowedOrgs) == 0:
+		http.Error(w, "Registration is closed; log in to your account and sign in with GitHub to link it", http.StatusForbidden)
+		return nil, nil
+	}
+
+	err = users.Create(db, user, "")
+	if err == users.ErrUsernameTaken {
+		user.Username = identity.Login + "@github"
+		err = users.Create(db, user, "")
+	}
+	if err == nil {
+		err = users.Link(db, user.ID, "github", identity.ID, identity.Login)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
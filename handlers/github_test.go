@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+	"static-site-hosting/users"
+)
+
+// fakeGitHub logs in the GitHub user whose login is the authorization code
+func fakeGitHub(t *testing.T) *httptest.Server {
+	ids := map[string]int{"octocat": 1, "hubot": 2}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"access_token": r.FormValue("code")})
+	})
+	mux.HandleFunc("/api/v3/user", func(w http.ResponseWriter, r *http.Request) {
+		login := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		json.NewEncoder(w).Encode(map[string]any{"id": ids[login], "login": login})
+	})
+	mux.HandleFunc("/api/v3/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGitHubLogin(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	saved := *cfg
+	defer func() { *cfg = saved }()
+
+	signer := auth.NewSigner([]byte("secret"))
+	throttle := auth.NewThrottle(5, time.Minute, time.Hour)
+	provider := auth.NewGitHubProvider(auth.GitHubConfig{ClientID: "app", ClientSecret: "shh", BaseURL: fakeGitHub(t).URL})
+
+	// login runs the flow for GitHub user code, logged in as claims
+	login := func(code string, claims *auth.Claims) (*httptest.ResponseRecorder, *auth.Claims) {
+		rr := httptest.NewRecorder()
+		GitHubLoginHandler(rr, httptest.NewRequest(http.MethodGet, "/auth/github", nil), provider, signer)
+		if rr.Code != http.StatusFound {
+			t.Fatalf("expected a redirect to GitHub, got %d", rr.Code)
+		}
+		target, _ := url.Parse(rr.Header().Get("Location"))
+
+		req := httptest.NewRequest(http.MethodGet, "/auth/github/callback?code="+code+"&state="+target.Query().Get("state"), nil)
+		req.AddCookie(rr.Result().Cookies()[0])
+		rr = httptest.NewRecorder()
+		GitHubCallbackHandler(rr, as(req, claims), db, provider, signer, throttle)
+		var session struct {
+			Token string `json:"token"`
+		}
+		json.NewDecoder(rr.Body).Decode(&session)
+		got, _ := signer.Verify(session.Token)
+		return rr, got
+	}
+
+	rr, octocat := login("octocat", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the first GitHub user to get an account, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if octocat.Provider != "github" || octocat.Role != auth.RoleAdmin || !strings.HasPrefix(octocat.Subject, "user:") {
+		t.Errorf("expected an admin session for a local account, got %+v", octocat)
+	}
+	if _, again := login("octocat", nil); again == nil || again.Subject != octocat.Subject {
+		t.Errorf("expected the same account on the next login, got %+v", again)
+	}
+
+	// With registration closed, other GitHub users need an account to link
+	cfg.RegistrationOpen = false
+	if rr, _ := login("hubot", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403 while registration is closed, got %d", rr.Code)
+	}
+	alice := &models.User{Username: "alice", Role: auth.RoleDeployer}
+	if err := users.Create(db, alice, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	aliceSession := userClaims(alice)
+	if _, linked := login("hubot", &aliceSession); linked == nil || linked.Subject != alice.Subject() {
+		t.Errorf("expected hubot to be linked to alice, got %+v", linked)
+	}
+	if _, hubot := login("hubot", nil); hubot == nil || hubot.Subject != alice.Subject() || hubot.Role != auth.RoleDeployer {
+		t.Errorf("expected hubot to log in as alice, got %+v", hubot)
+	}
+	if rr, _ := login("octocat", &aliceSession); rr.Code != http.StatusConflict {
+		t.Errorf("expected 409 linking a GitHub user linked to another account, got %d", rr.Code)
+	}
+
+	// A forged state is refused
+	req := httptest.NewRequest(http.MethodGet, "/auth/github/callback?code=octocat&state=forged", nil)
+	stateToken, _ := signer.Issue(auth.Claims{State: "real"}, time.Minute)
+	req.AddCookie(&http.Cookie{Name: githubStateCookie, Value: stateToken})
+	rr = httptest.NewRecorder()
+	GitHubCallbackHandler(rr, req, db, provider, signer, throttle)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a forged state, got %d", rr.Code)
+	}
+}
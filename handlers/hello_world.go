@@ -1,9 +0,0 @@
-package handlers
-
-import (
-	"net/http"
-)
-
-func HelloWorldHandler(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("Hello, World!"))
-}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"static-site-hosting/config"
+)
+
+// Build metadata, set by SetBuildInfo from values linked into the binary
+var (
+	buildVersion = "dev"
+	buildCommit  = ""
+	startedAt    = time.Now()
+)
+
+// SetBuildInfo records the version and commit the server was built from. An
+// empty commit falls back to the VCS revision Go stamps into the binary.
+func SetBuildInfo(version, commit string) {
+	if version != "" {
+		buildVersion = version
+	}
+	if commit == "" {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, s := range info.Settings {
+				if s.Key == "vcs.revision" {
+					commit = s.Value
+				}
+			}
+		}
+	}
+	buildCommit = commit
+	startedAt = time.Now()
+}
+
+// ServerInfo describes the running server for monitoring and client
+// compatibility checks
+type ServerInfo struct {
+	Version       string          `json:"version"`
+	Commit        string          `json:"commit,omitempty"`
+	StartedAt     time.Time       `json:"started_at"`
+	UptimeSeconds int64           `json:"uptime_seconds"`
+	Storage       string          `json:"storage_backend"`
+	Database      string          `json:"database_backend"`
+	Features      map[string]bool `json:"features"`
+}
+
+// InfoHandler reports the server's version, uptime, backends and enabled
+// features: GET /api/v1/info
+func InfoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ServerInfo{
+		Version:       buildVersion,
+		Commit:        buildCommit,
+		StartedAt:     startedAt.UTC(),
+		UptimeSeconds: int64(time.Since(startedAt).Seconds()),
+		Storage:       "local",
+		Database:      "sqlite",
+		Features:      enabledFeatures(),
+	})
+}
+
+// enabledFeatures lists optional behaviour switched on by configuration or
+// feature flags
+func enabledFeatures() map[string]bool {
+	enabled := map[string]bool{
+		"webdav_write":     cfg.WebDAVReadWrite,
+		"oidc_login":       cfg.OIDCIssuer != "",
+		"ldap_login":       cfg.LDAPURL != "",
+		"retention":        cfg.RetentionMaxAge > 0,
+		"duplicate_reuse":  cfg.DuplicateUploads != config.DuplicateOff,
+		"validation":       len(deployValidators()) > 0,
+		"smoke_test":       cfg.SmokeTest,
+		"immutable_chattr": cfg.ImmutableChattr,
+		"static_host":      cfg.StaticHost != "",
+		"site_domains":     cfg.SiteDomain != "" || len(cfg.CustomDomains) > 0,
+		"root_serving":     cfg.StaticRootServing,
+	}
+	for _, f := range flags.List() {
+		enabled[f.Name] = f.Enabled
+	}
+	return enabled
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInfoHandler(t *testing.T) {
+	SetBuildInfo("1.2.0", "abc123")
+	saved := cfg.SmokeTest
+	cfg.SmokeTest = true
+	defer func() { cfg.SmokeTest = saved }()
+
+	rr := httptest.NewRecorder()
+	InfoHandler(rr, httptest.NewRequest(http.MethodGet, "/api/v1/info", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	var info ServerInfo
+	if err := json.NewDecoder(rr.Body).Decode(&info); err != nil {
+		t.Fatalf("failed to decode info: %v", err)
+	}
+	if info.Version != "1.2.0" || info.Commit != "abc123" {
+		t.Errorf("unexpected build info %q %q", info.Version, info.Commit)
+	}
+	if info.Storage != "local" || info.Database != "sqlite" {
+		t.Errorf("unexpected backends %q %q", info.Storage, info.Database)
+	}
+	if !info.Features["smoke_test"] || info.Features["webdav_write"] {
+		t.Errorf("unexpected features %v", info.Features)
+	}
+
+	rr = httptest.NewRecorder()
+	InfoHandler(rr, httptest.NewRequest(http.MethodPost, "/api/v1/info", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for POST, got %d", rr.Code)
+	}
+}
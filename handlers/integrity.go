@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"static-site-hosting/integrity"
+)
+
+// integrityChecker runs on-demand checks with the nightly job's settings
+// and notifications; nil checks everything and only reports back
+var integrityChecker *integrity.Checker
+
+// SetIntegrityChecker installs the checker used by POST /admin/integrity
+func SetIntegrityChecker(c *integrity.Checker) {
+	integrityChecker = c
+}
+
+// recordFileHashes stores the manifest integrity checks compare against.
+// Failures are logged: the next check records a baseline instead.
+func recordFileHashes(db *sql.DB, deploymentID, dir string) {
+	if err := integrity.Record(db, deploymentID, dir); err != nil {
+		log.Printf("Warning: Failed to record file manifest of deployment %s: %v", deploymentID, err)
+	}
+}
+
+// IntegrityHandler lists recent integrity reports or runs a check now:
+//
+//	GET  /admin/integrity?limit=10
+//	POST /admin/integrity?sample=10  checks a 10% sample instead of the configured one
+func IntegrityHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	switch r.Method {
+	case http.MethodGet:
+		limit := 10
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		reports, err := integrity.Reports(db, limit)
+		if err != nil {
+			http.Error(w, "Failed to fetch integrity reports", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reports)
+
+	case http.MethodPost:
+		checker := integrity.Checker{DB: db, SamplePercent: 100}
+		if integrityChecker != nil {
+			checker = *integrityChecker
+		}
+		if v := r.URL.Query().Get("sample"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 100 {
+				http.Error(w, "sample must be a percentage from 1 to 100", http.StatusBadRequest)
+				return
+			}
+			checker.SamplePercent = n
+		}
+		report, err := checker.CheckNow()
+		if err != nil {
+			http.Error(w, "Integrity check failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+
+	default:
+		http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+	}
+}
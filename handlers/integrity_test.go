@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"static-site-hosting/immutable"
+	"static-site-hosting/integrity"
+	"static-site-hosting/models"
+)
+
+func TestIntegrityCheckAfterUpload(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	upload := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { UploadHandler(w, r, db) })
+	rr := uploadZip(t, upload, map[string]string{"index.html": "<h1>ok</h1>", "app.js": "run()"})
+	var d models.Deployment
+	json.NewDecoder(rr.Body).Decode(&d)
+
+	files, _ := integrity.Manifest(db, d.ID)
+	if len(files) != 2 {
+		t.Fatalf("expected the upload to record a manifest of 2 files, got %+v", files)
+	}
+
+	db.Exec("INSERT INTO webhooks (id, url, secret) VALUES ('wh-1', 'https://example.com/hook', '')")
+	immutable.Unseal(d.Path)
+	os.WriteFile(filepath.Join(d.Path, "app.js"), []byte("evil()"), 0644)
+
+	check := func(method, target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		IntegrityHandler(rr, httptest.NewRequest(method, target, nil), db)
+		return rr
+	}
+
+	if rr := check(http.MethodPost, "/admin/integrity?sample=0"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid sample, got %d", rr.Code)
+	}
+
+	rr = check(http.MethodPost, "/admin/integrity")
+	var report integrity.Report
+	json.NewDecoder(rr.Body).Decode(&report)
+	if rr.Code != http.StatusOK || len(report.Problems) != 1 || report.Problems[0].Path != "app.js" || report.Problems[0].DeploymentID != d.ID {
+		t.Fatalf("expected app.js to be reported modified, got %d %+v", rr.Code, report)
+	}
+
+	var event string
+	db.QueryRow("SELECT event FROM webhook_deliveries").Scan(&event)
+	if event != "integrity.corrupted" {
+		t.Errorf("expected an integrity.corrupted webhook, got %q", event)
+	}
+
+	rr = check(http.MethodGet, "/admin/integrity")
+	var reports []integrity.Report
+	json.NewDecoder(rr.Body).Decode(&reports)
+	if len(reports) != 1 || reports[0].ID != report.ID {
+		t.Errorf("expected the stored report, got %+v", reports)
+	}
+}
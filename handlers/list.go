@@ -1,21 +1,31 @@
 package handlers
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"static-site-hosting/models"
 )
 
-// Updated to use models.Deployment
+// ListDeploymentsHandler lists the caller's deployments, newest first.
+// Admins see everyone's. An unchanged list is answered with 304 Not
+// Modified when the request's If-None-Match has its ETag.
 func ListDeploymentsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "GET required", http.StatusMethodNotAllowed)
 		return
 	}
 
-	rows, err := db.Query("SELECT id, filename, timestamp, path FROM deployments ORDER BY timestamp DESC")
+	where, args := ownerScope(r)
+	if etag, err := listETag(db, r, where, args); err == nil && notModified(w, r, etag) {
+		return
+	}
+	rows, err := db.Query("SELECT id, filename, timestamp, path, site, archive_sha256, status, owner_id, deployed_by, visibility FROM deployments"+where+" ORDER BY timestamp DESC", args...)
 	if err != nil {
 		http.Error(w, "Failed to fetch deployments", http.StatusInternalServerError)
 		return
@@ -25,11 +35,14 @@ func ListDeploymentsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB)
 	var deployments []models.Deployment
 	for rows.Next() {
 		var d models.Deployment
-		err := rows.Scan(&d.ID, &d.Filename, &d.Timestamp, &d.Path)
+		err := rows.Scan(&d.ID, &d.Filename, &d.Timestamp, &d.Path, &d.Site, &d.ArchiveSHA256, &d.Status, &d.OwnerID, &d.DeployedBy, &d.Visibility)
 		if err != nil {
 			http.Error(w, "Failed to scan deployment", http.StatusInternalServerError)
 			return
 		}
+		if d.Status == models.StatusReady {
+			withURLs(r, &d)
+		}
 		deployments = append(deployments, d)
 	}
 
@@ -39,3 +52,35 @@ func ListDeploymentsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB)
 		return
 	}
 }
+
+// listETag returns a weak ETag for a list of the deployments matching where,
+// computed without building the list so pollers that already have it cost
+// one aggregate query. Deployment rows are never updated, so their IDs and
+// live storage stand for their content; the base URL their links are made
+// with is mixed in as well.
+func listETag(db *sql.DB, r *http.Request, where string, args []any) (string, error) {
+	var count, bytes int64
+	var ids string
+	err := db.QueryRow(`SELECT COUNT(*), COALESCE(group_concat(d.id), ''),
+			COALESCE(SUM((SELECT SUM(u.bytes) FROM deployment_usage u WHERE u.deployment_id = d.id AND u.deleted_at IS NULL)), 0)
+		FROM deployments d`+where, args...).Scan(&count, &ids, &bytes)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d\x00%s\x00%d\x00%s", count, ids, bytes, publicBaseURL(r))))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// notModified sets etag on the response and, if the request's
+// If-None-Match already has it, answers 304 and reports true. Responses
+// are per caller and must be revalidated before reuse.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !etagMatches(strings.ReplaceAll(header, "W/", ""), strings.TrimPrefix(etag, "W/")) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"static-site-hosting/auth"
 	"static-site-hosting/models"
+	"strings"
 	"testing"
 	"time"
 
@@ -155,3 +158,121 @@ func TestListDeploymentsHandlerInvalidMethod(t *testing.T) {
 		t.Errorf("expected status 405, got %d", status)
 	}
 }
+
+func TestSitesHandler(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	now := time.Now()
+	for _, d := range []struct {
+		id, site, status string
+		age              time.Duration
+		bytes            int64
+	}{
+		{"docs-1", "docs", models.StatusReady, 3 * time.Hour, 100},
+		{"docs-2", "docs", models.StatusReady, 2 * time.Hour, 200},
+		{"docs-3", "docs", models.StatusFailed, time.Hour, 50},
+		{"blog-1", "blog", models.StatusReady, time.Minute, 10},
+		{"one-off", "", models.StatusReady, time.Minute, 1},
+	} {
+		db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site, status) VALUES (?, 'site.zip', ?, ?, ?, ?)",
+			d.id, now.Add(-d.age), "deployments/"+d.id, d.site, d.status)
+		db.Exec("INSERT INTO deployment_usage (deployment_id, tenant_id, bytes, build_ms, created_at) VALUES (?, 'default', ?, 0, ?)",
+			d.id, d.bytes, now)
+	}
+
+	rr := httptest.NewRecorder()
+	SitesHandler(rr, httptest.NewRequest(http.MethodGet, "/sites", nil), db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var sites []models.SiteSummary
+	if err := json.NewDecoder(rr.Body).Decode(&sites); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if len(sites) != 2 || sites[0].Site != "blog" || sites[1].Site != "docs" {
+		t.Fatalf("expected blog then docs, got %+v", sites)
+	}
+	docs := sites[1]
+	if docs.LiveDeployment == nil || docs.LiveDeployment.ID != "docs-2" {
+		t.Errorf("expected docs-2 live, got %+v", docs.LiveDeployment)
+	}
+	if docs.LatestDeployment == nil || docs.LatestDeployment.ID != "docs-3" {
+		t.Errorf("expected docs-3 latest, got %+v", docs.LatestDeployment)
+	}
+	if docs.DeploymentCount != 3 || docs.FailedCount != 1 || docs.TotalBytes != 350 {
+		t.Errorf("expected 3 deployments, 1 failed and 350 bytes, got %d, %d and %d", docs.DeploymentCount, docs.FailedCount, docs.TotalBytes)
+	}
+}
+
+func TestSitesHandlerEmpty(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	rr := httptest.NewRecorder()
+	SitesHandler(rr, httptest.NewRequest(http.MethodGet, "/sites", nil), db)
+	if body := strings.TrimSpace(rr.Body.String()); body != "[]" {
+		t.Errorf("expected an empty list, got %s", body)
+	}
+}
+
+func TestListETags(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	insert := func(id, site string, claims *auth.Claims) {
+		db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site, owner_id) VALUES (?, 'site.zip', ?, ?, ?, ?)",
+			id, time.Now(), "deployments/"+id, site, requestOwner(as(httptest.NewRequest(http.MethodGet, "/", nil), claims)))
+	}
+	list := func(handler func(http.ResponseWriter, *http.Request, *sql.DB), path, etag string, claims *auth.Claims) *httptest.ResponseRecorder {
+		req := as(httptest.NewRequest(http.MethodGet, path, nil), claims)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req, db)
+		return rr
+	}
+	insert("docs-1", "docs", aliceClaims)
+
+	for path, handler := range map[string]func(http.ResponseWriter, *http.Request, *sql.DB){
+		"/deployments": ListDeploymentsHandler,
+		"/sites":       SitesHandler,
+	} {
+		first := list(handler, path, "", aliceClaims)
+		etag := first.Header().Get("ETag")
+		if first.Code != http.StatusOK || etag == "" {
+			t.Fatalf("%s: expected 200 with an ETag, got %d %q", path, first.Code, etag)
+		}
+		if rr := list(handler, path, etag, aliceClaims); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+			t.Errorf("%s: expected 304 with no body, got %d", path, rr.Code)
+		}
+		if rr := list(handler, path, `"other", `+strings.TrimPrefix(etag, "W/"), aliceClaims); rr.Code != http.StatusNotModified {
+			t.Errorf("%s: expected a strong form of the ETag in a list to match, got %d", path, rr.Code)
+		}
+		if rr := list(handler, path, `"other"`, aliceClaims); rr.Code != http.StatusOK {
+			t.Errorf("%s: expected a stale ETag to get the list, got %d", path, rr.Code)
+		}
+	}
+
+	etag := list(ListDeploymentsHandler, "/deployments", "", aliceClaims).Header().Get("ETag")
+	insert("blog-1", "blog", bobClaims)
+	if rr := list(ListDeploymentsHandler, "/deployments", etag, aliceClaims); rr.Code != http.StatusNotModified {
+		t.Errorf("expected bob's deployment not to change alice's list, got %d", rr.Code)
+	}
+	insert("docs-2", "docs", aliceClaims)
+	if rr := list(ListDeploymentsHandler, "/deployments", etag, aliceClaims); rr.Code != http.StatusOK {
+		t.Errorf("expected a new deployment to change the list, got %d", rr.Code)
+	}
+
+	etag = list(SitesHandler, "/sites", "", aliceClaims).Header().Get("ETag")
+	db.Exec("DELETE FROM deployments WHERE id = 'blog-1'")
+	if rr := list(SitesHandler, "/sites", etag, aliceClaims); rr.Code != http.StatusNotModified {
+		t.Errorf("expected bob's deployment not to change alice's sites, got %d", rr.Code)
+	}
+	db.Exec("DELETE FROM deployments WHERE id = 'docs-1'")
+	if rr := list(SitesHandler, "/sites", etag, aliceClaims); rr.Code != http.StatusOK {
+		t.Errorf("expected a deleted deployment to change the sites, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+
+	"static-site-hosting/locks"
+)
+
+// lockMutation takes the mutation locks for keys on behalf of op, answering
+// 409 Conflict when another operation holds any of them
+func lockMutation(w http.ResponseWriter, op string, keys ...string) (func(), bool) {
+	unlock, err := locks.Default.TryLock(op, keys...)
+	if err != nil {
+		http.Error(w, "Conflicting operation in progress: "+err.Error(), http.StatusConflict)
+		return nil, false
+	}
+	return unlock, true
+}
+
+// lockAllMutations is lockMutation for operations touching every deployment
+func lockAllMutations(w http.ResponseWriter, op string) (func(), bool) {
+	unlock, err := locks.Default.TryLockAll(op)
+	if err != nil {
+		http.Error(w, "Conflicting operation in progress: "+err.Error(), http.StatusConflict)
+		return nil, false
+	}
+	return unlock, true
+}
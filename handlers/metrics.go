@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"static-site-hosting/metrics"
+)
+
+// MetricsHandler exposes request counters and SLIs in Prometheus text format
+func MetricsHandler(w http.ResponseWriter, r *http.Request, rec *metrics.Recorder) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	totals := rec.Totals()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# TYPE http_requests_total counter")
+	fmt.Fprintf(w, "http_requests_total %d\n", totals.Requests)
+	fmt.Fprintln(w, "# TYPE http_requests_errors_total counter")
+	fmt.Fprintf(w, "http_requests_errors_total %d\n", totals.Errors)
+	fmt.Fprintln(w, "# TYPE static_requests_total counter")
+	fmt.Fprintf(w, "static_requests_total %d\n", totals.Static)
+	fmt.Fprintln(w, "# TYPE static_requests_fast_total counter")
+	fmt.Fprintf(w, "static_requests_fast_total %d\n", totals.StaticFast)
+	fmt.Fprintln(w, "# TYPE static_range_requests_total counter")
+	fmt.Fprintf(w, "static_range_requests_total %d\n", rangeStats.requests.Load())
+	fmt.Fprintln(w, "# TYPE static_range_partial_responses_total counter")
+	fmt.Fprintf(w, "static_range_partial_responses_total %d\n", rangeStats.partial.Load())
+	fmt.Fprintln(w, "# TYPE static_range_partial_bytes_total counter")
+	fmt.Fprintf(w, "static_range_partial_bytes_total %d\n", rangeStats.partialBytes.Load())
+	fmt.Fprintln(w, "# TYPE static_range_unsatisfiable_total counter")
+	fmt.Fprintf(w, "static_range_unsatisfiable_total %d\n", rangeStats.unsatisfiable.Load())
+	fmt.Fprintln(w, "# TYPE static_range_ignored_total counter")
+	fmt.Fprintf(w, "static_range_ignored_total %d\n", rangeStats.ignored.Load())
+
+	fmt.Fprintln(w, "# TYPE sli_availability_ratio gauge")
+	for _, win := range metrics.Windows {
+		fmt.Fprintf(w, "sli_availability_ratio{window=%q} %g\n", win.Name, rec.Window(win.Duration).Availability())
+	}
+	fmt.Fprintln(w, "# TYPE sli_static_latency_ratio gauge")
+	for _, win := range metrics.Windows {
+		fmt.Fprintf(w, "sli_static_latency_ratio{window=%q} %g\n", win.Name, rec.Window(win.Duration).LatencySLI())
+	}
+}
+
+type sloWindow struct {
+	Window              string  `json:"window"`
+	Requests            int64   `json:"requests"`
+	Errors              int64   `json:"errors"`
+	StaticRequests      int64   `json:"static_requests"`
+	Availability        float64 `json:"availability"`
+	StaticLatency       float64 `json:"static_latency"`
+	AvailabilityBudget  float64 `json:"availability_budget_remaining"`
+	StaticLatencyBudget float64 `json:"static_latency_budget_remaining"`
+}
+
+// SLOHandler reports SLIs and remaining error budget per rolling window
+func SLOHandler(w http.ResponseWriter, r *http.Request, rec *metrics.Recorder) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var windows []sloWindow
+	for _, win := range metrics.Windows {
+		c := rec.Window(win.Duration)
+		windows = append(windows, sloWindow{
+			Window:              win.Name,
+			Requests:            c.Requests,
+			Errors:              c.Errors,
+			StaticRequests:      c.Static,
+			Availability:        c.Availability(),
+			StaticLatency:       c.LatencySLI(),
+			AvailabilityBudget:  metrics.ErrorBudgetRemaining(c.Availability(), cfg.SLOAvailabilityTarget),
+			StaticLatencyBudget: metrics.ErrorBudgetRemaining(c.LatencySLI(), cfg.SLOLatencyTarget),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"targets": map[string]interface{}{
+			"availability":         cfg.SLOAvailabilityTarget,
+			"static_latency":       cfg.SLOLatencyTarget,
+			"static_latency_under": metrics.FastThreshold.String(),
+		},
+		"windows": windows,
+	})
+}
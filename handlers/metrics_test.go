@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"static-site-hosting/metrics"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	rec := metrics.NewRecorder()
+	rec.Observe("static", http.StatusOK, time.Millisecond)
+	rec.Observe("api", http.StatusInternalServerError, time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rr := httptest.NewRecorder()
+
+	MetricsHandler(rr, req, rec)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		"http_requests_total 2",
+		"http_requests_errors_total 1",
+		`sli_availability_ratio{window="5m"} 0.5`,
+		`sli_static_latency_ratio{window="5m"} 1`,
+		"# TYPE static_range_partial_bytes_total counter",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics output to contain %q", want)
+		}
+	}
+}
+
+func TestSLOHandler(t *testing.T) {
+	rec := metrics.NewRecorder()
+	rec.Observe("static", http.StatusOK, time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/slo", nil)
+	rr := httptest.NewRecorder()
+
+	SLOHandler(rr, req, rec)
+
+	var response struct {
+		Windows []sloWindow `json:"windows"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if len(response.Windows) != len(metrics.Windows) {
+		t.Fatalf("expected %d windows, got %d", len(metrics.Windows), len(response.Windows))
+	}
+	if response.Windows[0].Requests != 1 || response.Windows[0].AvailabilityBudget != 1 {
+		t.Errorf("unexpected 5m window: %+v", response.Windows[0])
+	}
+}
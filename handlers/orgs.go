@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+	"static-site-hosting/orgs"
+	"static-site-hosting/users"
+)
+
+// OrgsHandler lists (GET) the organizations the caller belongs to, every
+// one for admins, or creates (POST) one with the caller as its owner
+// Expected: POST /orgs {"name": "web-team"}
+func OrgsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if auth.FromContext(r.Context()) == nil {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		subject := requestOwner(r)
+		if isAdmin(r) {
+			subject = ""
+		}
+		list, err := orgs.New(db).List(subject)
+		if err != nil {
+			http.Error(w, "Failed to fetch organizations", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			writeFieldErrors(w, http.StatusBadRequest, models.FieldError{Field: "name", Code: models.CodeMissing, Message: "name is required"})
+			return
+		}
+		if len(req.Name) > 100 {
+			writeFieldErrors(w, http.StatusBadRequest, models.FieldError{Field: "name", Code: models.CodeInvalid, Message: "name must be at most 100 characters"})
+			return
+		}
+		org, err := orgs.New(db).Create(req.Name, requestOwner(r))
+		if err == orgs.ErrNameTaken {
+			writeFieldErrors(w, http.StatusConflict, models.FieldError{Field: "name", Code: models.CodeInvalid, Message: "name is already taken"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to create organization %s: %v", req.Name, err)
+			http.Error(w, "Failed to create organization", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(org)
+
+	default:
+		http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+	}
+}
+
+// OrgHandler shows (GET) or deletes (DELETE) an organization, and manages
+// its members and sites. Members see it; owners manage it. Deployments
+// owned by an organization are seen and changed by all of its members.
+//
+//	PUT /orgs/{id}/members {"username": "bob", "role": "member"}
+//	DELETE /orgs/{id}/members/{subject}
+//	PUT /orgs/{id}/sites/{site}
+func OrgHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if auth.FromContext(r.Context()) == nil {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/orgs/"), "/")
+	store := orgs.New(db)
+	role := store.Role(id, requestOwner(r))
+	if isAdmin(r) {
+		if _, err := store.Get(id); err != nil && err != sql.ErrNoRows {
+			http.Error(w, "Failed to fetch organization", http.StatusInternalServerError)
+			return
+		} else if err == nil {
+			role = orgs.RoleOwner
+		}
+	}
+	if role == "" {
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case rest == "":
+		orgResource(w, r, db, store, id, role)
+	case rest == "members" || strings.HasPrefix(rest, "members/"):
+		orgMembers(w, r, db, store, id, role, strings.TrimPrefix(strings.TrimPrefix(rest, "members"), "/"))
+	case strings.HasPrefix(rest, "sites/"):
+		orgSite(w, r, db, id, strings.TrimPrefix(rest, "sites/"))
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+func orgResource(w http.ResponseWriter, r *http.Request, db *sql.DB, store *orgs.Store, id, role string) {
+	switch r.Method {
+	case http.MethodGet:
+		org, err := store.Get(id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch organization", http.StatusInternalServerError)
+			return
+		}
+		org.Role = role
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(org)
+
+	case http.MethodDelete:
+		if role != orgs.RoleOwner {
+			http.Error(w, "Only owners can delete an organization", http.StatusForbidden)
+			return
+		}
+		var owned int
+		if err := db.QueryRow("SELECT COUNT(*) FROM deployments WHERE owner_id = ?", orgs.Subject(id)).Scan(&owned); err != nil {
+			http.Error(w, "Failed to check deployments", http.StatusInternalServerError)
+			return
+		}
+		if owned > 0 {
+			http.Error(w, "Organization still owns deployments; delete them first", http.StatusConflict)
+			return
+		}
+		if err := store.Delete(id); err != nil {
+			http.Error(w, "Failed to delete organization", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Organization deleted", "id": id})
+
+	default:
+		http.Error(w, "GET or DELETE required", http.StatusMethodNotAllowed)
+	}
+}
+
+// orgMembers adds a member or changes its role (PUT), or removes one
+// (DELETE). Members may leave on their own; anything else takes an owner.
+func orgMembers(w http.ResponseWriter, r *http.Request, db *sql.DB, store *orgs.Store, id, role, subject string) {
+	switch r.Method {
+	case http.MethodPut:
+		if role != orgs.RoleOwner {
+			http.Error(w, "Only owners can manage members", http.StatusForbidden)
+			return
+		}
+		var req struct {
+			Subject  string `json:"subject"`
+			Username string `json:"username"`
+			Role     string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if req.Role == "" {
+			req.Role = orgs.RoleMember
+		}
+		var errs models.ValidationErrors
+		if req.Role != orgs.RoleOwner && req.Role != orgs.RoleMember {
+			errs.Add("role", models.CodeInvalid, "role must be owner or member")
+		}
+		switch {
+		case req.Subject != "" && req.Username != "":
+			errs.Add("username", models.CodeInvalid, "give a subject or a username, not both")
+		case req.Username != "":
+			u, err := users.ByUsername(db, req.Username)
+			if err == sql.ErrNoRows {
+				errs.Add("username", models.CodeInvalid, "no account is named %s", req.Username)
+			} else if err != nil {
+				http.Error(w, "Failed to look up account", http.StatusInternalServerError)
+				return
+			} else {
+				req.Subject = u.Subject()
+			}
+		case req.Subject == "":
+			errs.Add("subject", models.CodeMissing, "subject or username is required")
+		}
+		if len(errs) > 0 {
+			writeFieldErrors(w, http.StatusBadRequest, errs...)
+			return
+		}
+		if err := store.SetMember(id, req.Subject, req.Role); err == orgs.ErrLastOwner {
+			http.Error(w, "An organization needs at least one owner", http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to save member", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"org_id": id, "subject": req.Subject, "role": req.Role})
+
+	case http.MethodDelete:
+		if subject == "" {
+			http.Error(w, "Member subject required", http.StatusBadRequest)
+			return
+		}
+		if role != orgs.RoleOwner && subject != requestOwner(r) {
+			http.Error(w, "Only owners can remove other members", http.StatusForbidden)
+			return
+		}
+		if err := store.RemoveMember(id, subject); err == orgs.ErrLastOwner {
+			http.Error(w, "An organization needs at least one owner", http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to remove member", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Member removed", "subject": subject})
+
+	default:
+		http.Error(w, "PUT or DELETE required", http.StatusMethodNotAllowed)
+	}
+}
+
+// orgSite moves every deployment of a site the caller owns to the
+// organization, so all its members share it. Later deployments of the
+// site stay with the organization.
+func orgSite(w http.ResponseWriter, r *http.Request, db *sql.DB, id, site string) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "PUT required", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := siteSlugError(site); err != nil {
+		writeFieldErrors(w, http.StatusBadRequest, *err)
+		return
+	}
+	if owns, err := ownsSite(db, r, site); err != nil {
+		http.Error(w, "Failed to check site ownership", http.StatusInternalServerError)
+		return
+	} else if !owns {
+		http.Error(w, "Site belongs to another owner", http.StatusForbidden)
+		return
+	}
+
+	result, err := db.Exec("UPDATE deployments SET owner_id = ? WHERE site = ?", orgs.Subject(id), site)
+	if err != nil {
+		http.Error(w, "Failed to move site", http.StatusInternalServerError)
+		return
+	}
+	moved, _ := result.RowsAffected()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"site": site, "org_id": id, "deployments": moved})
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+	"static-site-hosting/orgs"
+)
+
+func TestOrganizationsShareDeployments(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	SetOrgStore(orgs.New(db))
+	defer SetOrgStore(nil)
+	carolClaims := &auth.Claims{Subject: "user:carol", Role: auth.RoleDeployer}
+
+	call := func(method, path string, body any, claims *auth.Claims) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := as(httptest.NewRequest(method, path, bytes.NewReader(data)), claims)
+		rr := httptest.NewRecorder()
+		if path == "/orgs" {
+			OrgsHandler(rr, req, db)
+		} else {
+			OrgHandler(rr, req, db)
+		}
+		return rr
+	}
+	list := func(claims *auth.Claims) []models.Deployment {
+		rr := httptest.NewRecorder()
+		ListDeploymentsHandler(rr, as(httptest.NewRequest(http.MethodGet, "/deployments", nil), claims), db)
+		var deployments []models.Deployment
+		json.NewDecoder(rr.Body).Decode(&deployments)
+		return deployments
+	}
+	upload := func(claims *auth.Claims) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) { UploadHandler(w, as(r, claims), db) }
+	}
+
+	rr := call(http.MethodPost, "/orgs", map[string]string{"name": "web-team"}, aliceClaims)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var org orgs.Organization
+	json.NewDecoder(rr.Body).Decode(&org)
+	if rr := call(http.MethodPost, "/orgs", map[string]string{"name": "web-team"}, bobClaims); rr.Code != http.StatusConflict {
+		t.Errorf("expected a taken name to be refused, got %d", rr.Code)
+	}
+	if rr := call(http.MethodGet, "/orgs/"+org.ID, nil, bobClaims); rr.Code != http.StatusNotFound {
+		t.Errorf("expected outsiders not to see the organization, got %d", rr.Code)
+	}
+
+	// Alice shares her site with the organization, and adds bob
+	uploadToSite(t, upload(aliceClaims), "team", testZipBytes(t))
+	if rr := call(http.MethodPut, "/orgs/"+org.ID+"/sites/team", nil, aliceClaims); rr.Code != http.StatusOK {
+		t.Fatalf("expected alice to share her site, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := call(http.MethodPut, "/orgs/"+org.ID+"/members", map[string]string{"subject": "user:bob"}, aliceClaims); rr.Code != http.StatusOK {
+		t.Fatalf("expected alice to add bob, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := call(http.MethodPut, "/orgs/"+org.ID+"/members", map[string]string{"subject": "user:carol"}, bobClaims); rr.Code != http.StatusForbidden {
+		t.Errorf("expected members not to manage members, got %d", rr.Code)
+	}
+
+	if got := list(bobClaims); len(got) != 1 || got[0].Site != "team" {
+		t.Fatalf("expected bob to see the team's deployment, got %+v", got)
+	}
+	if got := list(carolClaims); len(got) != 0 {
+		t.Errorf("expected carol to see nothing, got %+v", got)
+	}
+	if owns, _ := ownsSite(db, as(httptest.NewRequest(http.MethodPost, "/upload", nil), carolClaims), "team"); owns {
+		t.Error("expected carol not to deploy to the team's site")
+	}
+
+	// Bob's deployment of the site belongs to the organization too
+	var bobs models.Deployment
+	archive, _ := os.ReadFile(writeZip(t, map[string]string{"index.html": "bob's change"}))
+	json.NewDecoder(uploadToSite(t, upload(bobClaims), "team", archive).Body).Decode(&bobs)
+	var owner string
+	db.QueryRow("SELECT owner_id FROM deployments WHERE id = ?", bobs.ID).Scan(&owner)
+	if owner != orgs.Subject(org.ID) {
+		t.Errorf("expected bob's deployment to be owned by the organization, got %q", owner)
+	}
+	if got := list(aliceClaims); len(got) != 2 {
+		t.Errorf("expected alice to see both deployments, got %d", len(got))
+	}
+
+	rr = httptest.NewRecorder()
+	DeleteDeploymentHandler(rr, as(httptest.NewRequest(http.MethodDelete, "/deployments/"+bobs.ID, nil), aliceClaims), db)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected alice to delete bob's deployment of the team's site, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := call(http.MethodDelete, "/orgs/"+org.ID+"/members/user:alice", nil, aliceClaims); rr.Code != http.StatusConflict {
+		t.Errorf("expected the last owner not to leave, got %d", rr.Code)
+	}
+	if rr := call(http.MethodDelete, "/orgs/"+org.ID, nil, aliceClaims); rr.Code != http.StatusConflict {
+		t.Errorf("expected an organization owning deployments not to be deleted, got %d", rr.Code)
+	}
+	if rr := call(http.MethodDelete, "/orgs/"+org.ID+"/members/user:bob", nil, bobClaims); rr.Code != http.StatusOK {
+		t.Fatalf("expected bob to leave, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := list(bobClaims); len(got) != 0 {
+		t.Errorf("expected bob to lose access on leaving, got %+v", got)
+	}
+}
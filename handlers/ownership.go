@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/orgs"
+)
+
+// orgStore records organizations; nil leaves every deployment with the
+// person who made it
+var orgStore *orgs.Store
+
+// SetOrgStore sets the store recording organizations and their members
+func SetOrgStore(s *orgs.Store) {
+	orgStore = s
+}
+
+// requestOwner returns the identity recorded as the owner of deployments
+// the request creates; empty for anonymous callers
+func requestOwner(r *http.Request) string {
+	if claims := auth.FromContext(r.Context()); claims != nil {
+		return claims.Subject
+	}
+	return ""
+}
+
+// isAdmin reports whether the caller is an admin, who may see and change
+// every owner's deployments. An admin's API token needs the admin scope.
+func isAdmin(r *http.Request) bool {
+	claims := auth.FromContext(r.Context())
+	return claims != nil && claims.Role == auth.RoleAdmin && claims.Permits(auth.ScopeAdmin)
+}
+
+// ownsDeployment reports whether the caller may see and change a deployment
+// owned by ownerID: its owner, or any member of the organization owning
+// it. Anonymous callers own the anonymous deployments, so installations
+// without accounts work as before.
+func ownsDeployment(r *http.Request, ownerID string) bool {
+	if isAdmin(r) || ownerID == requestOwner(r) {
+		return true
+	}
+	org, ok := orgs.FromSubject(ownerID)
+	return ok && orgStore.Role(org, requestOwner(r)) != ""
+}
+
+// ownedBy returns a condition and its arguments matching the deployments
+// the caller owns, alone or through an organization
+func ownedBy(r *http.Request) (string, []any) {
+	owner := requestOwner(r)
+	return "(owner_id = ? OR owner_id IN (SELECT 'org:' || org_id FROM organization_members WHERE subject = ?))", []any{owner, owner}
+}
+
+// ownerScope returns a WHERE clause and its arguments restricting a
+// deployments query to those the caller owns; empty for admins
+func ownerScope(r *http.Request) (string, []any) {
+	if isAdmin(r) {
+		return "", nil
+	}
+	cond, args := ownedBy(r)
+	return " WHERE " + cond, args
+}
+
+// ownsSite reports whether the caller may deploy to site: admins anywhere,
+// others only to sites without deployments of other owners, counting those
+// of their organizations as theirs
+func ownsSite(db *sql.DB, r *http.Request, site string) (bool, error) {
+	if site == "" || isAdmin(r) {
+		return true, nil
+	}
+	cond, args := ownedBy(r)
+	var others int
+	err := db.QueryRow("SELECT COUNT(*) FROM deployments WHERE site = ? AND NOT "+cond, append([]any{site}, args...)...).Scan(&others)
+	return others == 0, err
+}
+
+// authorizeSiteAccess checks that the caller owns site before reading or
+// changing it. Other owners' sites are indistinguishable from missing ones.
+// It answers the request and returns false when not.
+func authorizeSiteAccess(w http.ResponseWriter, r *http.Request, db *sql.DB, site string) bool {
+	owns, err := ownsSite(db, r, site)
+	if err != nil {
+		http.Error(w, "Failed to check site ownership", http.StatusInternalServerError)
+		return false
+	}
+	if !owns {
+		http.Error(w, "Site not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// authorizeSiteDeploy checks that the caller may deploy to site: deploy
+// tokens only to the site they were exchanged for, and everyone only to
+// sites they own. It answers the request, failing progress if it is an
+// upload, and returns false when not.
+func authorizeSiteDeploy(w http.ResponseWriter, r *http.Request, db *sql.DB, site string, progress *uploadTracker) bool {
+	if claims := auth.FromContext(r.Context()); claims != nil && claims.Site != "" && claims.Site != site {
+		msg := "Forbidden: token may only deploy site " + claims.Site
+		progress.fail(msg)
+		http.Error(w, msg, http.StatusForbidden)
+		return false
+	}
+	owns, err := ownsSite(db, r, site)
+	if err != nil {
+		progress.fail("Failed to check site ownership")
+		http.Error(w, "Failed to check site ownership", http.StatusInternalServerError)
+		return false
+	}
+	if !owns {
+		progress.fail("Site belongs to another owner")
+		http.Error(w, "Site belongs to another owner", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// deploymentOwner returns the owner to record for a new deployment of site:
+// the organization owning the site, if the caller is a member, otherwise
+// the caller
+func deploymentOwner(db *sql.DB, r *http.Request, site string) string {
+	owner := requestOwner(r)
+	if site == "" {
+		return owner
+	}
+	var latest string
+	err := db.QueryRow("SELECT owner_id FROM deployments WHERE site = ? ORDER BY timestamp DESC LIMIT 1", site).Scan(&latest)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Warning: Failed to look up the owner of site %s: %v", site, err)
+	}
+	if org, ok := orgs.FromSubject(latest); ok && (isAdmin(r) || orgStore.Role(org, owner) != "") {
+		return latest
+	}
+	return owner
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"static-site-hosting/artifacts"
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+)
+
+var (
+	aliceClaims = &auth.Claims{Subject: "user:alice", Role: auth.RoleDeployer}
+	bobClaims   = &auth.Claims{Subject: "user:bob", Role: auth.RoleDeployer}
+	adminClaims = &auth.Claims{Subject: "user:admin", Role: auth.RoleAdmin}
+)
+
+// as returns req made by the holder of claims; nil keeps it anonymous
+func as(req *http.Request, claims *auth.Claims) *http.Request {
+	if claims == nil {
+		return req
+	}
+	return req.WithContext(auth.WithClaims(req.Context(), claims))
+}
+
+func TestUploadRecordsOwner(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	zipBuffer, _ := createTestZip()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "site.zip")
+	io.Copy(part, zipBuffer)
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	UploadHandler(rr, as(req, aliceClaims), db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var ownerID string
+	db.QueryRow("SELECT owner_id FROM deployments").Scan(&ownerID)
+	if ownerID != "user:alice" {
+		t.Errorf("expected the deployment to be owned by user:alice, got %q", ownerID)
+	}
+}
+
+func TestDeploymentsAreIsolatedByOwner(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	for id, owner := range map[string]string{"alice-site": "user:alice", "bob-site": "user:bob", "anon-site": ""} {
+		path := filepath.Join("deployments", id)
+		os.MkdirAll(path, 0755)
+		os.WriteFile(filepath.Join(path, "index.html"), []byte(id), 0644)
+		db.Exec("INSERT INTO deployments (id, filename, timestamp, path, owner_id) VALUES (?, ?, ?, ?, ?)", id, id+".zip", time.Now(), path, owner)
+	}
+
+	list := func(claims *auth.Claims) map[string]bool {
+		rr := httptest.NewRecorder()
+		ListDeploymentsHandler(rr, as(httptest.NewRequest(http.MethodGet, "/deployments", nil), claims), db)
+		var deployments []models.Deployment
+		json.NewDecoder(rr.Body).Decode(&deployments)
+		ids := map[string]bool{}
+		for _, d := range deployments {
+			ids[d.ID] = true
+		}
+		return ids
+	}
+	if ids := list(aliceClaims); len(ids) != 1 || !ids["alice-site"] {
+		t.Errorf("expected alice to see only her deployment, got %v", ids)
+	}
+	if ids := list(nil); len(ids) != 1 || !ids["anon-site"] {
+		t.Errorf("expected anonymous callers to see only anonymous deployments, got %v", ids)
+	}
+	if ids := list(adminClaims); len(ids) != 3 {
+		t.Errorf("expected an admin to see every deployment, got %v", ids)
+	}
+
+	rr := httptest.NewRecorder()
+	RollbackHandler(rr, as(httptest.NewRequest(http.MethodPost, "/rollback/alice-site", nil), bobClaims), db)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected bob's rollback to alice's deployment to be 404, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	DeleteDeploymentHandler(rr, as(httptest.NewRequest(http.MethodDelete, "/deployments/alice-site", nil), bobClaims), db)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected bob's delete of alice's deployment to be 404, got %d", rr.Code)
+	}
+	if _, err := os.Stat(filepath.Join("deployments", "alice-site")); err != nil {
+		t.Errorf("expected alice's files to survive: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	DeleteDeploymentHandler(rr, as(httptest.NewRequest(http.MethodDelete, "/deployments/alice-site", nil), aliceClaims), db)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected alice to delete her deployment, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	DeleteDeploymentHandler(rr, as(httptest.NewRequest(http.MethodDelete, "/deployments/bob-site", nil), adminClaims), db)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected an admin to delete bob's deployment, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestDeployersOnlyDeployToTheirOwnSites(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site, owner_id) VALUES ('shop-1', 'shop.zip', ?, 'deployments/shop-1', 'shop', 'user:alice')", time.Now())
+	uploadAs := func(claims *auth.Claims) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) { UploadHandler(w, as(r, claims), db) }
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("site", "shop")
+	part, _ := writer.CreateFormFile("file", "shop.zip")
+	part.Write(testZipBytes(t))
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	uploadAs(bobClaims)(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected bob's deploy to alice's site to be 403, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM deployments WHERE site = 'shop'").Scan(&count)
+	if count != 1 {
+		t.Errorf("expected no deployment to be recorded, got %d", count)
+	}
+
+	uploadToSite(t, uploadAs(aliceClaims), "shop", testZipBytes(t))
+	uploadToSite(t, uploadAs(adminClaims), "shop", testZipBytes(t))
+}
+
+func TestRevisionsNeedOwnership(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	cfg.WebDAVReadWrite = true
+	defer func() { cfg.WebDAVReadWrite = false }()
+
+	path := filepath.Join("deployments", "alice-docs")
+	os.MkdirAll(path, 0755)
+	os.WriteFile(filepath.Join(path, "index.html"), []byte("alice"), 0644)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site, owner_id) VALUES ('alice-docs', 'docs.zip', ?, ?, 'docs', 'user:alice')", time.Now(), path)
+
+	edit := func(claims *auth.Claims) map[string]int {
+		codes := map[string]int{}
+		req := httptest.NewRequest(http.MethodPut, "/deployments/alice-docs/files/index.html", strings.NewReader("taken"))
+		req.Header.Set("If-Match", "*")
+		rr := httptest.NewRecorder()
+		DeploymentFileHandler(rr, as(req, claims), db)
+		codes["files API"] = rr.Code
+		rr = httptest.NewRecorder()
+		WebDAVHandler(rr, as(httptest.NewRequest(http.MethodPut, "/dav/alice-docs/new.html", strings.NewReader("taken")), claims), db)
+		codes["WebDAV"] = rr.Code
+		return codes
+	}
+
+	for route, code := range edit(bobClaims) {
+		if code != http.StatusNotFound {
+			t.Errorf("%s: expected bob's revision of alice's site to be 404, got %d", route, code)
+		}
+	}
+	token := &auth.Claims{Subject: "user:alice", Role: auth.RoleDeployer, Site: "blog"}
+	for route, code := range edit(token) {
+		if code != http.StatusForbidden {
+			t.Errorf("%s: expected a token for another site to be 403, got %d", route, code)
+		}
+	}
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM deployments WHERE site = 'docs' AND owner_id != 'user:alice'").Scan(&n)
+	if n != 0 {
+		t.Errorf("expected the site to stay alice's, found %d deployments of other owners", n)
+	}
+
+	if code := edit(aliceClaims)["WebDAV"]; code != http.StatusCreated {
+		t.Errorf("expected alice to revise her own site, got %d", code)
+	}
+}
+
+func TestSiteRoutesAreIsolatedByOwner(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	saved := artifacts.Default
+	defer func() { artifacts.Default = saved }()
+	artifacts.Default = artifacts.Local{Dir: t.TempDir()}
+
+	deploy := func(w http.ResponseWriter, r *http.Request) { SiteDeploymentsHandler(w, as(r, aliceClaims), db) }
+	var first, second models.Deployment
+	json.NewDecoder(putArchive(deploy, "shop", "application/gzip", createTestTarGz(t)).Body).Decode(&first)
+	json.NewDecoder(putArchive(deploy, "shop", "application/zip", testZipBytes(t)).Body).Decode(&second)
+	if first.ID == "" || second.ID == "" {
+		t.Fatal("failed to create alice's deployments")
+	}
+	if err := saveSiteExpiry(db, "shop", time.Now().Add(48*time.Hour).UTC()); err != nil {
+		t.Fatalf("failed to set expiry: %v", err)
+	}
+
+	routes := []struct {
+		name, method, path, body string
+		handler                  func(http.ResponseWriter, *http.Request, *sql.DB)
+	}{
+		{"artifact", http.MethodGet, "/deployments/" + first.ID + "/artifact", "", DeploymentArtifactHandler},
+		{"artifact extract", http.MethodPost, "/deployments/" + first.ID + "/artifact/extract", "", DeploymentArtifactHandler},
+		{"settings read", http.MethodGet, "/sites/" + first.ID + "/settings", "", SiteSettingsHandler},
+		{"settings write", http.MethodPut, "/sites/" + first.ID + "/settings", `{"redirects": []}`, SiteSettingsHandler},
+		{"expiry read", http.MethodGet, "/sites/shop/expiry", "", SiteExpiryHandler},
+		{"expiry write", http.MethodPut, "/sites/shop/expiry", `{"expires_at": "` + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + `"}`, SiteExpiryHandler},
+		{"expiry lift", http.MethodDelete, "/sites/shop/expiry", "", SiteExpiryHandler},
+		{"export", http.MethodGet, "/sites/shop/export", "", SiteExportHandler},
+		{"diff", http.MethodGet, "/deployments/" + second.ID + "/diff", "", DeploymentDiffHandler},
+		{"manifest", http.MethodGet, "/sites/shop/manifest.json", "", SiteManifestHandler},
+		{"pin", http.MethodPost, "/deployments/" + first.ID + "/pin", "", PinDeploymentHandler},
+	}
+	for _, route := range routes {
+		rr := httptest.NewRecorder()
+		route.handler(rr, as(httptest.NewRequest(route.method, route.path, strings.NewReader(route.body)), bobClaims), db)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%s: expected bob to get 404 for alice's site, got %d: %s", route.name, rr.Code, rr.Body.String())
+		}
+	}
+	var pins int
+	db.QueryRow("SELECT COUNT(*) FROM deployment_pins").Scan(&pins)
+	if pins != 0 {
+		t.Errorf("expected bob's pin to be refused, found %d pins", pins)
+	}
+	if expiresAt, _ := loadSiteExpiry(db, "shop"); expiresAt.Before(time.Now().Add(47 * time.Hour)) {
+		t.Errorf("expected alice's expiry to be untouched, got %v", expiresAt)
+	}
+
+	list := func(claims *auth.Claims) []models.SiteSummary {
+		rr := httptest.NewRecorder()
+		SitesHandler(rr, as(httptest.NewRequest(http.MethodGet, "/sites", nil), claims), db)
+		var summaries []models.SiteSummary
+		json.NewDecoder(rr.Body).Decode(&summaries)
+		return summaries
+	}
+	if summaries := list(bobClaims); len(summaries) != 0 {
+		t.Errorf("expected bob to see no sites, got %+v", summaries)
+	}
+	if summaries := list(aliceClaims); len(summaries) != 1 || summaries[0].Site != "shop" {
+		t.Errorf("expected alice to see her site, got %+v", summaries)
+	}
+	if summaries := list(adminClaims); len(summaries) != 1 {
+		t.Errorf("expected an admin to see every site, got %+v", summaries)
+	}
+
+	for _, route := range routes {
+		if route.method != http.MethodGet {
+			continue
+		}
+		rr := httptest.NewRecorder()
+		route.handler(rr, as(httptest.NewRequest(route.method, route.path, nil), aliceClaims), db)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected alice to read her own site, got %d: %s", route.name, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"static-site-hosting/atrest"
+	"static-site-hosting/routecache"
+)
+
+// preloadHint is a critical resource referenced from a page's <head>
+type preloadHint struct {
+	Href string `json:"href"` // site-relative unless it starts with "/" or a scheme
+	As   string `json:"as"`   // "style" or "script"
+}
+
+var (
+	headEndPattern   = regexp.MustCompile(`(?i)</head\s*>`)
+	linkTagPattern   = regexp.MustCompile(`(?is)<link\b[^>]*>`)
+	scriptTagPattern = regexp.MustCompile(`(?is)<script\b[^>]*>`)
+	attrPattern      = regexp.MustCompile(`(?is)\b([a-z-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	asyncAttrPattern = regexp.MustCompile(`(?i)\sasync(\s|=|/|>)`)
+)
+
+// findPreloadHints extracts stylesheets and scripts from the <head> of an
+// HTML document. pagePath is the page's path relative to the site root and is
+// used to resolve relative references.
+func findPreloadHints(html []byte, pagePath string) []preloadHint {
+	head := html
+	if loc := headEndPattern.FindIndex(html); loc != nil {
+		head = html[:loc[0]]
+	}
+
+	var hints []preloadHint
+	seen := map[string]bool{}
+	add := func(href, as string) {
+		href = strings.TrimSpace(href)
+		if href == "" || strings.HasPrefix(href, "data:") || strings.HasPrefix(href, "//") || strings.Contains(href, "://") {
+			// External origins can't be pushed from here
+			return
+		}
+		if !strings.HasPrefix(href, "/") {
+			href = path.Join(path.Dir(pagePath), href)
+		}
+		if !seen[href] {
+			seen[href] = true
+			hints = append(hints, preloadHint{Href: href, As: as})
+		}
+	}
+
+	for _, tag := range linkTagPattern.FindAll(head, -1) {
+		attrs := tagAttributes(tag)
+		if strings.EqualFold(attrs["rel"], "stylesheet") {
+			add(attrs["href"], "style")
+		}
+	}
+	for _, tag := range scriptTagPattern.FindAll(head, -1) {
+		attrs := tagAttributes(tag)
+		if _, async := attrs["async"]; async {
+			continue
+		}
+		if src, ok := attrs["src"]; ok {
+			add(src, "script")
+		}
+	}
+
+	return hints
+}
+
+func tagAttributes(tag []byte) map[string]string {
+	attrs := map[string]string{}
+	for _, m := range attrPattern.FindAllSubmatch(tag, -1) {
+		value := string(m[2]) + string(m[3]) + string(m[4])
+		attrs[strings.ToLower(string(m[1]))] = value
+	}
+	// Boolean attributes such as async have no value
+	if asyncAttrPattern.Match(tag) {
+		attrs["async"] = ""
+	}
+	return attrs
+}
+
+// recordPreloadHints scans every HTML file in a deployment and stores the
+// hints found. Failures are logged: missing hints only cost performance.
+func recordPreloadHints(db *sql.DB, deploymentID, dir string) {
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !isHTMLFile(p) {
+			return nil
+		}
+
+		content, err := atrest.ReadFile(dir, p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		hints := findPreloadHints(content, filepath.ToSlash(rel))
+		if len(hints) == 0 {
+			return nil
+		}
+
+		raw, _ := json.Marshal(hints)
+		_, err = db.Exec(
+			"INSERT OR REPLACE INTO preload_hints (deployment_id, page, hints) VALUES (?, ?, ?)",
+			deploymentID, filepath.ToSlash(rel), string(raw),
+		)
+		return err
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to record preload hints for %s: %v", deploymentID, err)
+	}
+	routecache.Invalidate()
+}
+
+func loadPreloadHints(db *sql.DB, deploymentID, page string) ([]preloadHint, error) {
+	var raw string
+	err := db.QueryRow("SELECT hints FROM preload_hints WHERE deployment_id = ? AND page = ?", deploymentID, page).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var hints []preloadHint
+	err = json.Unmarshal([]byte(raw), &hints)
+	return hints, err
+}
+
+// writePreloadHeaders adds Link preload headers for page, placing
+// site-relative hints under the prefix r is served at, and when requested
+// sends them ahead of the response as 103 Early Hints.
+func writePreloadHeaders(w http.ResponseWriter, r *http.Request, db *sql.DB, siteID, page string, earlyHints bool) {
+	hints, err := routecache.Lookup("preload:"+siteID+":"+page, func() ([]preloadHint, error) {
+		return loadPreloadHints(db, siteID, page)
+	})
+	if err != nil {
+		if err != routecache.ErrUnavailable {
+			log.Printf("Warning: Failed to load preload hints for %s/%s: %v", siteID, page, err)
+		}
+		return
+	}
+	if len(hints) == 0 {
+		return
+	}
+
+	for _, h := range hints {
+		href := h.Href
+		if !strings.HasPrefix(href, "/") {
+			href = sitePrefix(r, siteID) + "/" + href
+		}
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=preload; as=%s", href, h.As))
+	}
+
+	if earlyHints {
+		w.WriteHeader(http.StatusEarlyHints)
+	}
+}
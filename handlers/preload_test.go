@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"static-site-hosting/models"
+)
+
+func TestFindPreloadHints(t *testing.T) {
+	html := []byte(`<!DOCTYPE html>
+<html><head>
+<link rel="stylesheet" href="../css/app.css">
+<link rel="icon" href="favicon.ico">
+<link rel=stylesheet href="https://cdn.example.com/lib.css">
+<script src="/js/main.js"></script>
+<script async src="analytics.js"></script>
+</head><body>
+<script src="footer.js"></script>
+</body></html>`)
+
+	hints := findPreloadHints(html, "blog/index.html")
+
+	expected := []preloadHint{
+		{Href: "css/app.css", As: "style"},
+		{Href: "/js/main.js", As: "script"},
+	}
+	if len(hints) != len(expected) {
+		t.Fatalf("expected %d hints, got %d: %+v", len(expected), len(hints), hints)
+	}
+	for i := range expected {
+		if hints[i] != expected[i] {
+			t.Errorf("hint %d: expected %+v, got %+v", i, expected[i], hints[i])
+		}
+	}
+}
+
+func TestStaticFileHandlerPreloadHeaders(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	siteID := "preload-site"
+	sitePath := filepath.Join("deployments", siteID)
+	os.MkdirAll(sitePath, 0755)
+	recordDeployment(t, db, siteID)
+	os.WriteFile(filepath.Join(sitePath, "index.html"),
+		[]byte(`<html><head><link rel="stylesheet" href="style.css"></head><body></body></html>`), 0644)
+
+	recordPreloadHints(db, siteID, sitePath)
+	saveSiteSettings(db, siteID, models.SiteSettings{PreloadHeaders: true, EarlyHints: true})
+
+	server := httptest.NewServer(StaticFileHandler(db))
+	defer server.Close()
+
+	var earlyHints int
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				earlyHints++
+			}
+			return nil
+		},
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/preload-site/index.html", nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", resp.StatusCode)
+	}
+	if link := resp.Header.Get("Link"); link != "</preload-site/style.css>; rel=preload; as=style" {
+		t.Errorf("unexpected Link header %q", link)
+	}
+	if earlyHints != 1 {
+		t.Errorf("expected one 103 Early Hints response, got %d", earlyHints)
+	}
+}
+
+func TestPreloadHeadersFollowServingPrefix(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.SiteDomain = "sites.test"
+
+	siteID := "preload-site"
+	sitePath := filepath.Join("deployments", siteID)
+	os.MkdirAll(sitePath, 0755)
+	os.WriteFile(filepath.Join(sitePath, "index.html"),
+		[]byte(`<html><head><link rel="stylesheet" href="style.css"></head><body></body></html>`), 0644)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, 'site.zip', ?, ?)", siteID, time.Now(), sitePath)
+	recordPreloadHints(db, siteID, sitePath)
+	saveSiteSettings(db, siteID, models.SiteSettings{PreloadHeaders: true})
+
+	// Routed as cmd/main.go routes sites
+	static := StaticFileHandler(db)
+	mux := http.NewServeMux()
+	mux.Handle("/s/", http.StripPrefix("/s", static))
+	mux.Handle("/", static)
+	handler := SiteHostHandler(db, mux)
+
+	for _, tt := range []struct {
+		host, path, link string
+	}{
+		{"api.test", "/preload-site/index.html", "</preload-site/style.css>; rel=preload; as=style"},
+		{"api.test", "/s/preload-site/index.html", "</s/preload-site/style.css>; rel=preload; as=style"},
+		{"preload-site.sites.test", "/index.html", "</style.css>; rel=preload; as=style"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Host = tt.host
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s%s: expected status 200, got %d", tt.host, tt.path, rr.Code)
+		}
+		if link := rr.Header().Get("Link"); link != tt.link {
+			t.Errorf("%s%s: expected Link %q, got %q", tt.host, tt.path, tt.link, link)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"cmp"
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"static-site-hosting/atrest"
+)
+
+// maxCountedFiles bounds the files whose requests are counted per
+// deployment; files first requested after that aren't counted
+const maxCountedFiles = 10000
+
+// fileHits counts the requests for each file of each deployment while
+// prewarming is enabled, keyed by deployment ID and path within it
+var fileHits = struct {
+	sync.Mutex
+	counts map[string]map[string]int64
+}{counts: map[string]map[string]int64{}}
+
+// prewarmed holds files read ahead of their first request, keyed as
+// staticFileKey keys them. Files are dropped oldest first to stay within
+// cfg.PrewarmMaxBytes.
+var prewarmed = struct {
+	sync.Mutex
+	files map[string][]byte
+	order []string
+	size  int64
+}{files: map[string][]byte{}}
+
+// recordFileHit counts a request for p, a path within deploymentID
+func recordFileHit(deploymentID, p string) {
+	if cfg.PrewarmFiles <= 0 {
+		return
+	}
+	fileHits.Lock()
+	defer fileHits.Unlock()
+	counts := fileHits.counts[deploymentID]
+	if counts == nil {
+		counts = map[string]int64{}
+		fileHits.counts[deploymentID] = counts
+	}
+	if _, ok := counts[p]; ok || len(counts) < maxCountedFiles {
+		counts[p]++
+	}
+}
+
+// mostRequestedFiles returns up to n paths within deploymentID, most
+// requested first
+func mostRequestedFiles(deploymentID string, n int) []string {
+	fileHits.Lock()
+	counts := fileHits.counts[deploymentID]
+	paths := make([]string, 0, len(counts))
+	for p := range counts {
+		paths = append(paths, p)
+	}
+	slices.SortFunc(paths, func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), strings.Compare(a, b))
+	})
+	fileHits.Unlock()
+	return paths[:min(n, len(paths))]
+}
+
+// prewarmedFile returns the contents held for key, if any
+func prewarmedFile(key string) ([]byte, bool) {
+	prewarmed.Lock()
+	defer prewarmed.Unlock()
+	data, ok := prewarmed.files[key]
+	return data, ok
+}
+
+// storePrewarmed holds data under key, dropping the oldest files held to
+// make room. Files larger than the whole budget aren't held.
+func storePrewarmed(key string, data []byte) {
+	size := int64(len(data))
+	prewarmed.Lock()
+	defer prewarmed.Unlock()
+	if size > cfg.PrewarmMaxBytes {
+		return
+	}
+	if _, ok := prewarmed.files[key]; ok {
+		return
+	}
+	for prewarmed.size+size > cfg.PrewarmMaxBytes && len(prewarmed.order) > 0 {
+		oldest := prewarmed.order[0]
+		prewarmed.order = prewarmed.order[1:]
+		prewarmed.size -= int64(len(prewarmed.files[oldest]))
+		delete(prewarmed.files, oldest)
+	}
+	prewarmed.files[key] = data
+	prewarmed.order = append(prewarmed.order, key)
+	prewarmed.size += size
+}
+
+// prewarmPromotion reads the files most requested from previousID into
+// memory from liveID, the deployment that replaced it, in the background.
+// Its counts are dropped: the live deployment gathers its own.
+func prewarmPromotion(db *sql.DB, previousID, liveID string) {
+	if cfg.PrewarmFiles <= 0 || previousID == "" {
+		return
+	}
+	paths := mostRequestedFiles(previousID, cfg.PrewarmFiles)
+	fileHits.Lock()
+	delete(fileHits.counts, previousID)
+	fileHits.Unlock()
+	if len(paths) == 0 {
+		return
+	}
+	root, _, ok := deploymentRoot(db, liveID)
+	if !ok {
+		return
+	}
+	go func() {
+		if n := prewarmFiles(root, paths); n > 0 {
+			log.Printf("Prewarmed %d files of deployment %s", n, liveID)
+		}
+	}()
+}
+
+// prewarmFiles reads the files at paths within root into memory, skipping
+// those root doesn't have, and returns how many it read
+func prewarmFiles(root string, paths []string) int {
+	n := 0
+	for _, p := range paths {
+		fullPath := filepath.Join(root, filepath.FromSlash(p))
+		info, err := os.Stat(fullPath)
+		if err != nil || info.IsDir() || info.Size() > cfg.PrewarmMaxBytes {
+			continue
+		}
+		data, err := atrest.ReadFile(root, fullPath)
+		if err != nil {
+			log.Printf("Warning: Failed to prewarm %s: %v", fullPath, err)
+			continue
+		}
+		storePrewarmed(staticFileKey(fullPath, info), data)
+		n++
+	}
+	return n
+}
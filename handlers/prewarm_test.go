@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"static-site-hosting/models"
+)
+
+func TestPrewarmMostRequestedFiles(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	cfg.PrewarmFiles = 2
+	defer func() { cfg.PrewarmFiles = 0 }()
+
+	for _, id := range []string{"warm-1", "warm-2"} {
+		dir := filepath.Join("deployments", id)
+		os.MkdirAll(filepath.Join(dir, "css"), 0755)
+		os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>"+id+"</h1>"), 0644)
+		os.WriteFile(filepath.Join(dir, "css", "app.css"), []byte("body{}"), 0644)
+		os.WriteFile(filepath.Join(dir, "about.html"), []byte("about"), 0644)
+		db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES (?, 'warm.zip', ?, ?, 'warm')", id, time.Now(), dir)
+	}
+
+	handler := StaticFileHandler(db)
+	for _, p := range []string{"index.html", "index.html", "index.html", "css/app.css", "css/app.css", "about.html"} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/warm-1/"+p, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", p, rr.Code)
+		}
+	}
+
+	top := mostRequestedFiles("warm-1", cfg.PrewarmFiles)
+	if len(top) != 2 || top[0] != "index.html" || top[1] != "css/app.css" {
+		t.Fatalf("expected index.html and css/app.css most requested, got %v", top)
+	}
+
+	root := filepath.Join("deployments", "warm-2")
+	if n := prewarmFiles(root, append(top, "missing.html")); n != 2 {
+		t.Errorf("expected 2 files prewarmed, got %d", n)
+	}
+	for p, prewarm := range map[string]bool{"index.html": true, "css/app.css": true, "about.html": false} {
+		fullPath := filepath.Join(root, p)
+		info, _ := os.Stat(fullPath)
+		if _, ok := prewarmedFile(staticFileKey(fullPath, info)); ok != prewarm {
+			t.Errorf("%s: expected prewarmed %v, got %v", p, prewarm, ok)
+		}
+	}
+}
+
+func TestPrewarmedFilesStayWithinBudget(t *testing.T) {
+	cfg.PrewarmMaxBytes = 10
+	defer func() { cfg.PrewarmMaxBytes = 64 << 20 }()
+
+	storePrewarmed("budget-a", []byte("aaaa"))
+	storePrewarmed("budget-b", []byte("bbbb"))
+	storePrewarmed("budget-c", []byte("cccc"))
+	storePrewarmed("budget-big", []byte("too large to hold"))
+
+	for key, held := range map[string]bool{"budget-a": false, "budget-b": true, "budget-c": true, "budget-big": false} {
+		if _, ok := prewarmedFile(key); ok != held {
+			t.Errorf("%s: expected held %v, got %v", key, held, ok)
+		}
+	}
+}
+
+func TestQueryStringsShareCachedFiles(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	cfg.PrewarmFiles = 1
+	defer func() { cfg.PrewarmFiles = 0 }()
+
+	dir := filepath.Join("deployments", "query-1")
+	os.MkdirAll(dir, 0755)
+	fullPath := filepath.Join(dir, "index.html")
+	os.WriteFile(fullPath, []byte("on disk"), 0644)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES ('query-1', 'query.zip', ?, ?, 'query')", time.Now(), dir)
+	info, _ := os.Stat(fullPath)
+	storePrewarmed(staticFileKey(fullPath, info), []byte("in cache"))
+
+	// Tracking parameters neither bypass the cached file nor split its count
+	handler := StaticFileHandler(db)
+	for _, query := range []string{"", "?utm_source=newsletter", "?utm_source=ads&utm_medium=cpc"} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/query-1/index.html"+query, nil))
+		if rr.Body.String() != "in cache" {
+			t.Errorf("%q: expected the cached file, got %q", query, rr.Body.String())
+		}
+	}
+	fileHits.Lock()
+	counts := fileHits.counts["query-1"]
+	fileHits.Unlock()
+	if len(counts) != 1 || counts["index.html"] != 3 {
+		t.Errorf("expected 3 requests counted for index.html, got %v", counts)
+	}
+}
+
+func TestCacheKeyQuery(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	dir := filepath.Join("deployments", "query-2")
+	os.MkdirAll(dir, 0755)
+	fullPath := filepath.Join(dir, "app.js")
+	os.WriteFile(fullPath, []byte("on disk"), 0644)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES ('query-2', 'query.zip', ?, ?, 'query')", time.Now(), dir)
+	saveSiteSettings(db, "query-2", models.SiteSettings{CacheKeyQuery: []string{"v", "lang"}})
+	info, _ := os.Stat(fullPath)
+	storePrewarmed(staticFileKey(fullPath, info), []byte("in cache"))
+
+	// Only the named parameters key the cache, in a fixed order
+	handler := StaticFileHandler(db)
+	for query, want := range map[string]string{
+		"":                         "in cache",
+		"?utm_source=newsletter":   "in cache",
+		"?v=2":                     "on disk",
+		"?utm_source=ads&v=2":      "on disk",
+		"?lang=de&utm_medium=cpc":  "on disk",
+		"?unrelated=1&other=2&x=y": "in cache",
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/query-2/app.js"+query, nil))
+		if rr.Body.String() != want {
+			t.Errorf("%q: expected %q, got %q", query, want, rr.Body.String())
+		}
+	}
+
+	settings := models.SiteSettings{CacheKeyQuery: []string{"v", "lang"}}
+	a := cacheKeyQuery(settings, url.Values{"lang": {"de"}, "v": {"2"}, "utm_source": {"x"}})
+	b := cacheKeyQuery(settings, url.Values{"v": {"2"}, "lang": {"de"}})
+	if a != b || a != "lang=de&v=2" {
+		t.Errorf("expected the same key regardless of order and tracking parameters, got %q and %q", a, b)
+	}
+	if key := cacheKeyQuery(models.SiteSettings{}, url.Values{"v": {"2"}}); key != "" {
+		t.Errorf("expected query strings to be ignored by default, got %q", key)
+	}
+}
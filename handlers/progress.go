@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"static-site-hosting/models"
+
+	"github.com/google/uuid"
+)
+
+// Upload states reported by /uploads/{id}/progress
+const (
+	UploadReceiving  = "receiving"
+	UploadExtracting = "extracting"
+	UploadComplete   = "complete"
+	UploadFailed     = "failed"
+)
+
+// finished uploads stay queryable for this long
+const uploadProgressTTL = 10 * time.Minute
+
+// UploadProgress is a snapshot of an in-flight or recently finished upload
+type UploadProgress struct {
+	UploadID       string    `json:"upload_id"`
+	State          string    `json:"state"`
+	BytesReceived  int64     `json:"bytes_received"`
+	BytesTotal     int64     `json:"bytes_total"` // -1 when the client sent no Content-Length
+	FilesExtracted int       `json:"files_extracted"`
+	FilesTotal     int       `json:"files_total"`
+	DeploymentID   string    `json:"deployment_id,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type uploadTracker struct {
+	mu      sync.Mutex
+	id      string
+	state   string
+	total   int64
+	files   int
+	done    int
+	depID   string
+	errText string
+	updated time.Time
+
+	// Archive entries left out, the first maxSkippedReported kept
+	skipped      []models.SkippedEntry
+	skippedCount int
+
+	received atomic.Int64 // updated on every read, so kept outside mu
+}
+
+var uploads = struct {
+	sync.Mutex
+	byID map[string]*uploadTracker
+}{byID: map[string]*uploadTracker{}}
+
+// uploadID returns the client-chosen upload ID (X-Upload-Id header or
+// upload_id query parameter) so it can poll progress while the request is
+// still in flight, or a fresh one
+func uploadID(r *http.Request) string {
+	id := r.Header.Get("X-Upload-Id")
+	if id == "" {
+		id = r.URL.Query().Get("upload_id")
+	}
+	if !validUploadID(id) {
+		return uuid.New().String()
+	}
+	return id
+}
+
+func validUploadID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func startUpload(id string, total int64) *uploadTracker {
+	t := &uploadTracker{id: id, state: UploadReceiving, total: total, updated: time.Now().UTC()}
+
+	uploads.Lock()
+	defer uploads.Unlock()
+	for key, old := range uploads.byID {
+		if old.finishedBefore(time.Now().Add(-uploadProgressTTL)) {
+			delete(uploads.byID, key)
+		}
+	}
+	uploads.byID[id] = t
+	return t
+}
+
+func lookupUpload(id string) *uploadTracker {
+	uploads.Lock()
+	defer uploads.Unlock()
+	return uploads.byID[id]
+}
+
+// body wraps r so every byte read from the request counts as received
+func (t *uploadTracker) body(r io.ReadCloser) io.ReadCloser {
+	return &countingBody{ReadCloser: r, t: t}
+}
+
+func (t *uploadTracker) extracting(files int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state = UploadExtracting
+	t.files = files
+	t.updated = time.Now().UTC()
+}
+
+func (t *uploadTracker) extracted(done int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done = done
+	t.updated = time.Now().UTC()
+}
+
+// maxSkippedReported keeps upload reports readable for hostile archives
+const maxSkippedReported = 100
+
+// skip records an archive entry that was not extracted
+func (t *uploadTracker) skip(name, reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.skippedCount++
+	if len(t.skipped) < maxSkippedReported {
+		t.skipped = append(t.skipped, models.SkippedEntry{Path: name, Reason: reason})
+	}
+}
+
+// skippedEntries returns the skipped entries kept and how many there were
+func (t *uploadTracker) skippedEntries() ([]models.SkippedEntry, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]models.SkippedEntry(nil), t.skipped...), t.skippedCount
+}
+
+func (t *uploadTracker) complete(deploymentID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state = UploadComplete
+	t.depID = deploymentID
+	t.updated = time.Now().UTC()
+}
+
+// fail marks the upload failed. Requests that aren't uploads, such as
+// revisions, have no tracker.
+func (t *uploadTracker) fail(msg string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state = UploadFailed
+	t.errText = msg
+	t.updated = time.Now().UTC()
+}
+
+func (t *uploadTracker) finishedBefore(cutoff time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return (t.state == UploadComplete || t.state == UploadFailed) && t.updated.Before(cutoff)
+}
+
+func (t *uploadTracker) snapshot() UploadProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return UploadProgress{
+		UploadID:       t.id,
+		State:          t.state,
+		BytesReceived:  t.received.Load(),
+		BytesTotal:     t.total,
+		FilesExtracted: t.done,
+		FilesTotal:     t.files,
+		DeploymentID:   t.depID,
+		Error:          t.errText,
+		UpdatedAt:      t.updated,
+	}
+}
+
+type countingBody struct {
+	io.ReadCloser
+	t *uploadTracker
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.t.received.Add(int64(n))
+	return n, err
+}
+
+// UploadProgressHandler reports progress of an upload: GET /uploads/{id}/progress
+func UploadProgressHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/uploads/")
+	id, action, _ := strings.Cut(path, "/")
+	if id == "" || action != "progress" {
+		http.NotFound(w, r)
+		return
+	}
+
+	t := lookupUpload(id)
+	if t == nil {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(t.snapshot())
+}
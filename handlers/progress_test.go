@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func getProgress(t *testing.T, id string) (int, UploadProgress) {
+	req := httptest.NewRequest(http.MethodGet, "/uploads/"+id+"/progress", nil)
+	rr := httptest.NewRecorder()
+	UploadProgressHandler(rr, req)
+
+	var p UploadProgress
+	if rr.Code == http.StatusOK {
+		json.NewDecoder(rr.Body).Decode(&p)
+	}
+	return rr.Code, p
+}
+
+func TestUploadProgressCompleted(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	zipBuffer, err := createTestZip()
+	if err != nil {
+		t.Fatalf("failed to create test zip: %v", err)
+	}
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "test-site.zip")
+	io.Copy(part, zipBuffer)
+	writer.Close()
+	size := int64(body.Len())
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-Upload-Id", "cli-upload-1")
+	rr := httptest.NewRecorder()
+	UploadHandler(rr, req, db)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("X-Upload-Id"); got != "cli-upload-1" {
+		t.Errorf("expected X-Upload-Id to echo the client ID, got %q", got)
+	}
+
+	code, p := getProgress(t, "cli-upload-1")
+	if code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if p.State != UploadComplete || p.DeploymentID == "" {
+		t.Errorf("expected completed upload with deployment ID, got %+v", p)
+	}
+	if p.BytesReceived != size || p.BytesTotal != size {
+		t.Errorf("expected %d bytes received of %d, got %d of %d", size, size, p.BytesReceived, p.BytesTotal)
+	}
+	if p.FilesTotal == 0 || p.FilesExtracted != p.FilesTotal {
+		t.Errorf("expected all files extracted, got %d of %d", p.FilesExtracted, p.FilesTotal)
+	}
+}
+
+func TestUploadProgressFailed(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload?upload_id=cli-upload-2", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	UploadHandler(rr, req, db)
+
+	_, p := getProgress(t, "cli-upload-2")
+	if p.State != UploadFailed || p.Error != "Invalid file" {
+		t.Errorf("expected failed upload with error, got %+v", p)
+	}
+}
+
+func TestUploadProgressUnknownID(t *testing.T) {
+	if code, _ := getProgress(t, "does-not-exist"); code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", code)
+	}
+}
+
+func TestUploadIDRejectsUnsafeValues(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+	req.Header.Set("X-Upload-Id", "../../etc")
+	if id := uploadID(req); id == "../../etc" {
+		t.Error("expected unsafe upload ID to be replaced")
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"database/sql"
+
+	"static-site-hosting/events"
+	"static-site-hosting/webhooks"
+)
+
+// liveDeploymentID returns the ID of the deployment site serves, or "" when
+// it serves none
+func liveDeploymentID(db *sql.DB, site string) string {
+	if site == "" {
+		return ""
+	}
+	d, err := latestSiteDeployment(db, site)
+	if err != nil {
+		return ""
+	}
+	return d.ID
+}
+
+// notifyPromotion sends deployment.promoted when site no longer serves
+// previousID, as read with liveDeploymentID before the change, and
+// prewarms the new live deployment. Deployments without a site are only
+// reachable by ID and are never promoted.
+func notifyPromotion(db *sql.DB, site, previousID string) {
+	if site == "" {
+		return
+	}
+	live, err := latestSiteDeployment(db, site)
+	if err != nil || live.ID == previousID {
+		return
+	}
+	var previous any
+	if previousID != "" {
+		previous = previousID
+	}
+	data := map[string]any{
+		"site":                   site,
+		"previous_deployment_id": previous,
+		"deployment_id":          live.ID,
+		"deployment":             live,
+	}
+	webhooks.Notify(db, webhooks.EventDeploymentPromoted, data, site)
+	events.Record(db, events.DeploymentPromoted, live.ID, site, data)
+	prewarmPromotion(db, previousID, live.ID)
+}
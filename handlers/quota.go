@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+
+	"static-site-hosting/quota"
+	"static-site-hosting/routecache"
+	"static-site-hosting/usage"
+)
+
+// quotaWatcher supplies the quota warnings of API responses; nil disables
+// them
+var quotaWatcher *quota.Watcher
+
+// SetQuotaWatcher sets the watcher whose warnings API responses carry
+func SetQuotaWatcher(w *quota.Watcher) {
+	quotaWatcher = w
+}
+
+// QuotaWarningHandler adds an X-Quota-Warning header to API responses for
+// each quota the caller's tenant has used most of, such as
+//
+//	X-Quota-Warning: storage; percent=85; used=912680550; limit=1073741824
+//
+// Sites are served without them.
+func QuotaWarningHandler(classify func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if quotaWatcher.Enabled() && routecache.Available() && classify(r) == "api" {
+			warnings := quotaWatcher.Warnings(requestTenant(r, usage.DefaultTenant))
+			for _, value := range quota.Headers(warnings) {
+				w.Header().Add("X-Quota-Warning", value)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/quota"
+)
+
+func TestQuotaWarningHeaders(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	SetQuotaWatcher(quota.NewWatcher(db, quota.Limits{StorageBytes: 1000}, []int{80, 90}, time.Minute, nil, nil))
+	defer SetQuotaWatcher(nil)
+	db.Exec("INSERT INTO deployment_usage (deployment_id, tenant_id, bytes, created_at) VALUES ('a', 'acme', 950, ?)", time.Now().UTC())
+
+	class := "api"
+	handler := QuotaWarningHandler(func(*http.Request) string { return class }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	get := func(tenant string) http.Header {
+		req := httptest.NewRequest(http.MethodGet, "/deployments", nil)
+		req = req.WithContext(auth.WithClaims(req.Context(), &auth.Claims{Subject: "user", Tenant: tenant}))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Header()
+	}
+
+	if got := get("acme").Values("X-Quota-Warning"); len(got) != 1 || got[0] != "storage; percent=95; used=950; limit=1000" {
+		t.Errorf("expected a storage warning for acme, got %q", got)
+	}
+	if got := get("globex").Values("X-Quota-Warning"); len(got) != 0 {
+		t.Errorf("expected no warning for a tenant within its quota, got %q", got)
+	}
+	class = "static"
+	if got := get("acme").Values("X-Quota-Warning"); len(got) != 0 {
+		t.Errorf("expected sites to be served without warnings, got %q", got)
+	}
+}
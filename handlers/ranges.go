@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// rangeStats counts static requests for byte ranges, such as video players
+// seeking or downloads resuming, for /metrics
+var rangeStats struct {
+	requests      atomic.Int64 // requests with a Range header
+	partial       atomic.Int64 // answered 206 Partial Content
+	partialBytes  atomic.Int64 // body bytes of those answers
+	unsatisfiable atomic.Int64 // answered 416 Range Not Satisfiable
+	ignored       atomic.Int64 // HTML served whole, RANGE_REQUESTS_HTML being off
+}
+
+// rangesDisabled reports whether Range headers are ignored for the file at
+// name: HTML, when RANGE_REQUESTS_HTML is off, as pages are small and a
+// partial page is never what a browser wants, while media keeps them
+func rangesDisabled(name string) bool {
+	return !cfg.RangeRequestsHTML && isHTMLFile(name)
+}
+
+// withoutRanges returns a copy of r asking for the whole file
+func withoutRanges(r *http.Request) *http.Request {
+	r = r.Clone(r.Context())
+	r.Header.Del("Range")
+	r.Header.Del("If-Range")
+	return r
+}
+
+// observeRange counts a range request answered with status and n bytes
+func observeRange(status int, n int64) {
+	rangeStats.requests.Add(1)
+	switch status {
+	case http.StatusPartialContent:
+		rangeStats.partial.Add(1)
+		rangeStats.partialBytes.Add(n)
+	case http.StatusRequestedRangeNotSatisfiable:
+		rangeStats.unsatisfiable.Add(1)
+	}
+}
+
+// rangeWriter records the status of a static response and, for files
+// served without ranges, advertises so in place of ServeContent's
+// Accept-Ranges: bytes
+type rangeWriter struct {
+	http.ResponseWriter
+	status   int
+	noRanges bool
+}
+
+func (rw *rangeWriter) WriteHeader(code int) {
+	if rw.status == 0 && code >= 200 {
+		rw.status = code
+		if rw.noRanges {
+			rw.Header().Set("Accept-Ranges", "none")
+		}
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *rangeWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *rangeWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"static-site-hosting/models"
+)
+
+// matchRedirect returns the first of rules applying to p, a path within the
+// site, and its expanded target. Rules that aren't forced only apply when
+// the path has no file of its own.
+func matchRedirect(rules []models.RedirectRule, p string, exists bool) (models.RedirectRule, string, bool) {
+	for _, rule := range rules {
+		if exists && !rule.Force {
+			continue
+		}
+		re := accessPattern(rule.From)
+		if re == nil {
+			continue
+		}
+		match := re.FindStringSubmatchIndex(p)
+		if match == nil {
+			continue
+		}
+		if rule.Status == 0 {
+			rule.Status = http.StatusMovedPermanently
+		}
+		return rule, string(re.ExpandString(nil, rule.To, p, match)), true
+	}
+	return models.RedirectRule{}, "", false
+}
+
+// redirectTarget makes target, as given by a redirect rule, into a URL for
+// r: paths within the site are placed under the prefix the site is being
+// served at, and the query is kept unless target has its own
+func redirectTarget(r *http.Request, siteID, target string) string {
+	if strings.HasPrefix(target, "/") {
+		target = sitePrefix(r, siteID) + target
+	}
+	if r.URL.RawQuery != "" && !strings.Contains(target, "?") {
+		target += "?" + r.URL.RawQuery
+	}
+	return target
+}
+
+// sitePrefix returns the URL path siteID is served under for r: nothing on
+// a site's own host name, or the deployment ID, below /s/ if requested there
+func sitePrefix(r *http.Request, siteID string) string {
+	if IsSiteHost(r) {
+		return ""
+	}
+	if strings.HasPrefix(r.RequestURI, "/s/"+siteID+"/") {
+		return "/s/" + siteID
+	}
+	return "/" + siteID
+}
+
+// rewritePath cleans the target of a rewrite into a path within the site
+func rewritePath(target string) string {
+	target, _, _ = strings.Cut(target, "?")
+	return strings.TrimPrefix(path.Clean("/"+target), "/")
+}
+
+// applyHeaderRules sets the headers of every rule matching p, a path within
+// the site; later rules override earlier ones
+func applyHeaderRules(w http.ResponseWriter, rules []models.HeaderRule, p string) {
+	for _, rule := range rules {
+		if re := accessPattern(rule.Path); re != nil && re.MatchString(p) {
+			for name, value := range rule.Set {
+				w.Header().Set(name, value)
+			}
+		}
+	}
+}
+
+// applyContentTypeRules sets the content type and disposition the first of
+// a site's content type rules matching p, a path within the site, asks for,
+// and labels text with the site's charset. Attachments are saved as name,
+// the file served from content.
+func applyContentTypeRules(w http.ResponseWriter, settings models.SiteSettings, p, name string, content io.ReadSeeker) {
+	var rule models.ContentTypeRule
+	for _, r := range settings.ContentTypes {
+		if re := accessPattern(r.Path); re != nil && re.MatchString(p) {
+			rule = r
+			break
+		}
+	}
+	if rule.Attachment {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	}
+	if settings.Charset == "" && len(rule.Params) == 0 {
+		if rule.ContentType != "" {
+			w.Header().Set("Content-Type", rule.ContentType)
+		}
+		return
+	}
+
+	ctype := rule.ContentType
+	if ctype == "" {
+		ctype = guessContentType(name, content)
+	}
+	mediaType, params, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return
+	}
+	// A guessed charset is only ever a guess; a rule's is deliberate
+	if settings.Charset != "" && isTextType(mediaType) && (rule.ContentType == "" || params["charset"] == "") {
+		params["charset"] = settings.Charset
+	}
+	maps.Copy(params, rule.Params)
+	w.Header().Set("Content-Type", mime.FormatMediaType(mediaType, params))
+}
+
+// guessContentType works out the type of the file served as name from
+// content the way ServeContent does: by extension, else by sniffing
+func guessContentType(name string, content io.ReadSeeker) string {
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		return ctype
+	}
+	var head [512]byte
+	n, _ := io.ReadFull(content, head[:])
+	content.Seek(0, io.SeekStart)
+	return http.DetectContentType(head[:n])
+}
+
+// isTextType reports whether files of mediaType are text a charset applies to
+func isTextType(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+xml"), strings.HasSuffix(mediaType, "+json"):
+		return true
+	}
+	switch mediaType {
+	case "application/javascript", "application/json", "application/xml":
+		return true
+	}
+	return false
+}
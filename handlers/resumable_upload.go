@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"static-site-hosting/locks"
+	"static-site-hosting/models"
+
+	"github.com/google/uuid"
+)
+
+// CreateUploadHandler starts a resumable upload, in the style of tus: the
+// archive is then sent with PATCH /uploads/{id} from the Upload-Offset
+// HEAD /uploads/{id} reports, as many times as the connection breaks, and
+// deployed with POST /uploads/{id}/complete. An Upload-Length header
+// declares the archive's size, so an upload cut short isn't deployed.
+// Expected: POST /uploads
+func CreateUploadHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	length := int64(-1)
+	if v := r.Header.Get("Upload-Length"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			writeFieldErrors(w, http.StatusBadRequest, models.FieldError{
+				Field:   "Upload-Length",
+				Code:    models.CodeInvalid,
+				Message: "Upload-Length must be a number of bytes",
+			})
+			return
+		}
+		length = n
+	}
+	if limit := uploadPolicy(r, db).MaxBytes; limit > 0 && length > limit {
+		writeUploadTooLarge(w, limit, nil)
+		return
+	}
+
+	pruneChunkUploads()
+	pruneUploadSessions(db)
+	id := uuid.New().String()
+	dir, err := filepath.Abs(chunkDir(id))
+	if err == nil {
+		err = createUploadSession(db, id, dir, requestOwner(r), length)
+	}
+	if err != nil {
+		log.Printf("Failed to create upload: %v", err)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/uploads/"+id)
+	w.Header().Set("Upload-Offset", "0")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(UploadSession{UploadID: id, Length: length, Chunks: []UploadChunk{}, Missing: []int{}})
+}
+
+// UploadPatchHandler appends the request body to a resumable upload. The
+// Upload-Offset header must match the bytes the server holds, or the
+// request is refused with 409 and the offset to resume from. Each PATCH is
+// stored as the upload's next chunk; when the connection breaks, what
+// arrived is kept, so the client resumes from there.
+// Expected: PATCH /uploads/{id}
+func UploadPatchHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "PATCH required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/uploads/"), "/")
+	var errs models.ValidationErrors
+	errs.Include(uploadIDError(id))
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		errs.Add("Upload-Offset", models.CodeInvalid, "Upload-Offset must be a number of bytes")
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, http.StatusBadRequest, errs...)
+		return
+	}
+
+	unlock, ok := lockMutation(w, "upload", locks.Upload(id))
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	dir, ownerID, err := uploadSession(db, id)
+	if err == sql.ErrNoRows || err == nil && !ownsDeployment(r, ownerID) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch upload", http.StatusInternalServerError)
+		return
+	}
+	chunks, err := recordedChunks(db, id)
+	if err != nil {
+		http.Error(w, "Failed to fetch upload", http.StatusInternalServerError)
+		return
+	}
+	held, n := uploadOffset(chunks)
+	if n > maxUploadChunks {
+		http.Error(w, "Upload has too many parts", http.StatusBadRequest)
+		return
+	}
+	// Chunks sent out of order leave gaps an offset can't describe
+	if offset != held || len(chunks) != n-1 {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(held, 10))
+		http.Error(w, "Upload-Offset does not match the bytes received", http.StatusConflict)
+		return
+	}
+
+	// Nothing past the declared length, or the size limit, is accepted
+	limit := uploadPolicy(r, db).MaxBytes
+	if length := uploadLength(db, id); length >= 0 && (limit <= 0 || length < limit) {
+		limit = length
+	}
+	if limit > 0 {
+		if r.ContentLength > limit-held {
+			writeUploadTooLarge(w, limit, nil)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit-held)
+	}
+	if _, problem := verifyUploadSignature(r); problem != "" {
+		http.Error(w, problem, http.StatusUnauthorized)
+		return
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		http.Error(w, "Could not create temp file", http.StatusInternalServerError)
+		return
+	}
+
+	progress := restoreUpload(db, id)
+	w.Header().Set("X-Upload-Id", progress.id)
+	// What arrived of a signed request before it broke can't be checked
+	// against its signature, so none of it is kept
+	size, err := saveChunk(dir, n, progress.body(signedBody(r)), cfg.UploadSigningSecret == "")
+	if tooLarge(err) {
+		writeUploadTooLarge(w, limit, progress)
+		return
+	}
+	if errors.Is(err, errSignatureMismatch) {
+		http.Error(w, errUploadSignature, http.StatusUnauthorized)
+		return
+	}
+	if size > 0 {
+		if err := recordChunk(db, id, n, size, dir, ownerID); err != nil {
+			log.Printf("Failed to record chunk %d of upload %s: %v", n, id, err)
+			http.Error(w, "Failed to save uploaded file", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(held+size, 10))
+	if err != nil {
+		http.Error(w, "Failed to save uploaded file", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"static-site-hosting/models"
+)
+
+// brokenBody delivers data and then fails, as a dropped connection does
+type brokenBody struct {
+	io.Reader
+}
+
+func (b *brokenBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func patchUpload(db *sql.DB, location string, offset int, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, location, body)
+	req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+	rr := httptest.NewRecorder()
+	UploadPatchHandler(rr, req, db)
+	return rr
+}
+
+func TestResumableUpload(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	archive := createTestTarGz(t)
+	req := httptest.NewRequest(http.MethodPost, "/uploads", nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(len(archive)))
+	rr := httptest.NewRecorder()
+	CreateUploadHandler(rr, req, db)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	location := rr.Header().Get("Location")
+	id := strings.TrimPrefix(location, "/uploads/")
+	if uploadIDError(id) != nil {
+		t.Fatalf("expected the upload's location, got %q", location)
+	}
+
+	// The connection drops after 100 bytes; they are kept
+	rr = patchUpload(db, location, 0, &brokenBody{bytes.NewReader(archive[:100])})
+	if got := rr.Header().Get("Upload-Offset"); got != "100" {
+		t.Errorf("expected the broken PATCH to keep 100 bytes, got offset %q", got)
+	}
+
+	rr = httptest.NewRecorder()
+	UploadSessionHandler(rr, httptest.NewRequest(http.MethodHead, location, nil), db)
+	if got := rr.Header().Get("Upload-Offset"); rr.Code != http.StatusOK || got != "100" || rr.Body.Len() != 0 {
+		t.Errorf("expected HEAD to report offset 100, got %d %q", rr.Code, got)
+	}
+	if got := rr.Header().Get("Upload-Length"); got != strconv.Itoa(len(archive)) {
+		t.Errorf("expected the declared length, got %q", got)
+	}
+
+	// Resending from the start conflicts with what the server holds
+	rr = patchUpload(db, location, 0, bytes.NewReader(archive))
+	if rr.Code != http.StatusConflict || rr.Header().Get("Upload-Offset") != "100" {
+		t.Errorf("expected 409 with offset 100, got %d %q", rr.Code, rr.Header().Get("Upload-Offset"))
+	}
+	if rr := completeUpload(db, id, "?site=docs"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an incomplete upload to be refused, got %d", rr.Code)
+	}
+
+	// Nothing past the declared length is accepted
+	rr = patchUpload(db, location, 100, bytes.NewReader(append(archive[100:], "extra"...)))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 past the declared length, got %d", rr.Code)
+	}
+
+	rr = patchUpload(db, location, 100, bytes.NewReader(archive[100:]))
+	if rr.Code != http.StatusNoContent || rr.Header().Get("Upload-Offset") != strconv.Itoa(len(archive)) {
+		t.Fatalf("expected 204 at the full length, got %d %q: %s", rr.Code, rr.Header().Get("Upload-Offset"), rr.Body.String())
+	}
+
+	rr = completeUpload(db, id, "?site=docs")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var d models.Deployment
+	json.NewDecoder(rr.Body).Decode(&d)
+	if d.Site != "docs" {
+		t.Errorf("expected a deployment of docs, got %+v", d)
+	}
+	if rr := patchUpload(db, location, 0, bytes.NewReader(archive)); rr.Code != http.StatusNotFound {
+		t.Errorf("expected a completed upload to be gone, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"static-site-hosting/retention"
+)
+
+func retentionPolicy() retention.Policy {
+	return retention.Policy{MaxAge: cfg.RetentionMaxAge, Warning: cfg.RetentionWarning}
+}
+
+// ExpiringDeploymentsHandler lists deployments the retention policy will
+// delete within the next N days: GET /deployments/expiring?days=N
+func ExpiringDeploymentsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	policy := retentionPolicy()
+	within := policy.Warning
+	if v := r.URL.Query().Get("days"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			http.Error(w, "days must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		within = time.Duration(days) * 24 * time.Hour
+	}
+
+	expiring, err := retention.Expiring(db, policy, time.Now(), within)
+	if err != nil {
+		http.Error(w, "Failed to fetch deployments", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"retention_enabled": policy.Active(),
+		"within_days":       int(within.Hours() / 24),
+		"deployments":       expiring,
+	})
+}
+
+// PinDeploymentHandler exempts a deployment from retention (POST) or
+// removes the exemption (DELETE): /deployments/{id}/pin
+func PinDeploymentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	deploymentID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/deployments/"), "/pin")
+	if deploymentID == "" {
+		http.Error(w, "Deployment ID required", http.StatusBadRequest)
+		return
+	}
+
+	var ownerID string
+	err := db.QueryRow("SELECT owner_id FROM deployments WHERE id = ?", deploymentID).Scan(&ownerID)
+	if err == sql.ErrNoRows || err == nil && !ownsDeployment(r, ownerID) {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch deployment", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		_, err = db.Exec("INSERT OR IGNORE INTO deployment_pins (deployment_id, pinned_at) VALUES (?, ?)", deploymentID, time.Now().UTC())
+	case http.MethodDelete:
+		_, err = db.Exec("DELETE FROM deployment_pins WHERE deployment_id = ?", deploymentID)
+		// Warn again if the deployment is now close to expiry
+		db.Exec("DELETE FROM expiry_notices WHERE deployment_id = ?", deploymentID)
+	default:
+		http.Error(w, "POST or DELETE required", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update pin", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deployment_id": deploymentID,
+		"pinned":        r.Method == http.MethodPost,
+	})
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExpiringDeploymentsHandler(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	cfg.RetentionMaxAge = 30 * 24 * time.Hour
+	defer func() { cfg.RetentionMaxAge = 0 }()
+
+	now := time.Now()
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, ?, ?, ?)", "old", "old.zip", now.Add(-27*24*time.Hour), "x")
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, ?, ?, ?)", "new", "new.zip", now, "x")
+
+	req := httptest.NewRequest(http.MethodGet, "/deployments/expiring?days=5", nil)
+	rr := httptest.NewRecorder()
+	ExpiringDeploymentsHandler(rr, req, db)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		RetentionEnabled bool `json:"retention_enabled"`
+		Deployments      []struct {
+			ID string `json:"id"`
+		} `json:"deployments"`
+	}
+	json.NewDecoder(rr.Body).Decode(&body)
+	if !body.RetentionEnabled || len(body.Deployments) != 1 || body.Deployments[0].ID != "old" {
+		t.Fatalf("unexpected response: %+v", body)
+	}
+
+	// Pinning removes it from the list
+	req = httptest.NewRequest(http.MethodPost, "/deployments/old/pin", nil)
+	rr = httptest.NewRecorder()
+	PinDeploymentHandler(rr, req, db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 from pin, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/deployments/expiring?days=5", nil)
+	rr = httptest.NewRecorder()
+	ExpiringDeploymentsHandler(rr, req, db)
+	json.NewDecoder(rr.Body).Decode(&body)
+	if len(body.Deployments) != 0 {
+		t.Errorf("expected pinned deployment to be excluded, got %+v", body.Deployments)
+	}
+}
+
+func TestExpiringDeploymentsHandlerInvalidDays(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/deployments/expiring?days=soon", nil)
+	rr := httptest.NewRecorder()
+	ExpiringDeploymentsHandler(rr, req, db)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rr.Code)
+	}
+}
+
+func TestPinDeploymentHandlerNotFound(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/deployments/missing/pin", nil)
+	rr := httptest.NewRecorder()
+	PinDeploymentHandler(rr, req, db)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rr.Code)
+	}
+}
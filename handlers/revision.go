@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"static-site-hosting/events"
+	"static-site-hosting/immutable"
+	"static-site-hosting/models"
+	"static-site-hosting/routecache"
+	"static-site-hosting/usage"
+	"static-site-hosting/validate"
+	"static-site-hosting/webhooks"
+
+	"github.com/google/uuid"
+)
+
+// authorizeRevision checks that the caller may publish a new revision of
+// source, which only those who own it and may deploy to its site can. It
+// answers the request and returns false when not.
+func authorizeRevision(w http.ResponseWriter, r *http.Request, db *sql.DB, source models.Deployment) bool {
+	if !ownsDeployment(r, source.OwnerID) {
+		http.NotFound(w, r)
+		return false
+	}
+	return authorizeSiteDeploy(w, r, db, source.Site, nil)
+}
+
+// createRevision copies source into a new deployment, lets apply change the
+// copy, checks it as uploads are checked, then seals and records it.
+// Deployments are never modified in place, so this is how every content
+// edit is made. apply returns the status to
+// report on success; an error it returns is reported with that status.
+// The request is checked against its upload signature once apply has read
+// it. Callers hold the mutation locks for source.
+func createRevision(r *http.Request, db *sql.DB, source models.Deployment, filename string, apply func(dir string) (int, error)) (*models.Deployment, int, error) {
+	// What a revision writes is held to the caller's upload size limit
+	if limit := uploadPolicy(r, db).MaxBytes; limit > 0 {
+		if r.ContentLength > limit {
+			return nil, http.StatusRequestEntityTooLarge, errors.New(errUploadTooLarge)
+		}
+		r.Body = http.MaxBytesReader(nil, r.Body, limit)
+	}
+	verified, problem := verifyUploadSignature(r)
+	if problem != "" {
+		return nil, http.StatusUnauthorized, errors.New(problem)
+	}
+	started := time.Now()
+	newID := uuid.New().String()
+	newPath := filepath.Join("deployments", newID)
+	if err := copyDir(source.Path, newPath); err != nil {
+		immutable.RemoveAll(newPath)
+		return nil, http.StatusInternalServerError, errors.New("Failed to create new revision")
+	}
+
+	status, err := apply(newPath)
+	if err != nil {
+		immutable.RemoveAll(newPath)
+		// A body over the limit fails apply however it reads it
+		if _, rerr := io.Copy(io.Discard, r.Body); tooLarge(rerr) {
+			return nil, http.StatusRequestEntityTooLarge, errors.New(errUploadTooLarge)
+		}
+		return nil, status, err
+	}
+	if err := verified(); err != nil {
+		immutable.RemoveAll(newPath)
+		return nil, http.StatusUnauthorized, errors.New(errUploadSignature)
+	}
+	if code, err := checkRevision(r, db, newID, newPath); err != nil {
+		immutable.RemoveAll(newPath)
+		return nil, code, err
+	}
+	if err := immutable.Seal(newPath); err != nil {
+		immutable.RemoveAll(newPath)
+		return nil, http.StatusInternalServerError, errors.New("Failed to seal new revision")
+	}
+
+	newDeployment := models.NewDeployment(newID, filename, newPath)
+	newDeployment.Site = source.Site
+	newDeployment.OwnerID = deploymentOwner(db, r, newDeployment.Site)
+	newDeployment.DeployedBy = deployedBy(r)
+	newDeployment.Visibility = deploymentVisibility(db, newDeployment.Site, "")
+	previousLive := liveDeploymentID(db, newDeployment.Site)
+	inherited, err := inheritPassword(db, newDeployment)
+	if err != nil {
+		immutable.RemoveAll(newPath)
+		return nil, http.StatusInternalServerError, errors.New("Failed to save new revision")
+	}
+	_, err = db.Exec(
+		"INSERT INTO deployments (id, filename, timestamp, path, site, owner_id, deployed_by, visibility) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		newDeployment.ID, newDeployment.Filename, newDeployment.Timestamp, newDeployment.Path, newDeployment.Site, newDeployment.OwnerID, newDeployment.DeployedBy, newDeployment.Visibility,
+	)
+	if err != nil {
+		if inherited {
+			db.Exec("DELETE FROM deployment_passwords WHERE deployment_id = ?", newDeployment.ID)
+		}
+		immutable.RemoveAll(newPath)
+		return nil, http.StatusInternalServerError, errors.New("Failed to save new revision")
+	}
+	routecache.Invalidate()
+
+	recordPreloadHints(db, newID, newPath)
+	recordFileHashes(db, newID, newPath)
+	entry := usage.RecordDeployment(db, newID, requestTenant(r, usage.TenantOf(db, source.ID)), newPath, time.Since(started))
+	webhooks.Notify(db, webhooks.EventDeploymentCreated, newDeployment, newDeployment.Site)
+	events.Record(db, events.DeploymentCreated, newDeployment.ID, newDeployment.Site, map[string]any{"deployment": newDeployment, "usage": entry})
+	notifyPromotion(db, newDeployment.Site, previousLive)
+	return newDeployment, status, nil
+}
+
+// checkRevision makes the checks publishDeployment makes of an upload's
+// files: the caller's upload policy, validation and, where configured, the
+// smoke test. A revision that fails them isn't published; the error says
+// why, with the status to report it with.
+func checkRevision(r *http.Request, db *sql.DB, id, dir string) (int, error) {
+	if code, body := uploadPolicyViolation(r, db, dir); body != nil {
+		msg := body["error"].(string)
+		if files, ok := body["files"].([]string); ok {
+			msg += ": " + strings.Join(files, ", ")
+		}
+		return code, errors.New(msg)
+	}
+	report, err := validate.Run(dir, deployValidators())
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("Failed to validate deployment")
+	}
+	if !report.Passed {
+		var issues []string
+		for _, issue := range report.Issues {
+			issues = append(issues, strings.TrimPrefix(issue.Path+": ", ": ")+issue.Message)
+		}
+		return http.StatusUnprocessableEntity, errors.New("Deployment failed validation: " + strings.Join(issues, "; "))
+	}
+	if !cfg.SmokeTest {
+		return 0, nil
+	}
+	if smoke := smokeTest(db, id, dir); !smoke.Passed {
+		var failed []string
+		for _, result := range smoke.Results {
+			if result.Status != http.StatusOK {
+				failed = append(failed, fmt.Sprintf("%s answered %d", result.Path, result.Status))
+			}
+		}
+		return http.StatusUnprocessableEntity, errors.New("Deployment failed smoke test: " + strings.Join(failed, "; "))
+	}
+	return 0, nil
+}
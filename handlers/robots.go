@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"static-site-hosting/models"
+)
+
+// noIndexTag and disallowAll keep previews out of search engines
+const (
+	noIndexTag  = "noindex, nofollow"
+	disallowAll = "User-agent: *\nDisallow: /\n"
+)
+
+type siteHostKey struct{}
+
+// withSiteHost marks a request as addressed to a site by its slug or custom
+// domain rather than to a deployment by ID
+func withSiteHost(ctx context.Context) context.Context {
+	return context.WithValue(ctx, siteHostKey{}, true)
+}
+
+// isPreview reports whether r reached a deployment by its ID, through a
+// path, /s/ or ID host name URL, rather than through its site's host name
+func isPreview(r *http.Request) bool {
+	site, _ := r.Context().Value(siteHostKey{}).(bool)
+	return !site
+}
+
+// robotsPolicy returns the X-Robots-Tag header and robots.txt to answer r
+// with, each empty to leave the deployment's own. Previews kept out of
+// search engines ignore the site's robots settings.
+func robotsPolicy(r *http.Request, settings models.SiteSettings) (tag, txt string) {
+	if isPreview(r) && previewNoIndex(settings) {
+		return noIndexTag, disallowAll
+	}
+	return settings.RobotsTag, settings.RobotsTxt
+}
+
+// previewNoIndex reports whether previews of a deployment are kept out of
+// search engines. Without site host names every URL addresses deployments
+// by ID, so there is no production URL to protect unless the deployment's
+// settings ask for it.
+func previewNoIndex(settings models.SiteSettings) bool {
+	if settings.PreviewNoIndex != nil {
+		return *settings.PreviewNoIndex
+	}
+	return cfg.PreviewNoIndex && (cfg.SiteDomain != "" || len(cfg.CustomDomains) > 0)
+}
+
+// serveRobotsTxt answers a request for /robots.txt with txt
+func serveRobotsTxt(w http.ResponseWriter, r *http.Request, txt string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeContent(w, r, "robots.txt", time.Time{}, strings.NewReader(txt))
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"static-site-hosting/models"
+)
+
+func TestRobotsPolicy(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.SiteDomain = "sites.test"
+
+	dir := filepath.Join("deployments", "docs-1")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("docs"), 0644)
+	os.WriteFile(filepath.Join(dir, "robots.txt"), []byte("User-agent: *\nAllow: /\n"), 0644)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES ('docs-1', 'docs.zip', ?, ?, 'docs')", time.Now(), dir)
+
+	handler := SiteHostHandler(db, StaticFileHandler(db))
+	get := func(url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+		return rr
+	}
+
+	// The deployment's own robots.txt is served until settings override it
+	if rr := get("http://docs.sites.test/robots.txt"); rr.Body.String() != "User-agent: *\nAllow: /\n" || rr.Header().Get("X-Robots-Tag") != "" {
+		t.Errorf("expected the deployment's robots.txt and no tag, got %q, %q", rr.Body.String(), rr.Header().Get("X-Robots-Tag"))
+	}
+
+	settings := models.SiteSettings{RobotsTag: "noarchive", RobotsTxt: "User-agent: *\nDisallow: /drafts/\n"}
+	if err := saveSiteSettings(db, "docs-1", settings); err != nil {
+		t.Fatal(err)
+	}
+	if rr := get("http://docs.sites.test/"); rr.Header().Get("X-Robots-Tag") != "noarchive" {
+		t.Errorf("expected the site's robots tag, got %q", rr.Header().Get("X-Robots-Tag"))
+	}
+	if rr := get("http://docs.sites.test/robots.txt"); rr.Body.String() != settings.RobotsTxt || rr.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("expected the site's robots.txt, got %q (%s)", rr.Body.String(), rr.Header().Get("Content-Type"))
+	}
+
+	// Deployments reached by ID are kept out of search engines, but not the
+	// site itself
+	for _, url := range []string{"http://localhost/docs-1/index.html", "http://docs-1.sites.test/"} {
+		if rr := get(url); rr.Code != http.StatusOK || rr.Header().Get("X-Robots-Tag") != noIndexTag {
+			t.Errorf("%s: expected %d with a noindex tag, got %d %q", url, http.StatusOK, rr.Code, rr.Header().Get("X-Robots-Tag"))
+		}
+	}
+	if rr := get("http://localhost/docs-1/robots.txt"); rr.Body.String() != disallowAll {
+		t.Errorf("expected previews to disallow everything, got %q", rr.Body.String())
+	}
+	if rr := get("http://docs.sites.test/"); rr.Header().Get("X-Robots-Tag") != "noarchive" {
+		t.Errorf("expected the site to keep its own tag, got %q", rr.Header().Get("X-Robots-Tag"))
+	}
+
+	if err := (models.SiteSettings{RobotsTag: "noindex\r\nSet-Cookie: a=b"}).Validate(); err == nil {
+		t.Error("expected a multi-line robots_tag to be rejected")
+	}
+}
+
+func TestPreviewNoIndexDefaults(t *testing.T) {
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	preview := httptest.NewRequest(http.MethodGet, "/docs-1/index.html", nil)
+	off, on := false, true
+
+	tests := []struct {
+		name       string
+		siteDomain string
+		setting    *bool
+		noindex    bool
+	}{
+		{"with site host names", "sites.test", nil, true},
+		{"without site host names", "", nil, false},
+		{"disabled for the deployment", "sites.test", &off, false},
+		{"forced for the deployment", "", &on, true},
+	}
+	for _, tt := range tests {
+		cfg.SiteDomain = tt.siteDomain
+		tag, _ := robotsPolicy(preview, models.SiteSettings{PreviewNoIndex: tt.setting})
+		if (tag == noIndexTag) != tt.noindex {
+			t.Errorf("%s: expected noindex %v, got tag %q", tt.name, tt.noindex, tag)
+		}
+	}
+
+	cfg.SiteDomain = "sites.test"
+	cfg.PreviewNoIndex = false
+	if tag, _ := robotsPolicy(preview, models.SiteSettings{}); tag != "" {
+		t.Errorf("expected PREVIEW_NOINDEX=false to disable it, got tag %q", tag)
+	}
+}
@@ -5,12 +5,21 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"static-site-hosting/atrest"
+	"static-site-hosting/events"
+	"static-site-hosting/immutable"
+	"static-site-hosting/locks"
 	"static-site-hosting/models"
+	"static-site-hosting/routecache"
+	"static-site-hosting/usage"
+	"static-site-hosting/webhooks"
 
 	"github.com/google/uuid"
 )
@@ -30,12 +39,19 @@ func RollbackHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	}
 	sourceDeploymentID := path
 
+	// Hold the source for the whole copy so it can't be deleted underneath us
+	unlock, ok := lockMutation(w, "rollback", locks.Deployment(sourceDeploymentID))
+	if !ok {
+		return
+	}
+	defer unlock()
+
 	// Get the source deployment info
 	var sourceDeployment models.Deployment
-	err := db.QueryRow("SELECT id, filename, timestamp, path FROM deployments WHERE id = ?", sourceDeploymentID).
-		Scan(&sourceDeployment.ID, &sourceDeployment.Filename, &sourceDeployment.Timestamp, &sourceDeployment.Path)
+	err := db.QueryRow("SELECT id, filename, timestamp, path, site, status, owner_id FROM deployments WHERE id = ?", sourceDeploymentID).
+		Scan(&sourceDeployment.ID, &sourceDeployment.Filename, &sourceDeployment.Timestamp, &sourceDeployment.Path, &sourceDeployment.Site, &sourceDeployment.Status, &sourceDeployment.OwnerID)
 
-	if err == sql.ErrNoRows {
+	if err == sql.ErrNoRows || (err == nil && !ownsDeployment(r, sourceDeployment.OwnerID)) {
 		http.Error(w, "Source deployment not found", http.StatusNotFound)
 		return
 	}
@@ -43,6 +59,19 @@ func RollbackHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		http.Error(w, "Failed to fetch source deployment", http.StatusInternalServerError)
 		return
 	}
+	// Copying a failed deployment would put it live without validation
+	if sourceDeployment.Status != models.StatusReady {
+		http.Error(w, "Cannot roll back to a failed deployment", http.StatusConflict)
+		return
+	}
+
+	if key := locks.Site(sourceDeployment.Site); key != "" {
+		unlockSite, ok := lockMutation(w, "rollback", key)
+		if !ok {
+			return
+		}
+		defer unlockSite()
+	}
 
 	// Check if source deployment files still exist
 	if _, err := os.Stat(sourceDeployment.Path); os.IsNotExist(err) {
@@ -50,42 +79,128 @@ func RollbackHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	// Compare with what is live, so promoting the wrong artifact is caught
+	// before it replaces most of the site
+	previousLive := liveDeploymentID(db, sourceDeployment.Site)
+	summary := liveChanges(db, previousLive, sourceDeployment.Path)
+	if summary != nil && changedShare(*summary) > rollbackConfirmRatio && r.URL.Query().Get("confirm") != "true" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":           fmt.Sprintf("Rollback changes %.0f%% of the live deployment's files; repeat with confirm=true", 100*changedShare(*summary)),
+			"live_deployment": previousLive,
+			"changes":         summary,
+		})
+		return
+	}
+
+	started := time.Now()
+
 	// Create new deployment ID for the rollback
 	newDeploymentID := uuid.New().String()
 	newDeploymentPath := filepath.Join("deployments", newDeploymentID)
 
 	// Copy files from source deployment to new deployment
 	if err := copyDir(sourceDeployment.Path, newDeploymentPath); err != nil {
+		immutable.RemoveAll(newDeploymentPath)
 		http.Error(w, "Failed to copy deployment files", http.StatusInternalServerError)
 		return
 	}
+	if err := immutable.Seal(newDeploymentPath); err != nil {
+		immutable.RemoveAll(newDeploymentPath)
+		http.Error(w, "Failed to seal deployment", http.StatusInternalServerError)
+		return
+	}
 
 	// Create new deployment record in database
 	newFilename := fmt.Sprintf("[ROLLBACK] %s", sourceDeployment.Filename)
 	newDeployment := models.NewDeployment(newDeploymentID, newFilename, newDeploymentPath)
+	newDeployment.Site = sourceDeployment.Site
+	newDeployment.OwnerID = deploymentOwner(db, r, newDeployment.Site)
+	newDeployment.DeployedBy = deployedBy(r)
+	newDeployment.Visibility = deploymentVisibility(db, newDeployment.Site, "")
+	inherited, err := inheritPassword(db, newDeployment)
+	if err != nil {
+		immutable.RemoveAll(newDeploymentPath)
+		http.Error(w, "Failed to save rollback deployment", http.StatusInternalServerError)
+		return
+	}
 
 	_, err = db.Exec(
-		"INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, ?, ?, ?)",
-		newDeployment.ID, newDeployment.Filename, newDeployment.Timestamp, newDeployment.Path,
+		"INSERT INTO deployments (id, filename, timestamp, path, site, owner_id, deployed_by, visibility) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		newDeployment.ID, newDeployment.Filename, newDeployment.Timestamp, newDeployment.Path, newDeployment.Site, newDeployment.OwnerID, newDeployment.DeployedBy, newDeployment.Visibility,
 	)
 	if err != nil {
 		// Clean up files if DB insert fails
-		os.RemoveAll(newDeploymentPath)
+		if inherited {
+			db.Exec("DELETE FROM deployment_passwords WHERE deployment_id = ?", newDeployment.ID)
+		}
+		immutable.RemoveAll(newDeploymentPath)
 		http.Error(w, "Failed to save rollback deployment", http.StatusInternalServerError)
 		return
 	}
+	routecache.Invalidate()
+
+	recordPreloadHints(db, newDeploymentID, newDeploymentPath)
+	recordFileHashes(db, newDeploymentID, newDeploymentPath)
+	tenant := requestTenant(r, usage.TenantOf(db, sourceDeployment.ID))
+	entry := usage.RecordDeployment(db, newDeploymentID, tenant, newDeploymentPath, time.Since(started))
+	webhooks.Notify(db, webhooks.EventDeploymentCreated, newDeployment, newDeployment.Site)
+	events.Record(db, events.DeploymentCreated, newDeployment.ID, newDeployment.Site, map[string]any{"deployment": newDeployment, "usage": entry})
+	notifyPromotion(db, newDeployment.Site, previousLive)
 
 	w.Header().Set("Content-Type", "application/json")
 	response := map[string]interface{}{
 		"message":           "Rollback successful",
-		"source_deployment": sourceDeployment,
-		"new_deployment":    newDeployment,
+		"source_deployment": withURLs(r, &sourceDeployment),
+		"new_deployment":    withURLs(r, newDeployment),
+	}
+	if summary != nil {
+		response["live_deployment"] = previousLive
+		response["changes"] = summary
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
-// copyDir recursively copies a directory tree
+// rollbackConfirmRatio is the share of files a rollback may add, remove or
+// change in the live deployment before it must be confirmed
+const rollbackConfirmRatio = 0.5
+
+// liveChanges summarises how serving dir would change the files of liveID,
+// or returns nil when there is no live deployment or it can't be compared
+func liveChanges(db *sql.DB, liveID, dir string) *DiffSummary {
+	if liveID == "" {
+		return nil
+	}
+	live, err := fetchDeployment(db, liveID)
+	if err != nil {
+		return nil
+	}
+	diff, err := diffTrees(live.Path, dir)
+	if err != nil {
+		log.Printf("Warning: Failed to compare with live deployment %s: %v", liveID, err)
+		return nil
+	}
+	return &diff.Summary
+}
+
+// changedShare is the share of the files in either deployment that differ
+func changedShare(s DiffSummary) float64 {
+	changed := s.Added + s.Removed + s.Changed
+	if changed == 0 {
+		return 0
+	}
+	return float64(changed) / float64(changed+s.Unchanged)
+}
+
+// copyDir recursively copies a directory tree. The files of an encrypted
+// tree are copied decrypted, so the copy is sealed like any new tree.
 func copyDir(src, dst string) error {
+	return copyTree(src, src, dst)
+}
+
+// copyTree copies the directory src, within the tree at root, to dst
+func copyTree(root, src, dst string) error {
 	// Create destination directory
 	if err := os.MkdirAll(dst, 0755); err != nil {
 		return err
@@ -103,12 +218,12 @@ func copyDir(src, dst string) error {
 
 		if entry.IsDir() {
 			// Recursively copy subdirectory
-			if err := copyDir(srcPath, dstPath); err != nil {
+			if err := copyTree(root, srcPath, dstPath); err != nil {
 				return err
 			}
 		} else {
 			// Copy file
-			if err := copyFile(srcPath, dstPath); err != nil {
+			if err := copyFile(root, srcPath, dstPath); err != nil {
 				return err
 			}
 		}
@@ -117,9 +232,9 @@ func copyDir(src, dst string) error {
 	return nil
 }
 
-// copyFile copies a single file
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
+// copyFile copies a single file, within the tree at root, as plaintext
+func copyFile(root, src, dst string) error {
+	sourceFile, err := atrest.Open(root, src)
 	if err != nil {
 		return err
 	}
@@ -136,10 +251,10 @@ func copyFile(src, dst string) error {
 		return err
 	}
 
-	// Copy file permissions
+	// Copy file permissions, keeping the copy writable until it is sealed
 	sourceInfo, err := os.Stat(src)
 	if err != nil {
 		return err
 	}
-	return os.Chmod(dst, sourceInfo.Mode())
+	return os.Chmod(dst, sourceInfo.Mode()|0200)
 }
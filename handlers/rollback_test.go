@@ -209,3 +209,57 @@ func TestRollbackHandlerFilesNotExist(t *testing.T) {
 		t.Error("expected 'files no longer exist' error message")
 	}
 }
+
+func TestRollbackComparesWithLive(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	deploy := func(id string, at time.Time, files map[string]string) {
+		dir := filepath.Join("deployments", id)
+		for name, content := range files {
+			os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+			os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		}
+		if _, err := db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES (?, ?, ?, ?, ?)",
+			id, id+".zip", at, dir, "docs"); err != nil {
+			t.Fatalf("failed to insert deployment %s: %v", id, err)
+		}
+	}
+	now := time.Now()
+	deploy("v1", now.Add(-3*time.Hour), map[string]string{"index.html": "v1", "a.css": "a", "b.js": "b"})
+	deploy("other", now.Add(-2*time.Hour), map[string]string{"readme.txt": "unrelated"})
+	deploy("v2", now.Add(-time.Hour), map[string]string{"index.html": "v2", "a.css": "a", "b.js": "b"})
+
+	rollback := func(target string) (*httptest.ResponseRecorder, map[string]any) {
+		rr := httptest.NewRecorder()
+		RollbackHandler(rr, httptest.NewRequest(http.MethodPost, "/rollback/"+target, nil), db)
+		var body map[string]any
+		json.NewDecoder(rr.Body).Decode(&body)
+		return rr, body
+	}
+
+	// One file of three changes: the rollback goes ahead with a summary
+	rr, body := rollback("v1")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %v", rr.Code, body)
+	}
+	changes, _ := body["changes"].(map[string]any)
+	if body["live_deployment"] != "v2" || changes["changed"] != 1.0 || changes["unchanged"] != 2.0 {
+		t.Errorf("expected a summary of the changes to v2, got %v", body)
+	}
+
+	// Replacing every file needs confirming
+	rr, body = rollback("other")
+	if rr.Code != http.StatusConflict || !strings.Contains(body["error"].(string), "confirm=true") {
+		t.Fatalf("expected status 409 asking for confirmation, got %d: %v", rr.Code, body)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM deployments").Scan(&count)
+	if count != 4 {
+		t.Errorf("expected the unconfirmed rollback to create no deployment, found %d", count)
+	}
+	if rr, body = rollback("other?confirm=true"); rr.Code != http.StatusOK {
+		t.Errorf("expected the confirmed rollback to succeed, got %d: %v", rr.Code, body)
+	}
+}
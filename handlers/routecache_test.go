@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"static-site-hosting/models"
+	"static-site-hosting/routecache"
+)
+
+func TestStaticServingSurvivesDatabaseOutage(t *testing.T) {
+	db := setupTestDB(t)
+	defer os.RemoveAll("deployments")
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.SiteDomain = "sites.test"
+	defer routecache.SetAvailable(true)
+	savedMaxStale := routecache.MaxStale
+	defer func() { routecache.MaxStale = savedMaxStale }()
+
+	for _, id := range []string{"outage-1", "outage-2"} {
+		os.MkdirAll(filepath.Join("deployments", id, "private"), 0755)
+		os.WriteFile(filepath.Join("deployments", id, "index.html"), []byte("home"), 0644)
+		os.WriteFile(filepath.Join("deployments", id, "private", "x.html"), []byte("secret"), 0644)
+	}
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES ('outage-1', 'a.zip', ?, 'deployments/outage-1', 'outage-docs')", time.Now())
+	saveSiteSettings(db, "outage-1", models.SiteSettings{AccessRules: []models.AccessRule{{Path: "^/private/"}}})
+
+	handler := SiteHostHandler(db, http.NotFoundHandler())
+	get := func(host, path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := get("outage-docs.sites.test", "/index.html"); code != http.StatusOK {
+		t.Fatalf("expected the site to be served, got %d", code)
+	}
+	get("outage-docs.sites.test", "/private/x.html")
+
+	db.Close()
+	routecache.Check(context.Background(), db, time.Second)
+	if routecache.Available() {
+		t.Fatal("expected the closed database to be reported unavailable")
+	}
+
+	if code := get("outage-docs.sites.test", "/index.html"); code != http.StatusOK {
+		t.Errorf("expected the site to be served from the routing cache, got %d", code)
+	}
+	if code := get("outage-docs.sites.test", "/private/x.html"); code != http.StatusForbidden {
+		t.Errorf("expected cached access rules to still apply, got %d", code)
+	}
+	if code := get("outage-2.sites.test", "/index.html"); code != http.StatusNotFound {
+		t.Errorf("expected an unknown host to be refused without the database, got %d", code)
+	}
+	static := StaticFileHandler(db)
+	rr := httptest.NewRecorder()
+	static.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/outage-2/index.html", nil))
+	if rr.Code == http.StatusOK {
+		t.Error("expected a deployment whose visibility can't be looked up to be refused")
+	}
+
+	routecache.Invalidate()
+	routecache.MaxStale = 0
+	if code := get("outage-docs.sites.test", "/index.html"); code != http.StatusNotFound {
+		t.Errorf("expected routing data past its maximum staleness to be dropped, got %d", code)
+	}
+}
+
+func TestNewDeploymentIsLiveImmediately(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.SiteDomain = "sites.test"
+
+	os.MkdirAll(filepath.Join("deployments", "live-1"), 0755)
+	os.WriteFile(filepath.Join("deployments", "live-1", "index.html"), []byte("first"), 0644)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES ('live-1', 'a.zip', ?, 'deployments/live-1', 'live')", time.Now().Add(-time.Minute))
+
+	handler := SiteHostHandler(db, http.NotFoundHandler())
+	get := func() string {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://live.sites.test/index.html", nil))
+		return rr.Body.String()
+	}
+	if body := get(); body != "first" {
+		t.Fatalf("expected the first deployment, got %q", body)
+	}
+
+	zipBuffer, err := createTestZip()
+	if err != nil {
+		t.Fatalf("failed to create test zip: %v", err)
+	}
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("site", "live")
+	part, _ := writer.CreateFormFile("file", "site.zip")
+	io.Copy(part, zipBuffer)
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	UploadHandler(rr, req, db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("upload failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	if body := get(); !strings.Contains(body, "Test Site") {
+		t.Errorf("expected the new deployment to be served right after publishing, got %q", body)
+	}
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// secretFilePatterns are files that come along when a whole project
+// directory is zipped and must never be published. Patterns ending in a
+// slash match directories anywhere in the tree, the others file names.
+var secretFilePatterns = []string{
+	".env", ".env.*", "*.pem", "*.key", "id_rsa", "id_ed25519", ".npmrc", ".htpasswd",
+	".git/", ".svn/", ".hg/",
+}
+
+// secretFile reports whether name, a slash-separated path within a
+// deployment, is a secret file that cfg.SecretFilesAllow doesn't let
+// through. Names ending in a slash are directories.
+func secretFile(name string) bool {
+	clean := strings.Trim(path.Clean("/"+name), "/")
+	if clean == "" {
+		return false
+	}
+	dirs := strings.Split(clean, "/")
+	file := ""
+	if !strings.HasSuffix(name, "/") {
+		dirs, file = dirs[:len(dirs)-1], dirs[len(dirs)-1]
+	}
+	for _, pattern := range secretFilePatterns {
+		// Listing a pattern of its own turns it off
+		if slices.Contains(cfg.SecretFilesAllow, pattern) {
+			continue
+		}
+		if dir, ok := strings.CutSuffix(pattern, "/"); ok {
+			for _, d := range dirs {
+				if match, _ := path.Match(dir, d); match {
+					return !allowedSecret(clean, file)
+				}
+			}
+		} else if match, _ := path.Match(pattern, file); match && file != "" {
+			return !allowedSecret(clean, file)
+		}
+	}
+	return false
+}
+
+// allowedSecret reports whether cfg.SecretFilesAllow keeps a secret file:
+// patterns with a slash are matched against its whole path, the others
+// against its name
+func allowedSecret(clean, file string) bool {
+	for _, pattern := range cfg.SecretFilesAllow {
+		subject := file
+		if strings.Contains(pattern, "/") {
+			subject = clean
+		}
+		if match, _ := path.Match(pattern, subject); match {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutSecretFiles returns the archive to retain of one at src, in
+// format: src itself when it holds no secret files, otherwise a copy in
+// the spool without them, which the caller removes. Retained archives can
+// be downloaded, so they mustn't give away what extraction left out.
+func withoutSecretFiles(src, format string) (string, error) {
+	dst := src + ".stripped"
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	var removed int
+	if format == archiveZip {
+		removed, err = copyZipWithoutSecrets(src, out)
+	} else {
+		removed, err = copyTarWithoutSecrets(src, out, format == archiveTarGz)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || removed == 0 {
+		os.Remove(dst)
+		if err != nil {
+			return "", err
+		}
+		return src, nil
+	}
+	return dst, nil
+}
+
+// copyZipWithoutSecrets copies the entries of the zip at src that aren't
+// secret files to w, still compressed, and returns how many it left out
+func copyZipWithoutSecrets(src string, w io.Writer) (int, error) {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+	zw := zip.NewWriter(w)
+	removed := 0
+	for _, f := range zr.File {
+		if secretFile(f.Name) {
+			removed++
+			continue
+		}
+		if err := zw.Copy(f); err != nil {
+			return 0, err
+		}
+	}
+	return removed, zw.Close()
+}
+
+// copyTarWithoutSecrets copies the entries of the tar at src that aren't
+// secret files to w, and returns how many it left out
+func copyTarWithoutSecrets(src string, w io.Writer, gzipped bool) (int, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	var gz *gzip.Writer
+	if gzipped {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		defer zr.Close()
+		r = zr
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	removed := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		name := hdr.Name
+		if hdr.Typeflag == tar.TypeDir && !strings.HasSuffix(name, "/") {
+			name += "/"
+		}
+		if secretFile(name) {
+			removed++
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return 0, err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return 0, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	if gz != nil {
+		return removed, gz.Close()
+	}
+	return removed, nil
+}
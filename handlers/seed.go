@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+
+	"static-site-hosting/events"
+	"static-site-hosting/immutable"
+	"static-site-hosting/models"
+	"static-site-hosting/usage"
+)
+
+// Seed deploys the sample sites in fsys, laid out as {site}/{n}/... with
+// each n a deployment, oldest first, so a development server starts with
+// something to show. Deployments are recorded as if uploaded an hour
+// apart. A database that already has deployments is left alone. It
+// returns the number of deployments made.
+func Seed(db *sql.DB, fsys fs.FS) (int, error) {
+	var existing int
+	if err := db.QueryRow("SELECT COUNT(*) FROM deployments").Scan(&existing); err != nil || existing > 0 {
+		return 0, err
+	}
+
+	versions, err := fs.Glob(fsys, "*/*")
+	if err != nil {
+		return 0, err
+	}
+	started := time.Now().Add(-time.Duration(len(versions)) * time.Hour)
+	for i, version := range versions {
+		site := path.Dir(version)
+		deployment := models.NewDeployment(uuid.New().String(), fmt.Sprintf("%s-%s.zip", site, path.Base(version)), "")
+		deployment.Path = filepath.Join("deployments", deployment.ID)
+		deployment.Site = site
+		deployment.Timestamp = started.Add(time.Duration(i) * time.Hour)
+
+		if err := copyFixture(fsys, version, deployment.Path); err != nil {
+			immutable.RemoveAll(deployment.Path)
+			return i, err
+		}
+		if err := immutable.Seal(deployment.Path); err != nil {
+			immutable.RemoveAll(deployment.Path)
+			return i, err
+		}
+		_, err := db.Exec(
+			"INSERT INTO deployments (id, filename, timestamp, path, site, status) VALUES (?, ?, ?, ?, ?, ?)",
+			deployment.ID, deployment.Filename, deployment.Timestamp, deployment.Path, deployment.Site, deployment.Status,
+		)
+		if err != nil {
+			immutable.RemoveAll(deployment.Path)
+			return i, err
+		}
+		recordFileHashes(db, deployment.ID, deployment.Path)
+		recordPreloadHints(db, deployment.ID, deployment.Path)
+		entry := usage.RecordDeployment(db, deployment.ID, usage.DefaultTenant, deployment.Path, 0)
+		events.Record(db, events.DeploymentCreated, deployment.ID, deployment.Site, map[string]any{"deployment": deployment, "usage": entry})
+	}
+	return len(versions), nil
+}
+
+// copyFixture copies directory dir of fsys to dest
+func copyFixture(fsys fs.FS, dir, dest string) error {
+	return fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, name)
+		target := filepath.Join(dest, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"static-site-hosting/fixtures"
+	"static-site-hosting/models"
+)
+
+func TestSeed(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	sites, _ := fs.Sub(fixtures.Sites, "sites")
+	n, err := Seed(db, sites)
+	if err != nil || n != 4 {
+		t.Fatalf("expected 4 sample deployments, got %d, %v", n, err)
+	}
+	if n, err := Seed(db, sites); err != nil || n != 0 {
+		t.Errorf("expected a seeded database to be left alone, got %d, %v", n, err)
+	}
+
+	rr := httptest.NewRecorder()
+	SitesHandler(rr, httptest.NewRequest(http.MethodGet, "/sites", nil), db)
+	var summaries []models.SiteSummary
+	json.NewDecoder(rr.Body).Decode(&summaries)
+	bySite := map[string]models.SiteSummary{}
+	for _, s := range summaries {
+		bySite[s.Site] = s
+	}
+	docs := bySite["docs"]
+	if len(summaries) != 3 || docs.DeploymentCount != 2 || docs.LiveDeployment == nil || docs.TotalBytes == 0 {
+		t.Fatalf("expected three sites with two docs deployments, got %+v", summaries)
+	}
+
+	rr = httptest.NewRecorder()
+	StaticFileHandler(db).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/"+docs.LiveDeployment.ID+"/guide/index.html", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Guide") {
+		t.Errorf("expected the latest docs deployment to serve its guide, got %d", rr.Code)
+	}
+}
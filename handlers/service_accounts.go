@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+	"static-site-hosting/routecache"
+)
+
+// serviceProvider marks the claims of service account keys
+const serviceProvider = "service"
+
+// serviceKeyTTL stands in for never expiring: service account keys last
+// until they are revoked
+const serviceKeyTTL = 100 * 365 * 24 * time.Hour
+
+const serviceAccountColumns = "id, name, attribution, role, tenant, created_by, created_at, revoked_at"
+
+// ServiceAccountsHandler lists (GET) service accounts or creates (POST) one
+// along with its first key, which is only ever returned then. Admins only.
+// Expected: POST /admin/service-accounts {"name": "ci-prod", "role": "deployer", "scopes": ["deploy:upload"]}
+func ServiceAccountsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, signer *auth.Signer) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden: admin role required", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := db.Query("SELECT " + serviceAccountColumns + " FROM service_accounts ORDER BY name")
+		if err != nil {
+			http.Error(w, "Failed to fetch service accounts", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		accounts := []models.ServiceAccount{}
+		for rows.Next() {
+			a, err := scanServiceAccount(rows)
+			if err != nil {
+				http.Error(w, "Failed to scan service account", http.StatusInternalServerError)
+				return
+			}
+			accounts = append(accounts, *a)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(accounts)
+
+	case http.MethodPost:
+		createServiceAccount(w, r, db, signer)
+
+	default:
+		http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+	}
+}
+
+// ServiceAccountHandler shows a service account with its keys (GET),
+// revokes it with all of them (DELETE), or issues it another key (POST
+// .../keys), for rotating without downtime. Single keys are revoked with
+// DELETE /auth/tokens/{id}. Admins only.
+// Expected: POST /admin/service-accounts/{id}/keys {"name": "2025", "scopes": ["deploy:upload"]}
+func ServiceAccountHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, signer *auth.Signer) {
+	if !isAdmin(r) {
+		http.Error(w, "Forbidden: admin role required", http.StatusForbidden)
+		return
+	}
+
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/service-accounts/"), "/")
+	account, err := scanServiceAccount(db.QueryRow("SELECT "+serviceAccountColumns+" FROM service_accounts WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Service account not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch service account", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case rest == "keys" && r.Method == http.MethodPost:
+		if account.RevokedAt != nil {
+			http.Error(w, "Service account is revoked", http.StatusConflict)
+			return
+		}
+		var req struct {
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if req.Name == "" {
+			req.Name = account.Name
+		}
+		errs := scopeErrors(account.Role, req.Scopes)
+		if len(req.Name) > 100 {
+			errs.Add("name", models.CodeInvalid, "name must be at most 100 characters")
+		}
+		if len(errs) > 0 {
+			writeFieldErrors(w, http.StatusBadRequest, errs...)
+			return
+		}
+		key, err := issueServiceKey(db, signer, account, req.Name, req.Scopes)
+		if err != nil {
+			log.Printf("Failed to issue key for service account %s: %v", account.Name, err)
+			http.Error(w, "Failed to issue key", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(key)
+
+	case rest != "":
+		http.Error(w, "Not found", http.StatusNotFound)
+
+	case r.Method == http.MethodGet:
+		if account.Keys, err = serviceKeys(db, account); err != nil {
+			http.Error(w, "Failed to fetch keys", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(account)
+
+	case r.Method == http.MethodDelete:
+		now := time.Now().UTC()
+		if _, err := db.Exec("UPDATE service_accounts SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", now, id); err != nil {
+			http.Error(w, "Failed to revoke service account", http.StatusInternalServerError)
+			return
+		}
+		if _, err := db.Exec("UPDATE api_tokens SET revoked_at = ? WHERE owner_id = ? AND revoked_at IS NULL", now, account.Subject()); err != nil {
+			http.Error(w, "Failed to revoke keys", http.StatusInternalServerError)
+			return
+		}
+		routecache.Invalidate()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Service account revoked", "id": id})
+
+	default:
+		http.Error(w, "GET or DELETE required", http.StatusMethodNotAllowed)
+	}
+}
+
+func createServiceAccount(w http.ResponseWriter, r *http.Request, db *sql.DB, signer *auth.Signer) {
+	var req struct {
+		Name        string   `json:"name"`
+		Attribution string   `json:"attribution"`
+		Role        string   `json:"role"`
+		Tenant      string   `json:"tenant"`
+		Scopes      []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = auth.RoleDeployer
+	}
+	req.Name = strings.ToLower(strings.TrimSpace(req.Name))
+	req.Attribution = strings.TrimSpace(req.Attribution)
+	if req.Attribution == "" {
+		req.Attribution = req.Name
+	}
+
+	var errs models.ValidationErrors
+	if req.Name == "" {
+		errs.Add("name", models.CodeMissing, "name is required")
+	} else if !usernamePattern.MatchString(req.Name) {
+		errs.Add("name", models.CodeInvalid, "name may only contain letters, digits, '.', '_', '@' and '-' (up to 64)")
+	}
+	if len(req.Attribution) > 100 {
+		errs.Add("attribution", models.CodeInvalid, "attribution must be at most 100 characters")
+	}
+	if !auth.ValidRole(req.Role) {
+		errs.Add("role", models.CodeInvalid, "role must be admin, deployer or viewer")
+	} else {
+		errs = append(errs, scopeErrors(req.Role, req.Scopes)...)
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, http.StatusBadRequest, errs...)
+		return
+	}
+
+	account := &models.ServiceAccount{
+		ID:          uuid.New().String(),
+		Name:        req.Name,
+		Attribution: req.Attribution,
+		Role:        req.Role,
+		Tenant:      req.Tenant,
+		CreatedBy:   requestOwner(r),
+		CreatedAt:   time.Now().UTC(),
+	}
+	_, err := db.Exec(
+		"INSERT INTO service_accounts (id, name, attribution, role, tenant, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		account.ID, account.Name, account.Attribution, account.Role, account.Tenant, account.CreatedBy, account.CreatedAt,
+	)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		writeFieldErrors(w, http.StatusConflict, models.FieldError{Field: "name", Code: models.CodeInvalid, Message: "name is already taken"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create service account %s: %v", account.Name, err)
+		http.Error(w, "Failed to create service account", http.StatusInternalServerError)
+		return
+	}
+
+	key, err := issueServiceKey(db, signer, account, account.Name, req.Scopes)
+	if err != nil {
+		log.Printf("Failed to issue key for service account %s: %v", account.Name, err)
+		http.Error(w, "Failed to issue key", http.StatusInternalServerError)
+		return
+	}
+	account.Keys = []models.APIToken{*key}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(account)
+}
+
+// issueServiceKey issues a key acting as account within scopes. Keys are
+// API tokens owned by the account, so they are checked, listed and
+// revoked like any other.
+func issueServiceKey(db *sql.DB, signer *auth.Signer, account *models.ServiceAccount, name string, scopes []string) (*models.APIToken, error) {
+	now := time.Now().UTC()
+	t := &models.APIToken{
+		ID:        uuid.New().String(),
+		OwnerID:   account.Subject(),
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: now.Add(serviceKeyTTL),
+	}
+	claims := auth.Claims{
+		Subject:  account.Subject(),
+		Name:     account.Attribution,
+		Role:     account.Role,
+		Provider: serviceProvider,
+		Tenant:   account.Tenant,
+	}
+	token, err := signAPIToken(signer, &claims, t)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(
+		"INSERT INTO api_tokens (id, owner_id, name, scopes, created_at, expires_at, token_hash) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		t.ID, t.OwnerID, t.Name, strings.Join(t.Scopes, ","), t.CreatedAt, t.ExpiresAt, t.TokenHash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	t.Token = token
+	return t, nil
+}
+
+// serviceKeys returns the keys issued for account, revoked ones included
+func serviceKeys(db *sql.DB, account *models.ServiceAccount) ([]models.APIToken, error) {
+	rows, err := db.Query("SELECT id, owner_id, name, scopes, created_at, expires_at, revoked_at FROM api_tokens WHERE owner_id = ? ORDER BY created_at", account.Subject())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIToken{}
+	for rows.Next() {
+		var t models.APIToken
+		var scopes string
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&t.ID, &t.OwnerID, &t.Name, &scopes, &t.CreatedAt, &t.ExpiresAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Split(scopes, ",")
+		if revokedAt.Valid {
+			t.RevokedAt = &revokedAt.Time
+		}
+		keys = append(keys, t)
+	}
+	return keys, rows.Err()
+}
+
+// deployedBy returns the attribution recorded on deployments the caller
+// makes: a service account's, or nothing for people
+func deployedBy(r *http.Request) string {
+	if claims := auth.FromContext(r.Context()); claims != nil && claims.Provider == serviceProvider {
+		return claims.Name
+	}
+	return ""
+}
+
+func scanServiceAccount(row interface{ Scan(...any) error }) (*models.ServiceAccount, error) {
+	var a models.ServiceAccount
+	var revokedAt sql.NullTime
+	if err := row.Scan(&a.ID, &a.Name, &a.Attribution, &a.Role, &a.Tenant, &a.CreatedBy, &a.CreatedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		a.RevokedAt = &revokedAt.Time
+	}
+	return &a, nil
+}
+
+// scopeErrors checks the scopes asked for a service account key: at least
+// one, each known and within what the account's role may hold
+func scopeErrors(role string, scopes []string) models.ValidationErrors {
+	var errs models.ValidationErrors
+	if len(scopes) == 0 {
+		errs.Add("scopes", models.CodeMissing, "scopes is required")
+	}
+	for _, scope := range scopes {
+		if !auth.ValidScope(scope) {
+			errs.Add("scopes", models.CodeInvalid, "unknown scope %q; use deploy:read, deploy:upload, deploy:write, deploy:delete or admin", scope)
+		} else if !auth.ScopeAllowed(role, scope) {
+			errs.Add("scopes", models.CodeInvalid, "the %s role can't hold the %s scope", role, scope)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+)
+
+func TestServiceAccounts(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	signer := auth.NewSigner([]byte("secret"))
+	revoked := TokenRevoked(db)
+
+	call := func(method, path string, body any, claims *auth.Claims) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := as(httptest.NewRequest(method, path, bytes.NewReader(data)), claims)
+		rr := httptest.NewRecorder()
+		if path == "/admin/service-accounts" {
+			ServiceAccountsHandler(rr, req, db, signer)
+		} else {
+			ServiceAccountHandler(rr, req, db, signer)
+		}
+		return rr
+	}
+
+	create := map[string]any{"name": "ci-prod", "scopes": []string{auth.ScopeDeployUpload}}
+	if rr := call(http.MethodPost, "/admin/service-accounts", create, aliceClaims); rr.Code != http.StatusForbidden {
+		t.Errorf("expected only admins to create service accounts, got %d", rr.Code)
+	}
+	rr := call(http.MethodPost, "/admin/service-accounts", create, adminClaims)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var account models.ServiceAccount
+	json.NewDecoder(rr.Body).Decode(&account)
+	if account.Role != auth.RoleDeployer || account.Attribution != "ci-prod" || account.CreatedBy != adminClaims.Subject || len(account.Keys) != 1 {
+		t.Fatalf("expected a deployer attributed as ci-prod with one key, got %+v", account)
+	}
+	key := account.Keys[0]
+	claims, err := signer.Verify(key.Token)
+	if err != nil || claims.Subject != "service:"+account.ID || claims.TokenID != key.ID {
+		t.Fatalf("expected a key acting as the account, got %+v, %v", claims, err)
+	}
+	if time.Until(key.ExpiresAt) < 50*365*24*time.Hour {
+		t.Errorf("expected the key not to expire, got %v", key.ExpiresAt)
+	}
+
+	for name, body := range map[string]map[string]any{
+		"taken name":      create,
+		"beyond the role": {"name": "ci-admin", "scopes": []string{auth.ScopeAdmin}},
+		"no scopes":       {"name": "ci-staging"},
+		"invalid name":    {"name": "ci prod", "scopes": []string{auth.ScopeDeployUpload}},
+		"unknown role":    {"name": "ci-staging", "role": "robot", "scopes": []string{auth.ScopeDeployUpload}},
+	} {
+		if rr := call(http.MethodPost, "/admin/service-accounts", body, adminClaims); rr.Code != http.StatusBadRequest && rr.Code != http.StatusConflict {
+			t.Errorf("%s: expected the account to be refused, got %d", name, rr.Code)
+		}
+	}
+
+	// Deployments made with the key are attributed to the account
+	upload := func(w http.ResponseWriter, r *http.Request) { UploadHandler(w, as(r, claims), db) }
+	var deployment models.Deployment
+	json.NewDecoder(uploadToSite(t, upload, "docs", testZipBytes(t)).Body).Decode(&deployment)
+	if deployment.DeployedBy != "ci-prod" || deployment.OwnerID != claims.Subject {
+		t.Errorf("expected the deployment to be deployed by ci-prod, got %q (owner %q)", deployment.DeployedBy, deployment.OwnerID)
+	}
+	list := httptest.NewRecorder()
+	ListDeploymentsHandler(list, as(httptest.NewRequest(http.MethodGet, "/deployments", nil), adminClaims), db)
+	var listed []models.Deployment
+	json.NewDecoder(list.Body).Decode(&listed)
+	if len(listed) != 1 || listed[0].DeployedBy != "ci-prod" {
+		t.Errorf("expected the listing to show the attribution, got %+v", listed)
+	}
+
+	// A second key allows rotating; revoking the account revokes both
+	rr = call(http.MethodPost, "/admin/service-accounts/"+account.ID+"/keys", map[string]any{"scopes": []string{auth.ScopeDeployUpload}}, adminClaims)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var second models.APIToken
+	json.NewDecoder(rr.Body).Decode(&second)
+	rr = call(http.MethodGet, "/admin/service-accounts/"+account.ID, nil, adminClaims)
+	var fetched models.ServiceAccount
+	json.NewDecoder(rr.Body).Decode(&fetched)
+	if len(fetched.Keys) != 2 || fetched.Keys[0].Token != "" {
+		t.Errorf("expected both keys without their secrets, got %+v", fetched.Keys)
+	}
+
+	if rr := call(http.MethodDelete, "/admin/service-accounts/"+account.ID, nil, adminClaims); rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !revoked(key.ID, auth.TokenHash(key.Token)) || !revoked(second.ID, auth.TokenHash(second.Token)) {
+		t.Error("expected the account's keys to be revoked with it")
+	}
+	rr = call(http.MethodPost, "/admin/service-accounts/"+account.ID+"/keys", map[string]any{"scopes": []string{auth.ScopeDeployUpload}}, adminClaims)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected a revoked account to get no new keys, got %d", rr.Code)
+	}
+	if rr := call(http.MethodGet, "/admin/service-accounts/unknown", nil, adminClaims); rr.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown account to be 404, got %d", rr.Code)
+	}
+}
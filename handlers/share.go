@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+)
+
+// Query parameters of a share link
+const (
+	shareExpiresParam = "share_expires"
+	shareSigParam     = "share_sig"
+)
+
+// shareSigner signs share links; nil disables them
+var shareSigner *auth.Signer
+
+// SetShareSigner sets the signer for share links to protected deployments
+func SetShareSigner(s *auth.Signer) {
+	shareSigner = s
+}
+
+// ShareDeploymentHandler mints a signed link that shows a password-protected
+// or private deployment to whoever holds it until it expires, so previews
+// can go to reviewers who shouldn't have the password or an account. ttl defaults to, and can't
+// exceed, SHARE_LINK_TTL.
+// Expected: POST /deployments/{id}/share?ttl=1h
+func ShareDeploymentHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if shareSigner == nil {
+		http.Error(w, "Share links are not available", http.StatusServiceUnavailable)
+		return
+	}
+	deploymentID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/deployments/"), "/share")
+
+	var ownerID string
+	err := db.QueryRow("SELECT owner_id FROM deployments WHERE id = ?", deploymentID).Scan(&ownerID)
+	if err == sql.ErrNoRows || err == nil && !ownsDeployment(r, ownerID) {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch deployment", http.StatusInternalServerError)
+		return
+	}
+
+	ttl := cfg.ShareLinkTTL
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeFieldErrors(w, http.StatusBadRequest, models.FieldError{Field: "ttl", Code: models.CodeInvalid, Message: "ttl must be a positive duration such as 1h"})
+			return
+		}
+		ttl = min(d, ttl)
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	link := publicBaseURL(r) + "/s/" + deploymentID + "/?" + url.Values{
+		shareExpiresParam: {strconv.FormatInt(expires.Unix(), 10)},
+		shareSigParam:     {shareSigner.SignShare(deploymentID, expires.Unix())},
+	}.Encode()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"deployment_id": deploymentID,
+		"url":           link,
+		"expires_at":    expires.UTC(),
+	})
+}
+
+// shareCookie names the cookie that remembers a share link to deploymentID
+func shareCookie(deploymentID string) string {
+	return "share_" + deploymentID
+}
+
+// shareAuthorized reports whether r opens deploymentID with a valid share
+// link. The link's signature is kept in a cookie until it expires, so the
+// page's own assets and links load without it. Shared responses must not
+// be stored by shared caches.
+func shareAuthorized(w http.ResponseWriter, r *http.Request, deploymentID string) bool {
+	if shareSigner == nil {
+		return false
+	}
+	expires, sig := r.URL.Query().Get(shareExpiresParam), r.URL.Query().Get(shareSigParam)
+	fromLink := sig != ""
+	if !fromLink {
+		c, err := r.Cookie(shareCookie(deploymentID))
+		if err != nil {
+			return false
+		}
+		expires, sig, _ = strings.Cut(c.Value, ".")
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || !shareSigner.VerifyShare(deploymentID, unix, sig) {
+		return false
+	}
+
+	if fromLink {
+		http.SetCookie(w, &http.Cookie{
+			Name:     shareCookie(deploymentID),
+			Value:    expires + "." + sig,
+			Path:     "/",
+			Expires:  time.Unix(unix, 0),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(publicBaseURL(r), "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	w.Header().Set("Cache-Control", "private")
+	return true
+}
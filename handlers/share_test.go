@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"static-site-hosting/auth"
+)
+
+func TestShareDeployment(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	fastPasswords(t)
+	SetShareSigner(auth.NewSigner([]byte("secret")))
+	defer SetShareSigner(nil)
+
+	dir := filepath.Join("deployments", "preview-1")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("preview"), 0644)
+	os.WriteFile(filepath.Join(dir, "app.css"), []byte("body{}"), 0644)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, owner_id) VALUES ('preview-1', 'preview.zip', ?, ?, ?)", time.Now(), dir, requestOwner(as(httptest.NewRequest(http.MethodGet, "/", nil), aliceClaims)))
+	hash, _ := auth.HashPassword("s3cret")
+	db.Exec("INSERT INTO deployment_passwords (deployment_id, username, password_hash, updated_at) VALUES ('preview-1', '', ?, ?)", hash, time.Now())
+
+	share := func(query string, claims *auth.Claims) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ShareDeploymentHandler(rr, as(httptest.NewRequest(http.MethodPost, "/deployments/preview-1/share"+query, nil), claims), db)
+		return rr
+	}
+	// Routed as cmd/main.go routes sites
+	static := StaticFileHandler(db)
+	mux := http.NewServeMux()
+	mux.Handle("/s/", http.StripPrefix("/s", static))
+	mux.Handle("/", static)
+	get := func(path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := share("", bobClaims); rr.Code != http.StatusNotFound {
+		t.Errorf("expected bob not to see alice's deployment, got %d", rr.Code)
+	}
+	if rr := share("?ttl=soon", aliceClaims); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid ttl to be rejected, got %d", rr.Code)
+	}
+	rr := share("?ttl=1h", aliceClaims)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var minted struct {
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	json.NewDecoder(rr.Body).Decode(&minted)
+	if until := time.Until(minted.ExpiresAt); until <= 59*time.Minute || until > time.Hour {
+		t.Errorf("expected the link to last an hour, got %v", until)
+	}
+	link, err := url.Parse(minted.URL)
+	if err != nil || link.Path != "/s/preview-1/" {
+		t.Fatalf("expected a link to the deployment, got %q", minted.URL)
+	}
+
+	if rr := get("/preview-1/index.html"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the deployment to stay protected, got %d", rr.Code)
+	}
+	rr = get(minted.URL)
+	if rr.Code != http.StatusOK || rr.Header().Get("Cache-Control") != "private" {
+		t.Fatalf("expected the link to open the deployment privately, got %d (%q)", rr.Code, rr.Header().Get("Cache-Control"))
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != shareCookie("preview-1") {
+		t.Fatalf("expected a share cookie, got %v", cookies)
+	}
+	if rr := get("/preview-1/app.css", cookies[0]); rr.Code != http.StatusOK {
+		t.Errorf("expected the cookie to open the page's assets, got %d", rr.Code)
+	}
+	if cookies[0].Secure {
+		t.Error("expected the cookie not to be Secure over plain HTTP")
+	}
+	// Behind a TLS-terminating proxy the cookie is Secure, as the session cookie is
+	req := httptest.NewRequest(http.MethodGet, minted.URL, nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if cookies := rr.Result().Cookies(); len(cookies) != 1 || !cookies[0].Secure {
+		t.Errorf("expected a Secure cookie behind an HTTPS proxy, got %v", cookies)
+	}
+
+	tampered := link.Query()
+	tampered.Set(shareExpiresParam, strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10))
+	if rr := get("/preview-1/index.html?" + tampered.Encode()); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected an extended link to be rejected, got %d", rr.Code)
+	}
+	past := time.Now().Add(-time.Minute).Unix()
+	expired := url.Values{shareExpiresParam: {strconv.FormatInt(past, 10)}, shareSigParam: {shareSigner.SignShare("preview-1", past)}}
+	if rr := get("/preview-1/index.html?" + expired.Encode()); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected an expired link to be rejected, got %d", rr.Code)
+	}
+}
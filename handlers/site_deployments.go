@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"static-site-hosting/artifacts"
+	"static-site-hosting/immutable"
+	"static-site-hosting/models"
+
+	"github.com/google/uuid"
+)
+
+// Archive formats accepted as raw request bodies
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+)
+
+// archiveFormats maps request Content-Types to archive formats
+var archiveFormats = map[string]string{
+	"application/zip":              archiveZip,
+	"application/x-zip-compressed": archiveZip,
+	"application/x-tar":            archiveTar,
+	"application/gzip":             archiveTarGz,
+	"application/x-gzip":           archiveTarGz,
+	"application/x-gtar":           archiveTarGz,
+	"application/x-compressed-tar": archiveTarGz,
+}
+
+// SiteDeploymentsHandler deploys a raw archive body to a site.
+// Expected: PUT /sites/{slug}/deployments
+//
+// The archive format comes from the Content-Type. Tar bodies are extracted
+// straight from the request stream; zip needs random access to its central
+// directory, so zip bodies are spooled to a temporary file first. Either
+// way there is no multipart encoding, so
+//
+//	curl -T site.zip -H 'Content-Type: application/zip' .../sites/docs/deployments
+//
+// is a complete deploy.
+func SiteDeploymentsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "PUT required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	site := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/sites/"), "/deployments")
+	requestedID := r.URL.Query().Get("deployment_id")
+	var errs models.ValidationErrors
+	errs.Include(siteSlugError(site))
+	errs.Include(deploymentIDError(requestedID))
+	_, e := expiresAtParam(r, site)
+	errs.Include(e)
+	if len(errs) > 0 {
+		writeFieldErrors(w, http.StatusBadRequest, errs...)
+		return
+	}
+
+	format, e := archiveFormat(r)
+	if e != nil {
+		writeFieldErrors(w, http.StatusUnsupportedMediaType, *e)
+		return
+	}
+
+	started := time.Now()
+	progress := startUpload(uploadID(r), r.ContentLength)
+	w.Header().Set("X-Upload-Id", progress.id)
+
+	if !limitUploadBody(w, r, db) {
+		writeUploadTooLarge(w, uploadPolicy(r, db).MaxBytes, progress)
+		return
+	}
+	// The body is checked against its signature once it has been read
+	if _, problem := verifyUploadSignature(r); problem != "" {
+		progress.fail(problem)
+		http.Error(w, problem, http.StatusUnauthorized)
+		return
+	}
+
+	deployRawArchive(w, r, db, progress.body(r.Body), nil, format, site, requestedID, rawArchiveFilename(r, site, format), progress, started)
+}
+
+// deployRawArchive extracts and publishes an archive read from body. A zip
+// body that is a split archive is joined first, given the size of each of
+// its volumes.
+func deployRawArchive(w http.ResponseWriter, r *http.Request, db *sql.DB, body io.Reader, volumes []int64, format, site, requestedID, filename string, progress *uploadTracker, started time.Time) {
+	fail := func(msg string, code int) {
+		progress.fail(msg)
+		http.Error(w, msg, code)
+	}
+
+	// Tar bodies are extracted before their hash, and so their final ID, is
+	// known; they are staged under a random ID and renamed
+	stagingID := uuid.New().String()
+	deploymentID := stagingID
+	destDir := filepath.Join("deployments", stagingID)
+	hash := sha256.New()
+	body = io.TeeReader(body, hash)
+
+	var archivePath string
+	if format == archiveZip {
+		tempZip := spoolPath("%s.zip", stagingID)
+		archivePath = tempZip
+		dst, err := os.Create(tempZip)
+		if err != nil {
+			fail("Could not create temp file", http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(tempZip)
+		_, err = io.Copy(dst, body)
+		dst.Close()
+		if tooLarge(err) {
+			writeUploadTooLarge(w, uploadPolicy(r, db).MaxBytes, progress)
+			return
+		}
+		if err != nil {
+			fail("Failed to save uploaded file", http.StatusInternalServerError)
+			return
+		}
+		if err := joinZipVolumes(tempZip, volumes); err != nil {
+			fail("Failed to join split archive: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, release, handled := assignDeploymentID(w, r, db, stagingID, requestedID, site, hex.EncodeToString(hash.Sum(nil)), filename, progress, started)
+		if handled {
+			return
+		}
+		defer release()
+		deploymentID = id
+		destDir = filepath.Join("deployments", deploymentID)
+		if err := unzip(tempZip, destDir, progress); err != nil {
+			immutable.RemoveAll(destDir)
+			fail(extractFailure(err, "Failed to extract archive"), http.StatusBadRequest)
+			return
+		}
+	} else {
+		// Tar bodies are never stored to extract them, so keep a copy as
+		// they stream past only when the archive is to be retained
+		if artifacts.Default != nil {
+			archivePath = spoolPath("%s.%s", stagingID, format)
+			dst, err := os.Create(archivePath)
+			if err != nil {
+				fail("Could not create temp file", http.StatusInternalServerError)
+				return
+			}
+			defer os.Remove(archivePath)
+			defer dst.Close()
+			body = io.TeeReader(body, dst)
+		}
+		if err := untar(body, destDir, format == archiveTarGz, progress); err != nil {
+			immutable.RemoveAll(destDir)
+			if tooLarge(err) {
+				writeUploadTooLarge(w, uploadPolicy(r, db).MaxBytes, progress)
+				return
+			}
+			fail(extractFailure(err, "Failed to extract archive"), http.StatusBadRequest)
+			return
+		}
+		// Drain trailing padding so the hash covers the whole body
+		if _, err := io.Copy(io.Discard, body); tooLarge(err) {
+			immutable.RemoveAll(destDir)
+			writeUploadTooLarge(w, uploadPolicy(r, db).MaxBytes, progress)
+			return
+		}
+		// The hash is only known once the stream has been extracted
+		id, release, handled := assignDeploymentID(w, r, db, stagingID, requestedID, site, hex.EncodeToString(hash.Sum(nil)), filename, progress, started)
+		if handled {
+			immutable.RemoveAll(destDir)
+			return
+		}
+		defer release()
+		if id != stagingID {
+			deploymentID = id
+			finalDir := filepath.Join("deployments", deploymentID)
+			if err := os.Rename(destDir, finalDir); err != nil {
+				immutable.RemoveAll(destDir)
+				fail("Failed to move extracted files", http.StatusInternalServerError)
+				return
+			}
+			destDir = finalDir
+		}
+	}
+
+	deployment := models.NewDeployment(deploymentID, filename, destDir)
+	deployment.Site = site
+	deployment.ArchiveSHA256 = hex.EncodeToString(hash.Sum(nil))
+	var archive *uploadArchive
+	if archivePath != "" {
+		archive = &uploadArchive{archivePath, format}
+	}
+	publishDeployment(w, r, db, deployment, archive, progress, started)
+}
+
+// siteSlugError explains why site can't name a site, or returns nil
+func siteSlugError(site string) *models.FieldError {
+	if models.ReservedSiteSlug(site) {
+		return &models.FieldError{Field: "site", Code: models.CodeReserved, Message: fmt.Sprintf("Site name %q is reserved", site)}
+	}
+	if !models.ValidSiteSlug(site) {
+		return &models.FieldError{Field: "site", Code: models.CodeInvalid, Message: "Invalid site name"}
+	}
+	return nil
+}
+
+// archiveFormat returns the archive format of a raw body from its
+// Content-Type
+func archiveFormat(r *http.Request) (string, *models.FieldError) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if format, ok := archiveFormats[mediaType]; ok {
+		return format, nil
+	}
+	return "", &models.FieldError{
+		Field:   "Content-Type",
+		Code:    models.CodeUnsupported,
+		Message: "Content-Type must be application/zip, application/x-tar or application/gzip",
+	}
+}
+
+// rawArchiveFilename names a raw upload after its Content-Disposition
+// filename, falling back to the site slug
+func rawArchiveFilename(r *http.Request, site, format string) string {
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil {
+		if name := filepath.Base(params["filename"]); name != "." && name != "/" && name != "" {
+			return name
+		}
+	}
+	return site + "." + format
+}
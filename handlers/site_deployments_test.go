@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"static-site-hosting/models"
+)
+
+func createTestTarGz(t *testing.T) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	files := map[string]string{
+		"index.html":    "<html><body>Tar Site</body></html>",
+		"css/style.css": "body { margin: 0; }",
+	}
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.WriteHeader(&tar.Header{Name: "link", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink})
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func putArchive(handler func(http.ResponseWriter, *http.Request), site, contentType string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/sites/"+site+"/deployments", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func TestSiteDeploymentsRawTarGz(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	handler := func(w http.ResponseWriter, r *http.Request) { SiteDeploymentsHandler(w, r, db) }
+	archive := createTestTarGz(t)
+
+	rr := putArchive(handler, "docs", "application/gzip", archive)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+	var d models.Deployment
+	json.NewDecoder(rr.Body).Decode(&d)
+	if d.Site != "docs" || d.Filename != "docs.tar.gz" || d.ArchiveSHA256 == "" {
+		t.Errorf("unexpected deployment %+v", d)
+	}
+
+	content, err := os.ReadFile(filepath.Join(d.Path, "css", "style.css"))
+	if err != nil || string(content) != "body { margin: 0; }" {
+		t.Errorf("expected nested file to be extracted, got %q (%v)", content, err)
+	}
+	if _, err := os.Lstat(filepath.Join(d.Path, "link")); err == nil {
+		t.Error("expected symlink to be skipped")
+	}
+
+	// Streamed uploads are checked for duplicates once extracted
+	rr = putArchive(handler, "docs", "application/gzip", archive)
+	var again models.Deployment
+	json.NewDecoder(rr.Body).Decode(&again)
+	if again.ID != d.ID {
+		t.Errorf("expected duplicate upload to return %s, got %s", d.ID, again.ID)
+	}
+}
+
+func TestSiteDeploymentsRawZip(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	handler := func(w http.ResponseWriter, r *http.Request) { SiteDeploymentsHandler(w, r, db) }
+	rr := putArchive(handler, "docs", "application/zip", testZipBytes(t))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+	var d models.Deployment
+	json.NewDecoder(rr.Body).Decode(&d)
+	if _, err := os.Stat(filepath.Join(d.Path, "index.html")); err != nil {
+		t.Errorf("expected index.html to be extracted: %v", err)
+	}
+}
+
+func TestSiteDeploymentsRejects(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	handler := func(w http.ResponseWriter, r *http.Request) { SiteDeploymentsHandler(w, r, db) }
+	if rr := putArchive(handler, "docs", "text/plain", []byte("hi")); rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 for unknown content type, got %d", rr.Code)
+	}
+	if rr := putArchive(handler, "Bad_Site", "application/zip", []byte("hi")); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid slug, got %d", rr.Code)
+	}
+	rr := putArchive(handler, "deployments", "application/zip", []byte("hi"))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "reserved") {
+		t.Errorf("expected 400 for a reserved slug, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
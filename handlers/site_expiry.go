@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"static-site-hosting/atrest"
+	"static-site-hosting/models"
+	"static-site-hosting/routecache"
+)
+
+// expiredPage is the page a site provides for when it has expired
+const expiredPage = "expired.html"
+
+// SiteExpiryHandler views, sets or lifts a site's expiry date. Once it
+// passes the site answers 410 Gone with its expired page, and after
+// SITE_EXPIRY_GRACE_DAYS its deployments are deleted.
+// Expected: GET|PUT|DELETE /sites/{slug}/expiry
+func SiteExpiryHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	site := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/sites/"), "/expiry")
+	if !models.ValidSiteSlug(site) {
+		http.Error(w, "Invalid site name", http.StatusBadRequest)
+		return
+	}
+	if !authorizeSiteAccess(w, r, db, site) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		expiresAt, err := loadSiteExpiry(db, site)
+		if err != nil {
+			http.Error(w, "Failed to fetch site expiry", http.StatusInternalServerError)
+			return
+		}
+		if expiresAt.IsZero() {
+			http.Error(w, "Site has no expiry date", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newSiteExpiry(site, expiresAt))
+
+	case http.MethodPut:
+		var req struct {
+			ExpiresAt string `json:"expires_at"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if req.ExpiresAt == "" {
+			writeFieldErrors(w, http.StatusBadRequest, models.FieldError{Field: "expires_at", Code: models.CodeMissing, Message: "expires_at required"})
+			return
+		}
+		expiresAt, e := parseExpiresAt(req.ExpiresAt)
+		if e != nil {
+			writeFieldErrors(w, http.StatusBadRequest, *e)
+			return
+		}
+		if _, err := latestSiteDeployment(db, site); err == sql.ErrNoRows {
+			http.Error(w, "Site not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to fetch site", http.StatusInternalServerError)
+			return
+		}
+		if err := saveSiteExpiry(db, site, expiresAt); err != nil {
+			http.Error(w, "Failed to save site expiry", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newSiteExpiry(site, expiresAt))
+
+	case http.MethodDelete:
+		result, err := db.Exec("DELETE FROM site_expiry WHERE site = ?", site)
+		if err != nil {
+			http.Error(w, "Failed to lift site expiry", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			http.Error(w, "Site has no expiry date", http.StatusNotFound)
+			return
+		}
+		routecache.Invalidate()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Site " + site + " no longer expires",
+		})
+
+	default:
+		http.Error(w, "GET, PUT or DELETE required", http.StatusMethodNotAllowed)
+	}
+}
+
+func newSiteExpiry(site string, expiresAt time.Time) models.SiteExpiry {
+	return models.SiteExpiry{
+		Site:      site,
+		ExpiresAt: expiresAt,
+		DeletesAt: expiresAt.Add(cfg.SiteExpiryGrace),
+		Expired:   !time.Now().Before(expiresAt),
+	}
+}
+
+// parseExpiresAt parses an RFC 3339 expiry date, which must be in the future
+func parseExpiresAt(v string) (time.Time, *models.FieldError) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, &models.FieldError{Field: "expires_at", Code: models.CodeInvalid, Message: "expires_at must be an RFC 3339 time"}
+	}
+	if !t.After(time.Now()) {
+		return time.Time{}, &models.FieldError{Field: "expires_at", Code: models.CodeInvalid, Message: "expires_at must be in the future"}
+	}
+	return t.UTC(), nil
+}
+
+// expiresAtParam returns the expiry date a deploy sets for its site, or
+// the zero time when it sets none
+func expiresAtParam(r *http.Request, site string) (time.Time, *models.FieldError) {
+	v := r.FormValue("expires_at")
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if site == "" {
+		return time.Time{}, &models.FieldError{Field: "expires_at", Code: models.CodeInvalid, Message: "expires_at requires a site"}
+	}
+	return parseExpiresAt(v)
+}
+
+// loadSiteExpiry returns when site expires, or the zero time if it doesn't
+func loadSiteExpiry(db *sql.DB, site string) (time.Time, error) {
+	var expiresAt time.Time
+	err := db.QueryRow("SELECT expires_at FROM site_expiry WHERE site = ?", site).Scan(&expiresAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return expiresAt, err
+}
+
+func saveSiteExpiry(db *sql.DB, site string, expiresAt time.Time) error {
+	_, err := db.Exec(
+		`INSERT INTO site_expiry (site, expires_at, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(site) DO UPDATE SET expires_at = excluded.expires_at, updated_at = excluded.updated_at`,
+		site, expiresAt, time.Now().UTC(),
+	)
+	routecache.Invalidate()
+	return err
+}
+
+// siteExpired reports whether site has expired, through the routing cache
+func siteExpired(db *sql.DB, site string) bool {
+	if site == "" {
+		return false
+	}
+	expiresAt, err := routecache.Lookup("expiry:"+site, func() (time.Time, error) {
+		return loadSiteExpiry(db, site)
+	})
+	return err == nil && !expiresAt.IsZero() && !time.Now().Before(expiresAt)
+}
+
+// serveExpiredPage answers 410 Gone with the deployment's own expired.html,
+// or else SITE_EXPIRED_PAGE, or else a plain-text notice
+func serveExpiredPage(w http.ResponseWriter, root string) {
+	w.Header().Set("Cache-Control", "no-store")
+	page, err := atrest.ReadFile(root, filepath.Join(root, expiredPage))
+	if err != nil && cfg.SiteExpiredPage != "" {
+		page, err = os.ReadFile(cfg.SiteExpiredPage)
+	}
+	if err != nil {
+		http.Error(w, "This site has expired", http.StatusGone)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusGone)
+	w.Write(page)
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"static-site-hosting/models"
+	"static-site-hosting/routecache"
+)
+
+func TestSiteExpiryHandler(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	db.Exec("INSERT INTO deployments (id, filename, path, site) VALUES ('event-1', 'a.zip', 'deployments/event-1', 'event')")
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		SiteExpiryHandler(rr, httptest.NewRequest(method, path, strings.NewReader(body)), db)
+		return rr
+	}
+
+	if rr := request(http.MethodGet, "/sites/event/expiry", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 before an expiry is set, got %d", rr.Code)
+	}
+	expiresAt := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	rr := request(http.MethodPut, "/sites/event/expiry", `{"expires_at":"`+expiresAt.Format(time.RFC3339)+`"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var expiry models.SiteExpiry
+	json.NewDecoder(rr.Body).Decode(&expiry)
+	if !expiry.ExpiresAt.Equal(expiresAt) || !expiry.DeletesAt.Equal(expiresAt.Add(cfg.SiteExpiryGrace)) || expiry.Expired {
+		t.Errorf("unexpected expiry %+v", expiry)
+	}
+
+	for body, code := range map[string]int{
+		`{}`:                                    http.StatusBadRequest,
+		`{"expires_at":"tomorrow"}`:             http.StatusBadRequest,
+		`{"expires_at":"2001-01-01T00:00:00Z"}`: http.StatusBadRequest,
+		`{"expires_at":"2999-01-01T00:00:00Z"}`: http.StatusOK,
+	} {
+		if rr := request(http.MethodPut, "/sites/event/expiry", body); rr.Code != code {
+			t.Errorf("%s: expected %d, got %d", body, code, rr.Code)
+		}
+	}
+	if rr := request(http.MethodPut, "/sites/nowhere/expiry", `{"expires_at":"2999-01-01T00:00:00Z"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a site without deployments, got %d", rr.Code)
+	}
+
+	if rr := request(http.MethodDelete, "/sites/event/expiry", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected status 200 lifting the expiry, got %d", rr.Code)
+	}
+	if rr := request(http.MethodDelete, "/sites/event/expiry", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 lifting it again, got %d", rr.Code)
+	}
+}
+
+func TestExpiredSiteServesExpiredPage(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	for _, id := range []string{"event-1", "plain-1"} {
+		dir := filepath.Join("deployments", id)
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>Event</h1>"), 0644)
+		db.Exec("INSERT INTO deployments (id, filename, path, site) VALUES (?, 'a.zip', ?, ?)", id, dir, strings.TrimSuffix(id, "-1"))
+	}
+	os.WriteFile(filepath.Join("deployments", "event-1", "expired.html"), []byte("<h1>Thanks for coming</h1>"), 0644)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		StaticFileHandler(db).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	saveSiteExpiry(db, "event", time.Now().Add(time.Hour))
+	if rr := get("/event-1/index.html"); rr.Code != http.StatusOK {
+		t.Errorf("expected the site to be served before it expires, got %d", rr.Code)
+	}
+
+	saveSiteExpiry(db, "event", time.Now().Add(-time.Minute))
+	saveSiteExpiry(db, "plain", time.Now().Add(-time.Minute))
+	rr := get("/event-1/index.html")
+	if rr.Code != http.StatusGone || rr.Body.String() != "<h1>Thanks for coming</h1>" {
+		t.Errorf("expected 410 with the site's expired page, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	page := filepath.Join(t.TempDir(), "expired.html")
+	os.WriteFile(page, []byte("<h1>Gone</h1>"), 0644)
+	cfg.SiteExpiredPage = page
+	if rr := get("/plain-1/index.html"); rr.Code != http.StatusGone || rr.Body.String() != "<h1>Gone</h1>" {
+		t.Errorf("expected 410 with SITE_EXPIRED_PAGE, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	cfg.SiteExpiredPage = ""
+	routecache.Invalidate()
+	if rr := get("/plain-1/index.html"); rr.Code != http.StatusGone || !strings.Contains(rr.Body.String(), "expired") {
+		t.Errorf("expected 410 with the built-in notice, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestDeploySetsSiteExpiry(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	deploy := func(expiresAt string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/sites/event/deployments?expires_at="+url.QueryEscape(expiresAt), bytes.NewReader(testZipBytes(t)))
+		req.Header.Set("Content-Type", "application/zip")
+		rr := httptest.NewRecorder()
+		SiteDeploymentsHandler(rr, req, db)
+		return rr
+	}
+	if rr := deploy("yesterday"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid expires_at, got %d", rr.Code)
+	}
+
+	expiresAt := time.Now().Add(72 * time.Hour).UTC().Truncate(time.Second)
+	if rr := deploy(expiresAt.Format(time.RFC3339)); rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got, _ := loadSiteExpiry(db, "event"); !got.Equal(expiresAt) {
+		t.Errorf("expected the deploy to set the site's expiry, got %v", got)
+	}
+}
@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"static-site-hosting/atrest"
+	"static-site-hosting/events"
+	"static-site-hosting/immutable"
+	"static-site-hosting/models"
+	"static-site-hosting/tombstone"
+	"static-site-hosting/usage"
+	"static-site-hosting/webhooks"
+
+	"github.com/google/uuid"
+)
+
+// siteExportVersion is bumped whenever the bundle layout changes
+const siteExportVersion = 1
+
+// defaultExportDeployments is how many deployments an export includes
+// unless ?deployments=N asks otherwise
+const defaultExportDeployments = 5
+
+// siteExportManifest is manifest.json at the root of a site bundle. Each
+// deployment's files follow it under deployments/{id}/.
+type siteExportManifest struct {
+	Version     int                `json:"version"`
+	Site        string             `json:"site"`
+	ExportedAt  time.Time          `json:"exported_at"`
+	Domains     []string           `json:"domains,omitempty"`
+	Deployments []exportDeployment `json:"deployments"`
+}
+
+type exportDeployment struct {
+	ID            string              `json:"id"`
+	Filename      string              `json:"filename"`
+	Timestamp     time.Time           `json:"timestamp"`
+	ArchiveSHA256 string              `json:"archive_sha256,omitempty"`
+	Settings      models.SiteSettings `json:"settings"`
+	Pinned        bool                `json:"pinned,omitempty"`
+	Visibility    string              `json:"visibility,omitempty"`
+}
+
+// SiteExportHandler streams a site as a tar.gz bundle of its settings,
+// domains and latest live deployments.
+// Expected: GET /sites/{slug}/export?deployments=N
+func SiteExportHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	site := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/sites/"), "/export")
+	if !models.ValidSiteSlug(site) {
+		http.Error(w, "Invalid site name", http.StatusBadRequest)
+		return
+	}
+	if !authorizeSiteAccess(w, r, db, site) {
+		return
+	}
+	limit := defaultExportDeployments
+	if v := r.URL.Query().Get("deployments"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "deployments must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	rows, err := db.Query(
+		`SELECT id, filename, timestamp, path, archive_sha256, visibility,
+			EXISTS (SELECT 1 FROM deployment_pins WHERE deployment_id = deployments.id)
+		FROM deployments WHERE site = ? AND status = 'ready' ORDER BY timestamp DESC LIMIT ?`,
+		site, limit,
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch deployments", http.StatusInternalServerError)
+		return
+	}
+	manifest := siteExportManifest{Version: siteExportVersion, Site: site, ExportedAt: time.Now().UTC()}
+	roots := map[string]string{}
+	for rows.Next() {
+		var d exportDeployment
+		var root string
+		if err := rows.Scan(&d.ID, &d.Filename, &d.Timestamp, &root, &d.ArchiveSHA256, &d.Visibility, &d.Pinned); err != nil {
+			rows.Close()
+			http.Error(w, "Failed to scan deployment", http.StatusInternalServerError)
+			return
+		}
+		manifest.Deployments = append(manifest.Deployments, d)
+		roots[d.ID] = root
+	}
+	rows.Close()
+	if len(manifest.Deployments) == 0 {
+		http.Error(w, "Site not found", http.StatusNotFound)
+		return
+	}
+	for i, d := range manifest.Deployments {
+		settings, err := loadSiteSettings(db, d.ID)
+		if err != nil {
+			http.Error(w, "Failed to fetch site settings", http.StatusInternalServerError)
+			return
+		}
+		manifest.Deployments[i].Settings = settings
+	}
+	manifest.Domains = siteCustomDomains(site)
+
+	// Headers are committed from here on, so a failure can only cut the
+	// stream short, which the importer detects as a corrupt archive
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", site+"-export.tar.gz"))
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	if err := writeSiteExport(tw, manifest, roots); err != nil {
+		log.Printf("Failed to export site %s: %v", site, err)
+		return
+	}
+	tw.Close()
+	gz.Close()
+}
+
+// siteCustomDomains lists the SITE_CUSTOM_DOMAINS hostnames serving site
+func siteCustomDomains(site string) []string {
+	var domains []string
+	for host, slug := range cfg.CustomDomains {
+		if slug == site {
+			domains = append(domains, strings.ToLower(host))
+		}
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+func writeSiteExport(tw *tar.Writer, manifest siteExportManifest, roots map[string]string) error {
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(raw)), ModTime: manifest.ExportedAt}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(raw); err != nil {
+		return err
+	}
+
+	for _, d := range manifest.Deployments {
+		root := roots[d.ID]
+		err := filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || !entry.Type().IsRegular() {
+				return err
+			}
+			rel, _ := filepath.Rel(root, p)
+			return addTarFile(tw, root, p, path.Join("deployments", d.ID, filepath.ToSlash(rel)))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addTarFile(tw *tar.Writer, root, src, name string) error {
+	f, err := atrest.Open(root, src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	// Bundles hold plaintext; the importing server encrypts with its own key
+	hdr.Name, hdr.Size = name, f.Size()
+	// Deployment trees are sealed read-only; restore write access for the
+	// owner so the bundle extracts cleanly anywhere
+	hdr.Mode |= 0200
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// SiteImportHandler recreates a site from a bundle made by SiteExportHandler.
+// Expected: POST /sites/import[?site=slug]
+//
+// Imported deployments get new IDs but keep their timestamps, so the newest
+// one is live afterwards. ?site= imports under a different slug. Domains
+// are not configured here; those missing from SITE_CUSTOM_DOMAINS are
+// listed in the response so they can be added.
+func SiteImportHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !limitUploadBody(w, r, db) {
+		writeUploadTooLarge(w, uploadPolicy(r, db).MaxBytes, nil)
+		return
+	}
+	verified, problem := verifyUploadSignature(r)
+	if problem != "" {
+		http.Error(w, problem, http.StatusUnauthorized)
+		return
+	}
+
+	started := time.Now()
+	staging := spoolPath("import-%s", uuid.New().String())
+	defer os.RemoveAll(staging)
+	if err := untar(r.Body, staging, true, nil); err != nil {
+		if tooLarge(err) {
+			writeUploadTooLarge(w, uploadPolicy(r, db).MaxBytes, nil)
+			return
+		}
+		http.Error(w, extractFailure(err, "Failed to extract bundle"), http.StatusBadRequest)
+		return
+	}
+	if err := verified(); tooLarge(err) {
+		writeUploadTooLarge(w, uploadPolicy(r, db).MaxBytes, nil)
+		return
+	} else if err != nil {
+		http.Error(w, errUploadSignature, http.StatusUnauthorized)
+		return
+	}
+
+	var manifest siteExportManifest
+	raw, err := os.ReadFile(filepath.Join(staging, "manifest.json"))
+	if err == nil {
+		err = json.Unmarshal(raw, &manifest)
+	}
+	if err != nil {
+		http.Error(w, "Bundle has no valid manifest.json", http.StatusBadRequest)
+		return
+	}
+	if manifest.Version != siteExportVersion {
+		http.Error(w, fmt.Sprintf("Unsupported bundle version %d", manifest.Version), http.StatusBadRequest)
+		return
+	}
+	site := manifest.Site
+	if s := r.URL.Query().Get("site"); s != "" {
+		site = s
+	}
+	if e := siteSlugError(site); e != nil {
+		writeFieldErrors(w, http.StatusBadRequest, *e)
+		return
+	}
+	// IDs name directories in the bundle, so only accept real ones
+	for _, d := range manifest.Deployments {
+		if _, err := uuid.Parse(d.ID); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid deployment ID %q in manifest", d.ID), http.StatusBadRequest)
+			return
+		}
+		if d.Visibility != "" && !models.ValidVisibility(d.Visibility) {
+			http.Error(w, fmt.Sprintf("Invalid visibility for deployment %s", d.ID), http.StatusBadRequest)
+			return
+		}
+		if err := d.Settings.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid settings for deployment %s: %v", d.ID, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tenant := requestTenant(r, usage.DefaultTenant)
+	previousLive := liveDeploymentID(db, site)
+	owner := deploymentOwner(db, r, site)
+	imported := make([]map[string]any, 0, len(manifest.Deployments))
+	for _, d := range manifest.Deployments {
+		deployment, err := importDeployment(db, staging, site, owner, d)
+		if err != nil {
+			log.Printf("Failed to import deployment %s of site %s: %v", d.ID, manifest.Site, err)
+			http.Error(w, fmt.Sprintf("Failed to import deployment %s", d.ID), http.StatusInternalServerError)
+			return
+		}
+		entry := usage.RecordDeployment(db, deployment.ID, tenant, deployment.Path, time.Since(started))
+		webhooks.Notify(db, webhooks.EventDeploymentCreated, deployment, deployment.Site)
+		events.Record(db, events.DeploymentCreated, deployment.ID, deployment.Site, map[string]any{"deployment": deployment, "usage": entry})
+		imported = append(imported, map[string]any{"source_id": d.ID, "deployment": withURLs(r, deployment)})
+	}
+	notifyPromotion(db, site, previousLive)
+
+	var unconfigured []string
+	for _, domain := range manifest.Domains {
+		if cfg.CustomDomains[domain] != site {
+			unconfigured = append(unconfigured, domain)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"site":                 site,
+		"deployments":          imported,
+		"unconfigured_domains": unconfigured,
+	})
+}
+
+// importDeployment moves one bundled deployment into place under a new ID
+// and records it with its settings and pin
+func importDeployment(db *sql.DB, staging, site, owner string, d exportDeployment) (*models.Deployment, error) {
+	newID := uuid.New().String()
+	dest := filepath.Join("deployments", newID)
+	src := filepath.Join(staging, "deployments", d.ID)
+	err := moveDir(src, dest)
+	if os.IsNotExist(err) {
+		// Tar has no entries for a deployment without files
+		err = os.MkdirAll(dest, 0755)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := immutable.Seal(dest); err != nil {
+		immutable.RemoveAll(dest)
+		return nil, err
+	}
+
+	deployment := models.NewDeployment(newID, d.Filename, dest)
+	deployment.Site = site
+	deployment.ArchiveSHA256 = d.ArchiveSHA256
+	deployment.OwnerID = owner
+	// Bundles from before visibilities were exported fall back to the default
+	deployment.Visibility = deploymentVisibility(db, "", d.Visibility)
+	if !d.Timestamp.IsZero() {
+		deployment.Timestamp = d.Timestamp
+	}
+	// Bundles carry no passwords; a protected site stays protected
+	inherited, err := inheritPassword(db, deployment)
+	if err != nil {
+		immutable.RemoveAll(dest)
+		return nil, err
+	}
+	_, err = db.Exec(
+		"INSERT INTO deployments (id, filename, timestamp, path, site, archive_sha256, status, owner_id, visibility) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		deployment.ID, deployment.Filename, deployment.Timestamp, deployment.Path, deployment.Site, deployment.ArchiveSHA256, deployment.Status, deployment.OwnerID, deployment.Visibility,
+	)
+	if err != nil {
+		if inherited {
+			db.Exec("DELETE FROM deployment_passwords WHERE deployment_id = ?", deployment.ID)
+		}
+		immutable.RemoveAll(dest)
+		return nil, err
+	}
+	tombstone.Remove(db, deployment.ID)
+
+	if err := saveSiteSettings(db, newID, d.Settings); err != nil {
+		log.Printf("Warning: Failed to import settings for deployment %s: %v", newID, err)
+	}
+	if d.Pinned {
+		db.Exec("INSERT OR IGNORE INTO deployment_pins (deployment_id, pinned_at) VALUES (?, ?)", newID, time.Now().UTC())
+	}
+	recordPreloadHints(db, newID, dest)
+	recordFileHashes(db, newID, dest)
+	return deployment, nil
+}
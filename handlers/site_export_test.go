@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"static-site-hosting/models"
+)
+
+func TestSiteExportImportRoundTrip(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.CustomDomains = map[string]string{"docs.example.com": "docs"}
+
+	deploy := func(w http.ResponseWriter, r *http.Request) { SiteDeploymentsHandler(w, r, db) }
+	var older, newer models.Deployment
+	json.NewDecoder(putArchive(deploy, "docs", "application/zip", testZipBytes(t)).Body).Decode(&older)
+	time.Sleep(10 * time.Millisecond)
+	json.NewDecoder(putArchive(deploy, "docs", "application/gzip", createTestTarGz(t)).Body).Decode(&newer)
+	if older.ID == "" || newer.ID == "" {
+		t.Fatal("failed to create deployments")
+	}
+	saveSiteSettings(db, newer.ID, models.SiteSettings{CaseInsensitivePaths: true})
+	db.Exec("INSERT INTO deployment_pins (deployment_id, pinned_at) VALUES (?, ?)", newer.ID, time.Now())
+
+	rr := httptest.NewRecorder()
+	SiteExportHandler(rr, httptest.NewRequest(http.MethodGet, "/sites/docs/export?deployments=1", nil), db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 exporting, got %d: %s", rr.Code, rr.Body.String())
+	}
+	bundle := rr.Body.Bytes()
+
+	cfg.CustomDomains = nil
+	rr = httptest.NewRecorder()
+	SiteImportHandler(rr, httptest.NewRequest(http.MethodPost, "/sites/import?site=docs-copy", bytes.NewReader(bundle)), db)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201 importing, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Site        string `json:"site"`
+		Deployments []struct {
+			SourceID   string            `json:"source_id"`
+			Deployment models.Deployment `json:"deployment"`
+		} `json:"deployments"`
+		UnconfiguredDomains []string `json:"unconfigured_domains"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Site != "docs-copy" || len(resp.Deployments) != 1 || resp.Deployments[0].SourceID != newer.ID {
+		t.Fatalf("expected only the latest deployment imported into docs-copy, got %+v", resp)
+	}
+	if len(resp.UnconfiguredDomains) != 1 || resp.UnconfiguredDomains[0] != "docs.example.com" {
+		t.Errorf("expected docs.example.com reported as unconfigured, got %v", resp.UnconfiguredDomains)
+	}
+
+	imported := resp.Deployments[0].Deployment
+	if imported.ID == newer.ID || !imported.Timestamp.Equal(newer.Timestamp) {
+		t.Errorf("expected a new ID with the original timestamp, got %+v", imported)
+	}
+	content, err := os.ReadFile(filepath.Join(imported.Path, "css", "style.css"))
+	if err != nil || string(content) != "body { margin: 0; }" {
+		t.Errorf("expected imported files, got %q (%v)", content, err)
+	}
+	if settings, _ := loadSiteSettings(db, imported.ID); !settings.CaseInsensitivePaths {
+		t.Error("expected settings to be imported")
+	}
+	var pinned int
+	db.QueryRow("SELECT COUNT(*) FROM deployment_pins WHERE deployment_id = ?", imported.ID).Scan(&pinned)
+	if pinned != 1 {
+		t.Error("expected the pin to be imported")
+	}
+	if latest, err := latestSiteDeployment(db, "docs-copy"); err != nil || latest.ID != imported.ID {
+		t.Errorf("expected the imported deployment to be live, got %v (%v)", latest, err)
+	}
+}
+
+func TestSiteImportRejectsBadBundles(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	rr := httptest.NewRecorder()
+	SiteImportHandler(rr, httptest.NewRequest(http.MethodPost, "/sites/import", bytes.NewReader(createTestTarGz(t))), db)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a bundle without a manifest, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	SiteExportHandler(rr, httptest.NewRequest(http.MethodGet, "/sites/nothing/export", nil), db)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 exporting an unknown site, got %d", rr.Code)
+	}
+}
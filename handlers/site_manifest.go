@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"static-site-hosting/integrity"
+	"static-site-hosting/models"
+)
+
+// ManifestAsset is one file of a site's live deployment
+type ManifestAsset struct {
+	URL    string `json:"url"` // path relative to the site root
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// SiteManifest lists the assets of a site's live deployment for precaching
+type SiteManifest struct {
+	Site         string             `json:"site"`
+	DeploymentID string             `json:"deployment_id"`
+	Deployment   *models.Deployment `json:"deployment"`
+	Assets       []ManifestAsset    `json:"assets"`
+}
+
+// SiteManifestHandler lists every asset of a site's live deployment with
+// its SHA-256, so service workers and edge caches can precache the site and
+// fetch only what changed. Assets the site's access rules block for the
+// caller are left out. The ETag is the deployment ID, so revalidating is
+// cheap until the next deployment goes live.
+// Expected: GET /sites/{slug}/manifest.json
+func SiteManifestHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	site := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/sites/"), "/manifest.json")
+	if !models.ValidSiteSlug(site) {
+		http.Error(w, "Invalid site name", http.StatusBadRequest)
+		return
+	}
+	if !authorizeSiteAccess(w, r, db, site) {
+		return
+	}
+	deployment, err := latestSiteDeployment(db, site)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Site not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch site", http.StatusInternalServerError)
+		return
+	}
+	if _, _, ok := authorizeDeploymentRead(w, r, db, deployment.ID); !ok {
+		return
+	}
+
+	etag := `"` + deployment.ID + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	files, err := integrity.Manifest(db, deployment.ID)
+	if err == nil && len(files) == 0 {
+		// Deployments published before manifests were recorded
+		if err = integrity.Record(db, deployment.ID, deployment.Path); err == nil {
+			files, err = integrity.Manifest(db, deployment.ID)
+		}
+	}
+	if err != nil {
+		http.Error(w, "Failed to list deployment files", http.StatusInternalServerError)
+		return
+	}
+	settings, err := loadSiteSettings(db, deployment.ID)
+	if err != nil {
+		http.Error(w, "Failed to load site settings", http.StatusInternalServerError)
+		return
+	}
+
+	manifest := SiteManifest{
+		Site:         site,
+		DeploymentID: deployment.ID,
+		Deployment:   withURLs(r, deployment),
+		Assets:       []ManifestAsset{},
+	}
+	for _, f := range files {
+		url := "/" + f.Path
+		// Private paths can't be precached by anonymous visitors either
+		if action := accessAction(settings.AccessRules, r, url); action == models.AccessBlock || action == models.AccessPrivate || secretFile(f.Path) {
+			continue
+		}
+		manifest.Assets = append(manifest.Assets, ManifestAsset{URL: url, Size: f.Size, SHA256: f.SHA256})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
+}
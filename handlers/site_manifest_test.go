@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"static-site-hosting/models"
+)
+
+func TestSiteManifestHandler(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	for i, id := range []string{"old", "new"} {
+		dir := filepath.Join("deployments", id)
+		os.MkdirAll(filepath.Join(dir, "private"), 0755)
+		os.WriteFile(filepath.Join(dir, "index.html"), []byte(id), 0644)
+		os.WriteFile(filepath.Join(dir, "private", "notes.txt"), []byte("secret"), 0644)
+		db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES (?, 'a.zip', ?, ?, 'docs')",
+			id, time.Now().Add(time.Duration(i)*time.Minute), dir)
+	}
+	saveSiteSettings(db, "new", models.SiteSettings{AccessRules: []models.AccessRule{{Path: "^/private/"}}})
+
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rr := httptest.NewRecorder()
+		SiteManifestHandler(rr, req, db)
+		return rr
+	}
+
+	rr := get("/sites/docs/manifest.json", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var manifest SiteManifest
+	json.NewDecoder(rr.Body).Decode(&manifest)
+	if manifest.DeploymentID != "new" {
+		t.Errorf("expected the live deployment, got %q", manifest.DeploymentID)
+	}
+	sum := sha256.Sum256([]byte("new"))
+	want := ManifestAsset{URL: "/index.html", Size: 3, SHA256: hex.EncodeToString(sum[:])}
+	if len(manifest.Assets) != 1 || manifest.Assets[0] != want {
+		t.Errorf("expected only %+v, got %+v", want, manifest.Assets)
+	}
+
+	if rr := get("/sites/docs/manifest.json", `"new"`); rr.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for the current ETag, got %d", rr.Code)
+	}
+	if rr := get("/sites/docs/manifest.json", `"old"`); rr.Code != http.StatusOK {
+		t.Errorf("expected 200 for a stale ETag, got %d", rr.Code)
+	}
+	if rr := get("/sites/missing/manifest.json", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown site, got %d", rr.Code)
+	}
+}
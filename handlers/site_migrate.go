@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"static-site-hosting/locks"
+	"static-site-hosting/migrate"
+	"static-site-hosting/models"
+
+	"github.com/google/uuid"
+)
+
+// reportMigration is the report kind listing what a migration translated
+const reportMigration = "migration"
+
+// SiteMigrateHandler deploys a site exported from Netlify or Vercel as a
+// raw zip or tar body, translating its redirects and headers into the new
+// deployment's settings. What couldn't be translated is listed in the
+// deployment's migration report.
+// Expected: POST /sites/{slug}/migrate
+func SiteMigrateHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	site := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/sites/"), "/migrate")
+	if e := siteSlugError(site); e != nil {
+		writeFieldErrors(w, http.StatusBadRequest, *e)
+		return
+	}
+	format, e := archiveFormat(r)
+	if e != nil {
+		writeFieldErrors(w, http.StatusUnsupportedMediaType, *e)
+		return
+	}
+
+	started := time.Now()
+	progress := startUpload(uploadID(r), r.ContentLength)
+	w.Header().Set("X-Upload-Id", progress.id)
+	fail := func(msg string, code int) {
+		progress.fail(msg)
+		http.Error(w, msg, code)
+	}
+	if !limitUploadBody(w, r, db) {
+		writeUploadTooLarge(w, uploadPolicy(r, db).MaxBytes, progress)
+		return
+	}
+	if _, problem := verifyUploadSignature(r); problem != "" {
+		fail(problem, http.StatusUnauthorized)
+		return
+	}
+
+	unlock, ok := lockMutation(w, "migrate", locks.Site(site))
+	if !ok {
+		progress.fail("Conflicting operation in progress")
+		return
+	}
+	defer unlock()
+
+	// The export is kept as the deployment's archive, and extracted to a
+	// staging directory since only part of it may be served
+	deploymentID := uuid.New().String()
+	archivePath := spoolPath("%s.%s", deploymentID, format)
+	dst, err := os.Create(archivePath)
+	if err != nil {
+		fail("Could not create temp file", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(archivePath)
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, hash), progress.body(r.Body))
+	dst.Close()
+	if tooLarge(err) {
+		writeUploadTooLarge(w, uploadPolicy(r, db).MaxBytes, progress)
+		return
+	}
+	if err != nil {
+		fail("Failed to save uploaded file", http.StatusInternalServerError)
+		return
+	}
+	if err := checkUploadSignature(r); err != nil {
+		fail(errUploadSignature, http.StatusUnauthorized)
+		return
+	}
+
+	staging := spoolPath("migrate-%s", deploymentID)
+	defer os.RemoveAll(staging)
+	if err := extractArchive(archivePath, format, staging, progress); err != nil {
+		fail(extractFailure(err, "Failed to extract archive"), http.StatusBadRequest)
+		return
+	}
+
+	result, err := migrate.Convert(staging)
+	if err == migrate.ErrUnknownExport {
+		fail("Archive is not a Netlify or Vercel export: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		fail("Failed to translate export: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	destDir := filepath.Join("deployments", deploymentID)
+	os.MkdirAll("deployments", 0755)
+	if err := moveDir(result.Root, destDir); err != nil {
+		fail("Failed to move extracted files", http.StatusInternalServerError)
+		return
+	}
+
+	// The rules are in place before the deployment is recorded, so it never
+	// serves without them
+	if err := saveSiteSettings(db, deploymentID, result.Settings); err != nil {
+		os.RemoveAll(destDir)
+		fail("Failed to save site settings", http.StatusInternalServerError)
+		return
+	}
+	if err := saveReport(db, deploymentID, reportMigration, result); err != nil {
+		log.Printf("Warning: Failed to save migration report of deployment %s: %v", deploymentID, err)
+	}
+
+	deployment := models.NewDeployment(deploymentID, rawArchiveFilename(r, site, format), destDir)
+	deployment.Site = site
+	deployment.ArchiveSHA256 = hex.EncodeToString(hash.Sum(nil))
+	w.Header().Set("X-Migrated-From", result.Source)
+	publishDeployment(w, r, db, deployment, &uploadArchive{archivePath, format}, progress, started)
+
+	if _, err := fetchDeployment(db, deploymentID); err == sql.ErrNoRows {
+		db.Exec("DELETE FROM site_settings WHERE site_id = ?", deploymentID)
+		db.Exec("DELETE FROM deployment_reports WHERE deployment_id = ?", deploymentID)
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"static-site-hosting/models"
+)
+
+func TestRedirectRules(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	dir := filepath.Join("deployments", "redirect-site")
+	os.MkdirAll(filepath.Join(dir, "app"), 0755)
+	recordDeployment(t, db, "redirect-site")
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("home"), 0644)
+	os.WriteFile(filepath.Join(dir, "moved.html"), []byte("still here"), 0644)
+	os.WriteFile(filepath.Join(dir, "app", "shell.html"), []byte("shell"), 0644)
+	saveSiteSettings(db, "redirect-site", models.SiteSettings{
+		Redirects: []models.RedirectRule{
+			{From: "^/moved\\.html$", To: "/index.html"},
+			{From: "^/forced\\.html$", To: "https://example.com/", Status: 302, Force: true},
+			{From: "^/blog/(.*)$", To: "/news/${1}", Status: 308},
+			{From: "^/app/.*$", To: "/app/shell.html", Status: 200},
+		},
+		Headers: []models.HeaderRule{
+			{Path: "^/.*$", Set: map[string]string{"X-Frame-Options": "DENY"}},
+			{Path: "^/app/", Set: map[string]string{"X-Frame-Options": "SAMEORIGIN"}},
+		},
+	})
+	os.WriteFile(filepath.Join(dir, "forced.html"), []byte("shadowed"), 0644)
+
+	handler := StaticFileHandler(db)
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		return rr
+	}
+
+	if rr := get("/redirect-site/moved.html"); rr.Code != http.StatusOK || rr.Body.String() != "still here" {
+		t.Errorf("expected an existing file to shadow its rule, got %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := get("/redirect-site/forced.html"); rr.Code != http.StatusFound || rr.Header().Get("Location") != "https://example.com/" {
+		t.Errorf("expected a forced redirect, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	rr := get("/redirect-site/blog/2024/hello?ref=feed")
+	if rr.Code != http.StatusPermanentRedirect || rr.Header().Get("Location") != "/redirect-site/news/2024/hello?ref=feed" {
+		t.Errorf("expected a redirect within the site keeping the query, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	rr = get("/redirect-site/app/settings/profile")
+	if rr.Code != http.StatusOK || rr.Body.String() != "shell" {
+		t.Errorf("expected the rewrite target to be served, got %d %q", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("X-Frame-Options"); got != "SAMEORIGIN" {
+		t.Errorf("expected the later header rule to win, got %q", got)
+	}
+	if got := get("/redirect-site/index.html").Header().Get("X-Frame-Options"); got != "DENY" {
+		t.Errorf("expected the header rule to apply, got %q", got)
+	}
+}
+
+func TestSiteMigrateNetlify(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"index.html": "home",
+		"_redirects": "/old /index.html 301\n/api/* https://api.example.com/:splat 200\n",
+		"_headers":   "/*\n  X-Frame-Options: DENY\n",
+	} {
+		f, _ := zw.Create(name)
+		f.Write([]byte(content))
+	}
+	zw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/sites/docs/migrate", &buf)
+	req.Header.Set("Content-Type", "application/zip")
+	rr := httptest.NewRecorder()
+	SiteMigrateHandler(rr, req, db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("X-Migrated-From"); got != "netlify" {
+		t.Errorf("expected X-Migrated-From netlify, got %q", got)
+	}
+	var d models.Deployment
+	json.NewDecoder(rr.Body).Decode(&d)
+	if d.Site != "docs" {
+		t.Errorf("expected a deployment of docs, got %+v", d)
+	}
+	if _, err := os.Stat(filepath.Join(d.Path, "_redirects")); !os.IsNotExist(err) {
+		t.Error("expected _redirects not to be served")
+	}
+
+	settings, _ := loadSiteSettings(db, d.ID)
+	if len(settings.Redirects) != 1 || len(settings.Headers) != 1 {
+		t.Errorf("expected the redirect and headers to be translated, got %+v", settings)
+	}
+
+	rr = httptest.NewRecorder()
+	DeploymentReportHandler(rr, httptest.NewRequest(http.MethodGet, "/deployments/"+d.ID+"/report", nil), db)
+	var report struct {
+		Reports map[string]struct {
+			Source  string   `json:"source"`
+			Skipped []string `json:"skipped"`
+		} `json:"reports"`
+	}
+	json.NewDecoder(rr.Body).Decode(&report)
+	if m := report.Reports[reportMigration]; m.Source != "netlify" || len(m.Skipped) != 1 {
+		t.Errorf("expected a migration report listing the proxy rule, got %+v", report.Reports)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/sites/docs/migrate", bytes.NewReader(testZipBytes(t)))
+	req.Header.Set("Content-Type", "application/zip")
+	rr = httptest.NewRecorder()
+	SiteMigrateHandler(rr, req, db)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an archive that isn't an export, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"static-site-hosting/models"
+	"static-site-hosting/routecache"
+)
+
+// SiteSettingsHandler reads or replaces a site's settings
+// Expected: GET|PUT /sites/{site-id}/settings
+func SiteSettingsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	path := strings.TrimPrefix(r.URL.Path, "/sites/")
+	siteID, rest, _ := strings.Cut(path, "/")
+	if siteID == "" || rest != "settings" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var ownerID string
+	err := db.QueryRow("SELECT owner_id FROM deployments WHERE id = ?", siteID).Scan(&ownerID)
+	if err == sql.ErrNoRows || err == nil && !ownsDeployment(r, ownerID) {
+		http.Error(w, "Site not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch site", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		settings, err := loadSiteSettings(db, siteID)
+		if err != nil {
+			http.Error(w, "Failed to fetch site settings", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+
+	case http.MethodPut:
+		var settings models.SiteSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			writeFieldErrors(w, http.StatusBadRequest, settingsJSONError(err))
+			return
+		}
+		if err := settings.Validate(); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+		if err := saveSiteSettings(db, siteID, settings); err != nil {
+			http.Error(w, "Failed to save site settings", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+
+	default:
+		http.Error(w, "GET or PUT required", http.StatusMethodNotAllowed)
+	}
+}
+
+// settingsJSONError describes a settings body that doesn't decode, naming
+// the setting when it holds a value of the wrong type
+func settingsJSONError(err error) models.FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return models.FieldError{Field: typeErr.Field, Code: models.CodeInvalid, Message: "Invalid settings JSON: wrong type for " + typeErr.Field}
+	}
+	return models.FieldError{Field: "body", Code: models.CodeInvalid, Message: "Invalid settings JSON"}
+}
+
+// loadSiteSettings returns the stored settings for siteID, or defaults if none
+func loadSiteSettings(db *sql.DB, siteID string) (models.SiteSettings, error) {
+	var settings models.SiteSettings
+	var raw string
+	err := db.QueryRow("SELECT settings FROM site_settings WHERE site_id = ?", siteID).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	err = json.Unmarshal([]byte(raw), &settings)
+	return settings, err
+}
+
+func saveSiteSettings(db *sql.DB, siteID string, settings models.SiteSettings) error {
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		`INSERT INTO site_settings (site_id, settings) VALUES (?, ?)
+		ON CONFLICT(site_id) DO UPDATE SET settings = excluded.settings, updated_at = CURRENT_TIMESTAMP`,
+		siteID, string(raw),
+	)
+	routecache.Invalidate()
+	return err
+}
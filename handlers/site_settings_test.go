@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"static-site-hosting/models"
+)
+
+func TestSiteSettingsHandler(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec(
+		"INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, ?, ?, ?)",
+		"site-1", "site.zip", time.Now(), "deployments/site-1",
+	)
+	if err != nil {
+		t.Fatalf("failed to insert test deployment: %v", err)
+	}
+
+	// Defaults are returned before anything is saved
+	req := httptest.NewRequest(http.MethodGet, "/sites/site-1/settings", nil)
+	rr := httptest.NewRecorder()
+	SiteSettingsHandler(rr, req, db)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+
+	var settings models.SiteSettings
+	json.NewDecoder(rr.Body).Decode(&settings)
+	if settings.CaseInsensitivePaths {
+		t.Error("expected case-insensitive paths to default to false")
+	}
+
+	// Update settings
+	req = httptest.NewRequest(http.MethodPut, "/sites/site-1/settings", strings.NewReader(`{"case_insensitive_paths":true}`))
+	rr = httptest.NewRecorder()
+	SiteSettingsHandler(rr, req, db)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+
+	stored, err := loadSiteSettings(db, "site-1")
+	if err != nil {
+		t.Fatalf("failed to load settings: %v", err)
+	}
+	if !stored.CaseInsensitivePaths {
+		t.Error("expected case-insensitive paths to be saved")
+	}
+}
+
+func TestSiteSettingsHandlerNotFound(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/sites/missing/settings", nil)
+	rr := httptest.NewRecorder()
+	SiteSettingsHandler(rr, req, db)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rr.Code)
+	}
+}
+
+// fieldErrorsResponse is the body of a request refused for its fields
+type fieldErrorsResponse struct {
+	Error  string              `json:"error"`
+	Errors []models.FieldError `json:"errors"`
+}
+
+func TestSiteSettingsHandlerFieldErrors(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	db.Exec(
+		"INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, ?, ?, ?)",
+		"site-1", "site.zip", time.Now(), "deployments/site-1",
+	)
+
+	put := func(body string) (int, fieldErrorsResponse) {
+		req := httptest.NewRequest(http.MethodPut, "/sites/site-1/settings", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		SiteSettingsHandler(rr, req, db)
+		var resp fieldErrorsResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return rr.Code, resp
+	}
+
+	// Every problem is reported, each naming its field
+	code, resp := put(`{"access_rules":[{"path":"(","action":"deny"}],"redirects":[{"from":"^/a$","status":418}]}`)
+	if code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", code)
+	}
+	want := []models.FieldError{
+		{Field: "access_rules[0].path", Code: models.CodeInvalid},
+		{Field: "access_rules[0].action", Code: models.CodeInvalid},
+		{Field: "redirects[0].to", Code: models.CodeMissing},
+		{Field: "redirects[0].status", Code: models.CodeUnsupported},
+	}
+	if len(resp.Errors) != len(want) {
+		t.Fatalf("expected %d errors, got %+v", len(want), resp.Errors)
+	}
+	for i, e := range resp.Errors {
+		if e.Field != want[i].Field || e.Code != want[i].Code || e.Message == "" {
+			t.Errorf("expected %s %s, got %+v", want[i].Field, want[i].Code, e)
+		}
+	}
+	if resp.Error == "" {
+		t.Error("expected the messages to be joined in error")
+	}
+
+	if code, resp := put(`{"case_insensitive_paths":"yes"}`); code != http.StatusBadRequest || len(resp.Errors) != 1 || resp.Errors[0].Field != "case_insensitive_paths" {
+		t.Errorf("expected the mistyped setting to be named, got %d %+v", code, resp.Errors)
+	}
+	if code, resp := put(`{"cache_key_query":["v",""]}`); code != http.StatusBadRequest || len(resp.Errors) != 1 || resp.Errors[0].Field != "cache_key_query[1]" {
+		t.Errorf("expected the empty cache key parameter to be named, got %d %+v", code, resp.Errors)
+	}
+	if code, resp := put(`{`); code != http.StatusBadRequest || len(resp.Errors) != 1 || resp.Errors[0].Field != "body" {
+		t.Errorf("expected malformed JSON to be reported on the body, got %d %+v", code, resp.Errors)
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"static-site-hosting/models"
+)
+
+// SitesHandler lists the caller's sites, or every site for admins, with its live and latest deployments,
+// deployment counts and storage, most recently deployed first, so
+// dashboards needn't group the full deployment list themselves. Like the
+// deployment list, it carries an ETag and honours If-None-Match.
+// Expected: GET /sites
+func SitesHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	where, args := ownerScope(r)
+	if where == "" {
+		where = " WHERE d.site != ''"
+	} else {
+		where += " AND d.site != ''"
+	}
+	if etag, err := listETag(db, r, where, args); err == nil && notModified(w, r, etag) {
+		return
+	}
+
+	// Storage comes from the usage ledger, which measured each deployment
+	// when it was published and records none for aliases sharing files
+	rows, err := db.Query(`
+		SELECT d.id, d.filename, d.timestamp, d.path, d.site, d.archive_sha256, d.status,
+			COALESCE((SELECT SUM(u.bytes) FROM deployment_usage u WHERE u.deployment_id = d.id AND u.deleted_at IS NULL), 0)
+		FROM deployments d`+where+`
+		ORDER BY d.timestamp DESC`, args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch deployments", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	// Rows come newest first, so each site's first row is its latest
+	// deployment, and its first ready row the one it serves
+	sites := []*models.SiteSummary{}
+	bySite := map[string]*models.SiteSummary{}
+	for rows.Next() {
+		var d models.Deployment
+		var size int64
+		if err := rows.Scan(&d.ID, &d.Filename, &d.Timestamp, &d.Path, &d.Site, &d.ArchiveSHA256, &d.Status, &size); err != nil {
+			http.Error(w, "Failed to scan deployment", http.StatusInternalServerError)
+			return
+		}
+		s := bySite[d.Site]
+		if s == nil {
+			s = &models.SiteSummary{Site: d.Site}
+			bySite[d.Site] = s
+			sites = append(sites, s)
+		}
+		if d.Status == models.StatusReady {
+			withURLs(r, &d)
+		}
+		if s.LatestDeployment == nil {
+			latest := d
+			s.LatestDeployment = &latest
+		}
+		if s.LiveDeployment == nil && d.Status == models.StatusReady {
+			live := d
+			s.LiveDeployment = &live
+		}
+		if d.Status == models.StatusFailed {
+			s.FailedCount++
+		}
+		s.DeploymentCount++
+		s.TotalBytes += size
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Failed to fetch deployments", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sites)
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SmokeResult is the answer to one smoke test request
+type SmokeResult struct {
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+	Bytes  int    `json:"bytes"`
+}
+
+// SmokeReport is the outcome of requesting every configured smoke test path
+type SmokeReport struct {
+	Passed  bool          `json:"passed"`
+	Results []SmokeResult `json:"results"`
+	Hint    string        `json:"hint,omitempty"`
+}
+
+// smokeTestKey carries the smokeTarget of an internal smoke test request
+type smokeTestKey struct{}
+
+// smokeTarget is the deployment a smoke test checks and where its files are
+type smokeTarget struct {
+	id, dir string
+}
+
+// isSmokeTest reports whether r is an internal smoke test request, which
+// is not metered
+func isSmokeTest(r *http.Request) bool {
+	_, ok := r.Context().Value(smokeTestKey{}).(smokeTarget)
+	return ok
+}
+
+// smokeTestRoot returns the files of deploymentID when r is its smoke test.
+// Nothing else serves a deployment before it is recorded.
+func smokeTestRoot(r *http.Request, deploymentID string) (string, bool) {
+	target, ok := r.Context().Value(smokeTestKey{}).(smokeTarget)
+	return target.dir, ok && target.id == deploymentID
+}
+
+// smokeTest requests each configured path from a deployment through the
+// static file handler, exactly as a visitor would. It runs before the
+// deployment is recorded, so the requests carry where its files are.
+func smokeTest(db *sql.DB, deploymentID, dir string) *SmokeReport {
+	handler := StaticFileHandler(db)
+	ctx := context.WithValue(context.Background(), smokeTestKey{}, smokeTarget{id: deploymentID, dir: dir})
+
+	report := &SmokeReport{Passed: true, Results: []SmokeResult{}}
+	for _, p := range cfg.SmokeTestPaths {
+		p = "/" + strings.TrimPrefix(p, "/")
+		req := httptest.NewRequest(http.MethodGet, "/"+deploymentID+p, nil).WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		report.Results = append(report.Results, SmokeResult{Path: p, Status: rr.Code, Bytes: rr.Body.Len()})
+		if rr.Code != http.StatusOK {
+			report.Passed = false
+		}
+	}
+	if !report.Passed {
+		report.Hint = nestingHint(dir)
+	}
+	return report
+}
+
+// nestingHint explains the most common broken archive: everything zipped
+// inside one extra top-level directory
+func nestingHint(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		return ""
+	}
+	nested := entries[0].Name()
+	for _, p := range cfg.SmokeTestPaths {
+		if _, err := os.Stat(filepath.Join(dir, nested, filepath.FromSlash(strings.TrimPrefix(p, "/")))); err == nil {
+			return fmt.Sprintf("the archive's files are nested under %q; archive the contents of that directory instead of the directory itself", nested+"/")
+		}
+	}
+	return ""
+}
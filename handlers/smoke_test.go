@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"static-site-hosting/models"
+)
+
+func uploadZip(t *testing.T, upload http.Handler, files map[string]string) *httptest.ResponseRecorder {
+	archive := new(bytes.Buffer)
+	zw := zip.NewWriter(archive)
+	for name, content := range files {
+		f, _ := zw.Create(name)
+		f.Write([]byte(content))
+	}
+	zw.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "site.zip")
+	part.Write(archive.Bytes())
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	upload.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestSmokeTestCatchesNestedArchive(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	cfg.SmokeTest = true
+	defer func() { cfg.SmokeTest = false }()
+	upload := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { UploadHandler(w, r, db) })
+
+	rr := uploadZip(t, upload, map[string]string{"index.html": "<h1>ok</h1>"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected a well-formed archive to pass, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = uploadZip(t, upload, map[string]string{"dist/index.html": "<h1>ok</h1>"})
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Deployment models.Deployment `json:"deployment"`
+		SmokeTest  SmokeReport       `json:"smoke_test"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Deployment.Status != models.StatusFailed || resp.SmokeTest.Passed {
+		t.Fatalf("expected a failed deployment, got %+v", resp)
+	}
+	if len(resp.SmokeTest.Results) != 1 || resp.SmokeTest.Results[0].Status != http.StatusNotFound {
+		t.Errorf("expected /index.html to answer 404, got %+v", resp.SmokeTest.Results)
+	}
+	if !strings.Contains(resp.SmokeTest.Hint, `"dist/"`) {
+		t.Errorf("expected a hint about the dist/ directory, got %q", resp.SmokeTest.Hint)
+	}
+}
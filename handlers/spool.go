@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/google/uuid"
+)
+
+// spoolPath names a temporary file or directory in the spool directory.
+// Every name starts with "temp-", which is what PrepareSpool cleans up.
+func spoolPath(format string, args ...any) string {
+	return filepath.Join(cfg.SpoolDir, fmt.Sprintf("temp-"+format, args...))
+}
+
+// PrepareSpool creates the spool directory and removes what uploads cut
+// short by a restart left in it. Chunks of chunked uploads are kept until
+// they expire, since clients can still complete them. A spool directory on
+// another filesystem than deployments works, but staged sites are then
+// copied into place rather than renamed, which is logged.
+func PrepareSpool() error {
+	if err := os.MkdirAll(cfg.SpoolDir, 0755); err != nil {
+		return err
+	}
+
+	stale, err := filepath.Glob(spoolPath("*"))
+	if err != nil {
+		return err
+	}
+	removed := 0
+	for _, p := range stale {
+		if strings.HasPrefix(p, chunkDir("")) {
+			continue
+		}
+		if err := os.RemoveAll(p); err != nil {
+			log.Printf("Warning: Failed to remove stale spool file %s: %v", p, err)
+			continue
+		}
+		removed++
+	}
+	pruneChunkUploads()
+	if removed > 0 {
+		log.Printf("Removed %d stale spool files from %s", removed, cfg.SpoolDir)
+	}
+
+	probe := spoolPath("probe-%s", uuid.New().String())
+	if err := os.Mkdir(probe, 0755); err != nil {
+		return err
+	}
+	defer os.Remove(probe)
+	moved := filepath.Join("deployments", "."+filepath.Base(probe))
+	if err := os.Rename(probe, moved); errors.Is(err, syscall.EXDEV) {
+		log.Printf("Warning: SPOOL_DIR %s is not on the same filesystem as deployments; staged sites will be copied into place", cfg.SpoolDir)
+	} else if err == nil {
+		os.Remove(moved)
+	}
+	return nil
+}
+
+// moveDir moves a directory staged in the spool into place, copying it
+// when the spool is on another filesystem
+func moveDir(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyDir(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
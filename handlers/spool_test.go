@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPrepareSpool(t *testing.T) {
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.SpoolDir = filepath.Join(t.TempDir(), "spool")
+	os.MkdirAll("deployments", 0755)
+	defer os.RemoveAll("deployments")
+
+	os.MkdirAll(cfg.SpoolDir, 0755)
+	os.WriteFile(spoolPath("interrupted.zip"), []byte("partial"), 0644)
+	os.MkdirAll(spoolPath("import-interrupted"), 0755)
+	os.MkdirAll(chunkDir("recent"), 0755)
+	os.MkdirAll(chunkDir("abandoned"), 0755)
+	old := time.Now().Add(-2 * chunkUploadTTL)
+	os.Chtimes(chunkDir("abandoned"), old, old)
+	os.WriteFile(filepath.Join(cfg.SpoolDir, "unrelated.txt"), nil, 0644)
+
+	if err := PrepareSpool(); err != nil {
+		t.Fatalf("PrepareSpool: %v", err)
+	}
+	for _, p := range []string{spoolPath("interrupted.zip"), spoolPath("import-interrupted"), chunkDir("abandoned")} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", p)
+		}
+	}
+	for _, p := range []string{chunkDir("recent"), filepath.Join(cfg.SpoolDir, "unrelated.txt")} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("expected %s to be kept", p)
+		}
+	}
+	if entries, _ := os.ReadDir("deployments"); len(entries) != 0 {
+		t.Errorf("expected the filesystem probe to be cleaned up, found %d entries", len(entries))
+	}
+}
+
+func TestUploadUsesSpoolDir(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		SiteDeploymentsHandler(w, r, db)
+	}
+
+	// A spool directory that can't be written to fails the upload
+	notDir := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(notDir, nil, 0644)
+	cfg.SpoolDir = notDir
+	if rr := putArchive(handler, "docs", "application/zip", testZipBytes(t)); rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 spooling under a file, got %d", rr.Code)
+	}
+
+	cfg.SpoolDir = t.TempDir()
+	if rr := putArchive(handler, "docs", "application/zip", testZipBytes(t)); rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if entries, _ := os.ReadDir(cfg.SpoolDir); len(entries) != 0 {
+		t.Errorf("expected the spooled archive to be removed, found %d entries", len(entries))
+	}
+}
@@ -1,14 +1,23 @@
 package handlers
 
 import (
+	"database/sql"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+
+	"static-site-hosting/atrest"
+	"static-site-hosting/auth"
+	"static-site-hosting/features"
+	"static-site-hosting/models"
+	"static-site-hosting/routecache"
+	"static-site-hosting/tombstone"
 )
 
-func StaticFileHandler() http.Handler {
+func StaticFileHandler(db *sql.DB) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Println("Requested path:", r.URL.Path)
 
@@ -16,33 +25,111 @@ func StaticFileHandler() http.Handler {
 		path := strings.TrimPrefix(r.URL.Path, "/")
 		parts := strings.SplitN(path, "/", 2)
 
-		if len(parts) < 2 || parts[1] == "" {
+		if len(parts) < 2 {
 			http.NotFound(w, r)
 			return
 		}
 
 		siteID := parts[0]
 		filePath := parts[1]
+		// Directory URLs, such as the ones deployments advertise, serve
+		// their index.html as on a site's own host name
+		if filePath == "" || strings.HasSuffix(filePath, "/") {
+			filePath += "index.html"
+		}
+
+		settings, err := cachedSiteSettings(db, siteID)
+		if err != nil && routecache.Available() {
+			log.Printf("Warning: Failed to load settings for site %s: %v", siteID, err)
+		}
 
 		// Construct and clean the full path
-		fullPath := filepath.Join("deployments", siteID, filePath)
+		var root, site, owner string
+		var private bool
+		if dir, ok := smokeTestRoot(r, siteID); ok {
+			root = dir
+		} else {
+			root, site, ok = deploymentRoot(db, siteID)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			if owner, private, ok = authorizeDeploymentRead(w, r, db, siteID); !ok {
+				return
+			}
+		}
+		if siteExpired(db, site) {
+			serveExpiredPage(w, root)
+			return
+		}
+		robotsTag, robotsTxt := robotsPolicy(r, settings)
+		if filePath == "robots.txt" && robotsTxt != "" {
+			serveRobotsTxt(w, r, robotsTxt)
+			return
+		}
+		fullPath := filepath.Join(root, filePath)
 
-		// Security check: ensure we're not going outside deployments directory
-		absDeployments, _ := filepath.Abs("deployments")
-		absFullPath, _ := filepath.Abs(fullPath)
-		if !strings.HasPrefix(absFullPath, absDeployments) {
+		// Security check: ensure we're not going outside the deployment
+		if !withinRoot(root, fullPath) {
 			http.NotFound(w, r)
 			return
 		}
 
 		// Check if file exists and is not a directory
 		info, err := os.Stat(fullPath)
+		if os.IsNotExist(err) && settings.CaseInsensitivePaths {
+			if resolved, ok := resolveCaseInsensitive(root, filePath); ok {
+				fullPath = resolved
+				info, err = os.Stat(fullPath)
+			}
+		}
+		if rule, target, ok := matchRedirect(settings.Redirects, "/"+filePath, err == nil && !info.IsDir()); ok {
+			if rule.Status != http.StatusOK {
+				http.Redirect(w, r, redirectTarget(r, siteID, target), rule.Status)
+				return
+			}
+			fullPath = filepath.Join(root, rewritePath(target))
+			if !withinRoot(root, fullPath) {
+				http.NotFound(w, r)
+				return
+			}
+			info, err = os.Stat(fullPath)
+		}
+		if os.IsNotExist(err) && spaFallback(settings) && filepath.Ext(filePath) == "" {
+			fullPath = filepath.Join(root, "index.html")
+			info, err = os.Stat(fullPath)
+		}
+		// Rules see the requested path and, so that neither case folding nor
+		// dot segments get around them, the file it resolved to
+		if len(settings.AccessRules) > 0 {
+			resolved, _ := filepath.Rel(root, fullPath)
+			requested := accessAction(settings.AccessRules, r, "/"+filePath)
+			served := accessAction(settings.AccessRules, r, "/"+filepath.ToSlash(resolved))
+			if requested == models.AccessBlock || served == models.AccessBlock {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			// Private paths of a public site are private as whole deployments are
+			if requested == models.AccessPrivate || served == models.AccessPrivate {
+				if !private && !privateAuthorized(r, owner) && !shareAuthorized(w, r, siteID) {
+					denyPrivate(w, r)
+					return
+				}
+				w.Header().Set("Cache-Control", "private")
+			}
+		}
+		// Secret files extracted before they were left out are not served
+		if served, _ := filepath.Rel(root, fullPath); secretFile(filePath) || secretFile(filepath.ToSlash(served)) {
+			http.NotFound(w, r)
+			return
+		}
 		if err != nil {
 			if os.IsNotExist(err) {
 				http.NotFound(w, r)
 				return
 			}
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			log.Printf("Failed to serve %s: %v", fullPath, err)
+			serveErrorPage(w, root, http.StatusInternalServerError)
 			return
 		}
 
@@ -51,15 +138,227 @@ func StaticFileHandler() http.Handler {
 			return
 		}
 
+		if settings.PreloadHeaders && isHTMLFile(fullPath) {
+			page, _ := filepath.Rel(root, fullPath)
+			writePreloadHeaders(w, r, db, siteID, filepath.ToSlash(page), settings.EarlyHints)
+		}
+
 		// Instead of ServeFile, read and serve manually to avoid 301 redirects
-		file, err := os.Open(fullPath)
-		if err != nil {
+		servedPath := fullPath
+		if flags.Enabled(features.Brotli) && acceptsEncoding(r.Header.Get("Accept-Encoding"), "br") {
+			if br, err := os.Stat(fullPath + ".br"); err == nil && !br.IsDir() {
+				servedPath, info = fullPath+".br", br
+				w.Header().Set("Content-Encoding", "br")
+			}
+		}
+		if flags.Enabled(features.Brotli) {
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+		content, err := openStaticFile(root, servedPath, info, cacheKeyQuery(settings, r.URL.Query()))
+		if os.IsNotExist(err) {
 			http.NotFound(w, r)
 			return
 		}
-		defer file.Close()
+		if err != nil {
+			log.Printf("Failed to serve %s: %v", servedPath, err)
+			serveErrorPage(w, root, http.StatusInternalServerError)
+			return
+		}
+		defer content.Close()
+		if resolved, err := filepath.Rel(root, fullPath); err == nil {
+			recordFileHit(siteID, filepath.ToSlash(resolved))
+		}
+
+		applyContentTypeRules(w, settings, "/"+filePath, filepath.Base(fullPath), content)
+		applyHeaderRules(w, settings.Headers, "/"+filePath)
+		if robotsTag != "" {
+			w.Header().Set("X-Robots-Tag", robotsTag)
+		}
+
+		// Ranges let players seek and downloads resume; HTML may do without
+		rw := &rangeWriter{ResponseWriter: w, noRanges: rangesDisabled(fullPath)}
+		rangeRequest := r.Header.Get("Range") != ""
+		if rangeRequest && rw.noRanges {
+			rangeStats.ignored.Add(1)
+			r = withoutRanges(r)
+		}
 
 		// Set appropriate content type
-		http.ServeContent(w, r, filepath.Base(fullPath), info.ModTime(), file)
+		cw := &countingWriter{ResponseWriter: rw}
+		http.ServeContent(cw, r, filepath.Base(fullPath), info.ModTime(), content)
+		if !isSmokeTest(r) {
+			meter.AddBandwidth(siteID, cw.n)
+		}
+		if rangeRequest {
+			observeRange(rw.status, cw.n)
+		}
 	})
 }
+
+// authorizeDeploymentRead makes the checks every route reading a
+// deployment's files makes first: a private deployment is only for those
+// who could see it, and a protected one for those who know its password.
+// It answers the request and returns ok false when the
+// caller may not read it, and otherwise returns who owns the deployment and
+// whether it is private.
+func authorizeDeploymentRead(w http.ResponseWriter, r *http.Request, db *sql.DB, deploymentID string) (owner string, private, ok bool) {
+	owner, private = deploymentPrivate(db, deploymentID)
+	if private && !privateAuthorized(r, owner) && !shareAuthorized(w, r, deploymentID) {
+		denyPrivate(w, r)
+		return "", false, false
+	} else if private {
+		w.Header().Set("Cache-Control", "private")
+	}
+	if creds, protected := deploymentProtected(db, deploymentID); protected && !passwordAuthorized(r, creds) && !shareAuthorized(w, r, deploymentID) {
+		challengePassword(w)
+		return "", false, false
+	}
+	return owner, private, true
+}
+
+// withinRoot reports whether full names root or something below it. Other
+// deployments' directories share root's prefix but not its path segments.
+func withinRoot(root, full string) bool {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	absFull, err := filepath.Abs(full)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absRoot, absFull)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// denyPrivate answers a request for something private the caller may not
+// see. Signed-in callers can't tell it from something missing.
+func denyPrivate(w http.ResponseWriter, r *http.Request) {
+	if auth.FromContext(r.Context()) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Error(w, "Authentication required", http.StatusUnauthorized)
+}
+
+// errorPage is the page a site provides for server errors
+const errorPage = "50x.html"
+
+// serveErrorPage answers with status and the deployment's own 50x.html, or
+// a plain-text error if it has none or that can't be read either. Errors
+// aren't cacheable: the next attempt may well succeed.
+func serveErrorPage(w http.ResponseWriter, root string, status int) {
+	w.Header().Set("Cache-Control", "no-store")
+	page, err := atrest.ReadFile(root, filepath.Join(root, errorPage))
+	if err != nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	// Headers meant for the file that failed don't apply to the page
+	w.Header().Del("Content-Encoding")
+	w.Header().Del("Link")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(page)
+}
+
+// spaFallback reports whether missing pages fall back to index.html, per the
+// site's settings or else the spa_fallback feature flag
+func spaFallback(settings models.SiteSettings) bool {
+	if settings.SPAFallback != nil {
+		return *settings.SPAFallback
+	}
+	return flags.Enabled(features.SPAFallback)
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows coding
+func acceptsEncoding(header, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) {
+			continue
+		}
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, err := strconv.ParseFloat(v, 64)
+			return err != nil || q > 0
+		}
+		return true
+	}
+	return false
+}
+
+// deploymentRoot returns the directory serving siteID and the site it was
+// deployed to. Aliased deployments share another deployment's files, so
+// the recorded path wins over the ID. Deployments that failed validation,
+// or have been deleted, are not served, nor are any without a record or
+// whose record can't be read: uploads are extracted under their ID before
+// they are checked and recorded.
+func deploymentRoot(db *sql.DB, siteID string) (string, string, bool) {
+	if tombstone.Frozen(siteID) {
+		return "", "", false
+	}
+	// Exported so the entry can be shared with other nodes
+	type root struct {
+		Path, Site, Status string
+		Deleted            bool
+	}
+	found, err := routecache.Lookup("root:"+siteID, func() (root, error) {
+		var r root
+		err := db.QueryRow("SELECT path, site, status FROM deployments WHERE id = ?", siteID).Scan(&r.Path, &r.Site, &r.Status)
+		if err == sql.ErrNoRows {
+			if deleted, derr := tombstone.Deleted(db, siteID); derr == nil && deleted {
+				return root{Deleted: true}, nil
+			}
+		}
+		return r, err
+	})
+	if found.Deleted || err != nil || found.Path == "" {
+		return "", "", false
+	}
+	return found.Path, found.Site, found.Status == models.StatusReady
+}
+
+// cachedSiteSettings is loadSiteSettings through the routing cache
+func cachedSiteSettings(db *sql.DB, siteID string) (models.SiteSettings, error) {
+	return routecache.Lookup("settings:"+siteID, func() (models.SiteSettings, error) {
+		return loadSiteSettings(db, siteID)
+	})
+}
+
+// resolveCaseInsensitive walks rel below root one segment at a time, matching
+// each segment case-insensitively. Exact matches win over folded ones.
+func resolveCaseInsensitive(root, rel string) (string, bool) {
+	current := root
+	for _, segment := range strings.Split(filepath.ToSlash(filepath.Clean(rel)), "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", false
+		}
+
+		entries, err := os.ReadDir(current)
+		if err != nil {
+			return "", false
+		}
+
+		match := ""
+		for _, e := range entries {
+			if e.Name() == segment {
+				match = e.Name()
+				break
+			}
+			if match == "" && strings.EqualFold(e.Name(), segment) {
+				match = e.Name()
+			}
+		}
+		if match == "" {
+			return "", false
+		}
+		current = filepath.Join(current, match)
+	}
+	return current, true
+}
+
+func isHTMLFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".html" || ext == ".htm"
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+
+	"static-site-hosting/atrest"
+	"static-site-hosting/coalesce"
+	"static-site-hosting/models"
+)
+
+// staticReads coalesces concurrent reads of the same static file, so the
+// burst of requests for a newly promoted deployment's pages reads each
+// file from disk once rather than once per request
+var staticReads coalesce.Group[[]byte]
+
+// staticContent is a static file ready to be served
+type staticContent interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// sharedContent serves a read shared with other requests
+type sharedContent struct {
+	*bytes.Reader
+}
+
+func (sharedContent) Close() error { return nil }
+
+// openStaticFile opens path, within root and described by info, for serving. Prewarmed
+// files are served from memory. Files up to STATIC_COALESCE_MAX_BYTES are
+// read whole, in a single read shared by every request for them in the
+// meantime; larger ones are streamed. query is the part of the request's
+// query string its site keys caches by, as cacheKeyQuery returns it.
+func openStaticFile(root, path string, info os.FileInfo, query string) (staticContent, error) {
+	key := staticFileKey(path, info)
+	if query != "" {
+		key += "?" + query
+	}
+	if data, ok := prewarmedFile(key); ok {
+		return sharedContent{bytes.NewReader(data)}, nil
+	}
+	if cfg.StaticCoalesceMaxBytes <= 0 || info.Size() > cfg.StaticCoalesceMaxBytes {
+		file, err := atrest.Open(root, path)
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+	data, err, _ := staticReads.Do(key, func() ([]byte, error) {
+		return atrest.ReadFile(root, path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sharedContent{bytes.NewReader(data)}, nil
+}
+
+// staticFileKey identifies the contents of path, described by info.
+// Deployments are immutable, but a file replaced by a revision has a new
+// size or modification time and must not share an older read.
+func staticFileKey(path string, info os.FileInfo) string {
+	return path + "|" + strconv.FormatInt(info.Size(), 10) + "|" + strconv.FormatInt(info.ModTime().UnixNano(), 10)
+}
+
+// cacheKeyQuery is the part of query that settings.CacheKeyQuery names,
+// encoded in a fixed order so the same parameters always make the same key.
+// It is empty when the site names none, and tracking parameters never
+// count.
+func cacheKeyQuery(settings models.SiteSettings, query url.Values) string {
+	if len(settings.CacheKeyQuery) == 0 || len(query) == 0 {
+		return ""
+	}
+	kept := url.Values{}
+	for _, name := range settings.CacheKeyQuery {
+		if values, ok := query[name]; ok {
+			kept[name] = values
+		}
+	}
+	// Encode sorts by name
+	return kept.Encode()
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStaticFileHandlerCoalescedReads(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.StaticCoalesceMaxBytes = 1024
+
+	dir := filepath.Join("deployments", "herd")
+	os.MkdirAll(dir, 0755)
+	recordDeployment(t, db, "herd")
+	small := bytes.Repeat([]byte("s"), 512)
+	large := bytes.Repeat([]byte("l"), 4096)
+	os.WriteFile(filepath.Join(dir, "index.html"), small, 0644)
+	os.WriteFile(filepath.Join(dir, "video.bin"), large, 0644)
+
+	handler := StaticFileHandler(db)
+	get := func(path, rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// A herd of requests for the same files all get them whole
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path, want := "/herd/index.html", small
+			if i%2 == 1 {
+				path, want = "/herd/video.bin", large
+			}
+			if rr := get(path, ""); rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), want) {
+				t.Errorf("%s: got %d with %d bytes", path, rr.Code, rr.Body.Len())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if rr := get("/herd/index.html", "bytes=0-9"); rr.Code != http.StatusPartialContent || rr.Body.Len() != 10 {
+		t.Errorf("expected ranges of coalesced reads to work, got %d with %d bytes", rr.Code, rr.Body.Len())
+	}
+
+	// A file replaced since doesn't share the earlier read
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("new"), 0644)
+	os.Chtimes(filepath.Join(dir, "index.html"), time.Now(), time.Now().Add(time.Minute))
+	if rr := get("/herd/index.html", ""); rr.Body.String() != "new" {
+		t.Errorf("expected the replaced file, got %q", rr.Body.String())
+	}
+}
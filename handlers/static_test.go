@@ -1,14 +1,32 @@
 package handlers
 
 import (
+	"database/sql"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"static-site-hosting/models"
+	"static-site-hosting/routecache"
 )
 
+// recordDeployment records the files under deployments/{id} as a
+// deployment; files without a record are never served
+func recordDeployment(t *testing.T, db *sql.DB, id string) {
+	t.Helper()
+	_, err := db.Exec("INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, ?, ?, ?)", id, id+".zip", time.Now(), filepath.Join("deployments", id))
+	if err != nil {
+		t.Fatalf("failed to record deployment %s: %v", id, err)
+	}
+}
+
 func TestStaticFileHandler(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
 	// Setup test directory
 	siteID := "test123"
 	deployPath := filepath.Join("deployments", siteID)
@@ -17,6 +35,7 @@ func TestStaticFileHandler(t *testing.T) {
 		t.Fatalf("failed to create deployments dir: %v", err)
 	}
 	defer os.RemoveAll("deployments")
+	recordDeployment(t, db, siteID)
 
 	// Create test file
 	testContent := "<html><body>Hello World</body></html>"
@@ -52,13 +71,19 @@ func TestStaticFileHandler(t *testing.T) {
 		},
 		{
 			name:           "invalid path - only site ID",
-			path:           "/test123/",
+			path:           "/test123",
 			expectedStatus: http.StatusNotFound,
 			expectedBody:   "",
 		},
+		{
+			name:           "site root serves index.html",
+			path:           "/test123/",
+			expectedStatus: http.StatusOK,
+			expectedBody:   testContent,
+		},
 	}
 
-	handler := StaticFileHandler()
+	handler := StaticFileHandler(db)
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -77,3 +102,154 @@ func TestStaticFileHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestStaticFileHandlerIgnoresUnrecordedFiles(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	// An upload still being checked has its files in place but no record
+	dir := filepath.Join("deployments", "pending-1")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("not yet"), 0644)
+
+	handler := StaticFileHandler(db)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/pending-1/index.html", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected files without a record not to be served, got %d", rr.Code)
+	}
+
+	recordDeployment(t, db, "pending-1")
+	routecache.Invalidate()
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/pending-1/index.html", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "not yet" {
+		t.Errorf("expected the recorded deployment to be served, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestStaticFileHandlerCaseInsensitive(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	siteID := "legacy-site"
+	recordDeployment(t, db, siteID)
+	assetDir := filepath.Join("deployments", siteID, "Images")
+	if err := os.MkdirAll(assetDir, 0755); err != nil {
+		t.Fatalf("failed to create deployments dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(assetDir, "Logo.PNG"), []byte("png"), 0644); err != nil {
+		t.Fatalf("failed to write test file: %v", err)
+	}
+
+	handler := StaticFileHandler(db)
+
+	// Exact casing is required until the option is enabled
+	req := httptest.NewRequest(http.MethodGet, "/legacy-site/images/logo.png", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 before enabling option, got %d", rr.Code)
+	}
+
+	if err := saveSiteSettings(db, siteID, models.SiteSettings{CaseInsensitivePaths: true}); err != nil {
+		t.Fatalf("failed to save settings: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200 after enabling option, got %d", rr.Code)
+	}
+	if rr.Body.String() != "png" {
+		t.Errorf("expected body %q, got %q", "png", rr.Body.String())
+	}
+}
+
+func TestStaticFileHandlerErrorPage(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	for _, id := range []string{"plain-site", "custom-site"} {
+		os.MkdirAll(filepath.Join("deployments", id), 0755)
+		os.WriteFile(filepath.Join("deployments", id, "index.html"), []byte("home"), 0644)
+		recordDeployment(t, db, id)
+	}
+	os.WriteFile(filepath.Join("deployments", "custom-site", "50x.html"), []byte("<h1>Back soon</h1>"), 0644)
+
+	handler := StaticFileHandler(db)
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	// Looking up a file below a file fails with ENOTDIR, not "not found"
+	rr := get("/custom-site/index.html/x")
+	if rr.Code != http.StatusInternalServerError || rr.Body.String() != "<h1>Back soon</h1>" {
+		t.Errorf("expected the site's 50x.html with status 500, got %d %q", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("expected an HTML error page, got %q", ct)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("expected the error not to be cached, got %q", cc)
+	}
+
+	rr = get("/plain-site/index.html/x")
+	if rr.Code != http.StatusInternalServerError || rr.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("expected a plain-text 500 without a 50x.html, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
+
+func TestStaticFileHandlerRanges(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	saved := *cfg
+	defer func() { *cfg = saved }()
+
+	dir := filepath.Join("deployments", "media1")
+	os.MkdirAll(dir, 0755)
+	recordDeployment(t, db, "media1")
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>hello world</h1>"), 0644)
+	os.WriteFile(filepath.Join(dir, "clip.mp4"), []byte("0123456789"), 0644)
+
+	get := func(path, ranges string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Range", ranges)
+		rr := httptest.NewRecorder()
+		StaticFileHandler(db).ServeHTTP(rr, req)
+		return rr
+	}
+	before := []int64{rangeStats.requests.Load(), rangeStats.partial.Load(), rangeStats.partialBytes.Load(), rangeStats.unsatisfiable.Load(), rangeStats.ignored.Load()}
+
+	if rr := get("/media1/clip.mp4", "bytes=2-5"); rr.Code != http.StatusPartialContent || rr.Body.String() != "2345" {
+		t.Errorf("expected bytes 2-5 of the video, got %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := get("/media1/clip.mp4", "bytes=50-"); rr.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("expected 416 past the end, got %d", rr.Code)
+	}
+	if rr := get("/media1/index.html", "bytes=0-3"); rr.Code != http.StatusPartialContent {
+		t.Errorf("expected HTML ranges by default, got %d", rr.Code)
+	}
+
+	cfg.RangeRequestsHTML = false
+	rr := get("/media1/index.html", "bytes=0-3")
+	if rr.Code != http.StatusOK || rr.Body.String() != "<h1>hello world</h1>" || rr.Header().Get("Accept-Ranges") != "none" {
+		t.Errorf("expected the whole page without ranges, got %d %q (Accept-Ranges %q)", rr.Code, rr.Body.String(), rr.Header().Get("Accept-Ranges"))
+	}
+	if rr := get("/media1/clip.mp4", "bytes=0-0"); rr.Code != http.StatusPartialContent || rr.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("expected media to keep ranges, got %d", rr.Code)
+	}
+
+	after := []int64{rangeStats.requests.Load(), rangeStats.partial.Load(), rangeStats.partialBytes.Load(), rangeStats.unsatisfiable.Load(), rangeStats.ignored.Load()}
+	for i, want := range []int64{5, 3, 4 + 4 + 1, 1, 1} {
+		if got := after[i] - before[i]; got != want {
+			t.Errorf("range counter %d: expected %d more, got %d", i, want, got)
+		}
+	}
+}
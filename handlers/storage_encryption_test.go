@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"static-site-hosting/atrest"
+	"static-site-hosting/models"
+)
+
+func TestEncryptedStorage(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	c, err := atrest.NewCipher(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	atrest.Default = c
+	defer func() { atrest.Default = nil }()
+
+	page := "<html>" + strings.Repeat("encrypted at rest ", 5000) + "</html>"
+	archive, _ := os.ReadFile(writeZip(t, map[string]string{"index.html": page}))
+	upload := func(w http.ResponseWriter, r *http.Request) { UploadHandler(w, r, db) }
+	var deployment models.Deployment
+	json.NewDecoder(uploadToSite(t, upload, "vault", archive).Body).Decode(&deployment)
+
+	raw, err := os.ReadFile(filepath.Join(deployment.Path, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("encrypted at rest")) {
+		t.Fatal("expected the deployed file to be encrypted on disk")
+	}
+
+	static := StaticFileHandler(db)
+	rr := httptest.NewRecorder()
+	static.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/"+deployment.ID+"/index.html", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != page {
+		t.Errorf("expected the plaintext page, got %d with %d bytes", rr.Code, rr.Body.Len())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/"+deployment.ID+"/index.html", nil)
+	req.Header.Set("Range", "bytes=70000-70009")
+	rr = httptest.NewRecorder()
+	static.ServeHTTP(rr, req)
+	if rr.Code != http.StatusPartialContent || rr.Body.String() != page[70000:70010] {
+		t.Errorf("expected a decrypted range, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	DeploymentFileHandler(rr, httptest.NewRequest(http.MethodGet, "/deployments/"+deployment.ID+"/files/index.html", nil), db)
+	if rr.Code != http.StatusOK || rr.Body.String() != page {
+		t.Errorf("expected the files API to decrypt, got %d with %d bytes", rr.Code, rr.Body.Len())
+	}
+
+	// A revision copies the files decrypted and encrypts its own tree once,
+	// next to a plaintext file that merely starts like an encrypted one
+	lookalike := "ssh-enc1 is how this page starts"
+	revision, _, err := createRevision(httptest.NewRequest(http.MethodPatch, "/", nil), db, deployment, "[PATCH] vault.zip", func(dir string) (int, error) {
+		return 0, os.WriteFile(filepath.Join(dir, "lookalike.txt"), []byte(lookalike), 0644)
+	})
+	if err != nil {
+		t.Fatalf("createRevision failed: %v", err)
+	}
+	for name, want := range map[string]string{"index.html": page, "lookalike.txt": lookalike} {
+		rr = httptest.NewRecorder()
+		static.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/"+revision.ID+"/"+name, nil))
+		if rr.Code != http.StatusOK || rr.Body.String() != want {
+			t.Errorf("%s: expected the revision to serve the plaintext, got %d with %d bytes", name, rr.Code, rr.Body.Len())
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+	"static-site-hosting/routecache"
+	"static-site-hosting/usage"
+)
+
+// TokensHandler lists (GET) the caller's API tokens, all of them for
+// admins, or creates (POST) one acting as the caller within its scopes.
+// API tokens can't create other tokens, so a leaked one can't widen itself.
+// Expected: POST /auth/tokens {"name": "ci", "scopes": ["deploy:write"], "expires_in": "720h"}
+func TokensHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, signer *auth.Signer) {
+	claims := auth.FromContext(r.Context())
+	if claims == nil {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		listTokens(w, r, db)
+
+	case http.MethodPost:
+		if claims.TokenID != "" {
+			http.Error(w, "API tokens can't create tokens", http.StatusForbidden)
+			return
+		}
+		createToken(w, r, db, signer, claims)
+
+	default:
+		http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+	}
+}
+
+// RevokeTokenHandler revokes one of the caller's API tokens; admins may
+// revoke anyone's. Like rotation it needs a session, so a leaked token of
+// any scope can't revoke its owner's other tokens.
+// Expected: DELETE /auth/tokens/{id}
+func RevokeTokenHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "DELETE required", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := auth.FromContext(r.Context())
+	if claims == nil {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+	if claims.TokenID != "" {
+		http.Error(w, "API tokens can't revoke tokens", http.StatusForbidden)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/auth/tokens/")
+	var ownerID string
+	err := db.QueryRow("SELECT owner_id FROM api_tokens WHERE id = ?", id).Scan(&ownerID)
+	if err == sql.ErrNoRows || err == nil && !ownsDeployment(r, ownerID) {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch token", http.StatusInternalServerError)
+		return
+	}
+	if _, err := db.Exec("UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now().UTC(), id); err != nil {
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+	routecache.Invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Token revoked", "id": id})
+}
+
+// tokenMeter records API token use; nil when it isn't recorded
+var tokenMeter *usage.TokenMeter
+
+// SetTokenMeter sets the meter that records API token use
+func SetTokenMeter(m *usage.TokenMeter) {
+	tokenMeter = m
+}
+
+// TokenUsageHandler reports how much one of the caller's API tokens has
+// been used: requests, those that failed, bytes uploaded and when it was
+// last used, so stale and abused tokens stand out. Admins may see anyone's.
+// Expected: GET /auth/tokens/{id}/usage
+func TokenUsageHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	if auth.FromContext(r.Context()) == nil {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+	if tokenMeter == nil {
+		http.Error(w, "Token usage is not recorded", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/auth/tokens/"), "/usage")
+	var ownerID string
+	err := db.QueryRow("SELECT owner_id FROM api_tokens WHERE id = ?", id).Scan(&ownerID)
+	if err == sql.ErrNoRows || err == nil && !ownsDeployment(r, ownerID) {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch token", http.StatusInternalServerError)
+		return
+	}
+	u, err := tokenMeter.Usage(id)
+	if err != nil {
+		http.Error(w, "Failed to fetch token usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+func listTokens(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	where, args := ownerScope(r)
+	rows, err := db.Query("SELECT id, owner_id, name, scopes, created_at, expires_at, rotated_at, revoked_at FROM api_tokens"+where+" ORDER BY created_at", args...)
+	if err != nil {
+		http.Error(w, "Failed to fetch tokens", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		var t models.APIToken
+		var scopes string
+		var rotatedAt, revokedAt sql.NullTime
+		if err := rows.Scan(&t.ID, &t.OwnerID, &t.Name, &scopes, &t.CreatedAt, &t.ExpiresAt, &rotatedAt, &revokedAt); err != nil {
+			http.Error(w, "Failed to scan token", http.StatusInternalServerError)
+			return
+		}
+		t.Scopes = strings.Split(scopes, ",")
+		if rotatedAt.Valid {
+			t.RotatedAt = &rotatedAt.Time
+		}
+		if revokedAt.Valid {
+			t.RevokedAt = &revokedAt.Time
+		}
+		tokens = append(tokens, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func createToken(w http.ResponseWriter, r *http.Request, db *sql.DB, signer *auth.Signer, claims *auth.Claims) {
+	var req struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn string   `json:"expires_in"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	var errs models.ValidationErrors
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		errs.Add("name", models.CodeMissing, "name is required")
+	} else if len(req.Name) > 100 {
+		errs.Add("name", models.CodeInvalid, "name must be at most 100 characters")
+	}
+	if len(req.Scopes) == 0 {
+		errs.Add("scopes", models.CodeMissing, "scopes is required")
+	}
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			errs.Add("scopes", models.CodeInvalid, "unknown scope %q; use deploy:read, deploy:upload, deploy:write, deploy:delete or admin", scope)
+		}
+	}
+	ttl := cfg.APITokenTTL
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 || d > cfg.APITokenTTL {
+			errs.Add("expires_in", models.CodeInvalid, "expires_in must be a duration up to %s", cfg.APITokenTTL)
+		}
+		ttl = d
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, http.StatusBadRequest, errs...)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !auth.ScopeAllowed(claims.Role, scope) {
+			http.Error(w, "Forbidden: the "+claims.Role+" role can't grant the "+scope+" scope", http.StatusForbidden)
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	t := models.APIToken{
+		ID:        uuid.New().String(),
+		OwnerID:   claims.Subject,
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	token, err := signAPIToken(signer, claims, &t)
+	if err != nil {
+		http.Error(w, "Failed to issue token", http.StatusInternalServerError)
+		return
+	}
+	_, err = db.Exec(
+		"INSERT INTO api_tokens (id, owner_id, name, scopes, created_at, expires_at, token_hash) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		t.ID, t.OwnerID, t.Name, strings.Join(t.Scopes, ","), t.CreatedAt, t.ExpiresAt, t.TokenHash,
+	)
+	if err != nil {
+		http.Error(w, "Failed to save token", http.StatusInternalServerError)
+		return
+	}
+
+	// The token is only ever returned here
+	t.Token = token
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+// RotateTokenHandler replaces the secret of one of the caller's API tokens,
+// keeping its ID, name and scopes. The old secret, and deploy tokens
+// exchanged for it, stop working at once; the new one lasts as long as the
+// old one did when it was issued. Admins revoke others' tokens rather than
+// rotating them, so they never hold a token acting as someone else.
+// Expected: POST /auth/tokens/{id}/rotate
+func RotateTokenHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, signer *auth.Signer) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := auth.FromContext(r.Context())
+	if claims == nil {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+	if claims.TokenID != "" {
+		http.Error(w, "API tokens can't rotate tokens", http.StatusForbidden)
+		return
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/auth/tokens/"), "/rotate")
+	var t models.APIToken
+	var scopes string
+	var rotatedAt, revokedAt sql.NullTime
+	err := db.QueryRow("SELECT id, owner_id, name, scopes, created_at, expires_at, rotated_at, revoked_at FROM api_tokens WHERE id = ?", id).
+		Scan(&t.ID, &t.OwnerID, &t.Name, &scopes, &t.CreatedAt, &t.ExpiresAt, &rotatedAt, &revokedAt)
+	if err == sql.ErrNoRows || err == nil && t.OwnerID != claims.Subject {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch token", http.StatusInternalServerError)
+		return
+	}
+	if revokedAt.Valid {
+		http.Error(w, "Token is revoked", http.StatusConflict)
+		return
+	}
+	t.Scopes = strings.Split(scopes, ",")
+	for _, scope := range t.Scopes {
+		if !auth.ScopeAllowed(claims.Role, scope) {
+			http.Error(w, "Forbidden: the "+claims.Role+" role can't grant the "+scope+" scope", http.StatusForbidden)
+			return
+		}
+	}
+
+	issued := t.CreatedAt
+	if rotatedAt.Valid {
+		issued = rotatedAt.Time
+	}
+	now := time.Now().UTC()
+	t.ExpiresAt = now.Add(t.ExpiresAt.Sub(issued))
+	t.RotatedAt = &now
+	token, err := signAPIToken(signer, claims, &t)
+	if err != nil {
+		http.Error(w, "Failed to issue token", http.StatusInternalServerError)
+		return
+	}
+	result, err := db.Exec(
+		"UPDATE api_tokens SET token_hash = ?, rotated_at = ?, expires_at = ? WHERE id = ? AND revoked_at IS NULL",
+		t.TokenHash, now, t.ExpiresAt, t.ID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to save token", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Token is revoked", http.StatusConflict)
+		return
+	}
+	routecache.Invalidate()
+
+	// The new token is only ever returned here
+	t.Token = token
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// signAPIToken issues t as a token acting as claims within t's scopes, and
+// sets t.TokenHash to what is stored of it. The nonce makes every secret
+// distinct, even two issued for the same token in the same second.
+func signAPIToken(signer *auth.Signer, claims *auth.Claims, t *models.APIToken) (string, error) {
+	tokenClaims := *claims
+	tokenClaims.TokenID, tokenClaims.Scopes, tokenClaims.Nonce = t.ID, t.Scopes, randomToken()
+	// The token outlives the session it was created in
+	tokenClaims.SessionID = ""
+	token, err := signer.Issue(tokenClaims, time.Until(t.ExpiresAt))
+	if err != nil {
+		return "", err
+	}
+	t.TokenHash = auth.TokenHash(token)
+	return token, nil
+}
+
+// ExchangeTokenHandler trades an API token for a short-lived deploy token
+// that may only upload to one site, so CI jobs can keep the long-lived
+// token out of the build. The deploy token lasts DEPLOY_TOKEN_TTL unless a
+// shorter expires_in is asked for, never outlives the API token, and is
+// revoked with it.
+// Expected: POST /auth/tokens/exchange {"site": "docs", "expires_in": "10m"}
+func ExchangeTokenHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, signer *auth.Signer) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	claims := auth.FromContext(r.Context())
+	if claims == nil {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+	if claims.TokenID == "" || claims.Site != "" {
+		http.Error(w, "Only API tokens can be exchanged for deploy tokens", http.StatusForbidden)
+		return
+	}
+	if !claims.Permits(auth.ScopeDeployUpload) {
+		http.Error(w, "Forbidden: token lacks the "+auth.ScopeDeployUpload+" scope", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Site      string `json:"site"`
+		ExpiresIn string `json:"expires_in"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	var errs models.ValidationErrors
+	if req.Site == "" {
+		errs.Add("site", models.CodeMissing, "site is required")
+	} else if err := siteSlugError(req.Site); err != nil {
+		errs = append(errs, *err)
+	}
+	ttl := cfg.DeployTokenTTL
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 || d > cfg.DeployTokenTTL {
+			errs.Add("expires_in", models.CodeInvalid, "expires_in must be a duration up to %s", cfg.DeployTokenTTL)
+		}
+		ttl = d
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, http.StatusBadRequest, errs...)
+		return
+	}
+	if owns, err := ownsSite(db, r, req.Site); err != nil {
+		http.Error(w, "Failed to check site ownership", http.StatusInternalServerError)
+		return
+	} else if !owns {
+		http.Error(w, "Site belongs to another owner", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now().UTC()
+	if left := time.Unix(claims.ExpiresAt, 0).Sub(now); left < ttl {
+		ttl = left
+	}
+	tokenClaims := *claims
+	tokenClaims.Scopes, tokenClaims.Site = []string{auth.ScopeDeployUpload}, req.Site
+	tokenClaims.ParentHash = auth.TokenHash(auth.RequestToken(r))
+	token, err := signer.Issue(tokenClaims, ttl)
+	if err != nil {
+		http.Error(w, "Failed to issue token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{
+		"token":      token,
+		"site":       req.Site,
+		"scopes":     tokenClaims.Scopes,
+		"expires_at": now.Add(ttl),
+	})
+}
+
+// TokenRevoked reports whether the API token tokenID may no longer be used
+// with the secret whose TokenHash is tokenHash: revoked, rotated since,
+// or unknown to the database. It fails closed when the database can't say.
+func TokenRevoked(db *sql.DB) func(tokenID, tokenHash string) bool {
+	type entry struct {
+		Hash    string
+		Revoked bool
+	}
+	return func(tokenID, tokenHash string) bool {
+		found, err := routecache.Lookup("token:"+tokenID, func() (entry, error) {
+			var e entry
+			var revokedAt sql.NullTime
+			err := db.QueryRow("SELECT token_hash, revoked_at FROM api_tokens WHERE id = ?", tokenID).Scan(&e.Hash, &revokedAt)
+			e.Revoked = revokedAt.Valid
+			return e, err
+		})
+		return err != nil || found.Revoked || subtle.ConstantTimeCompare([]byte(found.Hash), []byte(tokenHash)) != 1
+	}
+}
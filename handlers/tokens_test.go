@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+	"static-site-hosting/usage"
+)
+
+func TestAPITokens(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	signer := auth.NewSigner([]byte("secret"))
+	tokens := func(w http.ResponseWriter, r *http.Request) { TokensHandler(w, r, db, signer) }
+	revoke := func(id string, claims *auth.Claims) int {
+		rr := httptest.NewRecorder()
+		RevokeTokenHandler(rr, as(httptest.NewRequest(http.MethodDelete, "/auth/tokens/"+id, nil), claims), db)
+		return rr.Code
+	}
+	revoked := TokenRevoked(db)
+
+	rr := postJSON(tokens, "/auth/tokens", map[string]any{"name": "ci", "scopes": []string{auth.ScopeDeployWrite}, "expires_in": "24h"}, aliceClaims)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created models.APIToken
+	json.NewDecoder(rr.Body).Decode(&created)
+	claims, err := signer.Verify(created.Token)
+	if err != nil || claims.Subject != aliceClaims.Subject || claims.TokenID != created.ID || len(claims.Scopes) != 1 {
+		t.Fatalf("expected a token acting as alice with its scopes, got %+v, %v", claims, err)
+	}
+	if revoked(created.ID, auth.TokenHash(created.Token)) {
+		t.Error("expected a new token to be usable")
+	}
+
+	// Rotation replaces the secret under the same ID
+	rotate := func(id string, claims *auth.Claims) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		RotateTokenHandler(rr, as(httptest.NewRequest(http.MethodPost, "/auth/tokens/"+id+"/rotate", nil), claims), db, signer)
+		return rr
+	}
+	if rr := rotate(created.ID, bobClaims); rr.Code != http.StatusNotFound {
+		t.Errorf("expected bob's rotation of alice's token to be 404, got %d", rr.Code)
+	}
+	if rr := rotate(created.ID, claims); rr.Code != http.StatusForbidden {
+		t.Errorf("expected a token not to rotate tokens, got %d", rr.Code)
+	}
+	rr = rotate(created.ID, aliceClaims)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var rotated models.APIToken
+	json.NewDecoder(rr.Body).Decode(&rotated)
+	if rotated.ID != created.ID || rotated.Token == created.Token || rotated.RotatedAt == nil {
+		t.Fatalf("expected a new secret for the same token, got %+v", rotated)
+	}
+	if !revoked(created.ID, auth.TokenHash(created.Token)) || revoked(created.ID, auth.TokenHash(rotated.Token)) {
+		t.Error("expected only the rotated secret to be usable")
+	}
+	created = rotated
+
+	for name, tt := range map[string]struct {
+		body   map[string]any
+		claims *auth.Claims
+		status int
+	}{
+		"beyond the role":  {map[string]any{"name": "ci", "scopes": []string{auth.ScopeAdmin}}, aliceClaims, http.StatusForbidden},
+		"unknown scope":    {map[string]any{"name": "ci", "scopes": []string{"deploy:*"}}, aliceClaims, http.StatusBadRequest},
+		"no scopes":        {map[string]any{"name": "ci"}, aliceClaims, http.StatusBadRequest},
+		"too long":         {map[string]any{"name": "ci", "scopes": []string{auth.ScopeDeployRead}, "expires_in": "8760h"}, aliceClaims, http.StatusBadRequest},
+		"from a token":     {map[string]any{"name": "ci", "scopes": []string{auth.ScopeDeployRead}}, claims, http.StatusForbidden},
+		"anonymous caller": {map[string]any{"name": "ci", "scopes": []string{auth.ScopeDeployRead}}, nil, http.StatusUnauthorized},
+	} {
+		if rr := postJSON(tokens, "/auth/tokens", tt.body, tt.claims); rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", name, tt.status, rr.Code, rr.Body.String())
+		}
+	}
+
+	list := func(claims *auth.Claims) []models.APIToken {
+		rr := httptest.NewRecorder()
+		tokens(rr, as(httptest.NewRequest(http.MethodGet, "/auth/tokens", nil), claims))
+		var listed []models.APIToken
+		json.NewDecoder(rr.Body).Decode(&listed)
+		return listed
+	}
+	if listed := list(aliceClaims); len(listed) != 1 || listed[0].Token != "" || listed[0].Scopes[0] != auth.ScopeDeployWrite {
+		t.Errorf("expected alice's token without its secret, got %+v", listed)
+	}
+	if listed := list(bobClaims); len(listed) != 0 {
+		t.Errorf("expected bob to see no tokens, got %+v", listed)
+	}
+
+	if code := revoke(created.ID, claims); code != http.StatusForbidden {
+		t.Errorf("expected a token not to revoke tokens, got %d", code)
+	}
+	if code := revoke(created.ID, bobClaims); code != http.StatusNotFound {
+		t.Errorf("expected bob's revocation of alice's token to be 404, got %d", code)
+	}
+	if code := revoke(created.ID, aliceClaims); code != http.StatusOK {
+		t.Errorf("expected alice to revoke her token, got %d", code)
+	}
+	if !revoked(created.ID, auth.TokenHash(created.Token)) || !revoked("unknown", "") {
+		t.Error("expected revoked and unknown tokens to be refused")
+	}
+	if rr := rotate(created.ID, aliceClaims); rr.Code != http.StatusConflict {
+		t.Errorf("expected a revoked token not to be rotated, got %d", rr.Code)
+	}
+	if listed := list(adminClaims); len(listed) != 1 || listed[0].RevokedAt == nil {
+		t.Errorf("expected an admin to see the revoked token, got %+v", listed)
+	}
+
+	// An admin's token only acts as an admin with the admin scope
+	readOnly := &auth.Claims{Subject: "user:admin", Role: auth.RoleAdmin, TokenID: "t", Scopes: []string{auth.ScopeDeployRead}}
+	if isAdmin(as(httptest.NewRequest(http.MethodGet, "/", nil), readOnly)) {
+		t.Error("expected a read-only admin token not to act as an admin")
+	}
+}
+
+func TestDeployTokenExchange(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	signer := auth.NewSigner([]byte("secret"))
+	exchange := func(w http.ResponseWriter, r *http.Request) { ExchangeTokenHandler(w, r, db, signer) }
+
+	now := time.Now().Unix()
+	apiToken := &auth.Claims{Subject: "user:alice", Role: auth.RoleDeployer, TokenID: "t1", Scopes: []string{auth.ScopeDeployWrite}, IssuedAt: now, ExpiresAt: now + 3600}
+	rr := postJSON(exchange, "/auth/tokens/exchange", map[string]any{"site": "docs"}, apiToken)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	deployToken, err := signer.Verify(resp.Token)
+	if err != nil || deployToken.Site != "docs" || deployToken.TokenID != "t1" || len(deployToken.Scopes) != 1 || deployToken.Scopes[0] != auth.ScopeDeployUpload {
+		t.Fatalf("expected an upload-only token for docs revoked with t1, got %+v, %v", deployToken, err)
+	}
+	if ttl := deployToken.ExpiresAt - deployToken.IssuedAt; ttl != int64(cfg.DeployTokenTTL/time.Second) {
+		t.Errorf("expected the token to last %s, got %ds", cfg.DeployTokenTTL, ttl)
+	}
+	if deployToken.Permits(auth.ScopeDeployWrite) || deployToken.Permits(auth.ScopeDeployRead) {
+		t.Error("expected the deploy token to permit uploads only")
+	}
+
+	readOnly := &auth.Claims{Subject: "user:alice", Role: auth.RoleDeployer, TokenID: "t2", Scopes: []string{auth.ScopeDeployRead}, ExpiresAt: now + 3600}
+	for name, tt := range map[string]struct {
+		body   map[string]any
+		claims *auth.Claims
+		status int
+	}{
+		"session":          {map[string]any{"site": "docs"}, aliceClaims, http.StatusForbidden},
+		"deploy token":     {map[string]any{"site": "docs"}, deployToken, http.StatusForbidden},
+		"read-only token":  {map[string]any{"site": "docs"}, readOnly, http.StatusForbidden},
+		"no site":          {map[string]any{}, apiToken, http.StatusBadRequest},
+		"invalid site":     {map[string]any{"site": "Not A Site"}, apiToken, http.StatusBadRequest},
+		"too long":         {map[string]any{"site": "docs", "expires_in": "1h"}, apiToken, http.StatusBadRequest},
+		"anonymous caller": {map[string]any{"site": "docs"}, nil, http.StatusUnauthorized},
+	} {
+		if rr := postJSON(exchange, "/auth/tokens/exchange", tt.body, tt.claims); rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", name, tt.status, rr.Code, rr.Body.String())
+		}
+	}
+
+	// The deploy token can't outlive the API token
+	expiring := *apiToken
+	expiring.ExpiresAt = now + 60
+	rr = postJSON(exchange, "/auth/tokens/exchange", map[string]any{"site": "docs"}, &expiring)
+	var capped struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(rr.Body).Decode(&capped)
+	if c, err := signer.Verify(capped.Token); err != nil || c.ExpiresAt > expiring.ExpiresAt {
+		t.Errorf("expected the deploy token to expire with the API token, got %+v, %v", c, err)
+	}
+
+	upload := func(site string) *httptest.ResponseRecorder {
+		zipBuffer, _ := createTestZip()
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("site", site)
+		part, _ := writer.CreateFormFile("file", "test-site.zip")
+		part.Write(zipBuffer.Bytes())
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		UploadHandler(rr, as(req, deployToken), db)
+		return rr
+	}
+	if rr := upload("blog"); rr.Code != http.StatusForbidden {
+		t.Errorf("expected an upload to another site to be forbidden, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := upload(""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected an upload without a site to be forbidden, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := upload("docs"); rr.Code != http.StatusOK {
+		t.Errorf("expected an upload to docs, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTokenUsage(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	signer := auth.NewSigner([]byte("secret"))
+	defer SetTokenMeter(nil)
+	meter := usage.NewTokenMeter(db)
+	SetTokenMeter(meter)
+
+	tokens := func(w http.ResponseWriter, r *http.Request) { TokensHandler(w, r, db, signer) }
+	var created models.APIToken
+	json.NewDecoder(postJSON(tokens, "/auth/tokens", map[string]any{"name": "ci", "scopes": []string{auth.ScopeDeployWrite}}, aliceClaims).Body).Decode(&created)
+
+	meter.Record(created.ID, 2048, false)
+	meter.Record(created.ID, 0, true)
+	meter.Flush()
+	meter.Record(created.ID, 0, false)
+
+	get := func(id string, claims *auth.Claims) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		TokenUsageHandler(rr, as(httptest.NewRequest(http.MethodGet, "/auth/tokens/"+id+"/usage", nil), claims), db)
+		return rr
+	}
+	rr := get(created.ID, aliceClaims)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var u usage.TokenUsage
+	json.NewDecoder(rr.Body).Decode(&u)
+	if u.TokenID != created.ID || u.Requests != 3 || u.Errors != 1 || u.BytesUploaded != 2048 || u.LastUsedAt == nil {
+		t.Errorf("unexpected usage %+v", u)
+	}
+	if rr := get(created.ID, adminClaims); rr.Code != http.StatusOK {
+		t.Errorf("expected admins to see any token's usage, got %d", rr.Code)
+	}
+	if rr := get(created.ID, bobClaims); rr.Code != http.StatusNotFound {
+		t.Errorf("expected others' tokens to be 404, got %d", rr.Code)
+	}
+	if rr := get("unknown", aliceClaims); rr.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown token to be 404, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/secrets"
+)
+
+// totpIssuer names the server in authenticator apps
+const totpIssuer = "Static Site Hosting"
+
+// TwoFactorHandler enrolls the caller in TOTP two-factor authentication,
+// which destructive admin operations then ask for. Setup returns a secret
+// to add to an authenticator app; enrollment takes effect once a code from
+// the app is verified. Removing it takes a current code.
+//
+//	GET    /auth/2fa
+//	POST   /auth/2fa/setup
+//	POST   /auth/2fa/verify {"code": "123456"}
+//	DELETE /auth/2fa (with X-TOTP-Code)
+func TwoFactorHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	claims := auth.FromContext(r.Context())
+	if claims == nil {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+	if claims.TokenID != "" {
+		http.Error(w, "API tokens can't manage two-factor authentication", http.StatusForbidden)
+		return
+	}
+
+	action := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/auth/2fa"), "/")
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		enrolled, err := totpEnrolled(db, claims.Subject)
+		if err != nil {
+			http.Error(w, "Failed to check two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"enrolled": enrolled})
+
+	case action == "" && r.Method == http.MethodDelete:
+		if enrolled, ok := CheckTOTP(db)(claims.Subject, r.Header.Get(auth.TOTPHeader)); !enrolled {
+			http.Error(w, "Two-factor authentication is not enabled", http.StatusNotFound)
+			return
+		} else if !ok {
+			http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+			return
+		}
+		if _, err := db.Exec("DELETE FROM totp_secrets WHERE subject = ?", claims.Subject); err != nil {
+			http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication disabled"})
+
+	case action == "setup" && r.Method == http.MethodPost:
+		setupTOTP(w, db, claims)
+
+	case action == "verify" && r.Method == http.MethodPost:
+		verifyTOTP(w, r, db, claims)
+
+	case action == "" || action == "setup" || action == "verify":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// setupTOTP starts an enrollment with a new secret, replacing any that
+// wasn't verified. An enrolled caller disables it first, with a code.
+func setupTOTP(w http.ResponseWriter, db *sql.DB, claims *auth.Claims) {
+	if enrolled, err := totpEnrolled(db, claims.Subject); err != nil {
+		http.Error(w, "Failed to check two-factor authentication", http.StatusInternalServerError)
+		return
+	} else if enrolled {
+		http.Error(w, "Two-factor authentication is already enabled; disable it first", http.StatusConflict)
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	sealed, err := secrets.Seal(secret)
+	if err != nil {
+		http.Error(w, "Failed to encrypt secret", http.StatusInternalServerError)
+		return
+	}
+	_, err = db.Exec(
+		`INSERT INTO totp_secrets (subject, secret, created_at) VALUES (?, ?, ?)
+		ON CONFLICT(subject) DO UPDATE SET secret = excluded.secret, created_at = excluded.created_at, last_step = 0`,
+		claims.Subject, sealed, time.Now().UTC(),
+	)
+	if err != nil {
+		http.Error(w, "Failed to save secret", http.StatusInternalServerError)
+		return
+	}
+
+	account := claims.Email
+	if account == "" {
+		account = claims.Subject
+	}
+	// The secret is only ever returned here
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret": secret,
+		"uri":    auth.TOTPURI(totpIssuer, account, secret),
+	})
+}
+
+// verifyTOTP completes an enrollment with a code from the app
+func verifyTOTP(w http.ResponseWriter, r *http.Request, db *sql.DB, claims *auth.Claims) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	var sealed string
+	err := db.QueryRow("SELECT secret FROM totp_secrets WHERE subject = ? AND confirmed_at IS NULL", claims.Subject).Scan(&sealed)
+	if err == sql.ErrNoRows {
+		http.Error(w, "No two-factor setup in progress; POST /auth/2fa/setup first", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch secret", http.StatusInternalServerError)
+		return
+	}
+	secret, err := secrets.Open(sealed)
+	if err != nil {
+		http.Error(w, "Failed to decrypt secret", http.StatusInternalServerError)
+		return
+	}
+	step, ok := auth.VerifyTOTP(secret, req.Code, time.Now())
+	if !ok {
+		http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+		return
+	}
+	if _, err := db.Exec("UPDATE totp_secrets SET confirmed_at = ?, last_step = ? WHERE subject = ?", time.Now().UTC(), step, claims.Subject); err != nil {
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Two-factor authentication enabled"})
+}
+
+// CheckTOTP reports whether subject has enrolled in two-factor
+// authentication and, if so, whether code is a current code for it. Each
+// code is accepted once. It fails closed when the database can't say.
+func CheckTOTP(db *sql.DB) func(subject, code string) (enrolled, ok bool) {
+	return func(subject, code string) (bool, bool) {
+		var sealed string
+		var lastStep int64
+		err := db.QueryRow("SELECT secret, last_step FROM totp_secrets WHERE subject = ? AND confirmed_at IS NOT NULL", subject).Scan(&sealed, &lastStep)
+		if err == sql.ErrNoRows {
+			return false, false
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to look up two-factor secret: %v", err)
+			return true, false
+		}
+		secret, err := secrets.Open(sealed)
+		if err != nil {
+			log.Printf("Warning: Failed to decrypt two-factor secret: %v", err)
+			return true, false
+		}
+		step, ok := auth.VerifyTOTP(secret, code, time.Now())
+		if !ok || step <= lastStep {
+			return true, false
+		}
+		// Only one request may use the code, even concurrently
+		result, err := db.Exec("UPDATE totp_secrets SET last_step = ? WHERE subject = ? AND last_step < ?", step, subject, step)
+		if err != nil {
+			return true, false
+		}
+		n, _ := result.RowsAffected()
+		return true, n == 1
+	}
+}
+
+func totpEnrolled(db *sql.DB, subject string) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM totp_secrets WHERE subject = ? AND confirmed_at IS NOT NULL", subject).Scan(&n)
+	return n > 0, err
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"static-site-hosting/auth"
+)
+
+func TestTwoFactor(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	check := CheckTOTP(db)
+
+	call := func(method, path string, body any, code string, claims *auth.Claims) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := as(httptest.NewRequest(method, path, bytes.NewReader(data)), claims)
+		if code != "" {
+			req.Header.Set(auth.TOTPHeader, code)
+		}
+		rr := httptest.NewRecorder()
+		TwoFactorHandler(rr, req, db)
+		return rr
+	}
+
+	if enrolled, _ := check(adminClaims.Subject, "123456"); enrolled {
+		t.Fatal("expected nobody to be enrolled yet")
+	}
+	rr := call(http.MethodPost, "/auth/2fa/setup", nil, "", adminClaims)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var setup struct{ Secret, URI string }
+	json.NewDecoder(rr.Body).Decode(&setup)
+	if setup.Secret == "" || setup.URI == "" {
+		t.Fatalf("expected a secret and URI, got %+v", setup)
+	}
+	// Until a code is verified nothing is asked for
+	if enrolled, _ := check(adminClaims.Subject, ""); enrolled {
+		t.Error("expected an unverified setup not to enroll")
+	}
+
+	if rr := call(http.MethodPost, "/auth/2fa/verify", map[string]string{"code": "000000"}, "", adminClaims); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected a wrong code to be refused, got %d", rr.Code)
+	}
+	code, _ := auth.TOTPCode(setup.Secret, time.Now())
+	if rr := call(http.MethodPost, "/auth/2fa/verify", map[string]string{"code": code}, "", adminClaims); rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := call(http.MethodPost, "/auth/2fa/setup", nil, "", adminClaims); rr.Code != http.StatusConflict {
+		t.Errorf("expected setup to refuse an enrolled caller, got %d", rr.Code)
+	}
+
+	// Each code works once
+	if enrolled, ok := check(adminClaims.Subject, code); !enrolled || ok {
+		t.Errorf("expected the verified code not to be reusable, got %v, %v", enrolled, ok)
+	}
+	next, _ := auth.TOTPCode(setup.Secret, time.Now().Add(30*time.Second))
+	if _, ok := check(adminClaims.Subject, next); !ok {
+		t.Error("expected a fresh code to be accepted")
+	}
+	if _, ok := check(adminClaims.Subject, next); ok {
+		t.Error("expected a replayed code to be refused")
+	}
+
+	rr = call(http.MethodGet, "/auth/2fa", nil, "", adminClaims)
+	var status map[string]bool
+	json.NewDecoder(rr.Body).Decode(&status)
+	if !status["enrolled"] {
+		t.Errorf("expected the caller to be enrolled, got %v", status)
+	}
+
+	token := *adminClaims
+	token.TokenID = "token-1"
+	if rr := call(http.MethodPost, "/auth/2fa/setup", nil, "", &token); rr.Code != http.StatusForbidden {
+		t.Errorf("expected API tokens to be refused, got %d", rr.Code)
+	}
+
+	if rr := call(http.MethodDelete, "/auth/2fa", nil, "000000", adminClaims); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected disabling to need a valid code, got %d", rr.Code)
+	}
+	// Codes are used up a step at a time; free the current ones again
+	if _, err := db.Exec("UPDATE totp_secrets SET last_step = 0"); err != nil {
+		t.Fatal(err)
+	}
+	if rr := call(http.MethodDelete, "/auth/2fa", nil, next, adminClaims); rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if enrolled, _ := check(adminClaims.Subject, ""); enrolled {
+		t.Error("expected two-factor authentication to be disabled")
+	}
+}
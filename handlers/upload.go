@@ -1,16 +1,28 @@
 package handlers
 
 import (
-	"archive/zip"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"static-site-hosting/artifacts"
+	"static-site-hosting/events"
+	"static-site-hosting/features"
+	"static-site-hosting/immutable"
 	"static-site-hosting/models"
-	"strings"
+	"static-site-hosting/tombstone"
+	"static-site-hosting/usage"
+	"static-site-hosting/validate"
+	"static-site-hosting/webhooks"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -22,111 +34,342 @@ func UploadHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	r.ParseMultipartForm(20 << 20)
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		http.Error(w, "Invalid file", http.StatusBadRequest)
+	started := time.Now()
+	progress := startUpload(uploadID(r), r.ContentLength)
+	w.Header().Set("X-Upload-Id", progress.id)
+	fail := func(msg string, code int) {
+		progress.fail(msg)
+		http.Error(w, msg, code)
+	}
+
+	if !limitUploadBody(w, r, db) {
+		writeUploadTooLarge(w, uploadPolicy(r, db).MaxBytes, progress)
+		return
+	}
+	r.Body = progress.body(r.Body)
+	verified, problem := verifyUploadSignature(r)
+	if problem != "" {
+		fail(problem, http.StatusUnauthorized)
+		return
+	}
+	if err := r.ParseMultipartForm(cfg.UploadMemoryBytes); tooLarge(err) {
+		writeUploadTooLarge(w, uploadPolicy(r, db).MaxBytes, progress)
+		return
+	}
+	if err := verified(); tooLarge(err) {
+		writeUploadTooLarge(w, uploadPolicy(r, db).MaxBytes, progress)
+		return
+	} else if err != nil {
+		fail(errUploadSignature, http.StatusUnauthorized)
+		return
+	}
+	// Several files are the volumes of a split archive
+	var files []*multipart.FileHeader
+	if r.MultipartForm != nil {
+		files = r.MultipartForm.File["file"]
+	}
+	site := r.FormValue("site")
+	requestedID := r.FormValue("deployment_id")
+	var errs models.ValidationErrors
+	if len(files) == 0 {
+		errs.Add("file", models.CodeMissing, "Invalid file")
+	}
+	if site != "" {
+		errs.Include(siteSlugError(site))
+	}
+	errs.Include(deploymentIDError(requestedID))
+	if v := r.FormValue("visibility"); v != "" {
+		errs.Include(visibilityError(v))
+	}
+	_, e := expiresAtParam(r, site)
+	errs.Include(e)
+	async, _ := strconv.ParseBool(r.FormValue("async"))
+	if async && !flags.Enabled(features.AsyncUploads) {
+		errs.Add("async", models.CodeDisabled, "Asynchronous uploads are not enabled")
+	}
+	if len(errs) > 0 {
+		progress.fail(errs.Error())
+		writeFieldErrors(w, http.StatusBadRequest, errs...)
 		return
 	}
-	defer file.Close()
 
-	originalFilename := header.Filename
+	originalFilename := files[0].Filename
+	if len(files) > 1 {
+		originalFilename = sortVolumes(files)
+	}
 	if originalFilename == "" {
 		originalFilename = "unknown.zip"
 	}
 
-	siteID := uuid.New().String()
-	tempZip := fmt.Sprintf("temp-%s.zip", siteID)
+	stagingID := uuid.New().String()
+	tempZip := spoolPath("%s.zip", stagingID)
 	dst, err := os.Create(tempZip)
 	if err != nil {
-		http.Error(w, "Could not create temp file", http.StatusInternalServerError)
+		fail("Could not create temp file", http.StatusInternalServerError)
 		return
 	}
 	defer dst.Close()
 	defer os.Remove(tempZip)
 
-	_, err = io.Copy(dst, file)
-	if err != nil {
-		http.Error(w, "Failed to save uploaded file", http.StatusInternalServerError)
-		return
+	hash := sha256.New()
+	volumes := make([]int64, len(files))
+	for i, header := range files {
+		file, err := header.Open()
+		if err != nil {
+			fail("Invalid file", http.StatusBadRequest)
+			return
+		}
+		volumes[i], err = io.Copy(io.MultiWriter(dst, hash), file)
+		file.Close()
+		if err != nil {
+			fail("Failed to save uploaded file", http.StatusInternalServerError)
+			return
+		}
 	}
 	dst.Close()
-
-	destDir := filepath.Join("deployments", siteID)
-	if err := unzip(tempZip, destDir); err != nil {
-		http.Error(w, "Failed to unzip", http.StatusInternalServerError)
+	// Build pipelines often produce tarballs, so the format is told from the
+	// content rather than assumed; split archives can only be zips
+	format := sniffArchiveFormat(tempZip)
+	if format == "" {
+		fail("Upload is not a zip, tar or tar.gz archive", http.StatusBadRequest)
 		return
 	}
+	if format == archiveZip {
+		if err := joinZipVolumes(tempZip, volumes); err != nil {
+			fail("Failed to join split archive: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	archiveHash := hex.EncodeToString(hash.Sum(nil))
 
-	// Save to database
-	_, err = db.Exec(
-		"INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, ?, ?, ?)",
-		siteID, originalFilename, models.NewDeployment(siteID, originalFilename, destDir).Timestamp, destDir,
-	)
-	if err != nil {
-		// Clean up files if DB insert fails
-		os.RemoveAll(destDir)
-		http.Error(w, "Failed to save deployment", http.StatusInternalServerError)
+	deploy := func(w http.ResponseWriter, r *http.Request, archivePath string) {
+		fail := func(msg string, code int) {
+			progress.fail(msg)
+			http.Error(w, msg, code)
+		}
+		// CI often redeploys unchanged builds; skip extraction when this
+		// exact archive is already deployed to the site
+		siteID, release, handled := assignDeploymentID(w, r, db, stagingID, requestedID, site, archiveHash, originalFilename, progress, started)
+		if handled {
+			return
+		}
+		defer release()
+
+		destDir := filepath.Join("deployments", siteID)
+		if err := extractArchive(archivePath, format, destDir, progress); err != nil {
+			immutable.RemoveAll(destDir)
+			fail(extractFailure(err, "Failed to extract archive"), http.StatusBadRequest)
+			return
+		}
+
+		deployment := models.NewDeployment(siteID, originalFilename, destDir)
+		deployment.Site = site
+		deployment.ArchiveSHA256 = archiveHash
+		publishDeployment(w, r, db, deployment, &uploadArchive{archivePath, format}, progress, started)
+	}
+	if !async {
+		deploy(w, r, tempZip)
 		return
 	}
 
-	// Create deployment using models
-	deployment := models.NewDeployment(siteID, originalFilename, destDir)
+	// Asynchronous uploads are answered once the archive is received. The
+	// background deploy owns the archive and outlives the request, and its
+	// outcome is reported through the upload's progress.
+	background := spoolPath("%s-async.%s", stagingID, format)
+	if err := os.Rename(tempZip, background); err != nil {
+		fail("Failed to save uploaded file", http.StatusInternalServerError)
+		return
+	}
+	detached := r.WithContext(context.WithoutCancel(r.Context()))
+	go func() {
+		defer os.Remove(background)
+		deploy(&backgroundResponse{header: http.Header{}}, detached, background)
+	}()
 
+	w.Header().Set("Location", "/uploads/"+progress.id+"/progress")
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(deployment)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(progress.snapshot())
+}
+
+// backgroundResponse stands in for the response of an upload deployed in
+// the background, which nobody reads: its progress reports the outcome
+type backgroundResponse struct {
+	header http.Header
 }
 
-func unzip(src, dest string) error {
-	r, err := zip.OpenReader(src)
+func (b *backgroundResponse) Header() http.Header         { return b.header }
+func (b *backgroundResponse) Write(p []byte) (int, error) { return len(p), nil }
+func (b *backgroundResponse) WriteHeader(int)             {}
+
+// uploadArchive is the archive a deployment was extracted from, still on
+// disk while the deployment is published
+type uploadArchive struct {
+	path   string
+	format string
+}
+
+// retainArchive keeps the archive a deployment was extracted from, less
+// any secret files, when artifact retention is on
+func retainArchive(db *sql.DB, deploymentID string, archive *uploadArchive) {
+	if artifacts.Default == nil {
+		return
+	}
+	src, err := withoutSecretFiles(archive.path, archive.format)
+	if err == nil {
+		if src != archive.path {
+			defer os.Remove(src)
+		}
+		err = artifacts.Retain(db, deploymentID, archive.format, src)
+	}
 	if err != nil {
-		return err
+		log.Printf("Warning: Failed to retain archive of deployment %s: %v", deploymentID, err)
 	}
-	defer r.Close()
+}
 
-	os.MkdirAll(dest, 0755)
+// publishDeployment seals an extracted deployment, records it and answers
+// the upload with it. The files are removed if it can't be recorded. The
+// archive is retained with the deployment when artifact retention is on.
+// Deploy tokens may only publish the site they were exchanged for, and
+// rewrite_base_path=true rewrites root-relative links in its HTML first.
+func publishDeployment(w http.ResponseWriter, r *http.Request, db *sql.DB, deployment *models.Deployment, archive *uploadArchive, progress *uploadTracker, started time.Time) {
+	fail := func(msg string) {
+		immutable.RemoveAll(deployment.Path)
+		progress.fail(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
 
-	for _, f := range r.File {
-		// Prevent path traversal attacks
-		if strings.Contains(f.Name, "..") {
-			continue // Skip files with .. in path
+	// Whichever route got here, nothing is published unsigned while
+	// uploads must be signed
+	if err := checkUploadSignature(r); err != nil {
+		immutable.RemoveAll(deployment.Path)
+		if tooLarge(err) {
+			writeUploadTooLarge(w, uploadPolicy(r, db).MaxBytes, progress)
+			return
+		}
+		progress.fail(errUploadSignature)
+		http.Error(w, errUploadSignature, http.StatusUnauthorized)
+		return
+	}
+	if !authorizeSiteDeploy(w, r, db, deployment.Site, progress) {
+		immutable.RemoveAll(deployment.Path)
+		return
+	}
+	if !enforceUploadPolicy(w, r, db, deployment.Path, progress) {
+		return
+	}
+	// Sites built for a domain root can ask for their links to be moved
+	// under the deployment's path
+	if rewrite, _ := strconv.ParseBool(r.FormValue("rewrite_base_path")); rewrite {
+		if err := rewriteBasePath(deployment.Path, "/s/"+deployment.ID); err != nil {
+			fail("Failed to rewrite base path")
+			return
 		}
+	}
 
-		fPath := filepath.Join(dest, f.Name)
+	extracted, err := uploadReport(deployment.Path, progress, uploadPolicy(r, db).Scan)
+	if err != nil {
+		fail("Failed to inspect deployment")
+		return
+	}
 
-		// Ensure the file path is within dest directory
-		if !strings.HasPrefix(fPath, filepath.Clean(dest)+string(os.PathSeparator)) {
-			continue
+	// Checks run before the deployment is recorded, and nothing but the
+	// smoke test serves a deployment without a record
+	checks := map[string]any{}
+	failure := ""
+	report, err := validate.Run(deployment.Path, deployValidators())
+	if err != nil {
+		fail("Failed to validate deployment")
+		return
+	}
+	if len(report.Validators) > 0 {
+		checks[reportValidation] = report
+	}
+	if !report.Passed {
+		failure = "Deployment failed validation"
+	} else if cfg.SmokeTest {
+		smoke := smokeTest(db, deployment.ID, deployment.Path)
+		checks[reportSmokeTest] = smoke
+		if !smoke.Passed {
+			failure = "Deployment failed smoke test"
 		}
+	}
+	if failure != "" {
+		deployment.Status = models.StatusFailed
+	}
 
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(fPath, f.Mode())
-			continue
-		}
+	if err := immutable.Seal(deployment.Path); err != nil {
+		fail("Failed to seal deployment")
+		return
+	}
 
-		// Create parent directories
-		if err := os.MkdirAll(filepath.Dir(fPath), 0755); err != nil {
-			return err
+	// Save to database, protected before anything can serve it
+	previousLive := liveDeploymentID(db, deployment.Site)
+	deployment.OwnerID = deploymentOwner(db, r, deployment.Site)
+	deployment.DeployedBy = deployedBy(r)
+	deployment.Visibility = deploymentVisibility(db, deployment.Site, r.FormValue("visibility"))
+	inherited, err := inheritPassword(db, deployment)
+	if err != nil {
+		fail("Failed to save deployment")
+		return
+	}
+	_, err = db.Exec(
+		"INSERT INTO deployments (id, filename, timestamp, path, site, archive_sha256, status, owner_id, deployed_by, visibility) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		deployment.ID, deployment.Filename, deployment.Timestamp, deployment.Path, deployment.Site, deployment.ArchiveSHA256, deployment.Status, deployment.OwnerID, deployment.DeployedBy, deployment.Visibility,
+	)
+	if err != nil {
+		if inherited {
+			db.Exec("DELETE FROM deployment_passwords WHERE deployment_id = ?", deployment.ID)
 		}
+		fail("Failed to save deployment")
+		return
+	}
+	// Requested and content-derived IDs can be those of deleted deployments
+	tombstone.Remove(db, deployment.ID)
+	for kind, report := range checks {
+		saveReport(db, deployment.ID, kind, report)
+	}
+	recordFileHashes(db, deployment.ID, deployment.Path)
+	// Failed deployments keep their archive too, for inspection
+	if archive != nil {
+		retainArchive(db, deployment.ID, archive)
+	}
 
-		outFile, err := os.OpenFile(fPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			return err
-		}
+	// Failed deployments keep their files for inspection but are never served
+	if failure != "" {
+		entry := usage.RecordDeployment(db, deployment.ID, requestTenant(r, usage.DefaultTenant), deployment.Path, time.Since(started))
+		webhooks.Notify(db, webhooks.EventDeploymentFailed, map[string]any{"deployment": deployment, "reports": checks}, deployment.Site)
+		events.Record(db, events.DeploymentFailed, deployment.ID, deployment.Site, map[string]any{"deployment": deployment, "reports": checks, "usage": entry})
+		progress.fail(failure)
 
-		rc, err := f.Open()
-		if err != nil {
-			outFile.Close()
-			return err
-		}
+		deployment.Upload = extracted
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":      failure,
+			"deployment": deployment,
+			"report":     checks[reportValidation],
+			"smoke_test": checks[reportSmokeTest],
+		})
+		return
+	}
 
-		_, err = io.Copy(outFile, rc)
-		outFile.Close()
-		rc.Close()
+	recordPreloadHints(db, deployment.ID, deployment.Path)
+	entry := usage.RecordDeployment(db, deployment.ID, requestTenant(r, usage.DefaultTenant), deployment.Path, time.Since(started))
 
-		if err != nil {
-			return err
+	// Deploys can set the site's expiry date, already validated
+	if expiresAt, e := expiresAtParam(r, deployment.Site); e == nil && !expiresAt.IsZero() {
+		if err := saveSiteExpiry(db, deployment.Site, expiresAt); err != nil {
+			log.Printf("Warning: Failed to save expiry of site %s: %v", deployment.Site, err)
 		}
 	}
-	return nil
+
+	webhooks.Notify(db, webhooks.EventDeploymentCreated, deployment, deployment.Site)
+	events.Record(db, events.DeploymentCreated, deployment.ID, deployment.Site, map[string]any{"deployment": deployment, "usage": entry})
+	notifyPromotion(db, deployment.Site, previousLive)
+	progress.complete(deployment.ID)
+
+	deployment.Upload = extracted
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(withURLs(r, deployment))
 }
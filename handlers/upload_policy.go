@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"static-site-hosting/immutable"
+	"static-site-hosting/policy"
+	"static-site-hosting/usage"
+)
+
+// virusScanner returns the scanner used when a policy requires scanning,
+// or nil if none is configured
+var virusScanner = func() policy.Scanner {
+	if cfg.ClamdAddr == "" {
+		return nil
+	}
+	return policy.Clamd{Addr: cfg.ClamdAddr}
+}
+
+func globalUploadPolicy() policy.Policy {
+	return policy.Policy{MaxBytes: cfg.UploadMaxBytes, AllowedTypes: cfg.UploadAllowedTypes, Scan: cfg.UploadScan}
+}
+
+// uploadPolicy returns the upload policy of the caller's tenant
+func uploadPolicy(r *http.Request, db *sql.DB) policy.Policy {
+	tenant := requestTenant(r, usage.DefaultTenant)
+	p, err := policy.ForTenant(db, tenant, globalUploadPolicy())
+	if err != nil {
+		log.Printf("Warning: Failed to load upload policy for tenant %s: %v", tenant, err)
+	}
+	return p
+}
+
+// errUploadTooLarge is the message uploads over the size limit get with 413
+const errUploadTooLarge = "Upload exceeds the size limit"
+
+// writeUploadTooLarge answers 413 with the size limit the upload went over,
+// so clients can tell how far over they are without guessing:
+//
+//	{"error": "Upload exceeds the size limit", "max_bytes": 10485760}
+//
+// The upload's tracker, if it has one yet, is marked failed.
+func writeUploadTooLarge(w http.ResponseWriter, limit int64, progress *uploadTracker) {
+	if progress != nil {
+		progress.fail(errUploadTooLarge)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(map[string]any{"error": errUploadTooLarge, "max_bytes": limit})
+}
+
+// limitUploadBody applies the caller's size limit to r's body. It returns
+// false when the declared length is already over the limit, so the upload
+// can be refused before anything is read.
+func limitUploadBody(w http.ResponseWriter, r *http.Request, db *sql.DB) bool {
+	p := uploadPolicy(r, db)
+	if p.MaxBytes <= 0 {
+		return true
+	}
+	if r.ContentLength > p.MaxBytes {
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, p.MaxBytes)
+	return true
+}
+
+// tooLarge reports whether err came from reading past the size limit
+func tooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// enforceUploadPolicy checks an extracted deployment against the caller's
+// allowed file types and virus scanning policy. On a violation the files
+// are removed, the upload is answered and false is returned.
+func enforceUploadPolicy(w http.ResponseWriter, r *http.Request, db *sql.DB, dir string, progress *uploadTracker) bool {
+	code, body := uploadPolicyViolation(r, db, dir)
+	if body == nil {
+		return true
+	}
+	immutable.RemoveAll(dir)
+	progress.fail(body["error"].(string))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+	return false
+}
+
+// uploadPolicyViolation checks the files in dir against the caller's
+// allowed file types and virus scanning policy, returning the status and
+// body to refuse them with, or a nil body when they may be published
+func uploadPolicyViolation(r *http.Request, db *sql.DB, dir string) (int, map[string]any) {
+	p := uploadPolicy(r, db)
+	rejected, err := policy.DisallowedFiles(dir, p.AllowedTypes)
+	if err != nil {
+		return http.StatusInternalServerError, map[string]any{"error": "Failed to check file types"}
+	}
+	if len(rejected) > 0 {
+		return http.StatusUnprocessableEntity, map[string]any{
+			"error":         "Upload contains file types that are not allowed",
+			"files":         rejected,
+			"allowed_types": p.AllowedTypes,
+		}
+	}
+
+	if !p.Scan {
+		return 0, nil
+	}
+	// Scanning is required, so an unavailable scanner fails the upload
+	scanner := virusScanner()
+	if scanner == nil {
+		return http.StatusServiceUnavailable, map[string]any{"error": "Virus scanning is required but no scanner is configured"}
+	}
+	findings, err := policy.ScanTree(scanner, dir)
+	if err != nil {
+		log.Printf("Virus scan failed: %v", err)
+		return http.StatusServiceUnavailable, map[string]any{"error": "Virus scanning is unavailable"}
+	}
+	if len(findings) > 0 {
+		return http.StatusUnprocessableEntity, map[string]any{"error": "Malware detected", "findings": findings}
+	}
+	return 0, nil
+}
+
+// TenantUploadPolicyHandler manages a tenant's upload policy override.
+// Expected: GET, PUT or DELETE /admin/tenants/{tenant}/upload-policy
+//
+// PUT takes any of max_bytes, allowed_types and scan; fields left out keep
+// following the global policy. Responses show the override and the
+// resulting effective policy.
+func TenantUploadPolicyHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	tenant, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/admin/tenants/"), "/upload-policy")
+	if !ok || tenant == "" || strings.Contains(tenant, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var o policy.Override
+		if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+			http.Error(w, "Invalid policy JSON", http.StatusBadRequest)
+			return
+		}
+		if o.MaxBytes != nil && *o.MaxBytes < 0 {
+			http.Error(w, "max_bytes must not be negative", http.StatusBadRequest)
+			return
+		}
+		if err := policy.SaveOverride(db, tenant, o); err != nil {
+			http.Error(w, "Failed to save upload policy", http.StatusInternalServerError)
+			return
+		}
+	case http.MethodDelete:
+		if err := policy.DeleteOverride(db, tenant); err != nil {
+			http.Error(w, "Failed to delete upload policy", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "GET, PUT or DELETE required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	o, found, err := policy.LoadOverride(db, tenant)
+	if err != nil {
+		http.Error(w, "Failed to fetch upload policy", http.StatusInternalServerError)
+		return
+	}
+	var override *policy.Override
+	if found {
+		override = &o
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"tenant":    tenant,
+		"override":  override,
+		"effective": globalUploadPolicy().With(o),
+	})
+}
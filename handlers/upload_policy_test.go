@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"static-site-hosting/policy"
+)
+
+type fakeScanner struct{ infected string }
+
+func (f fakeScanner) Scan(r io.Reader) (string, error) {
+	content, _ := io.ReadAll(r)
+	if strings.Contains(string(content), f.infected) {
+		return "Test-Signature", nil
+	}
+	return "", nil
+}
+
+func setTenantPolicy(t *testing.T, admin http.Handler, tenant, body string) {
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/admin/tenants/"+tenant+"/upload-policy", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 saving policy, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTenantUploadPolicyOverridesGlobal(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.UploadMaxBytes = 1000
+	cfg.UploadAllowedTypes = []string{".html"}
+
+	admin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { TenantUploadPolicyHandler(w, r, db) })
+	setTenantPolicy(t, admin, "acme", `{"max_bytes": 5000, "scan": true}`)
+
+	rr := httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/tenants/acme/upload-policy", nil))
+	var resp struct {
+		Effective policy.Policy `json:"effective"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Effective.MaxBytes != 5000 || !resp.Effective.Scan || len(resp.Effective.AllowedTypes) != 1 {
+		t.Errorf("expected overridden size and scanning with inherited types, got %+v", resp.Effective)
+	}
+
+	rr = httptest.NewRecorder()
+	admin.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/admin/tenants/acme/upload-policy", nil))
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Effective.MaxBytes != 1000 || resp.Effective.Scan {
+		t.Errorf("expected the global policy after delete, got %+v", resp.Effective)
+	}
+}
+
+func TestUploadPolicyEnforcement(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	saved, savedScanner := *cfg, virusScanner
+	defer func() { *cfg, virusScanner = saved, savedScanner }()
+	virusScanner = func() policy.Scanner { return fakeScanner{infected: "hello world"} }
+
+	upload := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { UploadHandler(w, r, db) })
+	admin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { TenantUploadPolicyHandler(w, r, db) })
+	site := map[string]string{"index.html": "<h1>hi</h1>", "script.js": "console.log('hello world')"}
+
+	// Anonymous uploads are billed to, and governed by, the default tenant
+	setTenantPolicy(t, admin, "default", `{"max_bytes": 100}`)
+	rr := uploadZip(t, upload, site)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 over the declared size limit, got %d", rr.Code)
+	}
+	assertTooLarge(t, rr, 100)
+
+	setTenantPolicy(t, admin, "default", `{"allowed_types": [".html", ".css"]}`)
+	rr = uploadZip(t, upload, site)
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "script.js") {
+		t.Errorf("expected script.js to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	setTenantPolicy(t, admin, "default", `{"scan": true}`)
+	rr = uploadZip(t, upload, site)
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "Test-Signature") {
+		t.Errorf("expected malware to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+	entries, _ := os.ReadDir("deployments")
+	if len(entries) != 0 {
+		t.Errorf("expected refused uploads to leave no files, found %d", len(entries))
+	}
+
+	virusScanner = func() policy.Scanner { return nil }
+	if rr := uploadZip(t, upload, site); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 when scanning is required but unavailable, got %d", rr.Code)
+	}
+}
+
+func TestRawUploadStreamedPastSizeLimit(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.UploadMaxBytes = 100
+
+	req := httptest.NewRequest(http.MethodPut, "/sites/docs/deployments", bytes.NewReader(createTestTarGz(t)))
+	req.Header.Set("Content-Type", "application/gzip")
+	req.ContentLength = -1 // chunked, so only reading can tell
+	rr := httptest.NewRecorder()
+	SiteDeploymentsHandler(rr, req, db)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 while streaming, got %d: %s", rr.Code, rr.Body.String())
+	}
+	assertTooLarge(t, rr, 100)
+}
+
+// assertTooLarge checks that a 413 states the limit as JSON
+func assertTooLarge(t *testing.T, rr *httptest.ResponseRecorder, limit int64) {
+	t.Helper()
+	var body struct {
+		Error    string `json:"error"`
+		MaxBytes int64  `json:"max_bytes"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil || rr.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected a JSON 413 body, got %q: %v", rr.Body.String(), err)
+	}
+	if body.Error != errUploadTooLarge || body.MaxBytes != limit {
+		t.Errorf("expected the %d byte limit to be stated, got %+v", limit, body)
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"static-site-hosting/models"
+)
+
+// uploadReport describes what an upload extracted into dir: the files and
+// their total size, the entries progress saw skipped, whether the files were
+// scanned for malware, and warnings about what the uploader may not expect
+func uploadReport(dir string, progress *uploadTracker, scanned bool) (*models.UploadReport, error) {
+	report := &models.UploadReport{Scanned: scanned}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		report.FilesExtracted++
+		report.TotalBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report.Skipped, report.SkippedCount = progress.skippedEntries()
+
+	if report.SkippedCount > 0 {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("%d archive entries were skipped and are not part of the deployment", report.SkippedCount))
+	}
+	if report.FilesExtracted == 0 {
+		report.Warnings = append(report.Warnings, "The archive contains no files")
+	} else if _, err := os.Stat(filepath.Join(dir, "index.html")); err != nil {
+		report.Warnings = append(report.Warnings, "There is no index.html at the root of the deployment")
+	}
+	return report, nil
+}
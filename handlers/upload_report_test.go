@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"static-site-hosting/config"
+	"static-site-hosting/models"
+)
+
+func TestUploadReport(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+	cfg.UnsafeEntries = config.UnsafeEntriesSkip
+	defer func() { cfg.UnsafeEntries = config.UnsafeEntriesReject }()
+
+	upload := func(w http.ResponseWriter, r *http.Request) { UploadHandler(w, r, db) }
+	archive, _ := os.ReadFile(writeZip(t, map[string]string{
+		"index.html":      "<html></html>",
+		"css/app.css":     "body{}",
+		"../../evil.html": "<script></script>",
+	}))
+	var deployment models.Deployment
+	json.NewDecoder(uploadToSite(t, upload, "report", archive).Body).Decode(&deployment)
+
+	report := deployment.Upload
+	if report == nil {
+		t.Fatal("expected an upload report in the response")
+	}
+	if report.FilesExtracted != 2 || report.TotalBytes != int64(len("<html></html>")+len("body{}")) {
+		t.Errorf("expected 2 files of 19 bytes, got %d of %d", report.FilesExtracted, report.TotalBytes)
+	}
+	if report.SkippedCount != 1 || len(report.Skipped) != 1 || report.Skipped[0] != (models.SkippedEntry{Path: "../../evil.html", Reason: models.SkipUnsafePath}) {
+		t.Errorf("expected the traversal entry to be reported skipped, got %+v", report.Skipped)
+	}
+	if len(report.Warnings) != 1 {
+		t.Errorf("expected a warning about the skipped entry, got %v", report.Warnings)
+	}
+	if report.Scanned {
+		t.Error("expected the upload not to be reported scanned without UPLOAD_SCAN")
+	}
+}
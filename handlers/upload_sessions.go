@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UploadChunk is a chunk of a chunked upload the server holds
+type UploadChunk struct {
+	Chunk  int   `json:"chunk"`
+	Size   int64 `json:"size"`
+	Offset int64 `json:"offset"` // -1 while an earlier chunk is missing
+}
+
+// UploadSession is what a client needs to resume a chunked upload
+type UploadSession struct {
+	UploadID      string        `json:"upload_id"`
+	BytesReceived int64         `json:"bytes_received"`
+	Offset        int64         `json:"offset"` // bytes held without gaps, where a PATCH resumes
+	Length        int64         `json:"length"` // -1 when the client declared none
+	Chunks        []UploadChunk `json:"chunks"`
+	Missing       []int         `json:"missing"` // gaps below the highest chunk
+	UpdatedAt     time.Time     `json:"updated_at"`
+}
+
+// uploadSession returns the spool directory and owner recorded for a
+// chunked upload, or sql.ErrNoRows if it has none
+func uploadSession(db *sql.DB, id string) (dir, ownerID string, err error) {
+	err = db.QueryRow("SELECT spool_dir, owner_id FROM upload_sessions WHERE id = ?", id).Scan(&dir, &ownerID)
+	return dir, ownerID, err
+}
+
+// createUploadSession records a new upload, to be stored in dir, of length
+// bytes, or -1 when unknown
+func createUploadSession(db *sql.DB, id, dir, ownerID string, length int64) error {
+	now := time.Now().UTC()
+	_, err := db.Exec("INSERT INTO upload_sessions (id, spool_dir, owner_id, length, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		id, dir, ownerID, length, now, now)
+	return err
+}
+
+// uploadLength returns the length declared for an upload, or -1 if none was
+func uploadLength(db *sql.DB, id string) int64 {
+	length := int64(-1)
+	db.QueryRow("SELECT length FROM upload_sessions WHERE id = ?", id).Scan(&length)
+	return length
+}
+
+// uploadOffset returns how many bytes of an upload are held from its start
+// without a gap, and the number of the chunk that would follow them
+func uploadOffset(chunks map[int]int64) (offset int64, next int) {
+	next = 1
+	for {
+		size, ok := chunks[next]
+		if !ok {
+			return offset, next
+		}
+		offset += size
+		next++
+	}
+}
+
+// recordChunk records that chunk n of an upload was stored in dir, so the
+// upload survives a restart even if SPOOL_DIR changes meanwhile
+func recordChunk(db *sql.DB, id string, n int, size int64, dir, ownerID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	if _, err := tx.Exec(`INSERT INTO upload_sessions (id, spool_dir, owner_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET updated_at = excluded.updated_at`, id, dir, ownerID, now, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO upload_chunks (upload_id, chunk, size) VALUES (?, ?, ?)
+		ON CONFLICT(upload_id, chunk) DO UPDATE SET size = excluded.size`, id, n, size); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordedChunks returns the sizes of the chunks recorded for an upload
+func recordedChunks(db *sql.DB, id string) (map[int]int64, error) {
+	rows, err := db.Query("SELECT chunk, size FROM upload_chunks WHERE upload_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	chunks := map[int]int64{}
+	for rows.Next() {
+		var n int
+		var size int64
+		if err := rows.Scan(&n, &size); err != nil {
+			return nil, err
+		}
+		chunks[n] = size
+	}
+	return chunks, rows.Err()
+}
+
+// forgetUpload removes what is recorded of a chunked upload
+func forgetUpload(db *sql.DB, id string) {
+	if _, err := db.Exec("DELETE FROM upload_chunks WHERE upload_id = ?", id); err != nil {
+		log.Printf("Warning: Failed to forget chunks of upload %s: %v", id, err)
+	}
+	if _, err := db.Exec("DELETE FROM upload_sessions WHERE id = ?", id); err != nil {
+		log.Printf("Warning: Failed to forget upload %s: %v", id, err)
+	}
+}
+
+// pruneUploadSessions forgets uploads left incomplete for longer than
+// chunkUploadTTL and removes their chunks, wherever they were spooled
+func pruneUploadSessions(db *sql.DB) {
+	rows, err := db.Query("SELECT id, spool_dir FROM upload_sessions WHERE updated_at < ?", time.Now().UTC().Add(-chunkUploadTTL))
+	if err != nil {
+		return
+	}
+	expired := map[string]string{}
+	for rows.Next() {
+		var id, dir string
+		if rows.Scan(&id, &dir) == nil {
+			expired[id] = dir
+		}
+	}
+	rows.Close()
+	for id, dir := range expired {
+		os.RemoveAll(dir)
+		forgetUpload(db, id)
+	}
+}
+
+// restoreUpload returns the tracker of a chunked upload, starting one if it
+// has none or its last upload finished. A tracker started after a restart
+// counts the chunks received before it.
+func restoreUpload(db *sql.DB, id string) *uploadTracker {
+	if t := lookupUpload(id); t != nil && !t.finishedBefore(time.Now()) {
+		return t
+	}
+	t := startUpload(id, -1)
+	chunks, _ := recordedChunks(db, id)
+	for _, size := range chunks {
+		t.received.Add(size)
+	}
+	return t
+}
+
+// UploadSessionHandler reports the chunks the server holds for a chunked
+// upload, with their offsets in the joined archive, so a client can resend
+// only what is missing, including after a restart. The Upload-Offset
+// header is where a PATCH resumes; HEAD returns only the headers.
+// Expected: GET /uploads/{id}
+func UploadSessionHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/uploads/"), "/")
+	if err := uploadIDError(id); err != nil {
+		writeFieldErrors(w, http.StatusBadRequest, *err)
+		return
+	}
+
+	session := UploadSession{UploadID: id, Chunks: []UploadChunk{}, Missing: []int{}}
+	var ownerID string
+	err := db.QueryRow("SELECT owner_id, length, updated_at FROM upload_sessions WHERE id = ?", id).Scan(&ownerID, &session.Length, &session.UpdatedAt)
+	if err == sql.ErrNoRows || err == nil && !ownsDeployment(r, ownerID) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch upload", http.StatusInternalServerError)
+		return
+	}
+	chunks, err := recordedChunks(db, id)
+	if err != nil {
+		http.Error(w, "Failed to fetch upload", http.StatusInternalServerError)
+		return
+	}
+
+	numbers := make([]int, 0, len(chunks))
+	for n := range chunks {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	var offset int64
+	next := 1
+	for _, n := range numbers {
+		for ; next < n; next++ {
+			session.Missing = append(session.Missing, next)
+			offset = -1
+		}
+		next = n + 1
+		session.Chunks = append(session.Chunks, UploadChunk{Chunk: n, Size: chunks[n], Offset: offset})
+		session.BytesReceived += chunks[n]
+		if offset >= 0 {
+			offset += chunks[n]
+		}
+	}
+
+	session.Offset, _ = uploadOffset(chunks)
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	if session.Length >= 0 {
+		w.Header().Set("Upload-Length", strconv.FormatInt(session.Length, 10))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		return
+	}
+	json.NewEncoder(w).Encode(session)
+}
+
+// spoolChunkDir is where the chunks of an upload go and who owns it: where
+// its first chunk went, or the current spool directory for a new upload
+func spoolChunkDir(db *sql.DB, r *http.Request, id string) (dir, ownerID string, err error) {
+	dir, ownerID, err = uploadSession(db, id)
+	if err == sql.ErrNoRows {
+		dir, err = filepath.Abs(chunkDir(id))
+		return dir, requestOwner(r), err
+	}
+	return dir, ownerID, err
+}
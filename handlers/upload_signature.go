@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Signed uploads carry the Unix time they were made and the hex
+// HMAC-SHA256, keyed with UPLOAD_SIGNING_SECRET, of that time, the request
+// as canonicalUploadRequest describes it and the hex SHA-256 of the request
+// body, each on a line of its own
+const (
+	uploadTimestampHeader = "X-Upload-Timestamp"
+	uploadSignatureHeader = "X-Upload-Signature"
+)
+
+const (
+	errUploadSignature        = "Invalid upload signature"
+	errUploadSignatureExpired = "Upload signature expired"
+)
+
+var errSignatureMismatch = errors.New("upload signature doesn't match its body")
+
+// uploadSignature returns the signature of request, as described by
+// canonicalUploadRequest, with a body hashing to bodySHA256 sent at timestamp
+func uploadSignature(secret string, timestamp int64, request, bodySHA256 string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, strconv.FormatInt(timestamp, 10)+"\n"+request+"\n"+bodySHA256)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// canonicalUploadRequest describes what a signature binds a body to: the
+// method, the escaped path and the query parameters sorted by name, each on
+// a line of its own. A signature made for one upload, site or deployment
+// ID can't be replayed on another.
+func canonicalUploadRequest(r *http.Request) string {
+	return r.Method + "\n" + r.URL.EscapedPath() + "\n" + r.URL.Query().Encode()
+}
+
+// verifyUploadSignature checks an upload's signature headers, returning
+// why they are refused if they are, and hashes its body as it is read. The
+// returned function drains what is left of the body and reports whether
+// the signature matches; nothing the upload contains may be acted on
+// before it has returned nil. With no signing secret configured every
+// upload is accepted.
+func verifyUploadSignature(r *http.Request) (func() error, string) {
+	if cfg.UploadSigningSecret == "" {
+		return func() error { return nil }, ""
+	}
+	timestamp, err := strconv.ParseInt(r.Header.Get(uploadTimestampHeader), 10, 64)
+	signature := r.Header.Get(uploadSignatureHeader)
+	if err != nil || signature == "" {
+		return nil, errUploadSignature
+	}
+	// Bounding the age limits how long a captured upload can be replayed
+	if age := time.Since(time.Unix(timestamp, 0)); age > cfg.UploadSignatureMaxAge || age < -cfg.UploadSignatureMaxAge {
+		return nil, errUploadSignatureExpired
+	}
+
+	body := &hashingBody{ReadCloser: r.Body, hash: sha256.New(), request: canonicalUploadRequest(r), timestamp: timestamp, signature: signature}
+	r.Body = body
+	return body.verify, ""
+}
+
+// checkUploadSignature drains the body of a request publishing content and
+// reports whether it is signed. Every route publishing a deployment gets
+// here before the deployment is recorded, so one that didn't set up its
+// signature with verifyUploadSignature publishes nothing while uploads
+// must be signed.
+func checkUploadSignature(r *http.Request) error {
+	if cfg.UploadSigningSecret == "" {
+		return nil
+	}
+	body, ok := r.Body.(*hashingBody)
+	if !ok {
+		return errSignatureMismatch
+	}
+	return body.verify()
+}
+
+// requireUploadSignature checks the signature of a request whose body is
+// read in full before it publishes anything, such as one without a body.
+// It answers the request, failing progress if there is one, and returns
+// false when the request isn't signed.
+func requireUploadSignature(w http.ResponseWriter, r *http.Request, progress *uploadTracker) bool {
+	verified, problem := verifyUploadSignature(r)
+	if problem == "" && verified() != nil {
+		problem = errUploadSignature
+	}
+	if problem != "" {
+		progress.fail(problem)
+		http.Error(w, problem, http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// signedBody returns the body of r for storing as it is read, as chunks
+// are. While uploads must be signed it fails with errSignatureMismatch in
+// place of io.EOF unless the body matches its signature, so a chunk that
+// isn't signed is never kept.
+func signedBody(r *http.Request) io.ReadCloser {
+	if body, ok := r.Body.(*hashingBody); ok {
+		return eofVerifier{body}
+	}
+	return r.Body
+}
+
+type eofVerifier struct{ *hashingBody }
+
+func (v eofVerifier) Read(p []byte) (int, error) {
+	n, err := v.hashingBody.Read(p)
+	if err == io.EOF {
+		if mismatch := v.matches(); mismatch != nil {
+			err = mismatch
+		}
+	}
+	return n, err
+}
+
+type hashingBody struct {
+	io.ReadCloser
+	hash      hash.Hash
+	request   string
+	timestamp int64
+	signature string
+
+	checked bool
+	err     error
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	return n, err
+}
+
+// verify drains the body and reports whether it matches its signature.
+// Once drained the body isn't read again, so uploads deployed in the
+// background can be checked after the request has ended.
+func (b *hashingBody) verify() error {
+	if b.checked {
+		return b.err
+	}
+	b.checked = true
+	if _, b.err = io.Copy(io.Discard, b); b.err != nil {
+		return b.err
+	}
+	b.err = b.matches()
+	return b.err
+}
+
+// matches reports whether what has been read of the body matches its
+// signature
+func (b *hashingBody) matches() error {
+	expected := uploadSignature(cfg.UploadSigningSecret, b.timestamp, b.request, hex.EncodeToString(b.hash.Sum(nil)))
+	if !hmac.Equal([]byte(expected), []byte(b.signature)) {
+		return errSignatureMismatch
+	}
+	return nil
+}
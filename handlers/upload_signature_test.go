@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"static-site-hosting/artifacts"
+	"static-site-hosting/models"
+)
+
+// signRequest signs req, whose body is body, as a client holding secret
+// would at the time at
+func signRequest(req *http.Request, body []byte, secret string, at time.Time) {
+	sum := sha256.Sum256(body)
+	req.Header.Set(uploadTimestampHeader, strconv.FormatInt(at.Unix(), 10))
+	req.Header.Set(uploadSignatureHeader, uploadSignature(secret, at.Unix(), canonicalUploadRequest(req), hex.EncodeToString(sum[:])))
+}
+
+func TestSignedUploads(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	cfg.UploadSigningSecret = "cluster-secret"
+	defer func() { cfg.UploadSigningSecret = "" }()
+
+	archive := testZipBytes(t)
+	upload := func(sign func(req *http.Request, body []byte)) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "site.zip")
+		part.Write(archive)
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(body.Bytes()))
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		if sign != nil {
+			sign(req, body.Bytes())
+		}
+		rr := httptest.NewRecorder()
+		UploadHandler(rr, req, db)
+		return rr
+	}
+	signAt := func(secret string, at time.Time, tamper bool) func(*http.Request, []byte) {
+		return func(req *http.Request, body []byte) {
+			signRequest(req, body, secret, at)
+			if tamper {
+				body[len(body)/2] ^= 1
+			}
+		}
+	}
+
+	for name, tt := range map[string]struct {
+		sign     func(*http.Request, []byte)
+		expected int
+	}{
+		"unsigned":     {nil, http.StatusUnauthorized},
+		"wrong secret": {signAt("guess", time.Now(), false), http.StatusUnauthorized},
+		"expired":      {signAt("cluster-secret", time.Now().Add(-time.Hour), false), http.StatusUnauthorized},
+		"tampered":     {signAt("cluster-secret", time.Now(), true), http.StatusUnauthorized},
+		"signed":       {signAt("cluster-secret", time.Now(), false), http.StatusOK},
+	} {
+		if rr := upload(tt.sign); rr.Code != tt.expected {
+			t.Errorf("%s: expected status %d, got %d: %s", name, tt.expected, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestSigningCoversEveryPublishingRoute(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	savedArtifacts := artifacts.Default
+	defer func() { artifacts.Default = savedArtifacts }()
+	artifacts.Default = artifacts.Local{Dir: t.TempDir()}
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.WebDAVReadWrite = true
+
+	// Made before uploads must be signed
+	var source models.Deployment
+	deploy := func(w http.ResponseWriter, r *http.Request) { SiteDeploymentsHandler(w, r, db) }
+	json.NewDecoder(putArchive(deploy, "docs", "application/gzip", createTestTarGz(t)).Body).Decode(&source)
+	if source.ID == "" {
+		t.Fatal("failed to create deployment")
+	}
+	rr := httptest.NewRecorder()
+	SiteExportHandler(rr, httptest.NewRequest(http.MethodGet, "/sites/docs/export?deployments=1", nil), db)
+	bundle := rr.Body.Bytes()
+	archiveServer := withArchiveServer(t, createTestTarGz(t))
+	netlify := writeZip(t, map[string]string{"index.html": "home", "_redirects": "/old /index.html 301\n"})
+
+	cfg.UploadSigningSecret = "cluster-secret"
+
+	for _, tt := range []struct {
+		name        string
+		handler     func(http.ResponseWriter, *http.Request, *sql.DB)
+		method      string
+		contentType string
+		header      map[string]string
+		prepare     func() (path string, body []byte)
+	}{
+		{"raw archive", SiteDeploymentsHandler, http.MethodPut, "application/zip", nil, func() (string, []byte) {
+			v2, _ := os.ReadFile(writeZip(t, map[string]string{"index.html": "<html>raw</html>"}))
+			return "/sites/docs/deployments", v2
+		}},
+		{"archive url", DeployURLHandler, http.MethodPost, "application/json", nil, func() (string, []byte) {
+			return "/deploy/url", []byte(`{"url": "` + archiveServer.URL + `/site.tar.gz", "site": "docs"}`)
+		}},
+		{"chunked upload", CompleteUploadHandler, http.MethodPost, "", nil, func() (string, []byte) {
+			archive := createTestTarGz(t)
+			req := httptest.NewRequest(http.MethodPut, "/uploads/signed-chunks/chunks/1", bytes.NewReader(archive))
+			signRequest(req, archive, cfg.UploadSigningSecret, time.Now())
+			UploadChunkHandler(httptest.NewRecorder(), req, db)
+			return "/uploads/signed-chunks/complete?site=docs", nil
+		}},
+		{"resumable upload", CompleteUploadHandler, http.MethodPost, "", nil, func() (string, []byte) {
+			archive := createTestTarGz(t)
+			req := httptest.NewRequest(http.MethodPost, "/uploads", nil)
+			req.Header.Set("Upload-Length", strconv.Itoa(len(archive)))
+			rr := httptest.NewRecorder()
+			CreateUploadHandler(rr, req, db)
+			location := rr.Header().Get("Location")
+			req = httptest.NewRequest(http.MethodPatch, location, bytes.NewReader(archive))
+			req.Header.Set("Upload-Offset", "0")
+			signRequest(req, archive, cfg.UploadSigningSecret, time.Now())
+			UploadPatchHandler(httptest.NewRecorder(), req, db)
+			return location + "/complete?site=docs", nil
+		}},
+		{"artifact extract", DeploymentArtifactHandler, http.MethodPost, "", nil, func() (string, []byte) {
+			return "/deployments/" + source.ID + "/artifact/extract", nil
+		}},
+		{"migration", SiteMigrateHandler, http.MethodPost, "application/zip", nil, func() (string, []byte) {
+			archive, _ := os.ReadFile(netlify)
+			return "/sites/docs/migrate", archive
+		}},
+		{"import", SiteImportHandler, http.MethodPost, "", nil, func() (string, []byte) {
+			return "/sites/import?site=docs-copy", bundle
+		}},
+		{"file edit", DeploymentFileHandler, http.MethodPut, "", map[string]string{"If-None-Match": "*"}, func() (string, []byte) {
+			return "/deployments/" + source.ID + "/files/new.txt", []byte("new")
+		}},
+		{"webdav write", WebDAVHandler, http.MethodPut, "", nil, func() (string, []byte) {
+			return "/dav/" + source.ID + "/dav.txt", []byte("dav")
+		}},
+	} {
+		path, body := tt.prepare()
+		request := func(signed bool) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(tt.method, path, bytes.NewReader(body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			if signed {
+				signRequest(req, body, cfg.UploadSigningSecret, time.Now())
+			}
+			rr := httptest.NewRecorder()
+			tt.handler(rr, req, db)
+			return rr
+		}
+
+		var before, after int
+		db.QueryRow("SELECT COUNT(*) FROM deployments").Scan(&before)
+		if rr := request(false); rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected an unsigned request to be refused, got %d: %s", tt.name, rr.Code, rr.Body.String())
+		}
+		db.QueryRow("SELECT COUNT(*) FROM deployments").Scan(&after)
+		if after != before {
+			t.Errorf("%s: expected nothing to be published unsigned", tt.name)
+		}
+		if rr := request(true); rr.Code >= http.StatusMultipleChoices {
+			t.Errorf("%s: expected a signed request to publish, got %d: %s", tt.name, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestUploadSignaturesBindTheRequest(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	cfg.UploadSigningSecret = "cluster-secret"
+	defer func() { cfg.UploadSigningSecret = "" }()
+
+	archive := createTestTarGz(t)
+	chunk := func(path string, sign func(*http.Request)) int {
+		req := httptest.NewRequest(http.MethodPut, path, bytes.NewReader(archive))
+		if sign != nil {
+			sign(req)
+		}
+		rr := httptest.NewRecorder()
+		UploadChunkHandler(rr, req, db)
+		return rr.Code
+	}
+
+	// Chunks are only kept when signed
+	if code := chunk("/uploads/bound/chunks/1", nil); code != http.StatusUnauthorized {
+		t.Errorf("expected an unsigned chunk to be refused, got %d", code)
+	}
+	if code := chunk("/uploads/bound/chunks/1", func(req *http.Request) {
+		signRequest(req, archive[:len(archive)-1], cfg.UploadSigningSecret, time.Now())
+	}); code != http.StatusUnauthorized {
+		t.Errorf("expected a chunk not matching its signature to be refused, got %d", code)
+	}
+	var held int
+	db.QueryRow("SELECT COUNT(*) FROM upload_chunks WHERE upload_id = 'bound'").Scan(&held)
+	if held != 0 {
+		t.Errorf("expected no chunk to be recorded, got %d", held)
+	}
+	if code := chunk("/uploads/bound/chunks/1", func(req *http.Request) {
+		signRequest(req, archive, cfg.UploadSigningSecret, time.Now())
+	}); code != http.StatusCreated {
+		t.Fatalf("expected a signed chunk to be kept, got %d", code)
+	}
+
+	// A signature made for one request doesn't carry over to another
+	signed := httptest.NewRequest(http.MethodPost, "/uploads/bound/complete?site=docs", nil)
+	signRequest(signed, nil, cfg.UploadSigningSecret, time.Now())
+	for _, path := range []string{
+		"/uploads/bound/complete?site=other",
+		"/uploads/bound/complete?site=docs&filename=other.zip",
+		"/uploads/other/complete?site=docs",
+	} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header = signed.Header.Clone()
+		rr := httptest.NewRecorder()
+		CompleteUploadHandler(rr, req, db)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected a replayed signature to be refused, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+	}
+	rr := httptest.NewRecorder()
+	CompleteUploadHandler(rr, signed, db)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected the signed request to deploy, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"os"
 	"path/filepath"
 	"static-site-hosting/models"
+	"static-site-hosting/routecache"
+	"static-site-hosting/schema"
 	"strings"
 	"testing"
 
@@ -19,25 +21,14 @@ import (
 )
 
 func setupTestDB(t *testing.T) *sql.DB {
-	// Create in-memory SQLite database for testing
-	db, err := sql.Open("sqlite3", ":memory:")
+	// The production schema, in memory
+	db, err := schema.OpenMemory()
 	if err != nil {
 		t.Fatalf("Failed to create test database: %v", err)
 	}
 
-	// Create tables
-	createDeploymentsTable := `
-	CREATE TABLE deployments (
-		id TEXT PRIMARY KEY,
-		filename TEXT NOT NULL,
-		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-		path TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	)`
-
-	if _, err := db.Exec(createDeploymentsTable); err != nil {
-		t.Fatalf("Failed to create deployments table: %v", err)
-	}
+	// Lookups cached by earlier tests refer to their databases
+	routecache.Invalidate()
 
 	return db
 }
@@ -146,8 +137,13 @@ func TestUploadHandler(t *testing.T) {
 	expectedFiles := []string{"index.html", "style.css", "script.js"}
 	for _, filename := range expectedFiles {
 		filePath := filepath.Join(deployment.Path, filename)
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		info, err := os.Stat(filePath)
+		if os.IsNotExist(err) {
 			t.Errorf("expected file %s to exist in deployment", filename)
+			continue
+		}
+		if info.Mode().Perm()&0222 != 0 {
+			t.Errorf("expected file %s to be read-only, got %o", filename, info.Mode().Perm())
 		}
 	}
 }
@@ -189,6 +185,44 @@ func TestUploadHandlerNoFile(t *testing.T) {
 	}
 }
 
+func TestUploadHandlerFieldErrors(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("site", "api")
+	writer.WriteField("deployment_id", "mine")
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	UploadHandler(rr, req, db)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected a JSON body, got %q", ct)
+	}
+
+	var resp fieldErrorsResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	want := []models.FieldError{
+		{Field: "file", Code: models.CodeMissing},
+		{Field: "site", Code: models.CodeReserved},
+		{Field: "deployment_id", Code: models.CodeDisabled},
+	}
+	if len(resp.Errors) != len(want) {
+		t.Fatalf("expected %d errors, got %+v", len(want), resp.Errors)
+	}
+	for i, e := range resp.Errors {
+		if e.Field != want[i].Field || e.Code != want[i].Code {
+			t.Errorf("expected %s %s, got %+v", want[i].Field, want[i].Code, e)
+		}
+	}
+}
+
 func TestUploadHandlerWithFilename(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
@@ -288,3 +322,90 @@ func TestUploadHandlerEmptyFilename(t *testing.T) {
 		t.Errorf("expected 'Invalid file' error message, got: %s", rr.Body.String())
 	}
 }
+
+func TestUploadHandlerTarGz(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "site.tar.gz")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	part.Write(createTestTarGz(t))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	UploadHandler(rr, req, db)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+	var deployment models.Deployment
+	if err := json.NewDecoder(rr.Body).Decode(&deployment); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	for _, name := range []string{"index.html", "css/style.css"} {
+		if _, err := os.Stat(filepath.Join(deployment.Path, name)); err != nil {
+			t.Errorf("expected %s to be extracted: %v", name, err)
+		}
+	}
+}
+
+func TestUploadHandlerRejectsUnknownFormat(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "site.rar")
+	part.Write([]byte("definitely not an archive"))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	UploadHandler(rr, req, db)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rr.Code)
+	}
+}
+
+func TestUploadHandlerRejectsTraversal(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	archive, _ := os.ReadFile(writeZip(t, map[string]string{
+		"index.html":      "<html></html>",
+		"../../evil.html": "<script></script>",
+	}))
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "site.zip")
+	part.Write(archive)
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	UploadHandler(rr, req, db)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "../../evil.html") {
+		t.Errorf("expected the rejected entry to be named, got %s", rr.Body.String())
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM deployments").Scan(&count)
+	if count != 0 {
+		t.Errorf("expected no deployment to be recorded, got %d", count)
+	}
+}
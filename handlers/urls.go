@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"database/sql"
+	"net"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"static-site-hosting/models"
+	"static-site-hosting/routecache"
+)
+
+// publicBaseURL returns the API's external scheme and host, from the
+// configuration or else from the request
+func publicBaseURL(r *http.Request) string {
+	if cfg.PublicBaseURL != "" {
+		return cfg.PublicBaseURL
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// withURLs fills in the public URLs of d for a response to r
+func withURLs(r *http.Request, d *models.Deployment) *models.Deployment {
+	base := publicBaseURL(r)
+	scheme, _, _ := strings.Cut(base, "://")
+
+	urls := &models.DeploymentURLs{Path: base + "/s/" + d.ID + "/"}
+	if cfg.StaticRootServing {
+		urls.Root = base + "/" + d.ID + "/"
+	}
+	if cfg.StaticHost != "" {
+		urls.StaticHost = scheme + "://" + cfg.StaticHost + "/" + d.ID + "/"
+	}
+	if cfg.SiteDomain != "" {
+		urls.Subdomain = scheme + "://" + d.ID + "." + cfg.SiteDomain + "/"
+		if d.Site != "" {
+			urls.SiteSubdomain = scheme + "://" + d.Site + "." + cfg.SiteDomain + "/"
+		}
+	}
+	if d.Site != "" {
+		for host, site := range cfg.CustomDomains {
+			if site == d.Site {
+				urls.CustomDomains = append(urls.CustomDomains, scheme+"://"+strings.ToLower(host)+"/")
+			}
+		}
+		sort.Strings(urls.CustomDomains)
+	}
+	d.URLs = urls
+	return d
+}
+
+// SiteHostHandler serves requests addressed to a deployment or site by host
+// name ({id}.{SITE_DOMAIN}, {slug}.{SITE_DOMAIN} or a custom domain) from
+// the static file handler, and passes every other request to next.
+func SiteHostHandler(db *sql.DB, next http.Handler) http.Handler {
+	static := StaticFileHandler(db)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		label, ok := siteHostLabel(r.Host)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		deploymentID, ok := resolveHostDeployment(db, label)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		// The path is joined onto the deployment's ID below, so dot
+		// segments must not climb out of it into another deployment
+		p := path.Clean("/" + r.URL.Path)
+		if slices.Contains(strings.Split(p, "/"), "..") {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/") && p != "/" {
+			p += "/"
+		}
+		if strings.HasSuffix(p, "/") {
+			p += "index.html"
+		}
+		ctx := r.Context()
+		if deploymentID != label {
+			ctx = withSiteHost(ctx)
+		}
+		r2 := r.Clone(ctx)
+		r2.URL.Path = "/" + deploymentID + p
+		r2.URL.RawPath = ""
+		static.ServeHTTP(w, r2)
+	})
+}
+
+// IsSiteHost reports whether r is addressed to a site by host name
+func IsSiteHost(r *http.Request) bool {
+	_, ok := siteHostLabel(r.Host)
+	return ok
+}
+
+// siteHostLabel returns the deployment ID or site slug a host name stands
+// for. Custom domains are returned as their slug.
+func siteHostLabel(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	for domain, site := range cfg.CustomDomains {
+		if strings.ToLower(domain) == host {
+			return site, true
+		}
+	}
+	if cfg.SiteDomain == "" {
+		return "", false
+	}
+	label, ok := strings.CutSuffix(host, "."+cfg.SiteDomain)
+	if !ok || label == "" || strings.Contains(label, ".") {
+		return "", false
+	}
+	return label, true
+}
+
+// resolveHostDeployment finds the deployment a host label serves: the
+// deployment with that ID, or else the live deployment of the site
+func resolveHostDeployment(db *sql.DB, label string) (string, bool) {
+	id, err := routecache.Lookup("host:"+label, func() (string, error) {
+		var id string
+		err := db.QueryRow("SELECT id FROM deployments WHERE id = ?", label).Scan(&id)
+		if err != sql.ErrNoRows || !models.ValidSiteSlug(label) {
+			return id, err
+		}
+		d, err := latestSiteDeployment(db, label)
+		if err != nil {
+			return "", err
+		}
+		return d.ID, nil
+	})
+	return id, err == nil
+}
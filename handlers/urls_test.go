@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"static-site-hosting/models"
+)
+
+func TestWithURLs(t *testing.T) {
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.PublicBaseURL = "https://api.example.com"
+	cfg.StaticHost = "static.example.com"
+	cfg.SiteDomain = "sites.example.com"
+	cfg.CustomDomains = map[string]string{"www.docs.io": "docs", "docs.io": "docs", "blog.io": "blog"}
+
+	d := withURLs(httptest.NewRequest(http.MethodGet, "/deployments", nil), &models.Deployment{ID: "abc", Site: "docs"})
+	expected := &models.DeploymentURLs{
+		Path:          "https://api.example.com/s/abc/",
+		Root:          "https://api.example.com/abc/",
+		StaticHost:    "https://static.example.com/abc/",
+		Subdomain:     "https://abc.sites.example.com/",
+		SiteSubdomain: "https://docs.sites.example.com/",
+		CustomDomains: []string{"https://docs.io/", "https://www.docs.io/"},
+	}
+	if !reflect.DeepEqual(d.URLs, expected) {
+		t.Errorf("expected %+v, got %+v", expected, d.URLs)
+	}
+
+	cfg.PublicBaseURL = ""
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/deployments", nil)
+	if got := withURLs(req, &models.Deployment{ID: "abc"}).URLs.Path; got != "http://localhost:8080/s/abc/" {
+		t.Errorf("expected a URL from the request host, got %q", got)
+	}
+}
+
+func TestSiteHostHandler(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.SiteDomain = "sites.test"
+	cfg.CustomDomains = map[string]string{"docs.example.org": "docs"}
+
+	for i, id := range []string{"old", "new"} {
+		dir := filepath.Join("deployments", id)
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, "index.html"), []byte(id), 0644)
+		db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES (?, ?, ?, ?, 'docs')",
+			id, "site.zip", time.Now().Add(time.Duration(i)*time.Minute), dir)
+	}
+
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("api")) })
+	handler := SiteHostHandler(db, api)
+
+	tests := []struct {
+		url  string
+		body string
+	}{
+		{"http://old.sites.test/", "old"},
+		{"http://docs.sites.test/index.html", "new"},
+		{"http://docs.example.org:8080/", "new"},
+		{"http://localhost/deployments", "api"},
+		{"http://a.b.sites.test/", "api"},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if rr.Body.String() != tt.body {
+			t.Errorf("%s: expected %q, got %d %q", tt.url, tt.body, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://missing.sites.test/", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown site, got %d", rr.Code)
+	}
+}
+
+func TestAdvertisedURLsServe(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.PublicBaseURL = "http://api.test"
+	cfg.StaticHost = "static.test"
+	cfg.StaticRootServing = true
+	cfg.SiteDomain = "sites.test"
+	cfg.CustomDomains = map[string]string{"docs.example.org": "docs"}
+
+	dir := filepath.Join("deployments", "abc")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("abc"), 0644)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES ('abc', 'site.zip', ?, ?, 'docs')", time.Now(), dir)
+
+	// Routed as cmd/main.go routes sites
+	static := StaticFileHandler(db)
+	mux := http.NewServeMux()
+	mux.Handle("/s/", http.StripPrefix("/s", static))
+	mux.Handle("static.test/", static)
+	mux.Handle("/", static)
+	handler := SiteHostHandler(db, mux)
+
+	urls := withURLs(httptest.NewRequest(http.MethodGet, "/deployments", nil), &models.Deployment{ID: "abc", Site: "docs"}).URLs
+	for _, u := range append([]string{urls.Path, urls.Root, urls.StaticHost, urls.Subdomain, urls.SiteSubdomain}, urls.CustomDomains...) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, u, nil))
+		if rr.Code != http.StatusOK || rr.Body.String() != "abc" {
+			t.Errorf("%s: expected the site's index.html, got %d %q", u, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestSiteHostHandlerStaysInsideTheDeployment(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.SiteDomain = "sites.test"
+
+	for _, d := range []struct{ id, site, visibility string }{
+		{"pub", "pub", models.VisibilityPublic},
+		{"priv", "priv", models.VisibilityPrivate},
+	} {
+		dir := filepath.Join("deployments", d.id)
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, "index.html"), []byte(d.id), 0644)
+		db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site, owner_id, visibility) VALUES (?, 'site.zip', ?, ?, ?, 'alice', ?)",
+			d.id, time.Now(), dir, d.site, d.visibility)
+	}
+	handler := SiteHostHandler(db, http.NotFoundHandler())
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://priv.sites.test/index.html", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the private site to need authentication, got %d", rr.Code)
+	}
+
+	for _, u := range []string{
+		"http://pub.sites.test/../priv/index.html",
+		"http://pub.sites.test/%2e%2e/priv/index.html",
+		"http://pub.sites.test/a/../../priv/index.html",
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, u, nil))
+		if rr.Code == http.StatusOK || strings.Contains(rr.Body.String(), "priv") {
+			t.Errorf("%s: expected the private site to stay out of reach, got %d %q", u, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://pub.sites.test/a/../", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "pub" {
+		t.Errorf("expected dot segments within the site to resolve, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestWithinRoot(t *testing.T) {
+	tests := []struct {
+		full string
+		ok   bool
+	}{
+		{"deployments/abc/index.html", true},
+		{"deployments/abc", true},
+		{"deployments/abcd/index.html", false},
+		{"deployments/other/index.html", false},
+		{"deployments", false},
+	}
+	for _, tt := range tests {
+		if got := withinRoot("deployments/abc", filepath.FromSlash(tt.full)); got != tt.ok {
+			t.Errorf("%s: expected %v, got %v", tt.full, tt.ok, got)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+	"static-site-hosting/users"
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._@-]{0,63}$`)
+
+// registerMu serializes registrations, so two concurrent first accounts
+// can't both become admin
+var registerMu sync.Mutex
+
+// RegisterHandler creates a local account. The first account is an admin
+// and is logged in straight away. After that admins create accounts with
+// any role; anyone else may only register themselves with the default role,
+// and only when registration is open.
+// Expected: POST /auth/register {"username": "...", "password": "...", "email": "...", "name": "..."}
+func RegisterHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, signer *auth.Signer) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Email    string `json:"email"`
+		Name     string `json:"name"`
+		Role     string `json:"role"`
+		Tenant   string `json:"tenant"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	var errs models.ValidationErrors
+	username := users.Normalize(req.Username)
+	if username == "" {
+		errs.Add("username", models.CodeMissing, "username is required")
+	} else if !usernamePattern.MatchString(username) {
+		errs.Add("username", models.CodeInvalid, "username may only contain letters, digits, '.', '_', '@' and '-' (up to 64)")
+	}
+	if len(req.Password) < cfg.PasswordMinLength {
+		errs.Add("password", models.CodeInvalid, "password must be at least %d characters", cfg.PasswordMinLength)
+	}
+	if req.Role != "" && !auth.ValidRole(req.Role) {
+		errs.Add("role", models.CodeInvalid, "role must be admin, deployer or viewer")
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, http.StatusBadRequest, errs...)
+		return
+	}
+
+	registerMu.Lock()
+	defer registerMu.Unlock()
+
+	count, err := users.Count(db)
+	if err != nil {
+		http.Error(w, "Failed to create account", http.StatusInternalServerError)
+		return
+	}
+	byAdmin := isAdmin(r)
+
+	user := &models.User{Username: username, Email: req.Email, Name: req.Name, Role: cfg.UserDefaultRole}
+	switch {
+	case count == 0:
+		user.Role = auth.RoleAdmin
+	case byAdmin:
+		if req.Role != "" {
+			user.Role = req.Role
+		}
+		user.Tenant = req.Tenant
+	case !cfg.RegistrationOpen:
+		http.Error(w, "Registration is closed; ask an admin for an account", http.StatusForbidden)
+		return
+	case req.Role != "" && req.Role != cfg.UserDefaultRole, req.Tenant != "":
+		http.Error(w, "Only admins may choose a role or tenant", http.StatusForbidden)
+		return
+	}
+
+	if err := users.Create(db, user, req.Password); err == users.ErrUsernameTaken {
+		writeFieldErrors(w, http.StatusConflict, models.FieldError{Field: "username", Code: models.CodeInvalid, Message: "username is already taken"})
+		return
+	} else if err != nil {
+		log.Printf("Failed to create account %s: %v", username, err)
+		http.Error(w, "Failed to create account", http.StatusInternalServerError)
+		return
+	}
+
+	if byAdmin {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(user)
+		return
+	}
+	issueSession(w, r, signer, userClaims(user))
+}
+
+// LoginHandler authenticates a local account and issues a session token.
+// Wrong passwords count towards lockouts of the client IP and the username,
+// as for LDAP.
+// Expected: POST /auth/login {"username": "...", "password": "..."}
+func LoginHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, signer *auth.Signer, throttle *auth.Throttle) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	ipKey, userKey := throttle.IPKey(r), auth.UserKey(users.Normalize(req.Username))
+	if refuseThrottled(w, r, throttle, "local", req.Username, ipKey, userKey) {
+		return
+	}
+
+	user, err := users.Authenticate(db, req.Username, req.Password)
+	if err == users.ErrInvalidCredentials {
+		recordAuthFailure(r, throttle, "local", req.Username, "invalid credentials", ipKey, userKey)
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Login failed: %v", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+
+	throttle.Reset(userKey)
+	throttle.Audit(r, auth.AuditEntry{Event: auth.AuditLoginSucceeded, Provider: "local", Subject: user.Username})
+	issueSession(w, r, signer, userClaims(user))
+}
+
+// userClaims are the session claims of a local account
+func userClaims(u *models.User) auth.Claims {
+	return auth.Claims{
+		Subject:  u.Subject(),
+		Email:    u.Email,
+		Name:     u.Name,
+		Role:     u.Role,
+		Provider: "local",
+		Tenant:   u.Tenant,
+	}
+}
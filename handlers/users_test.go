@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+)
+
+// fastPasswords lowers the password hashing cost for the test
+func fastPasswords(t *testing.T) {
+	saved := auth.PasswordIterations
+	auth.PasswordIterations = 1000
+	t.Cleanup(func() { auth.PasswordIterations = saved })
+}
+
+func postJSON(handler func(http.ResponseWriter, *http.Request), path string, body any, claims *auth.Claims) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	if claims != nil {
+		req = req.WithContext(auth.WithClaims(req.Context(), claims))
+	}
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	return rr
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	fastPasswords(t)
+	signer := auth.NewSigner([]byte("secret"))
+	throttle := auth.NewThrottle(5, time.Minute, time.Hour)
+	register := func(w http.ResponseWriter, r *http.Request) { RegisterHandler(w, r, db, signer) }
+	login := func(w http.ResponseWriter, r *http.Request) { LoginHandler(w, r, db, signer, throttle) }
+
+	rr := postJSON(register, "/auth/register", map[string]string{"username": "Alice", "password": "correct horse"}, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the first account to be created, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var session struct {
+		Token     string `json:"token"`
+		CSRFToken string `json:"csrf_token"`
+		User      struct {
+			ID   string `json:"id"`
+			Role string `json:"role"`
+		} `json:"user"`
+	}
+	json.NewDecoder(rr.Body).Decode(&session)
+	if session.User.Role != auth.RoleAdmin || !strings.HasPrefix(session.User.ID, "user:") {
+		t.Errorf("expected the first account to be an admin, got %+v", session.User)
+	}
+
+	rr = postJSON(login, "/auth/login", map[string]string{"username": "alice", "password": "correct horse"}, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected login to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	json.NewDecoder(rr.Body).Decode(&session)
+	claims, err := signer.Verify(session.Token)
+	if err != nil || claims.Provider != "local" || claims.Role != auth.RoleAdmin {
+		t.Errorf("expected a local admin session, got %+v, %v", claims, err)
+	}
+	if session.CSRFToken != auth.CSRFToken(session.Token) {
+		t.Errorf("expected the session's CSRF token, got %q", session.CSRFToken)
+	}
+
+	for _, creds := range []map[string]string{
+		{"username": "alice", "password": "wrong"},
+		{"username": "bob", "password": "correct horse"},
+	} {
+		if rr := postJSON(login, "/auth/login", creds, nil); rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %d", creds["username"], rr.Code)
+		}
+	}
+}
+
+func TestRegisterPolicy(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	fastPasswords(t)
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	signer := auth.NewSigner([]byte("secret"))
+	register := func(w http.ResponseWriter, r *http.Request) { RegisterHandler(w, r, db, signer) }
+
+	postJSON(register, "/auth/register", map[string]string{"username": "admin", "password": "password1"}, nil)
+
+	cfg.RegistrationOpen = false
+	if rr := postJSON(register, "/auth/register", map[string]string{"username": "bob", "password": "password1"}, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403 while registration is closed, got %d", rr.Code)
+	}
+
+	cfg.RegistrationOpen = true
+	if rr := postJSON(register, "/auth/register", map[string]string{"username": "bob", "password": "password1", "role": "admin"}, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a self-chosen role, got %d", rr.Code)
+	}
+	if rr := postJSON(register, "/auth/register", map[string]string{"username": "bob", "password": "password1"}, nil); rr.Code != http.StatusOK {
+		t.Errorf("expected open registration to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	admin := &auth.Claims{Subject: "user:1", Role: auth.RoleAdmin}
+	rr := postJSON(register, "/auth/register", map[string]string{"username": "carol", "password": "password1", "role": "viewer"}, admin)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected an admin to create the account, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var user models.User
+	json.NewDecoder(rr.Body).Decode(&user)
+	if user.Role != auth.RoleViewer || user.PasswordHash != "" || strings.Contains(rr.Body.String(), "pbkdf2") {
+		t.Errorf("unexpected account %s", rr.Body.String())
+	}
+
+	var roles []string
+	rows, _ := db.Query("SELECT role FROM users ORDER BY created_at")
+	for rows.Next() {
+		var role string
+		rows.Scan(&role)
+		roles = append(roles, role)
+	}
+	rows.Close()
+	if strings.Join(roles, ",") != "admin,deployer,viewer" {
+		t.Errorf("expected roles admin,deployer,viewer, got %v", roles)
+	}
+}
+
+func TestRegisterValidation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	fastPasswords(t)
+	signer := auth.NewSigner([]byte("secret"))
+	register := func(w http.ResponseWriter, r *http.Request) { RegisterHandler(w, r, db, signer) }
+
+	rr := postJSON(register, "/auth/register", map[string]string{"username": "a b", "password": "short"}, nil)
+	var body struct {
+		Errors []models.FieldError `json:"errors"`
+	}
+	json.NewDecoder(rr.Body).Decode(&body)
+	if rr.Code != http.StatusBadRequest || len(body.Errors) != 2 {
+		t.Errorf("expected 400 with username and password errors, got %d %+v", rr.Code, body.Errors)
+	}
+
+	admin := &auth.Claims{Subject: "user:1", Role: auth.RoleAdmin}
+	postJSON(register, "/auth/register", map[string]string{"username": "alice", "password": "password1"}, admin)
+	if rr := postJSON(register, "/auth/register", map[string]string{"username": "ALICE", "password": "password1"}, admin); rr.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a taken username, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"static-site-hosting/validate"
+)
+
+// Report kinds stored in deployment_reports
+const (
+	reportValidation = "validation"
+	reportSmokeTest  = "smoke_test"
+)
+
+// deployValidators builds the validators configured for uploads
+func deployValidators() []validate.Validator {
+	var validators []validate.Validator
+	if len(cfg.ValidateRequiredFiles) > 0 {
+		validators = append(validators, validate.RequiredFiles{Paths: cfg.ValidateRequiredFiles})
+	}
+	if cfg.ValidateHTML {
+		validators = append(validators, validate.HTML{})
+	}
+	if len(cfg.ValidateForbiddenPatterns) > 0 {
+		// Patterns were checked when the configuration was loaded
+		var patterns []*regexp.Regexp
+		for _, p := range cfg.ValidateForbiddenPatterns {
+			patterns = append(patterns, regexp.MustCompile(p))
+		}
+		validators = append(validators, validate.ForbiddenContent{Patterns: patterns, MaxSize: cfg.ValidateMaxScanBytes})
+	}
+	return validators
+}
+
+// saveReport stores a report about a deployment, replacing any earlier
+// report of the same kind
+func saveReport(db *sql.DB, deploymentID, kind string, report any) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		"INSERT OR REPLACE INTO deployment_reports (deployment_id, kind, report, created_at) VALUES (?, ?, ?, ?)",
+		deploymentID, kind, string(data), time.Now().UTC(),
+	)
+	return err
+}
+
+// DeploymentReportHandler returns the reports recorded for a deployment,
+// keyed by kind.
+// Expected: GET /deployments/{id}/report
+func DeploymentReportHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	deploymentID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/deployments/"), "/report")
+	var status string
+	err := db.QueryRow("SELECT status FROM deployments WHERE id = ?", deploymentID).Scan(&status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch deployment", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Query("SELECT kind, report FROM deployment_reports WHERE deployment_id = ?", deploymentID)
+	if err != nil {
+		http.Error(w, "Failed to fetch reports", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	reports := map[string]json.RawMessage{}
+	for rows.Next() {
+		var kind, report string
+		if err := rows.Scan(&kind, &report); err != nil {
+			http.Error(w, "Failed to scan report", http.StatusInternalServerError)
+			return
+		}
+		reports[kind] = json.RawMessage(report)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"deployment_id": deploymentID,
+		"status":        status,
+		"reports":       reports,
+	})
+}
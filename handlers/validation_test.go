@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"static-site-hosting/models"
+	"static-site-hosting/validate"
+)
+
+func TestUploadFailingValidationIsNotServed(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	cfg.ValidateRequiredFiles = []string{"index.html", "404.html"}
+	cfg.ValidateForbiddenPatterns = []string{`hello\s+world`}
+	defer func() {
+		cfg.ValidateRequiredFiles = nil
+		cfg.ValidateForbiddenPatterns = nil
+	}()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "test-site.zip")
+	part.Write(testZipBytes(t))
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	UploadHandler(rr, req, db)
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status 422, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Deployment models.Deployment `json:"deployment"`
+		Report     validate.Report   `json:"report"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Deployment.Status != models.StatusFailed {
+		t.Errorf("expected status %q, got %q", models.StatusFailed, resp.Deployment.Status)
+	}
+	if resp.Report.Passed || len(resp.Report.Issues) != 2 {
+		t.Fatalf("expected a missing 404.html and a forbidden match, got %+v", resp.Report.Issues)
+	}
+	if issue := resp.Report.Issues[1]; issue.Path != "script.js" || issue.Line != 1 {
+		t.Errorf("expected forbidden content at script.js:1, got %+v", issue)
+	}
+
+	id := resp.Deployment.ID
+	rr = httptest.NewRecorder()
+	StaticFileHandler(db).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/"+id+"/index.html", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected failed deployment not to be served, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	DeploymentReportHandler(rr, httptest.NewRequest(http.MethodGet, "/deployments/"+id+"/report", nil), db)
+	var stored struct {
+		Status  string                     `json:"status"`
+		Reports map[string]validate.Report `json:"reports"`
+	}
+	json.NewDecoder(rr.Body).Decode(&stored)
+	if stored.Status != models.StatusFailed || len(stored.Reports[reportValidation].Issues) != 2 {
+		t.Errorf("expected stored validation report, got %+v", stored)
+	}
+
+	rr = httptest.NewRecorder()
+	RollbackHandler(rr, httptest.NewRequest(http.MethodPost, "/rollback/"+id, nil), db)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected rollback to a failed deployment to be refused, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+	"static-site-hosting/routecache"
+)
+
+// DeploymentVisibilityHandler makes a deployment public or private. Private
+// deployments are only served to callers who may see them: their owners,
+// members of the organization owning them, admins and share links.
+// Expected: PATCH /deployments/{id} {"visibility": "private"}
+func DeploymentVisibilityHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "PATCH required", http.StatusMethodNotAllowed)
+		return
+	}
+	deploymentID := strings.TrimPrefix(r.URL.Path, "/deployments/")
+
+	var req struct {
+		Visibility string `json:"visibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := visibilityError(req.Visibility); err != nil {
+		writeFieldErrors(w, http.StatusBadRequest, *err)
+		return
+	}
+
+	var ownerID string
+	err := db.QueryRow("SELECT owner_id FROM deployments WHERE id = ?", deploymentID).Scan(&ownerID)
+	if err == sql.ErrNoRows || err == nil && !ownsDeployment(r, ownerID) {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch deployment", http.StatusInternalServerError)
+		return
+	}
+	if _, err := db.Exec("UPDATE deployments SET visibility = ? WHERE id = ?", req.Visibility, deploymentID); err != nil {
+		http.Error(w, "Failed to update deployment", http.StatusInternalServerError)
+		return
+	}
+	routecache.Invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"deployment_id": deploymentID, "visibility": req.Visibility})
+}
+
+func visibilityError(v string) *models.FieldError {
+	if v == "" {
+		return &models.FieldError{Field: "visibility", Code: models.CodeMissing, Message: "visibility is required"}
+	}
+	if !models.ValidVisibility(v) {
+		return &models.FieldError{Field: "visibility", Code: models.CodeInvalid, Message: "visibility must be public or private"}
+	}
+	return nil
+}
+
+// deploymentVisibility returns the visibility of a new deployment of site:
+// the requested one if any, otherwise that of the site's latest deployment,
+// so redeploying a private site doesn't publish it, otherwise the default
+func deploymentVisibility(db *sql.DB, site, requested string) string {
+	if requested != "" {
+		return requested
+	}
+	if site != "" {
+		var visibility string
+		err := db.QueryRow("SELECT visibility FROM deployments WHERE site = ? ORDER BY timestamp DESC LIMIT 1", site).Scan(&visibility)
+		if err == nil {
+			return visibility
+		}
+	}
+	return cfg.DefaultVisibility
+}
+
+// deploymentPrivate reports whether a deployment is private, through the
+// routing cache, and who owns it. A deployment without a record, such as an
+// upload still being checked, and a failed lookup, including one during a
+// database outage for a deployment the cache doesn't know, are treated as
+// private to everyone but admins.
+func deploymentPrivate(db *sql.DB, deploymentID string) (string, bool) {
+	type entry struct {
+		Owner      string
+		Visibility string
+	}
+	found, err := routecache.Lookup("visibility:"+deploymentID, func() (entry, error) {
+		var e entry
+		err := db.QueryRow("SELECT owner_id, visibility FROM deployments WHERE id = ?", deploymentID).Scan(&e.Owner, &e.Visibility)
+		return e, err
+	})
+	if err != nil {
+		return "", true
+	}
+	return found.Owner, found.Visibility == models.VisibilityPrivate
+}
+
+// privateAuthorized reports whether the caller may see a private deployment
+// owned by ownerID. Anonymous callers never may, even where ANONYMOUS_ROLE
+// lets them own anonymous deployments, nor may tokens naming nobody.
+func privateAuthorized(r *http.Request, ownerID string) bool {
+	claims := auth.FromContext(r.Context())
+	return claims != nil && claims.Subject != "" && ownsDeployment(r, ownerID)
+}
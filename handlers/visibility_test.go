@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"static-site-hosting/artifacts"
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+)
+
+func TestDeploymentVisibility(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	upload := func(archive []byte, visibility string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("site", "docs")
+		if visibility != "" {
+			writer.WriteField("visibility", visibility)
+		}
+		part, _ := writer.CreateFormFile("file", "site.zip")
+		part.Write(archive)
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		UploadHandler(rr, as(req, aliceClaims), db)
+		return rr
+	}
+	static := StaticFileHandler(db)
+	get := func(id string, claims *auth.Claims) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		static.ServeHTTP(rr, as(httptest.NewRequest(http.MethodGet, "/"+id+"/index.html", nil), claims))
+		return rr
+	}
+
+	if rr := upload(testZipBytes(t), "secret"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown visibility to be refused, got %d", rr.Code)
+	}
+	rr := upload(testZipBytes(t), models.VisibilityPrivate)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var deployment models.Deployment
+	json.NewDecoder(rr.Body).Decode(&deployment)
+	if deployment.Visibility != models.VisibilityPrivate {
+		t.Fatalf("expected a private deployment, got %q", deployment.Visibility)
+	}
+
+	for name, tt := range map[string]struct {
+		claims   *auth.Claims
+		expected int
+	}{
+		"anonymous":  {nil, http.StatusUnauthorized},
+		"other user": {bobClaims, http.StatusNotFound},
+		"owner":      {aliceClaims, http.StatusOK},
+		"admin":      {adminClaims, http.StatusOK},
+	} {
+		rr := get(deployment.ID, tt.claims)
+		if rr.Code != tt.expected {
+			t.Errorf("%s: expected status %d, got %d", name, tt.expected, rr.Code)
+		}
+		if rr.Code == http.StatusOK && rr.Header().Get("Cache-Control") != "private" {
+			t.Errorf("%s: expected a private response, got Cache-Control %q", name, rr.Header().Get("Cache-Control"))
+		}
+	}
+
+	// Redeploying the site keeps it private
+	v2, _ := os.ReadFile(writeZip(t, map[string]string{"index.html": "<html>v2</html>"}))
+	var redeployed models.Deployment
+	json.NewDecoder(upload(v2, "").Body).Decode(&redeployed)
+	if redeployed.Visibility != models.VisibilityPrivate {
+		t.Errorf("expected the redeployment to inherit private, got %q", redeployed.Visibility)
+	}
+
+	patch := func(body string, claims *auth.Claims) *httptest.ResponseRecorder {
+		req := as(httptest.NewRequest(http.MethodPatch, "/deployments/"+deployment.ID, strings.NewReader(body)), claims)
+		rr := httptest.NewRecorder()
+		DeploymentVisibilityHandler(rr, req, db)
+		return rr
+	}
+	if rr := patch(`{"visibility": "public"}`, bobClaims); rr.Code != http.StatusNotFound {
+		t.Errorf("expected others' deployments to be 404, got %d", rr.Code)
+	}
+	if rr := patch(`{"visibility": "hidden"}`, aliceClaims); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown visibility to be refused, got %d", rr.Code)
+	}
+	if rr := patch(`{"visibility": "public"}`, aliceClaims); rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := get(deployment.ID, nil); rr.Code != http.StatusOK {
+		t.Errorf("expected a public deployment to be served anonymously, got %d", rr.Code)
+	}
+	if rr := get(redeployed.ID, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the other deployment to stay private, got %d", rr.Code)
+	}
+}
+
+func TestPrivateDeploymentArtifactAndManifest(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	saved := artifacts.Default
+	defer func() { artifacts.Default = saved }()
+	artifacts.Default = artifacts.Local{Dir: t.TempDir()}
+
+	// Anonymous callers own anonymous deployments, but never see private ones
+	var deployment models.Deployment
+	deploy := func(w http.ResponseWriter, r *http.Request) { SiteDeploymentsHandler(w, r, db) }
+	json.NewDecoder(putArchive(deploy, "docs", "application/gzip", createTestTarGz(t)).Body).Decode(&deployment)
+	if deployment.ID == "" {
+		t.Fatal("failed to create deployment")
+	}
+	db.Exec("UPDATE deployments SET visibility = 'private' WHERE id = ?", deployment.ID)
+
+	for name, tt := range map[string]struct {
+		handler func(http.ResponseWriter, *http.Request, *sql.DB)
+		path    string
+	}{
+		"artifact": {DeploymentArtifactHandler, "/deployments/" + deployment.ID + "/artifact"},
+		"manifest": {SiteManifestHandler, "/sites/docs/manifest.json"},
+	} {
+		rr := httptest.NewRecorder()
+		tt.handler(rr, httptest.NewRequest(http.MethodGet, tt.path, nil), db)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected a private deployment to be refused anonymously, got %d", name, rr.Code)
+		}
+		rr = httptest.NewRecorder()
+		tt.handler(rr, as(httptest.NewRequest(http.MethodGet, tt.path, nil), adminClaims), db)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected an admin to read a private deployment, got %d", name, rr.Code)
+		}
+	}
+
+	// Uploads still being checked have no record yet
+	if _, private := deploymentPrivate(db, "unrecorded"); !private {
+		t.Error("expected a deployment without a record to be private")
+	}
+}
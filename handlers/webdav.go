@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"static-site-hosting/atrest"
+	"static-site-hosting/locks"
+	"static-site-hosting/models"
+)
+
+// WebDAVHandler exposes a deployment's files over a minimal WebDAV interface.
+// Expected: /dav/{site-id}/{file-path}
+//
+// Reads (OPTIONS, PROPFIND, GET, HEAD) are always available. Writes (PUT,
+// DELETE, MKCOL) are only allowed when WebDAV read-write mode is enabled and
+// never modify the deployment in place: each write copies the deployment into
+// a new revision, applies the change there, and points the client at it via
+// the Location and X-Deployment-Id headers.
+func WebDAVHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	rest := strings.TrimPrefix(r.URL.Path, "/dav/")
+	siteID, filePath, _ := strings.Cut(rest, "/")
+	if siteID == "" {
+		http.Error(w, "Site ID required", http.StatusBadRequest)
+		return
+	}
+
+	var deployment models.Deployment
+	err := db.QueryRow("SELECT id, filename, timestamp, path, site, status, owner_id FROM deployments WHERE id = ?", siteID).
+		Scan(&deployment.ID, &deployment.Filename, &deployment.Timestamp, &deployment.Path, &deployment.Site, &deployment.Status, &deployment.OwnerID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Site not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch site", http.StatusInternalServerError)
+		return
+	}
+	if !authorizeFileAccess(w, r, db, deployment) {
+		return
+	}
+
+	// Resolve within the deployment root only
+	cleanRel := path.Clean("/" + filePath)
+	fullPath := filepath.Join(deployment.Path, filepath.FromSlash(cleanRel))
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("DAV", "1")
+		w.Header().Set("Allow", strings.Join(davAllowedMethods(), ", "))
+		w.WriteHeader(http.StatusOK)
+	case "PROPFIND":
+		if info, err := os.Stat(fullPath); err == nil && secretFile(davName(cleanRel, info.IsDir())) {
+			http.NotFound(w, r)
+			return
+		}
+		davPropfind(w, r, siteID, deployment.Path, fullPath, cleanRel)
+	case http.MethodGet, http.MethodHead:
+		info, err := os.Stat(fullPath)
+		if err != nil || info.IsDir() || secretFile(cleanRel) {
+			http.NotFound(w, r)
+			return
+		}
+		file, err := atrest.Open(deployment.Path, fullPath)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+		http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+	case http.MethodPut, http.MethodDelete, "MKCOL":
+		if !cfg.WebDAVReadWrite {
+			w.Header().Set("Allow", strings.Join(davAllowedMethods(), ", "))
+			http.Error(w, "WebDAV is read-only", http.StatusMethodNotAllowed)
+			return
+		}
+		davWrite(w, r, db, deployment, cleanRel)
+	default:
+		w.Header().Set("Allow", strings.Join(davAllowedMethods(), ", "))
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// davName is rel as secretFile expects it, with a trailing slash for a
+// directory
+func davName(rel string, dir bool) string {
+	if dir {
+		return rel + "/"
+	}
+	return rel
+}
+
+func davAllowedMethods() []string {
+	methods := []string{"OPTIONS", "PROPFIND", "GET", "HEAD"}
+	if cfg.WebDAVReadWrite {
+		methods = append(methods, "PUT", "DELETE", "MKCOL")
+	}
+	return methods
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	XmlnsD    string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	Propstat davPropstat `xml:"D:propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davProp struct {
+	DisplayName      string          `xml:"D:displayname"`
+	ResourceType     davResourceType `xml:"D:resourcetype"`
+	GetContentLength *int64          `xml:"D:getcontentlength,omitempty"`
+	GetContentType   string          `xml:"D:getcontenttype,omitempty"`
+	GetLastModified  string          `xml:"D:getlastmodified"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection,omitempty"`
+}
+
+func davPropfind(w http.ResponseWriter, r *http.Request, siteID, root, fullPath, rel string) {
+	depth := r.Header.Get("Depth")
+	if depth == "" || strings.EqualFold(depth, "infinity") {
+		// RFC 4918 allows refusing infinite depth; listing 100k-file sites in
+		// one response is exactly what we want to avoid
+		http.Error(w, "Depth infinity is not supported", http.StatusForbidden)
+		return
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ms := davMultistatus{XmlnsD: "DAV:"}
+	ms.Responses = append(ms.Responses, davEntry(siteID, root, rel, fullPath, info))
+
+	if info.IsDir() && depth == "1" {
+		entries, err := os.ReadDir(fullPath)
+		if err != nil {
+			http.Error(w, "Failed to read directory", http.StatusInternalServerError)
+			return
+		}
+		for _, e := range entries {
+			childInfo, err := e.Info()
+			if err != nil || secretFile(davName(path.Join(rel, e.Name()), childInfo.IsDir())) {
+				continue
+			}
+			ms.Responses = append(ms.Responses, davEntry(siteID, root, path.Join(rel, e.Name()), filepath.Join(fullPath, e.Name()), childInfo))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(ms)
+}
+
+func davEntry(siteID, root, rel, fullPath string, info os.FileInfo) davResponse {
+	href := (&url.URL{Path: path.Join("/dav", siteID, rel)}).EscapedPath()
+	prop := davProp{
+		DisplayName:     info.Name(),
+		GetLastModified: info.ModTime().UTC().Format(http.TimeFormat),
+	}
+	if info.IsDir() {
+		href += "/"
+		prop.ResourceType.Collection = &struct{}{}
+	} else {
+		if size, err := atrest.PlainSize(root, fullPath, info); err == nil {
+			prop.GetContentLength = &size
+		}
+		prop.GetContentType = mime.TypeByExtension(filepath.Ext(info.Name()))
+	}
+	return davResponse{
+		Href:     href,
+		Propstat: davPropstat{Prop: prop, Status: "HTTP/1.1 200 OK"},
+	}
+}
+
+// davWrite applies a write to a fresh copy of the deployment and records the
+// copy as a new deployment, leaving the original untouched.
+func davWrite(w http.ResponseWriter, r *http.Request, db *sql.DB, source models.Deployment, rel string) {
+	if rel == "/" {
+		http.Error(w, "Cannot modify the site root", http.StatusForbidden)
+		return
+	}
+	if !authorizeRevision(w, r, db, source) {
+		return
+	}
+
+	unlock, ok := lockMutation(w, "WebDAV write", locks.Keys(source.ID, source.Site)...)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	newDeployment, status, err := createRevision(r, db, source, fmt.Sprintf("[WEBDAV] %s", source.Filename), func(dir string) (int, error) {
+		return davApply(r, filepath.Join(dir, filepath.FromSlash(rel)))
+	})
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	newID := newDeployment.ID
+
+	w.Header().Set("Location", path.Join("/dav", newID, rel))
+	w.Header().Set("X-Deployment-Id", newID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":           "New revision created",
+		"source_deployment": source.ID,
+		"new_deployment":    withURLs(r, newDeployment),
+	})
+}
+
+// davApply performs the write on target and returns the success status
+func davApply(r *http.Request, target string) (int, error) {
+	switch r.Method {
+	case http.MethodPut:
+		if info, err := os.Stat(target); err == nil && info.IsDir() {
+			return http.StatusConflict, fmt.Errorf("Cannot PUT over a collection")
+		}
+		if _, err := os.Stat(filepath.Dir(target)); err != nil {
+			return http.StatusConflict, fmt.Errorf("Parent collection does not exist")
+		}
+		f, err := os.Create(target)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("Failed to write file")
+		}
+		defer f.Close()
+		if _, err := io.Copy(f, r.Body); err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("Failed to write file")
+		}
+		return http.StatusCreated, nil
+
+	case http.MethodDelete:
+		if _, err := os.Stat(target); err != nil {
+			return http.StatusNotFound, fmt.Errorf("Resource not found")
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("Failed to delete resource")
+		}
+		return http.StatusOK, nil
+
+	default: // MKCOL
+		if _, err := os.Stat(target); err == nil {
+			return http.StatusMethodNotAllowed, fmt.Errorf("Resource already exists")
+		}
+		if err := os.Mkdir(target, 0755); err != nil {
+			return http.StatusConflict, fmt.Errorf("Parent collection does not exist")
+		}
+		return http.StatusCreated, nil
+	}
+}
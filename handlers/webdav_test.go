@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupWebDAVSite(t *testing.T) (siteID string) {
+	siteID = "dav-site"
+	sitePath := filepath.Join("deployments", siteID)
+	if err := os.MkdirAll(filepath.Join(sitePath, "css"), 0755); err != nil {
+		t.Fatalf("failed to create deployment dir: %v", err)
+	}
+	os.WriteFile(filepath.Join(sitePath, "index.html"), []byte("<html>dav</html>"), 0644)
+	os.WriteFile(filepath.Join(sitePath, "css", "style.css"), []byte("body{}"), 0644)
+	return siteID
+}
+
+func TestWebDAVPropfind(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	siteID := setupWebDAVSite(t)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, ?, ?, ?)",
+		siteID, "site.zip", time.Now(), filepath.Join("deployments", siteID))
+
+	req := httptest.NewRequest("PROPFIND", "/dav/"+siteID+"/", nil)
+	req.Header.Set("Depth", "1")
+	rr := httptest.NewRecorder()
+	WebDAVHandler(rr, req, db)
+
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("expected status 207, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{"/dav/dav-site/index.html", "/dav/dav-site/css/", "<D:collection>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected PROPFIND response to contain %q", want)
+		}
+	}
+
+	// Infinite depth is refused
+	req = httptest.NewRequest("PROPFIND", "/dav/"+siteID+"/", nil)
+	rr = httptest.NewRecorder()
+	WebDAVHandler(rr, req, db)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected status 403 for depth infinity, got %d", rr.Code)
+	}
+}
+
+func TestWebDAVReadOnlyByDefault(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	siteID := setupWebDAVSite(t)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, ?, ?, ?)",
+		siteID, "site.zip", time.Now(), filepath.Join("deployments", siteID))
+
+	req := httptest.NewRequest(http.MethodPut, "/dav/"+siteID+"/new.html", strings.NewReader("new"))
+	rr := httptest.NewRecorder()
+	WebDAVHandler(rr, req, db)
+
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", rr.Code)
+	}
+}
+
+func TestWebDAVWriteCreatesRevision(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	cfg.WebDAVReadWrite = true
+	defer func() { cfg.WebDAVReadWrite = false }()
+
+	siteID := setupWebDAVSite(t)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, ?, ?, ?)",
+		siteID, "site.zip", time.Now(), filepath.Join("deployments", siteID))
+
+	req := httptest.NewRequest(http.MethodPut, "/dav/"+siteID+"/index.html", strings.NewReader("<html>v2</html>"))
+	rr := httptest.NewRecorder()
+	WebDAVHandler(rr, req, db)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+
+	newID := rr.Header().Get("X-Deployment-Id")
+	if newID == "" || newID == siteID {
+		t.Fatalf("expected a new deployment ID, got %q", newID)
+	}
+
+	original, _ := os.ReadFile(filepath.Join("deployments", siteID, "index.html"))
+	if string(original) != "<html>dav</html>" {
+		t.Errorf("expected original deployment to be unchanged, got %q", string(original))
+	}
+
+	updated, _ := os.ReadFile(filepath.Join("deployments", newID, "index.html"))
+	if string(updated) != "<html>v2</html>" {
+		t.Errorf("expected new revision to contain update, got %q", string(updated))
+	}
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM deployments").Scan(&count)
+	if count != 2 {
+		t.Errorf("expected 2 deployments, got %d", count)
+	}
+}
+
+func TestWebDAVWritesAreChecked(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.WebDAVReadWrite = true
+	cfg.ValidateForbiddenPatterns = []string{`hello\s+world`}
+	cfg.SmokeTest = true
+	cfg.SmokeTestPaths = []string{"/"}
+
+	siteID := setupWebDAVSite(t)
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, ?, ?, ?)",
+		siteID, "site.zip", time.Now(), filepath.Join("deployments", siteID))
+
+	for _, tt := range []struct {
+		method, path, body, want string
+	}{
+		{http.MethodPut, "/notes.txt", "hello world", "failed validation"},
+		{http.MethodDelete, "/index.html", "", "failed smoke test"},
+	} {
+		req := httptest.NewRequest(tt.method, "/dav/"+siteID+tt.path, strings.NewReader(tt.body))
+		rr := httptest.NewRecorder()
+		WebDAVHandler(rr, req, db)
+		if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), tt.want) {
+			t.Errorf("%s %s: expected 422 %q, got %d: %s", tt.method, tt.path, tt.want, rr.Code, rr.Body.String())
+		}
+	}
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM deployments").Scan(&count)
+	if count != 1 {
+		t.Errorf("expected no revision to be published, got %d deployments", count)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/dav/"+siteID+"/notes.txt", strings.NewReader("notes"))
+	rr := httptest.NewRecorder()
+	WebDAVHandler(rr, req, db)
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected a passing revision to be published, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
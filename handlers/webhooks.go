@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"static-site-hosting/models"
+	"static-site-hosting/secrets"
+	"static-site-hosting/webhooks"
+
+	"github.com/google/uuid"
+)
+
+// WebhooksHandler lists (GET) the caller's webhooks, or every webhook for
+// admins, or registers (POST) one on /webhooks
+func WebhooksHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	switch r.Method {
+	case http.MethodGet:
+		where, args := ownerScope(r)
+		rows, err := db.Query("SELECT id, url, created_at, owner_id, events, sites FROM webhooks"+where+" ORDER BY created_at", args...)
+		if err != nil {
+			http.Error(w, "Failed to fetch webhooks", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		hooks := []models.Webhook{}
+		for rows.Next() {
+			var wh models.Webhook
+			var events, sites string
+			if err := rows.Scan(&wh.ID, &wh.URL, &wh.CreatedAt, &wh.OwnerID, &events, &sites); err != nil {
+				http.Error(w, "Failed to scan webhook", http.StatusInternalServerError)
+				return
+			}
+			wh.Events, wh.Sites = splitList(events), splitList(sites)
+			hooks = append(hooks, wh)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hooks)
+
+	case http.MethodPost:
+		var req struct {
+			URL    string   `json:"url"`
+			Secret string   `json:"secret"`
+			Events []string `json:"events"`
+			Sites  []string `json:"sites"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		u, err := url.Parse(req.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, "A valid http(s) url is required", http.StatusBadRequest)
+			return
+		}
+		if errs := webhookFilterErrors(req.Events, req.Sites); len(errs) > 0 {
+			writeFieldErrors(w, http.StatusBadRequest, errs...)
+			return
+		}
+		if !authorizeWebhookSites(w, r, db, req.Sites) {
+			return
+		}
+		if req.Secret == "" {
+			req.Secret = randomSecret()
+		}
+
+		wh := models.Webhook{
+			ID:        uuid.New().String(),
+			URL:       req.URL,
+			Secret:    req.Secret,
+			CreatedAt: time.Now().UTC(),
+			OwnerID:   requestOwner(r),
+			Events:    req.Events,
+			Sites:     req.Sites,
+		}
+		sealed, err := secrets.Seal(wh.Secret)
+		if err != nil {
+			http.Error(w, "Failed to encrypt webhook secret", http.StatusInternalServerError)
+			return
+		}
+		_, err = db.Exec(
+			"INSERT INTO webhooks (id, url, secret, created_at, owner_id, events, sites) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			wh.ID, wh.URL, sealed, wh.CreatedAt, wh.OwnerID, strings.Join(wh.Events, ","), strings.Join(wh.Sites, ","),
+		)
+		if err != nil {
+			http.Error(w, "Failed to save webhook", http.StatusInternalServerError)
+			return
+		}
+
+		// The secret is only ever returned here
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(wh)
+
+	default:
+		http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+	}
+}
+
+// WebhookHandler manages a single webhook of the caller's, or any for
+// admins; other owners' webhooks are not found:
+//
+//	PUT    /webhooks/{id}
+//	DELETE /webhooks/{id}
+//	GET    /webhooks/{id}/deliveries
+//	POST   /webhooks/{id}/redeliver
+//	GET    /webhooks/{id}/secrets
+//	POST   /webhooks/{id}/secrets/rotate
+//	DELETE /webhooks/{id}/secrets/{secret-id}
+func WebhookHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	path := strings.TrimPrefix(r.URL.Path, "/webhooks/")
+	webhookID, action, _ := strings.Cut(path, "/")
+	if webhookID == "" {
+		http.Error(w, "Webhook ID required", http.StatusBadRequest)
+		return
+	}
+
+	var ownerID string
+	err := db.QueryRow("SELECT owner_id FROM webhooks WHERE id = ?", webhookID).Scan(&ownerID)
+	if err == sql.ErrNoRows || err == nil && !ownsDeployment(r, ownerID) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch webhook", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodPut:
+		updateWebhookFilters(w, r, db, webhookID)
+	case action == "" && r.Method == http.MethodDelete:
+		deleteWebhook(w, db, webhookID)
+	case action == "deliveries" && r.Method == http.MethodGet:
+		listWebhookDeliveries(w, db, webhookID)
+	case action == "redeliver" && r.Method == http.MethodPost:
+		redeliverWebhook(w, r, db, webhookID)
+	case action == "secrets" && r.Method == http.MethodGet:
+		listWebhookSecrets(w, db, webhookID)
+	case action == "secrets/rotate" && r.Method == http.MethodPost:
+		rotateWebhookSecret(w, r, db, webhookID)
+	case strings.HasPrefix(action, "secrets/") && action != "secrets/rotate" && r.Method == http.MethodDelete:
+		revokeWebhookSecret(w, db, webhookID, strings.TrimPrefix(action, "secrets/"))
+	case action == "" || action == "deliveries" || action == "redeliver" || action == "secrets" || strings.HasPrefix(action, "secrets/"):
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func deleteWebhook(w http.ResponseWriter, db *sql.DB, webhookID string) {
+	db.Exec("DELETE FROM webhook_attempts WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE webhook_id = ?)", webhookID)
+	db.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", webhookID)
+	db.Exec("DELETE FROM webhook_secrets WHERE webhook_id = ?", webhookID)
+	if _, err := db.Exec("DELETE FROM webhooks WHERE id = ?", webhookID); err != nil {
+		http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Webhook " + webhookID + " deleted successfully",
+	})
+}
+
+// updateWebhookFilters replaces the events and sites a webhook is
+// notified of; leaving either out subscribes it to all of them again.
+// Deliveries already queued are sent regardless.
+func updateWebhookFilters(w http.ResponseWriter, r *http.Request, db *sql.DB, webhookID string) {
+	var req struct {
+		Events []string `json:"events"`
+		Sites  []string `json:"sites"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if errs := webhookFilterErrors(req.Events, req.Sites); len(errs) > 0 {
+		writeFieldErrors(w, http.StatusBadRequest, errs...)
+		return
+	}
+	if !authorizeWebhookSites(w, r, db, req.Sites) {
+		return
+	}
+
+	_, err := db.Exec("UPDATE webhooks SET events = ?, sites = ? WHERE id = ?", strings.Join(req.Events, ","), strings.Join(req.Sites, ","), webhookID)
+	if err != nil {
+		http.Error(w, "Failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+	wh := models.Webhook{ID: webhookID, Events: req.Events, Sites: req.Sites}
+	if err := db.QueryRow("SELECT url, created_at, owner_id FROM webhooks WHERE id = ?", webhookID).Scan(&wh.URL, &wh.CreatedAt, &wh.OwnerID); err != nil {
+		http.Error(w, "Failed to fetch webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wh)
+}
+
+// webhookFilterErrors checks that a webhook subscribes to known events and
+// valid site names
+func webhookFilterErrors(events, sites []string) models.ValidationErrors {
+	var errs models.ValidationErrors
+	for _, event := range events {
+		if !slices.Contains(webhooks.Events, event) {
+			errs.Add("events", models.CodeInvalid, "unknown event %q; use one of %s", event, strings.Join(webhooks.Events, ", "))
+		}
+	}
+	for _, site := range sites {
+		if !models.ValidSiteSlug(site) {
+			errs.Add("sites", models.CodeInvalid, "%q is not a valid site name", site)
+		}
+	}
+	return errs
+}
+
+// authorizeWebhookSites checks that the caller owns every site a webhook
+// filters on, so nobody is notified of other owners' sites. It answers the
+// request and returns false when not.
+func authorizeWebhookSites(w http.ResponseWriter, r *http.Request, db *sql.DB, sites []string) bool {
+	for _, site := range sites {
+		owns, err := ownsSite(db, r, site)
+		if err != nil {
+			http.Error(w, "Failed to check site ownership", http.StatusInternalServerError)
+			return false
+		}
+		if !owns {
+			http.Error(w, "Site "+site+" belongs to another owner", http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+type deliveryWithAttempts struct {
+	models.WebhookDelivery
+	AttemptLog []models.WebhookAttempt `json:"attempt_log"`
+}
+
+func listWebhookDeliveries(w http.ResponseWriter, db *sql.DB, webhookID string) {
+	rows, err := db.Query(
+		`SELECT id, webhook_id, event, payload, status, attempts, next_attempt_at, last_error, created_at, delivered_at
+		FROM webhook_deliveries WHERE webhook_id = ? ORDER BY created_at DESC LIMIT 100`,
+		webhookID,
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	deliveries := []deliveryWithAttempts{}
+	for rows.Next() {
+		var d deliveryWithAttempts
+		var deliveredAt sql.NullTime
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.LastError, &d.CreatedAt, &deliveredAt)
+		if err != nil {
+			rows.Close()
+			http.Error(w, "Failed to scan delivery", http.StatusInternalServerError)
+			return
+		}
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+	rows.Close()
+
+	for i := range deliveries {
+		attempts, err := loadDeliveryAttempts(db, deliveries[i].ID)
+		if err != nil {
+			http.Error(w, "Failed to fetch delivery attempts", http.StatusInternalServerError)
+			return
+		}
+		deliveries[i].AttemptLog = attempts
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+func loadDeliveryAttempts(db *sql.DB, deliveryID string) ([]models.WebhookAttempt, error) {
+	rows, err := db.Query(
+		`SELECT delivery_id, attempt, status_code, error, duration_ms, attempted_at
+		FROM webhook_attempts WHERE delivery_id = ? ORDER BY attempted_at`,
+		deliveryID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []models.WebhookAttempt{}
+	for rows.Next() {
+		var a models.WebhookAttempt
+		if err := rows.Scan(&a.DeliveryID, &a.Attempt, &a.StatusCode, &a.Error, &a.DurationMS, &a.AttemptedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, nil
+}
+
+func redeliverWebhook(w http.ResponseWriter, r *http.Request, db *sql.DB, webhookID string) {
+	// Body is optional: without a delivery_id every dead-lettered delivery is retried
+	var req struct {
+		DeliveryID string `json:"delivery_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	count, err := webhooks.Redeliver(db, webhookID, req.DeliveryID)
+	if err != nil {
+		http.Error(w, "Failed to requeue deliveries", http.StatusInternalServerError)
+		return
+	}
+	if req.DeliveryID != "" && count == 0 {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Deliveries requeued",
+		"requeued_count": count,
+	})
+}
+
+func listWebhookSecrets(w http.ResponseWriter, db *sql.DB, webhookID string) {
+	retired, err := webhooks.RetiredSecrets(db, webhookID)
+	if err != nil {
+		http.Error(w, "Failed to fetch webhook secrets", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"webhook_id": webhookID,
+		"retired":    retired,
+	})
+}
+
+// rotateWebhookSecret replaces the signing secret. The body is optional: a
+// secret is generated unless one is given, and the previous secret keeps
+// signing for WEBHOOK_SECRET_OVERLAP unless overlap_seconds says otherwise.
+func rotateWebhookSecret(w http.ResponseWriter, r *http.Request, db *sql.DB, webhookID string) {
+	var req struct {
+		Secret         string `json:"secret"`
+		OverlapSeconds *int64 `json:"overlap_seconds"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+	overlap := cfg.WebhookSecretOverlap
+	if req.OverlapSeconds != nil {
+		if *req.OverlapSeconds < 0 {
+			http.Error(w, "overlap_seconds must not be negative", http.StatusBadRequest)
+			return
+		}
+		overlap = time.Duration(*req.OverlapSeconds) * time.Second
+	}
+	if req.Secret == "" {
+		req.Secret = randomSecret()
+	}
+
+	retired, err := webhooks.RotateSecret(db, webhookID, req.Secret, overlap)
+	if err != nil {
+		http.Error(w, "Failed to rotate webhook secret", http.StatusInternalServerError)
+		return
+	}
+
+	// As on registration, this is the only time the secret is returned
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		WebhookID string                `json:"webhook_id"`
+		Secret    string                `json:"secret"`
+		Retired   *models.WebhookSecret `json:"retired,omitempty"`
+	}{webhookID, req.Secret, retired})
+}
+
+// revokeWebhookSecret ends a retired secret's overlap early
+func revokeWebhookSecret(w http.ResponseWriter, db *sql.DB, webhookID, secretID string) {
+	result, err := db.Exec("DELETE FROM webhook_secrets WHERE id = ? AND webhook_id = ?", secretID, webhookID)
+	if err != nil {
+		http.Error(w, "Failed to revoke webhook secret", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Webhook secret not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Webhook secret " + secretID + " revoked",
+	})
+}
+
+// splitList splits a comma-separated column, empty for none
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func randomSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
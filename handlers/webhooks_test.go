@@ -0,0 +1,381 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+	"static-site-hosting/secrets"
+)
+
+func TestWebhooksHandlerCreateAndList(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url":"https://example.com/hook"}`))
+	rr := httptest.NewRecorder()
+	WebhooksHandler(rr, req, db)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+
+	var created models.Webhook
+	json.NewDecoder(rr.Body).Decode(&created)
+	if created.ID == "" || created.Secret == "" {
+		t.Errorf("expected ID and generated secret, got %+v", created)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/webhooks", nil)
+	rr = httptest.NewRecorder()
+	WebhooksHandler(rr, req, db)
+
+	var hooks []models.Webhook
+	json.NewDecoder(rr.Body).Decode(&hooks)
+	if len(hooks) != 1 {
+		t.Fatalf("expected 1 webhook, got %d", len(hooks))
+	}
+	if hooks[0].Secret != "" {
+		t.Error("expected secret to be omitted from listing")
+	}
+}
+
+func TestWebhookFilters(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	rr := httptest.NewRecorder()
+	WebhooksHandler(rr, httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url":"https://example.com/hook","events":["deployment.deleted"],"sites":["docs"]}`)), db)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created models.Webhook
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	for _, body := range []string{`{"url":"https://example.com/hook","events":["deployment.*"]}`, `{"url":"https://example.com/hook","sites":["Not A Site"]}`} {
+		rr := httptest.NewRecorder()
+		WebhooksHandler(rr, httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body)), db)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, rr.Code)
+		}
+	}
+
+	// Only deletions of docs deployments are queued
+	for _, d := range []struct{ id, site string }{{"dep-docs", "docs"}, {"dep-blog", "blog"}} {
+		db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES (?, 'a.zip', ?, ?, ?)", d.id, time.Now(), filepath.Join("deployments", d.id), d.site)
+		DeleteDeploymentHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/deployments/"+d.id, nil), db)
+	}
+	var queued int
+	db.QueryRow("SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = ?", created.ID).Scan(&queued)
+	if queued != 1 {
+		t.Errorf("expected one delivery for the docs deletion, got %d", queued)
+	}
+
+	rr = httptest.NewRecorder()
+	WebhookHandler(rr, httptest.NewRequest(http.MethodPut, "/webhooks/"+created.ID, strings.NewReader(`{"sites":["blog"]}`)), db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	WebhooksHandler(rr, httptest.NewRequest(http.MethodGet, "/webhooks", nil), db)
+	var hooks []models.Webhook
+	json.NewDecoder(rr.Body).Decode(&hooks)
+	if len(hooks) != 1 || len(hooks[0].Events) != 0 || len(hooks[0].Sites) != 1 || hooks[0].Sites[0] != "blog" {
+		t.Errorf("expected the webhook to follow every event about blog, got %+v", hooks)
+	}
+}
+
+func TestWebhooksHandlerEncryptsSecret(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	keyring, _ := secrets.NewKeyring(bytes.Repeat([]byte{7}, 32))
+	secrets.Default = keyring
+	defer func() { secrets.Default = nil }()
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url":"https://example.com/hook","secret":"shh"}`))
+	rr := httptest.NewRecorder()
+	WebhooksHandler(rr, req, db)
+
+	var created models.Webhook
+	json.NewDecoder(rr.Body).Decode(&created)
+	if created.Secret != "shh" {
+		t.Errorf("expected the plaintext secret in the response, got %q", created.Secret)
+	}
+
+	var stored string
+	db.QueryRow("SELECT secret FROM webhooks WHERE id = ?", created.ID).Scan(&stored)
+	if stored == "shh" || !strings.HasPrefix(stored, "enc:") {
+		t.Errorf("expected the stored secret to be encrypted, got %q", stored)
+	}
+	if got, _ := secrets.Open(stored); got != "shh" {
+		t.Errorf("expected the stored secret to decrypt to shh, got %q", got)
+	}
+}
+
+func TestWebhooksHandlerInvalidURL(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url":"ftp://example.com"}`))
+	rr := httptest.NewRecorder()
+	WebhooksHandler(rr, req, db)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", rr.Code)
+	}
+}
+
+func TestWebhookHandlerRedeliver(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	now := time.Now().UTC()
+	db.Exec("INSERT INTO webhooks (id, url, secret) VALUES ('wh-1', 'https://example.com', 's')")
+	db.Exec(`INSERT INTO webhook_deliveries (id, webhook_id, event, payload, status, attempts, next_attempt_at, created_at)
+		VALUES ('dl-1', 'wh-1', 'deployment.created', '{}', ?, 8, ?, ?)`, models.DeliveryDead, now, now)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/wh-1/redeliver", nil)
+	rr := httptest.NewRecorder()
+	WebhookHandler(rr, req, db)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d. Response: %s", rr.Code, rr.Body.String())
+	}
+
+	var status string
+	db.QueryRow("SELECT status FROM webhook_deliveries WHERE id = 'dl-1'").Scan(&status)
+	if status != models.DeliveryPending {
+		t.Errorf("expected delivery to be pending, got %s", status)
+	}
+
+	// Deliveries listing includes the attempt log
+	req = httptest.NewRequest(http.MethodGet, "/webhooks/wh-1/deliveries", nil)
+	rr = httptest.NewRecorder()
+	WebhookHandler(rr, req, db)
+
+	var deliveries []deliveryWithAttempts
+	json.NewDecoder(rr.Body).Decode(&deliveries)
+	if len(deliveries) != 1 || deliveries[0].ID != "dl-1" {
+		t.Errorf("unexpected deliveries: %+v", deliveries)
+	}
+}
+
+func TestDeleteQueuesWebhook(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	db.Exec("INSERT INTO webhooks (id, url, secret) VALUES ('wh-1', 'https://example.com', 's')")
+
+	// Deleting a deployment queues a deployment.deleted event
+	db.Exec("INSERT INTO deployments (id, filename, timestamp, path) VALUES ('dep-1', 'a.zip', ?, 'deployments/dep-1')", time.Now())
+	req := httptest.NewRequest(http.MethodDelete, "/deployments/dep-1", nil)
+	rr := httptest.NewRecorder()
+	DeleteDeploymentHandler(rr, req, db)
+
+	var event string
+	if err := db.QueryRow("SELECT event FROM webhook_deliveries WHERE webhook_id = 'wh-1'").Scan(&event); err != nil {
+		t.Fatalf("expected a queued delivery: %v", err)
+	}
+	if event != "deployment.deleted" {
+		t.Errorf("expected deployment.deleted event, got %s", event)
+	}
+}
+
+func TestPromotionQueuesWebhook(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	db.Exec("INSERT INTO webhooks (id, url, secret) VALUES ('wh-1', 'https://example.com', 's')")
+	for i, id := range []string{"v1", "v2"} {
+		os.MkdirAll(filepath.Join("deployments", id), 0755)
+		os.WriteFile(filepath.Join("deployments", id, "index.html"), []byte(id), 0644)
+		db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES (?, 'a.zip', ?, ?, 'docs')",
+			id, time.Now().Add(time.Duration(i-2)*time.Minute), filepath.Join("deployments", id))
+	}
+
+	promotions := func() []map[string]any {
+		rows, _ := db.Query("SELECT payload FROM webhook_deliveries WHERE event = 'deployment.promoted' ORDER BY rowid")
+		defer rows.Close()
+		var data []map[string]any
+		for rows.Next() {
+			var payload string
+			rows.Scan(&payload)
+			var envelope struct{ Data map[string]any }
+			json.Unmarshal([]byte(payload), &envelope)
+			data = append(data, envelope.Data)
+		}
+		return data
+	}
+
+	// Rolling back to v1 creates a deployment and puts it live; every file
+	// changes, so it must be confirmed
+	rr := httptest.NewRecorder()
+	RollbackHandler(rr, httptest.NewRequest(http.MethodPost, "/rollback/v1?confirm=true", nil), db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("rollback failed: %d %s", rr.Code, rr.Body.String())
+	}
+	var created int
+	db.QueryRow("SELECT COUNT(*) FROM webhook_deliveries WHERE event = 'deployment.created'").Scan(&created)
+	if created != 1 {
+		t.Errorf("expected one deployment.created event, got %d", created)
+	}
+	got := promotions()
+	if len(got) != 1 {
+		t.Fatalf("expected one deployment.promoted event, got %d", len(got))
+	}
+	live := liveDeploymentID(db, "docs")
+	if got[0]["site"] != "docs" || got[0]["previous_deployment_id"] != "v2" || got[0]["deployment_id"] != live {
+		t.Errorf("unexpected promotion payload: %v", got[0])
+	}
+
+	// Deleting the live deployment puts v2 back live
+	rr = httptest.NewRecorder()
+	DeleteDeploymentHandler(rr, httptest.NewRequest(http.MethodDelete, "/deployments/"+live, nil), db)
+	got = promotions()
+	if len(got) != 2 || got[1]["previous_deployment_id"] != live || got[1]["deployment_id"] != "v2" {
+		t.Errorf("expected a promotion back to v2, got %v", got)
+	}
+
+	// Deleting a deployment that isn't live promotes nothing
+	DeleteDeploymentHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/deployments/v1", nil), db)
+	if got = promotions(); len(got) != 2 {
+		t.Errorf("expected no promotion for an inactive deployment, got %d events", len(got))
+	}
+}
+
+func TestWebhookSecretRotation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	db.Exec("INSERT INTO webhooks (id, url, secret) VALUES ('wh-1', 'https://example.com/hook', 'first')")
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		WebhookHandler(rr, httptest.NewRequest(method, path, strings.NewReader(body)), db)
+		return rr
+	}
+
+	rr := request(http.MethodPost, "/webhooks/wh-1/secrets/rotate", `{"secret":"second","overlap_seconds":3600}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var rotated struct {
+		Secret  string
+		Retired *models.WebhookSecret
+	}
+	json.NewDecoder(rr.Body).Decode(&rotated)
+	if rotated.Secret != "second" || rotated.Retired == nil {
+		t.Fatalf("expected the new secret and the retired one, got %+v", rotated)
+	}
+	if d := rotated.Retired.ExpiresAt.Sub(rotated.Retired.RetiredAt); d != time.Hour {
+		t.Errorf("expected a one hour overlap, got %v", d)
+	}
+
+	// Without a body a secret is generated and the configured overlap applies
+	rr = request(http.MethodPost, "/webhooks/wh-1/secrets/rotate", "")
+	json.NewDecoder(rr.Body).Decode(&rotated)
+	if rotated.Secret == "" || rotated.Secret == "second" {
+		t.Errorf("expected a generated secret, got %q", rotated.Secret)
+	}
+	if d := rotated.Retired.ExpiresAt.Sub(rotated.Retired.RetiredAt); d != cfg.WebhookSecretOverlap {
+		t.Errorf("expected the default overlap, got %v", d)
+	}
+
+	rr = request(http.MethodGet, "/webhooks/wh-1/secrets", "")
+	var listed struct{ Retired []map[string]any }
+	json.NewDecoder(rr.Body).Decode(&listed)
+	if len(listed.Retired) != 2 {
+		t.Fatalf("expected 2 retired secrets, got %+v", listed.Retired)
+	}
+	if _, ok := listed.Retired[0]["secret"]; ok {
+		t.Error("expected secrets to be left out of the listing")
+	}
+
+	if rr := request(http.MethodDelete, "/webhooks/wh-1/secrets/"+rotated.Retired.ID, ""); rr.Code != http.StatusOK {
+		t.Errorf("expected status 200 revoking a secret, got %d", rr.Code)
+	}
+	if rr := request(http.MethodDelete, "/webhooks/wh-1/secrets/"+rotated.Retired.ID, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 revoking it again, got %d", rr.Code)
+	}
+	if rr := request(http.MethodPost, "/webhooks/wh-1/secrets/rotate", `{"overlap_seconds":-1}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a negative overlap, got %d", rr.Code)
+	}
+}
+
+func TestWebhooksAreIsolatedByOwner(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	db.Exec("INSERT INTO deployments (id, filename, path, site, owner_id) VALUES ('docs-1', 'site.zip', 'deployments/docs-1', 'docs', ?)", aliceClaims.Subject)
+	db.Exec("INSERT INTO webhooks (id, url, secret, owner_id) VALUES ('wh-alice', 'https://alice.example.com', 's', ?)", aliceClaims.Subject)
+	db.Exec("INSERT INTO webhook_secrets (id, webhook_id, secret, retired_at, expires_at) VALUES ('ws-1', 'wh-alice', 's', ?, ?)", time.Now(), time.Now().Add(time.Hour))
+
+	request := func(handler func(http.ResponseWriter, *http.Request, *sql.DB), method, path, body string, claims *auth.Claims) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler(rr, as(httptest.NewRequest(method, path, strings.NewReader(body)), claims), db)
+		return rr
+	}
+
+	// Nobody registers a webhook for someone else's site
+	if rr := request(WebhooksHandler, http.MethodPost, "/webhooks", `{"url":"https://bob.example.com","sites":["docs"]}`, bobClaims); rr.Code != http.StatusForbidden {
+		t.Errorf("expected a filter on another owner's site to be refused, got %d", rr.Code)
+	}
+	rr := request(WebhooksHandler, http.MethodPost, "/webhooks", `{"url":"https://bob.example.com","sites":["blog"]}`, bobClaims)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created models.Webhook
+	json.NewDecoder(rr.Body).Decode(&created)
+	if created.OwnerID != bobClaims.Subject {
+		t.Errorf("expected the webhook to be bob's, got %q", created.OwnerID)
+	}
+
+	var hooks []models.Webhook
+	json.NewDecoder(request(WebhooksHandler, http.MethodGet, "/webhooks", "", bobClaims).Body).Decode(&hooks)
+	if len(hooks) != 1 || hooks[0].ID != created.ID {
+		t.Errorf("expected bob to list only his webhook, got %+v", hooks)
+	}
+	json.NewDecoder(request(WebhooksHandler, http.MethodGet, "/webhooks", "", adminClaims).Body).Decode(&hooks)
+	if len(hooks) != 2 {
+		t.Errorf("expected admins to list every webhook, got %d", len(hooks))
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+	}{
+		{http.MethodPut, "/webhooks/wh-alice", `{"events":["deployment.created"]}`},
+		{http.MethodDelete, "/webhooks/wh-alice", ""},
+		{http.MethodGet, "/webhooks/wh-alice/deliveries", ""},
+		{http.MethodPost, "/webhooks/wh-alice/redeliver", ""},
+		{http.MethodGet, "/webhooks/wh-alice/secrets", ""},
+		{http.MethodPost, "/webhooks/wh-alice/secrets/rotate", ""},
+		{http.MethodDelete, "/webhooks/wh-alice/secrets/ws-1", ""},
+	} {
+		if rr := request(WebhookHandler, tt.method, tt.path, tt.body, bobClaims); rr.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected another owner's webhook to be 404, got %d", tt.method, tt.path, rr.Code)
+		}
+	}
+	if rr := request(WebhookHandler, http.MethodPut, "/webhooks/"+created.ID, `{"sites":["docs"]}`, bobClaims); rr.Code != http.StatusForbidden {
+		t.Errorf("expected filtering on another owner's site to be refused, got %d", rr.Code)
+	}
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM webhook_secrets WHERE webhook_id = 'wh-alice'").Scan(&count)
+	if count != 1 {
+		t.Error("expected alice's webhook secrets to be untouched")
+	}
+	if rr := request(WebhookHandler, http.MethodGet, "/webhooks/wh-alice/secrets", "", aliceClaims); rr.Code != http.StatusOK {
+		t.Errorf("expected the owner to manage the webhook, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Signatures of the zip records joinZipVolumes rewrites
+const (
+	zipCentralHeaderSig = 0x02014b50
+	zipEndSig           = 0x06054b50
+	zip64EndSig         = 0x06064b50
+	zip64LocatorSig     = 0x07064b50
+)
+
+var errZipVolumes = errors.New("invalid split zip archive")
+
+// joinZipVolumes turns the volumes of a split zip archive (site.z01,
+// site.z02, …, site.zip), concatenated in order at path, into a single
+// volume archive in place; volumes holds the size of each, or is nil for a
+// single body. Split archives locate records by volume and offset within
+// it, so those are rewritten as offsets into the whole file. Archives that aren't split, including ones
+// cut into pieces byte for byte (site.zip.001, …), are left as they are.
+func joinZipVolumes(path string, volumes []int64) error {
+	le := binary.LittleEndian
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	// The end of central directory record is only followed by a comment of
+	// at most 64KiB
+	tail := make([]byte, min(size, 22+math.MaxUint16))
+	tailStart := size - int64(len(tail))
+	if _, err := f.ReadAt(tail, tailStart); err != nil {
+		return err
+	}
+	end := -1
+	for i := len(tail) - 22; i >= 0; i-- {
+		if le.Uint32(tail[i:]) == zipEndSig {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		// Not a zip archive, which extracting it reports
+		return nil
+	}
+	endOffset := tailStart + int64(end)
+	eocd := tail[end : end+22]
+	disk := int(le.Uint16(eocd[4:]))
+	if disk == 0 {
+		return nil
+	}
+	if volumes == nil {
+		volumes = []int64{size}
+	}
+	if disk+1 != len(volumes) {
+		return fmt.Errorf("archive has %d volumes, got %d", disk+1, len(volumes))
+	}
+
+	starts := make([]int64, len(volumes))
+	for i := 1; i < len(volumes); i++ {
+		starts[i] = starts[i-1] + volumes[i-1]
+	}
+	abs := func(disk uint32, offset uint64) (int64, error) {
+		if int(disk) >= len(starts) || offset > uint64(size) || starts[disk]+int64(offset) > size {
+			return 0, errZipVolumes
+		}
+		return starts[disk] + int64(offset), nil
+	}
+
+	cdDisk := uint32(le.Uint16(eocd[6:]))
+	entries := uint64(le.Uint16(eocd[10:]))
+	cdSize := uint64(le.Uint32(eocd[12:]))
+	cdOffset := uint64(le.Uint32(eocd[16:]))
+
+	// Zip64 archives hold the same fields, full width, in a record found
+	// through the locator before the end record
+	var locator, record []byte
+	var recordOffset int64
+	if endOffset >= 20 {
+		locator = make([]byte, 20)
+		if _, err := f.ReadAt(locator, endOffset-20); err != nil {
+			return err
+		}
+		if le.Uint32(locator) != zip64LocatorSig {
+			locator = nil
+		}
+	}
+	if locator != nil {
+		if recordOffset, err = abs(le.Uint32(locator[4:]), le.Uint64(locator[8:])); err != nil {
+			return err
+		}
+		record = make([]byte, 56)
+		if _, err := f.ReadAt(record, recordOffset); err != nil || le.Uint32(record) != zip64EndSig {
+			return errZipVolumes
+		}
+		cdDisk = le.Uint32(record[20:])
+		entries = le.Uint64(record[32:])
+		cdSize = le.Uint64(record[40:])
+		cdOffset = le.Uint64(record[48:])
+	}
+
+	cdStart, err := abs(cdDisk, cdOffset)
+	if err != nil || cdSize > uint64(size-cdStart) {
+		return errZipVolumes
+	}
+	cd := make([]byte, cdSize)
+	if _, err := f.ReadAt(cd, cdStart); err != nil {
+		return err
+	}
+	for i, p := uint64(0), 0; i < entries; i++ {
+		if p+46 > len(cd) || le.Uint32(cd[p:]) != zipCentralHeaderSig {
+			return errZipVolumes
+		}
+		h := cd[p : p+46]
+		nameLen, extraLen, commentLen := int(le.Uint16(h[28:])), int(le.Uint16(h[30:])), int(le.Uint16(h[32:]))
+		next := p + 46 + nameLen + extraLen + commentLen
+		if next > len(cd) {
+			return errZipVolumes
+		}
+		if err := relocateZipEntry(h, cd[p+46+nameLen:p+46+nameLen+extraLen], abs); err != nil {
+			return err
+		}
+		p = next
+	}
+
+	// Everything now lives on volume 0
+	le.PutUint16(eocd[4:], 0)
+	le.PutUint16(eocd[6:], 0)
+	le.PutUint16(eocd[8:], le.Uint16(eocd[10:]))
+	if le.Uint32(eocd[16:]) != math.MaxUint32 {
+		if cdStart >= math.MaxUint32 {
+			return errZipVolumes
+		}
+		le.PutUint32(eocd[16:], uint32(cdStart))
+	}
+	if record != nil {
+		le.PutUint32(record[16:], 0)
+		le.PutUint32(record[20:], 0)
+		le.PutUint64(record[24:], entries)
+		le.PutUint64(record[48:], uint64(cdStart))
+		le.PutUint32(locator[4:], 0)
+		le.PutUint64(locator[8:], uint64(recordOffset))
+		le.PutUint32(locator[16:], 1)
+		if _, err := f.WriteAt(record, recordOffset); err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(locator, endOffset-20); err != nil {
+			return err
+		}
+	}
+	if _, err := f.WriteAt(cd, cdStart); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(eocd, endOffset)
+	return err
+}
+
+// relocateZipEntry points a central directory header at its local header's
+// offset into the whole archive. Fields too large for the header are in its
+// zip64 extra field.
+func relocateZipEntry(h, extra []byte, abs func(uint32, uint64) (int64, error)) error {
+	le := binary.LittleEndian
+	disk := uint32(le.Uint16(h[34:]))
+	offset := uint64(le.Uint32(h[42:]))
+	var wideOffset, wideDisk []byte
+	for len(extra) >= 4 {
+		id, n := le.Uint16(extra), int(le.Uint16(extra[2:]))
+		if 4+n > len(extra) {
+			break
+		}
+		if id == 0x0001 {
+			// Present in this order, each only when its header field is full
+			field := extra[4 : 4+n]
+			for _, full := range []bool{le.Uint32(h[24:]) == math.MaxUint32, le.Uint32(h[20:]) == math.MaxUint32} {
+				if full && len(field) >= 8 {
+					field = field[8:]
+				}
+			}
+			if le.Uint32(h[42:]) == math.MaxUint32 && len(field) >= 8 {
+				wideOffset, offset = field[:8], le.Uint64(field)
+				field = field[8:]
+			}
+			if le.Uint16(h[34:]) == math.MaxUint16 && len(field) >= 4 {
+				wideDisk, disk = field[:4], le.Uint32(field)
+			}
+		}
+		extra = extra[4+n:]
+	}
+
+	pos, err := abs(disk, offset)
+	if err != nil {
+		return err
+	}
+	switch {
+	case wideOffset != nil:
+		le.PutUint64(wideOffset, uint64(pos))
+	case pos < math.MaxUint32:
+		le.PutUint32(h[42:], uint32(pos))
+	default:
+		return errZipVolumes
+	}
+	if wideDisk != nil {
+		le.PutUint32(wideDisk, 0)
+	} else {
+		le.PutUint16(h[34:], 0)
+	}
+	return nil
+}
+
+// volumeIndex orders the volumes of a split archive: site.z01, site.z02, …
+// before site.zip, and site.zip.001, site.zip.002, … by number
+func volumeIndex(name string) int {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	if ext == "zip" {
+		return math.MaxInt
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(ext, "z")); err == nil {
+		return n
+	}
+	return 0
+}
+
+// sortVolumes puts the volumes of a split archive in order, returning the
+// archive's name: that of its .zip volume, or the first with its number
+// dropped
+func sortVolumes(volumes []*multipart.FileHeader) string {
+	sort.SliceStable(volumes, func(i, j int) bool {
+		return volumeIndex(volumes[i].Filename) < volumeIndex(volumes[j].Filename)
+	})
+	if last := volumes[len(volumes)-1].Filename; volumeIndex(last) == math.MaxInt {
+		return last
+	}
+	first := volumes[0].Filename
+	return strings.TrimSuffix(first, filepath.Ext(first))
+}
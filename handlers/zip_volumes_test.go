@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"static-site-hosting/models"
+)
+
+// testSplitZip builds an archive holding index.html and a stored 10KB
+// asset, and cuts it into 4KB volumes as zip -s does: behind a spanning
+// signature, with records located by volume and offset within it
+func testSplitZip(t *testing.T) (volumes [][]byte, asset []byte) {
+	t.Helper()
+	asset = make([]byte, 10<<10)
+	rand.Read(asset)
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, _ := zw.Create("index.html")
+	f.Write([]byte("<html><body>Split Site</body></html>"))
+	f, _ = zw.CreateHeader(&zip.FileHeader{Name: "assets/data.bin", Method: zip.Store})
+	f.Write(asset)
+	zw.Close()
+
+	const size = 4 << 10
+	le := binary.LittleEndian
+	data := append([]byte("PK\x07\x08"), buf.Bytes()...)
+	end := len(data) - 22
+	if end/size != (len(data)-1)/size {
+		t.Fatal("end of central directory record spans volumes")
+	}
+	locate := func(pos int) (uint16, uint32) { return uint16(pos / size), uint32(pos % size) }
+	cdStart := int(le.Uint32(data[end+16:])) + 4
+	for p := cdStart; p < end; {
+		disk, offset := locate(int(le.Uint32(data[p+42:])) + 4)
+		le.PutUint16(data[p+34:], disk)
+		le.PutUint32(data[p+42:], offset)
+		p += 46 + int(le.Uint16(data[p+28:])) + int(le.Uint16(data[p+30:])) + int(le.Uint16(data[p+32:]))
+	}
+	disk, offset := locate(cdStart)
+	last, _ := locate(end)
+	le.PutUint16(data[end+4:], last)
+	le.PutUint16(data[end+6:], disk)
+	le.PutUint32(data[end+16:], offset)
+
+	for len(data) > 0 {
+		n := min(size, len(data))
+		volumes = append(volumes, data[:n])
+		data = data[n:]
+	}
+	return volumes, asset
+}
+
+func TestJoinZipVolumes(t *testing.T) {
+	volumes, asset := testSplitZip(t)
+	path := filepath.Join(t.TempDir(), "site.zip")
+	sizes := make([]int64, len(volumes))
+	for i, v := range volumes {
+		sizes[i] = int64(len(v))
+	}
+	os.WriteFile(path, bytes.Join(volumes, nil), 0644)
+
+	if err := joinZipVolumes(path, sizes[1:]); err == nil {
+		t.Error("expected an error when volumes are missing")
+	}
+	if err := joinZipVolumes(path, sizes); err != nil {
+		t.Fatalf("joinZipVolumes: %v", err)
+	}
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("joined archive doesn't open: %v", err)
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		got, _ := io.ReadAll(rc)
+		rc.Close()
+		if f.Name == "assets/data.bin" && !bytes.Equal(got, asset) {
+			t.Error("joined archive has different content")
+		}
+	}
+
+	// Archives that aren't split are left alone
+	plain := testZipBytes(t)
+	os.WriteFile(path, plain, 0644)
+	if err := joinZipVolumes(path, nil); err != nil {
+		t.Fatalf("joinZipVolumes: %v", err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, plain) {
+		t.Error("expected an archive that isn't split to be unchanged")
+	}
+}
+
+func TestUploadSplitArchive(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer os.RemoveAll("deployments")
+
+	volumes, asset := testSplitZip(t)
+	if len(volumes) != 3 {
+		t.Fatalf("expected 3 volumes, got %d", len(volumes))
+	}
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	// Volumes are put in order whatever order they are sent in
+	for _, v := range []struct {
+		name string
+		data []byte
+	}{{"site.z02", volumes[1]}, {"site.zip", volumes[2]}, {"site.z01", volumes[0]}} {
+		part, _ := writer.CreateFormFile("file", v.name)
+		part.Write(v.data)
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	UploadHandler(rr, req, db)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var d models.Deployment
+	json.NewDecoder(rr.Body).Decode(&d)
+	if d.Filename != "site.zip" {
+		t.Errorf("expected the archive to be named after its .zip volume, got %q", d.Filename)
+	}
+	if got, _ := os.ReadFile(filepath.Join(d.Path, "assets", "data.bin")); !bytes.Equal(got, asset) {
+		t.Error("expected the split archive's files to be deployed")
+	}
+}
@@ -0,0 +1,102 @@
+package i18n
+
+// builtin translates the messages clients run into most often. Others are
+// answered in English unless a translation directory covers them.
+var builtin = map[string]map[string]string{
+	"de": {
+		"GET required":                               "GET erforderlich",
+		"POST required":                              "POST erforderlich",
+		"PUT required":                               "PUT erforderlich",
+		"DELETE required":                            "DELETE erforderlich",
+		"Method not allowed":                         "Methode nicht erlaubt",
+		"404 page not found":                         "Nicht gefunden",
+		"Forbidden":                                  "Zugriff verweigert",
+		"Deployment not found":                       "Deployment nicht gefunden",
+		"Deployment ID required":                     "Deployment-ID erforderlich",
+		"Site not found":                             "Site nicht gefunden",
+		"Upload not found":                           "Upload nicht gefunden",
+		"Invalid file":                               "Ungültige Datei",
+		"Invalid site name":                          "Ungültiger Site-Name",
+		"Site name %q is reserved":                   "Der Site-Name {1} ist reserviert",
+		"Invalid JSON body":                          "Ungültiger JSON-Inhalt",
+		"Upload exceeds the size limit":              "Der Upload überschreitet die Größenbeschränkung",
+		"Incomplete upload: chunk %d is missing":     "Unvollständiger Upload: Teil {1} fehlt",
+		"Conflicting operation in progress: %s":      "Ein widersprüchlicher Vorgang läuft bereits: {1}",
+		"Conflicting operation in progress":          "Ein widersprüchlicher Vorgang läuft bereits",
+		"Database unavailable, try again later":      "Datenbank nicht verfügbar, bitte später erneut versuchen",
+		"Authentication required":                    "Anmeldung erforderlich",
+		"Invalid or expired credentials":             "Ungültige oder abgelaufene Zugangsdaten",
+		"Invalid username or password":               "Ungültiger Benutzername oder ungültiges Passwort",
+		"Too many failed login attempts":             "Zu viele fehlgeschlagene Anmeldeversuche",
+		"Upload is not a zip, tar or tar.gz archive": "Der Upload ist kein zip-, tar- oder tar.gz-Archiv",
+		"Content-Type must be application/zip, application/x-tar or application/gzip": "Content-Type muss application/zip, application/x-tar oder application/gzip sein",
+		"redirect %d: from required":    "Weiterleitung {1}: from erforderlich",
+		"redirect %d: to required":      "Weiterleitung {1}: to erforderlich",
+		"header rule %d: path required": "Header-Regel {1}: path erforderlich",
+		"header rule %d: set required":  "Header-Regel {1}: set erforderlich",
+	},
+	"fr": {
+		"GET required":                               "GET requis",
+		"POST required":                              "POST requis",
+		"PUT required":                               "PUT requis",
+		"DELETE required":                            "DELETE requis",
+		"Method not allowed":                         "Méthode non autorisée",
+		"404 page not found":                         "Introuvable",
+		"Forbidden":                                  "Accès refusé",
+		"Deployment not found":                       "Déploiement introuvable",
+		"Deployment ID required":                     "Identifiant de déploiement requis",
+		"Site not found":                             "Site introuvable",
+		"Upload not found":                           "Envoi introuvable",
+		"Invalid file":                               "Fichier invalide",
+		"Invalid site name":                          "Nom de site invalide",
+		"Site name %q is reserved":                   "Le nom de site {1} est réservé",
+		"Invalid JSON body":                          "Corps JSON invalide",
+		"Upload exceeds the size limit":              "L'envoi dépasse la taille maximale",
+		"Incomplete upload: chunk %d is missing":     "Envoi incomplet : le morceau {1} est manquant",
+		"Conflicting operation in progress: %s":      "Une opération concurrente est en cours : {1}",
+		"Conflicting operation in progress":          "Une opération concurrente est en cours",
+		"Database unavailable, try again later":      "Base de données indisponible, réessayez plus tard",
+		"Authentication required":                    "Authentification requise",
+		"Invalid or expired credentials":             "Identifiants invalides ou expirés",
+		"Invalid username or password":               "Nom d'utilisateur ou mot de passe invalide",
+		"Too many failed login attempts":             "Trop de tentatives de connexion échouées",
+		"Upload is not a zip, tar or tar.gz archive": "L'envoi n'est pas une archive zip, tar ou tar.gz",
+		"Content-Type must be application/zip, application/x-tar or application/gzip": "Content-Type doit être application/zip, application/x-tar ou application/gzip",
+		"redirect %d: from required":    "redirection {1} : from requis",
+		"redirect %d: to required":      "redirection {1} : to requis",
+		"header rule %d: path required": "règle d'en-têtes {1} : path requis",
+		"header rule %d: set required":  "règle d'en-têtes {1} : set requis",
+	},
+	"es": {
+		"GET required":                               "Se requiere GET",
+		"POST required":                              "Se requiere POST",
+		"PUT required":                               "Se requiere PUT",
+		"DELETE required":                            "Se requiere DELETE",
+		"Method not allowed":                         "Método no permitido",
+		"404 page not found":                         "No encontrado",
+		"Forbidden":                                  "Acceso denegado",
+		"Deployment not found":                       "Despliegue no encontrado",
+		"Deployment ID required":                     "Se requiere el ID del despliegue",
+		"Site not found":                             "Sitio no encontrado",
+		"Upload not found":                           "Subida no encontrada",
+		"Invalid file":                               "Archivo no válido",
+		"Invalid site name":                          "Nombre de sitio no válido",
+		"Site name %q is reserved":                   "El nombre de sitio {1} está reservado",
+		"Invalid JSON body":                          "Cuerpo JSON no válido",
+		"Upload exceeds the size limit":              "La subida supera el tamaño máximo",
+		"Incomplete upload: chunk %d is missing":     "Subida incompleta: falta el fragmento {1}",
+		"Conflicting operation in progress: %s":      "Hay una operación en conflicto en curso: {1}",
+		"Conflicting operation in progress":          "Hay una operación en conflicto en curso",
+		"Database unavailable, try again later":      "Base de datos no disponible, inténtelo más tarde",
+		"Authentication required":                    "Se requiere autenticación",
+		"Invalid or expired credentials":             "Credenciales no válidas o caducadas",
+		"Invalid username or password":               "Usuario o contraseña no válidos",
+		"Too many failed login attempts":             "Demasiados intentos de inicio de sesión fallidos",
+		"Upload is not a zip, tar or tar.gz archive": "La subida no es un archivo zip, tar o tar.gz",
+		"Content-Type must be application/zip, application/x-tar or application/gzip": "Content-Type debe ser application/zip, application/x-tar o application/gzip",
+		"redirect %d: from required":    "redirección {1}: se requiere from",
+		"redirect %d: to required":      "redirección {1}: se requiere to",
+		"header rule %d: path required": "regla de cabeceras {1}: se requiere path",
+		"header rule %d: set required":  "regla de cabeceras {1}: se requiere set",
+	},
+}
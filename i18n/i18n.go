@@ -0,0 +1,203 @@
+// Package i18n translates user-facing API error messages. Messages are
+// looked up by the English text the server produces, or by the format
+// string it was built from for messages carrying values: a translation of
+// "Site name %q is reserved" applies to every reserved name, with {1}
+// standing for the first value, {2} for the second and so on.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Separator joins several messages in one, as in the error of a request
+// with more than one invalid field; each part is translated on its own
+const Separator = "; "
+
+// entry is one message and its translations
+type entry struct {
+	format       string
+	pattern      *regexp.Regexp // nil for messages without values
+	translations map[string]string
+}
+
+// Catalog holds translations of messages into any number of languages.
+// English needs none: it is what the server writes.
+type Catalog struct {
+	exact    map[string]*entry
+	patterns []*entry // longest format first
+	byFormat map[string]*entry
+	langs    map[string]bool
+}
+
+// New returns a catalog holding the built-in translations
+func New() *Catalog {
+	c := &Catalog{exact: map[string]*entry{}, byFormat: map[string]*entry{}, langs: map[string]bool{}}
+	for lang, messages := range builtin {
+		c.Add(lang, messages)
+	}
+	return c
+}
+
+// Add adds or replaces translations into lang, keyed by English message
+// or format string
+func (c *Catalog) Add(lang string, messages map[string]string) {
+	lang = strings.ToLower(lang)
+	c.langs[lang] = true
+	for format, translation := range messages {
+		e := c.byFormat[format]
+		if e == nil {
+			e = &entry{format: format, translations: map[string]string{}}
+			if pattern := formatPattern(format); pattern != nil {
+				e.pattern = pattern
+				c.patterns = append(c.patterns, e)
+			} else {
+				c.exact[format] = e
+			}
+			c.byFormat[format] = e
+		}
+		e.translations[lang] = translation
+	}
+	// Where formats overlap, the one with the most literal text wins
+	sort.SliceStable(c.patterns, func(i, j int) bool {
+		return len(c.patterns[i].format) > len(c.patterns[j].format)
+	})
+}
+
+// LoadDir adds the translations in dir, one JSON object of messages per
+// language named after it, such as de.json or pt-br.json
+func (c *Catalog) LoadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(raw, &messages); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		c.Add(strings.TrimSuffix(filepath.Base(file), ".json"), messages)
+	}
+	return nil
+}
+
+// Languages lists the languages the catalog translates into
+func (c *Catalog) Languages() []string {
+	langs := make([]string, 0, len(c.langs))
+	for lang := range c.langs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Negotiate picks the language to answer in from an Accept-Language
+// header, or returns "" for English. Ranges are tried by quality; "de-CH"
+// falls back to "de" when only that is available.
+func (c *Catalog) Negotiate(header string) string {
+	type ranged struct {
+		tag string
+		q   float64
+	}
+	var ranges []ranged
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" && q > 0 {
+			ranges = append(ranges, ranged{tag, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, r := range ranges {
+		if r.tag == "*" || r.tag == "en" || strings.HasPrefix(r.tag, "en-") {
+			return ""
+		}
+		for tag := r.tag; tag != ""; {
+			if c.langs[tag] {
+				return tag
+			}
+			i := strings.LastIndex(tag, "-")
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return ""
+}
+
+// Translate returns msg in lang, or msg itself when there is no
+// translation for it
+func (c *Catalog) Translate(lang, msg string) string {
+	if lang == "" || !c.langs[lang] {
+		return msg
+	}
+	if parts := strings.Split(msg, Separator); len(parts) > 1 {
+		for i, part := range parts {
+			parts[i] = c.Translate(lang, part)
+		}
+		return strings.Join(parts, Separator)
+	}
+
+	if e := c.exact[msg]; e != nil {
+		if t, ok := e.translations[lang]; ok {
+			return t
+		}
+		return msg
+	}
+	for _, e := range c.patterns {
+		t, ok := e.translations[lang]
+		if !ok {
+			continue
+		}
+		m := e.pattern.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		for i, value := range m[1:] {
+			t = strings.ReplaceAll(t, "{"+strconv.Itoa(i+1)+"}", value)
+		}
+		return t
+	}
+	return msg
+}
+
+// formatVerbs matches the fmt verbs formatPattern understands
+var formatVerbs = regexp.MustCompile(`%[-+# 0-9.]*[sqdvw]`)
+
+// formatPattern turns a format string into a pattern matching the messages
+// it produces, with a group per value, or returns nil if it has no verbs
+func formatPattern(format string) *regexp.Regexp {
+	literal := strings.ReplaceAll(format, "%%", "\x00")
+	verbs := formatVerbs.FindAllStringIndex(literal, -1)
+	if verbs == nil {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	last := 0
+	for _, v := range verbs {
+		b.WriteString(regexp.QuoteMeta(strings.ReplaceAll(literal[last:v[0]], "\x00", "%")))
+		b.WriteString("(.+?)")
+		last = v[1]
+	}
+	b.WriteString(regexp.QuoteMeta(strings.ReplaceAll(literal[last:], "\x00", "%")))
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,80 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	c := New()
+	c.Add("pt-br", map[string]string{"Site not found": "Site não encontrado"})
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"de", "de"},
+		{"de-CH, en;q=0.5", "de"},
+		{"en-US, de;q=0.8", ""},
+		{"ja, fr;q=0.9, es;q=0.8", "fr"},
+		{"es;q=0.5, fr;q=0.9", "fr"},
+		{"fr;q=0, es", "es"},
+		{"pt-BR", "pt-br"},
+		{"pt-PT", ""},
+		{"*", ""},
+	}
+	for _, tt := range tests {
+		if got := c.Negotiate(tt.header); got != tt.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	c := New()
+	tests := []struct {
+		lang, msg, want string
+	}{
+		{"de", "Deployment not found", "Deployment nicht gefunden"},
+		{"fr", `Site name "uploads" is reserved`, `Le nom de site "uploads" est réservé`},
+		{"es", "Incomplete upload: chunk 3 is missing", "Subida incompleta: falta el fragmento 3"},
+		{"de", "redirect 0: from required; header rule 2: set required", "Weiterleitung 0: from erforderlich; Header-Regel 2: set erforderlich"},
+		{"de", "Something nobody translated", "Something nobody translated"},
+		{"", "Deployment not found", "Deployment not found"},
+		{"ja", "Deployment not found", "Deployment not found"},
+	}
+	for _, tt := range tests {
+		if got := c.Translate(tt.lang, tt.msg); got != tt.want {
+			t.Errorf("Translate(%q, %q) = %q, want %q", tt.lang, tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "nl.json"), []byte(`{"Site not found": "Site niet gevonden", "Webhook %s failed with %d%%": "Webhook {1} mislukt met {2}%"}`), 0644)
+	os.WriteFile(filepath.Join(dir, "de.json"), []byte(`{"Site not found": "Website nicht gefunden"}`), 0644)
+
+	c := New()
+	if err := c.LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+	if got := c.Translate("nl", "Site not found"); got != "Site niet gevonden" {
+		t.Errorf("expected the added language, got %q", got)
+	}
+	if got := c.Translate("nl", "Webhook hook-1 failed with 50%"); got != "Webhook hook-1 mislukt met 50%" {
+		t.Errorf("expected values to be carried over, got %q", got)
+	}
+	if got := c.Translate("de", "Site not found"); got != "Website nicht gefunden" {
+		t.Errorf("expected files to override built-in translations, got %q", got)
+	}
+	if got := c.Translate("de", "Deployment not found"); got != "Deployment nicht gefunden" {
+		t.Errorf("expected other built-in translations to remain, got %q", got)
+	}
+
+	os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{`), 0644)
+	if err := New().LoadDir(dir); err == nil {
+		t.Error("expected an error for a malformed file")
+	}
+}
@@ -0,0 +1,160 @@
+package immutable
+
+import (
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"static-site-hosting/atrest"
+)
+
+// Modes applied to sealed deployment trees
+const (
+	FileMode = 0444
+	DirMode  = 0555
+)
+
+// Chattr also sets the filesystem immutable attribute (chattr +i) on sealed
+// trees, which even root can't write through. It needs CAP_LINUX_IMMUTABLE
+// and a filesystem that supports it (ext4, xfs, btrfs).
+var Chattr bool
+
+// Seal makes every file and directory under dir read-only so a deployment's
+// content can't drift after it has been created, encrypting the files first
+// when atrest.Default is set. Directories are sealed bottom-up so the walk
+// never loses access to entries it still has to visit.
+func Seal(dir string) error {
+	if err := atrest.Default.EncryptTree(dir); err != nil {
+		return err
+	}
+	var dirs []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			dirs = append(dirs, p)
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		return os.Chmod(p, FileMode)
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i], DirMode); err != nil {
+			return err
+		}
+	}
+
+	if Chattr {
+		if out, err := exec.Command("chattr", "-R", "+i", dir).CombinedOutput(); err != nil {
+			log.Printf("Warning: Failed to set immutable attribute on %s: %v: %s", dir, err, out)
+		}
+	}
+	return nil
+}
+
+// Unseal makes a sealed tree writable again so it can be removed
+func Unseal(dir string) error {
+	if Chattr {
+		if out, err := exec.Command("chattr", "-R", "-i", dir).CombinedOutput(); err != nil {
+			log.Printf("Warning: Failed to clear immutable attribute on %s: %v: %s", dir, err, out)
+		}
+	}
+
+	// Directories are opened up top-down so their children become reachable
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return os.Chmod(p, 0755)
+		}
+		return nil
+	})
+}
+
+// RemoveAll is os.RemoveAll for trees that may have been sealed
+func RemoveAll(dir string) error {
+	if _, err := os.Lstat(dir); os.IsNotExist(err) {
+		return atrest.Unmark(dir)
+	}
+	if err := Unseal(dir); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return atrest.Unmark(dir)
+}
+
+// Wipe is RemoveAll that first overwrites every file with zeros and syncs
+// it to disk, so deleted content can't be read back from the freed blocks.
+// It returns how many files and bytes it overwrote. Copy-on-write
+// filesystems and SSDs may still keep old blocks elsewhere; encrypting the
+// volume covers those.
+func Wipe(dir string) (files int, bytes int64, err error) {
+	if _, err := os.Lstat(dir); os.IsNotExist(err) {
+		return 0, 0, atrest.Unmark(dir)
+	}
+	if err := Unseal(dir); err != nil {
+		return 0, 0, err
+	}
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		n, err := overwrite(p)
+		if err != nil {
+			return err
+		}
+		files++
+		bytes += n
+		return nil
+	})
+	if err != nil {
+		return files, bytes, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return files, bytes, err
+	}
+	return files, bytes, atrest.Unmark(dir)
+}
+
+// overwrite replaces the contents of the file at p with zeros in place
+func overwrite(p string) (int64, error) {
+	if err := os.Chmod(p, 0644); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.CopyN(f, zeros{}, info.Size())
+	if err != nil {
+		return n, err
+	}
+	return n, f.Sync()
+}
+
+type zeros struct{}
+
+func (zeros) Read(b []byte) (int, error) {
+	clear(b)
+	return len(b), nil
+}
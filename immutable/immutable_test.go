@@ -0,0 +1,76 @@
+package immutable
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSealAndRemoveAll(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "site")
+	os.MkdirAll(filepath.Join(dir, "css"), 0755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0644)
+	os.WriteFile(filepath.Join(dir, "css", "app.css"), []byte("body{}"), 0644)
+
+	if err := Seal(dir); err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	for _, p := range []string{"index.html", "css/app.css"} {
+		info, err := os.Stat(filepath.Join(dir, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != FileMode {
+			t.Errorf("expected %s to be %o, got %o", p, FileMode, info.Mode().Perm())
+		}
+	}
+	for _, p := range []string{".", "css"} {
+		info, _ := os.Stat(filepath.Join(dir, p))
+		if info.Mode().Perm() != DirMode {
+			t.Errorf("expected directory %s to be %o, got %o", p, DirMode, info.Mode().Perm())
+		}
+	}
+
+	if err := RemoveAll(dir); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("expected sealed tree to be removed")
+	}
+	if err := RemoveAll(dir); err != nil {
+		t.Errorf("expected removing a missing tree to succeed: %v", err)
+	}
+}
+
+func TestWipe(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "site")
+	os.MkdirAll(filepath.Join(dir, "css"), 0755)
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0644)
+	os.WriteFile(filepath.Join(dir, "css", "app.css"), []byte("body{}"), 0644)
+	Seal(dir)
+
+	// An open handle still reads the file after it's unlinked
+	f, err := os.Open(filepath.Join(dir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	files, bytes, err := Wipe(dir)
+	if err != nil {
+		t.Fatalf("Wipe failed: %v", err)
+	}
+	if files != 2 || bytes != 19 {
+		t.Errorf("expected 2 files and 19 bytes wiped, got %d and %d", files, bytes)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("expected wiped tree to be removed")
+	}
+	data, _ := io.ReadAll(f)
+	if string(data) != strings.Repeat("\x00", 13) {
+		t.Errorf("expected the contents to be overwritten, got %q", data)
+	}
+}
@@ -0,0 +1,387 @@
+package integrity
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"static-site-hosting/atrest"
+	"static-site-hosting/notify"
+	"static-site-hosting/webhooks"
+)
+
+// Problem kinds
+const (
+	ProblemMissing  = "missing"
+	ProblemModified = "modified"
+	ProblemUnread   = "unreadable"
+)
+
+// reportsKept is how many past reports are stored
+const reportsKept = 30
+
+// FileHash is one file of a deployment's recorded manifest
+type FileHash struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Problem is a file that no longer matches its manifest
+type Problem struct {
+	DeploymentID string `json:"deployment_id"`
+	Path         string `json:"path"`
+	Kind         string `json:"kind"`
+	Expected     string `json:"expected,omitempty"`
+	Actual       string `json:"actual,omitempty"`
+}
+
+// Report is the outcome of one integrity check
+type Report struct {
+	ID            int64     `json:"id,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	SamplePercent int       `json:"sample_percent"`
+	Deployments   int       `json:"deployments"`
+	FilesChecked  int       `json:"files_checked"`
+	// Baselined counts deployments that had no manifest yet, such as ones
+	// published before manifests were recorded; one is recorded instead
+	Baselined int       `json:"baselined"`
+	Problems  []Problem `json:"problems"`
+}
+
+// Corrupted reports whether the check found any problems
+func (r *Report) Corrupted() bool {
+	return len(r.Problems) > 0
+}
+
+// Record hashes every file below dir and stores the result as the manifest
+// of deploymentID, replacing any earlier one. Deployments are sealed before
+// they are recorded, so the manifest is what should stay on disk.
+func Record(db *sql.DB, deploymentID, dir string) error {
+	var files []FileHash
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		size, sum, err := hashFile(dir, p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		files = append(files, FileHash{Path: filepath.ToSlash(rel), Size: size, SHA256: sum})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM deployment_files WHERE deployment_id = ?", deploymentID); err != nil {
+		return err
+	}
+	for _, f := range files {
+		_, err := tx.Exec(
+			"INSERT INTO deployment_files (deployment_id, path, size, sha256) VALUES (?, ?, ?, ?)",
+			deploymentID, f.Path, f.Size, f.SHA256,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Copy gives deploymentID the manifest of fromID, for deployments sharing
+// another's files
+func Copy(db *sql.DB, fromID, deploymentID string) error {
+	_, err := db.Exec(
+		`INSERT OR REPLACE INTO deployment_files (deployment_id, path, size, sha256)
+		SELECT ?, path, size, sha256 FROM deployment_files WHERE deployment_id = ?`,
+		deploymentID, fromID,
+	)
+	return err
+}
+
+// Remove deletes the manifest of deploymentID, or of every deployment when
+// deploymentID is empty
+func Remove(db *sql.DB, deploymentID string) {
+	if deploymentID == "" {
+		db.Exec("DELETE FROM deployment_files")
+		return
+	}
+	db.Exec("DELETE FROM deployment_files WHERE deployment_id = ?", deploymentID)
+}
+
+// Manifest returns the recorded files of deploymentID in path order
+func Manifest(db *sql.DB, deploymentID string) ([]FileHash, error) {
+	rows, err := db.Query(
+		"SELECT path, size, sha256 FROM deployment_files WHERE deployment_id = ? ORDER BY path",
+		deploymentID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []FileHash
+	for rows.Next() {
+		var f FileHash
+		if err := rows.Scan(&f.Path, &f.Size, &f.SHA256); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// Check compares deployment files on disk against their manifests. With
+// samplePercent below 100 only that share of each deployment's files, at
+// least one, is picked at random. Deployments sharing files are checked once.
+func Check(db *sql.DB, samplePercent int) (*Report, error) {
+	if samplePercent <= 0 || samplePercent > 100 {
+		samplePercent = 100
+	}
+	report := &Report{StartedAt: time.Now().UTC(), SamplePercent: samplePercent, Problems: []Problem{}}
+
+	rows, err := db.Query("SELECT id, path FROM deployments ORDER BY timestamp")
+	if err != nil {
+		return nil, err
+	}
+	type deployment struct{ id, path string }
+	var deployments []deployment
+	seen := map[string]bool{}
+	for rows.Next() {
+		var d deployment
+		if err := rows.Scan(&d.id, &d.path); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !seen[d.path] {
+			seen[d.path] = true
+			deployments = append(deployments, d)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, d := range deployments {
+		files, err := Manifest(db, d.id)
+		if err != nil {
+			return nil, err
+		}
+		report.Deployments++
+		if len(files) == 0 {
+			if err := Record(db, d.id, d.path); err != nil {
+				log.Printf("Warning: Failed to record manifest of deployment %s: %v", d.id, err)
+			} else {
+				report.Baselined++
+			}
+			continue
+		}
+
+		for _, f := range sample(files, samplePercent) {
+			report.FilesChecked++
+			if p := verify(d.path, f); p != nil {
+				p.DeploymentID = d.id
+				report.Problems = append(report.Problems, *p)
+			}
+		}
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	return report, nil
+}
+
+// sample picks percent of files at random, at least one
+func sample(files []FileHash, percent int) []FileHash {
+	if percent >= 100 {
+		return files
+	}
+	n := max(1, len(files)*percent/100)
+	picked := make([]FileHash, len(files))
+	copy(picked, files)
+	rand.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+	return picked[:n]
+}
+
+func verify(root string, f FileHash) *Problem {
+	size, sum, err := hashFile(root, filepath.Join(root, filepath.FromSlash(f.Path)))
+	switch {
+	case os.IsNotExist(err):
+		return &Problem{Path: f.Path, Kind: ProblemMissing}
+	case err != nil:
+		return &Problem{Path: f.Path, Kind: ProblemUnread, Actual: err.Error()}
+	case size != f.Size || sum != f.SHA256:
+		return &Problem{Path: f.Path, Kind: ProblemModified, Expected: f.SHA256, Actual: sum}
+	}
+	return nil
+}
+
+func hashFile(root, name string) (int64, string, error) {
+	file, err := atrest.Open(root, name)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	n, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Save stores report, keeping the most recent reportsKept
+func Save(db *sql.DB, report *Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	res, err := db.Exec(
+		"INSERT INTO integrity_reports (started_at, problems, report) VALUES (?, ?, ?)",
+		report.StartedAt, len(report.Problems), string(data),
+	)
+	if err != nil {
+		return err
+	}
+	report.ID, _ = res.LastInsertId()
+	_, err = db.Exec(
+		"DELETE FROM integrity_reports WHERE id NOT IN (SELECT id FROM integrity_reports ORDER BY id DESC LIMIT ?)",
+		reportsKept,
+	)
+	return err
+}
+
+// Reports returns stored reports, newest first
+func Reports(db *sql.DB, limit int) ([]Report, error) {
+	rows, err := db.Query("SELECT id, report FROM integrity_reports ORDER BY id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		var id int64
+		var raw string
+		if err := rows.Scan(&id, &raw); err != nil {
+			return nil, err
+		}
+		var report Report
+		if err := json.Unmarshal([]byte(raw), &report); err != nil {
+			return nil, err
+		}
+		report.ID = id
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+// Checker runs an integrity check every night and reports corruption by
+// webhook and email
+type Checker struct {
+	DB            *sql.DB
+	SamplePercent int
+	Hour          int // UTC hour the check starts
+	Mailer        *notify.Mailer
+	Recipients    []string // addresses that receive corruption reports
+}
+
+// NewChecker returns a checker running daily at hour (UTC)
+func NewChecker(db *sql.DB, hour, samplePercent int, mailer *notify.Mailer, recipients []string) *Checker {
+	return &Checker{
+		DB:            db,
+		SamplePercent: samplePercent,
+		Hour:          hour,
+		Mailer:        mailer,
+		Recipients:    recipients,
+	}
+}
+
+// Run checks once a day at Hour until ctx is cancelled
+func (c *Checker) Run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(time.Until(c.next(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if _, err := c.CheckNow(); err != nil {
+			log.Printf("Warning: Integrity check failed: %v", err)
+		}
+	}
+}
+
+// next returns the first occurrence of Hour after now
+func (c *Checker) next(now time.Time) time.Time {
+	now = now.UTC()
+	at := time.Date(now.Year(), now.Month(), now.Day(), c.Hour, 0, 0, 0, time.UTC)
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at
+}
+
+// CheckNow runs a check, stores its report and sends it out if corruption
+// was found
+func (c *Checker) CheckNow() (*Report, error) {
+	report, err := Check(c.DB, c.SamplePercent)
+	if err != nil {
+		return nil, err
+	}
+	if err := Save(c.DB, report); err != nil {
+		log.Printf("Warning: Failed to save integrity report: %v", err)
+	}
+	log.Printf("Integrity check: %d files in %d deployments, %d problems", report.FilesChecked, report.Deployments, len(report.Problems))
+
+	if report.Corrupted() {
+		webhooks.Notify(c.DB, webhooks.EventIntegrityCorrupted, report)
+		if c.Mailer.Enabled() && len(c.Recipients) > 0 {
+			if err := c.Mailer.Send(c.Recipients, reportSubject(report), reportBody(report)); err != nil {
+				log.Printf("Warning: Failed to send integrity report: %v", err)
+			}
+		}
+	}
+	return report, nil
+}
+
+func reportSubject(report *Report) string {
+	deployments := map[string]bool{}
+	for _, p := range report.Problems {
+		deployments[p.DeploymentID] = true
+	}
+	return fmt.Sprintf("Integrity check: %d corrupted files in %d deployments", len(report.Problems), len(deployments))
+}
+
+func reportBody(report *Report) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "The integrity check started at %s found files that no longer match\n", report.StartedAt.Format(time.RFC3339))
+	b.WriteString("the manifests recorded when they were deployed.\n\n")
+	for _, p := range report.Problems {
+		fmt.Fprintf(&b, "  %s  %s  %s\n", p.DeploymentID, p.Path, p.Kind)
+	}
+	fmt.Fprintf(&b, "\nChecked %d files (%d%% sample) in %d deployments.\n", report.FilesChecked, report.SamplePercent, report.Deployments)
+	b.WriteString("Restore affected deployments from their archives with POST /deployments/{id}/artifact/extract\n")
+	b.WriteString("or redeploy them.\n")
+	return b.String()
+}
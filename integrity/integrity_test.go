@@ -0,0 +1,144 @@
+package integrity
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	schema := []string{
+		`CREATE TABLE deployments (
+			id TEXT PRIMARY KEY,
+			filename TEXT NOT NULL,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+			path TEXT NOT NULL
+		)`,
+		`CREATE TABLE deployment_files (
+			deployment_id TEXT NOT NULL,
+			path TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			sha256 TEXT NOT NULL,
+			PRIMARY KEY (deployment_id, path)
+		)`,
+		`CREATE TABLE integrity_reports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			started_at DATETIME NOT NULL,
+			problems INTEGER NOT NULL,
+			report TEXT NOT NULL
+		)`,
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+	}
+	return db
+}
+
+func writeSite(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheckFindsCorruption(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	dir := t.TempDir()
+
+	site := filepath.Join(dir, "dep-1")
+	writeSite(t, site, map[string]string{"index.html": "home", "css/site.css": "body{}", "about.html": "about"})
+	db.Exec("INSERT INTO deployments (id, filename, path) VALUES ('dep-1', 'a.zip', ?), ('alias', 'a.zip', ?)", site, site)
+	if err := Record(db, "dep-1", site); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	Copy(db, "dep-1", "alias")
+
+	files, _ := Manifest(db, "dep-1")
+	if len(files) != 3 || files[1].Path != "css/site.css" || files[1].Size != 6 {
+		t.Fatalf("unexpected manifest %+v", files)
+	}
+
+	report, err := Check(db, 100)
+	if err != nil || report.Corrupted() || report.FilesChecked != 3 || report.Deployments != 1 {
+		t.Fatalf("expected a clean check of one shared directory, got %+v, %v", report, err)
+	}
+
+	os.WriteFile(filepath.Join(site, "index.html"), []byte("h0me"), 0644)
+	os.Remove(filepath.Join(site, "about.html"))
+	report, _ = Check(db, 100)
+	kinds := map[string]string{}
+	for _, p := range report.Problems {
+		kinds[p.Path] = p.Kind
+	}
+	if len(report.Problems) != 2 || kinds["index.html"] != ProblemModified || kinds["about.html"] != ProblemMissing {
+		t.Errorf("expected index.html modified and about.html missing, got %+v", report.Problems)
+	}
+
+	report, _ = Check(db, 1)
+	if report.FilesChecked != 1 {
+		t.Errorf("expected a 1%% sample to check one file, checked %d", report.FilesChecked)
+	}
+}
+
+func TestCheckBaselinesUnrecordedDeployments(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	site := filepath.Join(t.TempDir(), "old")
+	writeSite(t, site, map[string]string{"index.html": "home"})
+	db.Exec("INSERT INTO deployments (id, filename, path) VALUES ('old', 'a.zip', ?)", site)
+
+	report, _ := Check(db, 100)
+	if report.Baselined != 1 || report.FilesChecked != 0 {
+		t.Errorf("expected the deployment to be baselined, got %+v", report)
+	}
+	if files, _ := Manifest(db, "old"); len(files) != 1 {
+		t.Errorf("expected a recorded manifest, got %+v", files)
+	}
+}
+
+func TestSaveKeepsRecentReports(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for i := 0; i < reportsKept+5; i++ {
+		if err := Save(db, &Report{StartedAt: time.Now(), FilesChecked: i}); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	reports, err := Reports(db, 100)
+	if err != nil || len(reports) != reportsKept {
+		t.Fatalf("expected %d reports, got %d, %v", reportsKept, len(reports), err)
+	}
+	if reports[0].FilesChecked != reportsKept+4 || reports[0].ID == 0 {
+		t.Errorf("expected the newest report first, got %+v", reports[0])
+	}
+}
+
+func TestCheckerNext(t *testing.T) {
+	c := &Checker{Hour: 3}
+	now := time.Date(2024, 5, 1, 2, 30, 0, 0, time.UTC)
+	if next := c.next(now); !next.Equal(time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected later today, got %v", next)
+	}
+	now = time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC)
+	if next := c.next(now); !next.Equal(time.Date(2024, 5, 2, 3, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected tomorrow, got %v", next)
+	}
+}
@@ -0,0 +1,108 @@
+package locks
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ConflictError is returned when a key is already held by another operation
+type ConflictError struct {
+	Key       string
+	Operation string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s is locked by a %s in progress", e.Key, e.Operation)
+}
+
+// Registry hands out non-blocking locks on deployments and sites so that
+// mutations racing each other fail fast instead of interleaving. A lock on
+// everything (bulk delete, reset) conflicts with every other lock.
+type Registry struct {
+	mu   sync.Mutex
+	held map[string]string // key -> operation holding it
+	all  string            // operation holding every key, if any
+}
+
+// New returns an empty registry
+func New() *Registry {
+	return &Registry{held: map[string]string{}}
+}
+
+// TryLock acquires every key for op, or none of them. The returned function
+// releases the keys.
+func (r *Registry) TryLock(op string, keys ...string) (func(), error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.all != "" {
+		return nil, &ConflictError{Key: "every deployment", Operation: r.all}
+	}
+	for _, key := range keys {
+		if holder, ok := r.held[key]; ok {
+			return nil, &ConflictError{Key: key, Operation: holder}
+		}
+	}
+	for _, key := range keys {
+		r.held[key] = op
+	}
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for _, key := range keys {
+			delete(r.held, key)
+		}
+	}, nil
+}
+
+// TryLockAll acquires every key for op; it fails while any key is held
+func (r *Registry) TryLockAll(op string) (func(), error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.all != "" {
+		return nil, &ConflictError{Key: "every deployment", Operation: r.all}
+	}
+	for key, holder := range r.held {
+		return nil, &ConflictError{Key: key, Operation: holder}
+	}
+	r.all = op
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.all = ""
+	}, nil
+}
+
+// Default is the registry shared by the HTTP handlers and background jobs
+var Default = New()
+
+// Deployment is the lock key for a single deployment
+func Deployment(id string) string {
+	return "deployment " + id
+}
+
+// Site is the lock key for a site's revision history; empty for deployments
+// that don't belong to a site
+func Site(slug string) string {
+	if slug == "" {
+		return ""
+	}
+	return "site " + slug
+}
+
+// Keys returns the lock keys for a deployment and, if set, its site
+func Keys(deploymentID, site string) []string {
+	keys := []string{Deployment(deploymentID)}
+	if key := Site(site); key != "" {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Upload is the lock key for a chunked upload being assembled
+func Upload(id string) string {
+	return "upload " + id
+}
@@ -0,0 +1,53 @@
+package locks
+
+import "testing"
+
+func TestTryLockConflicts(t *testing.T) {
+	r := New()
+
+	unlock, err := r.TryLock("rollback", Keys("a", "docs")...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := r.TryLock("delete", Deployment("a")); err == nil {
+		t.Error("expected deleting a locked deployment to conflict")
+	}
+	if _, err := r.TryLock("rollback", Keys("b", "docs")...); err == nil {
+		t.Error("expected a second mutation of the same site to conflict")
+	}
+	if _, err := r.TryLockAll("reset"); err == nil {
+		t.Error("expected reset to conflict with a held lock")
+	}
+
+	// A failed attempt must not leave partial locks behind
+	other, err := r.TryLock("delete", Deployment("b"))
+	if err != nil {
+		t.Fatalf("expected unrelated deployment to lock: %v", err)
+	}
+	other()
+
+	unlock()
+	if _, err := r.TryLock("delete", Deployment("a")); err != nil {
+		t.Errorf("expected lock to be released: %v", err)
+	}
+}
+
+func TestTryLockAll(t *testing.T) {
+	r := New()
+
+	unlock, err := r.TryLockAll("reset")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = r.TryLock("delete", Deployment("a"))
+	conflict, ok := err.(*ConflictError)
+	if !ok || conflict.Operation != "reset" {
+		t.Errorf("expected conflict with reset, got %v", err)
+	}
+
+	unlock()
+	if _, err := r.TryLock("delete", Deployment("a")); err != nil {
+		t.Errorf("expected lock to be released: %v", err)
+	}
+}
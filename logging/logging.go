@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"static-site-hosting/config"
+)
+
+// Setup points the application logger at the configured file and remote sink
+// and returns the writer to use for access logs (nil means the application
+// log). The returned func flushes and closes everything that was opened.
+func Setup(cfg *config.Config) (access io.Writer, closeFn func(), err error) {
+	var closers []io.Closer
+	closeFn = func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i].Close()
+		}
+	}
+
+	var app io.Writer = os.Stderr
+	if cfg.LogFile != "" {
+		f, err := openRotating(cfg, cfg.LogFile)
+		if err != nil {
+			return nil, closeFn, err
+		}
+		closers = append(closers, f)
+		app = f
+	}
+
+	if cfg.AccessLogFile != "" {
+		f, err := openRotating(cfg, cfg.AccessLogFile)
+		if err != nil {
+			closeFn()
+			return nil, func() {}, err
+		}
+		closers = append(closers, f)
+		access = f
+	}
+
+	if cfg.LogShipTarget != "" {
+		sink, err := NewSink(cfg.LogShipTarget, cfg.LogShipEndpoint)
+		if err != nil {
+			closeFn()
+			return nil, func() {}, err
+		}
+		shipper := NewShipper(sink)
+		closers = append(closers, shipper)
+
+		if access == nil {
+			access = app
+		}
+		app = io.MultiWriter(app, shipper.Writer("app"))
+		access = io.MultiWriter(access, shipper.Writer("access"))
+	}
+
+	log.SetOutput(app)
+	return access, closeFn, nil
+}
+
+func openRotating(cfg *config.Config, filename string) (*RotatingFile, error) {
+	return NewRotatingFile(filename, int64(cfg.LogMaxSizeMB)<<20, cfg.LogMaxAge, cfg.LogMaxBackups, cfg.LogCompress)
+}
@@ -0,0 +1,285 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// pruneInterval is how often backups past MaxAge are removed between
+// rotations, so a log that rarely grows doesn't keep them for ever
+var pruneInterval = time.Hour
+
+// RotatingFile is an io.WriteCloser that rotates the underlying file once it
+// grows past MaxSize bytes and prunes old backups by age and count.
+type RotatingFile struct {
+	Filename   string
+	MaxSize    int64         // bytes; 0 disables size-based rotation
+	MaxAge     time.Duration // 0 keeps backups regardless of age
+	MaxBackups int           // 0 keeps every backup
+	Compress   bool          // gzip rotated files
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewRotatingFile opens (or creates) filename for appending. Backups past
+// maxAge are pruned straight away and then every pruneInterval until the
+// file is closed.
+func NewRotatingFile(filename string, maxSize int64, maxAge time.Duration, maxBackups int, compress bool) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		Filename:   filename,
+		MaxSize:    maxSize,
+		MaxAge:     maxAge,
+		MaxBackups: maxBackups,
+		Compress:   compress,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	rf.prune()
+	if maxAge > 0 {
+		rf.stop = make(chan struct{})
+		go rf.pruneEvery(pruneInterval)
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) pruneEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rf.prune()
+		case <-rf.stop:
+			return
+		}
+	}
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if rf.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.MaxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close closes the current log file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.stop != nil {
+		rf.stopOnce.Do(func() { close(rf.stop) })
+	}
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+func (rf *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(rf.Filename), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(rf.Filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	rf.file = f
+	rf.size = info.Size()
+	return nil
+}
+
+// rotate moves the current file aside, reopens a fresh one and prunes backups.
+// Caller must hold rf.mu.
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+
+	backup := rf.backupName(time.Now())
+	if err := os.Rename(rf.Filename, backup); err != nil {
+		return err
+	}
+
+	if err := rf.open(); err != nil {
+		return err
+	}
+
+	// Compression and pruning don't need to block writers
+	go rf.cleanup(backup)
+	return nil
+}
+
+// backupName returns e.g. logs/access-2024-06-01T10-00-00.000.log, or
+// logs/access-2024-06-01T10-00-00.000-1.log and so on when a backup from
+// the same millisecond, compressed or not, already has that name. Stamps are
+// in UTC, as backups parses them.
+func (rf *RotatingFile) backupName(t time.Time) string {
+	dir := filepath.Dir(rf.Filename)
+	ext := filepath.Ext(rf.Filename)
+	prefix := strings.TrimSuffix(filepath.Base(rf.Filename), ext)
+	stamp := t.UTC().Format(backupTimeFormat)
+	for n := 0; ; n++ {
+		name := filepath.Join(dir, fmt.Sprintf("%s-%s%s", prefix, stamp, ext))
+		if n > 0 {
+			name = filepath.Join(dir, fmt.Sprintf("%s-%s-%d%s", prefix, stamp, n, ext))
+		}
+		if !exists(name) && !exists(name+".gz") {
+			return name
+		}
+	}
+}
+
+func exists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
+
+func (rf *RotatingFile) cleanup(latest string) {
+	if rf.Compress {
+		if err := compressFile(latest); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to compress log %s: %v\n", latest, err)
+		}
+	}
+	rf.prune()
+}
+
+// prune removes backups past MaxAge and beyond MaxBackups
+func (rf *RotatingFile) prune() {
+	backups, err := rf.backups()
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-rf.MaxAge)
+	for i, b := range backups {
+		expired := rf.MaxAge > 0 && b.t.Before(cutoff)
+		excess := rf.MaxBackups > 0 && i >= rf.MaxBackups
+		if expired || excess {
+			os.Remove(b.path)
+		}
+	}
+}
+
+type backupFile struct {
+	path string
+	t    time.Time
+	seq  int // uniqueness suffix among backups of the same millisecond
+}
+
+// backups lists rotated files for this log, newest first
+func (rf *RotatingFile) backups() ([]backupFile, error) {
+	dir := filepath.Dir(rf.Filename)
+	ext := filepath.Ext(rf.Filename)
+	prefix := strings.TrimSuffix(filepath.Base(rf.Filename), ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []backupFile
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext)
+		stamp = strings.TrimPrefix(stamp, prefix)
+		if len(stamp) < len(backupTimeFormat) {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, stamp[:len(backupTimeFormat)])
+		if err != nil {
+			continue
+		}
+		seq := 0
+		if suffix := stamp[len(backupTimeFormat):]; suffix != "" {
+			if seq, err = strconv.Atoi(strings.TrimPrefix(suffix, "-")); err != nil || !strings.HasPrefix(suffix, "-") || seq < 1 {
+				continue
+			}
+		}
+		out = append(out, backupFile{path: filepath.Join(dir, name), t: t, seq: seq})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].t.Equal(out[j].t) {
+			return out[i].t.After(out[j].t)
+		}
+		return out[i].seq > out[j].seq
+	})
+	return out, nil
+}
+
+func compressFile(src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	dst := src + ".gz"
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		gz.Close()
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
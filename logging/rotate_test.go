@@ -0,0 +1,159 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileRotatesOnSize(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+
+	rf, err := NewRotatingFile(logPath, 10, 0, 0, false)
+	if err != nil {
+		t.Fatalf("failed to open rotating file: %v", err)
+	}
+	defer rf.Close()
+
+	rf.Write([]byte("0123456789"))
+	rf.Write([]byte("abc"))
+
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+	if string(content) != "abc" {
+		t.Errorf("expected current log to contain %q, got %q", "abc", string(content))
+	}
+
+	backups, _ := rf.backups()
+	if len(backups) != 1 {
+		t.Fatalf("expected 1 backup, got %d", len(backups))
+	}
+}
+
+func TestRotatingFilePrunesBackups(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "access.log")
+
+	rf := &RotatingFile{Filename: logPath, MaxAge: 24 * time.Hour, MaxBackups: 1}
+
+	old := rf.backupName(time.Now().Add(-48 * time.Hour))
+	recent := rf.backupName(time.Now().Add(-time.Hour))
+	older := rf.backupName(time.Now().Add(-2 * time.Hour))
+	for _, p := range []string{old, recent, older} {
+		os.WriteFile(p, []byte("x"), 0644)
+	}
+
+	rf.cleanup(recent)
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("expected expired backup to be removed")
+	}
+	if _, err := os.Stat(older); !os.IsNotExist(err) {
+		t.Error("expected backup beyond MaxBackups to be removed")
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Error("expected newest backup to be kept")
+	}
+}
+
+func TestCompressFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "app-2024-01-01T00-00-00.000.log")
+	os.WriteFile(src, []byte(strings.Repeat("log line\n", 100)), 0644)
+
+	if err := compressFile(src); err != nil {
+		t.Fatalf("compressFile failed: %v", err)
+	}
+
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("expected original file to be removed")
+	}
+	if _, err := os.Stat(src + ".gz"); err != nil {
+		t.Error("expected gzip file to exist")
+	}
+}
+
+func TestRotatingFileBackupNamesAreUnique(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+
+	// Every write past the first rotates, many within the same millisecond
+	rf, err := NewRotatingFile(logPath, 1, 0, 0, false)
+	if err != nil {
+		t.Fatalf("failed to open rotating file: %v", err)
+	}
+	defer rf.Close()
+	for i := 0; i < 20; i++ {
+		rf.Write([]byte("x"))
+	}
+
+	backups, _ := rf.backups()
+	if len(backups) != 19 {
+		t.Fatalf("expected 19 backups, got %d", len(backups))
+	}
+
+	then := time.Now().Add(-time.Hour)
+	first := rf.backupName(then)
+	os.WriteFile(first+".gz", []byte("x"), 0644)
+	if second := rf.backupName(then); second == first || !strings.HasSuffix(second, "-1.log") {
+		t.Errorf("expected a suffixed name next to a compressed backup, got %s", second)
+	}
+}
+
+func TestRotatingFilePrunesWithoutRotating(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "access.log")
+
+	previous := pruneInterval
+	pruneInterval = 10 * time.Millisecond
+	defer func() { pruneInterval = previous }()
+
+	probe := &RotatingFile{Filename: logPath}
+	expired := probe.backupName(time.Now().Add(-48 * time.Hour))
+	os.WriteFile(expired, []byte("x"), 0644)
+
+	rf, err := NewRotatingFile(logPath, 0, 24*time.Hour, 0, false)
+	if err != nil {
+		t.Fatalf("failed to open rotating file: %v", err)
+	}
+	defer rf.Close()
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Error("expected an expired backup to be removed on open")
+	}
+
+	// Backups expiring later go without waiting for a rotation
+	later := probe.backupName(time.Now().Add(-25 * time.Hour))
+	os.WriteFile(later, []byte("x"), 0644)
+	deadline := time.Now().Add(2 * time.Second)
+	for exists(later) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if exists(later) {
+		t.Error("expected an expired backup to be pruned periodically")
+	}
+}
+
+func TestRotatingFileBackupAgesIgnoreTimeZone(t *testing.T) {
+	saved := time.Local
+	time.Local = time.FixedZone("UTC-5", -5*60*60)
+	defer func() { time.Local = saved }()
+
+	rf := &RotatingFile{Filename: filepath.Join(t.TempDir(), "access.log"), MaxAge: 24 * time.Hour}
+	taken := time.Now().Add(-22 * time.Hour)
+	recent := rf.backupName(taken)
+	os.WriteFile(recent, []byte("x"), 0644)
+
+	backups, _ := rf.backups()
+	if len(backups) != 1 || backups[0].t.Sub(taken).Abs() > time.Millisecond {
+		t.Fatalf("expected the backup to be dated %v, got %+v", taken, backups)
+	}
+	rf.prune()
+	if _, err := os.Stat(recent); err != nil {
+		t.Error("expected a backup within MaxAge to be kept west of UTC")
+	}
+}
@@ -0,0 +1,269 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a single structured log line shipped to a remote sink
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Stream  string    `json:"stream"` // "app" or "access"
+	Host    string    `json:"host"`
+	Message string    `json:"message"`
+}
+
+// Sink delivers batches of entries to a remote system
+type Sink interface {
+	Send(entries []Entry) error
+	Close() error
+}
+
+// Shipper batches log entries in the background and hands them to a Sink.
+// Writes never block: when the buffer is full new entries are dropped.
+type Shipper struct {
+	sink     Sink
+	host     string
+	entries  chan Entry
+	interval time.Duration
+	batch    int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewShipper starts a background shipper for sink
+func NewShipper(sink Sink) *Shipper {
+	host, _ := os.Hostname()
+	s := &Shipper{
+		sink:     sink,
+		host:     host,
+		entries:  make(chan Entry, 10000),
+		interval: time.Second,
+		batch:    500,
+		done:     make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// Writer returns an io.Writer that turns each write into an entry on stream
+func (s *Shipper) Writer(stream string) *StreamWriter {
+	return &StreamWriter{shipper: s, stream: stream}
+}
+
+// Close flushes pending entries and closes the sink
+func (s *Shipper) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	return s.sink.Close()
+}
+
+func (s *Shipper) enqueue(e Entry) {
+	select {
+	case s.entries <- e:
+	default:
+		// Buffer full; dropping is better than stalling request handling
+	}
+}
+
+func (s *Shipper) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	var pending []Entry
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if err := s.sink.Send(pending); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to ship %d log entries: %v\n", len(pending), err)
+		}
+		pending = nil
+	}
+
+	for {
+		select {
+		case e := <-s.entries:
+			pending = append(pending, e)
+			if len(pending) >= s.batch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-s.done:
+			for {
+				select {
+				case e := <-s.entries:
+					pending = append(pending, e)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// StreamWriter adapts a Shipper to io.Writer for use with log.Logger
+type StreamWriter struct {
+	shipper *Shipper
+	stream  string
+}
+
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	w.shipper.enqueue(Entry{
+		Time:    time.Now(),
+		Stream:  w.stream,
+		Host:    w.shipper.host,
+		Message: strings.TrimRight(string(p), "\n"),
+	})
+	return len(p), nil
+}
+
+// NewSink builds the sink for the configured target: "syslog", "loki" or "http"
+func NewSink(target, endpoint string) (Sink, error) {
+	switch target {
+	case "syslog":
+		return newSyslogSink(endpoint)
+	case "loki":
+		if endpoint == "" {
+			return nil, fmt.Errorf("loki log shipping requires an endpoint URL")
+		}
+		return &lokiSink{url: strings.TrimRight(endpoint, "/") + "/loki/api/v1/push", client: shipClient()}, nil
+	case "http":
+		if endpoint == "" {
+			return nil, fmt.Errorf("http log shipping requires an endpoint URL")
+		}
+		return &httpSink{url: endpoint, client: shipClient()}, nil
+	default:
+		return nil, fmt.Errorf("unknown log shipping target %q", target)
+	}
+}
+
+func shipClient() *http.Client {
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// syslogSink forwards entries to a local or remote syslog daemon.
+// endpoint is empty for the local daemon or e.g. udp://logs:514.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink(endpoint string) (*syslogSink, error) {
+	var network, addr string
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid syslog address %q: %v", endpoint, err)
+		}
+		network, addr = u.Scheme, u.Host
+	}
+
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, "static-site-hosting")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) Send(entries []Entry) error {
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := s.w.Info(string(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
+
+// lokiSink pushes entries to Grafana Loki, one stream per log stream name
+type lokiSink struct {
+	url    string
+	client *http.Client
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (s *lokiSink) Send(entries []Entry) error {
+	streams := map[string]*lokiStream{}
+	var order []string
+	for _, e := range entries {
+		key := e.Stream + "|" + e.Host
+		ls, ok := streams[key]
+		if !ok {
+			ls = &lokiStream{Stream: map[string]string{
+				"app":    "static-site-hosting",
+				"stream": e.Stream,
+				"host":   e.Host,
+			}}
+			streams[key] = ls
+			order = append(order, key)
+		}
+		ls.Values = append(ls.Values, [2]string{strconv.FormatInt(e.Time.UnixNano(), 10), e.Message})
+	}
+
+	payload := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, key := range order {
+		payload.Streams = append(payload.Streams, streams[key])
+	}
+
+	return postJSON(s.client, s.url, payload)
+}
+
+func (s *lokiSink) Close() error { return nil }
+
+// httpSink posts entries as a JSON array to an arbitrary collector
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) Send(entries []Entry) error {
+	return postJSON(s.client, s.url, entries)
+}
+
+func (s *httpSink) Close() error { return nil }
+
+func postJSON(client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("log collector returned %s", resp.Status)
+	}
+	return nil
+}
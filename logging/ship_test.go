@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestShipperHTTPSink(t *testing.T) {
+	var mu sync.Mutex
+	var received []Entry
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []Entry
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("failed to decode batch: %v", err)
+		}
+		mu.Lock()
+		received = append(received, batch...)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	sink, err := NewSink("http", server.URL)
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	shipper := NewShipper(sink)
+
+	logger := log.New(shipper.Writer("access"), "", 0)
+	logger.Println("GET /index.html")
+	shipper.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 {
+		t.Fatalf("expected 1 shipped entry, got %d", len(received))
+	}
+	if received[0].Stream != "access" || received[0].Message != "GET /index.html" {
+		t.Errorf("unexpected entry: %+v", received[0])
+	}
+}
+
+func TestLokiSinkPayload(t *testing.T) {
+	var payload struct {
+		Streams []lokiStream `json:"streams"`
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/push" {
+			t.Errorf("unexpected push path %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, _ := NewSink("loki", server.URL)
+	err := sink.Send([]Entry{
+		{Stream: "app", Host: "a", Message: "one"},
+		{Stream: "access", Host: "a", Message: "two"},
+		{Stream: "app", Host: "a", Message: "three"},
+	})
+	if err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	if len(payload.Streams) != 2 {
+		t.Fatalf("expected 2 streams, got %d", len(payload.Streams))
+	}
+	if len(payload.Streams[0].Values) != 2 {
+		t.Errorf("expected app stream to have 2 values, got %d", len(payload.Streams[0].Values))
+	}
+}
+
+func TestNewSinkUnknownTarget(t *testing.T) {
+	if _, err := NewSink("carrier-pigeon", ""); err == nil {
+		t.Error("expected error for unknown target")
+	}
+}
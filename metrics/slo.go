@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// FastThreshold is the latency under which a static request counts as "fast"
+const FastThreshold = 100 * time.Millisecond
+
+// Windows over which SLIs are reported
+var Windows = []struct {
+	Name     string
+	Duration time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+const bucketWidth = time.Minute
+
+type bucket struct {
+	minute     int64 // unix minute this bucket currently holds
+	requests   int64
+	errors     int64 // 5xx responses
+	static     int64
+	staticFast int64
+}
+
+// Counters are monotonic totals since process start
+type Counters struct {
+	Requests   int64
+	Errors     int64
+	Static     int64
+	StaticFast int64
+}
+
+// Recorder tracks request outcomes in one-minute buckets covering the longest window
+type Recorder struct {
+	mu      sync.Mutex
+	buckets []bucket
+	totals  Counters
+	now     func() time.Time
+}
+
+// NewRecorder creates a recorder sized for the longest window
+func NewRecorder() *Recorder {
+	longest := Windows[len(Windows)-1].Duration
+	return &Recorder{
+		buckets: make([]bucket, int(longest/bucketWidth)),
+		now:     time.Now,
+	}
+}
+
+// Observe records one finished request
+func (rec *Recorder) Observe(class string, status int, elapsed time.Duration) {
+	minute := rec.now().Unix() / 60
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	b := &rec.buckets[minute%int64(len(rec.buckets))]
+	if b.minute != minute {
+		*b = bucket{minute: minute}
+	}
+
+	b.requests++
+	rec.totals.Requests++
+	if status >= 500 {
+		b.errors++
+		rec.totals.Errors++
+	}
+	if class == "static" {
+		b.static++
+		rec.totals.Static++
+		if elapsed < FastThreshold {
+			b.staticFast++
+			rec.totals.StaticFast++
+		}
+	}
+}
+
+// Totals returns counters since process start
+func (rec *Recorder) Totals() Counters {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.totals
+}
+
+// Window sums the buckets falling inside the trailing duration d
+func (rec *Recorder) Window(d time.Duration) Counters {
+	current := rec.now().Unix() / 60
+	oldest := current - int64(d/bucketWidth) + 1
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	var c Counters
+	for _, b := range rec.buckets {
+		if b.minute < oldest || b.minute > current {
+			continue
+		}
+		c.Requests += b.requests
+		c.Errors += b.errors
+		c.Static += b.static
+		c.StaticFast += b.staticFast
+	}
+	return c
+}
+
+// Availability is the fraction of requests that did not fail with a 5xx.
+// With no traffic the service is considered fully available.
+func (c Counters) Availability() float64 {
+	if c.Requests == 0 {
+		return 1
+	}
+	return 1 - float64(c.Errors)/float64(c.Requests)
+}
+
+// LatencySLI is the fraction of static requests served under FastThreshold
+func (c Counters) LatencySLI() float64 {
+	if c.Static == 0 {
+		return 1
+	}
+	return float64(c.StaticFast) / float64(c.Static)
+}
+
+// ErrorBudgetRemaining reports how much of the allowed failure ratio for
+// target (e.g. 0.999) is still unspent: 1 is untouched, 0 or below is exhausted.
+func ErrorBudgetRemaining(sli, target float64) float64 {
+	allowed := 1 - target
+	if allowed <= 0 {
+		return 0
+	}
+	return 1 - (1-sli)/allowed
+}
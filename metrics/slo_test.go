@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecorderWindows(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	rec := NewRecorder()
+	rec.now = func() time.Time { return now }
+
+	rec.Observe("static", 200, 10*time.Millisecond)
+	rec.Observe("static", 200, 300*time.Millisecond)
+	rec.Observe("api", 500, time.Millisecond)
+
+	// Two hours later, one more request
+	now = now.Add(2 * time.Hour)
+	rec.Observe("api", 200, time.Millisecond)
+
+	recent := rec.Window(5 * time.Minute)
+	if recent.Requests != 1 || recent.Errors != 0 {
+		t.Errorf("expected 1 request and 0 errors in 5m window, got %+v", recent)
+	}
+
+	day := rec.Window(24 * time.Hour)
+	if day.Requests != 4 || day.Errors != 1 {
+		t.Errorf("expected 4 requests and 1 error in 24h window, got %+v", day)
+	}
+	if day.LatencySLI() != 0.5 {
+		t.Errorf("expected latency SLI 0.5, got %v", day.LatencySLI())
+	}
+	if day.Availability() != 0.75 {
+		t.Errorf("expected availability 0.75, got %v", day.Availability())
+	}
+
+	if rec.Totals().Requests != 4 {
+		t.Errorf("expected 4 total requests, got %d", rec.Totals().Requests)
+	}
+}
+
+func TestErrorBudgetRemaining(t *testing.T) {
+	tests := []struct {
+		sli, target, expected float64
+	}{
+		{1, 0.99, 1},
+		{0.995, 0.99, 0.5},
+		{0.98, 0.99, -1},
+	}
+
+	for _, tt := range tests {
+		got := ErrorBudgetRemaining(tt.sli, tt.target)
+		if diff := got - tt.expected; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("ErrorBudgetRemaining(%v, %v) = %v, expected %v", tt.sli, tt.target, got, tt.expected)
+		}
+	}
+}
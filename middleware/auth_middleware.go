@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+
+	"static-site-hosting/auth"
+)
+
+// AuthMiddleware attaches the caller's claims to the request context when a
+// valid token is presented as a Bearer header or session cookie. Requests
+// without credentials pass through anonymously; invalid credentials get 401.
+// Each rejected token counts as a failure for the client's IP in throttle;
+// once the IP is locked out its credentials are refused with 429 unchecked.
+// Session tokens are also refused, and their cookie cleared, once
+// sessionActive reports their session expired or logged out. A session
+// cookie sent with an unsafe method but without its CSRF token is ignored,
+// so requests other pages forge pass through anonymously.
+func AuthMiddleware(signer *auth.Signer, throttle *auth.Throttle, sessionActive func(sessionID string) bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := auth.RequestToken(r)
+		if token == "" || auth.CookieWithoutCSRF(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ipKey := throttle.IPKey(r)
+		if wait := throttle.Wait(ipKey); wait > 0 {
+			throttle.Audit(r, auth.AuditEntry{Event: auth.AuditThrottled, Detail: "token"})
+			w.Header().Set("Retry-After", auth.RetryAfter(wait))
+			http.Error(w, "Too many failed authentication attempts", http.StatusTooManyRequests)
+			return
+		}
+
+		claims, err := signer.Verify(token)
+		if err != nil {
+			throttle.Fail(ipKey)
+			throttle.Audit(r, auth.AuditEntry{Event: auth.AuditTokenRejected, Detail: err.Error()})
+			http.Error(w, "Invalid or expired credentials", http.StatusUnauthorized)
+			return
+		}
+		if claims.SessionID != "" && sessionActive != nil && !sessionActive(claims.SessionID) {
+			http.SetCookie(w, &http.Cookie{Name: auth.SessionCookie, Path: "/", MaxAge: -1})
+			http.Error(w, "Session expired or logged out", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+	})
+}
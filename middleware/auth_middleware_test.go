@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"static-site-hosting/auth"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	signer := auth.NewSigner([]byte("secret"))
+	token, _ := signer.Issue(auth.Claims{Subject: "user-1", Role: auth.RoleAdmin}, time.Hour)
+	live, _ := signer.Issue(auth.Claims{Subject: "user-1", SessionID: "live"}, time.Hour)
+	ended, _ := signer.Issue(auth.Claims{Subject: "user-1", SessionID: "ended"}, time.Hour)
+	loginState, _ := signer.Issue(auth.Claims{Purpose: auth.PurposeLoginState, State: "abc"}, time.Hour)
+
+	var seen *auth.Claims
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = auth.FromContext(r.Context())
+	})
+	handler := AuthMiddleware(signer, nil, func(id string) bool { return id == "live" }, next)
+
+	tests := []struct {
+		name           string
+		setup          func(r *http.Request)
+		expectedStatus int
+		expectSubject  string
+	}{
+		{"anonymous", func(r *http.Request) {}, http.StatusOK, ""},
+		{"bearer token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }, http.StatusOK, "user-1"},
+		{"session cookie", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: token}) }, http.StatusOK, "user-1"},
+		{"invalid token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized, ""},
+		{"live session", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: live}) }, http.StatusOK, "user-1"},
+		{"ended session", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: ended}) }, http.StatusUnauthorized, ""},
+		{"ended session bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+ended) }, http.StatusUnauthorized, ""},
+		{"login state token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+loginState) }, http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			tt.setup(req)
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			subject := ""
+			if seen != nil {
+				subject = seen.Subject
+			}
+			if subject != tt.expectSubject {
+				t.Errorf("expected subject %q, got %q", tt.expectSubject, subject)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareThrottlesRejectedTokens(t *testing.T) {
+	signer := auth.NewSigner([]byte("secret"))
+	token, _ := signer.Issue(auth.Claims{Subject: "user-1"}, time.Hour)
+	throttle := auth.NewThrottle(2, time.Minute, time.Hour)
+	handler := AuthMiddleware(signer, throttle, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(token, addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = addr
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 3; i++ {
+		if rr := request("guess", "10.0.0.1:1234"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i+1, rr.Code)
+		}
+	}
+	rr := request(token, "10.0.0.1:1234")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 once the IP is locked out, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected Retry-After 60, got %q", rr.Header().Get("Retry-After"))
+	}
+	if rr := request(token, "10.0.0.2:1234"); rr.Code != http.StatusOK {
+		t.Errorf("expected other clients to be unaffected, got %d", rr.Code)
+	}
+}
+
+func TestAuthMiddlewareRequiresCSRFTokenWithCookies(t *testing.T) {
+	signer := auth.NewSigner([]byte("secret"))
+	token, _ := signer.Issue(auth.Claims{Subject: "user-1"}, time.Hour)
+
+	var seen *auth.Claims
+	handler := AuthMiddleware(signer, nil, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = auth.FromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name          string
+		method        string
+		setup         func(r *http.Request)
+		expectSubject string
+	}{
+		{"cookie read", http.MethodGet, func(r *http.Request) {}, "user-1"},
+		{"cookie write without token", http.MethodPost, func(r *http.Request) {}, ""},
+		{"cookie write with wrong token", http.MethodDelete, func(r *http.Request) { r.Header.Set(auth.CSRFHeader, auth.CSRFToken("other")) }, ""},
+		{"cookie write with token", http.MethodPost, func(r *http.Request) { r.Header.Set(auth.CSRFHeader, auth.CSRFToken(token)) }, "user-1"},
+		{"bearer write", http.MethodPut, func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }, "user-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.AddCookie(&http.Cookie{Name: auth.SessionCookie, Value: token})
+			tt.setup(req)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("expected status 200, got %d", rr.Code)
+			}
+			subject := ""
+			if seen != nil {
+				subject = seen.Subject
+			}
+			if subject != tt.expectSubject {
+				t.Errorf("expected subject %q, got %q", tt.expectSubject, subject)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+
+	"static-site-hosting/auth"
+)
+
+// AuthorizeMiddleware refuses requests from callers whose role doesn't
+// reach the one required returns for them ("" for public requests).
+// Anonymous callers act with anonymousRole; if that is empty they get 401
+// for anything not public, authenticated callers 403 for what their role
+// doesn't allow.
+func AuthorizeMiddleware(required func(*http.Request) string, anonymousRole string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		need := required(r)
+		claims := auth.FromContext(r.Context())
+		role := anonymousRole
+		if claims != nil {
+			role = claims.Role
+		}
+		if auth.Allows(role, need) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if claims == nil {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Forbidden: requires the "+need+" role", http.StatusForbidden)
+	})
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"static-site-hosting/auth"
+)
+
+func TestAuthorizeMiddleware(t *testing.T) {
+	// Reads need a viewer, /reset an admin and other changes a deployer
+	required := func(r *http.Request) string {
+		switch {
+		case r.URL.Path == "/public":
+			return ""
+		case r.URL.Path == "/reset":
+			return auth.RoleAdmin
+		case r.Method == http.MethodGet:
+			return auth.RoleViewer
+		}
+		return auth.RoleDeployer
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name           string
+		anonymousRole  string
+		role           string // "" for anonymous
+		method, path   string
+		expectedStatus int
+	}{
+		{"viewer reads", "", auth.RoleViewer, http.MethodGet, "/deployments", http.StatusOK},
+		{"viewer uploads", "", auth.RoleViewer, http.MethodPost, "/upload", http.StatusForbidden},
+		{"deployer uploads", "", auth.RoleDeployer, http.MethodPost, "/upload", http.StatusOK},
+		{"deployer resets", "", auth.RoleDeployer, http.MethodPost, "/reset", http.StatusForbidden},
+		{"admin resets", "", auth.RoleAdmin, http.MethodPost, "/reset", http.StatusOK},
+		{"unknown role", "", "superuser", http.MethodGet, "/deployments", http.StatusForbidden},
+		{"anonymous public", "", "", http.MethodGet, "/public", http.StatusOK},
+		{"anonymous without a role", "", "", http.MethodGet, "/deployments", http.StatusUnauthorized},
+		{"anonymous viewer reads", auth.RoleViewer, "", http.MethodGet, "/deployments", http.StatusOK},
+		{"anonymous viewer uploads", auth.RoleViewer, "", http.MethodPost, "/upload", http.StatusUnauthorized},
+		{"anonymous admin", auth.RoleAdmin, "", http.MethodPost, "/reset", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.role != "" {
+				req = req.WithContext(auth.WithClaims(req.Context(), &auth.Claims{Subject: "user-1", Role: tt.role}))
+			}
+			rr := httptest.NewRecorder()
+			AuthorizeMiddleware(required, tt.anonymousRole, next).ServeHTTP(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// AvailabilityMiddleware answers requests that need the database with 503
+// while available reports it down. Static file serving, and anything else
+// needsDB declines, carries on from the filesystem and routing cache.
+func AvailabilityMiddleware(available func() bool, needsDB func(*http.Request) bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available() && needsDB(r) {
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "Database unavailable, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
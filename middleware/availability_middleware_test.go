@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAvailabilityMiddleware(t *testing.T) {
+	up := true
+	needsDB := func(r *http.Request) bool { return !strings.HasPrefix(r.URL.Path, "/s/") }
+	handler := AvailabilityMiddleware(func() bool { return up }, needsDB, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	if rr := request("/deployments"); rr.Code != http.StatusOK {
+		t.Errorf("expected API requests to pass while the database is up, got %d", rr.Code)
+	}
+
+	up = false
+	rr := request("/deployments")
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After for API requests while the database is down, got %d", rr.Code)
+	}
+	if rr := request("/s/site/index.html"); rr.Code != http.StatusOK {
+		t.Errorf("expected static requests to be served while the database is down, got %d", rr.Code)
+	}
+}
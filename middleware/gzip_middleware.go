@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressibleTypes are the API response types worth compressing
+var compressibleTypes = []string{"application/json", "application/xml", "text/csv", "text/xml"}
+
+// GzipMiddleware compresses API responses for clients that accept gzip.
+// Only requests classify labels "api" are considered, and only JSON, XML
+// and CSV bodies of at least minSize bytes are compressed; smaller bodies
+// are sent as is since gzip would make them larger. Negotiable responses
+// always carry Vary: Accept-Encoding so caches keep the variants apart.
+func GzipMiddleware(minSize int, classify func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if classify(r) != "api" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipWriter{ResponseWriter: w, minSize: minSize, accepts: acceptsGzip(r.Header.Get("Accept-Encoding"))}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// gzipWriter buffers the start of a response until it knows whether the
+// body is large enough to compress
+type gzipWriter struct {
+	http.ResponseWriter
+	minSize int
+	accepts bool
+
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (gw *gzipWriter) WriteHeader(code int) {
+	// 1xx responses such as 103 Early Hints go out immediately
+	if code < 200 {
+		gw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if gw.status == 0 {
+		gw.status = code
+	}
+}
+
+func (gw *gzipWriter) Write(b []byte) (int, error) {
+	if gw.status == 0 {
+		gw.status = http.StatusOK
+	}
+	if !gw.decided {
+		if !gw.negotiable() {
+			gw.passthrough()
+		} else {
+			gw.buf = append(gw.buf, b...)
+			if len(gw.buf) < gw.minSize {
+				return len(b), nil
+			}
+			if err := gw.start(); err != nil {
+				return 0, err
+			}
+			return len(b), nil
+		}
+	}
+
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+	return gw.ResponseWriter.Write(b)
+}
+
+// negotiable reports whether the response could be served compressed
+func (gw *gzipWriter) negotiable() bool {
+	h := gw.Header()
+	if h.Get("Content-Encoding") != "" || gw.status == http.StatusNoContent || gw.status == http.StatusNotModified {
+		return false
+	}
+	contentType := strings.ToLower(h.Get("Content-Type"))
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return strings.Contains(contentType, "+json")
+}
+
+// start commits to the compressed variant, if the client accepts it, and
+// writes the buffered bytes
+func (gw *gzipWriter) start() error {
+	gw.decided = true
+	h := gw.Header()
+	h.Add("Vary", "Accept-Encoding")
+	if !gw.accepts {
+		gw.ResponseWriter.WriteHeader(gw.status)
+		_, err := gw.ResponseWriter.Write(gw.buf)
+		gw.buf = nil
+		return err
+	}
+
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	gw.ResponseWriter.WriteHeader(gw.status)
+	gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	_, err := gw.gz.Write(gw.buf)
+	gw.buf = nil
+	return err
+}
+
+// passthrough sends the response unmodified
+func (gw *gzipWriter) passthrough() {
+	gw.decided = true
+	gw.ResponseWriter.WriteHeader(gw.status)
+}
+
+// Close flushes whatever is still buffered
+func (gw *gzipWriter) Close() error {
+	if !gw.decided {
+		if gw.status == 0 {
+			// Nothing was written; let net/http send its implicit 200
+			return nil
+		}
+		if gw.buf == nil || !gw.negotiable() {
+			gw.passthrough()
+			return nil
+		}
+		// Too small to be worth compressing, but still a negotiable response
+		gw.decided = true
+		gw.Header().Add("Vary", "Accept-Encoding")
+		gw.Header().Set("Content-Length", strconv.Itoa(len(gw.buf)))
+		gw.ResponseWriter.WriteHeader(gw.status)
+		_, err := gw.ResponseWriter.Write(gw.buf)
+		return err
+	}
+	if gw.gz != nil {
+		return gw.gz.Close()
+	}
+	return nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (gw *gzipWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		return q > 0
+	}
+	return false
+}
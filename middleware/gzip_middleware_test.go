@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func apiClass(*http.Request) string { return "api" }
+
+func jsonHandler(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	})
+}
+
+func TestGzipMiddlewareCompressesLargeJSON(t *testing.T) {
+	body := `[` + strings.Repeat(`{"id":"abc","filename":"site.zip"},`, 100) + `{}]`
+	handler := GzipMiddleware(1024, apiClass, jsonHandler(body))
+
+	req := httptest.NewRequest(http.MethodGet, "/deployments", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip encoding, got %q", rr.Header().Get("Content-Encoding"))
+	}
+	if rr.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("expected Vary: Accept-Encoding, got %q", rr.Header().Get("Vary"))
+	}
+	zr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("invalid gzip body: %v", err)
+	}
+	decoded, _ := io.ReadAll(zr)
+	if string(decoded) != body {
+		t.Error("decompressed body does not match")
+	}
+}
+
+func TestGzipMiddlewareSkips(t *testing.T) {
+	large := strings.Repeat("x", 4096)
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		handler        http.Handler
+		class          string
+		wantVary       bool
+	}{
+		{"client does not accept gzip", "identity", jsonHandler(`{"data":"` + large + `"}`), "api", true},
+		{"gzip refused with q=0", "gzip;q=0", jsonHandler(`{"data":"` + large + `"}`), "api", true},
+		{"below minimum size", "gzip", jsonHandler(`{"ok":true}`), "api", true},
+		{"static request", "gzip", jsonHandler(`{"data":"` + large + `"}`), "static", false},
+		{"non-JSON body", "gzip", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, large, http.StatusInternalServerError)
+		}), "api", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class := func(*http.Request) string { return tt.class }
+			req := httptest.NewRequest(http.MethodGet, "/deployments", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rr := httptest.NewRecorder()
+			GzipMiddleware(1024, class, tt.handler).ServeHTTP(rr, req)
+
+			if rr.Header().Get("Content-Encoding") != "" {
+				t.Errorf("expected no compression, got %q", rr.Header().Get("Content-Encoding"))
+			}
+			if got := rr.Header().Get("Vary") == "Accept-Encoding"; got != tt.wantVary {
+				t.Errorf("expected Vary set=%v, got %q", tt.wantVary, rr.Header().Get("Vary"))
+			}
+			if rr.Body.Len() == 0 {
+				t.Error("expected body to be passed through")
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"static-site-hosting/i18n"
+)
+
+// LocalizeMiddleware translates the error messages of API responses into
+// the language the client prefers by Accept-Language. Only responses with
+// an error status are touched: plain text bodies line by line, and JSON
+// bodies by their "error" and "message" fields, so codes, field names and
+// everything else clients match on stay as they are. Requests asking for
+// English, or a language the catalog doesn't know, pass through.
+func LocalizeMiddleware(catalog *i18n.Catalog, classify func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if classify(r) != "api" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Language")
+		lang := catalog.Negotiate(r.Header.Get("Accept-Language"))
+		if lang == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		lw := &localizeWriter{ResponseWriter: w, catalog: catalog, lang: lang}
+		defer lw.Close()
+		next.ServeHTTP(lw, r)
+	})
+}
+
+// localizeWriter holds back error responses until they are complete, so
+// their messages can be translated as a whole
+type localizeWriter struct {
+	http.ResponseWriter
+	catalog *i18n.Catalog
+	lang    string
+
+	status int
+	buf    bytes.Buffer
+}
+
+func (lw *localizeWriter) WriteHeader(code int) {
+	if code < 200 {
+		lw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if lw.status != 0 {
+		return
+	}
+	lw.status = code
+	if code < 400 {
+		lw.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (lw *localizeWriter) Write(b []byte) (int, error) {
+	if lw.status == 0 {
+		lw.WriteHeader(http.StatusOK)
+	}
+	if lw.status < 400 {
+		return lw.ResponseWriter.Write(b)
+	}
+	return lw.buf.Write(b)
+}
+
+// Close translates and sends a held back error response
+func (lw *localizeWriter) Close() error {
+	if lw.status < 400 {
+		return nil
+	}
+	body := lw.buf.Bytes()
+	contentType := strings.ToLower(lw.Header().Get("Content-Type"))
+	switch {
+	case lw.Header().Get("Content-Encoding") != "":
+	case strings.HasPrefix(contentType, "text/plain"):
+		lines := strings.Split(string(body), "\n")
+		for i, line := range lines {
+			lines[i] = lw.catalog.Translate(lw.lang, line)
+		}
+		body = []byte(strings.Join(lines, "\n"))
+		lw.Header().Set("Content-Language", lw.lang)
+	case strings.HasPrefix(contentType, "application/json") || strings.Contains(contentType, "+json"):
+		if translated, ok := lw.translateJSON(body); ok {
+			body = translated
+			lw.Header().Set("Content-Language", lw.lang)
+		}
+	}
+	lw.Header().Del("Content-Length")
+	lw.ResponseWriter.WriteHeader(lw.status)
+	_, err := lw.ResponseWriter.Write(body)
+	return err
+}
+
+// translateJSON translates the "error" and "message" strings anywhere in
+// a JSON body
+func (lw *localizeWriter) translateJSON(body []byte) ([]byte, bool) {
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, false
+	}
+	var walk func(any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for key, value := range v {
+				if s, ok := value.(string); ok && (key == "error" || key == "message") {
+					v[key] = lw.catalog.Translate(lw.lang, s)
+				} else {
+					walk(value)
+				}
+			}
+		case []any:
+			for _, value := range v {
+				walk(value)
+			}
+		}
+	}
+	walk(v)
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, false
+	}
+	return out.Bytes(), true
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (lw *localizeWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
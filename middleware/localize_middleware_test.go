@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"static-site-hosting/i18n"
+)
+
+func TestLocalizeMiddleware(t *testing.T) {
+	catalog := i18n.New()
+	request := func(h http.Handler, path, acceptLanguage string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		rr := httptest.NewRecorder()
+		LocalizeMiddleware(catalog, func(r *http.Request) string {
+			if strings.HasPrefix(r.URL.Path, "/s/") {
+				return "static"
+			}
+			return "api"
+		}, h).ServeHTTP(rr, req)
+		return rr
+	}
+	notFound := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Deployment not found", http.StatusNotFound)
+	})
+
+	rr := request(notFound, "/deployments/x", "de-DE, en;q=0.5")
+	if rr.Code != http.StatusNotFound || rr.Body.String() != "Deployment nicht gefunden\n" {
+		t.Errorf("expected a German 404, got %d %q", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Content-Language") != "de" || rr.Header().Get("Vary") != "Accept-Language" {
+		t.Errorf("expected Content-Language and Vary headers, got %v", rr.Header())
+	}
+
+	if rr := request(notFound, "/deployments/x", "en"); rr.Body.String() != "Deployment not found\n" {
+		t.Errorf("expected English to pass through, got %q", rr.Body.String())
+	}
+	if rr := request(notFound, "/s/site/missing.html", "de"); rr.Body.String() != "Deployment not found\n" {
+		t.Errorf("expected static responses to pass through, got %q", rr.Body.String())
+	}
+
+	ok := jsonHandler(`{"message":"Site not found"}`)
+	if rr := request(ok, "/deployments", "fr"); rr.Body.String() != `{"message":"Site not found"}` {
+		t.Errorf("expected successful responses to pass through, got %q", rr.Body.String())
+	}
+
+	// JSON errors keep their codes and fields
+	invalid := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error": "Invalid site name; redirect 0: to required",
+			"errors": []map[string]string{
+				{"field": "site", "code": "invalid", "message": "Invalid site name"},
+				{"field": "redirects[0].to", "code": "missing", "message": "redirect 0: to required"},
+			},
+		})
+	})
+	rr = request(invalid, "/upload", "es")
+	var body struct {
+		Error  string
+		Errors []struct{ Field, Code, Message string }
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("expected a JSON body: %v", err)
+	}
+	if body.Error != "Nombre de sitio no válido; redirección 0: se requiere to" {
+		t.Errorf("expected a Spanish error, got %q", body.Error)
+	}
+	if len(body.Errors) != 2 || body.Errors[0].Code != "invalid" || body.Errors[1].Field != "redirects[0].to" ||
+		body.Errors[1].Message != "redirección 0: se requiere to" {
+		t.Errorf("expected translated messages with codes and fields kept, got %+v", body.Errors)
+	}
+}
@@ -1,13 +1,38 @@
 package middleware
 
 import (
+	"io"
 	"log"
 	"net/http"
+
+	"static-site-hosting/tracing"
 )
 
+// accessLog receives request lines; nil falls back to the standard logger
+var accessLog *log.Logger
+
+// SetAccessLogOutput sends access logs to w instead of the application log
+func SetAccessLogOutput(w io.Writer) {
+	if w == nil {
+		accessLog = nil
+		return
+	}
+	accessLog = log.New(w, "", log.LstdFlags)
+}
+
+// LoggingMiddleware logs each request, with the trace and span IDs when
+// TracingMiddleware runs before it
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s", r.Method, r.URL.Path)
+		line := r.Method + " " + r.URL.Path
+		if span := tracing.FromContext(r.Context()); span != nil {
+			line += " trace_id=" + span.Context.TraceIDString() + " span_id=" + span.Context.SpanIDString()
+		}
+		if accessLog != nil {
+			accessLog.Print(line)
+		} else {
+			log.Print(line)
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -7,6 +7,8 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"static-site-hosting/tracing"
 )
 
 func TestLoggingMiddleware(t *testing.T) {
@@ -36,3 +38,31 @@ func TestLoggingMiddleware(t *testing.T) {
 		t.Errorf("Expected log to contain 'GET /test-path', got %q", logged)
 	}
 }
+
+func TestTracingMiddlewareJoinsCallerTrace(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(nil)
+
+	var handlerTraceID string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerTraceID = tracing.FromContext(r.Context()).Context.TraceIDString()
+	})
+	handler := TracingMiddleware(LoggingMiddleware(next))
+
+	req := httptest.NewRequest("GET", "/upload", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if handlerTraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected handlers to see the caller's trace, got %q", handlerTraceID)
+	}
+	if !strings.Contains(buf.String(), "trace_id=4bf92f3577b34da6a3ce929d0e0e4736") {
+		t.Errorf("expected the access log to carry the trace ID, got %q", buf.String())
+	}
+	resp, ok := tracing.ParseTraceparent(rr.Header().Get("Traceresponse"))
+	if !ok || resp.TraceIDString() != handlerTraceID || resp.SpanIDString() == "00f067aa0ba902b7" {
+		t.Errorf("expected a traceresponse naming the server span, got %q", rr.Header().Get("Traceresponse"))
+	}
+}
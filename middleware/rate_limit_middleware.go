@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"net/http"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/ratelimit"
+)
+
+// RateLimitMiddleware answers requests with 429 and Retry-After once the
+// client has spent its requests. limit returns the limiter and key of the
+// client making a request, or a nil limiter for requests not limited.
+func RateLimitMiddleware(limit func(*http.Request) (*ratelimit.Limiter, string), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter, key := limit(r)
+		if wait := limiter.Allow(key); wait > 0 {
+			w.Header().Set("Retry-After", auth.RetryAfter(wait))
+			http.Error(w, "Rate limit exceeded, try again later", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"static-site-hosting/ratelimit"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limiter := ratelimit.New(1, 2)
+	limit := func(r *http.Request) (*ratelimit.Limiter, string) {
+		if strings.HasPrefix(r.URL.Path, "/s/") {
+			return nil, ""
+		}
+		return limiter, r.Header.Get("X-Key")
+	}
+	handler := RateLimitMiddleware(limit, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Key", key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := request("/deployments", "a"); rr.Code != http.StatusOK {
+			t.Fatalf("request %d: expected the burst to pass, got %d", i+1, rr.Code)
+		}
+	}
+	rr := request("/deployments", "a")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected 429 with Retry-After: 60, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if rr := request("/deployments", "b"); rr.Code != http.StatusOK {
+		t.Errorf("expected another key to be limited separately, got %d", rr.Code)
+	}
+	if rr := request("/s/site/index.html", "a"); rr.Code != http.StatusOK {
+		t.Errorf("expected requests without a limiter to pass, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+
+	"static-site-hosting/auth"
+)
+
+// ScopeMiddleware refuses API tokens that revoked reports as revoked or
+// replaced, given their ID and TokenHash, and those whose scopes don't grant
+// the one required returns for the request ("" for public requests). Deploy
+// tokens are checked with the hash of the API token they were exchanged
+// for. Sessions pass through; their role is checked by AuthorizeMiddleware.
+func ScopeMiddleware(required func(*http.Request) string, revoked func(tokenID, tokenHash string) bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := auth.FromContext(r.Context())
+		if claims == nil || claims.TokenID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		hash := claims.ParentHash
+		if hash == "" {
+			hash = auth.TokenHash(auth.RequestToken(r))
+		}
+		if revoked(claims.TokenID, hash) {
+			http.Error(w, "Invalid or expired credentials", http.StatusUnauthorized)
+			return
+		}
+		if need := required(r); !claims.Permits(need) {
+			http.Error(w, "Forbidden: token lacks the "+need+" scope", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
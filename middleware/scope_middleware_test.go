@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"static-site-hosting/auth"
+)
+
+func TestScopeMiddleware(t *testing.T) {
+	required := func(r *http.Request) string {
+		switch {
+		case r.URL.Path == "/public":
+			return ""
+		case r.URL.Path == "/reset":
+			return auth.ScopeAdmin
+		case r.Method == http.MethodGet:
+			return auth.ScopeDeployRead
+		case r.Method == http.MethodDelete:
+			return auth.ScopeDeployDelete
+		}
+		return auth.ScopeDeployWrite
+	}
+	revoked := func(tokenID, tokenHash string) bool {
+		return tokenID == "revoked" || tokenHash == auth.TokenHash("rotated-secret")
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	ci := &auth.Claims{Subject: "user:ci", Role: auth.RoleAdmin, TokenID: "ci", Scopes: []string{auth.ScopeDeployWrite}}
+	tests := []struct {
+		name           string
+		claims         *auth.Claims
+		method, path   string
+		expectedStatus int
+	}{
+		{"token uploads", ci, http.MethodPost, "/upload", http.StatusOK},
+		{"token reads", ci, http.MethodGet, "/deployments", http.StatusOK},
+		{"token deletes", ci, http.MethodDelete, "/deployments/abc", http.StatusForbidden},
+		{"token resets", ci, http.MethodPost, "/reset", http.StatusForbidden},
+		{"token public", ci, http.MethodGet, "/public", http.StatusOK},
+		{"revoked token", &auth.Claims{Role: auth.RoleAdmin, TokenID: "revoked", Scopes: []string{auth.ScopeAdmin}}, http.MethodGet, "/public", http.StatusUnauthorized},
+		{"rotated deploy token", &auth.Claims{TokenID: "ci", Scopes: []string{auth.ScopeDeployUpload}, ParentHash: auth.TokenHash("rotated-secret")}, http.MethodPost, "/upload", http.StatusUnauthorized},
+		{"session", &auth.Claims{Subject: "user:admin", Role: auth.RoleAdmin}, http.MethodPost, "/reset", http.StatusOK},
+		{"anonymous", nil, http.MethodDelete, "/deployments/abc", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.claims != nil {
+				req = req.WithContext(auth.WithClaims(req.Context(), tt.claims))
+			}
+			rr := httptest.NewRecorder()
+			ScopeMiddleware(required, revoked, next).ServeHTTP(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestScopeMiddlewareChecksSecret(t *testing.T) {
+	revoked := func(tokenID, tokenHash string) bool { return tokenHash != auth.TokenHash("current-secret") }
+	handler := ScopeMiddleware(func(*http.Request) string { return "" }, revoked, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ci := &auth.Claims{TokenID: "ci", Scopes: []string{auth.ScopeDeployRead}}
+
+	for secret, expected := range map[string]int{"current-secret": http.StatusOK, "rotated-secret": http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req.WithContext(auth.WithClaims(req.Context(), ci)))
+		if rr.Code != expected {
+			t.Errorf("%s: expected status %d, got %d", secret, expected, rr.Code)
+		}
+	}
+}
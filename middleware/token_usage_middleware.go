@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+
+	"static-site-hosting/auth"
+)
+
+// TokenUsageMiddleware reports every request made with an API token to
+// record, with the bytes of its body that were read and whether it was
+// answered with an error, so stale and misbehaving tokens can be found.
+// Requests with sessions aren't recorded.
+func TokenUsageMiddleware(record func(tokenID string, uploaded int64, failed bool), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := auth.FromContext(r.Context())
+		if claims == nil || claims.TokenID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		record(claims.TokenID, body.n, sw.status >= http.StatusBadRequest)
+	})
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
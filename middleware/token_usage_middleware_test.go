@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"static-site-hosting/auth"
+)
+
+func TestTokenUsageMiddleware(t *testing.T) {
+	type call struct {
+		tokenID  string
+		uploaded int64
+		failed   bool
+	}
+	var calls []call
+	record := func(tokenID string, uploaded int64, failed bool) {
+		calls = append(calls, call{tokenID, uploaded, failed})
+	}
+	handler := TokenUsageMiddleware(record, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		if r.Method == http.MethodDelete {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		}
+	}))
+
+	serve := func(method, body string, claims *auth.Claims) {
+		req := httptest.NewRequest(method, "/upload", strings.NewReader(body))
+		if claims != nil {
+			req = req.WithContext(auth.WithClaims(req.Context(), claims))
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	token := &auth.Claims{Subject: "user:alice", TokenID: "t1"}
+	serve(http.MethodPost, "archive", token)
+	serve(http.MethodDelete, "", token)
+	serve(http.MethodPost, "archive", &auth.Claims{Subject: "user:alice"})
+	serve(http.MethodPost, "archive", nil)
+
+	expected := []call{{"t1", 7, false}, {"t1", 0, true}}
+	if len(calls) != len(expected) {
+		t.Fatalf("expected only token requests to be recorded, got %+v", calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Errorf("call %d: expected %+v, got %+v", i, expected[i], calls[i])
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+
+	"static-site-hosting/tracing"
+)
+
+// TracingMiddleware records a server span for every request. A valid W3C
+// traceparent header from the caller makes the span part of the caller's
+// trace, so a deploy started by CI or a gateway shows up in its trace;
+// otherwise a new trace begins. The span is carried in the request context
+// for the access log and handlers, and returned in a traceresponse header.
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent, _ := tracing.ParseTraceparent(r.Header.Get("Traceparent"))
+		ctx, span := tracing.Start(r.Context(), r.Method, parent)
+		span.Server = true
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("server.address", r.Host)
+		if ua := r.UserAgent(); ua != "" {
+			span.SetAttribute("user_agent.original", ua)
+		}
+		w.Header().Set("Traceresponse", span.Context.Traceparent())
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttribute("http.response.status_code", status)
+		span.Error = status >= 500
+		span.Finish()
+	})
+}
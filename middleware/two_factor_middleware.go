@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+
+	"static-site-hosting/auth"
+)
+
+// TwoFactorMiddleware asks callers for the code of their authenticator app,
+// in the X-TOTP-Code header, on the requests sensitive reports, such as
+// resetting the system. check reports whether subject has enrolled and, if
+// so, whether code is valid. Callers who haven't enrolled, anonymous ones
+// included, pass unless requireEnrollment is set. Wrong codes count towards
+// lockouts of the client IP and the caller, as failed logins do.
+func TwoFactorMiddleware(sensitive func(*http.Request) bool, check func(subject, code string) (enrolled, ok bool), throttle *auth.Throttle, requireEnrollment bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !sensitive(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		claims := auth.FromContext(r.Context())
+		if claims == nil {
+			// Anonymous callers can't enroll, so only pass when it's optional
+			if requireEnrollment {
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		keys := []string{throttle.IPKey(r), "totp:" + claims.Subject}
+		if wait := throttle.Wait(keys...); wait > 0 {
+			throttle.Audit(r, auth.AuditEntry{Event: auth.AuditThrottled, Provider: "totp", Subject: claims.Subject})
+			w.Header().Set("Retry-After", auth.RetryAfter(wait))
+			http.Error(w, "Too many failed two-factor attempts", http.StatusTooManyRequests)
+			return
+		}
+
+		code := r.Header.Get(auth.TOTPHeader)
+		enrolled, ok := check(claims.Subject, code)
+		switch {
+		case ok:
+			throttle.Reset(keys[1])
+			next.ServeHTTP(w, r)
+		case !enrolled && !requireEnrollment:
+			next.ServeHTTP(w, r)
+		case !enrolled:
+			http.Error(w, "Forbidden: enroll in two-factor authentication at /auth/2fa/setup first", http.StatusForbidden)
+		case code == "":
+			http.Error(w, "Two-factor code required in the "+auth.TOTPHeader+" header", http.StatusUnauthorized)
+		default:
+			detail := "invalid code"
+			if lockout := throttle.Fail(keys...); lockout > 0 {
+				detail += "; locked out for " + lockout.String()
+			}
+			throttle.Audit(r, auth.AuditEntry{Event: auth.AuditTOTPFailed, Provider: "totp", Subject: claims.Subject, Detail: detail})
+			http.Error(w, "Invalid two-factor code", http.StatusUnauthorized)
+		}
+	})
+}
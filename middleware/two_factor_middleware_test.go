@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"static-site-hosting/auth"
+)
+
+func TestTwoFactorMiddleware(t *testing.T) {
+	// Wrong codes are audited to the log
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	sensitive := func(r *http.Request) bool { return r.URL.Path == "/reset" }
+	check := func(subject, code string) (bool, bool) {
+		if subject != "user:enrolled" {
+			return false, false
+		}
+		return true, code == "123456"
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	enrolled := &auth.Claims{Subject: "user:enrolled", Role: auth.RoleAdmin}
+	other := &auth.Claims{Subject: "user:other", Role: auth.RoleAdmin}
+
+	tests := []struct {
+		name           string
+		claims         *auth.Claims
+		path, code     string
+		require        bool
+		expectedStatus int
+	}{
+		{"other requests", enrolled, "/deployments", "", false, http.StatusOK},
+		{"valid code", enrolled, "/reset", "123456", false, http.StatusOK},
+		{"missing code", enrolled, "/reset", "", false, http.StatusUnauthorized},
+		{"wrong code", enrolled, "/reset", "000000", false, http.StatusUnauthorized},
+		{"not enrolled", other, "/reset", "", false, http.StatusOK},
+		{"enrollment required", other, "/reset", "", true, http.StatusForbidden},
+		{"anonymous", nil, "/reset", "", false, http.StatusOK},
+		{"anonymous with enrollment required", nil, "/reset", "", true, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.claims != nil {
+				req = req.WithContext(auth.WithClaims(req.Context(), tt.claims))
+			}
+			if tt.code != "" {
+				req.Header.Set(auth.TOTPHeader, tt.code)
+			}
+			rr := httptest.NewRecorder()
+			TwoFactorMiddleware(sensitive, check, nil, tt.require, next).ServeHTTP(rr, req)
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+
+	// Guessing codes locks the caller out
+	throttle := auth.NewThrottle(2, time.Minute, time.Hour)
+	handler := TwoFactorMiddleware(sensitive, check, throttle, false, next)
+	codes := []string{"000001", "000002", "000003", "123456"}
+	statuses := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}
+	for i, code := range codes {
+		req := httptest.NewRequest(http.MethodPost, "/reset", nil)
+		req = req.WithContext(auth.WithClaims(req.Context(), enrolled))
+		req.Header.Set(auth.TOTPHeader, code)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != statuses[i] {
+			t.Errorf("attempt %d: expected status %d, got %d", i+1, statuses[i], rr.Code)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Package migrate translates sites exported from other static hosts into a
+// deployment directory and the site settings that reproduce their
+// redirects and headers here.
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"static-site-hosting/models"
+)
+
+// Sources a site can be migrated from
+const (
+	SourceNetlify = "netlify"
+	SourceVercel  = "vercel"
+)
+
+// ErrUnknownExport is returned for directories that look like neither a
+// Netlify nor a Vercel export
+var ErrUnknownExport = errors.New("no Netlify or Vercel configuration found")
+
+// Result is an export translated for deployment
+type Result struct {
+	Source string `json:"source"`
+	// Root is the directory holding the files to serve; configuration files
+	// the source host doesn't serve have been removed from it
+	Root      string              `json:"-"`
+	Settings  models.SiteSettings `json:"-"`
+	Redirects int                 `json:"redirects"`
+	Headers   int                 `json:"headers"`
+	// Skipped lists rules with no equivalent here, and why
+	Skipped []string `json:"skipped"`
+}
+
+func (res *Result) skip(format string, args ...any) {
+	res.Skipped = append(res.Skipped, fmt.Sprintf(format, args...))
+}
+
+// redirect adds rule unless it isn't valid here, such as a rewrite to
+// somewhere other than a path within the site
+func (res *Result) redirect(desc string, rule models.RedirectRule) {
+	if err := (models.SiteSettings{Redirects: []models.RedirectRule{rule}}).Validate(); err != nil {
+		res.skip("%s: %s", desc, strings.TrimPrefix(err.Error(), "redirect 1: "))
+		return
+	}
+	res.Settings.Redirects = append(res.Settings.Redirects, rule)
+	res.Redirects++
+}
+
+func (res *Result) header(rule models.HeaderRule) {
+	res.Settings.Headers = append(res.Settings.Headers, rule)
+	res.Headers++
+}
+
+// Convert detects which host dir was exported from and translates it.
+// Archives of a single folder are looked into.
+func Convert(dir string) (*Result, error) {
+	for {
+		switch {
+		case exists(filepath.Join(dir, ".vercel", "output", "config.json")):
+			return VercelOutput(dir)
+		case exists(filepath.Join(dir, "vercel.json")):
+			return Vercel(dir)
+		case exists(filepath.Join(dir, "netlify.toml")), exists(filepath.Join(dir, "_redirects")), exists(filepath.Join(dir, "_headers")):
+			return Netlify(dir)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) != 1 || !entries[0].IsDir() {
+			return nil, ErrUnknownExport
+		}
+		dir = filepath.Join(dir, entries[0].Name())
+	}
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// within resolves rel below dir, refusing paths that leave it
+func within(dir, rel string) (string, error) {
+	joined := filepath.Join(dir, filepath.FromSlash(rel))
+	if joined != filepath.Clean(dir) && !strings.HasPrefix(joined, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%q is outside the export", rel)
+	}
+	return joined, nil
+}
+
+// pathPattern translates a route path with :name placeholders and a
+// trailing * (Netlify), or :name*, :name+, :name? and :name(regexp)
+// (Vercel), into an anchored regular expression. It returns the group
+// number of each placeholder; a splat is named "splat".
+func pathPattern(p string) (string, map[string]int, error) {
+	var parts []string
+	groups := map[string]int{}
+	n := 0
+	for i := 0; i < len(p); {
+		switch c := p[i]; {
+		case c == ':' && i+1 < len(p) && isNameChar(p[i+1]):
+			j := i + 1
+			for j < len(p) && isNameChar(p[j]) {
+				j++
+			}
+			name := p[i+1 : j]
+			expr := "[^/]+"
+			if j < len(p) && p[j] == '(' {
+				end := closingParen(p, j)
+				if end < 0 {
+					return "", nil, fmt.Errorf("unbalanced parenthesis in %q", p)
+				}
+				expr = p[j+1 : end]
+				j = end + 1
+			}
+			if j < len(p) {
+				switch p[j] {
+				case '*':
+					expr, j = ".*", j+1
+				case '+':
+					expr, j = ".+", j+1
+				case '?':
+					expr, j = "[^/]*", j+1
+				}
+			}
+			n++
+			groups[name] = n
+			parts = append(parts, "("+expr+")")
+			i = j
+		case c == '(':
+			end := closingParen(p, i)
+			if end < 0 {
+				return "", nil, fmt.Errorf("unbalanced parenthesis in %q", p)
+			}
+			n++
+			parts = append(parts, p[i:end+1])
+			i = end + 1
+		case c == '*':
+			n++
+			groups["splat"] = n
+			parts = append(parts, "(.*)")
+			i++
+		default:
+			parts = append(parts, regexp.QuoteMeta(p[i:i+1]))
+			i++
+		}
+	}
+
+	// /blog/* matches /blog too, and a trailing slash is optional elsewhere,
+	// as on both hosts
+	last := len(parts) - 1
+	switch {
+	case last > 0 && parts[last] == "(.*)" && parts[last-1] == "/":
+		parts = append(parts[:last-1], "(?:/(.*))?")
+	case !strings.HasSuffix(p, "/"):
+		parts = append(parts, "/?")
+	}
+	expr := "^" + strings.Join(parts, "") + "$"
+	if _, err := regexp.Compile(expr); err != nil {
+		return "", nil, err
+	}
+	return expr, groups, nil
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// closingParen returns the index of the parenthesis closing the one at i
+func closingParen(s string, i int) int {
+	depth := 0
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// placeholders matches :name references in a route target, with the
+// modifier Vercel allows to repeat from the source
+var placeholders = regexp.MustCompile(`:([A-Za-z_][A-Za-z0-9_]*)[*+?]?`)
+
+// expandTarget replaces the :name placeholders of target with references
+// to their groups. Placeholders that aren't groups are left alone, such as
+// the port in https://host:8080/.
+func expandTarget(target string, groups map[string]int) string {
+	target = strings.ReplaceAll(target, "$", "$$")
+	return placeholders.ReplaceAllStringFunc(target, func(m string) string {
+		if n, ok := groups[strings.TrimRight(m[1:], "*+?")]; ok {
+			return fmt.Sprintf("${%d}", n)
+		}
+		return m
+	})
+}
+
+// redirectStatus reports whether status is a redirect RedirectRule supports
+func redirectStatus(status int) bool {
+	switch status {
+	case 301, 302, 303, 307, 308:
+		return true
+	}
+	return false
+}
+
+// external reports whether target points at another host
+func external(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
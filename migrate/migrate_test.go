@@ -0,0 +1,243 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPathPattern(t *testing.T) {
+	tests := []struct {
+		route   string
+		target  string
+		path    string
+		matches bool
+		want    string
+	}{
+		{"/old", "/new", "/old", true, "/new"},
+		{"/old", "/new", "/old/", true, "/new"},
+		{"/old", "/new", "/older", false, ""},
+		{"/blog/*", "/news/:splat", "/blog/2024/post", true, "/news/2024/post"},
+		{"/blog/*", "/news/:splat", "/blog", true, "/news/"},
+		{"/users/:id/posts", "/u/:id", "/users/42/posts", true, "/u/42"},
+		{"/docs/:path*", "/v2/:path*", "/docs/a/b", true, "/v2/a/b"},
+		{"/post/:id(\\d+)", "/p/:id", "/post/12", true, "/p/12"},
+		{"/post/:id(\\d+)", "/p/:id", "/post/abc", false, ""},
+		{"/(.*).php", "/legacy", "/index.php", true, "/legacy"},
+		{"/a.b", "/c", "/axb", false, ""},
+		{"/go", "https://example.com:8443/:x", "/go", true, "https://example.com:8443/:x"},
+	}
+	for _, tt := range tests {
+		expr, groups, err := pathPattern(tt.route)
+		if err != nil {
+			t.Errorf("%s: %v", tt.route, err)
+			continue
+		}
+		re := regexp.MustCompile(expr)
+		match := re.FindStringSubmatchIndex(tt.path)
+		if (match != nil) != tt.matches {
+			t.Errorf("%s (%s) on %s: expected match %v", tt.route, expr, tt.path, tt.matches)
+			continue
+		}
+		if match == nil {
+			continue
+		}
+		if got := string(re.ExpandString(nil, expandTarget(tt.target, groups), tt.path, match)); got != tt.want {
+			t.Errorf("%s on %s: expected %s, got %s", tt.route, tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestNetlify(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"netlify.toml": `
+[build]
+  publish = "dist" # built site
+
+[[redirects]]
+  from = "/docs/*"
+  to = "/guide/:splat"
+  status = 302
+  force = true
+
+[[redirects]]
+  from = "/fr/*"
+  to = "/fr/index.html"
+  status = 200
+  conditions = {Language = ["fr"]}
+
+[[headers]]
+  for = "/assets/*"
+  [headers.values]
+    Cache-Control = "public, max-age=31536000"
+`,
+		"dist/index.html": "home",
+		"dist/_redirects": `# comment
+/home          /            301
+/api/*         https://api.example.com/:splat  200
+/*             /index.html  200
+/store id=:id  /products/:id  301
+`,
+		"dist/_headers": `/*
+  X-Frame-Options: DENY
+  Link: </a.css>; rel=preload
+  Link: </b.js>; rel=preload
+`,
+	})
+
+	res, err := Convert(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Source != SourceNetlify || res.Root != filepath.Join(dir, "dist") {
+		t.Errorf("expected the publish directory of a Netlify site, got %s %s", res.Source, res.Root)
+	}
+	for _, name := range []string{"dist/_redirects", "dist/_headers"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed from the served files", name)
+		}
+	}
+
+	redirects := res.Settings.Redirects
+	if len(redirects) != 3 {
+		t.Fatalf("expected 3 redirects, got %+v", redirects)
+	}
+	if r := redirects[0]; r.From != "^/home/?$" || r.To != "/" || r.Status != 301 || r.Force {
+		t.Errorf("unexpected first redirect %+v", r)
+	}
+	if r := redirects[1]; r.To != "/index.html" || r.Status != 200 {
+		t.Errorf("expected the SPA rewrite, got %+v", r)
+	}
+	if r := redirects[2]; r.To != "/guide/${1}" || r.Status != 302 || !r.Force {
+		t.Errorf("expected the netlify.toml redirect last, got %+v", r)
+	}
+	if len(res.Skipped) != 3 {
+		t.Errorf("expected the proxy, query and conditional rules to be skipped, got %q", res.Skipped)
+	}
+
+	headers := res.Settings.Headers
+	if len(headers) != 2 {
+		t.Fatalf("expected 2 header rules, got %+v", headers)
+	}
+	if got := headers[0].Set["Link"]; got != "</a.css>; rel=preload, </b.js>; rel=preload" {
+		t.Errorf("expected repeated headers to be combined, got %q", got)
+	}
+	if got := headers[1].Set["Cache-Control"]; got != "public, max-age=31536000" {
+		t.Errorf("expected the netlify.toml header, got %q", got)
+	}
+}
+
+func TestVercel(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"site/vercel.json": `{
+  "outputDirectory": "public",
+  "cleanUrls": true,
+  "redirects": [
+    {"source": "/old/:slug", "destination": "/new/:slug"},
+    {"source": "/temp", "destination": "/", "permanent": false},
+    {"source": "/beta", "destination": "/", "has": [{"type": "cookie", "key": "beta"}]}
+  ],
+  "rewrites": [
+    {"source": "/app/:path*", "destination": "/app/index.html"},
+    {"source": "/api/:path*", "destination": "https://api.example.com/:path*"}
+  ],
+  "headers": [
+    {"source": "/(.*)", "headers": [{"key": "X-Content-Type-Options", "value": "nosniff"}]}
+  ]
+}`,
+		"site/public/index.html": "home",
+	})
+
+	// A single top-level folder is looked into
+	res, err := Convert(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Source != SourceVercel || res.Root != filepath.Join(dir, "site", "public") {
+		t.Errorf("expected the output directory of a Vercel project, got %s %s", res.Source, res.Root)
+	}
+
+	redirects := res.Settings.Redirects
+	if len(redirects) != 3 {
+		t.Fatalf("expected 3 redirects, got %+v", redirects)
+	}
+	if r := redirects[0]; r.To != "/new/${1}" || r.Status != 308 || !r.Force {
+		t.Errorf("expected a forced permanent redirect, got %+v", r)
+	}
+	if r := redirects[1]; r.Status != 307 {
+		t.Errorf("expected a temporary redirect, got %+v", r)
+	}
+	if r := redirects[2]; r.Status != 200 || r.Force {
+		t.Errorf("expected a rewrite for missing files, got %+v", r)
+	}
+	if len(res.Settings.Headers) != 1 || res.Settings.Headers[0].Set["X-Content-Type-Options"] != "nosniff" {
+		t.Errorf("unexpected headers %+v", res.Settings.Headers)
+	}
+	if len(res.Skipped) != 3 {
+		t.Errorf("expected cleanUrls, the conditional redirect and the proxy to be skipped, got %q", res.Skipped)
+	}
+}
+
+func TestVercelOutput(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".vercel/output/config.json": `{
+  "version": 3,
+  "routes": [
+    {"src": "^/(.*)/$", "headers": {"Location": "/$1"}, "status": 308},
+    {"src": "^/(.*)$", "headers": {"X-Frame-Options": "DENY"}, "continue": true},
+    {"handle": "filesystem"},
+    {"src": "^/blog/(?<slug>[^/]+)$", "dest": "/blog/[slug].html?slug=$slug"},
+    {"handle": "error"},
+    {"src": "^/.*$", "dest": "/404.html", "status": 404}
+  ]
+}`,
+		".vercel/output/static/index.html": "home",
+	})
+
+	res, err := Convert(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Root != filepath.Join(dir, ".vercel", "output", "static") {
+		t.Errorf("expected the static output directory, got %s", res.Root)
+	}
+	redirects := res.Settings.Redirects
+	if len(redirects) != 2 {
+		t.Fatalf("expected 2 redirects, got %+v", redirects)
+	}
+	if r := redirects[0]; r.To != "/${1}" || r.Status != 308 || !r.Force {
+		t.Errorf("expected a forced redirect from before the filesystem phase, got %+v", r)
+	}
+	if r := redirects[1]; r.To != "/blog/[slug].html?slug=${slug}" || r.Status != 200 || r.Force {
+		t.Errorf("expected a rewrite from the filesystem phase, got %+v", r)
+	}
+	if len(res.Settings.Headers) != 1 {
+		t.Errorf("expected one header rule, got %+v", res.Settings.Headers)
+	}
+	if len(res.Skipped) != 1 {
+		t.Errorf("expected the error phase to be skipped, got %q", res.Skipped)
+	}
+}
+
+func TestConvertUnknownExport(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"index.html": "home"})
+	if _, err := Convert(dir); err != ErrUnknownExport {
+		t.Errorf("expected ErrUnknownExport, got %v", err)
+	}
+}
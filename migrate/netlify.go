@@ -0,0 +1,280 @@
+package migrate
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"static-site-hosting/models"
+)
+
+// netlifyStatus matches the status field of a _redirects line
+var netlifyStatus = regexp.MustCompile(`^\d{3}!?$`)
+
+// netlifyRedirect is one redirect rule in either of Netlify's formats
+type netlifyRedirect struct {
+	from, to string
+	status   int
+	force    bool
+	// unsupported names a part of the rule with no equivalent here
+	unsupported string
+}
+
+// Netlify translates a Netlify site: its publish directory with the
+// _redirects and _headers files in it, and any rules in netlify.toml
+func Netlify(dir string) (*Result, error) {
+	res := &Result{Source: SourceNetlify, Root: dir, Skipped: []string{}}
+
+	var toml netlifyConfig
+	if raw, err := os.ReadFile(filepath.Join(dir, "netlify.toml")); err == nil {
+		toml = parseNetlifyTOML(string(raw))
+		os.Remove(filepath.Join(dir, "netlify.toml"))
+	}
+	if toml.publish != "" {
+		root, err := within(dir, filepath.Join(toml.base, toml.publish))
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(root); err == nil && info.IsDir() {
+			res.Root = root
+		}
+	}
+
+	// Rules in _redirects take precedence over netlify.toml
+	redirects, err := readNetlifyRedirects(filepath.Join(res.Root, "_redirects"))
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range append(redirects, toml.redirects...) {
+		res.netlifyRedirect(r)
+	}
+
+	headers, err := readNetlifyHeaders(filepath.Join(res.Root, "_headers"))
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range append(headers, toml.headers...) {
+		pattern, _, err := pathPattern(h.path)
+		if err != nil {
+			res.skip("headers for %s: %v", h.path, err)
+			continue
+		}
+		res.header(models.HeaderRule{Path: pattern, Set: h.values})
+	}
+
+	// Netlify reads these files but never serves them
+	os.Remove(filepath.Join(res.Root, "_redirects"))
+	os.Remove(filepath.Join(res.Root, "_headers"))
+	return res, nil
+}
+
+func (res *Result) netlifyRedirect(r netlifyRedirect) {
+	desc := r.from + " " + r.to
+	switch {
+	case r.unsupported != "":
+		res.skip("redirect %s: %s is not supported", desc, r.unsupported)
+		return
+	case !strings.HasPrefix(r.from, "/"):
+		res.skip("redirect %s: rules for other domains are not supported", desc)
+		return
+	case r.status == 200 && external(r.to):
+		res.skip("redirect %s: proxying to other hosts is not supported", desc)
+		return
+	case r.status != 200 && !redirectStatus(r.status):
+		res.skip("redirect %s: status %d is not supported", desc, r.status)
+		return
+	}
+	pattern, groups, err := pathPattern(r.from)
+	if err != nil {
+		res.skip("redirect %s: %v", desc, err)
+		return
+	}
+	res.redirect("redirect "+desc, models.RedirectRule{From: pattern, To: expandTarget(r.to, groups), Status: r.status, Force: r.force})
+}
+
+// readNetlifyRedirects parses a _redirects file: one rule per line, as
+//
+//	from [param=value ...] to [status[!]] [condition=value ...]
+func readNetlifyRedirects(name string) ([]netlifyRedirect, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []netlifyRedirect
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		r := netlifyRedirect{from: fields[0], status: 301}
+		rest := fields[1:]
+		for len(rest) > 0 && strings.Contains(rest[0], "=") && !strings.HasPrefix(rest[0], "/") && !external(rest[0]) {
+			r.unsupported = "query parameter matching"
+			rest = rest[1:]
+		}
+		if len(rest) == 0 {
+			continue
+		}
+		r.to, rest = rest[0], rest[1:]
+		if len(rest) > 0 && netlifyStatus.MatchString(rest[0]) {
+			r.force = strings.HasSuffix(rest[0], "!")
+			r.status, _ = strconv.Atoi(strings.TrimSuffix(rest[0], "!"))
+			rest = rest[1:]
+		}
+		if len(rest) > 0 && r.unsupported == "" {
+			r.unsupported = "matching on " + strings.Join(rest, " ")
+		}
+		rules = append(rules, r)
+	}
+	return rules, scanner.Err()
+}
+
+// netlifyHeaders is the headers set for one path
+type netlifyHeaders struct {
+	path   string
+	values map[string]string
+}
+
+// readNetlifyHeaders parses a _headers file: a path on a line of its own,
+// followed by indented "Name: value" lines
+func readNetlifyHeaders(name string) ([]netlifyHeaders, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var blocks []netlifyHeaders
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			blocks = append(blocks, netlifyHeaders{path: trimmed, values: map[string]string{}})
+			continue
+		}
+		name, value, ok := strings.Cut(trimmed, ":")
+		if !ok || len(blocks) == 0 {
+			continue
+		}
+		block := blocks[len(blocks)-1]
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		// Repeated headers are combined, as Netlify does
+		if prev, ok := block.values[name]; ok {
+			value = prev + ", " + value
+		}
+		block.values[name] = value
+	}
+	return blocks, scanner.Err()
+}
+
+// netlifyConfig is what netlify.toml says about serving the site
+type netlifyConfig struct {
+	base, publish string
+	redirects     []netlifyRedirect
+	headers       []netlifyHeaders
+}
+
+// parseNetlifyTOML reads the parts of netlify.toml that affect serving:
+// [build] base and publish, [[redirects]] and [[headers]]. It understands
+// the subset of TOML these are written in; inline tables mark a redirect
+// as unsupported, since they only hold conditions.
+func parseNetlifyTOML(raw string) netlifyConfig {
+	var cfg netlifyConfig
+	table := ""
+	for _, line := range strings.Split(raw, "\n") {
+		line = strings.TrimSpace(stripTOMLComment(line))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			table = strings.TrimSpace(strings.Trim(line, "[]"))
+			switch {
+			case strings.HasPrefix(line, "[[") && table == "redirects":
+				cfg.redirects = append(cfg.redirects, netlifyRedirect{status: 301})
+			case strings.HasPrefix(line, "[[") && table == "headers":
+				cfg.headers = append(cfg.headers, netlifyHeaders{values: map[string]string{}})
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		value = strings.TrimSpace(value)
+
+		switch {
+		case table == "build" && key == "base":
+			cfg.base = tomlString(value)
+		case table == "build" && key == "publish":
+			cfg.publish = tomlString(value)
+		case table == "redirects" && len(cfg.redirects) > 0:
+			r := &cfg.redirects[len(cfg.redirects)-1]
+			switch key {
+			case "from":
+				r.from = tomlString(value)
+			case "to":
+				r.to = tomlString(value)
+			case "status":
+				r.status, _ = strconv.Atoi(value)
+			case "force":
+				r.force = value == "true"
+			case "query", "conditions", "signed":
+				r.unsupported = fmt.Sprintf("%q", key)
+			}
+		case (table == "redirects.query" || table == "redirects.conditions") && len(cfg.redirects) > 0:
+			cfg.redirects[len(cfg.redirects)-1].unsupported = fmt.Sprintf("%q", strings.TrimPrefix(table, "redirects."))
+		case table == "headers" && len(cfg.headers) > 0 && key == "for":
+			cfg.headers[len(cfg.headers)-1].path = tomlString(value)
+		case table == "headers.values" && len(cfg.headers) > 0:
+			cfg.headers[len(cfg.headers)-1].values[key] = tomlString(value)
+		}
+	}
+	return cfg
+}
+
+// stripTOMLComment drops a # comment outside quotes
+func stripTOMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// tomlString decodes a basic or literal TOML string
+func tomlString(value string) string {
+	if strings.HasPrefix(value, "'") {
+		return strings.Trim(value, "'")
+	}
+	if s, err := strconv.Unquote(value); err == nil {
+		return s
+	}
+	return value
+}
@@ -0,0 +1,267 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"static-site-hosting/models"
+)
+
+// vercelConfig is the part of vercel.json that affects serving
+type vercelConfig struct {
+	OutputDirectory string `json:"outputDirectory"`
+	CleanURLs       bool   `json:"cleanUrls"`
+	TrailingSlash   *bool  `json:"trailingSlash"`
+	Redirects       []struct {
+		Source      string          `json:"source"`
+		Destination string          `json:"destination"`
+		Permanent   *bool           `json:"permanent"`
+		StatusCode  int             `json:"statusCode"`
+		Has         json.RawMessage `json:"has"`
+		Missing     json.RawMessage `json:"missing"`
+	} `json:"redirects"`
+	Rewrites []struct {
+		Source      string          `json:"source"`
+		Destination string          `json:"destination"`
+		Has         json.RawMessage `json:"has"`
+		Missing     json.RawMessage `json:"missing"`
+	} `json:"rewrites"`
+	Headers []struct {
+		Source  string `json:"source"`
+		Headers []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"headers"`
+		Has     json.RawMessage `json:"has"`
+		Missing json.RawMessage `json:"missing"`
+	} `json:"headers"`
+	Routes json.RawMessage `json:"routes"`
+}
+
+// Vercel translates a Vercel project: its output directory and the
+// redirects, rewrites and headers in vercel.json. Redirects apply before
+// files are looked up, rewrites only to paths with no file, as on Vercel.
+func Vercel(dir string) (*Result, error) {
+	res := &Result{Source: SourceVercel, Root: dir, Skipped: []string{}}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "vercel.json"))
+	if err != nil {
+		return nil, err
+	}
+	var cfg vercelConfig
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("vercel.json: %w", err)
+	}
+	os.Remove(filepath.Join(dir, "vercel.json"))
+	if cfg.OutputDirectory != "" {
+		if res.Root, err = within(dir, cfg.OutputDirectory); err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(res.Root); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("output directory %q not found", cfg.OutputDirectory)
+		}
+	}
+
+	if cfg.CleanURLs {
+		res.skip("cleanUrls is not supported")
+	}
+	if cfg.TrailingSlash != nil {
+		res.skip("trailingSlash is not supported")
+	}
+	if len(cfg.Routes) > 0 {
+		res.skip("legacy routes are not supported; use redirects, rewrites and headers")
+	}
+
+	for _, r := range cfg.Redirects {
+		desc := r.Source + " " + r.Destination
+		if conditional(r.Has, r.Missing) {
+			res.skip("redirect %s: has and missing conditions are not supported", desc)
+			continue
+		}
+		pattern, groups, err := pathPattern(r.Source)
+		if err != nil {
+			res.skip("redirect %s: %v", desc, err)
+			continue
+		}
+		status := r.StatusCode
+		if status == 0 {
+			status = 308
+			if r.Permanent != nil && !*r.Permanent {
+				status = 307
+			}
+		}
+		if !redirectStatus(status) {
+			res.skip("redirect %s: status %d is not supported", desc, status)
+			continue
+		}
+		res.redirect("redirect "+desc, models.RedirectRule{From: pattern, To: expandTarget(r.Destination, groups), Status: status, Force: true})
+	}
+
+	for _, r := range cfg.Rewrites {
+		desc := r.Source + " " + r.Destination
+		switch {
+		case conditional(r.Has, r.Missing):
+			res.skip("rewrite %s: has and missing conditions are not supported", desc)
+			continue
+		case external(r.Destination):
+			res.skip("rewrite %s: proxying to other hosts is not supported", desc)
+			continue
+		}
+		pattern, groups, err := pathPattern(r.Source)
+		if err != nil {
+			res.skip("rewrite %s: %v", desc, err)
+			continue
+		}
+		res.redirect("rewrite "+desc, models.RedirectRule{From: pattern, To: expandTarget(r.Destination, groups), Status: 200})
+	}
+
+	for _, h := range cfg.Headers {
+		if conditional(h.Has, h.Missing) {
+			res.skip("headers for %s: has and missing conditions are not supported", h.Source)
+			continue
+		}
+		pattern, _, err := pathPattern(h.Source)
+		if err != nil {
+			res.skip("headers for %s: %v", h.Source, err)
+			continue
+		}
+		values := map[string]string{}
+		for _, kv := range h.Headers {
+			values[kv.Key] = kv.Value
+		}
+		if len(values) > 0 {
+			res.header(models.HeaderRule{Path: pattern, Set: values})
+		}
+	}
+	return res, nil
+}
+
+// conditional reports whether a rule has has or missing conditions
+func conditional(has, missing json.RawMessage) bool {
+	empty := func(m json.RawMessage) bool {
+		s := strings.TrimSpace(string(m))
+		return s == "" || s == "null" || s == "[]"
+	}
+	return !empty(has) || !empty(missing)
+}
+
+// vercelRoute is one route of the Build Output API's config.json
+type vercelRoute struct {
+	Src      string            `json:"src"`
+	Dest     string            `json:"dest"`
+	Headers  map[string]string `json:"headers"`
+	Status   int               `json:"status"`
+	Continue bool              `json:"continue"`
+	Handle   string            `json:"handle"`
+	Methods  []string          `json:"methods"`
+	Has      json.RawMessage   `json:"has"`
+	Missing  json.RawMessage   `json:"missing"`
+	// Set on routes handled by functions, which can't be migrated
+	MiddlewarePath string `json:"middlewarePath"`
+}
+
+// vercelOutputConfig is .vercel/output/config.json
+type vercelOutputConfig struct {
+	Version int           `json:"version"`
+	Routes  []vercelRoute `json:"routes"`
+}
+
+// captureRefs matches $1 and $name references in a route's dest
+var captureRefs = regexp.MustCompile(`\$([0-9]+|[A-Za-z_][A-Za-z0-9_]*)`)
+
+// VercelOutput translates a Vercel Build Output API directory, as written
+// by `vercel build`: the files of .vercel/output/static and the routes of
+// its config.json. Routes before the filesystem phase apply to every
+// request, those in it only to paths with no file; later phases are left
+// out.
+func VercelOutput(dir string) (*Result, error) {
+	output := filepath.Join(dir, ".vercel", "output")
+	res := &Result{Source: SourceVercel, Root: filepath.Join(output, "static"), Skipped: []string{}}
+
+	raw, err := os.ReadFile(filepath.Join(output, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	var cfg vercelOutputConfig
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("config.json: %w", err)
+	}
+	if cfg.Version != 3 {
+		return nil, fmt.Errorf("unsupported Build Output API version %d", cfg.Version)
+	}
+	if err := os.MkdirAll(res.Root, 0755); err != nil {
+		return nil, err
+	}
+	if exists(filepath.Join(output, "functions")) {
+		res.skip("functions are not supported")
+	}
+
+	phase := ""
+	skipped := map[string]int{}
+	for _, route := range cfg.Routes {
+		if route.Handle != "" {
+			phase = route.Handle
+			continue
+		}
+		if phase != "" && phase != "filesystem" {
+			skipped[phase]++
+			continue
+		}
+		res.vercelRoute(route, phase == "")
+	}
+	for _, phase := range []string{"miss", "rewrite", "hit", "error", "resource"} {
+		if n := skipped[phase]; n > 0 {
+			res.skip("%d routes in the %q phase are not supported", n, phase)
+		}
+	}
+	return res, nil
+}
+
+func (res *Result) vercelRoute(route vercelRoute, force bool) {
+	desc := "route " + route.Src
+	if route.MiddlewarePath != "" || len(route.Methods) > 0 || conditional(route.Has, route.Missing) {
+		res.skip("%s: conditional routes are not supported", desc)
+		return
+	}
+	pattern := route.Src
+	if !strings.HasPrefix(pattern, "^") {
+		pattern = "^(?:" + pattern + ")$"
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		res.skip("%s: %v", desc, err)
+		return
+	}
+	dest := captureRefs.ReplaceAllString(route.Dest, "$${$1}")
+
+	headers := map[string]string{}
+	location := ""
+	for name, value := range route.Headers {
+		if strings.EqualFold(name, "Location") {
+			location = captureRefs.ReplaceAllString(value, "$${$1}")
+			continue
+		}
+		headers[name] = value
+	}
+	if len(headers) > 0 {
+		res.header(models.HeaderRule{Path: pattern, Set: headers})
+	}
+
+	switch {
+	case redirectStatus(route.Status) && (location != "" || dest != ""):
+		if location == "" {
+			location = dest
+		}
+		res.redirect(desc, models.RedirectRule{From: pattern, To: location, Status: route.Status, Force: force})
+	case route.Status != 0 && route.Status != 200:
+		res.skip("%s: status %d is not supported", desc, route.Status)
+	case dest == "":
+	case external(dest):
+		res.skip("%s: proxying to other hosts is not supported", desc)
+	default:
+		res.redirect(desc, models.RedirectRule{From: pattern, To: dest, Status: 200, Force: force})
+	}
+}
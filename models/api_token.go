@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// APIToken is a long-lived token for scripts and CI, limited to its scopes.
+// The token itself is only returned when it is created or rotated; only
+// its hash is stored.
+type APIToken struct {
+	ID        string     `json:"id" db:"id"`
+	OwnerID   string     `json:"owner_id" db:"owner_id"`
+	Name      string     `json:"name" db:"name"`
+	Scopes    []string   `json:"scopes" db:"scopes"` // stored comma-separated
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty" db:"rotated_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	Token     string     `json:"token,omitempty" db:"-"`
+}
+
+// TableName returns the database table name for this model
+func (t *APIToken) TableName() string {
+	return "api_tokens"
+}
@@ -55,3 +55,16 @@ func TestDeploymentJSONSerialization(t *testing.T) {
 		t.Error("Path should not be empty")
 	}
 }
+
+func TestValidSiteSlug(t *testing.T) {
+	for _, slug := range []string{"docs", "my-site", "a1"} {
+		if !ValidSiteSlug(slug) {
+			t.Errorf("expected %q to be valid", slug)
+		}
+	}
+	for _, slug := range []string{"", "-docs", "docs-", "My-Site", "a/b", "a.b", "upload", "deployments", "s"} {
+		if ValidSiteSlug(slug) {
+			t.Errorf("expected %q to be invalid", slug)
+		}
+	}
+}
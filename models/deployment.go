@@ -8,18 +8,130 @@ type Deployment struct {
 	Filename  string    `json:"filename" db:"filename"`
 	Timestamp time.Time `json:"timestamp" db:"timestamp"`
 	Path      string    `json:"path" db:"path"`
+
+	// Site groups deployments of the same website; empty for one-off uploads
+	Site string `json:"site,omitempty" db:"site"`
+	// ArchiveSHA256 is the hash of the uploaded archive, used to detect re-uploads
+	ArchiveSHA256 string `json:"archive_sha256,omitempty" db:"archive_sha256"`
+	// Status is StatusReady once a deployment may be served
+	Status string `json:"status,omitempty" db:"status"`
+	// Owner is the subject of the session that created the deployment,
+	// such as "user:<id>" for local accounts; empty for anonymous uploads
+	OwnerID string `json:"owner_id,omitempty" db:"owner_id"`
+	// DeployedBy attributes deployments made by a service account, such as
+	// "ci-prod"; empty for people, whom OwnerID already names
+	DeployedBy string `json:"deployed_by,omitempty" db:"deployed_by"`
+	// Visibility is VisibilityPrivate for deployments served only to callers
+	// who may see them
+	Visibility string `json:"visibility,omitempty" db:"visibility"`
+
+	// URLs are computed per response and never stored
+	URLs *DeploymentURLs `json:"urls,omitempty" db:"-"`
+	// Upload is only set in the response to the upload that created it
+	Upload *UploadReport `json:"upload,omitempty" db:"-"`
+}
+
+// DeploymentURLs lists the public addresses a deployment can be reached at.
+// SiteSubdomain and CustomDomains serve whichever deployment of the site is
+// currently live, not necessarily this one.
+type DeploymentURLs struct {
+	Path          string   `json:"path"`
+	Root          string   `json:"root,omitempty"`
+	StaticHost    string   `json:"static_host,omitempty"`
+	Subdomain     string   `json:"subdomain,omitempty"`
+	SiteSubdomain string   `json:"site_subdomain,omitempty"`
+	CustomDomains []string `json:"custom_domains,omitempty"`
+}
+
+// UploadReport tells an uploader what became of their archive, so files
+// left out of the deployment don't go unnoticed
+type UploadReport struct {
+	FilesExtracted int            `json:"files_extracted"`
+	TotalBytes     int64          `json:"total_bytes"`
+	Skipped        []SkippedEntry `json:"skipped,omitempty"`
+	SkippedCount   int            `json:"skipped_count"` // Skipped may be cut short
+	Scanned        bool           `json:"scanned"`       // checked for malware before going live
+	Warnings       []string       `json:"warnings,omitempty"`
+}
+
+// SkippedEntry is an archive entry that was not extracted
+type SkippedEntry struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Reasons archive entries are skipped
+const (
+	SkipUnsafePath  = "unsafe_path"      // would be written outside the deployment
+	SkipUnsupported = "unsupported_type" // links and special files
+	SkipSecret      = "secret_file"      // .env, .git/, keys and the like
+)
+
+// Deployment statuses
+const (
+	StatusReady  = "ready"  // live and servable
+	StatusFailed = "failed" // kept for inspection but never served
+)
+
+// Deployment visibilities
+const (
+	VisibilityPublic  = "public"  // served to anyone
+	VisibilityPrivate = "private" // served to its owners, admins and share links
+)
+
+// ValidVisibility reports whether v is a deployment visibility
+func ValidVisibility(v string) bool {
+	return v == VisibilityPublic || v == VisibilityPrivate
 }
 
 // NewDeployment creates a new deployment instance
 func NewDeployment(id, filename, path string) *Deployment {
 	return &Deployment{
-		ID:        id,
-		Filename:  filename,
-		Timestamp: time.Now(),
-		Path:      path,
+		ID:         id,
+		Filename:   filename,
+		Timestamp:  time.Now(),
+		Path:       path,
+		Status:     StatusReady,
+		Visibility: VisibilityPublic,
 	}
 }
 
+// reservedSiteSlugs are first path segments used by the API, or likely to
+// be in future, which a site name would otherwise collide with
+var reservedSiteSlugs = map[string]bool{
+	"s": true, "api": true, "admin": true, "auth": true, "login": true, "logout": true,
+	"upload": true, "uploads": true, "deployments": true, "sites": true, "rollback": true,
+	"reset": true, "dav": true, "webhooks": true, "metrics": true,
+	"health": true, "healthz": true, "readyz": true, "status": true, "static": true,
+	"assets": true, "www": true,
+}
+
+// ReservedSiteSlug reports whether s is kept back for API routes
+func ReservedSiteSlug(s string) bool {
+	return reservedSiteSlugs[s]
+}
+
+// ValidSiteSlug reports whether s can name a site: 1-63 lowercase letters,
+// digits and hyphens, not starting or ending with a hyphen, and not reserved
+func ValidSiteSlug(s string) bool {
+	if len(s) == 0 || len(s) > 63 || ReservedSiteSlug(s) || s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidDeploymentID reports whether s can be used as a client-supplied
+// deployment ID. IDs appear as URL path segments and DNS labels, so they
+// follow the same rules as site names; generated UUIDs always pass.
+func ValidDeploymentID(s string) bool {
+	return ValidSiteSlug(s)
+}
+
 // TableName returns the database table name for this model
 func (d *Deployment) TableName() string {
 	return "deployments"
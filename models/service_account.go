@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// ServiceAccount is a non-human identity, such as a CI pipeline, that
+// deploys with keys an admin issued for it. Keys don't expire but stop
+// working when revoked, together with the account or one at a time.
+type ServiceAccount struct {
+	ID   string `json:"id" db:"id"`
+	Name string `json:"name" db:"name"`
+	// Attribution is recorded as deployed_by on the deployments the
+	// account makes, such as "ci-prod"; it defaults to Name
+	Attribution string     `json:"attribution" db:"attribution"`
+	Role        string     `json:"role" db:"role"`
+	Tenant      string     `json:"tenant,omitempty" db:"tenant"`
+	CreatedBy   string     `json:"created_by" db:"created_by"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+
+	// Keys are listed when a single account is fetched
+	Keys []APIToken `json:"keys,omitempty" db:"-"`
+}
+
+// TableName returns the database table name for this model
+func (a *ServiceAccount) TableName() string {
+	return "service_accounts"
+}
+
+// Subject is the identity deployments and keys record for the account
+func (a *ServiceAccount) Subject() string {
+	return "service:" + a.ID
+}
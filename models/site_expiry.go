@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// SiteExpiry is the date a temporary site, such as an event microsite,
+// stops being served. Its deployments are deleted at DeletesAt, a grace
+// period later, unless the expiry is lifted first.
+type SiteExpiry struct {
+	Site      string    `json:"site" db:"site"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	DeletesAt time.Time `json:"deletes_at"`
+	Expired   bool      `json:"expired"`
+}
+
+// TableName returns the database table name for this model
+func (e *SiteExpiry) TableName() string {
+	return "site_expiry"
+}
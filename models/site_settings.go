@@ -0,0 +1,201 @@
+package models
+
+import (
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+)
+
+// SiteSettings holds per-site serving options, stored as JSON keyed by site ID
+type SiteSettings struct {
+	// CaseInsensitivePaths resolves /logo.png to Logo.PNG when no exact match exists
+	CaseInsensitivePaths bool `json:"case_insensitive_paths"`
+
+	// PreloadHeaders adds Link preload headers for the critical CSS/JS found
+	// in each page's <head> at deploy time; EarlyHints also sends them as 103
+	PreloadHeaders bool `json:"preload_headers"`
+	EarlyHints     bool `json:"early_hints"`
+
+	// SPAFallback serves index.html for extensionless paths that don't
+	// exist, for client-side routing. Unset follows the spa_fallback flag.
+	SPAFallback *bool `json:"spa_fallback,omitempty"`
+
+	// AccessRules filter requests before files are served; the first rule
+	// matching a request decides whether it is blocked or allowed
+	AccessRules []AccessRule `json:"access_rules,omitempty"`
+
+	// Redirects send or rewrite requests to other paths; the first rule
+	// matching a path applies. Headers are added to responses for matching
+	// paths, later rules overriding earlier ones.
+	Redirects []RedirectRule `json:"redirects,omitempty"`
+	Headers   []HeaderRule   `json:"headers,omitempty"`
+
+	// ContentTypes override the type files are served as, or have them
+	// downloaded; the first rule matching a path applies
+	ContentTypes []ContentTypeRule `json:"content_types,omitempty"`
+
+	// Charset labels text files, such as "windows-1252" for a legacy site,
+	// in place of the charset guessed from their extension or content.
+	// Content type rules naming a charset of their own win.
+	Charset string `json:"charset,omitempty"`
+
+	// RobotsTag is sent as the X-Robots-Tag header of every file served,
+	// such as "noindex, nofollow"; RobotsTxt is served as /robots.txt in
+	// place of the deployment's own
+	RobotsTag string `json:"robots_tag,omitempty"`
+	RobotsTxt string `json:"robots_txt,omitempty"`
+
+	// PreviewNoIndex keeps the deployment out of search engines when it is
+	// reached by ID rather than through its site's host name. Unset follows
+	// PREVIEW_NOINDEX.
+	PreviewNoIndex *bool `json:"preview_noindex,omitempty"`
+
+	// CacheKeyQuery names the query parameters that make requests for the
+	// same file cached separately, such as "v" for a version parameter.
+	// Every other parameter, like utm_source, is left out of cache keys, as
+	// the whole query string is when none are named.
+	CacheKeyQuery []string `json:"cache_key_query,omitempty"`
+}
+
+// Access rule actions
+const (
+	AccessBlock   = "block"
+	AccessAllow   = "allow"
+	AccessPrivate = "private"
+)
+
+// AccessRule matches requests on every condition it sets. Path is a regular
+// expression matched against the path within the site, such as
+// "^/private/"; UserAgent a case-insensitive one matched against the
+// User-Agent header. Action defaults to block; allow exempts requests from
+// the rules after it, and private serves them only to callers who could see
+// the deployment were it private.
+type AccessRule struct {
+	Path      string   `json:"path,omitempty"`
+	UserAgent string   `json:"user_agent,omitempty"`
+	Methods   []string `json:"methods,omitempty"`
+	Action    string   `json:"action,omitempty"`
+}
+
+// RedirectRule redirects requests whose path within the site matches From,
+// a regular expression, to To, in which ${1} and so on stand for From's
+// groups. Status 200 rewrites instead: the target file is served at the
+// requested URL. Unless Force is set, a rule only applies to paths with no
+// file of their own.
+type RedirectRule struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status int    `json:"status,omitempty"` // defaults to 301
+	Force  bool   `json:"force,omitempty"`
+}
+
+// redirectStatuses are the statuses a redirect rule may answer with
+var redirectStatuses = map[int]bool{200: true, 301: true, 302: true, 303: true, 307: true, 308: true}
+
+// HeaderRule sets response headers on requests whose path within the site
+// matches Path, a regular expression
+type HeaderRule struct {
+	Path string            `json:"path"`
+	Set  map[string]string `json:"set"`
+}
+
+// ContentTypeRule changes how files whose path within the site matches
+// Path, a regular expression such as `\.apk$`, are served: as ContentType
+// rather than the type their extension suggests, with Params set on
+// whichever type that is, and with Attachment, as downloads browsers save
+// rather than display
+type ContentTypeRule struct {
+	Path        string            `json:"path"`
+	ContentType string            `json:"content_type,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+	Attachment  bool              `json:"attachment,omitempty"`
+}
+
+// charsetPattern matches charset names as IANA registers them
+var charsetPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:+-]*$`)
+
+// Validate checks that every access rule has a condition, valid patterns
+// and a known action, that redirect, header and content type rules are
+// complete, that the charset is a charset name and that cache key
+// parameters are parameter names. It returns ValidationErrors listing
+// every problem.
+func (s SiteSettings) Validate() error {
+	var errs ValidationErrors
+	for i, rule := range s.AccessRules {
+		field := fmt.Sprintf("access_rules[%d]", i)
+		if rule.Path == "" && rule.UserAgent == "" && len(rule.Methods) == 0 {
+			errs.Add(field, CodeMissing, "access rule %d: path, user_agent or methods required", i+1)
+		}
+		if _, err := regexp.Compile(rule.Path); err != nil {
+			errs.Add(field+".path", CodeInvalid, "access rule %d: %v", i+1, err)
+		}
+		if _, err := regexp.Compile(rule.UserAgent); err != nil {
+			errs.Add(field+".user_agent", CodeInvalid, "access rule %d: %v", i+1, err)
+		}
+		if rule.Action != "" && rule.Action != AccessBlock && rule.Action != AccessAllow && rule.Action != AccessPrivate {
+			errs.Add(field+".action", CodeInvalid, "access rule %d: action must be %q, %q or %q", i+1, AccessBlock, AccessAllow, AccessPrivate)
+		}
+	}
+	for i, rule := range s.Redirects {
+		field := fmt.Sprintf("redirects[%d]", i)
+		if rule.From == "" {
+			errs.Add(field+".from", CodeMissing, "redirect %d: from required", i+1)
+		} else if _, err := regexp.Compile(rule.From); err != nil {
+			errs.Add(field+".from", CodeInvalid, "redirect %d: %v", i+1, err)
+		}
+		if rule.To == "" {
+			errs.Add(field+".to", CodeMissing, "redirect %d: to required", i+1)
+		} else if rule.Status == 200 && !strings.HasPrefix(rule.To, "/") {
+			errs.Add(field+".to", CodeInvalid, "redirect %d: rewrites must target a path within the site", i+1)
+		}
+		if rule.Status != 0 && !redirectStatuses[rule.Status] {
+			errs.Add(field+".status", CodeUnsupported, "redirect %d: status must be 200, 301, 302, 303, 307 or 308", i+1)
+		}
+	}
+	for i, rule := range s.Headers {
+		field := fmt.Sprintf("headers[%d]", i)
+		if rule.Path == "" {
+			errs.Add(field+".path", CodeMissing, "header rule %d: path required", i+1)
+		} else if _, err := regexp.Compile(rule.Path); err != nil {
+			errs.Add(field+".path", CodeInvalid, "header rule %d: %v", i+1, err)
+		}
+		if len(rule.Set) == 0 {
+			errs.Add(field+".set", CodeMissing, "header rule %d: set required", i+1)
+		}
+	}
+	for i, rule := range s.ContentTypes {
+		field := fmt.Sprintf("content_types[%d]", i)
+		if rule.Path == "" {
+			errs.Add(field+".path", CodeMissing, "content type rule %d: path required", i+1)
+		} else if _, err := regexp.Compile(rule.Path); err != nil {
+			errs.Add(field+".path", CodeInvalid, "content type rule %d: %v", i+1, err)
+		}
+		if rule.ContentType == "" && len(rule.Params) == 0 && !rule.Attachment {
+			errs.Add(field, CodeMissing, "content type rule %d: content_type, params or attachment required", i+1)
+		} else if _, _, err := mime.ParseMediaType(rule.ContentType); rule.ContentType != "" && err != nil {
+			errs.Add(field+".content_type", CodeInvalid, "content type rule %d: %v", i+1, err)
+		}
+		// FormatMediaType refuses names and values a header can't carry
+		if len(rule.Params) > 0 && mime.FormatMediaType("text/plain", rule.Params) == "" {
+			errs.Add(field+".params", CodeInvalid, "content type rule %d: invalid parameter", i+1)
+		}
+	}
+	if s.Charset != "" && !charsetPattern.MatchString(s.Charset) {
+		errs.Add("charset", CodeInvalid, "charset %q is not a valid charset name", s.Charset)
+	}
+	for i, name := range s.CacheKeyQuery {
+		if name == "" || strings.ContainsAny(name, "&=#") {
+			errs.Add(fmt.Sprintf("cache_key_query[%d]", i), CodeInvalid, "cache key parameter %d: %q is not a query parameter name", i+1, name)
+		}
+	}
+	if strings.ContainsAny(s.RobotsTag, "\r\n") {
+		errs.Add("robots_tag", CodeInvalid, "robots_tag must be a single line")
+	}
+	return errs.Err()
+}
+
+// TableName returns the database table name for this model
+func (s *SiteSettings) TableName() string {
+	return "site_settings"
+}
@@ -0,0 +1,13 @@
+package models
+
+// SiteSummary describes a site as a whole: the deployment it serves, its
+// newest deployment (which differs when that one failed validation), and
+// how many deployments it has and how much storage they take.
+type SiteSummary struct {
+	Site             string      `json:"site"`
+	LiveDeployment   *Deployment `json:"live_deployment"`
+	LatestDeployment *Deployment `json:"latest_deployment"`
+	DeploymentCount  int         `json:"deployment_count"`
+	FailedCount      int         `json:"failed_count"`
+	TotalBytes       int64       `json:"total_bytes"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// User is a local account that logs in with a username and password
+type User struct {
+	ID           string    `json:"id" db:"id"`
+	Username     string    `json:"username" db:"username"`
+	Email        string    `json:"email,omitempty" db:"email"`
+	Name         string    `json:"name,omitempty" db:"name"`
+	Role         string    `json:"role" db:"role"`
+	Tenant       string    `json:"tenant,omitempty" db:"tenant"`
+	PasswordHash string    `json:"-" db:"password_hash"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// TableName returns the database table name for this model
+func (u *User) TableName() string {
+	return "users"
+}
+
+// Subject is the identity deployments and sessions record for the user
+func (u *User) Subject() string {
+	return "user:" + u.ID
+}
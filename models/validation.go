@@ -0,0 +1,59 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Validation error codes, for clients to tell problems apart without
+// parsing messages
+const (
+	CodeMissing     = "missing"     // a required field is absent
+	CodeInvalid     = "invalid"     // the value is malformed or out of range
+	CodeReserved    = "reserved"    // the value is taken by the API itself
+	CodeUnsupported = "unsupported" // the value is well-formed but not handled
+	CodeDisabled    = "disabled"    // the field needs a feature that is off
+)
+
+// FieldError is a problem with one field of a request. Field names the
+// JSON or form field, with rule indexes as in "access_rules[0].path".
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
+// ValidationErrors lists every problem found in a request
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, e := range v {
+		messages[i] = e.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add records a problem with field
+func (v *ValidationErrors) Add(field, code, format string, args ...any) {
+	*v = append(*v, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// Include records e unless it is nil
+func (v *ValidationErrors) Include(e *FieldError) {
+	if e != nil {
+		*v = append(*v, *e)
+	}
+}
+
+// Err returns v as an error, or nil when nothing was found
+func (v ValidationErrors) Err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+// Webhook is an outbound HTTP endpoint notified of deployment events
+type Webhook struct {
+	ID        string    `json:"id" db:"id"`
+	URL       string    `json:"url" db:"url"`
+	Secret    string    `json:"secret,omitempty" db:"secret"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// OwnerID is the subject that registered the webhook. Only its owner
+	// and admins may see and change it. It is empty for anonymous callers.
+	OwnerID string `json:"owner_id,omitempty" db:"owner_id"`
+
+	// Events and Sites limit the webhook to those events, and to events
+	// about those sites; empty means all. Both are stored comma-separated.
+	Events []string `json:"events,omitempty" db:"events"`
+	Sites  []string `json:"sites,omitempty" db:"sites"`
+}
+
+// TableName returns the database table name for this model
+func (wh *Webhook) TableName() string {
+	return "webhooks"
+}
+
+// Delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// WebhookDelivery is one event queued for one webhook, retried until it is
+// delivered or moved to the dead-letter state
+type WebhookDelivery struct {
+	ID            string     `json:"id" db:"id"`
+	WebhookID     string     `json:"webhook_id" db:"webhook_id"`
+	Event         string     `json:"event" db:"event"`
+	Payload       string     `json:"payload" db:"payload"`
+	Status        string     `json:"status" db:"status"`
+	Attempts      int        `json:"attempts" db:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" db:"next_attempt_at"`
+	LastError     string     `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty" db:"delivered_at"`
+}
+
+// TableName returns the database table name for this model
+func (d *WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// WebhookAttempt records a single HTTP attempt for a delivery
+type WebhookAttempt struct {
+	DeliveryID  string    `json:"delivery_id" db:"delivery_id"`
+	Attempt     int       `json:"attempt" db:"attempt"`
+	StatusCode  int       `json:"status_code,omitempty" db:"status_code"`
+	Error       string    `json:"error,omitempty" db:"error"`
+	DurationMS  int64     `json:"duration_ms" db:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at" db:"attempted_at"`
+}
+
+// TableName returns the database table name for this model
+func (a *WebhookAttempt) TableName() string {
+	return "webhook_attempts"
+}
+
+// WebhookSecret is a signing secret retired by a rotation. Deliveries are
+// signed with it as well as the current secret until it expires, so
+// consumers can switch over without rejecting events.
+type WebhookSecret struct {
+	ID        string    `json:"id" db:"id"`
+	WebhookID string    `json:"webhook_id" db:"webhook_id"`
+	Secret    string    `json:"-" db:"secret"`
+	RetiredAt time.Time `json:"retired_at" db:"retired_at"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// TableName returns the database table name for this model
+func (s *WebhookSecret) TableName() string {
+	return "webhook_secrets"
+}
@@ -0,0 +1,59 @@
+package notify
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mailer sends plain-text notification emails through an SMTP relay
+type Mailer struct {
+	Addr     string // host:port of the relay
+	Username string // optional; PLAIN auth is used when set
+	Password string
+	From     string
+}
+
+// Enabled reports whether an SMTP relay is configured. Safe on a nil Mailer.
+func (m *Mailer) Enabled() bool {
+	return m != nil && m.Addr != "" && m.From != ""
+}
+
+// Send delivers a message to every recipient in to
+func (m *Mailer) Send(to []string, subject, body string) error {
+	if !m.Enabled() {
+		return fmt.Errorf("mail is not configured")
+	}
+	if len(to) == 0 {
+		return nil
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host := m.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	return smtp.SendMail(m.Addr, auth, m.From, to, buildMessage(m.From, to, subject, body))
+}
+
+func buildMessage(from string, to []string, subject, body string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", sanitizeHeader(subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// sanitizeHeader stops header injection through user-controlled values
+func sanitizeHeader(v string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(v)
+}
@@ -0,0 +1,30 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMailerEnabled(t *testing.T) {
+	var nilMailer *Mailer
+	if nilMailer.Enabled() {
+		t.Error("expected nil mailer to be disabled")
+	}
+	if (&Mailer{Addr: "localhost:25"}).Enabled() {
+		t.Error("expected mailer without From to be disabled")
+	}
+	if !(&Mailer{Addr: "localhost:25", From: "noreply@example.com"}).Enabled() {
+		t.Error("expected configured mailer to be enabled")
+	}
+}
+
+func TestBuildMessageSanitizesSubject(t *testing.T) {
+	msg := string(buildMessage("a@example.com", []string{"b@example.com"}, "hi\r\nBcc: evil@example.com", "line1\nline2"))
+
+	if strings.Contains(msg, "\r\nBcc:") {
+		t.Errorf("expected header injection to be neutralised:\n%s", msg)
+	}
+	if !strings.Contains(msg, "\r\n\r\nline1\r\nline2") {
+		t.Errorf("expected CRLF body after headers:\n%s", msg)
+	}
+}
@@ -0,0 +1,209 @@
+// Package orgs keeps organizations: teams whose members share the sites
+// and deployments the organization owns. Members are identified by the
+// subject they log in as; owners may also manage the membership.
+package orgs
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Membership roles
+const (
+	RoleOwner  = "owner"
+	RoleMember = "member"
+)
+
+// subjectPrefix marks owner IDs that are organizations, not people
+const subjectPrefix = "org:"
+
+// ErrNameTaken is returned when creating an organization with a name in use
+var ErrNameTaken = errors.New("organization name is already taken")
+
+// ErrLastOwner is returned for changes that would leave an organization
+// without an owner
+var ErrLastOwner = errors.New("an organization needs at least one owner")
+
+// Organization is a team sharing the deployments it owns
+type Organization struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Role      string    `json:"role,omitempty"` // the caller's, when listed for them
+	Members   []Member  `json:"members,omitempty"`
+}
+
+// Member is a subject belonging to an organization
+type Member struct {
+	Subject string    `json:"subject"`
+	Role    string    `json:"role"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// Subject is the owner ID deployments owned by organization id record
+func Subject(id string) string {
+	return subjectPrefix + id
+}
+
+// FromSubject returns the organization an owner ID names, if it names one
+func FromSubject(ownerID string) (string, bool) {
+	return strings.CutPrefix(ownerID, subjectPrefix)
+}
+
+// Store records organizations and their members in the database. A nil
+// Store has no organizations.
+type Store struct {
+	db *sql.DB
+}
+
+// New returns a store keeping organizations in db
+func New(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Create records an organization named name, with owner as its first owner
+func (s *Store) Create(name, owner string) (Organization, error) {
+	org := Organization{ID: uuid.New().String(), Name: name, CreatedAt: time.Now().UTC(), Role: RoleOwner}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return org, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("INSERT INTO organizations (id, name, created_at) VALUES (?, ?, ?)", org.ID, org.Name, org.CreatedAt)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return org, ErrNameTaken
+	}
+	if err != nil {
+		return org, err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO organization_members (org_id, subject, role, added_at) VALUES (?, ?, ?, ?)",
+		org.ID, owner, RoleOwner, org.CreatedAt,
+	); err != nil {
+		return org, err
+	}
+	org.Members = []Member{{Subject: owner, Role: RoleOwner, AddedAt: org.CreatedAt}}
+	return org, tx.Commit()
+}
+
+// Get returns organization id with its members, or sql.ErrNoRows
+func (s *Store) Get(id string) (Organization, error) {
+	var org Organization
+	err := s.db.QueryRow("SELECT id, name, created_at FROM organizations WHERE id = ?", id).Scan(&org.ID, &org.Name, &org.CreatedAt)
+	if err != nil {
+		return org, err
+	}
+	rows, err := s.db.Query("SELECT subject, role, added_at FROM organization_members WHERE org_id = ? ORDER BY added_at, subject", id)
+	if err != nil {
+		return org, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.Subject, &m.Role, &m.AddedAt); err != nil {
+			return org, err
+		}
+		org.Members = append(org.Members, m)
+	}
+	return org, rows.Err()
+}
+
+// List returns the organizations subject belongs to, with its role in
+// each, or every organization if subject is empty
+func (s *Store) List(subject string) ([]Organization, error) {
+	query := "SELECT o.id, o.name, o.created_at, COALESCE(m.role, '') FROM organizations o LEFT JOIN organization_members m ON m.org_id = o.id AND m.subject = ?"
+	if subject != "" {
+		query += " WHERE m.subject IS NOT NULL"
+	}
+	rows, err := s.db.Query(query+" ORDER BY o.name", subject)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Organization{}
+	for rows.Next() {
+		var org Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.CreatedAt, &org.Role); err != nil {
+			return nil, err
+		}
+		list = append(list, org)
+	}
+	return list, rows.Err()
+}
+
+// Role returns subject's role in organization id, or "" if it isn't a
+// member. A failed lookup counts as not a member.
+func (s *Store) Role(id, subject string) string {
+	if s == nil || subject == "" {
+		return ""
+	}
+	var role string
+	err := s.db.QueryRow("SELECT role FROM organization_members WHERE org_id = ? AND subject = ?", id, subject).Scan(&role)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Warning: Failed to look up membership of %s: %v", id, err)
+	}
+	return role
+}
+
+// SetMember adds subject to organization id with role, or changes its role
+func (s *Store) SetMember(id, subject, role string) error {
+	if role != RoleOwner {
+		if err := s.keepOwner(id, subject); err != nil {
+			return err
+		}
+	}
+	_, err := s.db.Exec(
+		`INSERT INTO organization_members (org_id, subject, role, added_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(org_id, subject) DO UPDATE SET role = excluded.role`,
+		id, subject, role, time.Now().UTC(),
+	)
+	return err
+}
+
+// RemoveMember removes subject from organization id
+func (s *Store) RemoveMember(id, subject string) error {
+	if err := s.keepOwner(id, subject); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM organization_members WHERE org_id = ? AND subject = ?", id, subject)
+	return err
+}
+
+// keepOwner returns ErrLastOwner if subject is the only owner of
+// organization id
+func (s *Store) keepOwner(id, subject string) error {
+	var others int
+	err := s.db.QueryRow(
+		"SELECT COUNT(*) FROM organization_members WHERE org_id = ? AND role = ? AND subject != ?",
+		id, RoleOwner, subject,
+	).Scan(&others)
+	if err != nil {
+		return err
+	}
+	if others == 0 && s.Role(id, subject) == RoleOwner {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// Delete removes organization id and its memberships
+func (s *Store) Delete(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM organization_members WHERE org_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM organizations WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
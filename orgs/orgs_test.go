@@ -0,0 +1,67 @@
+package orgs
+
+import (
+	"testing"
+
+	"static-site-hosting/schema"
+)
+
+func TestStore(t *testing.T) {
+	db, err := schema.OpenMemory()
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	s := New(db)
+
+	org, err := s.Create("web-team", "user:alice")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := s.Create("web-team", "user:bob"); err != ErrNameTaken {
+		t.Errorf("expected ErrNameTaken, got %v", err)
+	}
+	if role := s.Role(org.ID, "user:alice"); role != RoleOwner {
+		t.Errorf("expected the creator to be an owner, got %q", role)
+	}
+
+	if err := s.SetMember(org.ID, "user:bob", RoleMember); err != nil {
+		t.Fatalf("SetMember failed: %v", err)
+	}
+	if err := s.SetMember(org.ID, "user:alice", RoleMember); err != ErrLastOwner {
+		t.Errorf("expected demoting the last owner to fail, got %v", err)
+	}
+	if err := s.RemoveMember(org.ID, "user:alice"); err != ErrLastOwner {
+		t.Errorf("expected removing the last owner to fail, got %v", err)
+	}
+	if err := s.SetMember(org.ID, "user:bob", RoleOwner); err != nil {
+		t.Fatalf("SetMember failed: %v", err)
+	}
+	if err := s.RemoveMember(org.ID, "user:alice"); err != nil {
+		t.Errorf("expected alice to leave once bob owns it too, got %v", err)
+	}
+
+	if list, _ := s.List("user:alice"); len(list) != 0 {
+		t.Errorf("expected alice to belong to nothing, got %+v", list)
+	}
+	if list, _ := s.List("user:bob"); len(list) != 1 || list[0].Role != RoleOwner {
+		t.Errorf("expected bob to own web-team, got %+v", list)
+	}
+	if got, _ := s.Get(org.ID); len(got.Members) != 1 {
+		t.Errorf("expected one member left, got %+v", got.Members)
+	}
+
+	if err := s.Delete(org.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if list, _ := s.List(""); len(list) != 0 {
+		t.Errorf("expected no organizations left, got %+v", list)
+	}
+	if id, ok := FromSubject(Subject(org.ID)); !ok || id != org.ID {
+		t.Errorf("expected the subject to name the organization, got %q", id)
+	}
+	var none *Store
+	if none.Role(org.ID, "user:bob") != "" {
+		t.Error("expected a nil store to have no members")
+	}
+}
@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Scanner checks content for malware, returning the signature name of
+// anything found or "" when the content is clean
+type Scanner interface {
+	Scan(r io.Reader) (string, error)
+}
+
+// Clamd scans content with a ClamAV daemon over its INSTREAM protocol
+type Clamd struct {
+	Addr    string // "host:port", or a unix socket path
+	Timeout time.Duration
+}
+
+// clamdChunk is the largest chunk sent per INSTREAM frame
+const clamdChunk = 64 * 1024
+
+func (c Clamd) Scan(r io.Reader) (string, error) {
+	network := "tcp"
+	if strings.HasPrefix(c.Addr, "/") {
+		network = "unix"
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	conn, err := net.DialTimeout(network, c.Addr, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	buf := make([]byte, clamdChunk)
+	size := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, werr := conn.Write(append(size, buf[:n]...)); werr != nil {
+				return "", werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	// Replies look like "stream: OK" or "stream: Eicar-Signature FOUND"
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	result := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+// Finding is malware found in one file
+type Finding struct {
+	Path      string `json:"path"`
+	Signature string `json:"signature"`
+}
+
+// ScanTree scans every file below root
+func ScanTree(s Scanner, root string) ([]Finding, error) {
+	var findings []Finding
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		signature, err := s.Scan(f)
+		if err != nil {
+			return err
+		}
+		if signature != "" {
+			rel, _ := filepath.Rel(root, p)
+			findings = append(findings, Finding{Path: filepath.ToSlash(rel), Signature: signature})
+		}
+		return nil
+	})
+	return findings, err
+}
@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Policy limits what an upload may contain
+type Policy struct {
+	// MaxBytes caps the upload request body; 0 means no limit
+	MaxBytes int64 `json:"max_bytes"`
+	// AllowedTypes lists the file extensions a deployment may contain, such
+	// as ".html". Empty allows everything; extensionless files always pass.
+	AllowedTypes []string `json:"allowed_types"`
+	// Scan sends every extracted file to the virus scanner
+	Scan bool `json:"scan"`
+}
+
+// Override replaces parts of the global policy for one tenant. Unset
+// fields inherit the global value.
+type Override struct {
+	MaxBytes     *int64    `json:"max_bytes,omitempty"`
+	AllowedTypes *[]string `json:"allowed_types,omitempty"`
+	Scan         *bool     `json:"scan,omitempty"`
+}
+
+// With returns p with the fields set in o replaced
+func (p Policy) With(o Override) Policy {
+	if o.MaxBytes != nil {
+		p.MaxBytes = *o.MaxBytes
+	}
+	if o.AllowedTypes != nil {
+		p.AllowedTypes = *o.AllowedTypes
+	}
+	if o.Scan != nil {
+		p.Scan = *o.Scan
+	}
+	return p
+}
+
+// LoadOverride returns the override stored for tenant, if any
+func LoadOverride(db *sql.DB, tenant string) (Override, bool, error) {
+	var o Override
+	var raw string
+	err := db.QueryRow("SELECT policy FROM upload_policies WHERE tenant_id = ?", tenant).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return o, false, nil
+	}
+	if err != nil {
+		return o, false, err
+	}
+	return o, true, json.Unmarshal([]byte(raw), &o)
+}
+
+// SaveOverride replaces the override stored for tenant
+func SaveOverride(db *sql.DB, tenant string, o Override) error {
+	raw, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		"INSERT OR REPLACE INTO upload_policies (tenant_id, policy, updated_at) VALUES (?, ?, ?)",
+		tenant, string(raw), time.Now().UTC(),
+	)
+	return err
+}
+
+// DeleteOverride returns tenant to the global policy
+func DeleteOverride(db *sql.DB, tenant string) error {
+	_, err := db.Exec("DELETE FROM upload_policies WHERE tenant_id = ?", tenant)
+	return err
+}
+
+// ForTenant returns the policy in force for tenant
+func ForTenant(db *sql.DB, tenant string, global Policy) (Policy, error) {
+	o, _, err := LoadOverride(db, tenant)
+	if err != nil {
+		return global, err
+	}
+	return global.With(o), nil
+}
+
+// DisallowedFiles lists the files below root whose extension is not in
+// allowed. An empty allowed list permits everything.
+func DisallowedFiles(root string, allowed []string) ([]string, error) {
+	if len(allowed) == 0 {
+		return nil, nil
+	}
+	ok := map[string]bool{}
+	for _, ext := range allowed {
+		ok["."+strings.TrimPrefix(strings.ToLower(ext), ".")] = true
+	}
+
+	var rejected []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(p))
+		if ext != "" && !ok[ext] {
+			rel, _ := filepath.Rel(root, p)
+			rejected = append(rejected, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return rejected, err
+}
@@ -0,0 +1,100 @@
+package policy
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWithOverride(t *testing.T) {
+	global := Policy{MaxBytes: 100, AllowedTypes: []string{".html"}, Scan: false}
+	max := int64(0)
+	scan := true
+	got := global.With(Override{MaxBytes: &max, Scan: &scan})
+	want := Policy{MaxBytes: 0, AllowedTypes: []string{".html"}, Scan: true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestDisallowedFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"index.html", "CNAME", "tools/setup.EXE", "css/site.css"} {
+		p := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, nil, 0644)
+	}
+
+	got, err := DisallowedFiles(root, []string{"html", ".CSS"})
+	if err != nil {
+		t.Fatalf("DisallowedFiles failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{"tools/setup.EXE"}) {
+		t.Errorf("unexpected disallowed files %v", got)
+	}
+	if got, _ := DisallowedFiles(root, nil); got != nil {
+		t.Errorf("expected no restriction without a list, got %v", got)
+	}
+}
+
+// fakeClamd answers INSTREAM requests, flagging streams containing "EICAR"
+func fakeClamd(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				cmd := make([]byte, len("zINSTREAM\x00"))
+				io.ReadFull(conn, cmd)
+				var content strings.Builder
+				size := make([]byte, 4)
+				for {
+					if _, err := io.ReadFull(conn, size); err != nil {
+						return
+					}
+					n := binary.BigEndian.Uint32(size)
+					if n == 0 {
+						break
+					}
+					chunk := make([]byte, n)
+					io.ReadFull(conn, chunk)
+					content.Write(chunk)
+				}
+				if strings.Contains(content.String(), "EICAR") {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+				} else {
+					conn.Write([]byte("stream: OK\x00"))
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestClamdScanTree(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "index.html"), []byte("<h1>fine</h1>"), 0644)
+	os.WriteFile(filepath.Join(root, "bad.js"), []byte("X5O!P%@AP EICAR test"), 0644)
+
+	findings, err := ScanTree(Clamd{Addr: fakeClamd(t)}, root)
+	if err != nil {
+		t.Fatalf("ScanTree failed: %v", err)
+	}
+	want := []Finding{{Path: "bad.js", Signature: "Eicar-Test-Signature"}}
+	if !reflect.DeepEqual(findings, want) {
+		t.Errorf("expected %v, got %v", want, findings)
+	}
+}
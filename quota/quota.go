@@ -0,0 +1,299 @@
+// Package quota warns tenants as they approach their storage and bandwidth
+// quotas, before anything they do is turned away: each API response carries
+// the warnings in force, and crossing a threshold is announced once by
+// webhook and email.
+package quota
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"static-site-hosting/notify"
+	"static-site-hosting/usage"
+	"static-site-hosting/webhooks"
+)
+
+// Resources a quota applies to
+const (
+	ResourceStorage   = "storage"
+	ResourceBandwidth = "bandwidth" // per calendar month
+)
+
+// Limits are the quotas every tenant has, in bytes; 0 means no quota
+type Limits struct {
+	StorageBytes   int64
+	BandwidthBytes int64
+}
+
+// Warning says a tenant has used at least Threshold percent of a quota
+type Warning struct {
+	TenantID  string `json:"tenant_id"`
+	Resource  string `json:"resource"`
+	UsedBytes int64  `json:"used_bytes"`
+	Limit     int64  `json:"limit_bytes"`
+	Percent   int    `json:"percent"`   // share of the quota used, rounded down
+	Threshold int    `json:"threshold"` // highest warning threshold crossed
+	// Period is the month bandwidth is counted for, such as "2024-06"
+	Period string `json:"period,omitempty"`
+}
+
+// Header formats w as an X-Quota-Warning header value
+func (w Warning) Header() string {
+	return fmt.Sprintf("%s; percent=%d; used=%d; limit=%d", w.Resource, w.Percent, w.UsedBytes, w.Limit)
+}
+
+// Check returns the warnings in force for tenant at now, given thresholds
+// in percent
+func Check(db *sql.DB, tenant string, limits Limits, thresholds []int, now time.Time) ([]Warning, error) {
+	if limits.StorageBytes <= 0 && limits.BandwidthBytes <= 0 {
+		return nil, nil
+	}
+	now = now.UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	storage, bandwidth, err := usage.Current(db, tenant, month)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []Warning
+	for _, q := range []struct {
+		resource    string
+		used, limit int64
+		period      string
+	}{
+		{ResourceStorage, storage, limits.StorageBytes, ""},
+		{ResourceBandwidth, bandwidth, limits.BandwidthBytes, month.Format("2006-01")},
+	} {
+		if q.limit <= 0 {
+			continue
+		}
+		percent := int(q.used * 100 / q.limit)
+		crossed := 0
+		for _, t := range thresholds {
+			if percent >= t && t > crossed {
+				crossed = t
+			}
+		}
+		if crossed > 0 {
+			warnings = append(warnings, Warning{
+				TenantID:  tenant,
+				Resource:  q.resource,
+				UsedBytes: q.used,
+				Limit:     q.limit,
+				Percent:   percent,
+				Threshold: crossed,
+				Period:    q.period,
+			})
+		}
+	}
+	return warnings, nil
+}
+
+// cacheTTL is how long a tenant's warnings are reused for API responses
+const cacheTTL = time.Minute
+
+// Watcher keeps track of every tenant's warnings, checking them on demand
+// for API responses and every Interval in the background, and sends out
+// the ones crossing a new threshold
+type Watcher struct {
+	DB         *sql.DB
+	Limits     Limits
+	Thresholds []int
+	Interval   time.Duration
+	Mailer     *notify.Mailer
+	Recipients []string // addresses that receive quota warnings
+
+	mu     sync.Mutex
+	cached map[string]cachedWarnings
+}
+
+type cachedWarnings struct {
+	warnings []Warning
+	at       time.Time
+}
+
+// NewWatcher returns a watcher warning at thresholds percent of limits
+func NewWatcher(db *sql.DB, limits Limits, thresholds []int, interval time.Duration, mailer *notify.Mailer, recipients []string) *Watcher {
+	return &Watcher{
+		DB:         db,
+		Limits:     limits,
+		Thresholds: thresholds,
+		Interval:   interval,
+		Mailer:     mailer,
+		Recipients: recipients,
+		cached:     map[string]cachedWarnings{},
+	}
+}
+
+// Enabled reports whether there are quotas to warn about. Safe on a nil
+// Watcher.
+func (w *Watcher) Enabled() bool {
+	return w != nil && (w.Limits.StorageBytes > 0 || w.Limits.BandwidthBytes > 0) && len(w.Thresholds) > 0
+}
+
+// Warnings returns the warnings in force for tenant, checked at most once
+// a minute
+func (w *Watcher) Warnings(tenant string) []Warning {
+	if !w.Enabled() {
+		return nil
+	}
+	w.mu.Lock()
+	c, ok := w.cached[tenant]
+	w.mu.Unlock()
+	if ok && time.Since(c.at) < cacheTTL {
+		return c.warnings
+	}
+	warnings, err := w.check(tenant)
+	if err != nil {
+		log.Printf("Warning: Failed to check quotas of tenant %s: %v", tenant, err)
+		return c.warnings
+	}
+	return warnings
+}
+
+// Run checks every tenant each Interval until ctx is cancelled
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := w.CheckAll(); err != nil {
+			log.Printf("Warning: Quota check failed: %v", err)
+		}
+	}
+}
+
+// CheckAll checks the quotas of every tenant with recorded usage
+func (w *Watcher) CheckAll() error {
+	if !w.Enabled() {
+		return nil
+	}
+	tenants, err := usage.Tenants(w.DB)
+	if err != nil {
+		return err
+	}
+	for _, tenant := range tenants {
+		if _, err := w.check(tenant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check refreshes the cached warnings of tenant and sends out the ones
+// crossing a threshold not yet announced
+func (w *Watcher) check(tenant string) ([]Warning, error) {
+	warnings, err := Check(w.DB, tenant, w.Limits, w.Thresholds, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	w.cached[tenant] = cachedWarnings{warnings, time.Now()}
+	w.mu.Unlock()
+
+	announced, err := w.announced(tenant)
+	if err != nil {
+		return warnings, err
+	}
+	current := map[string]bool{}
+	for _, warning := range warnings {
+		current[warning.Resource] = true
+		if a, ok := announced[warning.Resource]; ok && a.threshold >= warning.Threshold && a.period == warning.Period {
+			continue
+		}
+		if err := w.announce(warning); err != nil {
+			return warnings, err
+		}
+	}
+	// Dropping back below every threshold, by deleting deployments or at
+	// the turn of the month, rearms the warnings
+	for resource := range announced {
+		if !current[resource] {
+			w.DB.Exec("DELETE FROM quota_notices WHERE tenant_id = ? AND resource = ?", tenant, resource)
+		}
+	}
+	return warnings, nil
+}
+
+type notice struct {
+	threshold int
+	period    string
+}
+
+// announced returns the highest threshold announced per resource
+func (w *Watcher) announced(tenant string) (map[string]notice, error) {
+	rows, err := w.DB.Query("SELECT resource, threshold, period FROM quota_notices WHERE tenant_id = ?", tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	announced := map[string]notice{}
+	for rows.Next() {
+		var resource string
+		var n notice
+		if err := rows.Scan(&resource, &n.threshold, &n.period); err != nil {
+			return nil, err
+		}
+		announced[resource] = n
+	}
+	return announced, rows.Err()
+}
+
+// announce records warning as sent and sends it by webhook and email
+func (w *Watcher) announce(warning Warning) error {
+	_, err := w.DB.Exec(
+		"INSERT OR REPLACE INTO quota_notices (tenant_id, resource, threshold, period, notified_at) VALUES (?, ?, ?, ?, ?)",
+		warning.TenantID, warning.Resource, warning.Threshold, warning.Period, time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+	log.Printf("Quota warning: tenant %s has used %d%% of its %s quota", warning.TenantID, warning.Percent, warning.Resource)
+	webhooks.Notify(w.DB, webhooks.EventQuotaWarning, warning)
+	if w.Mailer.Enabled() && len(w.Recipients) > 0 {
+		if err := w.Mailer.Send(w.Recipients, warningSubject(warning), warningBody(warning)); err != nil {
+			log.Printf("Warning: Failed to send quota warning: %v", err)
+		}
+	}
+	return nil
+}
+
+func warningSubject(warning Warning) string {
+	return fmt.Sprintf("Quota warning: %s has used %d%% of its %s quota", warning.TenantID, warning.Percent, warning.Resource)
+}
+
+func warningBody(warning Warning) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Tenant %s has used %d of its %d bytes of %s", warning.TenantID, warning.UsedBytes, warning.Limit, warning.Resource)
+	if warning.Period != "" {
+		fmt.Fprintf(&b, " for %s", warning.Period)
+	}
+	fmt.Fprintf(&b, " (%d%%), crossing the %d%% warning threshold.\n\n", warning.Percent, warning.Threshold)
+	if warning.Resource == ResourceStorage {
+		b.WriteString("Delete deployments that are no longer needed to free up storage.\n")
+	} else {
+		b.WriteString("Bandwidth is counted per calendar month.\n")
+	}
+	return b.String()
+}
+
+// Headers returns the X-Quota-Warning values for warnings, in a stable
+// order
+func Headers(warnings []Warning) []string {
+	values := make([]string, 0, len(warnings))
+	for _, warning := range warnings {
+		values = append(values, warning.Header())
+	}
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,172 @@
+package quota
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	schema := []string{
+		`CREATE TABLE deployment_usage (
+			deployment_id TEXT PRIMARY KEY,
+			tenant_id TEXT NOT NULL,
+			bytes INTEGER NOT NULL DEFAULT 0,
+			build_ms INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL,
+			deleted_at DATETIME
+		)`,
+		`CREATE TABLE bandwidth_daily (
+			deployment_id TEXT NOT NULL,
+			day TEXT NOT NULL,
+			bytes INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (deployment_id, day)
+		)`,
+		`CREATE TABLE quota_notices (
+			tenant_id TEXT NOT NULL,
+			resource TEXT NOT NULL,
+			threshold INTEGER NOT NULL,
+			period TEXT NOT NULL DEFAULT '',
+			notified_at DATETIME NOT NULL,
+			PRIMARY KEY (tenant_id, resource)
+		)`,
+		`CREATE TABLE webhooks (
+			id TEXT PRIMARY KEY,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events TEXT NOT NULL DEFAULT '',
+			sites TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			webhook_id TEXT NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at DATETIME NOT NULL,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			delivered_at DATETIME
+		)`,
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+	}
+	db.Exec("INSERT INTO webhooks (id, url, secret) VALUES ('hook', 'http://example.com', 's')")
+	return db
+}
+
+func insertUsage(t *testing.T, db *sql.DB, id, tenant string, bytes int64) {
+	_, err := db.Exec(
+		"INSERT INTO deployment_usage (deployment_id, tenant_id, bytes, created_at) VALUES (?, ?, ?, ?)",
+		id, tenant, bytes, time.Now().UTC(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func queuedWarnings(t *testing.T, db *sql.DB) int {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM webhook_deliveries WHERE event = 'quota.warning'").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCheckThresholds(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	insertUsage(t, db, "a", "acme", 850)
+	db.Exec("INSERT INTO bandwidth_daily (deployment_id, day, bytes) VALUES ('a', '2024-06-10', 950), ('a', '2024-05-31', 5000)")
+	insertUsage(t, db, "b", "globex", 100)
+
+	limits := Limits{StorageBytes: 1000, BandwidthBytes: 1000}
+	warnings, err := Check(db, "acme", limits, []int{80, 90}, now)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(warnings) != 2 {
+		t.Fatalf("expected storage and bandwidth warnings, got %+v", warnings)
+	}
+	if w := warnings[0]; w.Resource != ResourceStorage || w.Percent != 85 || w.Threshold != 80 {
+		t.Errorf("expected storage at 85%% crossing 80%%, got %+v", w)
+	}
+	// Bandwidth from before the month doesn't count
+	if w := warnings[1]; w.Resource != ResourceBandwidth || w.Percent != 95 || w.Threshold != 90 || w.Period != "2024-06" {
+		t.Errorf("expected June bandwidth at 95%% crossing 90%%, got %+v", w)
+	}
+
+	if warnings, _ := Check(db, "globex", limits, []int{80, 90}, now); len(warnings) != 0 {
+		t.Errorf("expected no warnings below the thresholds, got %+v", warnings)
+	}
+	if warnings, _ := Check(db, "acme", Limits{}, []int{80, 90}, now); len(warnings) != 0 {
+		t.Errorf("expected no warnings without quotas, got %+v", warnings)
+	}
+}
+
+func TestWatcherAnnouncesEachThresholdOnce(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	w := NewWatcher(db, Limits{StorageBytes: 1000}, []int{80, 90}, time.Minute, nil, nil)
+	insertUsage(t, db, "a", "acme", 820)
+
+	if err := w.CheckAll(); err != nil {
+		t.Fatalf("CheckAll failed: %v", err)
+	}
+	w.CheckAll()
+	if n := queuedWarnings(t, db); n != 1 {
+		t.Fatalf("expected one warning for crossing 80%%, got %d", n)
+	}
+
+	insertUsage(t, db, "b", "acme", 100)
+	w.CheckAll()
+	if n := queuedWarnings(t, db); n != 2 {
+		t.Fatalf("expected another warning for crossing 90%%, got %d", n)
+	}
+
+	// Freeing storage rearms the warnings
+	db.Exec("UPDATE deployment_usage SET deleted_at = ?", time.Now().UTC())
+	w.CheckAll()
+	insertUsage(t, db, "c", "acme", 850)
+	w.CheckAll()
+	if n := queuedWarnings(t, db); n != 3 {
+		t.Errorf("expected a warning after dropping below and crossing again, got %d", n)
+	}
+}
+
+func TestWatcherWarnings(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	var disabled *Watcher
+	if disabled.Enabled() || disabled.Warnings("acme") != nil {
+		t.Error("expected a nil watcher to be disabled")
+	}
+
+	w := NewWatcher(db, Limits{StorageBytes: 1000}, []int{80}, time.Minute, nil, nil)
+	insertUsage(t, db, "a", "acme", 900)
+	headers := Headers(w.Warnings("acme"))
+	if len(headers) != 1 || !strings.HasPrefix(headers[0], "storage; percent=90;") {
+		t.Errorf("expected a storage warning header, got %q", headers)
+	}
+	if n := queuedWarnings(t, db); n != 1 {
+		t.Errorf("expected the warning to be announced when first seen, got %d", n)
+	}
+}
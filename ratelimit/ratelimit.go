@@ -0,0 +1,82 @@
+// Package ratelimit limits how often each client may call the API with
+// token buckets: a client's bucket holds up to Burst requests and refills
+// at PerMinute requests a minute.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// maxBuckets bounds memory; full buckets are pruned beyond it
+const maxBuckets = 10000
+
+// Limiter keeps a token bucket per key. A nil Limiter never limits.
+type Limiter struct {
+	PerMinute int
+	Burst     int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+// bucket is a token bucket kept as the time it will be full again: until
+// then it is a token short for every refill interval left
+type bucket struct {
+	full time.Time
+}
+
+// New returns a limiter allowing each key perMinute requests a minute, in
+// bursts of up to burst, or nil, which never limits, if perMinute isn't
+// positive. A burst below 1 is taken as 1.
+func New(perMinute, burst int) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &Limiter{
+		PerMinute: perMinute,
+		Burst:     max(burst, 1),
+		buckets:   map[string]*bucket{},
+		now:       time.Now,
+	}
+}
+
+// Allow takes a request from key's bucket. It returns 0 if there was one,
+// otherwise how long the caller must wait before the next is accepted.
+func (l *Limiter) Allow(key string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.prune(now)
+		}
+		b = &bucket{full: now}
+		l.buckets[key] = b
+	}
+	interval := time.Minute / time.Duration(l.PerMinute)
+	full := b.full
+	if full.Before(now) {
+		full = now
+	}
+	if over := full.Sub(now) - interval*time.Duration(l.Burst-1); over > 0 {
+		return over
+	}
+	b.full = full.Add(interval)
+	return 0
+}
+
+// prune forgets buckets that are full again, as a new bucket starts full
+func (l *Limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if !b.full.After(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(60, 3)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if wait := l.Allow("a"); wait != 0 {
+			t.Fatalf("request %d: expected the burst to be allowed, got a wait of %v", i+1, wait)
+		}
+	}
+	if wait := l.Allow("a"); wait != time.Second {
+		t.Errorf("expected a one second wait once the burst is spent, got %v", wait)
+	}
+	if wait := l.Allow("b"); wait != 0 {
+		t.Errorf("expected another key to have its own bucket, got a wait of %v", wait)
+	}
+
+	now = now.Add(time.Second)
+	if wait := l.Allow("a"); wait != 0 {
+		t.Errorf("expected a token to refill after a second, got a wait of %v", wait)
+	}
+	if wait := l.Allow("a"); wait == 0 {
+		t.Error("expected only one token to have refilled")
+	}
+
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if wait := l.Allow("a"); wait != 0 {
+			t.Fatalf("expected the bucket to refill up to the burst, got a wait of %v", wait)
+		}
+	}
+	if wait := l.Allow("a"); wait == 0 {
+		t.Error("expected the bucket not to refill beyond the burst")
+	}
+
+	var disabled *Limiter = New(0, 10)
+	if disabled != nil || disabled.Allow("a") != 0 {
+		t.Error("expected a limiter without a rate never to limit")
+	}
+}
@@ -0,0 +1,294 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"static-site-hosting/artifacts"
+	"static-site-hosting/events"
+	"static-site-hosting/immutable"
+	"static-site-hosting/integrity"
+	"static-site-hosting/locks"
+	"static-site-hosting/models"
+	"static-site-hosting/notify"
+	"static-site-hosting/routecache"
+	"static-site-hosting/tombstone"
+	"static-site-hosting/usage"
+	"static-site-hosting/webhooks"
+)
+
+// Policy deletes deployments MaxAge after they were created unless pinned.
+// Warning is how far ahead of deletion owners are notified.
+type Policy struct {
+	MaxAge  time.Duration
+	Warning time.Duration
+}
+
+// Active reports whether deployments expire at all
+func (p Policy) Active() bool {
+	return p.MaxAge > 0
+}
+
+// ExpiringDeployment is a deployment with its scheduled deletion time
+type ExpiringDeployment struct {
+	models.Deployment
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expiring returns unpinned deployments scheduled for deletion before
+// now+within, soonest first. Already-overdue deployments are included.
+func Expiring(db *sql.DB, policy Policy, now time.Time, within time.Duration) ([]ExpiringDeployment, error) {
+	if !policy.Active() {
+		return []ExpiringDeployment{}, nil
+	}
+
+	rows, err := db.Query(
+		`SELECT id, filename, timestamp, path, site FROM deployments
+		WHERE id NOT IN (SELECT deployment_id FROM deployment_pins)`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cutoff := now.Add(within)
+	expiring := []ExpiringDeployment{}
+	for rows.Next() {
+		var d models.Deployment
+		if err := rows.Scan(&d.ID, &d.Filename, &d.Timestamp, &d.Path, &d.Site); err != nil {
+			return nil, err
+		}
+		expiresAt := d.Timestamp.Add(policy.MaxAge)
+		if expiresAt.Before(cutoff) {
+			expiring = append(expiring, ExpiringDeployment{Deployment: d, ExpiresAt: expiresAt.UTC()})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(expiring, func(i, j int) bool { return expiring[i].ExpiresAt.Before(expiring[j].ExpiresAt) })
+	return expiring, nil
+}
+
+// Sweeper warns about deployments nearing expiry and deletes expired ones,
+// along with the deployments of sites whose expiry date is SiteGrace past
+type Sweeper struct {
+	DB         *sql.DB
+	Policy     Policy
+	SiteGrace  time.Duration
+	Mailer     *notify.Mailer
+	Recipients []string // addresses that receive expiry emails
+	Interval   time.Duration
+}
+
+// NewSweeper returns a sweeper that runs hourly
+func NewSweeper(db *sql.DB, policy Policy, mailer *notify.Mailer, recipients []string) *Sweeper {
+	return &Sweeper{
+		DB:         db,
+		Policy:     policy,
+		Mailer:     mailer,
+		Recipients: recipients,
+		Interval:   time.Hour,
+	}
+}
+
+// Run sweeps every Interval until ctx is cancelled
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sweep(time.Now()); err != nil {
+			log.Printf("Warning: Retention sweep failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep deletes expired sites once their grace period is over, sends
+// warnings for deployments entering the warning window, then deletes
+// deployments whose retention period has passed
+func (s *Sweeper) Sweep(now time.Time) error {
+	if err := s.sweepSites(now); err != nil {
+		return err
+	}
+	if !s.Policy.Active() {
+		return nil
+	}
+
+	upcoming, err := Expiring(s.DB, s.Policy, now, s.Policy.Warning)
+	if err != nil {
+		return err
+	}
+
+	var warn []ExpiringDeployment
+	for _, d := range upcoming {
+		if !d.ExpiresAt.After(now) {
+			if err := s.delete(d.Deployment); err != nil {
+				log.Printf("Warning: Failed to delete expired deployment %s: %v", d.ID, err)
+			}
+			continue
+		}
+		notified, err := s.notified(d.ID)
+		if err != nil {
+			return err
+		}
+		if !notified {
+			warn = append(warn, d)
+		}
+	}
+
+	if len(warn) > 0 {
+		s.warn(warn, now)
+	}
+	return nil
+}
+
+// sweepSites deletes every deployment of sites that expired more than
+// SiteGrace ago. The expiry is forgotten once none are left, so a site
+// whose deployments are busy elsewhere is retried next sweep.
+func (s *Sweeper) sweepSites(now time.Time) error {
+	rows, err := s.DB.Query("SELECT site FROM site_expiry WHERE expires_at <= ?", now.Add(-s.SiteGrace).UTC())
+	if err != nil {
+		return err
+	}
+	var sites []string
+	for rows.Next() {
+		var site string
+		if err := rows.Scan(&site); err != nil {
+			rows.Close()
+			return err
+		}
+		sites = append(sites, site)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, site := range sites {
+		rows, err := s.DB.Query("SELECT id, filename, timestamp, path, site FROM deployments WHERE site = ?", site)
+		if err != nil {
+			return err
+		}
+		var deployments []models.Deployment
+		for rows.Next() {
+			var d models.Deployment
+			if err := rows.Scan(&d.ID, &d.Filename, &d.Timestamp, &d.Path, &d.Site); err != nil {
+				rows.Close()
+				return err
+			}
+			deployments = append(deployments, d)
+		}
+		rows.Close()
+
+		remaining := 0
+		for _, d := range deployments {
+			if err := s.delete(d); err != nil {
+				log.Printf("Warning: Failed to delete deployment %s of expired site %s: %v", d.ID, site, err)
+				remaining++
+			}
+		}
+		if remaining == 0 {
+			s.DB.Exec("DELETE FROM site_expiry WHERE site = ?", site)
+			routecache.Invalidate()
+			log.Printf("Retention: deleted expired site %s (%d deployments)", site, len(deployments))
+		}
+	}
+	return nil
+}
+
+func (s *Sweeper) notified(deploymentID string) (bool, error) {
+	var count int
+	err := s.DB.QueryRow("SELECT COUNT(*) FROM expiry_notices WHERE deployment_id = ?", deploymentID).Scan(&count)
+	return count > 0, err
+}
+
+func (s *Sweeper) warn(deployments []ExpiringDeployment, now time.Time) {
+	var sites []string
+	for _, d := range deployments {
+		sites = append(sites, d.Site)
+	}
+	webhooks.Notify(s.DB, webhooks.EventDeploymentExpiring, map[string]interface{}{
+		"deployments": deployments,
+	}, sites...)
+
+	if s.Mailer.Enabled() && len(s.Recipients) > 0 {
+		if err := s.Mailer.Send(s.Recipients, expirySubject(deployments), expiryBody(deployments)); err != nil {
+			log.Printf("Warning: Failed to send expiry email: %v", err)
+		}
+	}
+
+	// Each deployment is announced once; pinning and unpinning resets this
+	for _, d := range deployments {
+		s.DB.Exec("INSERT OR IGNORE INTO expiry_notices (deployment_id, notified_at) VALUES (?, ?)", d.ID, now.UTC())
+	}
+}
+
+func (s *Sweeper) delete(d models.Deployment) error {
+	// A deployment being copied or deleted elsewhere is retried next sweep
+	unlock, err := locks.Default.TryLock("retention sweep", locks.Deployment(d.ID))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := tombstone.Add(s.DB, d.ID); err != nil {
+		tombstone.Remove(s.DB, d.ID)
+		return err
+	}
+	if _, err := s.DB.Exec("DELETE FROM deployments WHERE id = ?", d.ID); err != nil {
+		tombstone.Remove(s.DB, d.ID)
+		return err
+	}
+	routecache.Invalidate()
+	s.DB.Exec("DELETE FROM site_settings WHERE site_id = ?", d.ID)
+	s.DB.Exec("DELETE FROM preload_hints WHERE deployment_id = ?", d.ID)
+	s.DB.Exec("DELETE FROM deployment_pins WHERE deployment_id = ?", d.ID)
+	s.DB.Exec("DELETE FROM deployment_passwords WHERE deployment_id = ?", d.ID)
+	s.DB.Exec("DELETE FROM expiry_notices WHERE deployment_id = ?", d.ID)
+	s.DB.Exec("DELETE FROM deployment_reports WHERE deployment_id = ?", d.ID)
+	artifacts.Remove(s.DB, d.ID)
+	integrity.Remove(s.DB, d.ID)
+	usage.MarkDeleted(s.DB, d.ID)
+	webhooks.Notify(s.DB, webhooks.EventDeploymentDeleted, d, d.Site)
+	events.Record(s.DB, events.DeploymentDeleted, d.ID, d.Site, map[string]any{"deployment": d})
+
+	// Aliased deployments share files; only the last one removes them
+	var sharing int
+	s.DB.QueryRow("SELECT COUNT(*) FROM deployments WHERE path = ?", d.Path).Scan(&sharing)
+	if sharing == 0 {
+		if err := immutable.RemoveAll(d.Path); err != nil {
+			log.Printf("Warning: Failed to delete files at %s: %v", d.Path, err)
+		}
+	}
+	log.Printf("Retention: deleted deployment %s (%s)", d.ID, d.Filename)
+	return nil
+}
+
+func expirySubject(deployments []ExpiringDeployment) string {
+	if len(deployments) == 1 {
+		return fmt.Sprintf("Deployment %s is scheduled for deletion", deployments[0].ID)
+	}
+	return fmt.Sprintf("%d deployments are scheduled for deletion", len(deployments))
+}
+
+func expiryBody(deployments []ExpiringDeployment) string {
+	var b strings.Builder
+	b.WriteString("The following deployments will be deleted by the retention policy.\n")
+	b.WriteString("Pin any you still need with POST /deployments/{id}/pin.\n\n")
+	for _, d := range deployments {
+		fmt.Fprintf(&b, "  %s  %s  deletes at %s\n", d.ID, d.Filename, d.ExpiresAt.Format(time.RFC3339))
+	}
+	return b.String()
+}
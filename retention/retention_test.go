@@ -0,0 +1,179 @@
+package retention
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	schema := []string{
+		`CREATE TABLE deployments (
+			id TEXT PRIMARY KEY,
+			filename TEXT NOT NULL,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+			path TEXT NOT NULL,
+			site TEXT NOT NULL DEFAULT '',
+			archive_sha256 TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE TABLE site_settings (site_id TEXT PRIMARY KEY, settings TEXT NOT NULL)`,
+		`CREATE TABLE preload_hints (deployment_id TEXT NOT NULL, page TEXT NOT NULL, hints TEXT NOT NULL, PRIMARY KEY (deployment_id, page))`,
+		`CREATE TABLE webhooks (id TEXT PRIMARY KEY, url TEXT NOT NULL, secret TEXT NOT NULL, events TEXT NOT NULL DEFAULT '', sites TEXT NOT NULL DEFAULT '', created_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			webhook_id TEXT NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at DATETIME NOT NULL,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			delivered_at DATETIME
+		)`,
+		`CREATE TABLE deployment_usage (
+			deployment_id TEXT PRIMARY KEY,
+			tenant_id TEXT NOT NULL,
+			bytes INTEGER NOT NULL DEFAULT 0,
+			build_ms INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL,
+			deleted_at DATETIME
+		)`,
+		`CREATE TABLE deployment_pins (deployment_id TEXT PRIMARY KEY, pinned_at DATETIME NOT NULL)`,
+		`CREATE TABLE expiry_notices (deployment_id TEXT PRIMARY KEY, notified_at DATETIME NOT NULL)`,
+		`CREATE TABLE site_expiry (site TEXT PRIMARY KEY, expires_at DATETIME NOT NULL, updated_at DATETIME NOT NULL)`,
+		`CREATE TABLE deployment_tombstones (deployment_id TEXT PRIMARY KEY, deleted_at DATETIME NOT NULL)`,
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+	}
+	return db
+}
+
+func insertDeployment(t *testing.T, db *sql.DB, id string, created time.Time, path string) {
+	_, err := db.Exec("INSERT INTO deployments (id, filename, timestamp, path) VALUES (?, ?, ?, ?)", id, id+".zip", created, path)
+	if err != nil {
+		t.Fatalf("Failed to insert deployment: %v", err)
+	}
+}
+
+func TestExpiring(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	now := time.Now()
+	policy := Policy{MaxAge: 30 * 24 * time.Hour, Warning: 7 * 24 * time.Hour}
+
+	insertDeployment(t, db, "old", now.Add(-28*24*time.Hour), "x")
+	insertDeployment(t, db, "new", now.Add(-1*24*time.Hour), "x")
+	insertDeployment(t, db, "pinned", now.Add(-29*24*time.Hour), "x")
+	db.Exec("INSERT INTO deployment_pins (deployment_id, pinned_at) VALUES ('pinned', ?)", now)
+
+	expiring, err := Expiring(db, policy, now, policy.Warning)
+	if err != nil {
+		t.Fatalf("Expiring failed: %v", err)
+	}
+	if len(expiring) != 1 || expiring[0].ID != "old" {
+		t.Fatalf("expected only 'old' to be expiring, got %+v", expiring)
+	}
+
+	expiring, _ = Expiring(db, Policy{}, now, policy.Warning)
+	if len(expiring) != 0 {
+		t.Errorf("expected nothing to expire without a policy, got %d", len(expiring))
+	}
+}
+
+func TestSweepWarnsOnceAndDeletesExpired(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	db.Exec("INSERT INTO webhooks (id, url, secret) VALUES ('wh', 'http://example.invalid', '')")
+	db.Exec("INSERT INTO webhooks (id, url, secret, sites) VALUES ('wh-docs', 'http://example.invalid', '', 'docs')")
+
+	dir := filepath.Join(t.TempDir(), "expired")
+	os.MkdirAll(dir, 0755)
+
+	now := time.Now()
+	insertDeployment(t, db, "expired", now.Add(-31*24*time.Hour), dir)
+	insertDeployment(t, db, "soon", now.Add(-25*24*time.Hour), "x")
+	db.Exec("UPDATE deployments SET site = 'docs' WHERE id = 'soon'")
+
+	s := NewSweeper(db, Policy{MaxAge: 30 * 24 * time.Hour, Warning: 7 * 24 * time.Hour}, nil, nil)
+	if err := s.Sweep(now); err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+	if err := s.Sweep(now); err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+
+	var remaining int
+	db.QueryRow("SELECT COUNT(*) FROM deployments WHERE id = 'expired'").Scan(&remaining)
+	if remaining != 0 {
+		t.Error("expected expired deployment to be deleted")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("expected expired deployment files to be removed")
+	}
+
+	var warnings, deletions int
+	db.QueryRow("SELECT COUNT(*) FROM webhook_deliveries WHERE event = 'deployment.expiring'").Scan(&warnings)
+	db.QueryRow("SELECT COUNT(*) FROM webhook_deliveries WHERE event = 'deployment.deleted'").Scan(&deletions)
+	if warnings != 2 {
+		t.Errorf("expected one expiry warning per webhook across two sweeps, got %d", warnings)
+	}
+	var siteWarnings int
+	db.QueryRow("SELECT COUNT(*) FROM webhook_deliveries WHERE event = 'deployment.expiring' AND webhook_id = 'wh-docs'").Scan(&siteWarnings)
+	if siteWarnings != 1 {
+		t.Errorf("expected the webhook filtered to docs to be warned, got %d deliveries", siteWarnings)
+	}
+	if deletions != 1 {
+		t.Errorf("expected one deletion event, got %d", deletions)
+	}
+}
+
+func TestSweepDeletesExpiredSites(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	now := time.Now()
+	for _, d := range []struct{ id, site string }{{"event-1", "event"}, {"event-2", "event"}, {"recent-1", "recent"}, {"docs-1", "docs"}} {
+		dir := filepath.Join(t.TempDir(), d.id)
+		os.MkdirAll(dir, 0755)
+		db.Exec("INSERT INTO deployments (id, filename, timestamp, path, site) VALUES (?, 'a.zip', ?, ?, ?)", d.id, now, dir, d.site)
+	}
+	db.Exec("INSERT INTO site_expiry (site, expires_at, updated_at) VALUES ('event', ?, ?), ('recent', ?, ?)",
+		now.Add(-8*24*time.Hour).UTC(), now, now.Add(-time.Hour).UTC(), now)
+
+	// Sites expire whether or not deployments do
+	s := NewSweeper(db, Policy{}, nil, nil)
+	s.SiteGrace = 7 * 24 * time.Hour
+	if err := s.Sweep(now); err != nil {
+		t.Fatalf("Sweep failed: %v", err)
+	}
+
+	count := func(query string) (n int) {
+		db.QueryRow(query).Scan(&n)
+		return n
+	}
+	if n := count("SELECT COUNT(*) FROM deployments WHERE site = 'event'"); n != 0 {
+		t.Errorf("expected the site past its grace period to be deleted, %d deployments left", n)
+	}
+	if n := count("SELECT COUNT(*) FROM site_expiry WHERE site = 'event'"); n != 0 {
+		t.Error("expected the deleted site's expiry to be forgotten")
+	}
+	if n := count("SELECT COUNT(*) FROM deployments WHERE site IN ('recent', 'docs')"); n != 2 {
+		t.Errorf("expected sites within their grace period or without expiry to remain, got %d deployments", n)
+	}
+}
@@ -0,0 +1,155 @@
+package routecache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"static-site-hosting/coalesce"
+)
+
+// Cache lifetimes, set from configuration at startup. Entries are dropped
+// as soon as Invalidate is called; TTL only bounds how long changes made
+// elsewhere, such as by another server sharing the database, go unseen.
+// MaxStale is how long entries keep serving while the database is down.
+var (
+	TTL      = time.Minute
+	MaxStale = 24 * time.Hour
+)
+
+// maxEntries bounds the cache; it starts over when full
+const maxEntries = 10000
+
+// ErrUnavailable is returned for lookups with no usable entry while the
+// database is down
+var ErrUnavailable = errors.New("database unavailable")
+
+// The cache holds the lookups static serving depends on, such as which
+// deployment a host serves, where a deployment's files are and its site
+// settings, so serving a file doesn't query the database.
+var cache = struct {
+	sync.Mutex
+	entries map[string]entry
+}{entries: map[string]entry{}}
+
+type entry struct {
+	value      any
+	err        error // sql.ErrNoRows, so unknown names are cached too
+	generation uint64
+	fetched    time.Time
+}
+
+// fetches coalesces concurrent fetches of the same key and generation
+var fetches coalesce.Group[any]
+
+// generation is bumped by Invalidate; older entries are refetched
+var generation atomic.Uint64
+
+// Invalidate marks every entry out of date, on every node when the cache
+// is shared. Call it after changing anything a lookup reads. Entries are
+// kept to serve from if the database fails.
+func Invalidate() {
+	generation.Add(1)
+	announce()
+}
+
+// Lookup returns the value stored under key, calling fetch when there is no
+// current entry here or in the shared tier. If fetch fails, or the database
+// is known to be down, the last entry is returned while it is younger than
+// MaxStale.
+func Lookup[V any](key string, fetch func() (V, error)) (V, error) {
+	gen := generation.Load()
+	rkey := remoteKey(key)
+	cache.Lock()
+	e, ok := cache.entries[key]
+	cache.Unlock()
+	age := time.Since(e.fetched)
+	if ok && e.generation == gen && age < TTL {
+		return e.value.(V), e.err
+	}
+
+	// After a deploy invalidates the cache, every request for a busy site
+	// misses at once; they share a single fetch
+	v, err, _ := fetches.Do(key+"@"+strconv.FormatUint(gen, 10), func() (any, error) {
+		// Another node may have fetched it already
+		if v, err, ok := loadShared[V](rkey); ok {
+			return v, err
+		}
+		if !Available() {
+			return nil, ErrUnavailable
+		}
+		v, err := fetch()
+		if err == nil || err == sql.ErrNoRows {
+			saveShared(rkey, v, err)
+		}
+		return v, err
+	})
+	value, _ := v.(V)
+	if err == nil || err == sql.ErrNoRows {
+		cache.Lock()
+		if len(cache.entries) >= maxEntries {
+			cache.entries = map[string]entry{}
+		}
+		cache.entries[key] = entry{value: value, err: err, generation: gen, fetched: time.Now()}
+		cache.Unlock()
+		return value, err
+	}
+	if ok && age < MaxStale {
+		return e.value.(V), e.err
+	}
+	return value, err
+}
+
+// available is maintained by Monitor; lookups skip the database while it
+// is down rather than wait on it
+var available atomic.Bool
+
+func init() {
+	available.Store(true)
+}
+
+// Available reports whether the last database health check passed
+func Available() bool {
+	return available.Load()
+}
+
+// SetAvailable overrides the database state until the next check
+func SetAvailable(up bool) {
+	available.Store(up)
+}
+
+// Monitor checks the database every interval until ctx is cancelled
+func Monitor(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		Check(ctx, db, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check queries the database once, updating Available and logging when
+// the database goes down or comes back
+func Check(ctx context.Context, db *sql.DB, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var one int
+	err := db.QueryRowContext(ctx, "SELECT 1 FROM deployments LIMIT 1").Scan(&one)
+	up := err == nil || err == sql.ErrNoRows
+	if was := available.Swap(up); was != up {
+		if up {
+			log.Println("Database available again")
+		} else {
+			log.Printf("Database unavailable, serving sites from the routing cache: %v", err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package routecache
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLookupCachesUntilInvalidated(t *testing.T) {
+	calls := 0
+	fetch := func() (string, error) {
+		calls++
+		return "value", nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if v, err := Lookup("test:hit", fetch); err != nil || v != "value" {
+			t.Fatalf("expected value, got %q, %v", v, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected one fetch for repeated lookups, got %d", calls)
+	}
+
+	Invalidate()
+	Lookup("test:hit", fetch)
+	if calls != 2 {
+		t.Errorf("expected a fetch after Invalidate, got %d fetches", calls)
+	}
+}
+
+func TestLookupCachesMissingRows(t *testing.T) {
+	calls := 0
+	fetch := func() (string, error) {
+		calls++
+		return "", sql.ErrNoRows
+	}
+
+	Lookup("test:missing", fetch)
+	if _, err := Lookup("test:missing", fetch); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected unknown keys to be cached, got %d fetches", calls)
+	}
+}
+
+func TestLookupServesStaleEntriesOnFailure(t *testing.T) {
+	savedMaxStale := MaxStale
+	defer func() { MaxStale = savedMaxStale }()
+
+	Lookup("test:stale", func() (string, error) { return "old", nil })
+	Invalidate()
+
+	failing := func() (string, error) { return "", errors.New("database is locked") }
+	if v, err := Lookup("test:stale", failing); err != nil || v != "old" {
+		t.Errorf("expected the stale entry, got %q, %v", v, err)
+	}
+
+	SetAvailable(false)
+	defer SetAvailable(true)
+	if v, err := Lookup("test:stale", failing); err != nil || v != "old" {
+		t.Errorf("expected the stale entry while unavailable, got %q, %v", v, err)
+	}
+	if _, err := Lookup("test:unknown", failing); err != ErrUnavailable {
+		t.Errorf("expected ErrUnavailable without an entry, got %v", err)
+	}
+
+	MaxStale = time.Duration(0)
+	if _, err := Lookup("test:stale", failing); err != ErrUnavailable {
+		t.Errorf("expected entries past MaxStale to be dropped, got %v", err)
+	}
+}
+
+func TestLookupCoalescesConcurrentMisses(t *testing.T) {
+	Invalidate()
+	var calls atomic.Int32
+	fetch := func() (int, error) {
+		calls.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		return 42, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := Lookup("test:herd", fetch); v != 42 || err != nil {
+				t.Errorf("expected 42, got %d, %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected concurrent misses to share one fetch, got %d", n)
+	}
+}
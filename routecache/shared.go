@@ -0,0 +1,170 @@
+package routecache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"static-site-hosting/shared"
+)
+
+// Remote is a cache tier shared by every node. Entries are kept under the
+// shared generation, which Invalidate advances and announces to the other
+// nodes, so a deploy on one node is seen by all of them at once.
+type Remote interface {
+	shared.Store
+	shared.Cache
+}
+
+const (
+	generationKey     = "routecache:generation"
+	invalidateChannel = "routecache:invalidate"
+)
+
+var remote struct {
+	sync.RWMutex
+	store Remote
+}
+
+// sharedGeneration is the last shared generation this node has seen
+var sharedGeneration atomic.Int64
+
+// remoteEntry is how entries are stored in the shared tier
+type remoteEntry[V any] struct {
+	Value   V    `json:"value"`
+	Missing bool `json:"missing,omitempty"` // cached sql.ErrNoRows
+}
+
+// Share puts store in front of the database for every node using it and
+// follows the invalidations they announce until ctx is cancelled. Lookups
+// fall back to the database whenever store can't be reached.
+func Share(ctx context.Context, store Remote) {
+	remote.Lock()
+	remote.store = store
+	remote.Unlock()
+	go follow(ctx, store)
+}
+
+func sharedStore() Remote {
+	remote.RLock()
+	defer remote.RUnlock()
+	return remote.store
+}
+
+// follow subscribes to invalidations, resubscribing after failures
+func follow(ctx context.Context, store Remote) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		// Invalidations announced while unsubscribed were missed
+		refreshGeneration(store)
+		started := time.Now()
+		err := store.Subscribe(ctx, invalidateChannel, func(msg string) {
+			if gen, err := strconv.ParseInt(msg, 10, 64); err == nil {
+				advanceGeneration(gen)
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Warning: Routing cache invalidations unavailable: %v", err)
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// refreshGeneration reads the shared generation and drops local entries
+// if it moved
+func refreshGeneration(store Remote) {
+	ctx, cancel := shared.Context()
+	defer cancel()
+	if gen, err := store.Get(ctx, generationKey); err == nil {
+		advanceGeneration(gen)
+	}
+}
+
+// advanceGeneration moves to shared generation gen if it is newer
+func advanceGeneration(gen int64) {
+	for {
+		seen := sharedGeneration.Load()
+		if gen <= seen {
+			return
+		}
+		if sharedGeneration.CompareAndSwap(seen, gen) {
+			generation.Add(1)
+			return
+		}
+	}
+}
+
+// announce advances the shared generation and tells the other nodes
+func announce() {
+	store := sharedStore()
+	if store == nil {
+		return
+	}
+	ctx, cancel := shared.Context()
+	defer cancel()
+	gen, err := store.IncrBy(ctx, generationKey, 1, 0)
+	if err != nil {
+		log.Printf("Warning: Failed to share routing cache invalidation: %v", err)
+		return
+	}
+	advanceGeneration(gen)
+	if err := store.Publish(ctx, invalidateChannel, strconv.FormatInt(gen, 10)); err != nil {
+		log.Printf("Warning: Failed to share routing cache invalidation: %v", err)
+	}
+}
+
+// remoteKey is where key is shared in the current shared generation
+func remoteKey(key string) string {
+	return "routecache:" + strconv.FormatInt(sharedGeneration.Load(), 10) + ":" + key
+}
+
+// loadShared returns the shared entry stored under rkey, if there is a
+// usable one
+func loadShared[V any](rkey string) (V, error, bool) {
+	var e remoteEntry[V]
+	store := sharedStore()
+	if store == nil {
+		return e.Value, nil, false
+	}
+	ctx, cancel := shared.Context()
+	defer cancel()
+	data, ok, err := store.Load(ctx, rkey)
+	if err != nil || !ok || json.Unmarshal(data, &e) != nil {
+		return e.Value, nil, false
+	}
+	if e.Missing {
+		return e.Value, sql.ErrNoRows, true
+	}
+	return e.Value, nil, true
+}
+
+// saveShared stores a fetched entry under rkey for the other nodes. The key
+// is taken before fetching, so an entry fetched across an invalidation is
+// filed under the generation it belongs to.
+func saveShared[V any](rkey string, value V, err error) {
+	store := sharedStore()
+	if store == nil {
+		return
+	}
+	data, jerr := json.Marshal(remoteEntry[V]{Value: value, Missing: err == sql.ErrNoRows})
+	if jerr != nil {
+		return
+	}
+	ctx, cancel := shared.Context()
+	defer cancel()
+	store.Save(ctx, rkey, data, TTL)
+}
@@ -0,0 +1,104 @@
+package routecache
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"testing"
+	"time"
+
+	"static-site-hosting/shared"
+)
+
+// shareMemory shares the cache through an in-process store for the test
+func shareMemory(t *testing.T) *shared.Memory {
+	store := shared.NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	Share(ctx, store)
+	t.Cleanup(func() {
+		cancel()
+		remote.Lock()
+		remote.store = nil
+		remote.Unlock()
+	})
+	return store
+}
+
+// forgetLocal empties this node's cache, as if another node were asking
+func forgetLocal() {
+	cache.Lock()
+	cache.entries = map[string]entry{}
+	cache.Unlock()
+}
+
+func TestSharedLookupServesOtherNodesFetches(t *testing.T) {
+	shareMemory(t)
+
+	calls := 0
+	fetch := func() (string, error) {
+		calls++
+		return "deployments/abc", nil
+	}
+	missing := func() (string, error) {
+		calls++
+		return "", sql.ErrNoRows
+	}
+	Lookup("test:shared", fetch)
+	Lookup("test:shared-missing", missing)
+	forgetLocal()
+
+	if v, err := Lookup("test:shared", fetch); err != nil || v != "deployments/abc" {
+		t.Errorf("expected the shared entry, got %q, %v", v, err)
+	}
+	if _, err := Lookup("test:shared-missing", missing); err != sql.ErrNoRows {
+		t.Errorf("expected the shared missing entry, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected entries fetched by one node to serve the others, got %d fetches", calls)
+	}
+
+	// Shared entries also serve while the database is down
+	forgetLocal()
+	SetAvailable(false)
+	defer SetAvailable(true)
+	if v, err := Lookup("test:shared", fetch); err != nil || v != "deployments/abc" {
+		t.Errorf("expected the shared entry while unavailable, got %q, %v", v, err)
+	}
+}
+
+func TestSharedInvalidationReachesEveryNode(t *testing.T) {
+	store := shareMemory(t)
+	ctx := context.Background()
+
+	calls := 0
+	fetch := func() (string, error) {
+		calls++
+		return "v" + strconv.Itoa(calls), nil
+	}
+	Lookup("test:broadcast", fetch)
+
+	// Another node invalidates: it advances the shared generation and
+	// announces it. Keep announcing until the subscription is in place.
+	next := sharedGeneration.Load() + 1
+	store.Set(ctx, generationKey, next, time.Hour)
+	deadline := time.Now().Add(time.Second)
+	for sharedGeneration.Load() < next && time.Now().Before(deadline) {
+		store.Publish(ctx, invalidateChannel, strconv.FormatInt(next, 10))
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := sharedGeneration.Load(); got != next {
+		t.Fatalf("expected shared generation %d, got %d", next, got)
+	}
+	if v, _ := Lookup("test:broadcast", fetch); v != "v2" {
+		t.Errorf("expected a refetch after another node's invalidation, got %q", v)
+	}
+
+	// Local invalidations are announced to the others
+	Invalidate()
+	if gen, _ := store.Get(ctx, generationKey); gen != next+1 {
+		t.Errorf("expected Invalidate to advance the shared generation to %d, got %d", next+1, gen)
+	}
+	if v, _ := Lookup("test:broadcast", fetch); v != "v3" {
+		t.Errorf("expected a refetch after Invalidate, got %q", v)
+	}
+}
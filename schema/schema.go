@@ -0,0 +1,516 @@
+// Package schema creates the SQLite tables the server keeps its state in.
+package schema
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// OpenMemory returns a database with every table that lives only in memory
+// and is gone once closed
+func OpenMemory() (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	// Every connection would get its own in-memory database
+	db.SetMaxOpenConns(1)
+	if err := Create(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Create creates any of the tables that don't exist yet
+func Create(db *sql.DB) error {
+	createDeploymentsTable := `
+	CREATE TABLE IF NOT EXISTS deployments (
+		id TEXT PRIMARY KEY,
+		filename TEXT NOT NULL,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+		path TEXT NOT NULL,
+		site TEXT NOT NULL DEFAULT '',
+		archive_sha256 TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'ready',
+		owner_id TEXT NOT NULL DEFAULT '',
+		deployed_by TEXT NOT NULL DEFAULT '',
+		visibility TEXT NOT NULL DEFAULT 'public',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(createDeploymentsTable); err != nil {
+		return err
+	}
+
+	createSiteSettingsTable := `
+	CREATE TABLE IF NOT EXISTS site_settings (
+		site_id TEXT PRIMARY KEY,
+		settings TEXT NOT NULL DEFAULT '{}',
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(createSiteSettingsTable); err != nil {
+		return err
+	}
+
+	createPreloadHintsTable := `
+	CREATE TABLE IF NOT EXISTS preload_hints (
+		deployment_id TEXT NOT NULL,
+		page TEXT NOT NULL,
+		hints TEXT NOT NULL,
+		PRIMARY KEY (deployment_id, page)
+	)`
+
+	if _, err := db.Exec(createPreloadHintsTable); err != nil {
+		return err
+	}
+
+	createWebhooksTable := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL DEFAULT '',
+		sites TEXT NOT NULL DEFAULT '',
+		owner_id TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := db.Exec(createWebhooksTable); err != nil {
+		return err
+	}
+
+	createWebhookDeliveriesTable := `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id TEXT PRIMARY KEY,
+		webhook_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at DATETIME NOT NULL,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		delivered_at DATETIME
+	)`
+
+	if _, err := db.Exec(createWebhookDeliveriesTable); err != nil {
+		return err
+	}
+
+	createWebhookAttemptsTable := `
+	CREATE TABLE IF NOT EXISTS webhook_attempts (
+		delivery_id TEXT NOT NULL,
+		attempt INTEGER NOT NULL,
+		status_code INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		attempted_at DATETIME NOT NULL
+	)`
+
+	if _, err := db.Exec(createWebhookAttemptsTable); err != nil {
+		return err
+	}
+
+	createWebhookSecretsTable := `
+	CREATE TABLE IF NOT EXISTS webhook_secrets (
+		id TEXT PRIMARY KEY,
+		webhook_id TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		retired_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL
+	)`
+
+	if _, err := db.Exec(createWebhookSecretsTable); err != nil {
+		return err
+	}
+
+	createSiteExpiryTable := `
+	CREATE TABLE IF NOT EXISTS site_expiry (
+		site TEXT PRIMARY KEY,
+		expires_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`
+
+	if _, err := db.Exec(createSiteExpiryTable); err != nil {
+		return err
+	}
+
+	createDeploymentTombstonesTable := `
+	CREATE TABLE IF NOT EXISTS deployment_tombstones (
+		deployment_id TEXT PRIMARY KEY,
+		deleted_at DATETIME NOT NULL
+	)`
+
+	if _, err := db.Exec(createDeploymentTombstonesTable); err != nil {
+		return err
+	}
+
+	createQuotaNoticesTable := `
+	CREATE TABLE IF NOT EXISTS quota_notices (
+		tenant_id TEXT NOT NULL,
+		resource TEXT NOT NULL,
+		threshold INTEGER NOT NULL,
+		period TEXT NOT NULL DEFAULT '',
+		notified_at DATETIME NOT NULL,
+		PRIMARY KEY (tenant_id, resource)
+	)`
+
+	if _, err := db.Exec(createQuotaNoticesTable); err != nil {
+		return err
+	}
+
+	createDeploymentUsageTable := `
+	CREATE TABLE IF NOT EXISTS deployment_usage (
+		deployment_id TEXT PRIMARY KEY,
+		tenant_id TEXT NOT NULL,
+		bytes INTEGER NOT NULL DEFAULT 0,
+		build_ms INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		deleted_at DATETIME
+	)`
+
+	if _, err := db.Exec(createDeploymentUsageTable); err != nil {
+		return err
+	}
+
+	createBandwidthDailyTable := `
+	CREATE TABLE IF NOT EXISTS bandwidth_daily (
+		deployment_id TEXT NOT NULL,
+		day TEXT NOT NULL,
+		bytes INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (deployment_id, day)
+	)`
+
+	if _, err := db.Exec(createBandwidthDailyTable); err != nil {
+		return err
+	}
+
+	createDeploymentPinsTable := `
+	CREATE TABLE IF NOT EXISTS deployment_pins (
+		deployment_id TEXT PRIMARY KEY,
+		pinned_at DATETIME NOT NULL
+	)`
+
+	if _, err := db.Exec(createDeploymentPinsTable); err != nil {
+		return err
+	}
+
+	createDeploymentPasswordsTable := `
+	CREATE TABLE IF NOT EXISTS deployment_passwords (
+		deployment_id TEXT PRIMARY KEY,
+		username TEXT NOT NULL DEFAULT '',
+		password_hash TEXT NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`
+
+	if _, err := db.Exec(createDeploymentPasswordsTable); err != nil {
+		return err
+	}
+
+	// Events are never changed once written; replaying them rebuilds
+	// derived state
+	createEventsTable := `
+	CREATE TABLE IF NOT EXISTS events (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		type TEXT NOT NULL,
+		deployment_id TEXT NOT NULL DEFAULT '',
+		site TEXT NOT NULL DEFAULT '',
+		data TEXT NOT NULL DEFAULT 'null',
+		created_at DATETIME NOT NULL
+	);
+	CREATE TRIGGER IF NOT EXISTS events_no_update BEFORE UPDATE ON events
+	BEGIN SELECT RAISE(ABORT, 'events are append-only'); END;
+	CREATE TRIGGER IF NOT EXISTS events_no_delete BEFORE DELETE ON events
+	BEGIN SELECT RAISE(ABORT, 'events are append-only'); END`
+
+	if _, err := db.Exec(createEventsTable); err != nil {
+		return err
+	}
+
+	createSessionsTable := `
+	CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		subject TEXT NOT NULL,
+		user_agent TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL
+	)`
+
+	if _, err := db.Exec(createSessionsTable); err != nil {
+		return err
+	}
+
+	createOrganizationsTable := `
+	CREATE TABLE IF NOT EXISTS organizations (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		created_at DATETIME NOT NULL
+	)`
+
+	if _, err := db.Exec(createOrganizationsTable); err != nil {
+		return err
+	}
+
+	createOrganizationMembersTable := `
+	CREATE TABLE IF NOT EXISTS organization_members (
+		org_id TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		role TEXT NOT NULL,
+		added_at DATETIME NOT NULL,
+		PRIMARY KEY (org_id, subject)
+	)`
+
+	if _, err := db.Exec(createOrganizationMembersTable); err != nil {
+		return err
+	}
+
+	createServiceAccountsTable := `
+	CREATE TABLE IF NOT EXISTS service_accounts (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		attribution TEXT NOT NULL,
+		role TEXT NOT NULL,
+		tenant TEXT NOT NULL DEFAULT '',
+		created_by TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		revoked_at DATETIME
+	)`
+
+	if _, err := db.Exec(createServiceAccountsTable); err != nil {
+		return err
+	}
+
+	createTOTPSecretsTable := `
+	CREATE TABLE IF NOT EXISTS totp_secrets (
+		subject TEXT PRIMARY KEY,
+		secret TEXT NOT NULL,
+		last_step INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		confirmed_at DATETIME
+	)`
+
+	if _, err := db.Exec(createTOTPSecretsTable); err != nil {
+		return err
+	}
+
+	createExpiryNoticesTable := `
+	CREATE TABLE IF NOT EXISTS expiry_notices (
+		deployment_id TEXT PRIMARY KEY,
+		notified_at DATETIME NOT NULL
+	)`
+
+	if _, err := db.Exec(createExpiryNoticesTable); err != nil {
+		return err
+	}
+
+	createDeploymentReportsTable := `
+	CREATE TABLE IF NOT EXISTS deployment_reports (
+		deployment_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		report TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (deployment_id, kind)
+	)`
+
+	if _, err := db.Exec(createDeploymentReportsTable); err != nil {
+		return err
+	}
+
+	createFeatureFlagsTable := `
+	CREATE TABLE IF NOT EXISTS feature_flags (
+		name TEXT PRIMARY KEY,
+		enabled BOOLEAN NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`
+
+	if _, err := db.Exec(createFeatureFlagsTable); err != nil {
+		return err
+	}
+
+	createUploadPoliciesTable := `
+	CREATE TABLE IF NOT EXISTS upload_policies (
+		tenant_id TEXT PRIMARY KEY,
+		policy TEXT NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`
+
+	if _, err := db.Exec(createUploadPoliciesTable); err != nil {
+		return err
+	}
+
+	createDeploymentArtifactsTable := `
+	CREATE TABLE IF NOT EXISTS deployment_artifacts (
+		deployment_id TEXT PRIMARY KEY,
+		store TEXT NOT NULL,
+		key TEXT NOT NULL,
+		format TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		sha256 TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	)`
+
+	if _, err := db.Exec(createDeploymentArtifactsTable); err != nil {
+		return err
+	}
+
+	createDeploymentFilesTable := `
+	CREATE TABLE IF NOT EXISTS deployment_files (
+		deployment_id TEXT NOT NULL,
+		path TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		sha256 TEXT NOT NULL,
+		PRIMARY KEY (deployment_id, path)
+	)`
+
+	if _, err := db.Exec(createDeploymentFilesTable); err != nil {
+		return err
+	}
+
+	createIntegrityReportsTable := `
+	CREATE TABLE IF NOT EXISTS integrity_reports (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		started_at DATETIME NOT NULL,
+		problems INTEGER NOT NULL,
+		report TEXT NOT NULL
+	)`
+
+	if _, err := db.Exec(createIntegrityReportsTable); err != nil {
+		return err
+	}
+
+	createUsersTable := `
+	CREATE TABLE IF NOT EXISTS users (
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL UNIQUE,
+		email TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL DEFAULT '',
+		role TEXT NOT NULL,
+		tenant TEXT NOT NULL DEFAULT '',
+		password_hash TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	)`
+
+	if _, err := db.Exec(createUsersTable); err != nil {
+		return err
+	}
+
+	createUserIdentitiesTable := `
+	CREATE TABLE IF NOT EXISTS user_identities (
+		provider TEXT NOT NULL,
+		external_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		login TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (provider, external_id)
+	)`
+
+	if _, err := db.Exec(createUserIdentitiesTable); err != nil {
+		return err
+	}
+
+	createAPITokensTable := `
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id TEXT PRIMARY KEY,
+		owner_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		rotated_at DATETIME,
+		revoked_at DATETIME,
+		token_hash TEXT NOT NULL DEFAULT ''
+	)`
+
+	if _, err := db.Exec(createAPITokensTable); err != nil {
+		return err
+	}
+
+	createAPITokenUsageTable := `
+	CREATE TABLE IF NOT EXISTS api_token_usage (
+		token_id TEXT PRIMARY KEY,
+		requests INTEGER NOT NULL DEFAULT 0,
+		errors INTEGER NOT NULL DEFAULT 0,
+		bytes_uploaded INTEGER NOT NULL DEFAULT 0,
+		last_used_at DATETIME
+	)`
+
+	if _, err := db.Exec(createAPITokenUsageTable); err != nil {
+		return err
+	}
+
+	createUploadSessionsTable := `
+	CREATE TABLE IF NOT EXISTS upload_sessions (
+		id TEXT PRIMARY KEY,
+		spool_dir TEXT NOT NULL,
+		owner_id TEXT NOT NULL DEFAULT '',
+		length INTEGER NOT NULL DEFAULT -1,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`
+
+	if _, err := db.Exec(createUploadSessionsTable); err != nil {
+		return err
+	}
+
+	createUploadChunksTable := `
+	CREATE TABLE IF NOT EXISTS upload_chunks (
+		upload_id TEXT NOT NULL,
+		chunk INTEGER NOT NULL,
+		size INTEGER NOT NULL,
+		PRIMARY KEY (upload_id, chunk)
+	)`
+
+	if _, err := db.Exec(createUploadChunksTable); err != nil {
+		return err
+	}
+
+	// Keeping the example table for now
+	createExampleTable := `
+	CREATE TABLE IF NOT EXISTS example (
+		id INTEGER PRIMARY KEY, 
+		name TEXT
+	)`
+
+	if _, err := db.Exec(createExampleTable); err != nil {
+		return err
+	}
+
+	return addMissingColumns(db)
+}
+
+// addedColumns are the columns added to tables after databases had been
+// created without them. CREATE TABLE IF NOT EXISTS leaves an existing
+// table as it is, so each is added when missing. Added columns need a
+// default to fill the rows already there.
+var addedColumns = []struct{ table, column, definition string }{
+	{"deployments", "filename", "TEXT NOT NULL DEFAULT ''"},
+	{"deployments", "site", "TEXT NOT NULL DEFAULT ''"},
+	{"deployments", "archive_sha256", "TEXT NOT NULL DEFAULT ''"},
+	{"deployments", "status", "TEXT NOT NULL DEFAULT 'ready'"},
+	{"deployments", "owner_id", "TEXT NOT NULL DEFAULT ''"},
+	{"deployments", "deployed_by", "TEXT NOT NULL DEFAULT ''"},
+	{"deployments", "visibility", "TEXT NOT NULL DEFAULT 'public'"},
+	{"webhooks", "owner_id", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// addMissingColumns brings tables of a database made by an earlier version
+// up to date with addedColumns
+func addMissingColumns(db *sql.DB) error {
+	for _, c := range addedColumns {
+		var n int
+		err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", c.table, c.column).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE " + c.table + " ADD COLUMN " + c.column + " " + c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// prefix marks a sealed value; anything else is legacy plaintext
+const prefix = "enc:v1:"
+
+// ErrNoKey is returned when opening a sealed value without a key for it
+var ErrNoKey = errors.New("secret is encrypted with a key that is not configured")
+
+// Keyring encrypts credentials stored in the database, so a copy of the
+// SQLite file alone doesn't reveal them. Values are sealed with AES-256-GCM
+// under the current key and tagged with its ID, so values sealed with an
+// older key stay readable while they are rotated onto the new one.
+type Keyring struct {
+	currentID string
+	keys      map[string]cipher.AEAD
+}
+
+// Default seals secrets stored by handlers; nil stores them in plaintext
+var Default *Keyring
+
+// ParseKey decodes a 32-byte key given as base64 or hex
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("key must be 32 bytes, base64 or hex encoded")
+}
+
+// NewKeyring seals with current; previous keys are kept for opening values
+// sealed before a rotation
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{keys: map[string]cipher.AEAD{}}
+	for i, key := range append([][]byte{current}, previous...) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		id := KeyID(key)
+		if i == 0 {
+			k.currentID = id
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// KeyID identifies a key without revealing it
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// Seal encrypts plaintext with the current key. A nil keyring returns it
+// unchanged.
+func (k *Keyring) Seal(plaintext string) (string, error) {
+	if k == nil || plaintext == "" {
+		return plaintext, nil
+	}
+	aead := k.keys[k.currentID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return prefix + k.currentID + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a stored value. Plaintext stored before encryption was
+// enabled is returned as is.
+func (k *Keyring) Open(stored string) (string, error) {
+	if !strings.HasPrefix(stored, prefix) {
+		return stored, nil
+	}
+	id, data, ok := strings.Cut(strings.TrimPrefix(stored, prefix), ":")
+	if !ok {
+		return "", errors.New("malformed encrypted secret")
+	}
+	if k == nil || k.keys[id] == nil {
+		return "", fmt.Errorf("%w (key %s)", ErrNoKey, id)
+	}
+	aead := k.keys[id]
+	sealed, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted secret")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypting secret with key %s: %w", id, err)
+	}
+	return string(plaintext), nil
+}
+
+// ID returns the ID of the key new values are sealed with
+func (k *Keyring) ID() string {
+	return k.currentID
+}
+
+// current reports whether stored is already sealed with the current key
+func (k *Keyring) current(stored string) bool {
+	return strings.HasPrefix(stored, prefix+k.currentID+":")
+}
+
+// Seal encrypts plaintext with the Default keyring
+func Seal(plaintext string) (string, error) { return Default.Seal(plaintext) }
+
+// Open decrypts a stored value with the Default keyring
+func Open(stored string) (string, error) { return Default.Open(stored) }
+
+// Column is a database column holding secrets, addressed by its table's
+// primary key
+type Column struct {
+	Table string
+	Key   string
+	Name  string
+}
+
+// Columns lists every column Rotate re-encrypts
+var Columns = []Column{
+	{Table: "webhooks", Key: "id", Name: "secret"},
+	{Table: "webhook_secrets", Key: "id", Name: "secret"},
+	{Table: "totp_secrets", Key: "subject", Name: "secret"},
+}
+
+// Rotate re-seals every secret in Columns that isn't sealed with k's current
+// key: plaintext from before encryption was enabled, and values sealed with
+// a previous key. It returns how many values were rewritten; on error
+// nothing is.
+func Rotate(db *sql.DB, k *Keyring) (int, error) {
+	if k == nil {
+		return 0, errors.New("no encryption key configured")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rotated := 0
+	for _, col := range Columns {
+		rows, err := tx.Query(fmt.Sprintf("SELECT %s, %s FROM %s", col.Key, col.Name, col.Table))
+		if err != nil {
+			return 0, err
+		}
+		stale := map[string]string{}
+		for rows.Next() {
+			var id, stored string
+			if err := rows.Scan(&id, &stored); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			if stored != "" && !k.current(stored) {
+				stale[id] = stored
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+
+		for id, stored := range stale {
+			plaintext, err := k.Open(stored)
+			if err != nil {
+				return 0, fmt.Errorf("%s.%s %s: %w", col.Table, col.Name, id, err)
+			}
+			sealed, err := k.Seal(plaintext)
+			if err != nil {
+				return 0, err
+			}
+			update := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?", col.Table, col.Name, col.Key)
+			if _, err := tx.Exec(update, sealed, id); err != nil {
+				return 0, err
+			}
+			rotated++
+		}
+	}
+	return rotated, tx.Commit()
+}
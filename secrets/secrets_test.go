@@ -0,0 +1,114 @@
+package secrets
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func testKey(b byte) []byte { return bytes.Repeat([]byte{b}, 32) }
+
+func TestSealAndOpen(t *testing.T) {
+	k, err := NewKeyring(testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := k.Seal("shh")
+	if err != nil || !strings.HasPrefix(sealed, "enc:v1:"+KeyID(testKey(1))+":") || strings.Contains(sealed, "shh") {
+		t.Fatalf("expected a sealed value, got %q, %v", sealed, err)
+	}
+	if again, _ := k.Seal("shh"); again == sealed {
+		t.Error("expected a fresh nonce for every seal")
+	}
+	if got, err := k.Open(sealed); err != nil || got != "shh" {
+		t.Errorf("expected shh, got %q, %v", got, err)
+	}
+	if got, err := k.Open("legacy"); err != nil || got != "legacy" {
+		t.Errorf("expected plaintext to pass through, got %q, %v", got, err)
+	}
+
+	other, _ := NewKeyring(testKey(2))
+	if _, err := other.Open(sealed); !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected ErrNoKey opening with another key, got %v", err)
+	}
+	var none *Keyring
+	if _, err := none.Open(sealed); !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected ErrNoKey without a keyring, got %v", err)
+	}
+	if got, _ := none.Seal("shh"); got != "shh" {
+		t.Errorf("expected a nil keyring to store plaintext, got %q", got)
+	}
+
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if _, err := k.Open(tampered); err == nil {
+		t.Error("expected tampered ciphertext to be rejected")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	if _, err := ParseKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="); err != nil {
+		t.Errorf("expected a base64 key to parse: %v", err)
+	}
+	if _, err := ParseKey(strings.Repeat("ab", 32)); err != nil {
+		t.Errorf("expected a hex key to parse: %v", err)
+	}
+	if _, err := ParseKey("too-short"); err == nil {
+		t.Error("expected a short key to be rejected")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	db.Exec("CREATE TABLE webhooks (id TEXT PRIMARY KEY, secret TEXT NOT NULL)")
+	db.Exec("CREATE TABLE webhook_secrets (id TEXT PRIMARY KEY, secret TEXT NOT NULL)")
+	db.Exec("CREATE TABLE totp_secrets (subject TEXT PRIMARY KEY, secret TEXT NOT NULL)")
+
+	oldKeys, _ := NewKeyring(testKey(1))
+	oldSealed, _ := oldKeys.Seal("old")
+	db.Exec("INSERT INTO webhooks (id, secret) VALUES ('a', ?), ('b', 'plain'), ('c', '')", oldSealed)
+
+	if _, err := Rotate(db, nil); err == nil {
+		t.Error("expected rotation without a key to fail")
+	}
+
+	k, _ := NewKeyring(testKey(2), testKey(1))
+	n, err := Rotate(db, k)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 secrets rotated, got %d, %v", n, err)
+	}
+	for id, want := range map[string]string{"a": "old", "b": "plain"} {
+		var stored string
+		db.QueryRow("SELECT secret FROM webhooks WHERE id = ?", id).Scan(&stored)
+		if !k.current(stored) {
+			t.Errorf("%s: expected the current key, got %q", id, stored)
+		}
+		if got, _ := k.Open(stored); got != want {
+			t.Errorf("%s: expected %q, got %q", id, want, got)
+		}
+	}
+
+	if n, _ := Rotate(db, k); n != 0 {
+		t.Errorf("expected a second rotation to be a no-op, rotated %d", n)
+	}
+
+	// Without the old key a rotation fails and changes nothing
+	db.Exec("UPDATE webhooks SET secret = ? WHERE id = 'b'", oldSealed)
+	newOnly, _ := NewKeyring(testKey(3))
+	if _, err := Rotate(db, newOnly); !errors.Is(err, ErrNoKey) {
+		t.Errorf("expected ErrNoKey, got %v", err)
+	}
+	var stored string
+	db.QueryRow("SELECT secret FROM webhooks WHERE id = 'a'").Scan(&stored)
+	if !k.current(stored) {
+		t.Error("expected a failed rotation to roll back")
+	}
+}
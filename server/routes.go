@@ -0,0 +1,384 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/config"
+	"static-site-hosting/handlers"
+	"static-site-hosting/ratelimit"
+)
+
+// routes registers every endpoint of the API on a new mux
+func routes(o Options) *http.ServeMux {
+	db, cfg, recorder := o.DB, o.Config, o.Metrics
+	mux := http.NewServeMux()
+
+	// API endpoints
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		handlers.UploadHandler(w, r, db)
+	})
+	mux.HandleFunc("/deploy/url", func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeployURLHandler(w, r, db)
+	})
+
+	// Handle both list (GET) and delete all (DELETE) on /deployments
+	mux.HandleFunc("/deployments", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			handlers.ListDeploymentsHandler(w, r, db)
+		case http.MethodDelete:
+			handlers.DeleteAllDeploymentsHandler(w, r, db)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Deployment IDs may be chosen by clients, so requests are told apart
+	// by the path segments after the ID, never by matching within the path
+	mux.HandleFunc("/deployments/", func(w http.ResponseWriter, r *http.Request) {
+		id, rest := splitID(r.URL.Path, "/deployments/")
+		switch {
+		case id == "expiring" && rest == "" && r.Method == http.MethodGet:
+			handlers.ExpiringDeploymentsHandler(w, r, db)
+		case rest == "pin":
+			handlers.PinDeploymentHandler(w, r, db)
+		case rest == "password":
+			handlers.DeploymentPasswordHandler(w, r, db)
+		case rest == "share":
+			handlers.ShareDeploymentHandler(w, r, db)
+		case strings.HasPrefix(rest, "files/"):
+			handlers.DeploymentFileHandler(w, r, db)
+		case rest == "files":
+			handlers.DeploymentFilesHandler(w, r, db)
+		case rest == "artifact", strings.HasPrefix(rest, "artifact/"):
+			handlers.DeploymentArtifactHandler(w, r, db)
+		case rest == "diff":
+			handlers.DeploymentDiffHandler(w, r, db)
+		case rest == "report":
+			handlers.DeploymentReportHandler(w, r, db)
+		case rest != "":
+			http.NotFound(w, r)
+		case r.Method == http.MethodPatch:
+			handlers.DeploymentVisibilityHandler(w, r, db)
+		default:
+			handlers.DeleteDeploymentHandler(w, r, db)
+		}
+	})
+	mux.HandleFunc("/uploads", func(w http.ResponseWriter, r *http.Request) {
+		handlers.CreateUploadHandler(w, r, db)
+	})
+	mux.HandleFunc("/uploads/", func(w http.ResponseWriter, r *http.Request) {
+		_, rest := splitID(r.URL.Path, "/uploads/")
+		switch {
+		case strings.HasPrefix(rest, "chunks/"):
+			handlers.UploadChunkHandler(w, r, db)
+		case rest == "complete":
+			handlers.CompleteUploadHandler(w, r, db)
+		case rest == "progress":
+			handlers.UploadProgressHandler(w, r)
+		case rest != "":
+			http.NotFound(w, r)
+		case r.Method == http.MethodPatch:
+			handlers.UploadPatchHandler(w, r, db)
+		default:
+			handlers.UploadSessionHandler(w, r, db)
+		}
+	})
+	mux.HandleFunc("/rollback/", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RollbackHandler(w, r, db)
+	})
+	mux.HandleFunc("/reset", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ResetSystemHandler(w, r, db)
+	})
+	mux.HandleFunc("/sites", func(w http.ResponseWriter, r *http.Request) {
+		handlers.SitesHandler(w, r, db)
+	})
+	mux.HandleFunc("/sites/", func(w http.ResponseWriter, r *http.Request) {
+		slug, rest := splitID(r.URL.Path, "/sites/")
+		switch {
+		case slug == "import" && rest == "":
+			handlers.SiteImportHandler(w, r, db)
+		case rest == "export":
+			handlers.SiteExportHandler(w, r, db)
+		case rest == "deployments":
+			handlers.SiteDeploymentsHandler(w, r, db)
+		case rest == "manifest.json":
+			handlers.SiteManifestHandler(w, r, db)
+		case rest == "migrate":
+			handlers.SiteMigrateHandler(w, r, db)
+		case rest == "expiry":
+			handlers.SiteExpiryHandler(w, r, db)
+		default:
+			handlers.SiteSettingsHandler(w, r, db)
+		}
+	})
+	mux.HandleFunc("/orgs", func(w http.ResponseWriter, r *http.Request) {
+		handlers.OrgsHandler(w, r, db)
+	})
+	mux.HandleFunc("/orgs/", func(w http.ResponseWriter, r *http.Request) {
+		handlers.OrgHandler(w, r, db)
+	})
+	mux.HandleFunc("/dav/", func(w http.ResponseWriter, r *http.Request) {
+		handlers.WebDAVHandler(w, r, db)
+	})
+	mux.HandleFunc("/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		handlers.WebhooksHandler(w, r, db)
+	})
+	mux.HandleFunc("/webhooks/", func(w http.ResponseWriter, r *http.Request) {
+		handlers.WebhookHandler(w, r, db)
+	})
+	mux.HandleFunc("/api/v1/info", handlers.InfoHandler)
+	mux.HandleFunc("/admin/features", handlers.FeaturesHandler)
+	mux.HandleFunc("/admin/features/", handlers.FeatureHandler)
+	mux.HandleFunc("/admin/tenants/", func(w http.ResponseWriter, r *http.Request) {
+		handlers.TenantUploadPolicyHandler(w, r, db)
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		handlers.MetricsHandler(w, r, recorder)
+	})
+	mux.HandleFunc("/admin/slo", func(w http.ResponseWriter, r *http.Request) {
+		handlers.SLOHandler(w, r, recorder)
+	})
+	mux.HandleFunc("/admin/billing/usage", func(w http.ResponseWriter, r *http.Request) {
+		handlers.BillingUsageHandler(w, r, db)
+	})
+	mux.HandleFunc("/admin/events", func(w http.ResponseWriter, r *http.Request) {
+		handlers.EventsHandler(w, r, db)
+	})
+	mux.HandleFunc("/admin/events/replay", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ReplayEventsHandler(w, r, db)
+	})
+	mux.HandleFunc("/admin/integrity", func(w http.ResponseWriter, r *http.Request) {
+		handlers.IntegrityHandler(w, r, db)
+	})
+
+	// Static file serving under its own prefix, so no site can shadow an API
+	// route. A dedicated host serves sites at its root and nothing else.
+	static := handlers.StaticFileHandler(db)
+	mux.Handle(staticPrefix, http.StripPrefix(strings.TrimSuffix(staticPrefix, "/"), static))
+	if cfg.StaticHost != "" {
+		mux.Handle(cfg.StaticHost+"/", static)
+	}
+	// The legacy catch-all only sees paths no API route claims
+	if cfg.StaticRootServing {
+		mux.Handle("/", static)
+	}
+
+	authRoutes(mux, db, cfg, o.Signer, o.Throttle)
+	return mux
+}
+
+// splitID splits a path under prefix into the ID it starts with and what
+// follows the ID, without the slash between them
+func splitID(path, prefix string) (id, rest string) {
+	id, rest, _ = strings.Cut(strings.TrimPrefix(path, prefix), "/")
+	return id, rest
+}
+
+// staticPrefix is the path prefix sites are served under
+const staticPrefix = "/s/"
+
+// requestClass labels requests routed to static file serving as "static"
+func requestClass(mux *http.ServeMux) func(*http.Request) string {
+	return func(r *http.Request) string {
+		if handlers.IsSiteHost(r) {
+			return "static"
+		}
+		// The only host-specific pattern is the static host
+		_, pattern := mux.Handler(r)
+		if pattern == "/" || pattern == staticPrefix || !strings.HasPrefix(pattern, "/") {
+			return "static"
+		}
+		return "api"
+	}
+}
+
+// needsDatabase reports whether a request is answered from the database, so
+// gets 503 during an outage. Sites, metrics and build info are served
+// without it.
+func needsDatabase(mux *http.ServeMux) func(*http.Request) bool {
+	class := requestClass(mux)
+	return func(r *http.Request) bool {
+		switch r.URL.Path {
+		case "/metrics", "/api/v1/info", "/auth/me":
+			return false
+		}
+		return class(r) != "static"
+	}
+}
+
+// rateLimit limits API requests per API token (deploy tokens count against
+// the token they were exchanged for), per user for sessions and per client
+// IP for anonymous callers. Static files aren't limited.
+func rateLimit(mux *http.ServeMux, cfg *config.Config, throttle *auth.Throttle) func(*http.Request) (*ratelimit.Limiter, string) {
+	signedIn := ratelimit.New(cfg.RateLimitPerMinute, cfg.RateLimitBurst)
+	anonymous := ratelimit.New(cfg.RateLimitAnonymousPerMinute, cfg.RateLimitBurst)
+	class := requestClass(mux)
+	return func(r *http.Request) (*ratelimit.Limiter, string) {
+		if class(r) == "static" {
+			return nil, ""
+		}
+		claims := auth.FromContext(r.Context())
+		switch {
+		case claims == nil:
+			return anonymous, throttle.IPKey(r)
+		case claims.TokenID != "":
+			return signedIn, "token:" + claims.TokenID
+		default:
+			return signedIn, "subject:" + claims.Subject
+		}
+	}
+}
+
+// requiredRole returns the least role allowed to make a request. Sites,
+// logins, metrics and build info are public; reads need a viewer and
+// changes a deployer, except that resetting, deleting every deployment and
+// anything under /admin need an admin.
+func requiredRole(mux *http.ServeMux) func(*http.Request) string {
+	class := requestClass(mux)
+	return func(r *http.Request) string {
+		path := r.URL.Path
+		switch {
+		case class(r) == "static", strings.HasPrefix(path, "/auth/"), path == "/metrics", path == "/api/v1/info":
+			return ""
+		case path == "/reset", path == "/deployments" && r.Method == http.MethodDelete, strings.HasPrefix(path, "/admin/"):
+			return auth.RoleAdmin
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+			return auth.RoleViewer
+		}
+		return auth.RoleDeployer
+	}
+}
+
+// destructiveAdmin reports whether r is an admin operation that destroys
+// state: resetting the system, deleting every deployment, replaying the
+// event log and deletions under /admin. They need a second factor.
+func destructiveAdmin(r *http.Request) bool {
+	path := r.URL.Path
+	switch {
+	case path == "/reset", path == "/deployments" && r.Method == http.MethodDelete, path == "/admin/events/replay":
+		return true
+	}
+	return strings.HasPrefix(path, "/admin/") && r.Method == http.MethodDelete
+}
+
+// requiredScope is the scope API tokens need for each request: the one
+// matching the role it requires, except that deletions need deploy:delete,
+// so upload tokens can't remove anything, and uploads need only
+// deploy:upload
+func requiredScope(mux *http.ServeMux) func(*http.Request) string {
+	role := requiredRole(mux)
+	return func(r *http.Request) string {
+		switch role(r) {
+		case "":
+			return ""
+		case auth.RoleAdmin:
+			return auth.ScopeAdmin
+		case auth.RoleViewer:
+			return auth.ScopeDeployRead
+		}
+		switch {
+		case r.Method == http.MethodDelete:
+			return auth.ScopeDeployDelete
+		case r.URL.Path == "/upload", r.URL.Path == "/deploy/url", r.URL.Path == "/uploads", strings.HasPrefix(r.URL.Path, "/uploads/"),
+			strings.HasPrefix(r.URL.Path, "/sites/") && strings.HasSuffix(r.URL.Path, "/deployments"):
+			return auth.ScopeDeployUpload
+		}
+		return auth.ScopeDeployWrite
+	}
+}
+
+// authRoutes registers login endpoints for local accounts and the
+// configured identity providers
+func authRoutes(mux *http.ServeMux, db *sql.DB, cfg *config.Config, signer *auth.Signer, throttle *auth.Throttle) {
+	mux.HandleFunc("/auth/me", handlers.MeHandler)
+	mux.HandleFunc("/auth/register", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RegisterHandler(w, r, db, signer)
+	})
+	mux.HandleFunc("/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		handlers.LoginHandler(w, r, db, signer, throttle)
+	})
+	mux.HandleFunc("/auth/logout", handlers.LogoutHandler)
+	mux.HandleFunc("/auth/tokens", func(w http.ResponseWriter, r *http.Request) {
+		handlers.TokensHandler(w, r, db, signer)
+	})
+	mux.HandleFunc("/auth/tokens/", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/rotate"):
+			handlers.RotateTokenHandler(w, r, db, signer)
+		case strings.HasSuffix(r.URL.Path, "/usage"):
+			handlers.TokenUsageHandler(w, r, db)
+		default:
+			handlers.RevokeTokenHandler(w, r, db)
+		}
+	})
+	mux.HandleFunc("/auth/tokens/exchange", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ExchangeTokenHandler(w, r, db, signer)
+	})
+	mux.HandleFunc("/auth/2fa", func(w http.ResponseWriter, r *http.Request) {
+		handlers.TwoFactorHandler(w, r, db)
+	})
+	mux.HandleFunc("/auth/2fa/", func(w http.ResponseWriter, r *http.Request) {
+		handlers.TwoFactorHandler(w, r, db)
+	})
+	mux.HandleFunc("/admin/service-accounts", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ServiceAccountsHandler(w, r, db, signer)
+	})
+	mux.HandleFunc("/admin/service-accounts/", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ServiceAccountHandler(w, r, db, signer)
+	})
+
+	if cfg.OIDCIssuer != "" {
+		provider := auth.NewOIDCProvider(auth.OIDCConfig{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       cfg.OIDCScopes,
+			GroupsClaim:  cfg.OIDCGroupsClaim,
+			TenantClaim:  cfg.OIDCTenantClaim,
+		})
+		mux.HandleFunc("/auth/oidc/login", func(w http.ResponseWriter, r *http.Request) {
+			handlers.OIDCLoginHandler(w, r, provider, signer)
+		})
+		mux.HandleFunc("/auth/oidc/callback", func(w http.ResponseWriter, r *http.Request) {
+			handlers.OIDCCallbackHandler(w, r, provider, signer, throttle)
+		})
+	}
+
+	if cfg.GitHubClientID != "" {
+		provider := auth.NewGitHubProvider(auth.GitHubConfig{
+			ClientID:     cfg.GitHubClientID,
+			ClientSecret: cfg.GitHubClientSecret,
+			RedirectURL:  cfg.GitHubRedirectURL,
+			BaseURL:      cfg.GitHubURL,
+			APIURL:       cfg.GitHubAPIURL,
+			Orgs:         cfg.GitHubAllowedOrgs,
+		})
+		mux.HandleFunc("/auth/github", func(w http.ResponseWriter, r *http.Request) {
+			handlers.GitHubLoginHandler(w, r, provider, signer)
+		})
+		mux.HandleFunc("/auth/github/callback", func(w http.ResponseWriter, r *http.Request) {
+			handlers.GitHubCallbackHandler(w, r, db, provider, signer, throttle)
+		})
+	}
+
+	if cfg.LDAPURL != "" {
+		authenticator := auth.NewLDAPAuthenticator(auth.LDAPConfig{
+			URL:            cfg.LDAPURL,
+			BindDN:         cfg.LDAPBindDN,
+			BindPassword:   cfg.LDAPBindPassword,
+			UserBaseDN:     cfg.LDAPUserBaseDN,
+			UserFilter:     cfg.LDAPUserFilter,
+			GroupAttribute: cfg.LDAPGroupAttribute,
+		})
+		mux.HandleFunc("/auth/ldap/login", func(w http.ResponseWriter, r *http.Request) {
+			handlers.LDAPLoginHandler(w, r, authenticator, signer, throttle)
+		})
+	}
+}
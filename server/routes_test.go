@@ -0,0 +1,51 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/config"
+	"static-site-hosting/schema"
+)
+
+func TestDeploymentRoutesDontMatchWithinIDs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	db, err := schema.OpenMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	mux := routes(Options{DB: db, Config: config.Default(), Signer: auth.NewSigner([]byte("secret"))}.withDefaults())
+	admin := &auth.Claims{Subject: "user:admin", Role: auth.RoleAdmin}
+
+	// Client-supplied IDs may start with, or be, the name of a sub-resource
+	for _, id := range []string{"artifact-1", "files", "files-2", "pin", "expiring"} {
+		dir := filepath.Join("deployments", id)
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, "index.html"), []byte("hi"), 0644)
+		if _, err := db.Exec("INSERT INTO deployments (id, filename, timestamp, path, status) VALUES (?, 'site.zip', ?, ?, 'active')", id, time.Now(), dir); err != nil {
+			t.Fatal(err)
+		}
+
+		serve := func(method, path string) int {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(method, path, nil)
+			mux.ServeHTTP(rr, req.WithContext(auth.WithClaims(req.Context(), admin)))
+			return rr.Code
+		}
+		if code := serve(http.MethodGet, "/deployments/"+id+"/files"); code != http.StatusOK {
+			t.Errorf("%s: expected its file manifest, got %d", id, code)
+		}
+		if code := serve(http.MethodGet, "/deployments/"+id+"/files/index.html"); code != http.StatusOK {
+			t.Errorf("%s: expected its index.html, got %d", id, code)
+		}
+		if code := serve(http.MethodDelete, "/deployments/"+id); code != http.StatusOK {
+			t.Errorf("%s: expected it to be deleted, got %d", id, code)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Package server builds the API's HTTP handler: its routes and the
+// middleware every request passes through. The server binary, testsupport
+// and the end-to-end tests all serve the same handler, so what is tested is
+// what runs.
+package server
+
+import (
+	"database/sql"
+	"net/http"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/config"
+	"static-site-hosting/handlers"
+	"static-site-hosting/i18n"
+	"static-site-hosting/metrics"
+	"static-site-hosting/middleware"
+	"static-site-hosting/routecache"
+)
+
+// Options are what the handler is built from. DB, Config and Signer are
+// required; the rest have defaults.
+type Options struct {
+	DB     *sql.DB
+	Config *config.Config
+	// Signer signs and verifies session and API tokens
+	Signer *auth.Signer
+	// Throttle locks out clients after failed logins; by default one
+	// following Config, kept by this node alone
+	Throttle *auth.Throttle
+	// Metrics records what /metrics and /admin/slo report
+	Metrics *metrics.Recorder
+	// Catalog translates API error messages; by default they are in English
+	Catalog *i18n.Catalog
+	// SessionActive reports whether a session hasn't expired or been logged
+	// out; without it sessions last as long as their token
+	SessionActive func(sessionID string) bool
+	// RecordTokenUse is told of every request made with an API token
+	RecordTokenUse func(tokenID string, uploaded int64, failed bool)
+}
+
+func (o Options) withDefaults() Options {
+	if o.Throttle == nil {
+		o.Throttle = auth.NewThrottle(o.Config.AuthMaxFailures, o.Config.AuthLockoutBase, o.Config.AuthLockoutMax)
+		o.Throttle.TrustProxy = o.Config.TrustProxyHeaders
+	}
+	if o.Metrics == nil {
+		o.Metrics = metrics.NewRecorder()
+	}
+	if o.Catalog == nil {
+		o.Catalog = i18n.New()
+	}
+	if o.RecordTokenUse == nil {
+		o.RecordTokenUse = func(string, int64, bool) {}
+	}
+	return o
+}
+
+// Handler returns the API: every route, behind tracing, logging, metrics,
+// compression, translation, authentication, rate limiting, authorization
+// and two-factor checks, in that order
+func Handler(o Options) http.Handler {
+	o = o.withDefaults()
+	db, cfg := o.DB, o.Config
+	mux := routes(o)
+
+	return middleware.TracingMiddleware(
+		middleware.LoggingMiddleware(
+			middleware.MetricsMiddleware(o.Metrics, requestClass(mux),
+				middleware.GzipMiddleware(cfg.APIGzipMinBytes, requestClass(mux),
+					middleware.LocalizeMiddleware(o.Catalog, requestClass(mux),
+						middleware.AvailabilityMiddleware(routecache.Available, needsDatabase(mux),
+							middleware.AuthMiddleware(o.Signer, o.Throttle, o.SessionActive,
+								middleware.TokenUsageMiddleware(o.RecordTokenUse,
+									middleware.RateLimitMiddleware(rateLimit(mux, cfg, o.Throttle),
+										middleware.AuthorizeMiddleware(requiredRole(mux), cfg.AnonymousRole,
+											middleware.ScopeMiddleware(requiredScope(mux), handlers.TokenRevoked(db),
+												middleware.TwoFactorMiddleware(destructiveAdmin, handlers.CheckTOTP(db), o.Throttle, cfg.Require2FA,
+													handlers.QuotaWarningHandler(requestClass(mux), handlers.SiteHostHandler(db, mux)),
+												),
+											),
+										),
+									),
+								),
+							),
+						),
+					),
+				),
+			),
+		),
+	)
+}
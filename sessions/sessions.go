@@ -0,0 +1,68 @@
+// Package sessions keeps browser sessions server-side. A session token is
+// still a signed JWT, but names a session recorded here, and is only good
+// while that record is: until it expires or its holder logs out.
+package sessions
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+// Session is a login from one browser or client
+type Session struct {
+	ID        string    `json:"id"`
+	Subject   string    `json:"subject"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Store records sessions in the database
+type Store struct {
+	db *sql.DB
+}
+
+// New returns a store keeping sessions in db
+func New(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Create records a session for subject lasting ttl. Expired sessions are
+// removed on the way.
+func (s *Store) Create(subject, userAgent string, ttl time.Duration) (Session, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Session{}, err
+	}
+	now := time.Now().UTC()
+	session := Session{ID: hex.EncodeToString(id), Subject: subject, UserAgent: userAgent, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+
+	if _, err := s.db.Exec("DELETE FROM sessions WHERE expires_at <= ?", now); err != nil {
+		log.Printf("Warning: Failed to remove expired sessions: %v", err)
+	}
+	_, err := s.db.Exec(
+		"INSERT INTO sessions (id, subject, user_agent, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		session.ID, session.Subject, session.UserAgent, session.CreatedAt, session.ExpiresAt,
+	)
+	return session, err
+}
+
+// Active reports whether session id exists and hasn't expired. A failed
+// lookup counts as inactive.
+func (s *Store) Active(id string) bool {
+	var expiresAt time.Time
+	err := s.db.QueryRow("SELECT expires_at FROM sessions WHERE id = ?", id).Scan(&expiresAt)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Warning: Failed to look up session: %v", err)
+	}
+	return err == nil && time.Now().Before(expiresAt)
+}
+
+// End removes session id, logging it out
+func (s *Store) End(id string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,64 @@
+package sessions
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func setupDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	_, err = db.Exec(`CREATE TABLE sessions (
+		id TEXT PRIMARY KEY,
+		subject TEXT NOT NULL,
+		user_agent TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create sessions table: %v", err)
+	}
+	return db
+}
+
+func TestStore(t *testing.T) {
+	db := setupDB(t)
+	defer db.Close()
+	store := New(db)
+
+	expired, err := store.Create("user:alice", "curl", -time.Minute)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	session, err := store.Create("user:alice", "curl", time.Hour)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if !store.Active(session.ID) {
+		t.Error("expected the new session to be active")
+	}
+	if store.Active(expired.ID) {
+		t.Error("expected the expired session to be inactive")
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&count)
+	if count != 1 {
+		t.Errorf("expected the expired session to be removed, got %d sessions", count)
+	}
+
+	if err := store.End(session.ID); err != nil {
+		t.Fatalf("End failed: %v", err)
+	}
+	if store.Active(session.ID) {
+		t.Error("expected the session to end")
+	}
+	if store.Active("unknown") {
+		t.Error("expected an unknown session to be inactive")
+	}
+}
@@ -0,0 +1,153 @@
+package shared
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Memory is a Store and Cache within a single process, for tests and for
+// sharing state between components of one node
+type Memory struct {
+	mu          sync.Mutex
+	entries     map[string]memoryEntry
+	subscribers map[string][]chan string
+	now         func() time.Time
+}
+
+type memoryEntry struct {
+	value   int64
+	data    []byte    // set by Save; read as a number by counters, like Redis strings
+	expires time.Time // zero for never
+}
+
+// number returns the entry as a counter
+func (e memoryEntry) number() int64 {
+	if e.data != nil {
+		n, _ := strconv.ParseInt(string(e.data), 10, 64)
+		return n
+	}
+	return e.value
+}
+
+// NewMemory returns an empty Memory store
+func NewMemory() *Memory {
+	return &Memory{entries: map[string]memoryEntry{}, subscribers: map[string][]chan string{}, now: time.Now}
+}
+
+// get returns the live entry for key; call with mu held
+func (m *Memory) get(key string) (memoryEntry, bool) {
+	e, ok := m.entries[key]
+	if ok && !e.expires.IsZero() && !m.now().Before(e.expires) {
+		delete(m.entries, key)
+		return memoryEntry{}, false
+	}
+	return e, ok
+}
+
+func (m *Memory) IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, _ := m.get(key)
+	e.value, e.data = e.number()+n, nil
+	if ttl > 0 {
+		e.expires = m.now().Add(ttl)
+	}
+	m.entries[key] = e
+	return e.value, nil
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, _ := m.get(key)
+	return e.number(), nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, v int64, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = memoryEntry{value: v, expires: m.now().Add(ttl)}
+	return nil
+}
+
+func (m *Memory) TTL(ctx context.Context, key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.get(key)
+	if !ok || e.expires.IsZero() {
+		return 0, nil
+	}
+	return e.expires.Sub(m.now()), nil
+}
+
+func (m *Memory) Del(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+func (m *Memory) Load(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.get(key)
+	if !ok {
+		return nil, false, nil
+	}
+	if e.data == nil {
+		return []byte(strconv.FormatInt(e.value, 10)), true, nil
+	}
+	return append([]byte(nil), e.data...), true, nil
+}
+
+func (m *Memory) Save(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = memoryEntry{data: append([]byte{}, value...), expires: m.now().Add(ttl)}
+	return nil
+}
+
+func (m *Memory) Publish(ctx context.Context, channel, message string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.subscribers[channel] {
+		select {
+		case ch <- message:
+		default:
+			// A subscriber that isn't keeping up misses messages, as it
+			// would with a Redis connection that dropped
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(ctx context.Context, channel string, handle func(message string)) error {
+	ch := make(chan string, 64)
+	m.mu.Lock()
+	m.subscribers[channel] = append(m.subscribers[channel], ch)
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		subs := m.subscribers[channel]
+		for i, c := range subs {
+			if c == ch {
+				m.subscribers[channel] = append(subs[:i:i], subs[i+1:]...)
+				break
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-ch:
+			handle(msg)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package shared
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redis is a Store and Cache in a Redis server, spoken to over RESP on a
+// single connection that is re-established after any error. Each
+// subscription has a connection of its own.
+type Redis struct {
+	Addr     string // host:port
+	Username string // for Redis 6 ACLs; empty uses the default user
+	Password string
+	DB       int
+	Prefix   string // prepended to every key
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// ParseRedisURL returns a Redis for a URL such as
+// redis://:password@host:6379/2. Keys are prefixed with prefix.
+func ParseRedisURL(raw, prefix string) (*Redis, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	r := &Redis{Addr: u.Host, Prefix: prefix}
+	if u.Port() == "" {
+		r.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.Username = u.User.Username()
+		r.Password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if r.DB, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid database %q", db)
+		}
+	}
+	return r, nil
+}
+
+func (r *Redis) IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	v, err := r.do(ctx, "INCRBY", r.Prefix+key, strconv.FormatInt(n, 10))
+	if err != nil {
+		return 0, err
+	}
+	if ttl > 0 {
+		if _, err := r.do(ctx, "PEXPIRE", r.Prefix+key, strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+			return 0, err
+		}
+	}
+	return toInt(v)
+}
+
+func (r *Redis) Get(ctx context.Context, key string) (int64, error) {
+	v, err := r.do(ctx, "GET", r.Prefix+key)
+	if err != nil || v == nil {
+		return 0, err
+	}
+	return toInt(v)
+}
+
+func (r *Redis) Set(ctx context.Context, key string, v int64, ttl time.Duration) error {
+	_, err := r.do(ctx, "SET", r.Prefix+key, strconv.FormatInt(v, 10), "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	return err
+}
+
+func (r *Redis) TTL(ctx context.Context, key string) (time.Duration, error) {
+	v, err := r.do(ctx, "PTTL", r.Prefix+key)
+	if err != nil {
+		return 0, err
+	}
+	ms, err := toInt(v)
+	if err != nil || ms < 0 {
+		// -2 for a missing key, -1 for one without expiry
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func (r *Redis) Del(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := []string{"DEL"}
+	for _, key := range keys {
+		args = append(args, r.Prefix+key)
+	}
+	_, err := r.do(ctx, args...)
+	return err
+}
+
+func (r *Redis) Load(ctx context.Context, key string) ([]byte, bool, error) {
+	v, err := r.do(ctx, "GET", r.Prefix+key)
+	if err != nil || v == nil {
+		return nil, false, err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected reply %v", v)
+	}
+	return []byte(s), true, nil
+}
+
+func (r *Redis) Save(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := r.do(ctx, "SET", r.Prefix+key, string(value), "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	return err
+}
+
+func (r *Redis) Publish(ctx context.Context, channel, message string) error {
+	_, err := r.do(ctx, "PUBLISH", r.Prefix+channel, message)
+	return err
+}
+
+// subscriptionPing is how often an idle subscription is pinged; a
+// connection silent for three times as long is taken for dead
+const subscriptionPing = 30 * time.Second
+
+func (r *Redis) Subscribe(ctx context.Context, channel string, handle func(message string)) error {
+	conn, rd, err := r.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetDeadline(time.Now().Add(Timeout))
+	if err := writeCommand(conn, []string{"SUBSCRIBE", r.Prefix + channel}); err != nil {
+		return err
+	}
+	conn.SetWriteDeadline(time.Time{})
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(subscriptionPing)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				writeCommand(conn, []string{"PING"})
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(3 * subscriptionPing))
+		v, err := readReply(rd)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		// Replies are ["subscribe", channel, count], ["pong", ""] and
+		// ["message", channel, payload]
+		if items, ok := v.([]any); ok && len(items) == 3 && items[0] == "message" {
+			if msg, ok := items[2].(string); ok {
+				handle(msg)
+			}
+		}
+	}
+}
+
+// Close closes the connection
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// do sends a command and returns its reply: a string, an int64, nil or a
+// []any
+func (r *Redis) do(ctx context.Context, args ...string) (any, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		conn, rd, err := r.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r.conn, r.rd = conn, rd
+	}
+	v, err := roundTrip(ctx, r.conn, r.rd, args)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection is in an unknown state
+		r.conn.Close()
+		r.conn = nil
+	}
+	return v, err
+}
+
+// dial opens a connection, authenticated and on the configured database
+func (r *Redis) dial(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", r.Addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	rd := bufio.NewReader(conn)
+
+	var setup [][]string
+	if r.Password != "" {
+		if r.Username != "" {
+			setup = append(setup, []string{"AUTH", r.Username, r.Password})
+		} else {
+			setup = append(setup, []string{"AUTH", r.Password})
+		}
+	}
+	if r.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(r.DB)})
+	}
+	for _, args := range setup {
+		if _, err := roundTrip(ctx, conn, rd, args); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	return conn, rd, nil
+}
+
+func roundTrip(ctx context.Context, conn net.Conn, rd *bufio.Reader, args []string) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(Timeout)
+	}
+	conn.SetDeadline(deadline)
+
+	if err := writeCommand(conn, args); err != nil {
+		return nil, err
+	}
+	return readReply(rd)
+}
+
+// writeCommand sends one command
+func writeCommand(conn net.Conn, args []string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(conn, b.String())
+	return err
+}
+
+// readReply reads one RESP reply
+func readReply(rd *bufio.Reader) (any, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(rd); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func toInt(v any) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, fmt.Errorf("redis: unexpected reply %v", v)
+}
@@ -0,0 +1,48 @@
+// Package shared keeps counters that every node of a multi-node deployment
+// must agree on, such as authentication lockouts and bandwidth served, in
+// a store they all reach. Without one each node counts on its own.
+package shared
+
+import (
+	"context"
+	"time"
+)
+
+// Store is a shared store of expiring integer counters
+type Store interface {
+	// IncrBy adds n to key and returns the new value. A ttl above zero
+	// (re)sets the key to expire after it.
+	IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+	// Get returns the value of key, or 0 if it doesn't exist
+	Get(ctx context.Context, key string) (int64, error)
+	// Set sets key to v, expiring after ttl
+	Set(ctx context.Context, key string, v int64, ttl time.Duration) error
+	// TTL returns how long key has left, or 0 if it doesn't exist or
+	// never expires
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	// Del deletes keys
+	Del(ctx context.Context, keys ...string) error
+}
+
+// Cache is a shared store of expiring values, with messages broadcast to
+// every node subscribed to a channel
+type Cache interface {
+	// Load returns the value of key and whether it exists
+	Load(ctx context.Context, key string) ([]byte, bool, error)
+	// Save sets key to value, expiring after ttl
+	Save(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Publish sends message to the subscribers of channel
+	Publish(ctx context.Context, channel, message string) error
+	// Subscribe calls handle with each message sent to channel until ctx
+	// is cancelled or the connection fails, and returns why it stopped
+	Subscribe(ctx context.Context, channel string, handle func(message string)) error
+}
+
+// Timeout bounds each operation, so a slow store delays requests by at
+// most this much before callers fall back to local state
+const Timeout = 500 * time.Millisecond
+
+// Context returns a context bounded by Timeout
+func Context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), Timeout)
+}
@@ -0,0 +1,190 @@
+// Package testsupport helps integrations and custom middleware test against
+// the API without a running server: an in-memory store with the server's
+// schema, a builder for the sites to deploy and a test server serving the
+// API from the store with the real server's routes and middleware.
+package testsupport
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/config"
+	"static-site-hosting/models"
+	"static-site-hosting/schema"
+	"static-site-hosting/server"
+)
+
+// NewStore returns an in-memory database with every table the server uses,
+// closed when the test ends
+func NewStore(t testing.TB) *sql.DB {
+	t.Helper()
+	db, err := schema.OpenMemory()
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Workdir moves the test into an empty temporary directory, where the
+// deployments it makes are written, and back when it ends. Like t.Chdir,
+// it can't be used in parallel tests.
+func Workdir(t testing.TB) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	return dir
+}
+
+// Site is the content of a site to deploy, by slash-separated path
+type Site map[string]string
+
+// Zip archives the site the way uploads expect it
+func (s Site) Zip() ([]byte, error) {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, name := range names {
+		f, err := w.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, s[name]); err != nil {
+			return nil, err
+		}
+	}
+	err := w.Close()
+	return buf.Bytes(), err
+}
+
+// Deploy writes the site into a new deployment directory and records it in
+// db without going through the API, as a deployment of site, or of no site
+// if it's empty. The deployment is written under the working directory, so
+// call Workdir first.
+func Deploy(t testing.TB, db *sql.DB, site string, files Site) models.Deployment {
+	t.Helper()
+	deployment := models.NewDeployment(uuid.New().String(), "site.zip", "")
+	deployment.Path = filepath.Join("deployments", deployment.ID)
+	deployment.Site = site
+
+	for name, content := range files {
+		target := filepath.Join(deployment.Path, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			t.Fatalf("Failed to create deployment directory: %v", err)
+		}
+		if err := os.WriteFile(target, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	_, err := db.Exec(
+		"INSERT INTO deployments (id, filename, timestamp, path, site, status) VALUES (?, ?, ?, ?, ?, ?)",
+		deployment.ID, deployment.Filename, deployment.Timestamp, deployment.Path, deployment.Site, deployment.Status,
+	)
+	if err != nil {
+		t.Fatalf("Failed to record deployment: %v", err)
+	}
+	return *deployment
+}
+
+// Upload deploys the site through POST /upload of the server at serverURL,
+// signed in with AsAdmin, as a deployment of site, or of no site if it's
+// empty
+func Upload(t testing.TB, serverURL, site string, files Site) models.Deployment {
+	t.Helper()
+	archive, err := files.Zip()
+	if err != nil {
+		t.Fatalf("Failed to create test site: %v", err)
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if site != "" {
+		writer.WriteField("site", site)
+	}
+	part, err := writer.CreateFormFile("file", "site.zip")
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	part.Write(archive)
+	writer.Close()
+
+	req, err := http.NewRequest(http.MethodPost, serverURL+"/upload", body)
+	if err != nil {
+		t.Fatalf("Failed to create upload request: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := http.DefaultClient.Do(AsAdmin(req))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		t.Fatalf("Upload failed with status %d: %s", resp.StatusCode, msg)
+	}
+
+	var deployment models.Deployment
+	if err := json.NewDecoder(resp.Body).Decode(&deployment); err != nil {
+		t.Fatalf("Failed to decode upload response: %v", err)
+	}
+	return deployment
+}
+
+// signer signs the credentials accepted by the servers NewServer starts
+var signer = auth.NewSigner([]byte("testsupport"))
+
+// adminClaims are those AsAdmin signs requests in with
+var adminClaims = auth.Claims{Subject: "user:admin", Role: auth.RoleAdmin}
+
+// AsAdmin signs r in as an admin: with a Bearer token the servers NewServer
+// starts accept, and with claims for handlers called directly
+func AsAdmin(r *http.Request) *http.Request {
+	token, err := signer.Issue(adminClaims, time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	claims := adminClaims
+	return r.WithContext(auth.WithClaims(r.Context(), &claims))
+}
+
+// NewServer starts a server for the API backed by db, with every route and
+// middleware of the real one and its default configuration: anonymous
+// callers only get sites, which are served under /s/, so sign requests in
+// with AsAdmin. Each middleware wraps the ones before it, so the last sees
+// requests first. The server is closed when the test ends.
+func NewServer(t testing.TB, db *sql.DB, middleware ...func(http.Handler) http.Handler) *httptest.Server {
+	t.Helper()
+	handler := Routes(db)
+	for _, m := range middleware {
+		handler = m(handler)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Routes returns the handler NewServer serves, for tests that call it
+// directly
+func Routes(db *sql.DB) http.Handler {
+	return server.Handler(server.Options{DB: db, Config: config.Default(), Signer: signer})
+}
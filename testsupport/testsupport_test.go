@@ -0,0 +1,100 @@
+package testsupport
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"static-site-hosting/models"
+)
+
+func TestServer(t *testing.T) {
+	Workdir(t)
+	db := NewStore(t)
+
+	var seen []string
+	record := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = append(seen, r.Method+" "+r.URL.Path)
+			next.ServeHTTP(w, r)
+		})
+	}
+	server := NewServer(t, db, record)
+
+	uploaded := Upload(t, server.URL, "docs", Site{"index.html": "<h1>Docs</h1>", "css/site.css": "body {}"})
+	direct := Deploy(t, db, "", Site{"index.html": "direct"})
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/deployments", nil)
+	resp, err := http.DefaultClient.Do(AsAdmin(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []models.Deployment
+	json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if len(list) != 2 {
+		t.Fatalf("expected both deployments to be listed, got %+v", list)
+	}
+
+	for id, want := range map[string]string{uploaded.ID + "/css/site.css": "body {}", direct.ID + "/index.html": "direct"} {
+		resp, err := http.Get(server.URL + "/s/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != want {
+			t.Errorf("%s: expected %q, got %d %q", id, want, resp.StatusCode, body)
+		}
+	}
+	if len(seen) != 4 || seen[0] != "POST /upload" {
+		t.Errorf("expected the middleware to see every request, got %v", seen)
+	}
+}
+
+func TestServerHasTheRealMiddleware(t *testing.T) {
+	Workdir(t)
+	db := NewStore(t)
+	server := NewServer(t, db)
+	d := Deploy(t, db, "", Site{"index.html": "hello"})
+
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/deployments"},
+		{http.MethodDelete, "/deployments"},
+		{http.MethodDelete, "/deployments/" + d.ID},
+		{http.MethodPost, "/reset"},
+		{http.MethodGet, "/admin/features"},
+	} {
+		req, _ := http.NewRequest(tc.method, server.URL+tc.path, nil)
+		req.Header.Set("X-Confirm", "yes")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("anonymous %s %s: expected 401, got %d", tc.method, tc.path, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/s/" + d.ID + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Errorf("expected anonymous callers to get sites, got %d %q", resp.StatusCode, body)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/admin/features", nil)
+	resp, err = http.DefaultClient.Do(AsAdmin(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected an admin to get /admin/features, got %d", resp.StatusCode)
+	}
+}
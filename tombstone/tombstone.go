@@ -0,0 +1,96 @@
+// Package tombstone keeps deleted deployments from being served. Static
+// serving falls back to looking for a deployment's files under its ID when
+// there is no record of it, which would otherwise serve a deleted
+// deployment for as long as its files outlive the record: while removal
+// is in progress, or for good if it fails.
+package tombstone
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"static-site-hosting/routecache"
+)
+
+// frozen holds the deployments deleted by this process, so they stop
+// being served at once, even from routing lookups cached before the
+// deletion while the database is unavailable
+var frozen = struct {
+	sync.RWMutex
+	ids map[string]bool
+}{ids: map[string]bool{}}
+
+// Add records deployments as deleted. Call it before deleting their
+// records, so there is no moment where neither says what to serve.
+func Add(db *sql.DB, ids ...string) error {
+	frozen.Lock()
+	for _, id := range ids {
+		frozen.ids[id] = true
+	}
+	frozen.Unlock()
+	routecache.Invalidate()
+
+	now := time.Now().UTC()
+	for _, id := range ids {
+		if _, err := db.Exec("INSERT OR REPLACE INTO deployment_tombstones (deployment_id, deleted_at) VALUES (?, ?)", id, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddAll is Add for every deployment, for bulk deletion and resets. It
+// returns the IDs it froze, to lift if the deletion goes no further.
+func AddAll(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT id FROM deployments")
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, Add(db, ids...)
+}
+
+// Remove lifts the tombstone of a deployment ID in use again: after a
+// deletion that failed, or a redeploy reusing a content-derived ID
+func Remove(db *sql.DB, id string) {
+	frozen.Lock()
+	delete(frozen.ids, id)
+	frozen.Unlock()
+	db.Exec("DELETE FROM deployment_tombstones WHERE deployment_id = ?", id)
+	routecache.Invalidate()
+}
+
+// RemoveAll is Remove for each of ids
+func RemoveAll(db *sql.DB, ids []string) {
+	for _, id := range ids {
+		Remove(db, id)
+	}
+}
+
+// Frozen reports whether this process deleted id
+func Frozen(id string) bool {
+	frozen.RLock()
+	defer frozen.RUnlock()
+	return frozen.ids[id]
+}
+
+// Deleted reports whether id has a tombstone, for deployments deleted by
+// another server or before a restart
+func Deleted(db *sql.DB, id string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM deployment_tombstones WHERE deployment_id = ?", id).Scan(&count)
+	return count > 0, err
+}
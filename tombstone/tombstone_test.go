@@ -0,0 +1,67 @@
+package tombstone
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{
+		`CREATE TABLE deployments (id TEXT PRIMARY KEY)`,
+		`CREATE TABLE deployment_tombstones (deployment_id TEXT PRIMARY KEY, deleted_at DATETIME NOT NULL)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+	}
+	return db
+}
+
+func TestAddAndRemove(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := Add(db, "tomb-a"); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if !Frozen("tomb-a") {
+		t.Error("expected tomb-a to be frozen")
+	}
+	if deleted, err := Deleted(db, "tomb-a"); err != nil || !deleted {
+		t.Errorf("expected a recorded tombstone, got %v, %v", deleted, err)
+	}
+
+	Remove(db, "tomb-a")
+	if Frozen("tomb-a") {
+		t.Error("expected tomb-a to be unfrozen after Remove")
+	}
+	if deleted, _ := Deleted(db, "tomb-a"); deleted {
+		t.Error("expected the tombstone to be gone after Remove")
+	}
+}
+
+func TestAddAll(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	db.Exec("INSERT INTO deployments (id) VALUES ('tomb-b'), ('tomb-c')")
+
+	ids, err := AddAll(db)
+	if err != nil {
+		t.Fatalf("AddAll failed: %v", err)
+	}
+	if len(ids) != 2 || !Frozen("tomb-b") || !Frozen("tomb-c") {
+		t.Errorf("expected every deployment to be frozen, got %v", ids)
+	}
+
+	RemoveAll(db, ids)
+	if Frozen("tomb-b") || Frozen("tomb-c") {
+		t.Error("expected RemoveAll to lift every tombstone")
+	}
+}
@@ -0,0 +1,199 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// OTLPExporter sends spans in batches to an OpenTelemetry collector using
+// OTLP/HTTP with JSON encoding. Export never blocks: when the buffer is
+// full new spans are dropped.
+type OTLPExporter struct {
+	url      string
+	service  string
+	client   *http.Client
+	spans    chan *Span
+	interval time.Duration
+	batch    int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewOTLPExporter starts an exporter posting to endpoint/v1/traces, the
+// OTEL_EXPORTER_OTLP_ENDPOINT convention, reporting spans as service
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	e := &OTLPExporter{
+		url:      endpoint + "/v1/traces",
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		spans:    make(chan *Span, 2048),
+		interval: 5 * time.Second,
+		batch:    256,
+		done:     make(chan struct{}),
+	}
+	e.wg.Add(1)
+	go e.run()
+	return e
+}
+
+func (e *OTLPExporter) Export(s *Span) {
+	select {
+	case e.spans <- s:
+	default:
+	}
+}
+
+// Close flushes pending spans
+func (e *OTLPExporter) Close() {
+	close(e.done)
+	e.wg.Wait()
+}
+
+func (e *OTLPExporter) run() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	var pending []*Span
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if err := e.send(pending); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to export %d spans: %v\n", len(pending), err)
+		}
+		pending = nil
+	}
+
+	for {
+		select {
+		case s := <-e.spans:
+			pending = append(pending, s)
+			if len(pending) >= e.batch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.done:
+			for {
+				select {
+				case s := <-e.spans:
+					pending = append(pending, s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// OTLP span kinds and status codes
+const (
+	otlpKindInternal = 1
+	otlpKindServer   = 2
+	otlpStatusError  = 2
+)
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            map[string]int `json:"status,omitempty"`
+}
+
+func (e *OTLPExporter) send(spans []*Span) error {
+	converted := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:           s.Context.TraceIDString(),
+			SpanID:            s.Context.SpanIDString(),
+			Name:              s.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes()),
+		}
+		if s.ParentID != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.ParentID[:])
+		}
+		if s.Server {
+			o.Kind = otlpKindServer
+		}
+		if s.Error {
+			o.Status = map[string]int{"code": otlpStatusError}
+		}
+		converted = append(converted, o)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]any{"service.name": e.service}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]string{"name": "static-site-hosting"},
+				"spans": converted,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpAttributes converts attributes to OTLP's typed key/value form, in
+// key order so payloads are stable
+func otlpAttributes(attrs map[string]any) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		var value map[string]any
+		switch v := attrs[k].(type) {
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: value})
+	}
+	return kvs
+}
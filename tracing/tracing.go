@@ -0,0 +1,166 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanContext identifies a span within a trace, as carried by the W3C
+// traceparent header
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// ParseTraceparent reads a W3C traceparent header value,
+// "00-{trace-id}-{parent-id}-{flags}". Unknown future versions are accepted
+// as long as they start with the version 00 fields.
+func ParseTraceparent(v string) (SpanContext, bool) {
+	var sc SpanContext
+	v = strings.TrimSpace(v)
+	// IDs are lowercase hex only
+	if strings.ToLower(v) != v {
+		return sc, false
+	}
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	trace, err1 := hex.DecodeString(parts[1])
+	span, err2 := hex.DecodeString(parts[2])
+	flags, err3 := hex.DecodeString(parts[3])
+	if err1 != nil || err2 != nil || err3 != nil || len(trace) != 16 || len(span) != 8 || len(flags) != 1 {
+		return sc, false
+	}
+	copy(sc.TraceID[:], trace)
+	copy(sc.SpanID[:], span)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.Valid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// Valid reports whether neither ID is all zeros
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats sc as a traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := 0
+	if sc.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceIDString(), sc.SpanIDString(), flags)
+}
+
+func (sc SpanContext) TraceIDString() string { return hex.EncodeToString(sc.TraceID[:]) }
+func (sc SpanContext) SpanIDString() string  { return hex.EncodeToString(sc.SpanID[:]) }
+
+// Span is one timed operation within a trace
+type Span struct {
+	Name       string
+	Context    SpanContext
+	ParentID   [8]byte // zero for a root span
+	Server     bool    // handles an incoming request, as opposed to internal work
+	Start      time.Time
+	End        time.Time
+	Error      bool
+	mu         sync.Mutex
+	attributes map[string]any
+}
+
+// SetAttribute records a key/value pair on the span
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = map[string]any{}
+	}
+	s.attributes[key] = value
+}
+
+// Attributes returns a copy of the span's attributes
+func (s *Span) Attributes() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attrs := make(map[string]any, len(s.attributes))
+	for k, v := range s.attributes {
+		attrs[k] = v
+	}
+	return attrs
+}
+
+// Finish ends the span and hands it to the exporter if it is sampled
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	if s.Context.Sampled {
+		if e := exporter(); e != nil {
+			e.Export(s)
+		}
+	}
+}
+
+// Exporter receives finished, sampled spans
+type Exporter interface {
+	Export(s *Span)
+}
+
+var (
+	mu  sync.RWMutex
+	exp Exporter
+)
+
+// SetExporter installs the exporter for finished spans; nil disables export
+func SetExporter(e Exporter) {
+	mu.Lock()
+	defer mu.Unlock()
+	exp = e
+}
+
+func exporter() Exporter {
+	mu.RLock()
+	defer mu.RUnlock()
+	return exp
+}
+
+type spanKey struct{}
+
+// Start begins a span named name as a child of the span in ctx, or of
+// parent when ctx has none and parent is valid (a remote caller's span).
+// Without either it starts a new, sampled trace.
+func Start(ctx context.Context, name string, parent SpanContext) (context.Context, *Span) {
+	if current := FromContext(ctx); current != nil {
+		parent = current.Context
+	}
+	s := &Span{Name: name, Start: time.Now()}
+	if parent.Valid() {
+		s.Context.TraceID = parent.TraceID
+		s.Context.Sampled = parent.Sampled
+		s.ParentID = parent.SpanID
+	} else {
+		rand.Read(s.Context.TraceID[:])
+		s.Context.Sampled = true
+	}
+	rand.Read(s.Context.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// FromContext returns the span carried by ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(header)
+	if !ok || !sc.Sampled || sc.TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("failed to parse %s: %+v", header, sc)
+	}
+	if sc.Traceparent() != header {
+		t.Errorf("expected %s to round trip, got %s", header, sc.Traceparent())
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", // uppercase
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01", // zero trace ID
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", // zero span ID
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", // forbidden version
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+	} {
+		if _, ok := ParseTraceparent(invalid); ok {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+	if _, ok := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future"); !ok {
+		t.Error("expected a future version with extra fields to be accepted")
+	}
+}
+
+func TestStartContinuesTrace(t *testing.T) {
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx, server := Start(context.Background(), "GET", parent)
+	if server.Context.TraceID != parent.TraceID || server.ParentID != parent.SpanID || server.Context.Sampled {
+		t.Errorf("expected a child of the remote span keeping its sampling decision, got %+v", server.Context)
+	}
+	_, child := Start(ctx, "extract", SpanContext{})
+	if child.Context.TraceID != parent.TraceID || child.ParentID != server.Context.SpanID {
+		t.Errorf("expected a child of the server span, got %+v", child.Context)
+	}
+
+	_, root := Start(context.Background(), "GET", SpanContext{})
+	if !root.Context.Valid() || root.ParentID != [8]byte{} || !root.Context.Sampled {
+		t.Errorf("expected a new sampled root span, got %+v", root.Context)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	received := make(chan map[string]any, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			http.NotFound(w, r)
+			return
+		}
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		received <- body
+	}))
+	defer collector.Close()
+
+	e := NewOTLPExporter(collector.URL, "hosting-test")
+	SetExporter(e)
+	defer SetExporter(nil)
+
+	_, span := Start(context.Background(), "GET", SpanContext{})
+	span.Server = true
+	span.SetAttribute("http.response.status_code", 200)
+	span.Finish()
+	e.Close()
+
+	select {
+	case body := <-received:
+		spans := body["resourceSpans"].([]any)[0].(map[string]any)["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)
+		got := spans[0].(map[string]any)
+		if got["traceId"] != span.Context.TraceIDString() || got["kind"] != float64(otlpKindServer) {
+			t.Errorf("unexpected exported span %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected spans to be exported on close")
+	}
+}
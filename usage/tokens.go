@@ -0,0 +1,129 @@
+package usage
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// TokenUsage is what has been recorded of an API token's use. Deploy tokens
+// count towards the API token they were exchanged for.
+type TokenUsage struct {
+	TokenID       string     `json:"token_id"`
+	Requests      int64      `json:"requests"`
+	Errors        int64      `json:"errors"` // answered with a 4xx or 5xx status
+	BytesUploaded int64      `json:"bytes_uploaded"`
+	LastUsedAt    *time.Time `json:"last_used_at,omitempty"`
+}
+
+// TokenMeter accumulates API token use in memory and periodically flushes
+// it to the database, like Meter does bandwidth
+type TokenMeter struct {
+	db      *sql.DB
+	mu      sync.Mutex
+	pending map[string]*TokenUsage
+}
+
+// NewTokenMeter returns a token usage meter writing to db
+func NewTokenMeter(db *sql.DB) *TokenMeter {
+	return &TokenMeter{db: db, pending: map[string]*TokenUsage{}}
+}
+
+// Record counts a request made with tokenID that uploaded n bytes and
+// failed if its status was an error. Safe on a nil TokenMeter.
+func (m *TokenMeter) Record(tokenID string, n int64, failed bool) {
+	if m == nil || tokenID == "" {
+		return
+	}
+	now := time.Now().UTC()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.pending[tokenID]
+	if u == nil {
+		u = &TokenUsage{TokenID: tokenID}
+		m.pending[tokenID] = u
+	}
+	u.Requests++
+	u.BytesUploaded += n
+	if failed {
+		u.Errors++
+	}
+	u.LastUsedAt = &now
+}
+
+// Usage returns what is recorded of tokenID, including use not flushed yet
+func (m *TokenMeter) Usage(tokenID string) (TokenUsage, error) {
+	u := TokenUsage{TokenID: tokenID}
+	var lastUsed sql.NullTime
+	err := m.db.QueryRow("SELECT requests, errors, bytes_uploaded, last_used_at FROM api_token_usage WHERE token_id = ?", tokenID).
+		Scan(&u.Requests, &u.Errors, &u.BytesUploaded, &lastUsed)
+	if err != nil && err != sql.ErrNoRows {
+		return u, err
+	}
+	if lastUsed.Valid {
+		u.LastUsedAt = &lastUsed.Time
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p := m.pending[tokenID]; p != nil {
+		u.Requests += p.Requests
+		u.Errors += p.Errors
+		u.BytesUploaded += p.BytesUploaded
+		u.LastUsedAt = p.LastUsedAt
+	}
+	return u, nil
+}
+
+// Flush writes accumulated token usage to the database
+func (m *TokenMeter) Flush() error {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = map[string]*TokenUsage{}
+	m.mu.Unlock()
+
+	var failed error
+	for id, u := range pending {
+		if failed == nil {
+			_, failed = m.db.Exec(
+				`INSERT INTO api_token_usage (token_id, requests, errors, bytes_uploaded, last_used_at) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT(token_id) DO UPDATE SET requests = requests + excluded.requests, errors = errors + excluded.errors,
+					bytes_uploaded = bytes_uploaded + excluded.bytes_uploaded, last_used_at = excluded.last_used_at`,
+				id, u.Requests, u.Errors, u.BytesUploaded, *u.LastUsedAt,
+			)
+			if failed == nil {
+				continue
+			}
+		}
+		// Put it back so the next flush retries
+		m.mu.Lock()
+		if p := m.pending[id]; p != nil {
+			p.Requests += u.Requests
+			p.Errors += u.Errors
+			p.BytesUploaded += u.BytesUploaded
+		} else {
+			m.pending[id] = u
+		}
+		m.mu.Unlock()
+	}
+	return failed
+}
+
+// Run flushes every interval until ctx is cancelled, then flushes once more
+func (m *TokenMeter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Flush(); err != nil {
+				log.Printf("Warning: Failed to flush token usage: %v", err)
+			}
+		case <-ctx.Done():
+			m.Flush()
+			return
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package usage
+
+import "testing"
+
+func TestTokenMeter(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	m := NewTokenMeter(db)
+
+	m.Record("t1", 100, false)
+	m.Record("t1", 0, true)
+	m.Record("", 50, false)
+	if err := m.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	m.Record("t1", 20, false)
+
+	// Unflushed use is reported too
+	u, err := m.Usage("t1")
+	if err != nil {
+		t.Fatalf("Usage failed: %v", err)
+	}
+	if u.Requests != 3 || u.Errors != 1 || u.BytesUploaded != 120 || u.LastUsedAt == nil {
+		t.Errorf("unexpected usage %+v", u)
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if again, _ := m.Usage("t1"); again.Requests != 3 || again.BytesUploaded != 120 || again.LastUsedAt == nil {
+		t.Errorf("expected flushing not to change the totals, got %+v", again)
+	}
+
+	if u, err := m.Usage("unused"); err != nil || u.Requests != 0 || u.LastUsedAt != nil {
+		t.Errorf("expected an unused token to have no usage, got %+v (%v)", u, err)
+	}
+
+	var nilMeter *TokenMeter
+	nilMeter.Record("t1", 1, false)
+}
@@ -0,0 +1,425 @@
+package usage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"static-site-hosting/events"
+	"static-site-hosting/shared"
+)
+
+// DefaultTenant is used for deployments created without a tenant identity
+const DefaultTenant = "default"
+
+// Shared, when set, also counts each tenant's monthly bandwidth where every
+// node sees it, so quotas cover what all nodes served rather than this one
+var Shared shared.Store
+
+// sharedBandwidthTTL keeps a month's shared bandwidth for the month after
+const sharedBandwidthTTL = 62 * 24 * time.Hour
+
+// sharedBandwidthKey is the shared counter of a tenant's bandwidth in a
+// month such as "2024-06"
+func sharedBandwidthKey(tenant, month string) string {
+	return "bandwidth:" + tenant + ":" + month
+}
+
+// Entry is what the usage ledger records of a deployment
+type Entry struct {
+	DeploymentID string `json:"deployment_id"`
+	TenantID     string `json:"tenant_id"`
+	Bytes        int64  `json:"bytes"`
+	BuildMS      int64  `json:"build_ms"`
+}
+
+// RecordDeployment adds a deployment to the usage ledger and returns what
+// it recorded, for the event log. buildTime is the server time spent
+// producing it (receiving and extracting the archive). An empty dir
+// records no storage, for deployments sharing another's files.
+func RecordDeployment(db *sql.DB, deploymentID, tenantID, dir string, buildTime time.Duration) Entry {
+	if tenantID == "" {
+		tenantID = DefaultTenant
+	}
+	entry := Entry{DeploymentID: deploymentID, TenantID: tenantID, BuildMS: buildTime.Milliseconds()}
+	if dir != "" {
+		var err error
+		if entry.Bytes, err = DirSize(dir); err != nil {
+			log.Printf("Warning: Failed to measure deployment %s: %v", deploymentID, err)
+		}
+	}
+	_, err := db.Exec(
+		"INSERT INTO deployment_usage (deployment_id, tenant_id, bytes, build_ms, created_at) VALUES (?, ?, ?, ?, ?)",
+		entry.DeploymentID, entry.TenantID, entry.Bytes, entry.BuildMS, time.Now().UTC(),
+	)
+	if err != nil {
+		log.Printf("Warning: Failed to record usage for %s: %v", deploymentID, err)
+	}
+	return entry
+}
+
+// MarkDeleted stops storage accrual for a deployment. An empty ID marks
+// every live deployment, for bulk deletes.
+func MarkDeleted(db *sql.DB, deploymentID string) {
+	var err error
+	now := time.Now().UTC()
+	if deploymentID == "" {
+		_, err = db.Exec("UPDATE deployment_usage SET deleted_at = ? WHERE deleted_at IS NULL", now)
+	} else {
+		_, err = db.Exec("UPDATE deployment_usage SET deleted_at = ? WHERE deployment_id = ? AND deleted_at IS NULL", now, deploymentID)
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to record deletion usage: %v", err)
+	}
+}
+
+// Rebuild replaces the usage ledger with one replayed from the event log:
+// a row for every deployment created or failed with its usage attached,
+// closed by its deletion. Bandwidth is metered, not logged, and is kept.
+// It returns the number of events replayed.
+func Rebuild(db *sql.DB) (int, error) {
+	type row struct {
+		Entry
+		created, deleted time.Time
+	}
+	var order []string
+	ledger := map[string]*row{}
+	n, err := events.Replay(db, 0, func(e events.Event) error {
+		switch e.Type {
+		case events.DeploymentCreated, events.DeploymentFailed:
+			var data struct {
+				Usage *Entry `json:"usage"`
+			}
+			if json.Unmarshal(e.Data, &data) != nil || data.Usage == nil {
+				return nil
+			}
+			if _, ok := ledger[e.DeploymentID]; !ok {
+				order = append(order, e.DeploymentID)
+			}
+			ledger[e.DeploymentID] = &row{Entry: *data.Usage, created: e.CreatedAt}
+		case events.DeploymentDeleted:
+			if r, ok := ledger[e.DeploymentID]; ok && r.deleted.IsZero() {
+				r.deleted = e.CreatedAt
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return n, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM deployment_usage"); err != nil {
+		return n, err
+	}
+	for _, id := range order {
+		r := ledger[id]
+		var deleted any
+		if !r.deleted.IsZero() {
+			deleted = r.deleted
+		}
+		_, err := tx.Exec(
+			"INSERT INTO deployment_usage (deployment_id, tenant_id, bytes, build_ms, created_at, deleted_at) VALUES (?, ?, ?, ?, ?, ?)",
+			id, r.TenantID, r.Bytes, r.BuildMS, r.created, deleted,
+		)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, tx.Commit()
+}
+
+// DirSize sums the sizes of all regular files below dir
+func DirSize(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// Meter accumulates bytes served per deployment in memory and periodically
+// flushes them to the database, keeping DB writes off the request path
+type Meter struct {
+	db      *sql.DB
+	mu      sync.Mutex
+	pending map[meterKey]int64
+	// shared holds bandwidth flushed to the database but not yet counted
+	// in Shared, by shared key
+	shared map[string]int64
+}
+
+type meterKey struct {
+	deploymentID string
+	day          string
+}
+
+// NewMeter returns a bandwidth meter writing to db
+func NewMeter(db *sql.DB) *Meter {
+	return &Meter{db: db, pending: map[meterKey]int64{}, shared: map[string]int64{}}
+}
+
+// AddBandwidth records n bytes served for a deployment. Safe on a nil Meter.
+func (m *Meter) AddBandwidth(deploymentID string, n int64) {
+	if m == nil || n <= 0 {
+		return
+	}
+	key := meterKey{deploymentID: deploymentID, day: time.Now().UTC().Format("2006-01-02")}
+	m.mu.Lock()
+	m.pending[key] += n
+	m.mu.Unlock()
+}
+
+// Flush writes accumulated bandwidth to the database
+func (m *Meter) Flush() error {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = map[meterKey]int64{}
+	m.mu.Unlock()
+
+	for key, n := range pending {
+		_, err := m.db.Exec(
+			`INSERT INTO bandwidth_daily (deployment_id, day, bytes) VALUES (?, ?, ?)
+			ON CONFLICT(deployment_id, day) DO UPDATE SET bytes = bytes + excluded.bytes`,
+			key.deploymentID, key.day, n,
+		)
+		if err != nil {
+			// Put it back so the next flush retries
+			m.mu.Lock()
+			m.pending[key] += n
+			m.mu.Unlock()
+			return err
+		}
+		if Shared != nil {
+			m.mu.Lock()
+			m.shared[sharedBandwidthKey(TenantOf(m.db, key.deploymentID), key.day[:7])] += n
+			m.mu.Unlock()
+		}
+	}
+	return m.flushShared()
+}
+
+// flushShared adds the bandwidth counted since the last flush to Shared,
+// keeping what fails for the next flush
+func (m *Meter) flushShared() error {
+	if Shared == nil {
+		return nil
+	}
+	m.mu.Lock()
+	pending := m.shared
+	m.shared = map[string]int64{}
+	m.mu.Unlock()
+
+	ctx, cancel := shared.Context()
+	defer cancel()
+	var failed error
+	for key, n := range pending {
+		if failed == nil {
+			_, failed = Shared.IncrBy(ctx, key, n, sharedBandwidthTTL)
+			if failed == nil {
+				continue
+			}
+		}
+		m.mu.Lock()
+		m.shared[key] += n
+		m.mu.Unlock()
+	}
+	return failed
+}
+
+// Run flushes every interval until ctx is cancelled, then flushes once more
+func (m *Meter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Flush(); err != nil {
+				log.Printf("Warning: Failed to flush bandwidth usage: %v", err)
+			}
+		case <-ctx.Done():
+			m.Flush()
+			return
+		}
+	}
+}
+
+// TenantUsage is one tenant's billable usage for a period
+type TenantUsage struct {
+	TenantID          string  `json:"tenant_id"`
+	StorageByteDays   float64 `json:"storage_byte_days"`
+	BandwidthBytes    int64   `json:"bandwidth_bytes"`
+	BuildMinutes      float64 `json:"build_minutes"`
+	DeploymentsActive int     `json:"deployments_active"`
+}
+
+// ParsePeriod parses a billing period such as "2024-06" into [start, end)
+func ParsePeriod(period string) (time.Time, time.Time, error) {
+	start, err := time.Parse("2006-01", period)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("period must look like 2024-06")
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// Report computes per-tenant usage for [start, end). Storage accrues for the
+// part of the period each deployment existed; builds count in the period the
+// deployment was created.
+func Report(db *sql.DB, start, end time.Time) ([]TenantUsage, error) {
+	now := time.Now().UTC()
+	byTenant := map[string]*TenantUsage{}
+	get := func(tenant string) *TenantUsage {
+		u, ok := byTenant[tenant]
+		if !ok {
+			u = &TenantUsage{TenantID: tenant}
+			byTenant[tenant] = u
+		}
+		return u
+	}
+
+	rows, err := db.Query(
+		`SELECT tenant_id, bytes, build_ms, created_at, deleted_at FROM deployment_usage
+		WHERE created_at < ? AND (deleted_at IS NULL OR deleted_at >= ?)`,
+		end, start,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var tenant string
+		var bytes, buildMS int64
+		var created time.Time
+		var deleted sql.NullTime
+		if err := rows.Scan(&tenant, &bytes, &buildMS, &created, &deleted); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		from, to := created, now
+		if deleted.Valid {
+			to = deleted.Time
+		}
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+
+		u := get(tenant)
+		if to.After(from) {
+			u.StorageByteDays += float64(bytes) * to.Sub(from).Hours() / 24
+			u.DeploymentsActive++
+		}
+		if !created.Before(start) && created.Before(end) {
+			u.BuildMinutes += float64(buildMS) / 60000
+		}
+	}
+	rows.Close()
+
+	rows, err = db.Query(
+		`SELECT COALESCE(du.tenant_id, ?), SUM(bw.bytes) FROM bandwidth_daily bw
+		LEFT JOIN deployment_usage du ON du.deployment_id = bw.deployment_id
+		WHERE bw.day >= ? AND bw.day < ?
+		GROUP BY COALESCE(du.tenant_id, ?)`,
+		DefaultTenant, start.Format("2006-01-02"), end.Format("2006-01-02"), DefaultTenant,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var tenant string
+		var bytes int64
+		if err := rows.Scan(&tenant, &bytes); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		get(tenant).BandwidthBytes += bytes
+	}
+	rows.Close()
+
+	report := make([]TenantUsage, 0, len(byTenant))
+	for _, u := range byTenant {
+		report = append(report, *u)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].TenantID < report[j].TenantID })
+	return report, nil
+}
+
+// TenantOf returns the tenant a deployment is billed to
+func TenantOf(db *sql.DB, deploymentID string) string {
+	var tenant string
+	err := db.QueryRow("SELECT tenant_id FROM deployment_usage WHERE deployment_id = ?", deploymentID).Scan(&tenant)
+	if err != nil {
+		return DefaultTenant
+	}
+	return tenant
+}
+
+// Current returns the storage a tenant's live deployments take and the
+// bandwidth they have served since start, the first of a month, as
+// flushed by the Meter. With Shared set bandwidth is that of every node.
+func Current(db *sql.DB, tenant string, start time.Time) (storage, bandwidth int64, err error) {
+	err = db.QueryRow(
+		"SELECT COALESCE(SUM(bytes), 0) FROM deployment_usage WHERE tenant_id = ? AND deleted_at IS NULL",
+		tenant,
+	).Scan(&storage)
+	if err != nil {
+		return 0, 0, err
+	}
+	if Shared != nil {
+		ctx, cancel := shared.Context()
+		bandwidth, err = Shared.Get(ctx, sharedBandwidthKey(tenant, start.Format("2006-01")))
+		cancel()
+		if err == nil {
+			return storage, bandwidth, nil
+		}
+		log.Printf("Warning: Shared bandwidth unavailable, using this node's: %v", err)
+	}
+	err = db.QueryRow(
+		`SELECT COALESCE(SUM(bw.bytes), 0) FROM bandwidth_daily bw
+		LEFT JOIN deployment_usage du ON du.deployment_id = bw.deployment_id
+		WHERE COALESCE(du.tenant_id, ?) = ? AND bw.day >= ?`,
+		DefaultTenant, tenant, start.Format("2006-01-02"),
+	).Scan(&bandwidth)
+	return storage, bandwidth, err
+}
+
+// Tenants lists the tenants with recorded usage
+func Tenants(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT tenant_id FROM deployment_usage ORDER BY tenant_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tenants []string
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, rows.Err()
+}
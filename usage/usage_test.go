@@ -0,0 +1,218 @@
+package usage
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"static-site-hosting/shared"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	schema := []string{
+		`CREATE TABLE deployment_usage (
+			deployment_id TEXT PRIMARY KEY,
+			tenant_id TEXT NOT NULL,
+			bytes INTEGER NOT NULL DEFAULT 0,
+			build_ms INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL,
+			deleted_at DATETIME
+		)`,
+		`CREATE TABLE bandwidth_daily (
+			deployment_id TEXT NOT NULL,
+			day TEXT NOT NULL,
+			bytes INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (deployment_id, day)
+		)`,
+		`CREATE TABLE api_token_usage (
+			token_id TEXT PRIMARY KEY,
+			requests INTEGER NOT NULL DEFAULT 0,
+			errors INTEGER NOT NULL DEFAULT 0,
+			bytes_uploaded INTEGER NOT NULL DEFAULT 0,
+			last_used_at DATETIME
+		)`,
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+	}
+	return db
+}
+
+func insertUsage(t *testing.T, db *sql.DB, id, tenant string, bytes, buildMS int64, created time.Time, deleted *time.Time) {
+	var deletedAt interface{}
+	if deleted != nil {
+		deletedAt = *deleted
+	}
+	_, err := db.Exec(
+		"INSERT INTO deployment_usage (deployment_id, tenant_id, bytes, build_ms, created_at, deleted_at) VALUES (?, ?, ?, ?, ?, ?)",
+		id, tenant, bytes, buildMS, created, deletedAt,
+	)
+	if err != nil {
+		t.Fatalf("Failed to insert usage: %v", err)
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	start, end, err := ParsePeriod("2024-06")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !start.Equal(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected range %v - %v", start, end)
+	}
+
+	for _, bad := range []string{"", "2024", "2024-13", "June"} {
+		if _, _, err := ParsePeriod(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestReportStorageAndBuilds(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	start, end, _ := ParsePeriod("2024-06")
+
+	// Lives through the whole month: 30 days of 1000 bytes, built in May
+	insertUsage(t, db, "a", "acme", 1000, 120000, start.AddDate(0, 0, -5), nil)
+	// Created on June 11th and deleted on June 21st: 10 days
+	created := start.AddDate(0, 0, 10)
+	deleted := start.AddDate(0, 0, 20)
+	insertUsage(t, db, "b", "acme", 500, 30000, created, &deleted)
+	// Deleted before the period: no storage
+	gone := start.AddDate(0, 0, -1)
+	insertUsage(t, db, "c", "globex", 9999, 60000, start.AddDate(0, -2, 0), &gone)
+	// Created after the period
+	insertUsage(t, db, "d", "globex", 9999, 60000, end.AddDate(0, 0, 1), nil)
+
+	report, err := Report(db, start, end)
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if len(report) != 1 {
+		t.Fatalf("expected only acme in report, got %+v", report)
+	}
+
+	acme := report[0]
+	if acme.TenantID != "acme" {
+		t.Fatalf("expected acme, got %s", acme.TenantID)
+	}
+	if want := 1000.0*30 + 500.0*10; math.Abs(acme.StorageByteDays-want) > 0.01 {
+		t.Errorf("expected %.2f byte-days, got %.2f", want, acme.StorageByteDays)
+	}
+	if math.Abs(acme.BuildMinutes-0.5) > 0.001 {
+		t.Errorf("expected 0.5 build minutes (only b was built in June), got %f", acme.BuildMinutes)
+	}
+	if acme.DeploymentsActive != 2 {
+		t.Errorf("expected 2 active deployments, got %d", acme.DeploymentsActive)
+	}
+}
+
+func TestMeterFlushAttributesBandwidth(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	now := time.Now().UTC()
+	insertUsage(t, db, "site-1", "acme", 0, 0, now, nil)
+
+	m := NewMeter(db)
+	m.AddBandwidth("site-1", 100)
+	m.AddBandwidth("site-1", 50)
+	m.AddBandwidth("unknown-site", 7)
+	if err := m.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	m.AddBandwidth("site-1", 10)
+	if err := m.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	start, end, _ := ParsePeriod(now.Format("2006-01"))
+	report, err := Report(db, start, end)
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+
+	got := map[string]int64{}
+	for _, u := range report {
+		got[u.TenantID] = u.BandwidthBytes
+	}
+	if got["acme"] != 160 {
+		t.Errorf("expected 160 bytes for acme, got %d", got["acme"])
+	}
+	if got[DefaultTenant] != 7 {
+		t.Errorf("expected unattributed bytes on the default tenant, got %d", got[DefaultTenant])
+	}
+}
+
+func TestNilMeterIsNoop(t *testing.T) {
+	var m *Meter
+	m.AddBandwidth("site", 10)
+}
+
+func TestRecordDeploymentAndMarkDeleted(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), make([]byte, 300), 0644)
+	os.MkdirAll(filepath.Join(dir, "css"), 0755)
+	os.WriteFile(filepath.Join(dir, "css", "site.css"), make([]byte, 200), 0644)
+
+	RecordDeployment(db, "dep-1", "", dir, 90*time.Second)
+
+	var tenant string
+	var bytes, buildMS int64
+	db.QueryRow("SELECT tenant_id, bytes, build_ms FROM deployment_usage WHERE deployment_id = 'dep-1'").Scan(&tenant, &bytes, &buildMS)
+	if tenant != DefaultTenant || bytes != 500 || buildMS != 90000 {
+		t.Errorf("unexpected ledger row: tenant=%s bytes=%d build_ms=%d", tenant, bytes, buildMS)
+	}
+	if TenantOf(db, "dep-1") != DefaultTenant {
+		t.Errorf("expected default tenant")
+	}
+
+	MarkDeleted(db, "dep-1")
+	var deleted sql.NullTime
+	db.QueryRow("SELECT deleted_at FROM deployment_usage WHERE deployment_id = 'dep-1'").Scan(&deleted)
+	if !deleted.Valid {
+		t.Error("expected deleted_at to be set")
+	}
+}
+
+func TestMeterCountsSharedBandwidth(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	Shared = shared.NewMemory()
+	defer func() { Shared = nil }()
+
+	now := time.Now().UTC()
+	insertUsage(t, db, "site-1", "acme", 0, 0, now, nil)
+	// Another node has already served some of acme's bandwidth
+	Shared.IncrBy(context.Background(), sharedBandwidthKey("acme", now.Format("2006-01")), 1000, 0)
+
+	m := NewMeter(db)
+	m.AddBandwidth("site-1", 100)
+	if err := m.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if _, bandwidth, err := Current(db, "acme", month); err != nil || bandwidth != 1100 {
+		t.Errorf("expected every node's 1100 bytes, got %d, %v", bandwidth, err)
+	}
+}
@@ -0,0 +1,126 @@
+// Package users stores local accounts, for installations without an
+// identity provider or with people outside it
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+)
+
+var (
+	ErrUsernameTaken      = errors.New("username already taken")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrIdentityLinked     = errors.New("identity already linked to an account")
+)
+
+const userColumns = "id, username, email, name, role, tenant, password_hash, created_at"
+
+// dummyHash is checked against when a username doesn't exist, so a login
+// takes as long whether or not it does
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := auth.HashPassword("")
+	return hash
+})
+
+// Normalize returns the form usernames are stored and looked up in
+func Normalize(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// Count returns the number of accounts
+func Count(db *sql.DB) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&n)
+	return n, err
+}
+
+// Create stores u with password hashed, filling in its ID and creation
+// time. Accounts created without a password can only log in through an
+// identity linked to them.
+func Create(db *sql.DB, u *models.User, password string) error {
+	var hash string
+	if password != "" {
+		var err error
+		if hash, err = auth.HashPassword(password); err != nil {
+			return err
+		}
+	}
+	u.ID = uuid.New().String()
+	u.Username = Normalize(u.Username)
+	u.PasswordHash = hash
+	u.CreatedAt = time.Now().UTC()
+
+	_, err := db.Exec(
+		"INSERT INTO users (id, username, email, name, role, tenant, password_hash, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		u.ID, u.Username, u.Email, u.Name, u.Role, u.Tenant, u.PasswordHash, u.CreatedAt,
+	)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrUsernameTaken
+	}
+	return err
+}
+
+// Authenticate returns the account for username if password is its password
+func Authenticate(db *sql.DB, username, password string) (*models.User, error) {
+	u, err := scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", Normalize(username)))
+	if err == sql.ErrNoRows {
+		auth.CheckPassword(dummyHash(), password)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !auth.CheckPassword(u.PasswordHash, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return u, nil
+}
+
+// ByUsername returns the account named username, or sql.ErrNoRows
+func ByUsername(db *sql.DB, username string) (*models.User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", Normalize(username)))
+}
+
+// Get returns the account with id, or sql.ErrNoRows
+func Get(db *sql.DB, id string) (*models.User, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+// ByIdentity returns the account an identity at an external provider, such
+// as a GitHub user ID, is linked to, or sql.ErrNoRows
+func ByIdentity(db *sql.DB, provider, externalID string) (*models.User, error) {
+	return scanUser(db.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE id = (SELECT user_id FROM user_identities WHERE provider = ? AND external_id = ?)",
+		provider, externalID,
+	))
+}
+
+// Link links an identity at an external provider to the account userID, so
+// it logs in as that account. An identity links to one account at most.
+func Link(db *sql.DB, userID, provider, externalID, login string) error {
+	_, err := db.Exec(
+		"INSERT INTO user_identities (provider, external_id, user_id, login, created_at) VALUES (?, ?, ?, ?, ?)",
+		provider, externalID, userID, login, time.Now().UTC(),
+	)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrIdentityLinked
+	}
+	return err
+}
+
+func scanUser(row *sql.Row) (*models.User, error) {
+	var u models.User
+	err := row.Scan(&u.ID, &u.Username, &u.Email, &u.Name, &u.Role, &u.Tenant, &u.PasswordHash, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
@@ -0,0 +1,114 @@
+package users
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"static-site-hosting/auth"
+	"static-site-hosting/models"
+)
+
+func setupDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`CREATE TABLE users (
+		id TEXT PRIMARY KEY,
+		username TEXT NOT NULL UNIQUE,
+		email TEXT NOT NULL DEFAULT '',
+		name TEXT NOT NULL DEFAULT '',
+		role TEXT NOT NULL,
+		tenant TEXT NOT NULL DEFAULT '',
+		password_hash TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	)`)
+	if err == nil {
+		_, err = db.Exec(`CREATE TABLE user_identities (
+			provider TEXT NOT NULL,
+			external_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			login TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (provider, external_id)
+		)`)
+	}
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+
+	saved := auth.PasswordIterations
+	auth.PasswordIterations = 1000
+	t.Cleanup(func() { auth.PasswordIterations = saved })
+	return db
+}
+
+func TestCreateAndAuthenticate(t *testing.T) {
+	db := setupDB(t)
+
+	u := &models.User{Username: " Alice ", Role: auth.RoleDeployer, Tenant: "acme"}
+	if err := Create(db, u, "s3cret-pass"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if u.ID == "" || u.Username != "alice" {
+		t.Errorf("expected an ID and normalized username, got %+v", u)
+	}
+	if n, _ := Count(db); n != 1 {
+		t.Errorf("expected 1 account, got %d", n)
+	}
+
+	got, err := Authenticate(db, "ALICE", "s3cret-pass")
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if got.ID != u.ID || got.Role != auth.RoleDeployer || got.Tenant != "acme" {
+		t.Errorf("unexpected account %+v", got)
+	}
+
+	if _, err := Authenticate(db, "alice", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials for a wrong password, got %v", err)
+	}
+	if _, err := Authenticate(db, "nobody", "s3cret-pass"); err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials for an unknown user, got %v", err)
+	}
+}
+
+func TestCreateRejectsTakenUsername(t *testing.T) {
+	db := setupDB(t)
+
+	Create(db, &models.User{Username: "alice", Role: auth.RoleViewer}, "password")
+	if err := Create(db, &models.User{Username: "Alice", Role: auth.RoleViewer}, "password"); err != ErrUsernameTaken {
+		t.Errorf("expected ErrUsernameTaken, got %v", err)
+	}
+}
+
+func TestLinkedIdentities(t *testing.T) {
+	db := setupDB(t)
+
+	u := &models.User{Username: "octocat", Role: auth.RoleDeployer}
+	if err := Create(db, u, ""); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := Authenticate(db, "octocat", ""); err != ErrInvalidCredentials {
+		t.Errorf("expected an account without a password to refuse password logins, got %v", err)
+	}
+
+	if _, err := ByIdentity(db, "github", "583231"); err != sql.ErrNoRows {
+		t.Errorf("expected no account for an unlinked identity, got %v", err)
+	}
+	if err := Link(db, u.ID, "github", "583231", "octocat"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	if found, err := ByIdentity(db, "github", "583231"); err != nil || found.ID != u.ID {
+		t.Errorf("expected the linked account, got %+v, %v", found, err)
+	}
+	if err := Link(db, "someone-else", "github", "583231", "octocat"); err != ErrIdentityLinked {
+		t.Errorf("expected ErrIdentityLinked, got %v", err)
+	}
+	if found, err := Get(db, u.ID); err != nil || found.Username != "octocat" {
+		t.Errorf("expected Get to find the account, got %+v, %v", found, err)
+	}
+}
@@ -0,0 +1,242 @@
+package validate
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Issue is one problem a validator found in a deployment
+type Issue struct {
+	Validator string `json:"validator"`
+	Path      string `json:"path,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Message   string `json:"message"`
+}
+
+// Report is the outcome of running every configured validator
+type Report struct {
+	Passed     bool     `json:"passed"`
+	Validators []string `json:"validators"`
+	Issues     []Issue  `json:"issues"`
+}
+
+// Validator checks an extracted deployment tree before it goes live
+type Validator interface {
+	Name() string
+	Validate(root string) ([]Issue, error)
+}
+
+// maxIssuesPerValidator keeps reports readable for badly broken archives
+const maxIssuesPerValidator = 100
+
+// Run validates root with every validator. An error means a validator could
+// not run at all, not that the deployment is invalid.
+func Run(root string, validators []Validator) (*Report, error) {
+	report := &Report{Validators: []string{}, Issues: []Issue{}}
+	for _, v := range validators {
+		report.Validators = append(report.Validators, v.Name())
+		issues, err := v.Validate(root)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", v.Name(), err)
+		}
+		if len(issues) > maxIssuesPerValidator {
+			more := len(issues) - maxIssuesPerValidator
+			issues = append(issues[:maxIssuesPerValidator], Issue{
+				Validator: v.Name(),
+				Message:   fmt.Sprintf("%d more issues not shown", more),
+			})
+		}
+		report.Issues = append(report.Issues, issues...)
+	}
+	report.Passed = len(report.Issues) == 0
+	return report, nil
+}
+
+// RequiredFiles fails deployments missing any of Paths
+type RequiredFiles struct {
+	Paths []string
+}
+
+func (RequiredFiles) Name() string { return "required_files" }
+
+func (v RequiredFiles) Validate(root string) ([]Issue, error) {
+	var issues []Issue
+	for _, p := range v.Paths {
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(p)))
+		if err == nil && !info.IsDir() {
+			continue
+		}
+		issues = append(issues, Issue{Validator: v.Name(), Path: p, Message: "required file is missing"})
+	}
+	return issues, nil
+}
+
+// ForbiddenContent fails deployments whose text files match any pattern,
+// e.g. leaked credentials or internal hostnames. Files larger than MaxSize
+// are not scanned.
+type ForbiddenContent struct {
+	Patterns []*regexp.Regexp
+	MaxSize  int64
+}
+
+func (ForbiddenContent) Name() string { return "forbidden_content" }
+
+func (v ForbiddenContent) Validate(root string) ([]Issue, error) {
+	var issues []Issue
+	err := walkFiles(root, func(rel, full string, info fs.FileInfo) error {
+		if v.MaxSize > 0 && info.Size() > v.MaxSize || !isTextFile(rel) {
+			return nil
+		}
+		return scanLines(full, func(n int, line string) {
+			for _, re := range v.Patterns {
+				if re.MatchString(line) {
+					issues = append(issues, Issue{
+						Validator: v.Name(),
+						Path:      rel,
+						Line:      n,
+						Message:   fmt.Sprintf("matches forbidden pattern %q", re.String()),
+					})
+				}
+			}
+		})
+	})
+	return issues, err
+}
+
+// HTML checks that every HTML page has balanced tags, catching truncated or
+// mangled pages before they are served
+type HTML struct{}
+
+func (HTML) Name() string { return "html" }
+
+var (
+	htmlTag     = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9-]*)[^>]*?(/?)>`)
+	htmlComment = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlRawText = regexp.MustCompile(`(?is)<(script|style|textarea|pre)\b[^>]*>.*?</(script|style|textarea|pre)\s*>`)
+)
+
+// Elements that never have a closing tag, or whose closing tag is optional
+var (
+	htmlVoid = map[string]bool{
+		"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+		"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+	}
+	htmlOptionalClose = map[string]bool{
+		"html": true, "head": true, "body": true, "p": true, "li": true, "dt": true, "dd": true,
+		"option": true, "optgroup": true, "tr": true, "td": true, "th": true, "thead": true,
+		"tbody": true, "tfoot": true, "colgroup": true, "rt": true, "rp": true,
+	}
+)
+
+func (v HTML) Validate(root string) ([]Issue, error) {
+	var issues []Issue
+	err := walkFiles(root, func(rel, full string, info fs.FileInfo) error {
+		ext := strings.ToLower(filepath.Ext(rel))
+		if ext != ".html" && ext != ".htm" {
+			return nil
+		}
+		content, err := os.ReadFile(full)
+		if err != nil {
+			return err
+		}
+		for _, msg := range checkHTML(string(content)) {
+			issues = append(issues, Issue{Validator: v.Name(), Path: rel, Message: msg})
+		}
+		return nil
+	})
+	return issues, err
+}
+
+// checkHTML reports unmatched and unclosed tags in a page
+func checkHTML(page string) []string {
+	// Comments and raw-text elements may contain anything that looks like a tag
+	page = htmlComment.ReplaceAllString(page, "")
+	page = htmlRawText.ReplaceAllString(page, "")
+
+	var problems []string
+	var open []string
+	for _, m := range htmlTag.FindAllStringSubmatch(page, -1) {
+		closing, name, selfClosing := m[1] == "/", strings.ToLower(m[2]), m[3] == "/"
+		if htmlVoid[name] || selfClosing && !closing {
+			continue
+		}
+		if !closing {
+			open = append(open, name)
+			continue
+		}
+
+		// Pop to the matching element, tolerating omitted optional closes
+		match := -1
+		for i := len(open) - 1; i >= 0; i-- {
+			if open[i] == name {
+				match = i
+				break
+			}
+			if !htmlOptionalClose[open[i]] {
+				break
+			}
+		}
+		if match < 0 {
+			problems = append(problems, fmt.Sprintf("unexpected closing tag </%s>", name))
+			continue
+		}
+		open = open[:match]
+	}
+	for _, name := range open {
+		if !htmlOptionalClose[name] {
+			problems = append(problems, fmt.Sprintf("unclosed tag <%s>", name))
+		}
+	}
+	return problems
+}
+
+func walkFiles(root string, fn func(rel, full string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		return fn(filepath.ToSlash(rel), p, info)
+	})
+}
+
+func scanLines(name string, fn func(n int, line string)) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		fn(n, scanner.Text())
+	}
+	// Minified bundles may exceed the line limit; skip rather than fail
+	if err := scanner.Err(); err != nil && err != bufio.ErrTooLong {
+		return err
+	}
+	return nil
+}
+
+var textExtensions = map[string]bool{
+	".html": true, ".htm": true, ".css": true, ".js": true, ".mjs": true, ".json": true,
+	".txt": true, ".xml": true, ".svg": true, ".md": true, ".map": true, ".env": true,
+	".yml": true, ".yaml": true, ".toml": true, ".ini": true, ".conf": true, ".webmanifest": true,
+}
+
+func isTextFile(name string) bool {
+	return textExtensions[strings.ToLower(filepath.Ext(name))]
+}
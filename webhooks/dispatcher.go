@@ -0,0 +1,302 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"static-site-hosting/models"
+
+	"github.com/google/uuid"
+)
+
+// Event names
+const (
+	EventDeploymentCreated  = "deployment.created"
+	EventDeploymentPromoted = "deployment.promoted" // a site's live deployment changed
+	EventDeploymentFailed   = "deployment.failed"
+	EventDeploymentDeleted  = "deployment.deleted"
+	EventDeploymentExpiring = "deployment.expiring"
+	EventIntegrityCorrupted = "integrity.corrupted"
+	EventQuotaWarning       = "quota.warning" // a tenant crossed a quota warning threshold
+)
+
+// Envelope is the JSON body POSTed to webhook endpoints
+type Envelope struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Events lists every event webhooks may subscribe to
+var Events = []string{
+	EventDeploymentCreated, EventDeploymentPromoted, EventDeploymentFailed, EventDeploymentDeleted,
+	EventDeploymentExpiring, EventIntegrityCorrupted, EventQuotaWarning,
+}
+
+// Enqueue queues event for every registered webhook subscribed to it. sites
+// are the sites the event is about, if any: webhooks filtering on sites are
+// only notified of events about one of theirs. Delivery happens in the
+// background Dispatcher, so callers never wait on remote endpoints.
+func Enqueue(db *sql.DB, event string, data interface{}, sites ...string) error {
+	rows, err := db.Query("SELECT id, events, sites FROM webhooks")
+	if err != nil {
+		return err
+	}
+	var webhookIDs []string
+	for rows.Next() {
+		var id, events, filter string
+		if err := rows.Scan(&id, &events, &filter); err != nil {
+			rows.Close()
+			return err
+		}
+		if subscribed(events, filter, event, sites) {
+			webhookIDs = append(webhookIDs, id)
+		}
+	}
+	rows.Close()
+
+	now := time.Now().UTC()
+	for _, webhookID := range webhookIDs {
+		deliveryID := uuid.New().String()
+		payload, err := json.Marshal(Envelope{ID: deliveryID, Event: event, CreatedAt: now, Data: data})
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec(
+			`INSERT INTO webhook_deliveries (id, webhook_id, event, payload, status, attempts, next_attempt_at, created_at)
+			VALUES (?, ?, ?, ?, ?, 0, ?, ?)`,
+			deliveryID, webhookID, event, string(payload), models.DeliveryPending, now, now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscribed reports whether a webhook filtering on events and sites, both
+// stored comma-separated and empty for all, is notified of event about
+// sites
+func subscribed(events, filter, event string, sites []string) bool {
+	if events != "" && !slices.Contains(strings.Split(events, ","), event) {
+		return false
+	}
+	if filter == "" {
+		return true
+	}
+	for _, site := range sites {
+		if site != "" && slices.Contains(strings.Split(filter, ","), site) {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify is Enqueue for callers that shouldn't fail because of webhooks
+func Notify(db *sql.DB, event string, data interface{}, sites ...string) {
+	if err := Enqueue(db, event, data, sites...); err != nil {
+		log.Printf("Warning: Failed to queue %s webhooks: %v", event, err)
+	}
+}
+
+// Redeliver moves deliveries of a webhook back to pending so they are retried
+// immediately. With an empty deliveryID every dead-lettered delivery is
+// requeued. It returns the number of deliveries requeued.
+func Redeliver(db *sql.DB, webhookID, deliveryID string) (int64, error) {
+	now := time.Now().UTC()
+	var result sql.Result
+	var err error
+	if deliveryID != "" {
+		result, err = db.Exec(
+			`UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ?, last_error = ''
+			WHERE id = ? AND webhook_id = ?`,
+			models.DeliveryPending, now, deliveryID, webhookID,
+		)
+	} else {
+		result, err = db.Exec(
+			`UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_at = ?, last_error = ''
+			WHERE webhook_id = ? AND status = ?`,
+			models.DeliveryPending, now, webhookID, models.DeliveryDead,
+		)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Dispatcher delivers queued webhook events with exponential backoff
+type Dispatcher struct {
+	DB          *sql.DB
+	Client      *http.Client
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	Interval    time.Duration // how often to poll for due deliveries
+}
+
+// NewDispatcher returns a dispatcher with sensible defaults
+func NewDispatcher(db *sql.DB, maxAttempts int, baseBackoff time.Duration) *Dispatcher {
+	return &Dispatcher{
+		DB:          db,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: maxAttempts,
+		BaseBackoff: baseBackoff,
+		MaxBackoff:  6 * time.Hour,
+		Interval:    time.Second,
+	}
+}
+
+// Run polls for due deliveries until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.ProcessDue(ctx); err != nil {
+				log.Printf("Warning: Webhook dispatch failed: %v", err)
+			}
+		}
+	}
+}
+
+type dueDelivery struct {
+	models.WebhookDelivery
+	url    string
+	secret string
+}
+
+// ProcessDue attempts every pending delivery whose next attempt time has passed
+func (d *Dispatcher) ProcessDue(ctx context.Context) error {
+	rows, err := d.DB.Query(
+		`SELECT dl.id, dl.webhook_id, dl.event, dl.payload, dl.attempts, wh.url, wh.secret
+		FROM webhook_deliveries dl JOIN webhooks wh ON wh.id = dl.webhook_id
+		WHERE dl.status = ? AND dl.next_attempt_at <= ?
+		ORDER BY dl.next_attempt_at LIMIT 100`,
+		models.DeliveryPending, time.Now().UTC(),
+	)
+	if err != nil {
+		return err
+	}
+
+	var due []dueDelivery
+	for rows.Next() {
+		var dd dueDelivery
+		if err := rows.Scan(&dd.ID, &dd.WebhookID, &dd.Event, &dd.Payload, &dd.Attempts, &dd.url, &dd.secret); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, dd)
+	}
+	rows.Close()
+
+	for _, dd := range due {
+		if ctx.Err() != nil {
+			return nil
+		}
+		d.attempt(ctx, dd)
+	}
+	return nil
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, dd dueDelivery) {
+	attempt := dd.Attempts + 1
+	start := time.Now()
+	statusCode, sendErr := d.send(ctx, dd)
+	elapsed := time.Since(start)
+
+	errText := ""
+	if sendErr != nil {
+		errText = sendErr.Error()
+	}
+	d.DB.Exec(
+		`INSERT INTO webhook_attempts (delivery_id, attempt, status_code, error, duration_ms, attempted_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		dd.ID, attempt, statusCode, errText, elapsed.Milliseconds(), start.UTC(),
+	)
+
+	now := time.Now().UTC()
+	switch {
+	case sendErr == nil:
+		d.DB.Exec(
+			"UPDATE webhook_deliveries SET status = ?, attempts = ?, last_error = '', delivered_at = ? WHERE id = ?",
+			models.DeliveryDelivered, attempt, now, dd.ID,
+		)
+	case attempt >= d.MaxAttempts:
+		log.Printf("Webhook delivery %s moved to dead-letter after %d attempts: %v", dd.ID, attempt, sendErr)
+		d.DB.Exec(
+			"UPDATE webhook_deliveries SET status = ?, attempts = ?, last_error = ? WHERE id = ?",
+			models.DeliveryDead, attempt, errText, dd.ID,
+		)
+	default:
+		d.DB.Exec(
+			"UPDATE webhook_deliveries SET attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?",
+			attempt, errText, now.Add(d.backoff(attempt)), dd.ID,
+		)
+	}
+}
+
+// backoff returns the wait after the given failed attempt: base, 2x, 4x, ...
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	wait := d.BaseBackoff
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if wait >= d.MaxBackoff {
+			return d.MaxBackoff
+		}
+	}
+	return wait
+}
+
+func (d *Dispatcher) send(ctx context.Context, dd dueDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dd.url, bytes.NewReader([]byte(dd.Payload)))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", dd.Event)
+	req.Header.Set("X-Webhook-Delivery", dd.ID)
+	signature, err := signatures(d.DB, dd.WebhookID, dd.secret, []byte(dd.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("webhook secret: %w", err)
+	}
+	if signature != "" {
+		req.Header.Set("X-Webhook-Signature", signature)
+	}
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of body using secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,277 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"static-site-hosting/models"
+	"static-site-hosting/secrets"
+)
+
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	schema := []string{
+		`CREATE TABLE webhooks (
+			id TEXT PRIMARY KEY,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events TEXT NOT NULL DEFAULT '',
+			sites TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			webhook_id TEXT NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at DATETIME NOT NULL,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			delivered_at DATETIME
+		)`,
+		`CREATE TABLE webhook_attempts (
+			delivery_id TEXT NOT NULL,
+			attempt INTEGER NOT NULL,
+			status_code INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			duration_ms INTEGER NOT NULL DEFAULT 0,
+			attempted_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE webhook_secrets (
+			id TEXT PRIMARY KEY,
+			webhook_id TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			retired_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL
+		)`,
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create tables: %v", err)
+		}
+	}
+	return db
+}
+
+func deliveryStatus(t *testing.T, db *sql.DB) (status string, attempts int) {
+	if err := db.QueryRow("SELECT status, attempts FROM webhook_deliveries").Scan(&status, &attempts); err != nil {
+		t.Fatalf("failed to read delivery: %v", err)
+	}
+	return status, attempts
+}
+
+func TestDispatcherDeliversSignedEvent(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Webhook-Signature")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	db.Exec("INSERT INTO webhooks (id, url, secret) VALUES ('wh-1', ?, 'shh')", server.URL)
+	if err := Enqueue(db, EventDeploymentCreated, map[string]string{"id": "dep-1"}); err != nil {
+		t.Fatalf("enqueue failed: %v", err)
+	}
+
+	d := NewDispatcher(db, 3, time.Minute)
+	if err := d.ProcessDue(context.Background()); err != nil {
+		t.Fatalf("ProcessDue failed: %v", err)
+	}
+
+	status, attempts := deliveryStatus(t, db)
+	if status != models.DeliveryDelivered || attempts != 1 {
+		t.Errorf("expected delivered after 1 attempt, got %s after %d", status, attempts)
+	}
+
+	var payload string
+	db.QueryRow("SELECT payload FROM webhook_deliveries").Scan(&payload)
+	if signature != "sha256="+Sign("shh", []byte(payload)) {
+		t.Errorf("unexpected signature %q", signature)
+	}
+}
+
+func TestDispatcherSignsWithDecryptedSecret(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Webhook-Signature")
+	}))
+	defer server.Close()
+
+	keyring, _ := secrets.NewKeyring(bytes.Repeat([]byte{7}, 32))
+	secrets.Default = keyring
+	defer func() { secrets.Default = nil }()
+	sealed, _ := keyring.Seal("shh")
+	db.Exec("INSERT INTO webhooks (id, url, secret) VALUES ('wh-1', ?, ?)", server.URL, sealed)
+	Enqueue(db, EventDeploymentCreated, map[string]string{"id": "dep-1"})
+
+	d := NewDispatcher(db, 3, time.Minute)
+	d.ProcessDue(context.Background())
+
+	var payload string
+	db.QueryRow("SELECT payload FROM webhook_deliveries").Scan(&payload)
+	if signature != "sha256="+Sign("shh", []byte(payload)) {
+		t.Errorf("expected a signature with the decrypted secret, got %q", signature)
+	}
+
+	// A secret sealed with a key that is no longer configured fails the
+	// attempt rather than sending an unsigned event
+	secrets.Default = nil
+	signature = ""
+	db.Exec("UPDATE webhook_deliveries SET status = ?, next_attempt_at = ?", models.DeliveryPending, time.Now().UTC().Add(-time.Second))
+	d.ProcessDue(context.Background())
+	var lastError string
+	db.QueryRow("SELECT last_error FROM webhook_deliveries").Scan(&lastError)
+	if signature != "" || !strings.Contains(lastError, "not configured") {
+		t.Errorf("expected the attempt to fail without the key, got error %q", lastError)
+	}
+}
+
+func TestDispatcherRetriesThenDeadLetters(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	db.Exec("INSERT INTO webhooks (id, url, secret) VALUES ('wh-1', ?, '')", server.URL)
+	Enqueue(db, EventDeploymentCreated, nil)
+
+	// Zero backoff so every pass retries immediately
+	d := NewDispatcher(db, 2, 0)
+	d.ProcessDue(context.Background())
+
+	status, attempts := deliveryStatus(t, db)
+	if status != models.DeliveryPending || attempts != 1 {
+		t.Errorf("expected pending after first failure, got %s after %d", status, attempts)
+	}
+
+	d.ProcessDue(context.Background())
+	status, attempts = deliveryStatus(t, db)
+	if status != models.DeliveryDead || attempts != 2 {
+		t.Errorf("expected dead after max attempts, got %s after %d", status, attempts)
+	}
+
+	var logged int
+	db.QueryRow("SELECT COUNT(*) FROM webhook_attempts").Scan(&logged)
+	if logged != 2 {
+		t.Errorf("expected 2 logged attempts, got %d", logged)
+	}
+
+	// Redelivery puts dead-lettered deliveries back in the queue
+	count, err := Redeliver(db, "wh-1", "")
+	if err != nil || count != 1 {
+		t.Fatalf("expected 1 requeued delivery, got %d (%v)", count, err)
+	}
+	status, attempts = deliveryStatus(t, db)
+	if status != models.DeliveryPending || attempts != 0 {
+		t.Errorf("expected pending with reset attempts, got %s after %d", status, attempts)
+	}
+}
+
+func TestDispatcherBackoff(t *testing.T) {
+	d := &Dispatcher{BaseBackoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, want := range expected {
+		if got := d.backoff(i + 1); got != want {
+			t.Errorf("backoff(%d) = %v, expected %v", i+1, got, want)
+		}
+	}
+}
+
+func TestDispatcherSignsWithRetiredSecrets(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Webhook-Signature")
+	}))
+	defer server.Close()
+
+	db.Exec("INSERT INTO webhooks (id, url, secret) VALUES ('wh-1', ?, 'first')", server.URL)
+	if _, err := RotateSecret(db, "wh-1", "second", time.Hour); err != nil {
+		t.Fatalf("RotateSecret: %v", err)
+	}
+	if retired, err := RotateSecret(db, "wh-1", "third", 0); err != nil || retired != nil {
+		t.Fatalf("expected a rotation without overlap to retire nothing, got %+v, %v", retired, err)
+	}
+	Enqueue(db, EventDeploymentCreated, map[string]string{"id": "dep-1"})
+
+	d := NewDispatcher(db, 3, time.Minute)
+	d.ProcessDue(context.Background())
+
+	var payload string
+	db.QueryRow("SELECT payload FROM webhook_deliveries").Scan(&payload)
+	want := "sha256=" + Sign("third", []byte(payload)) + ", sha256=" + Sign("first", []byte(payload))
+	if signature != want {
+		t.Errorf("expected signatures with the current and overlapping secrets, got %q", signature)
+	}
+
+	// Once the overlap is over only the current secret signs
+	db.Exec("UPDATE webhook_secrets SET expires_at = ?", time.Now().UTC().Add(-time.Second))
+	db.Exec("UPDATE webhook_deliveries SET status = ?, next_attempt_at = ?", models.DeliveryPending, time.Now().UTC().Add(-time.Second))
+	d.ProcessDue(context.Background())
+	if signature != "sha256="+Sign("third", []byte(payload)) {
+		t.Errorf("expected the expired secret to stop signing, got %q", signature)
+	}
+}
+
+func TestEnqueueFiltersByEventAndSite(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	db.Exec("INSERT INTO webhooks (id, url, secret) VALUES ('all', 'http://example.com', '')")
+	db.Exec("INSERT INTO webhooks (id, url, secret, events) VALUES ('promotions', 'http://example.com', '', 'deployment.promoted')")
+	db.Exec("INSERT INTO webhooks (id, url, secret, sites) VALUES ('docs', 'http://example.com', '', 'docs,blog')")
+
+	Enqueue(db, EventDeploymentCreated, nil, "docs")
+	Enqueue(db, EventDeploymentPromoted, nil, "shop")
+	Enqueue(db, EventQuotaWarning, nil)
+	Enqueue(db, EventDeploymentExpiring, nil, "shop", "blog")
+
+	queued := map[string][]string{}
+	rows, _ := db.Query("SELECT webhook_id, event FROM webhook_deliveries ORDER BY event")
+	for rows.Next() {
+		var id, event string
+		rows.Scan(&id, &event)
+		queued[id] = append(queued[id], event)
+	}
+	rows.Close()
+
+	if len(queued["all"]) != 4 {
+		t.Errorf("expected the unfiltered webhook to get every event, got %v", queued["all"])
+	}
+	if got := queued["promotions"]; len(got) != 1 || got[0] != EventDeploymentPromoted {
+		t.Errorf("expected only promotions, got %v", got)
+	}
+	if got := strings.Join(queued["docs"], " "); got != "deployment.created deployment.expiring" {
+		t.Errorf("expected only events about docs or blog, got %v", queued["docs"])
+	}
+}
@@ -0,0 +1,110 @@
+package webhooks
+
+import (
+	"database/sql"
+	"time"
+
+	"static-site-hosting/models"
+	"static-site-hosting/secrets"
+
+	"github.com/google/uuid"
+)
+
+// RotateSecret makes secret the webhook's signing secret. The secret it
+// replaces keeps signing deliveries for overlap, alongside the new one, and
+// is dropped at once if overlap is zero. It returns the retired secret, or
+// nil when it was dropped.
+func RotateSecret(db *sql.DB, webhookID, secret string, overlap time.Duration) (*models.WebhookSecret, error) {
+	sealed, err := secrets.Seal(secret)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	if err := tx.QueryRow("SELECT secret FROM webhooks WHERE id = ?", webhookID).Scan(&previous); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE webhooks SET secret = ? WHERE id = ?", sealed, webhookID); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if _, err := tx.Exec("DELETE FROM webhook_secrets WHERE webhook_id = ? AND expires_at <= ?", webhookID, now); err != nil {
+		return nil, err
+	}
+
+	var retired *models.WebhookSecret
+	if overlap > 0 && previous != "" {
+		retired = &models.WebhookSecret{
+			ID:        uuid.New().String(),
+			WebhookID: webhookID,
+			RetiredAt: now,
+			ExpiresAt: now.Add(overlap),
+		}
+		_, err := tx.Exec(
+			"INSERT INTO webhook_secrets (id, webhook_id, secret, retired_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+			retired.ID, webhookID, previous, retired.RetiredAt, retired.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return retired, tx.Commit()
+}
+
+// RetiredSecrets lists the webhook's retired secrets that still sign
+// deliveries, most recently retired first. Secret is left sealed.
+func RetiredSecrets(db *sql.DB, webhookID string) ([]models.WebhookSecret, error) {
+	rows, err := db.Query(
+		`SELECT id, webhook_id, secret, retired_at, expires_at FROM webhook_secrets
+		WHERE webhook_id = ? AND expires_at > ? ORDER BY retired_at DESC`,
+		webhookID, time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	retired := []models.WebhookSecret{}
+	for rows.Next() {
+		var s models.WebhookSecret
+		if err := rows.Scan(&s.ID, &s.WebhookID, &s.Secret, &s.RetiredAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		retired = append(retired, s)
+	}
+	return retired, rows.Err()
+}
+
+// signatures signs body with the current secret and every retired one still
+// in its overlap, in that order, as the X-Webhook-Signature header value
+func signatures(db *sql.DB, webhookID, current string, body []byte) (string, error) {
+	sealed := []string{current}
+	retired, err := RetiredSecrets(db, webhookID)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range retired {
+		sealed = append(sealed, s.Secret)
+	}
+
+	header := ""
+	for _, stored := range sealed {
+		secret, err := secrets.Open(stored)
+		if err != nil {
+			return "", err
+		}
+		if secret == "" {
+			continue
+		}
+		if header != "" {
+			header += ", "
+		}
+		header += "sha256=" + Sign(secret, body)
+	}
+	return header, nil
+}